@@ -24,6 +24,7 @@ const EnvVaultClientKey = "VAULT_CLIENT_KEY"
 const EnvVaultInsecure = "VAULT_SKIP_VERIFY"
 const EnvVaultTLSServerName = "VAULT_TLS_SERVER_NAME"
 const EnvVaultWrapTTL = "VAULT_WRAP_TTL"
+const EnvVaultNamespace = "VAULT_NAMESPACE"
 
 var (
 	errRedirect = errors.New("redirect")
@@ -166,6 +167,7 @@ type Client struct {
 	addr               *url.URL
 	config             *Config
 	token              string
+	namespace          string
 	wrappingLookupFunc WrappingLookupFunc
 }
 
@@ -173,7 +175,8 @@ type Client struct {
 //
 // If the environment variable `VAULT_TOKEN` is present, the token will be
 // automatically added to the client. Otherwise, you must manually call
-// `SetToken()`.
+// `SetToken()`. Likewise, the namespace of the requests is set from the
+// environment variable `VAULT_NAMESPACE` if it is present.
 func NewClient(c *Config) (*Client, error) {
 	u, err := url.Parse(c.Address)
 	if err != nil {
@@ -205,6 +208,10 @@ func NewClient(c *Config) (*Client, error) {
 		client.SetToken(token)
 	}
 
+	if namespace := os.Getenv(EnvVaultNamespace); namespace != "" {
+		client.SetNamespace(namespace)
+	}
+
 	return client, nil
 }
 
@@ -231,6 +238,23 @@ func (c *Client) ClearToken() {
 	c.token = ""
 }
 
+// Namespace returns the path of the namespace the requests of this client
+// are made in. It will return the empty string for the root namespace.
+func (c *Client) Namespace() string {
+	return c.namespace
+}
+
+// SetNamespace sets the path of the namespace the requests of this client
+// are made in. The paths of the requests are relative to it.
+func (c *Client) SetNamespace(v string) {
+	c.namespace = v
+}
+
+// ClearNamespace makes the requests of this client in the root namespace.
+func (c *Client) ClearNamespace() {
+	c.namespace = ""
+}
+
 // NewRequest creates a new raw request object to query the Vault server
 // configured for this client. This is an advanced method and generally
 // doesn't need to be called externally.
@@ -243,6 +267,7 @@ func (c *Client) NewRequest(method, path string) *Request {
 			Path:   path,
 		},
 		ClientToken: c.token,
+		Namespace:   c.namespace,
 		Params:      make(map[string][]string),
 	}
 
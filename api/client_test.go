@@ -62,6 +62,42 @@ func TestClientToken(t *testing.T) {
 	}
 }
 
+func TestClientNamespace(t *testing.T) {
+	var namespace string
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		namespace = req.Header.Get("X-Vault-Namespace")
+	}
+
+	config, ln := testHTTPServer(t, http.HandlerFunc(handler))
+	defer ln.Close()
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	client.SetNamespace("team/")
+	if v := client.Namespace(); v != "team/" {
+		t.Fatalf("bad: %s", v)
+	}
+
+	// Verify the namespace is sent with the requests
+	resp, err := client.RawRequest(client.NewRequest("GET", "/v1/secret/foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	resp.Body.Close()
+	if namespace != "team/" {
+		t.Fatalf("bad: %s", namespace)
+	}
+
+	client.ClearNamespace()
+
+	if v := client.Namespace(); v != "" {
+		t.Fatalf("bad: %s", v)
+	}
+}
+
 func TestClientRedirect(t *testing.T) {
 	primary := func(w http.ResponseWriter, req *http.Request) {
 		w.Write([]byte("test"))
@@ -15,10 +15,16 @@ type Request struct {
 	URL         *url.URL
 	Params      url.Values
 	ClientToken string
+	Namespace   string
 	WrapTTL     string
 	Obj         interface{}
 	Body        io.Reader
 	BodySize    int64
+	ContentType string
+
+	// rawBody holds a body set with SetRawBody, so that it can be sent
+	// again on a redirect
+	rawBody []byte
 }
 
 // SetJSONBody is used to set a request body that is a JSON-encoded value.
@@ -35,11 +41,24 @@ func (r *Request) SetJSONBody(val interface{}) error {
 	return nil
 }
 
+// SetRawBody is used to set a request body that is sent as is, using the
+// given content type.
+func (r *Request) SetRawBody(contentType string, body []byte) {
+	r.ContentType = contentType
+	r.rawBody = body
+	r.Body = bytes.NewReader(body)
+	r.BodySize = int64(len(body))
+}
+
 // ResetJSONBody is used to reset the body for a redirect
 func (r *Request) ResetJSONBody() error {
 	if r.Body == nil {
 		return nil
 	}
+	if r.rawBody != nil {
+		r.SetRawBody(r.ContentType, r.rawBody)
+		return nil
+	}
 	return r.SetJSONBody(r.Obj)
 }
 
@@ -63,9 +82,17 @@ func (r *Request) ToHTTP() (*http.Request, error) {
 		req.Header.Set("X-Vault-Token", r.ClientToken)
 	}
 
+	if len(r.Namespace) != 0 {
+		req.Header.Set("X-Vault-Namespace", r.Namespace)
+	}
+
 	if len(r.WrapTTL) != 0 {
 		req.Header.Set("X-Vault-Wrap-TTL", r.WrapTTL)
 	}
 
+	if len(r.ContentType) != 0 {
+		req.Header.Set("Content-Type", r.ContentType)
+	}
+
 	return req, nil
 }
@@ -39,6 +39,7 @@ type SecretWrapInfo struct {
 	TTL             int       `json:"ttl"`
 	CreationTime    time.Time `json:"creation_time"`
 	WrappedAccessor string    `json:"wrapped_accessor"`
+	CreationPath    string    `json:"creation_path"`
 }
 
 // SecretAuth is the structure containing auth information if we have it.
@@ -50,6 +51,8 @@ type SecretAuth struct {
 
 	LeaseDuration int  `json:"lease_duration"`
 	Renewable     bool `json:"renewable"`
+
+	EntityID string `json:"entity_id"`
 }
 
 // ParseSecret is used to parse a secret value from JSON from an io.Reader.
@@ -43,3 +43,41 @@ func (c *Sys) RevokeForce(id string) error {
 	}
 	return err
 }
+
+// LeaseLookup returns the issue, expiration and last renewal times of the
+// lease with the given ID
+func (c *Sys) LeaseLookup(id string) (*Secret, error) {
+	r := c.c.NewRequest("PUT", "/v1/sys/leases/lookup")
+
+	body := map[string]interface{}{"lease_id": id}
+	if err := r.SetJSONBody(body); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.c.RawRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ParseSecret(resp.Body)
+}
+
+// LeaseList lists the lease IDs and the nested prefixes under the given
+// prefix. It returns nil if there are none.
+func (c *Sys) LeaseList(prefix string) (*Secret, error) {
+	r := c.c.NewRequest("GET", "/v1/sys/leases/lookup/"+prefix)
+	r.Params.Set("list", "true")
+	resp, err := c.c.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == 404 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSecret(resp.Body)
+}
@@ -0,0 +1,72 @@
+package api
+
+import "fmt"
+
+// ListNamespaces returns the names of the child namespaces of the namespace
+// of the client
+func (c *Sys) ListNamespaces() ([]string, error) {
+	r := c.c.NewRequest("LIST", "/v1/sys/namespaces")
+	resp, err := c.c.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+		if resp.StatusCode == 404 {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result listNamespacesResp
+	err = resp.DecodeJSON(&result)
+	return result.Keys, err
+}
+
+// GetNamespace returns a child namespace of the namespace of the client, or
+// nil if there is none
+func (c *Sys) GetNamespace(name string) (*NamespaceOutput, error) {
+	r := c.c.NewRequest("GET", fmt.Sprintf("/v1/sys/namespaces/%s", name))
+	resp, err := c.c.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+		if resp.StatusCode == 404 {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(NamespaceOutput)
+	err = resp.DecodeJSON(result)
+	return result, err
+}
+
+// CreateNamespace creates a child namespace of the namespace of the client
+func (c *Sys) CreateNamespace(name string) error {
+	r := c.c.NewRequest("POST", fmt.Sprintf("/v1/sys/namespaces/%s", name))
+	resp, err := c.c.RawRequest(r)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+	return err
+}
+
+// DeleteNamespace deletes a child namespace of the namespace of the client
+func (c *Sys) DeleteNamespace(name string) error {
+	r := c.c.NewRequest("DELETE", fmt.Sprintf("/v1/sys/namespaces/%s", name))
+	resp, err := c.c.RawRequest(r)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+	return err
+}
+
+type NamespaceOutput struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+}
+
+type listNamespacesResp struct {
+	Keys []string `json:"keys"`
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// RaftJoin adds a node to the cluster of the raft storage backend
+func (c *Sys) RaftJoin(nodeID, address string) error {
+	body := map[string]interface{}{
+		"node_id": nodeID,
+		"address": address,
+	}
+
+	r := c.c.NewRequest("PUT", "/v1/sys/storage/raft/join")
+	if err := r.SetJSONBody(body); err != nil {
+		return err
+	}
+
+	resp, err := c.c.RawRequest(r)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+	return err
+}
+
+// RaftRemovePeer removes a node from the cluster of the raft storage backend
+func (c *Sys) RaftRemovePeer(nodeID string) error {
+	body := map[string]interface{}{
+		"node_id": nodeID,
+	}
+
+	r := c.c.NewRequest("PUT", "/v1/sys/storage/raft/remove-peer")
+	if err := r.SetJSONBody(body); err != nil {
+		return err
+	}
+
+	resp, err := c.c.RawRequest(r)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+	return err
+}
+
+// RaftListPeers returns the nodes of the cluster of the raft storage backend
+func (c *Sys) RaftListPeers() ([]*RaftPeer, error) {
+	r := c.c.NewRequest("GET", "/v1/sys/storage/raft/list-peers")
+	resp, err := c.c.RawRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Peers []*RaftPeer `json:"peers"`
+	}
+	err = resp.DecodeJSON(&result)
+	return result.Peers, err
+}
+
+// RaftSnapshot writes a snapshot of the raft storage backend to the writer
+func (c *Sys) RaftSnapshot(w io.Writer) error {
+	r := c.c.NewRequest("GET", "/v1/sys/storage/raft/snapshot")
+	resp, err := c.c.RawRequest(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// RaftSnapshotRestore restores the raft storage backend of the whole
+// cluster from the snapshot read from the reader
+func (c *Sys) RaftSnapshotRestore(snapshot io.Reader) error {
+	body, err := ioutil.ReadAll(snapshot)
+	if err != nil {
+		return err
+	}
+
+	r := c.c.NewRequest("POST", "/v1/sys/storage/raft/snapshot")
+	r.SetRawBody("application/octet-stream", body)
+
+	resp, err := c.c.RawRequest(r)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+	return err
+}
+
+type RaftPeer struct {
+	NodeID  string `json:"node_id"`
+	Address string `json:"address"`
+	Leader  bool   `json:"leader"`
+	Voter   bool   `json:"voter"`
+}
@@ -36,6 +36,18 @@ func (c *Sys) Unseal(shard string) (*SealStatusResponse, error) {
 	return sealStatusRequest(c, r)
 }
 
+// UnsealMigrate provides a key part to complete a pending seal migration
+func (c *Sys) UnsealMigrate(shard string) (*SealStatusResponse, error) {
+	body := map[string]interface{}{"key": shard, "migrate": true}
+
+	r := c.c.NewRequest("PUT", "/v1/sys/unseal")
+	if err := r.SetJSONBody(body); err != nil {
+		return nil, err
+	}
+
+	return sealStatusRequest(c, r)
+}
+
 func sealStatusRequest(c *Sys, r *Request) (*SealStatusResponse, error) {
 	resp, err := c.c.RawRequest(r)
 	if err != nil {
@@ -49,8 +61,9 @@ func sealStatusRequest(c *Sys, r *Request) (*SealStatusResponse, error) {
 }
 
 type SealStatusResponse struct {
-	Sealed   bool
-	T        int
-	N        int
-	Progress int
+	Sealed    bool
+	T         int
+	N         int
+	Progress  int
+	Migration bool
 }
@@ -0,0 +1,60 @@
+package api
+
+// WrappingLookup returns the creation TTL, creation time and creation path of
+// a response-wrapping token, without unwrapping it
+func (c *Sys) WrappingLookup(token string) (*Secret, error) {
+	return c.wrappingRequest("/v1/sys/wrapping/lookup", token)
+}
+
+// Rewrap moves the response of a response-wrapping token to a new token,
+// and revokes the given one. The new token is in the WrapInfo of the result.
+func (c *Sys) Rewrap(token string) (*Secret, error) {
+	return c.wrappingRequest("/v1/sys/wrapping/rewrap", token)
+}
+
+// Unwrap returns the response wrapped by a response-wrapping token, and
+// revokes the token. If the token is empty, the client token is unwrapped.
+func (c *Sys) Unwrap(token string) (*Secret, error) {
+	return c.wrappingRequest("/v1/sys/wrapping/unwrap", token)
+}
+
+// Wrap wraps the given data in a response-wrapping token with the given TTL,
+// such as "5m". If the TTL is empty, the wrapping lookup function of the
+// client is used. The token is in the WrapInfo of the result.
+func (c *Sys) Wrap(data map[string]interface{}, ttl string) (*Secret, error) {
+	r := c.c.NewRequest("PUT", "/v1/sys/wrapping/wrap")
+	if ttl != "" {
+		r.WrapTTL = ttl
+	}
+	if err := r.SetJSONBody(data); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.c.RawRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ParseSecret(resp.Body)
+}
+
+func (c *Sys) wrappingRequest(path, token string) (*Secret, error) {
+	body := map[string]interface{}{}
+	if token != "" {
+		body["token"] = token
+	}
+
+	r := c.c.NewRequest("PUT", path)
+	if err := r.SetJSONBody(body); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.c.RawRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ParseSecret(resp.Body)
+}
@@ -38,6 +38,7 @@ func (f *FormatJSON) FormatRequest(
 			DisplayName: auth.DisplayName,
 			Policies:    auth.Policies,
 			Metadata:    auth.Metadata,
+			EntityID:    auth.EntityID,
 		},
 
 		Request: JSONRequest{
@@ -77,6 +78,7 @@ func (f *FormatJSON) FormatResponse(
 			DisplayName: resp.Auth.DisplayName,
 			Policies:    resp.Auth.Policies,
 			Metadata:    resp.Auth.Metadata,
+			EntityID:    resp.Auth.EntityID,
 		}
 	}
 
@@ -94,6 +96,7 @@ func (f *FormatJSON) FormatResponse(
 			Token:           resp.WrapInfo.Token,
 			CreationTime:    resp.WrapInfo.CreationTime,
 			WrappedAccessor: resp.WrapInfo.WrappedAccessor,
+			CreationPath:    resp.WrapInfo.CreationPath,
 		}
 	}
 
@@ -108,6 +111,7 @@ func (f *FormatJSON) FormatResponse(
 			DisplayName: auth.DisplayName,
 			Policies:    auth.Policies,
 			Metadata:    auth.Metadata,
+			EntityID:    auth.EntityID,
 		},
 
 		Request: JSONRequest{
@@ -171,6 +175,7 @@ type JSONAuth struct {
 	DisplayName string            `json:"display_name"`
 	Policies    []string          `json:"policies"`
 	Metadata    map[string]string `json:"metadata"`
+	EntityID    string            `json:"entity_id,omitempty"`
 }
 
 type JSONSecret struct {
@@ -182,6 +187,7 @@ type JSONWrapInfo struct {
 	Token           string    `json:"token"`
 	CreationTime    time.Time `json:"creation_time"`
 	WrappedAccessor string    `json:"wrapped_accessor,omitempty"`
+	CreationPath    string    `json:"creation_path,omitempty"`
 }
 
 // getRemoteAddr safely gets the remote address avoiding a nil pointer
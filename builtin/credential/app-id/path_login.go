@@ -101,6 +101,9 @@ func (b *backend) pathLogin(
 			LeaseOptions: logical.LeaseOptions{
 				Renewable: true,
 			},
+			Alias: &logical.Alias{
+				Name: metadata["user-id"],
+			},
 		},
 	}, nil
 }
@@ -0,0 +1,107 @@
+package approle
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/helper/salt"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+type backend struct {
+	*framework.Backend
+
+	// The salt is used to obfuscate the role IDs, secret IDs and accessors
+	// before using them as storage keys.
+	salt *salt.Salt
+
+	// Lock to make changes to role entries and the role ID index
+	roleMutex sync.RWMutex
+
+	// Lock to make changes to secret ID entries and their accessors. Login
+	// takes the write lock as it may decrement the number of uses.
+	secretIDMutex sync.RWMutex
+
+	// Guards the secret ID tidy function
+	tidySecretIDCASGuard uint32
+
+	// Duration after which the periodic function of the backend tidies the
+	// expired secret IDs.
+	tidyCooldownPeriod time.Duration
+
+	// nextTidyTime holds the time at which the periodic func should initiate
+	// the tidy operation.
+	nextTidyTime time.Time
+}
+
+func Factory(conf *logical.BackendConfig) (logical.Backend, error) {
+	b, err := Backend(conf)
+	if err != nil {
+		return nil, err
+	}
+	return b.Setup(conf)
+}
+
+func Backend(conf *logical.BackendConfig) (*backend, error) {
+	salt, err := salt.NewSalt(conf.StorageView, &salt.Config{
+		HashFunc: salt.SHA256Hash,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b := &backend{
+		salt: salt,
+
+		// Expired secret IDs are rejected at login regardless, so tidying
+		// them once an hour is enough to keep the storage clean.
+		tidyCooldownPeriod: time.Hour,
+	}
+
+	b.Backend = &framework.Backend{
+		PeriodicFunc: b.periodicFunc,
+		AuthRenew:    b.pathLoginRenew,
+		Help:         backendHelp,
+		PathsSpecial: &logical.Paths{
+			Unauthenticated: []string{
+				"login",
+			},
+		},
+		Paths: append([]*framework.Path{
+			pathLogin(b),
+			pathListRoles(b),
+			pathTidySecretID(b),
+		}, rolePaths(b)...),
+	}
+
+	return b, nil
+}
+
+// periodicFunc is invoked once in a minute by the RollbackManager. It tidies
+// the expired secret IDs once every 'tidyCooldownPeriod'.
+func (b *backend) periodicFunc(req *logical.Request) error {
+	if b.nextTidyTime.IsZero() || !time.Now().UTC().Before(b.nextTidyTime) {
+		if _, err := b.tidySecretID(req.Storage); err != nil {
+			return err
+		}
+		b.nextTidyTime = time.Now().UTC().Add(b.tidyCooldownPeriod)
+	}
+	return nil
+}
+
+const backendHelp = `
+Any registered role can authenticate itself with Vault. The credentials
+required for login depend on the constraints set on the role.
+
+A role is created using the 'role/<role_name>' endpoint. Each role has a
+'role_id' that identifies it, which can be read through the
+'role/<role_name>/role-id' endpoint. If 'bind_secret_id' is set on the role,
+login also requires a 'secret_id', which is generated through the
+'role/<role_name>/secret-id' endpoint or set through the
+'role/<role_name>/custom-secret-id' endpoint. Secret IDs can be bound to CIDR
+blocks and limited in number of uses and lifetime.
+
+Each secret ID has an accessor, which can be used to look it up and destroy
+it without knowing its value.
+`
@@ -0,0 +1,521 @@
+package approle
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func createBackendWithStorage(t *testing.T) (*backend, logical.Storage) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil {
+		t.Fatalf("failed to create backend")
+	}
+	_, err = b.Setup(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, storage
+}
+
+func handle(t *testing.T, b *backend, req *logical.Request) *logical.Response {
+	resp, err := b.HandleRequest(req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: path: %s err: %v resp: %#v", req.Path, err, resp)
+	}
+	return resp
+}
+
+func createRole(t *testing.T, b *backend, s logical.Storage, roleName string, data map[string]interface{}) string {
+	handle(t, b, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/" + roleName,
+		Storage:   s,
+		Data:      data,
+	})
+
+	resp := handle(t, b, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/" + roleName + "/role-id",
+		Storage:   s,
+	})
+	return resp.Data["role_id"].(string)
+}
+
+func generateSecretID(t *testing.T, b *backend, s logical.Storage, roleName string, data map[string]interface{}) (string, string) {
+	resp := handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/" + roleName + "/secret-id",
+		Storage:   s,
+		Data:      data,
+	})
+	return resp.Data["secret_id"].(string), resp.Data["secret_id_accessor"].(string)
+}
+
+func loginRequest(s logical.Storage, roleID, secretID, remoteAddr string) *logical.Request {
+	return &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"role_id":   roleID,
+			"secret_id": secretID,
+		},
+		Connection: &logical.Connection{
+			RemoteAddr: remoteAddr,
+		},
+	}
+}
+
+func TestBackend_RoleCRUD(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	createRole(t, b, storage, "role1", map[string]interface{}{
+		"policies":           "p,q,r,s",
+		"secret_id_num_uses": 10,
+		"secret_id_ttl":      300,
+		"token_ttl":          400,
+		"token_max_ttl":      500,
+		"bound_cidr_list":    "127.0.0.1/16,127.0.0.1/32",
+	})
+
+	resp := handle(t, b, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/role1",
+		Storage:   storage,
+	})
+	expected := map[string]interface{}{
+		"bind_secret_id":     true,
+		"policies":           []string{"default", "p", "q", "r", "s"},
+		"secret_id_num_uses": 10,
+		"secret_id_ttl":      time.Duration(300),
+		"token_ttl":          time.Duration(400),
+		"token_max_ttl":      time.Duration(500),
+		"bound_cidr_list":    "127.0.0.1/16,127.0.0.1/32",
+	}
+	if !reflect.DeepEqual(resp.Data, expected) {
+		t.Fatalf("bad: role data: expected: %#v\n actual: %#v", expected, resp.Data)
+	}
+
+	// Update a single field and check that the rest is retained
+	handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id_num_uses": 20,
+		},
+	})
+	resp = handle(t, b, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/role1",
+		Storage:   storage,
+	})
+	expected["secret_id_num_uses"] = 20
+	if !reflect.DeepEqual(resp.Data, expected) {
+		t.Fatalf("bad: role data: expected: %#v\n actual: %#v", expected, resp.Data)
+	}
+
+	// A role needs at least one constraint
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/role2",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"bind_secret_id": false,
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a role without constraints: resp: %#v", resp)
+	}
+
+	resp = handle(t, b, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "role/",
+		Storage:   storage,
+	})
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"role1"}) {
+		t.Fatalf("bad: role list: %#v", resp.Data["keys"])
+	}
+
+	handle(t, b, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "role/role1",
+		Storage:   storage,
+	})
+	resp = handle(t, b, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/role1",
+		Storage:   storage,
+	})
+	if resp != nil {
+		t.Fatalf("expected a nil response for a deleted role: %#v", resp)
+	}
+}
+
+func TestBackend_RoleID(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	roleID := createRole(t, b, storage, "role1", nil)
+	if roleID == "" {
+		t.Fatalf("expected a generated role_id")
+	}
+	secretID, _ := generateSecretID(t, b, storage, "role1", nil)
+
+	handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1/role-id",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_id": "custom-role-id",
+		},
+	})
+
+	// The previous role ID should not be usable anymore
+	resp, err := b.HandleRequest(loginRequest(storage, roleID, secretID, "127.0.0.1"))
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected login with the old role_id to fail: resp: %#v", resp)
+	}
+
+	resp = handle(t, b, loginRequest(storage, "custom-role-id", secretID, "127.0.0.1"))
+	if resp.Auth == nil {
+		t.Fatalf("expected login with the custom role_id to succeed")
+	}
+
+	// Role IDs are unique across roles
+	createRole(t, b, storage, "role2", nil)
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role2/role-id",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_id": "custom-role-id",
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a duplicate role_id: resp: %#v", resp)
+	}
+}
+
+func TestBackend_SecretID(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	createRole(t, b, storage, "role1", map[string]interface{}{
+		"secret_id_num_uses": 5,
+		"secret_id_ttl":      300,
+	})
+	secretID, accessor := generateSecretID(t, b, storage, "role1", map[string]interface{}{
+		"metadata": `{"foo": "bar"}`,
+	})
+
+	resp := handle(t, b, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "role/role1/secret-id/",
+		Storage:   storage,
+	})
+	if !reflect.DeepEqual(resp.Data["keys"], []string{accessor}) {
+		t.Fatalf("bad: secret ID accessors: %#v", resp.Data["keys"])
+	}
+
+	resp = handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1/secret-id/lookup",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id": secretID,
+		},
+	})
+	if resp.Data["secret_id_accessor"] != accessor ||
+		resp.Data["secret_id_num_uses"] != 5 ||
+		resp.Data["secret_id_ttl"] != time.Duration(300) ||
+		!reflect.DeepEqual(resp.Data["metadata"], map[string]string{"foo": "bar"}) {
+		t.Fatalf("bad: secret ID lookup: %#v", resp.Data)
+	}
+
+	resp = handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1/secret-id-accessor/lookup",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id_accessor": accessor,
+		},
+	})
+	if resp.Data["secret_id_accessor"] != accessor {
+		t.Fatalf("bad: secret ID accessor lookup: %#v", resp.Data)
+	}
+
+	handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1/secret-id-accessor/destroy",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id_accessor": accessor,
+		},
+	})
+	resp = handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1/secret-id/lookup",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id": secretID,
+		},
+	})
+	if resp != nil {
+		t.Fatalf("expected the secret ID to be destroyed: %#v", resp)
+	}
+
+	// Custom secret IDs behave like generated ones
+	handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1/custom-secret-id",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id": "custom-secret-id",
+		},
+	})
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1/custom-secret-id",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id": "custom-secret-id",
+		},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatalf("expected an error for a duplicate secret_id")
+	}
+	handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1/secret-id/destroy",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id": "custom-secret-id",
+		},
+	})
+	resp = handle(t, b, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "role/role1/secret-id/",
+		Storage:   storage,
+	})
+	if keys, ok := resp.Data["keys"].([]string); ok && len(keys) != 0 {
+		t.Fatalf("expected no secret IDs: %#v", resp.Data["keys"])
+	}
+}
+
+func TestBackend_LoginAndRenew(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	roleID := createRole(t, b, storage, "role1", map[string]interface{}{
+		"policies":      "a,b",
+		"token_ttl":     400,
+		"token_max_ttl": 500,
+	})
+	secretID, _ := generateSecretID(t, b, storage, "role1", map[string]interface{}{
+		"metadata": `{"foo": "bar"}`,
+	})
+
+	resp, err := b.HandleRequest(loginRequest(storage, roleID, "invalid", "127.0.0.1"))
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected login with an invalid secret_id to fail: resp: %#v", resp)
+	}
+
+	resp = handle(t, b, loginRequest(storage, roleID, secretID, "127.0.0.1"))
+	auth := resp.Auth
+	if auth == nil {
+		t.Fatalf("expected auth in the login response")
+	}
+	if !reflect.DeepEqual(auth.Policies, []string{"a", "b", "default"}) {
+		t.Fatalf("bad: policies: %#v", auth.Policies)
+	}
+	if auth.Metadata["role_name"] != "role1" || auth.Metadata["foo"] != "bar" {
+		t.Fatalf("bad: metadata: %#v", auth.Metadata)
+	}
+	if auth.TTL != 400*time.Second {
+		t.Fatalf("bad: ttl: %s", auth.TTL)
+	}
+
+	auth.IssueTime = time.Now()
+	resp = handle(t, b, &logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      "login",
+		Storage:   storage,
+		Auth:      auth,
+	})
+	if resp.Auth.TTL != 400*time.Second {
+		t.Fatalf("bad: renewed ttl: %s", resp.Auth.TTL)
+	}
+
+	// Renewal fails once the policies of the role change
+	handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"policies": "c",
+		},
+	})
+	_, err = b.HandleRequest(&logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      "login",
+		Storage:   storage,
+		Auth:      auth,
+	})
+	if err == nil {
+		t.Fatalf("expected renewal to fail after the policies changed")
+	}
+}
+
+func TestBackend_LoginNumUses(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	roleID := createRole(t, b, storage, "role1", map[string]interface{}{
+		"secret_id_num_uses": 2,
+	})
+	secretID, accessor := generateSecretID(t, b, storage, "role1", nil)
+
+	handle(t, b, loginRequest(storage, roleID, secretID, "127.0.0.1"))
+
+	resp := handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1/secret-id-accessor/lookup",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id_accessor": accessor,
+		},
+	})
+	if resp.Data["secret_id_num_uses"] != 1 {
+		t.Fatalf("bad: secret_id_num_uses: %#v", resp.Data["secret_id_num_uses"])
+	}
+
+	handle(t, b, loginRequest(storage, roleID, secretID, "127.0.0.1"))
+
+	resp, err := b.HandleRequest(loginRequest(storage, roleID, secretID, "127.0.0.1"))
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected login to fail after the uses are exhausted: resp: %#v", resp)
+	}
+
+	// The accessor is removed along with the secret ID
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1/secret-id-accessor/lookup",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id_accessor": accessor,
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected the accessor to be removed: resp: %#v", resp)
+	}
+}
+
+func TestBackend_LoginCIDR(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	roleID := createRole(t, b, storage, "role1", map[string]interface{}{
+		"bound_cidr_list": "10.0.0.0/8",
+	})
+
+	// The CIDR blocks of a secret ID must be within those of the role
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1/secret-id",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"cidr_list": "192.168.0.0/16",
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a cidr_list outside the role's blocks: resp: %#v", resp)
+	}
+
+	secretID, _ := generateSecretID(t, b, storage, "role1", map[string]interface{}{
+		"cidr_list": "10.1.0.0/16",
+	})
+
+	resp, err = b.HandleRequest(loginRequest(storage, roleID, secretID, "192.168.1.1"))
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected login from outside the role's blocks to fail: resp: %#v", resp)
+	}
+	resp, err = b.HandleRequest(loginRequest(storage, roleID, secretID, "10.2.0.1:4321"))
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected login from outside the secret ID's blocks to fail: resp: %#v", resp)
+	}
+	handle(t, b, loginRequest(storage, roleID, secretID, "10.1.0.1:4321"))
+
+	// Without a bound secret ID, the role ID and the CIDR blocks suffice
+	roleID = createRole(t, b, storage, "role2", map[string]interface{}{
+		"bind_secret_id":  false,
+		"bound_cidr_list": "10.0.0.0/8",
+	})
+	resp = handle(t, b, loginRequest(storage, roleID, "", "10.1.0.1"))
+	if resp.Auth == nil {
+		t.Fatalf("expected auth in the login response")
+	}
+}
+
+func TestBackend_TidySecretID(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	roleID := createRole(t, b, storage, "role1", map[string]interface{}{
+		"secret_id_ttl": 1,
+	})
+	expiredSecretID, _ := generateSecretID(t, b, storage, "role1", nil)
+
+	handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id_ttl": 0,
+		},
+	})
+	secretID, _ := generateSecretID(t, b, storage, "role1", nil)
+
+	time.Sleep(2 * time.Second)
+
+	resp, err := b.HandleRequest(loginRequest(storage, roleID, expiredSecretID, "127.0.0.1"))
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected login with an expired secret_id to fail: resp: %#v", resp)
+	}
+
+	// The failed login already removed the expired secret ID, so create
+	// another one for tidy to remove
+	handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/role1",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id_ttl": 1,
+		},
+	})
+	generateSecretID(t, b, storage, "role1", nil)
+	time.Sleep(2 * time.Second)
+
+	resp = handle(t, b, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tidy/secret-id",
+		Storage:   storage,
+	})
+	if resp.Data["deleted"] != 1 {
+		t.Fatalf("bad: deleted: %#v", resp.Data["deleted"])
+	}
+
+	accessors, err := storage.List("accessor/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accessors) != 1 {
+		t.Fatalf("expected only the accessor of the unexpired secret ID: %#v", accessors)
+	}
+
+	handle(t, b, loginRequest(storage, roleID, secretID, "127.0.0.1"))
+}
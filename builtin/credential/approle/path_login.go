@@ -0,0 +1,195 @@
+package approle
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/helper/policyutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathLogin(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "login$",
+		Fields: map[string]*framework.FieldSchema{
+			"role_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Unique identifier of the Role. Required to be supplied when the 'bind_secret_id' constraint is set.",
+			},
+			"secret_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "SecretID belonging to the role",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathLoginUpdate,
+		},
+		HelpSynopsis:    pathLoginHelpSys,
+		HelpDescription: pathLoginHelpDesc,
+	}
+}
+
+// Returns the Auth object indicating the authentication and authorization information
+// if the credentials provided are validated by the backend.
+func (b *backend) pathLoginUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleID := data.Get("role_id").(string)
+	if roleID == "" {
+		return logical.ErrorResponse("missing role_id"), nil
+	}
+
+	b.roleMutex.RLock()
+	roleIDIndex, err := b.nonLockedRoleIDEntry(req.Storage, roleID)
+	if err != nil {
+		b.roleMutex.RUnlock()
+		return nil, err
+	}
+	if roleIDIndex == nil {
+		b.roleMutex.RUnlock()
+		return logical.ErrorResponse("invalid role_id"), nil
+	}
+	roleName := roleIDIndex.Name
+	role, err := b.nonLockedRoleEntry(req.Storage, roleName)
+	b.roleMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("invalid role_id"), nil
+	}
+
+	belongs, err := remoteAddrInCIDRList(req, role.BoundCIDRList)
+	if err != nil {
+		return nil, err
+	}
+	if !belongs {
+		return logical.ErrorResponse("source address unauthorized through CIDR restrictions on the role"), nil
+	}
+
+	metadata := make(map[string]string)
+	if role.BindSecretID {
+		secretID := data.Get("secret_id").(string)
+		if secretID == "" {
+			return logical.ErrorResponse("missing secret_id"), nil
+		}
+
+		secretEntry, resp, err := b.validateBindSecretID(req, roleName, secretID)
+		if resp != nil || err != nil {
+			return resp, err
+		}
+		for k, v := range secretEntry.Metadata {
+			metadata[k] = v
+		}
+	}
+
+	// Always include the role name, for later filtering
+	metadata["role_name"] = roleName
+
+	return &logical.Response{
+		Auth: &logical.Auth{
+			Policies: role.Policies,
+			Metadata: metadata,
+			InternalData: map[string]interface{}{
+				"role_name": roleName,
+			},
+			DisplayName: roleName,
+			LeaseOptions: logical.LeaseOptions{
+				TTL:       role.TokenTTL,
+				Renewable: true,
+			},
+			Alias: &logical.Alias{
+				Name: role.RoleID,
+			},
+		},
+	}, nil
+}
+
+// validateBindSecretID checks the secret ID presented at login and consumes
+// one of its uses. An error response is returned if the secret ID is invalid.
+func (b *backend) validateBindSecretID(req *logical.Request, roleName, secretID string) (*secretIDStorageEntry, *logical.Response, error) {
+	// Take the write lock as the number of uses may be decremented
+	b.secretIDMutex.Lock()
+	defer b.secretIDMutex.Unlock()
+
+	entryPath := b.secretIDStoragePath(roleName, secretID)
+	secretEntry, err := b.nonLockedSecretIDStorageEntry(req.Storage, entryPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if secretEntry == nil {
+		return nil, logical.ErrorResponse("invalid secret_id"), nil
+	}
+
+	if secretEntry.expired() {
+		if err := b.nonLockedDeleteSecretID(req.Storage, entryPath, secretEntry); err != nil {
+			return nil, nil, err
+		}
+		return nil, logical.ErrorResponse("invalid secret_id"), nil
+	}
+
+	// Check the CIDR restrictions before consuming a use of the secret ID
+	belongs, err := remoteAddrInCIDRList(req, secretEntry.CIDRList)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !belongs {
+		return nil, logical.ErrorResponse("source address unauthorized through CIDR restrictions on the secret ID"), nil
+	}
+
+	switch {
+	case secretEntry.SecretIDNumUses == 0:
+		// Unlimited uses
+	case secretEntry.SecretIDNumUses == 1:
+		// This was the last use; the entry goes away now
+		if err := b.nonLockedDeleteSecretID(req.Storage, entryPath, secretEntry); err != nil {
+			return nil, nil, err
+		}
+	default:
+		secretEntry.SecretIDNumUses--
+		secretEntry.LastUpdatedTime = time.Now().UTC()
+		if err := b.nonLockedSetSecretIDStorageEntry(req.Storage, entryPath, secretEntry); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return secretEntry, nil, nil
+}
+
+// Invoked when the token issued by this backend is attempting a renewal.
+func (b *backend) pathLoginRenew(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName, ok := req.Auth.InternalData["role_name"].(string)
+	if !ok || roleName == "" {
+		return nil, fmt.Errorf("failed to fetch role_name during renewal")
+	}
+
+	// Ensure that the role still exists
+	role, err := b.lockedRoleEntry(req.Storage, roleName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate role %s during renewal: %s", roleName, err)
+	}
+	if role == nil {
+		return nil, fmt.Errorf("role %s does not exist during renewal", roleName)
+	}
+
+	if !policyutil.EquivalentPolicies(role.Policies, req.Auth.Policies) {
+		return nil, fmt.Errorf("policies have changed, not renewing")
+	}
+
+	return framework.LeaseExtend(role.TokenTTL, role.TokenMaxTTL, b.System())(req, data)
+}
+
+const pathLoginHelpSys = "Issue a token based on the credentials supplied"
+
+const pathLoginHelpDesc = `
+While the credential 'role_id' is required at all times,
+other credentials required depends on the properties of the role
+to which the 'role_id' belongs to. The 'bind_secret_id'
+constraint (enabled by default) on the role requires the
+'secret_id' credential to be presented.
+
+'role_id' is fetched using the 'role/<role_name>/role-id'
+endpoint and 'secret_id' is fetched using the 'role/<role_name>/secret-id'
+endpoint.`
@@ -0,0 +1,903 @@
+package approle
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/fatih/structs"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/policyutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// roleStorageEntry stores all the options that are set on a role
+type roleStorageEntry struct {
+	// Identifier of the role, used along with the secret ID to login
+	RoleID string `json:"role_id" structs:"role_id" mapstructure:"role_id"`
+
+	// Whether a secret ID is required to login
+	BindSecretID bool `json:"bind_secret_id" structs:"bind_secret_id" mapstructure:"bind_secret_id"`
+
+	// CIDR blocks from which login is allowed
+	BoundCIDRList []string `json:"bound_cidr_list" structs:"bound_cidr_list" mapstructure:"bound_cidr_list"`
+
+	// Policies of the tokens issued by the role
+	Policies []string `json:"policies" structs:"policies" mapstructure:"policies"`
+
+	// Number of times a secret ID generated for the role can be used
+	SecretIDNumUses int `json:"secret_id_num_uses" structs:"secret_id_num_uses" mapstructure:"secret_id_num_uses"`
+
+	// Lifetime of the secret IDs generated for the role
+	SecretIDTTL time.Duration `json:"secret_id_ttl" structs:"secret_id_ttl" mapstructure:"secret_id_ttl"`
+
+	// TTL and max TTL of the tokens issued by the role
+	TokenTTL    time.Duration `json:"token_ttl" structs:"token_ttl" mapstructure:"token_ttl"`
+	TokenMaxTTL time.Duration `json:"token_max_ttl" structs:"token_max_ttl" mapstructure:"token_max_ttl"`
+}
+
+// roleIDStorageEntry maps a role ID to the name of its role
+type roleIDStorageEntry struct {
+	Name string `json:"name"`
+}
+
+func pathListRoles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/?",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathRoleList,
+		},
+
+		HelpSynopsis:    strings.TrimSpace(roleHelp["role-list"][0]),
+		HelpDescription: strings.TrimSpace(roleHelp["role-list"][1]),
+	}
+}
+
+func rolePaths(b *backend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "role/" + framework.GenericNameRegex("role_name"),
+			Fields: map[string]*framework.FieldSchema{
+				"role_name": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Name of the role.",
+				},
+				"bind_secret_id": &framework.FieldSchema{
+					Type:        framework.TypeBool,
+					Default:     true,
+					Description: "Impose secret_id to be presented when logging in using this role. Defaults to 'true'.",
+				},
+				"bound_cidr_list": &framework.FieldSchema{
+					Type: framework.TypeString,
+					Description: `Comma separated list of CIDR blocks. If set, specifies the blocks of
+IP addresses which can perform the login operation.`,
+				},
+				"policies": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Default:     "default",
+					Description: "Comma separated list of policies on the role.",
+				},
+				"secret_id_num_uses": &framework.FieldSchema{
+					Type: framework.TypeInt,
+					Description: `Number of times a secret ID can access the role, after which the
+secret ID will expire. Defaults to 0 meaning that the secret ID is of unlimited use.`,
+				},
+				"secret_id_ttl": &framework.FieldSchema{
+					Type: framework.TypeDurationSecond,
+					Description: `Duration in seconds after which the issued secret ID should expire.
+Defaults to 0, meaning no expiration.`,
+				},
+				"token_ttl": &framework.FieldSchema{
+					Type: framework.TypeDurationSecond,
+					Description: `Duration in seconds after which the issued token should expire.
+Defaults to 0, in which case the value will fall back to the system/mount defaults.`,
+				},
+				"token_max_ttl": &framework.FieldSchema{
+					Type: framework.TypeDurationSecond,
+					Description: `Duration in seconds after which the issued token should not be allowed
+to be renewed. Defaults to 0, in which case the value will fall back to the system/mount defaults.`,
+				},
+			},
+			ExistenceCheck: b.pathRoleExistenceCheck,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.CreateOperation: b.pathRoleCreateUpdate,
+				logical.UpdateOperation: b.pathRoleCreateUpdate,
+				logical.ReadOperation:   b.pathRoleRead,
+				logical.DeleteOperation: b.pathRoleDelete,
+			},
+			HelpSynopsis:    strings.TrimSpace(roleHelp["role"][0]),
+			HelpDescription: strings.TrimSpace(roleHelp["role"][1]),
+		},
+		&framework.Path{
+			Pattern: "role/" + framework.GenericNameRegex("role_name") + "/role-id$",
+			Fields: map[string]*framework.FieldSchema{
+				"role_name": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Name of the role.",
+				},
+				"role_id": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Identifier of the role. Defaults to a UUID.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.pathRoleRoleIDRead,
+				logical.UpdateOperation: b.pathRoleRoleIDUpdate,
+			},
+			HelpSynopsis:    strings.TrimSpace(roleHelp["role-id"][0]),
+			HelpDescription: strings.TrimSpace(roleHelp["role-id"][1]),
+		},
+		&framework.Path{
+			Pattern: "role/" + framework.GenericNameRegex("role_name") + "/secret-id/?$",
+			Fields: map[string]*framework.FieldSchema{
+				"role_name": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Name of the role.",
+				},
+				"metadata": &framework.FieldSchema{
+					Type: framework.TypeString,
+					Description: `JSON object of string values to be attached to the tokens issued
+with this secret ID, which will also be logged in audit logs.`,
+				},
+				"cidr_list": &framework.FieldSchema{
+					Type: framework.TypeString,
+					Description: `Comma separated list of CIDR blocks enforcing secret IDs to be used from
+specific set of IP addresses. If 'bound_cidr_list' is set on the role, then the
+list of CIDR blocks listed here should be a subset of the CIDR blocks listed on
+the role.`,
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathRoleSecretIDUpdate,
+				logical.ListOperation:   b.pathRoleSecretIDList,
+			},
+			HelpSynopsis:    strings.TrimSpace(roleHelp["secret-id"][0]),
+			HelpDescription: strings.TrimSpace(roleHelp["secret-id"][1]),
+		},
+		&framework.Path{
+			Pattern: "role/" + framework.GenericNameRegex("role_name") + "/custom-secret-id$",
+			Fields: map[string]*framework.FieldSchema{
+				"role_name": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Name of the role.",
+				},
+				"secret_id": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "SecretID to be attached to the role.",
+				},
+				"metadata": &framework.FieldSchema{
+					Type: framework.TypeString,
+					Description: `JSON object of string values to be attached to the tokens issued
+with this secret ID, which will also be logged in audit logs.`,
+				},
+				"cidr_list": &framework.FieldSchema{
+					Type: framework.TypeString,
+					Description: `Comma separated list of CIDR blocks enforcing secret IDs to be used from
+specific set of IP addresses.`,
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathRoleCustomSecretIDUpdate,
+			},
+			HelpSynopsis:    strings.TrimSpace(roleHelp["custom-secret-id"][0]),
+			HelpDescription: strings.TrimSpace(roleHelp["custom-secret-id"][1]),
+		},
+		&framework.Path{
+			Pattern: "role/" + framework.GenericNameRegex("role_name") + "/secret-id/lookup$",
+			Fields: map[string]*framework.FieldSchema{
+				"role_name": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Name of the role.",
+				},
+				"secret_id": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "SecretID attached to the role.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathRoleSecretIDLookupUpdate,
+			},
+			HelpSynopsis:    strings.TrimSpace(roleHelp["secret-id-lookup"][0]),
+			HelpDescription: strings.TrimSpace(roleHelp["secret-id-lookup"][1]),
+		},
+		&framework.Path{
+			Pattern: "role/" + framework.GenericNameRegex("role_name") + "/secret-id/destroy$",
+			Fields: map[string]*framework.FieldSchema{
+				"role_name": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Name of the role.",
+				},
+				"secret_id": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "SecretID attached to the role.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathRoleSecretIDDestroyUpdate,
+			},
+			HelpSynopsis:    strings.TrimSpace(roleHelp["secret-id-destroy"][0]),
+			HelpDescription: strings.TrimSpace(roleHelp["secret-id-destroy"][1]),
+		},
+		&framework.Path{
+			Pattern: "role/" + framework.GenericNameRegex("role_name") + "/secret-id-accessor/lookup$",
+			Fields: map[string]*framework.FieldSchema{
+				"role_name": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Name of the role.",
+				},
+				"secret_id_accessor": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Accessor of the SecretID",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathRoleSecretIDAccessorLookupUpdate,
+			},
+			HelpSynopsis:    strings.TrimSpace(roleHelp["secret-id-accessor-lookup"][0]),
+			HelpDescription: strings.TrimSpace(roleHelp["secret-id-accessor-lookup"][1]),
+		},
+		&framework.Path{
+			Pattern: "role/" + framework.GenericNameRegex("role_name") + "/secret-id-accessor/destroy$",
+			Fields: map[string]*framework.FieldSchema{
+				"role_name": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Name of the role.",
+				},
+				"secret_id_accessor": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Accessor of the SecretID",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathRoleSecretIDAccessorDestroyUpdate,
+			},
+			HelpSynopsis:    strings.TrimSpace(roleHelp["secret-id-accessor-destroy"][0]),
+			HelpDescription: strings.TrimSpace(roleHelp["secret-id-accessor-destroy"][1]),
+		},
+	}
+}
+
+// pathRoleExistenceCheck returns whether the role with the given name exists
+func (b *backend) pathRoleExistenceCheck(req *logical.Request, data *framework.FieldData) (bool, error) {
+	role, err := b.lockedRoleEntry(req.Storage, data.Get("role_name").(string))
+	if err != nil {
+		return false, err
+	}
+	return role != nil, nil
+}
+
+func (b *backend) lockedRoleEntry(s logical.Storage, roleName string) (*roleStorageEntry, error) {
+	b.roleMutex.RLock()
+	defer b.roleMutex.RUnlock()
+
+	return b.nonLockedRoleEntry(s, roleName)
+}
+
+func (b *backend) nonLockedRoleEntry(s logical.Storage, roleName string) (*roleStorageEntry, error) {
+	if roleName == "" {
+		return nil, fmt.Errorf("missing role_name")
+	}
+
+	entry, err := s.Get("role/" + strings.ToLower(roleName))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result roleStorageEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// nonLockedSetRoleEntry stores the role and, if the role ID changed, moves
+// its role ID index entry. The caller must hold the role lock.
+func (b *backend) nonLockedSetRoleEntry(s logical.Storage, roleName string, role *roleStorageEntry, previousRoleID string) error {
+	if role.RoleID != previousRoleID {
+		existing, err := b.nonLockedRoleIDEntry(s, role.RoleID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return fmt.Errorf("role_id already in use")
+		}
+
+		entry, err := logical.StorageEntryJSON("role_id/"+b.salt.SaltID(role.RoleID), &roleIDStorageEntry{
+			Name: roleName,
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.Put(entry); err != nil {
+			return err
+		}
+
+		if previousRoleID != "" {
+			if err := s.Delete("role_id/" + b.salt.SaltID(previousRoleID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON("role/"+roleName, role)
+	if err != nil {
+		return err
+	}
+	return s.Put(entry)
+}
+
+func (b *backend) nonLockedRoleIDEntry(s logical.Storage, roleID string) (*roleIDStorageEntry, error) {
+	if roleID == "" {
+		return nil, fmt.Errorf("missing role_id")
+	}
+
+	entry, err := s.Get("role_id/" + b.salt.SaltID(roleID))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result roleIDStorageEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// pathRoleList is used to list all the roles
+func (b *backend) pathRoleList(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.roleMutex.RLock()
+	defer b.roleMutex.RUnlock()
+
+	roles, err := req.Storage.List("role/")
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(roles), nil
+}
+
+// pathRoleCreateUpdate registers a new role or updates an existing one
+func (b *backend) pathRoleCreateUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := strings.ToLower(data.Get("role_name").(string))
+	if roleName == "" {
+		return logical.ErrorResponse("missing role_name"), nil
+	}
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	role, err := b.nonLockedRoleEntry(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil && req.Operation == logical.UpdateOperation {
+		return logical.ErrorResponse(fmt.Sprintf("role '%s' does not exist", roleName)), nil
+	}
+
+	// The role ID is generated when the role is created and can only be
+	// changed afterwards through the role-id endpoint
+	var previousRoleID string
+	if role == nil {
+		roleID, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate role_id: %s", err)
+		}
+		role = &roleStorageEntry{
+			RoleID: roleID,
+		}
+	} else {
+		previousRoleID = role.RoleID
+	}
+
+	if bindSecretIDRaw, ok := data.GetOk("bind_secret_id"); ok {
+		role.BindSecretID = bindSecretIDRaw.(bool)
+	} else if req.Operation == logical.CreateOperation {
+		role.BindSecretID = data.Get("bind_secret_id").(bool)
+	}
+
+	if boundCIDRListRaw, ok := data.GetOk("bound_cidr_list"); ok {
+		role.BoundCIDRList, err = parseCIDRList(boundCIDRListRaw.(string))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid bound_cidr_list: %s", err)), nil
+		}
+	}
+
+	// At least one constraint has to be set on the role
+	if !role.BindSecretID && len(role.BoundCIDRList) == 0 {
+		return logical.ErrorResponse("at least one constraint should be enabled on the role"), nil
+	}
+
+	if policiesRaw, ok := data.GetOk("policies"); ok {
+		role.Policies = policyutil.ParsePolicies(policiesRaw.(string))
+	} else if req.Operation == logical.CreateOperation {
+		role.Policies = policyutil.ParsePolicies(data.Get("policies").(string))
+	}
+
+	if numUsesRaw, ok := data.GetOk("secret_id_num_uses"); ok {
+		role.SecretIDNumUses = numUsesRaw.(int)
+		if role.SecretIDNumUses < 0 {
+			return logical.ErrorResponse("secret_id_num_uses cannot be negative"), nil
+		}
+	}
+
+	if secretIDTTLRaw, ok := data.GetOk("secret_id_ttl"); ok {
+		role.SecretIDTTL = time.Duration(secretIDTTLRaw.(int)) * time.Second
+	}
+	if tokenTTLRaw, ok := data.GetOk("token_ttl"); ok {
+		role.TokenTTL = time.Duration(tokenTTLRaw.(int)) * time.Second
+	}
+	if tokenMaxTTLRaw, ok := data.GetOk("token_max_ttl"); ok {
+		role.TokenMaxTTL = time.Duration(tokenMaxTTLRaw.(int)) * time.Second
+	}
+	if role.SecretIDTTL < 0 || role.TokenTTL < 0 || role.TokenMaxTTL < 0 {
+		return logical.ErrorResponse("TTLs cannot be negative"), nil
+	}
+
+	var resp logical.Response
+	if role.TokenMaxTTL > time.Duration(0) && role.TokenTTL > role.TokenMaxTTL {
+		return logical.ErrorResponse("token_ttl should not be greater than token_max_ttl"), nil
+	}
+	if role.TokenMaxTTL > b.System().MaxLeaseTTL() {
+		resp.AddWarning("token_max_ttl is greater than the backend mount's maximum TTL value; issued tokens' max TTL value will be truncated")
+	}
+
+	if err := b.nonLockedSetRoleEntry(req.Storage, roleName, role, previousRoleID); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Warnings()) == 0 {
+		return nil, nil
+	}
+	return &resp, nil
+}
+
+// pathRoleRead returns the properties of the role
+func (b *backend) pathRoleRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := b.lockedRoleEntry(req.Storage, data.Get("role_name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	respData := structs.New(role).Map()
+
+	// The role ID is read through the role-id endpoint
+	delete(respData, "role_id")
+
+	respData["bound_cidr_list"] = strings.Join(role.BoundCIDRList, ",")
+	respData["secret_id_ttl"] = role.SecretIDTTL / time.Second
+	respData["token_ttl"] = role.TokenTTL / time.Second
+	respData["token_max_ttl"] = role.TokenMaxTTL / time.Second
+
+	return &logical.Response{
+		Data: respData,
+	}, nil
+}
+
+// pathRoleDelete removes the role along with all of its secret IDs
+func (b *backend) pathRoleDelete(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := strings.ToLower(data.Get("role_name").(string))
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	role, err := b.nonLockedRoleEntry(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	if err := b.flushRoleSecrets(req.Storage, roleName); err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Delete("role_id/" + b.salt.SaltID(role.RoleID)); err != nil {
+		return nil, err
+	}
+
+	return nil, req.Storage.Delete("role/" + roleName)
+}
+
+// flushRoleSecrets deletes all the secret IDs of a role and their accessors
+func (b *backend) flushRoleSecrets(s logical.Storage, roleName string) error {
+	b.secretIDMutex.Lock()
+	defer b.secretIDMutex.Unlock()
+
+	prefix := "secret_id/" + b.salt.SaltID(roleName) + "/"
+	secretIDs, err := s.List(prefix)
+	if err != nil {
+		return err
+	}
+	for _, secretIDSalted := range secretIDs {
+		secretEntry, err := b.nonLockedSecretIDStorageEntry(s, prefix+secretIDSalted)
+		if err != nil {
+			return err
+		}
+		if secretEntry == nil {
+			continue
+		}
+		if err := b.nonLockedDeleteSecretID(s, prefix+secretIDSalted, secretEntry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pathRoleRoleIDRead returns the role ID of the role
+func (b *backend) pathRoleRoleIDRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := b.lockedRoleEntry(req.Storage, data.Get("role_name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"role_id": role.RoleID,
+		},
+	}, nil
+}
+
+// pathRoleRoleIDUpdate sets a custom role ID on the role
+func (b *backend) pathRoleRoleIDUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := strings.ToLower(data.Get("role_name").(string))
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	role, err := b.nonLockedRoleEntry(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role '%s' does not exist", roleName)), nil
+	}
+
+	roleID := data.Get("role_id").(string)
+	if roleID == "" {
+		return logical.ErrorResponse("missing role_id"), nil
+	}
+
+	previousRoleID := role.RoleID
+	role.RoleID = roleID
+	if err := b.nonLockedSetRoleEntry(req.Storage, roleName, role, previousRoleID); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	return nil, nil
+}
+
+// pathRoleSecretIDUpdate generates a new secret ID for the role
+func (b *backend) pathRoleSecretIDUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	secretID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secret_id: %s", err)
+	}
+	return b.handleSecretIDCreate(req, data, secretID)
+}
+
+// pathRoleCustomSecretIDUpdate registers a secret ID chosen by the caller
+func (b *backend) pathRoleCustomSecretIDUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	secretID := data.Get("secret_id").(string)
+	if secretID == "" {
+		return logical.ErrorResponse("missing secret_id"), nil
+	}
+	return b.handleSecretIDCreate(req, data, secretID)
+}
+
+func (b *backend) handleSecretIDCreate(
+	req *logical.Request, data *framework.FieldData, secretID string) (*logical.Response, error) {
+	roleName := strings.ToLower(data.Get("role_name").(string))
+
+	role, err := b.lockedRoleEntry(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role '%s' does not exist", roleName)), nil
+	}
+	if !role.BindSecretID {
+		return logical.ErrorResponse("bind_secret_id is not set on the role"), nil
+	}
+
+	cidrList, err := parseCIDRList(data.Get("cidr_list").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid cidr_list: %s", err)), nil
+	}
+	if err := checkCIDRSubset(role.BoundCIDRList, cidrList); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	metadata, err := parseMetadata(data.Get("metadata").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if _, ok := metadata["role_name"]; ok {
+		return logical.ErrorResponse("'role_name' is reserved and cannot be set in metadata"), nil
+	}
+
+	accessor, err := b.registerSecretIDEntry(req.Storage, roleName, secretID, role, &secretIDStorageEntry{
+		Metadata: metadata,
+		CIDRList: cidrList,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store secret_id: %s", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"secret_id":          secretID,
+			"secret_id_accessor": accessor,
+		},
+	}, nil
+}
+
+// checkCIDRSubset verifies that each block of the secret ID is contained in
+// one of the blocks of the role
+func checkCIDRSubset(roleCIDRs, secretIDCIDRs []string) error {
+	if len(roleCIDRs) == 0 {
+		return nil
+	}
+	for _, secretIDCIDR := range secretIDCIDRs {
+		secretIP, secretNet, err := net.ParseCIDR(secretIDCIDR)
+		if err != nil {
+			return err
+		}
+		secretOnes, _ := secretNet.Mask.Size()
+
+		contained := false
+		for _, roleCIDR := range roleCIDRs {
+			_, roleNet, err := net.ParseCIDR(roleCIDR)
+			if err != nil {
+				return err
+			}
+			roleOnes, _ := roleNet.Mask.Size()
+			if roleNet.Contains(secretIP) && roleOnes <= secretOnes {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			return fmt.Errorf("CIDR block '%s' is not a subset of the role's bound_cidr_list", secretIDCIDR)
+		}
+	}
+	return nil
+}
+
+// pathRoleSecretIDList lists the accessors of the secret IDs of the role
+func (b *backend) pathRoleSecretIDList(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := strings.ToLower(data.Get("role_name").(string))
+
+	role, err := b.lockedRoleEntry(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role '%s' does not exist", roleName)), nil
+	}
+
+	b.secretIDMutex.RLock()
+	defer b.secretIDMutex.RUnlock()
+
+	prefix := "secret_id/" + b.salt.SaltID(roleName) + "/"
+	secretIDs, err := req.Storage.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var accessors []string
+	for _, secretIDSalted := range secretIDs {
+		secretEntry, err := b.nonLockedSecretIDStorageEntry(req.Storage, prefix+secretIDSalted)
+		if err != nil {
+			return nil, err
+		}
+		if secretEntry == nil {
+			continue
+		}
+		accessors = append(accessors, secretEntry.SecretIDAccessor)
+	}
+
+	return logical.ListResponse(accessors), nil
+}
+
+// pathRoleSecretIDLookupUpdate returns the properties of a secret ID
+func (b *backend) pathRoleSecretIDLookupUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	secretID := data.Get("secret_id").(string)
+	if secretID == "" {
+		return logical.ErrorResponse("missing secret_id"), nil
+	}
+
+	entryPath := b.secretIDStoragePath(strings.ToLower(data.Get("role_name").(string)), secretID)
+	return b.secretIDLookupResponse(req.Storage, entryPath)
+}
+
+// pathRoleSecretIDDestroyUpdate deletes a secret ID
+func (b *backend) pathRoleSecretIDDestroyUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	secretID := data.Get("secret_id").(string)
+	if secretID == "" {
+		return logical.ErrorResponse("missing secret_id"), nil
+	}
+
+	entryPath := b.secretIDStoragePath(strings.ToLower(data.Get("role_name").(string)), secretID)
+	return nil, b.destroySecretID(req.Storage, entryPath)
+}
+
+// pathRoleSecretIDAccessorLookupUpdate returns the properties of the secret
+// ID with the given accessor
+func (b *backend) pathRoleSecretIDAccessorLookupUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entryPath, resp, err := b.secretIDPathFromAccessor(req.Storage, data)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	return b.secretIDLookupResponse(req.Storage, entryPath)
+}
+
+// pathRoleSecretIDAccessorDestroyUpdate deletes the secret ID with the given
+// accessor
+func (b *backend) pathRoleSecretIDAccessorDestroyUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entryPath, resp, err := b.secretIDPathFromAccessor(req.Storage, data)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	return nil, b.destroySecretID(req.Storage, entryPath)
+}
+
+// secretIDPathFromAccessor resolves the storage path of the secret ID that
+// the accessor belongs to
+func (b *backend) secretIDPathFromAccessor(s logical.Storage, data *framework.FieldData) (string, *logical.Response, error) {
+	accessor := data.Get("secret_id_accessor").(string)
+	if accessor == "" {
+		return "", logical.ErrorResponse("missing secret_id_accessor"), nil
+	}
+
+	b.secretIDMutex.RLock()
+	accessorEntry, err := b.nonLockedSecretIDAccessorEntry(s, accessor)
+	b.secretIDMutex.RUnlock()
+	if err != nil {
+		return "", nil, err
+	}
+	if accessorEntry == nil {
+		return "", logical.ErrorResponse("invalid secret_id_accessor"), nil
+	}
+
+	roleName := strings.ToLower(data.Get("role_name").(string))
+	return "secret_id/" + b.salt.SaltID(roleName) + "/" + accessorEntry.SecretIDSalted, nil, nil
+}
+
+func (b *backend) secretIDLookupResponse(s logical.Storage, entryPath string) (*logical.Response, error) {
+	b.secretIDMutex.RLock()
+	defer b.secretIDMutex.RUnlock()
+
+	secretEntry, err := b.nonLockedSecretIDStorageEntry(s, entryPath)
+	if err != nil {
+		return nil, err
+	}
+	if secretEntry == nil {
+		return nil, nil
+	}
+
+	respData := structs.New(secretEntry).Map()
+	respData["secret_id_ttl"] = secretEntry.SecretIDTTL / time.Second
+	respData["cidr_list"] = strings.Join(secretEntry.CIDRList, ",")
+	respData["expired"] = secretEntry.expired()
+
+	return &logical.Response{
+		Data: respData,
+	}, nil
+}
+
+func (b *backend) destroySecretID(s logical.Storage, entryPath string) error {
+	b.secretIDMutex.Lock()
+	defer b.secretIDMutex.Unlock()
+
+	secretEntry, err := b.nonLockedSecretIDStorageEntry(s, entryPath)
+	if err != nil {
+		return err
+	}
+	if secretEntry == nil {
+		return nil
+	}
+	return b.nonLockedDeleteSecretID(s, entryPath, secretEntry)
+}
+
+var roleHelp = map[string][2]string{
+	"role-list": {
+		"Lists all the roles registered with the backend.",
+		"The list will contain the names of the roles.",
+	},
+	"role": {
+		"Register a role with the backend.",
+		`
+A role can represent a service, a machine or anything that can be IDed.
+The set of policies on the role defines access to the role, meaning, any
+Vault token with a policy set that is a superset of the policies on the
+role registered here will have access to the role. If a SecretID is desired
+to be generated against only this specific role, it can be done via
+'role/<role_name>/secret-id' and 'role/<role_name>/custom-secret-id' endpoints.
+The properties of the SecretID created against the role and the properties
+of the token issued with the SecretID generated againt the role, can be
+configured using the parameters of this endpoint.`,
+	},
+	"role-id": {
+		"Returns the 'role_id' of the role.",
+		`
+If login is performed from an role, then its 'role_id' should be presented
+as a credential during the login. This 'role_id' can be retrieved using
+this endpoint. A custom 'role_id' can also be set by writing to it.`,
+	},
+	"secret-id": {
+		"Generate a SecretID against this role.",
+		`
+The SecretID generated using this endpoint will be scoped to access
+just this role and none else. The properties of this SecretID will be
+based on the options set on the role. It will expire after a period
+defined by the 'secret_id_ttl' option on the role and/or the backend
+mount's maximum TTL value. Listing this endpoint returns the accessors
+of the SecretIDs of the role.`,
+	},
+	"custom-secret-id": {
+		"Assign a SecretID of choice against the role.",
+		`
+This option is not recommended unless there is a specific need
+to do so. This will assign a client supplied SecretID to be used to access
+the role. This SecretID will behave similarly to the SecretIDs generated by
+the backend. The properties of this SecretID will be based on the options
+set on the role.`,
+	},
+	"secret-id-lookup": {
+		"Read the properties of an issued secret_id",
+		`
+This endpoint is used to read the properties of a secret_id associated to a
+role.`,
+	},
+	"secret-id-destroy": {
+		"Invalidate an issued secret_id",
+		`
+This endpoint is used to delete the properties of a secret_id associated to a
+role.`,
+	},
+	"secret-id-accessor-lookup": {
+		"Read an issued secret_id, using its accessor",
+		`
+This is particularly useful to lookup the non-expiring 'secret_id's.
+The list operation on the 'role/<role_name>/secret-id' endpoint will return
+the 'secret_id_accessor's. This endpoint can be used to read the properties
+of the secret. If the 'secret_id_num_uses' field in the response is 0, it
+represents a non-expiring 'secret_id'.`,
+	},
+	"secret-id-accessor-destroy": {
+		"Delete an issued secret_id, using its accessor",
+		`
+This is particularly useful to clean-up the non-expiring 'secret_id's.
+The list operation on the 'role/<role_name>/secret-id' endpoint will return
+the 'secret_id_accessor's. This endpoint can be used to read the properties
+of the secret. If the 'secret_id_num_uses' field in the response is 0, it
+represents a non-expiring 'secret_id'.`,
+	},
+}
@@ -0,0 +1,102 @@
+package approle
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathTidySecretID(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy/secret-id$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathTidySecretIDUpdate,
+		},
+
+		HelpSynopsis:    pathTidySecretIDSyn,
+		HelpDescription: pathTidySecretIDDesc,
+	}
+}
+
+// tidySecretID is used to delete entries in the secret ID storage that have
+// expired, along with their accessors. It returns the number of deleted
+// entries.
+func (b *backend) tidySecretID(s logical.Storage) (int, error) {
+	if !atomic.CompareAndSwapUint32(&b.tidySecretIDCASGuard, 0, 1) {
+		return 0, fmt.Errorf("SecretID tidy operation already running")
+	}
+	defer atomic.StoreUint32(&b.tidySecretIDCASGuard, 0)
+
+	roleNameHMACs, err := s.List("secret_id/")
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, roleNameHMAC := range roleNameHMACs {
+		// The listing of the prefix yields the role directories
+		prefix := "secret_id/" + roleNameHMAC
+		count, err := b.tidyRoleSecretIDs(s, prefix)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += count
+	}
+
+	return deleted, nil
+}
+
+// tidyRoleSecretIDs deletes the expired secret IDs of a single role
+func (b *backend) tidyRoleSecretIDs(s logical.Storage, prefix string) (int, error) {
+	b.secretIDMutex.Lock()
+	defer b.secretIDMutex.Unlock()
+
+	secretIDHMACs, err := s.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, secretIDHMAC := range secretIDHMACs {
+		entryPath := prefix + secretIDHMAC
+		secretEntry, err := b.nonLockedSecretIDStorageEntry(s, entryPath)
+		if err != nil {
+			return deleted, err
+		}
+		if secretEntry == nil || !secretEntry.expired() {
+			continue
+		}
+		if err := b.nonLockedDeleteSecretID(s, entryPath, secretEntry); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// pathTidySecretIDUpdate is used to delete the expired secret ID entries
+func (b *backend) pathTidySecretIDUpdate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	deleted, err := b.tidySecretID(req.Storage)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"deleted": deleted,
+		},
+	}, nil
+}
+
+const pathTidySecretIDSyn = "Trigger the clean-up of expired SecretID entries."
+
+const pathTidySecretIDDesc = `
+SecretIDs will have expiration time attached to them. The periodic function
+of the backend will look for expired entries and delete them. This happens
+once in an hour. Invoking this endpoint will trigger the clean-up action,
+without waiting for the backend's periodic function.`
@@ -0,0 +1,218 @@
+package approle
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/strutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+// secretIDStorageEntry represents the information stored in storage when a
+// secret ID is created.
+type secretIDStorageEntry struct {
+	// Accessor of the secret ID, which can be used to look it up or destroy
+	// it without knowing its value
+	SecretIDAccessor string `json:"secret_id_accessor" structs:"secret_id_accessor" mapstructure:"secret_id_accessor"`
+
+	// Number of times the secret ID can be used to login. Zero means
+	// unlimited uses.
+	SecretIDNumUses int `json:"secret_id_num_uses" structs:"secret_id_num_uses" mapstructure:"secret_id_num_uses"`
+
+	// Duration after which the secret ID expires. Zero means it never
+	// expires.
+	SecretIDTTL time.Duration `json:"secret_id_ttl" structs:"secret_id_ttl" mapstructure:"secret_id_ttl"`
+
+	CreationTime    time.Time `json:"creation_time" structs:"creation_time" mapstructure:"creation_time"`
+	ExpirationTime  time.Time `json:"expiration_time" structs:"expiration_time" mapstructure:"expiration_time"`
+	LastUpdatedTime time.Time `json:"last_updated_time" structs:"last_updated_time" mapstructure:"last_updated_time"`
+
+	// Metadata attached to the tokens issued with the secret ID
+	Metadata map[string]string `json:"metadata" structs:"metadata" mapstructure:"metadata"`
+
+	// CIDR blocks from which the secret ID can be used
+	CIDRList []string `json:"cidr_list" structs:"cidr_list" mapstructure:"cidr_list"`
+}
+
+// secretIDAccessorStorageEntry maps an accessor to its secret ID.
+type secretIDAccessorStorageEntry struct {
+	// The salted secret ID
+	SecretIDSalted string `json:"secret_id_salted"`
+}
+
+// expired returns whether the secret ID has outlived its TTL
+func (s *secretIDStorageEntry) expired() bool {
+	return !s.ExpirationTime.IsZero() && time.Now().UTC().After(s.ExpirationTime)
+}
+
+func (b *backend) secretIDStoragePath(roleName, secretID string) string {
+	return "secret_id/" + b.salt.SaltID(roleName) + "/" + b.salt.SaltID(secretID)
+}
+
+func (b *backend) secretIDAccessorStoragePath(accessor string) string {
+	return "accessor/" + b.salt.SaltID(accessor)
+}
+
+// nonLockedSecretIDStorageEntry fetches a secret ID entry by its storage
+// path. The caller must hold the secret ID lock.
+func (b *backend) nonLockedSecretIDStorageEntry(s logical.Storage, entryPath string) (*secretIDStorageEntry, error) {
+	entry, err := s.Get(entryPath)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result secretIDStorageEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (b *backend) nonLockedSetSecretIDStorageEntry(s logical.Storage, entryPath string, secretEntry *secretIDStorageEntry) error {
+	entry, err := logical.StorageEntryJSON(entryPath, secretEntry)
+	if err != nil {
+		return err
+	}
+	return s.Put(entry)
+}
+
+// nonLockedDeleteSecretID removes a secret ID entry and its accessor. The
+// caller must hold the secret ID lock.
+func (b *backend) nonLockedDeleteSecretID(s logical.Storage, entryPath string, secretEntry *secretIDStorageEntry) error {
+	if secretEntry.SecretIDAccessor != "" {
+		if err := s.Delete(b.secretIDAccessorStoragePath(secretEntry.SecretIDAccessor)); err != nil {
+			return fmt.Errorf("failed to delete secret ID accessor: %s", err)
+		}
+	}
+	return s.Delete(entryPath)
+}
+
+// nonLockedSecretIDAccessorEntry fetches the entry of an accessor. The caller
+// must hold the secret ID lock.
+func (b *backend) nonLockedSecretIDAccessorEntry(s logical.Storage, accessor string) (*secretIDAccessorStorageEntry, error) {
+	entry, err := s.Get(b.secretIDAccessorStoragePath(accessor))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result secretIDAccessorStorageEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// registerSecretIDEntry stores a new secret ID for the role along with an
+// accessor for it, returning the accessor.
+func (b *backend) registerSecretIDEntry(s logical.Storage, roleName, secretID string, role *roleStorageEntry, secretEntry *secretIDStorageEntry) (string, error) {
+	b.secretIDMutex.Lock()
+	defer b.secretIDMutex.Unlock()
+
+	entryPath := b.secretIDStoragePath(roleName, secretID)
+	existing, err := b.nonLockedSecretIDStorageEntry(s, entryPath)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return "", fmt.Errorf("secret ID is already registered")
+	}
+
+	accessor, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate secret ID accessor: %s", err)
+	}
+
+	currentTime := time.Now().UTC()
+	secretEntry.SecretIDAccessor = accessor
+	secretEntry.SecretIDNumUses = role.SecretIDNumUses
+	secretEntry.SecretIDTTL = role.SecretIDTTL
+	secretEntry.CreationTime = currentTime
+	secretEntry.LastUpdatedTime = currentTime
+	if secretEntry.SecretIDTTL != 0 {
+		secretEntry.ExpirationTime = currentTime.Add(secretEntry.SecretIDTTL)
+	}
+
+	accessorEntry, err := logical.StorageEntryJSON(b.secretIDAccessorStoragePath(accessor), &secretIDAccessorStorageEntry{
+		SecretIDSalted: b.salt.SaltID(secretID),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := s.Put(accessorEntry); err != nil {
+		return "", err
+	}
+
+	if err := b.nonLockedSetSecretIDStorageEntry(s, entryPath, secretEntry); err != nil {
+		return "", err
+	}
+
+	return accessor, nil
+}
+
+// parseCIDRList splits a comma separated list of CIDR blocks and checks the
+// validity of each block
+func parseCIDRList(cidrList string) ([]string, error) {
+	cidrs := strutil.ParseStrings(cidrList)
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid CIDR block '%s': %s", cidr, err)
+		}
+	}
+	return cidrs, nil
+}
+
+// remoteAddrInCIDRList checks whether the remote address of a request is in
+// one of the given CIDR blocks. An empty list allows any address.
+func remoteAddrInCIDRList(req *logical.Request, cidrs []string) (bool, error) {
+	if len(cidrs) == 0 {
+		return true, nil
+	}
+
+	if req.Connection == nil || req.Connection.RemoteAddr == "" {
+		return false, nil
+	}
+
+	// The remote address may or may not contain the port
+	remoteAddr := req.Connection.RemoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+	ip := net.ParseIP(remoteAddr)
+	if ip == nil {
+		return false, nil
+	}
+
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return false, err
+		}
+		if ipNet.Contains(ip) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// parseMetadata decodes the JSON encoded metadata of a secret ID
+func parseMetadata(metadata string) (map[string]string, error) {
+	result := make(map[string]string)
+	if strings.TrimSpace(metadata) == "" {
+		return result, nil
+	}
+	if err := json.Unmarshal([]byte(metadata), &result); err != nil {
+		return nil, fmt.Errorf("metadata must be a JSON object of string values: %s", err)
+	}
+	return result, nil
+}
@@ -403,6 +403,9 @@ func (b *backend) pathLoginUpdate(
 				Renewable: true,
 				TTL:       b.System().DefaultLeaseTTL(),
 			},
+			Alias: &logical.Alias{
+				Name: identityDoc.InstanceID,
+			},
 		},
 	}
 
@@ -79,6 +79,11 @@ func (b *backend) pathLogin(
 				Renewable: true,
 				TTL:       ttl,
 			},
+			// The common name alone is not unique, as the certificates
+			// of several roles may share it
+			Alias: &logical.Alias{
+				Name: matched.Entry.Name + "/" + clientCerts[0].Subject.CommonName,
+			},
 		},
 	}
 	return resp, nil
@@ -82,41 +82,43 @@ func EscapeLDAPValue(input string) string {
 	return input
 }
 
-func (b *backend) Login(req *logical.Request, username string, password string) ([]string, *logical.Response, error) {
+// Login authenticates a user against LDAP, and returns the policies of the
+// user along with the LDAP groups the user is a member of
+func (b *backend) Login(req *logical.Request, username string, password string) ([]string, []string, *logical.Response, error) {
 
 	cfg, err := b.Config(req)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	if cfg == nil {
-		return nil, logical.ErrorResponse("ldap backend not configured"), nil
+		return nil, nil, logical.ErrorResponse("ldap backend not configured"), nil
 	}
 
 	c, err := cfg.DialLDAP()
 	if err != nil {
-		return nil, logical.ErrorResponse(err.Error()), nil
+		return nil, nil, logical.ErrorResponse(err.Error()), nil
 	}
 	if c == nil {
-		return nil, logical.ErrorResponse("invalid connection returned from LDAP dial"), nil
+		return nil, nil, logical.ErrorResponse("invalid connection returned from LDAP dial"), nil
 	}
 
 	bindDN, err := getBindDN(cfg, c, username)
 	if err != nil {
-		return nil, logical.ErrorResponse(err.Error()), nil
+		return nil, nil, logical.ErrorResponse(err.Error()), nil
 	}
 
 	if err = c.Bind(bindDN, password); err != nil {
-		return nil, logical.ErrorResponse(fmt.Sprintf("LDAP bind failed: %v", err)), nil
+		return nil, nil, logical.ErrorResponse(fmt.Sprintf("LDAP bind failed: %v", err)), nil
 	}
 
 	userDN, err := getUserDN(cfg, c, bindDN)
 	if err != nil {
-		return nil, logical.ErrorResponse(err.Error()), nil
+		return nil, nil, logical.ErrorResponse(err.Error()), nil
 	}
 
 	ldapGroups, err := getLdapGroups(cfg, c, userDN, username)
 	if err != nil {
-		return nil, logical.ErrorResponse(err.Error()), nil
+		return nil, nil, logical.ErrorResponse(err.Error()), nil
 	}
 
 	ldapResponse := &logical.Response{
@@ -155,10 +157,10 @@ func (b *backend) Login(req *logical.Request, username string, password string)
 		}
 
 		ldapResponse.Data["error"] = errStr
-		return nil, ldapResponse, nil
+		return nil, nil, ldapResponse, nil
 	}
 
-	return policies, ldapResponse, nil
+	return policies, ldapGroups, ldapResponse, nil
 }
 
 func getBindDN(cfg *ConfigEntry, c *ldap.Conn, username string) (string, error) {
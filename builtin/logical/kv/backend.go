@@ -0,0 +1,80 @@
+package kv
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	// defaultMaxVersions is the number of versions retained per key when
+	// neither the key metadata nor the backend configuration set a limit.
+	defaultMaxVersions = 10
+
+	// lockCount is the number of locks used to serialize writes to the
+	// metadata of keys. Keys are mapped onto the locks by hashing their path.
+	lockCount = 256
+)
+
+func Factory(conf *logical.BackendConfig) (logical.Backend, error) {
+	return Backend().Setup(conf)
+}
+
+func Backend() *backend {
+	var b backend
+	b.Backend = &framework.Backend{
+		Help: strings.TrimSpace(backendHelp),
+
+		PathsSpecial: &logical.Paths{
+			// Older versions of a secret are selected with the "version"
+			// query parameter
+			QueryParams: []string{
+				"data/*",
+			},
+		},
+
+		Paths: []*framework.Path{
+			pathConfig(&b),
+			pathData(&b),
+			pathMetadata(&b),
+			pathDelete(&b),
+			pathUndelete(&b),
+			pathDestroy(&b),
+		},
+	}
+
+	return &b
+}
+
+type backend struct {
+	*framework.Backend
+
+	// Locks guarding the read-modify-write cycle on the metadata of a key.
+	// Check-and-set semantics rely on these being held across the version
+	// check and the write of the new version.
+	locks [lockCount]sync.RWMutex
+}
+
+// lockForKey returns the lock which guards the given key.
+func (b *backend) lockForKey(key string) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &b.locks[h.Sum32()%lockCount]
+}
+
+const backendHelp = `
+The kv backend stores arbitrary secrets while retaining a configurable
+number of previous versions of every key.
+
+Secrets are written and read at "data/<path>". Every write creates a new
+version; older versions can be read by passing the "version" parameter.
+Versions can be soft deleted and undeleted via "delete/<path>" and
+"undelete/<path>", or permanently removed via "destroy/<path>". The
+version history of a key is available at "metadata/<path>".
+
+Writes can supply a "cas" option to only succeed if the current version
+of the key matches the given value.
+`
@@ -0,0 +1,285 @@
+package kv
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// failingMetadataStorage fails the writes of the key metadata
+type failingMetadataStorage struct {
+	logical.Storage
+}
+
+func (s *failingMetadataStorage) Put(entry *logical.StorageEntry) error {
+	if strings.HasPrefix(entry.Key, metadataPrefix) {
+		return errors.New("metadata write failed")
+	}
+	return s.Storage.Put(entry)
+}
+
+func getBackend(t *testing.T) (*backend, logical.Storage) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b := Backend()
+	if _, err := b.Setup(config); err != nil {
+		t.Fatal(err)
+	}
+
+	return b, storage
+}
+
+func testRequest(t *testing.T, b *backend, storage logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: op,
+		Path:      path,
+		Storage:   storage,
+		Data:      data,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: %s %s: resp: %#v err: %v", op, path, resp, err)
+	}
+	return resp
+}
+
+func testWrite(t *testing.T, b *backend, storage logical.Storage, path string, data map[string]interface{}) uint64 {
+	resp := testRequest(t, b, storage, logical.UpdateOperation, "data/"+path, map[string]interface{}{
+		"data": data,
+	})
+	return resp.Data["version"].(uint64)
+}
+
+func testRead(t *testing.T, b *backend, storage logical.Storage, path string, version int) *logical.Response {
+	var data map[string]interface{}
+	if version != 0 {
+		data = map[string]interface{}{
+			"version": version,
+		}
+	}
+	return testRequest(t, b, storage, logical.ReadOperation, "data/"+path, data)
+}
+
+func TestBackend_versions(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for i := 1; i <= 3; i++ {
+		version := testWrite(t, b, storage, "foo/bar", map[string]interface{}{
+			"value": i,
+		})
+		if version != uint64(i) {
+			t.Fatalf("expected version %d, got %d", i, version)
+		}
+	}
+
+	resp := testRead(t, b, storage, "foo/bar", 0)
+	if !reflect.DeepEqual(resp.Data["data"], map[string]interface{}{"value": float64(3)}) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if resp.Data["metadata"].(map[string]interface{})["version"].(uint64) != 3 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	resp = testRead(t, b, storage, "foo/bar", 1)
+	if !reflect.DeepEqual(resp.Data["data"], map[string]interface{}{"value": float64(1)}) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// A version that was never written is not found
+	resp = testRead(t, b, storage, "foo/bar", 4)
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	resp = testRequest(t, b, storage, logical.ReadOperation, "metadata/foo/bar", nil)
+	if resp.Data["current_version"].(uint64) != 3 || resp.Data["oldest_version"].(uint64) != 1 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if len(resp.Data["versions"].(map[string]interface{})) != 3 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	resp = testRequest(t, b, storage, logical.ListOperation, "metadata/foo", nil)
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"bar"}) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
+func TestBackend_maxVersions(t *testing.T) {
+	b, storage := getBackend(t)
+
+	testRequest(t, b, storage, logical.UpdateOperation, "config", map[string]interface{}{
+		"max_versions": 3,
+	})
+
+	for i := 1; i <= 5; i++ {
+		testWrite(t, b, storage, "foo", map[string]interface{}{
+			"value": i,
+		})
+	}
+
+	resp := testRequest(t, b, storage, logical.ReadOperation, "metadata/foo", nil)
+	if resp.Data["oldest_version"].(uint64) != 3 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if len(resp.Data["versions"].(map[string]interface{})) != 3 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if resp := testRead(t, b, storage, "foo", 2); resp != nil {
+		t.Fatalf("pruned version should not be readable: %#v", resp)
+	}
+	if entry, _ := storage.Get(versionKey("foo", 2)); entry != nil {
+		t.Fatal("pruned version data should have been removed")
+	}
+
+	// The data of a pruned version is only removed once the metadata no
+	// longer references it
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "data/foo",
+		Storage:   &failingMetadataStorage{Storage: storage},
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"value": 6,
+			},
+		},
+	})
+	if err == nil {
+		t.Fatalf("expected error, got: %#v", resp)
+	}
+	if resp := testRead(t, b, storage, "foo", 3); resp == nil {
+		t.Fatal("version referenced by the metadata should be readable")
+	}
+
+	// Lowering the per-key limit prunes immediately
+	testRequest(t, b, storage, logical.UpdateOperation, "metadata/foo", map[string]interface{}{
+		"max_versions": 1,
+	})
+	resp = testRequest(t, b, storage, logical.ReadOperation, "metadata/foo", nil)
+	if resp.Data["oldest_version"].(uint64) != 5 || resp.Data["max_versions"].(int) != 1 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
+func TestBackend_cas(t *testing.T) {
+	b, storage := getBackend(t)
+
+	write := func(cas interface{}) (*logical.Response, error) {
+		return b.HandleRequest(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+				"options": map[string]interface{}{
+					"cas": cas,
+				},
+			},
+		})
+	}
+
+	// cas of 0 only succeeds if the key does not exist
+	if resp, err := write(0); err != nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v err: %v", resp, err)
+	}
+	if resp, err := write(0); err != logical.ErrInvalidRequest || !resp.IsError() {
+		t.Fatalf("expected cas failure: resp: %#v err: %v", resp, err)
+	}
+
+	if resp, err := write(2); err != logical.ErrInvalidRequest || !resp.IsError() {
+		t.Fatalf("expected cas failure: resp: %#v err: %v", resp, err)
+	}
+	if resp, err := write("1"); err != nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v err: %v", resp, err)
+	}
+
+	// Once required, writes without cas are rejected
+	testRequest(t, b, storage, logical.UpdateOperation, "metadata/foo", map[string]interface{}{
+		"cas_required": true,
+	})
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	})
+	if err != logical.ErrInvalidRequest || !resp.IsError() {
+		t.Fatalf("expected cas failure: resp: %#v err: %v", resp, err)
+	}
+}
+
+func TestBackend_deleteUndeleteDestroy(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for i := 1; i <= 3; i++ {
+		testWrite(t, b, storage, "foo", map[string]interface{}{
+			"value": i,
+		})
+	}
+
+	// Deleting the data path soft deletes the current version
+	testRequest(t, b, storage, logical.DeleteOperation, "data/foo", nil)
+	resp := testRead(t, b, storage, "foo", 0)
+	if resp.Data["data"] != nil {
+		t.Fatalf("deleted version should not return data: %#v", resp.Data)
+	}
+	if resp.Data["metadata"].(map[string]interface{})["deletion_time"].(string) == "" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	testRequest(t, b, storage, logical.UpdateOperation, "delete/foo", map[string]interface{}{
+		"versions": "1,2",
+	})
+	if resp := testRead(t, b, storage, "foo", 1); resp.Data["data"] != nil {
+		t.Fatalf("deleted version should not return data: %#v", resp.Data)
+	}
+
+	testRequest(t, b, storage, logical.UpdateOperation, "undelete/foo", map[string]interface{}{
+		"versions": "1,3",
+	})
+	if resp := testRead(t, b, storage, "foo", 1); resp.Data["data"] == nil {
+		t.Fatalf("undeleted version should return data: %#v", resp.Data)
+	}
+	if resp := testRead(t, b, storage, "foo", 3); resp.Data["data"] == nil {
+		t.Fatalf("undeleted version should return data: %#v", resp.Data)
+	}
+
+	testRequest(t, b, storage, logical.UpdateOperation, "destroy/foo", map[string]interface{}{
+		"versions": "2",
+	})
+	if entry, _ := storage.Get(versionKey("foo", 2)); entry != nil {
+		t.Fatal("destroyed version data should have been removed")
+	}
+
+	// Destroyed versions can not be undeleted
+	testRequest(t, b, storage, logical.UpdateOperation, "undelete/foo", map[string]interface{}{
+		"versions": "2",
+	})
+	resp = testRead(t, b, storage, "foo", 2)
+	if resp.Data["data"] != nil || !resp.Data["metadata"].(map[string]interface{})["destroyed"].(bool) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Deleting the metadata removes the key entirely
+	testRequest(t, b, storage, logical.DeleteOperation, "metadata/foo", nil)
+	if resp := testRead(t, b, storage, "foo", 0); resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+	keys, err := storage.List(versionPrefixForKey("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("version data should have been removed: %v", keys)
+	}
+}
@@ -0,0 +1,116 @@
+package kv
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathConfig(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config$",
+		Fields: map[string]*framework.FieldSchema{
+			"max_versions": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `The number of versions to keep for each key. Defaults
+to 10. This can be overridden per key via the metadata endpoint.`,
+			},
+			"cas_required": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If true, all keys will require the "cas" option to
+be set on writes.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathConfigRead,
+			logical.UpdateOperation: b.pathConfigWrite,
+		},
+
+		HelpSynopsis:    pathConfigHelpSyn,
+		HelpDescription: pathConfigHelpDesc,
+	}
+}
+
+// configEntry holds the backend wide settings for version retention and
+// check-and-set enforcement.
+type configEntry struct {
+	MaxVersions int  `json:"max_versions" structs:"max_versions" mapstructure:"max_versions"`
+	CASRequired bool `json:"cas_required" structs:"cas_required" mapstructure:"cas_required"`
+}
+
+// config returns the stored backend configuration, or an empty one if none
+// has been written yet.
+func (b *backend) config(s logical.Storage) (*configEntry, error) {
+	entry, err := s.Get("config")
+	if err != nil {
+		return nil, err
+	}
+
+	var result configEntry
+	if entry == nil {
+		return &result, nil
+	}
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (b *backend) pathConfigRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"max_versions": config.MaxVersions,
+			"cas_required": config.CASRequired,
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxVersionsRaw, ok := d.GetOk("max_versions"); ok {
+		config.MaxVersions = maxVersionsRaw.(int)
+	}
+	if config.MaxVersions < 0 {
+		return logical.ErrorResponse("max_versions cannot be negative"), nil
+	}
+
+	if casRequiredRaw, ok := d.GetOk("cas_required"); ok {
+		config.CASRequired = casRequiredRaw.(bool)
+	}
+
+	entry, err := logical.StorageEntryJSON("config", config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, fmt.Errorf("failed to write configuration: %v", err)
+	}
+
+	return nil, nil
+}
+
+const pathConfigHelpSyn = `
+Configures the version retention and check-and-set behavior of the backend.
+`
+
+const pathConfigHelpDesc = `
+This path configures backend wide defaults. "max_versions" sets the number of
+versions kept for each key; once exceeded, the oldest versions are removed.
+A value of 0 uses the default of 10. "cas_required" forces every write to
+supply the "cas" option. Both values can be overridden per key through the
+"metadata/<path>" endpoint.
+`
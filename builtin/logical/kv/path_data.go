@@ -0,0 +1,248 @@
+package kv
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"github.com/mitchellh/mapstructure"
+)
+
+func pathData(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "data/(?P<path>.+)",
+		Fields: map[string]*framework.FieldSchema{
+			"path": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Location of the secret.",
+			},
+			"version": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `The version to read. If unset or 0, the current
+version is returned.`,
+			},
+			"data": &framework.FieldSchema{
+				Type:        framework.TypeMap,
+				Description: "The secret data to write.",
+			},
+			"options": &framework.FieldSchema{
+				Type: framework.TypeMap,
+				Description: `Options for the write. "cas" sets the version the
+key is expected to be at; the write fails if it does not match. A value of 0
+only allows the write if the key does not exist.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathDataRead,
+			logical.CreateOperation: b.pathDataWrite,
+			logical.UpdateOperation: b.pathDataWrite,
+			logical.DeleteOperation: b.pathDataDelete,
+		},
+
+		ExistenceCheck: b.pathMetadataExistenceCheck,
+
+		HelpSynopsis:    pathDataHelpSyn,
+		HelpDescription: pathDataHelpDesc,
+	}
+}
+
+// versionEntry is the data of a single version of a key.
+type versionEntry struct {
+	Data        map[string]interface{} `json:"data"`
+	CreatedTime time.Time              `json:"created_time"`
+}
+
+// writeOptions are the options which can be given alongside a write.
+type writeOptions struct {
+	CAS *uint64 `mapstructure:"cas"`
+}
+
+func (b *backend) pathDataRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("path").(string)
+
+	version := d.Get("version").(int)
+	if version < 0 {
+		return logical.ErrorResponse("version cannot be negative"), nil
+	}
+
+	lock := b.lockForKey(key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	meta, err := b.keyMetadata(req.Storage, key)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, nil
+	}
+
+	readVersion := uint64(version)
+	if readVersion == 0 {
+		readVersion = meta.CurrentVersion
+	}
+
+	versionMeta, ok := meta.Versions[readVersion]
+	if !ok {
+		return nil, nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"data":     nil,
+			"metadata": versionResponseMetadata(readVersion, versionMeta),
+		},
+	}
+
+	// Deleted and destroyed versions only expose their metadata
+	if versionMeta.Deleted() || versionMeta.Destroyed {
+		return resp, nil
+	}
+
+	entry, err := req.Storage.Get(versionKey(key, readVersion))
+	if err != nil {
+		return nil, fmt.Errorf("read failed: %v", err)
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("data for version %d of %q is missing", readVersion, key)
+	}
+
+	var data versionEntry
+	if err := entry.DecodeJSON(&data); err != nil {
+		return nil, fmt.Errorf("json decoding failed: %v", err)
+	}
+	resp.Data["data"] = data.Data
+
+	return resp, nil
+}
+
+func (b *backend) pathDataWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("path").(string)
+
+	dataRaw, ok := d.GetOk("data")
+	if !ok {
+		return logical.ErrorResponse("missing data"), nil
+	}
+	data := dataRaw.(map[string]interface{})
+
+	var options writeOptions
+	if optionsRaw, ok := d.GetOk("options"); ok {
+		if err := mapstructure.WeakDecode(optionsRaw, &options); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid options: %v", err)), nil
+		}
+	}
+
+	config, err := b.config(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := b.lockForKey(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.keyMetadata(req.Storage, key)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		meta = &keyMetadata{
+			Key:      key,
+			Versions: map[uint64]*versionMetadata{},
+		}
+	}
+
+	// Enforce check-and-set before anything is written
+	switch {
+	case options.CAS != nil:
+		if *options.CAS != meta.CurrentVersion {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"check-and-set parameter did not match the current version: expected %d, current version is %d",
+				*options.CAS, meta.CurrentVersion)), logical.ErrInvalidRequest
+		}
+	case meta.CASRequired || config.CASRequired:
+		return logical.ErrorResponse("check-and-set parameter required for this call"), logical.ErrInvalidRequest
+	}
+
+	now := time.Now().UTC()
+	version := meta.addVersion(now)
+
+	entry, err := logical.StorageEntryJSON(versionKey(key, version), &versionEntry{
+		Data:        data,
+		CreatedTime: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The version data is written before the metadata referencing it, so an
+	// interrupted write leaves the key at its previous version
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, fmt.Errorf("failed to write: %v", err)
+	}
+	if err := b.putPrunedKeyMetadata(req.Storage, config, meta); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: versionResponseMetadata(version, meta.Versions[version]),
+	}, nil
+}
+
+func (b *backend) pathDataDelete(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("path").(string)
+
+	lock := b.lockForKey(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.keyMetadata(req.Storage, key)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, nil
+	}
+
+	versionMeta, ok := meta.Versions[meta.CurrentVersion]
+	if !ok || versionMeta.Deleted() || versionMeta.Destroyed {
+		return nil, nil
+	}
+
+	versionMeta.DeletionTime = time.Now().UTC()
+	if err := b.putKeyMetadata(req.Storage, meta); err != nil {
+		return nil, fmt.Errorf("failed to write metadata: %v", err)
+	}
+
+	return nil, nil
+}
+
+func versionResponseMetadata(version uint64, v *versionMetadata) map[string]interface{} {
+	result := v.toMap()
+	result["version"] = version
+	return result
+}
+
+const pathDataHelpSyn = `
+Writes, reads and deletes versions of a secret.
+`
+
+const pathDataHelpDesc = `
+A write to this path stores the given "data" as a new version of the key.
+If the "cas" option is given, the write only succeeds if the current
+version of the key matches it; a "cas" of 0 only allows the write if the
+key does not exist yet. Once the number of versions exceeds the configured
+limit, the oldest versions are removed.
+
+A read returns the current version of the key, or the version given by the
+"version" parameter, along with the metadata of that version. Deleted and
+destroyed versions return only their metadata.
+
+A delete soft deletes the current version. It can be restored through the
+"undelete/<path>" endpoint.
+`
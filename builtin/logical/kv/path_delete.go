@@ -0,0 +1,192 @@
+package kv
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/helper/strutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func versionFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"path": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Location of the secret.",
+		},
+		"versions": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Comma-separated list of the versions to operate on.",
+		},
+	}
+}
+
+func pathDelete(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "delete/(?P<path>.+)",
+		Fields:  versionFields(),
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.versionsOperation(softDeleteVersion),
+		},
+
+		HelpSynopsis:    pathDeleteHelpSyn,
+		HelpDescription: pathDeleteHelpDesc,
+	}
+}
+
+func pathUndelete(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "undelete/(?P<path>.+)",
+		Fields:  versionFields(),
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.versionsOperation(undeleteVersion),
+		},
+
+		HelpSynopsis:    pathUndeleteHelpSyn,
+		HelpDescription: pathUndeleteHelpDesc,
+	}
+}
+
+func pathDestroy(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "destroy/(?P<path>.+)",
+		Fields:  versionFields(),
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.versionsOperation(destroyVersion),
+		},
+
+		HelpSynopsis:    pathDestroyHelpSyn,
+		HelpDescription: pathDestroyHelpDesc,
+	}
+}
+
+// versionFunc changes the state of a single version of a key. It returns
+// true if the metadata of the version was modified.
+type versionFunc func(s logical.Storage, key string, version uint64, v *versionMetadata) (bool, error)
+
+func softDeleteVersion(s logical.Storage, key string, version uint64, v *versionMetadata) (bool, error) {
+	if v.Deleted() || v.Destroyed {
+		return false, nil
+	}
+	v.DeletionTime = time.Now().UTC()
+	return true, nil
+}
+
+func undeleteVersion(s logical.Storage, key string, version uint64, v *versionMetadata) (bool, error) {
+	if !v.Deleted() || v.Destroyed {
+		return false, nil
+	}
+	v.DeletionTime = time.Time{}
+	return true, nil
+}
+
+func destroyVersion(s logical.Storage, key string, version uint64, v *versionMetadata) (bool, error) {
+	if v.Destroyed {
+		return false, nil
+	}
+	if err := s.Delete(versionKey(key, version)); err != nil {
+		return false, err
+	}
+	v.Destroyed = true
+	return true, nil
+}
+
+// versionsOperation returns a callback applying f to each of the versions
+// given in the request.
+func (b *backend) versionsOperation(f versionFunc) framework.OperationFunc {
+	return func(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		key := d.Get("path").(string)
+
+		versions, err := parseVersions(d.Get("versions").(string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if len(versions) == 0 {
+			return logical.ErrorResponse("no versions provided"), nil
+		}
+
+		lock := b.lockForKey(key)
+		lock.Lock()
+		defer lock.Unlock()
+
+		meta, err := b.keyMetadata(req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return nil, nil
+		}
+
+		modified := false
+		for _, version := range versions {
+			versionMeta, ok := meta.Versions[version]
+			if !ok {
+				continue
+			}
+
+			changed, err := f(req.Storage, key, version, versionMeta)
+			if err != nil {
+				return nil, err
+			}
+			modified = modified || changed
+		}
+
+		if !modified {
+			return nil, nil
+		}
+
+		if err := b.putKeyMetadata(req.Storage, meta); err != nil {
+			return nil, fmt.Errorf("failed to write metadata: %v", err)
+		}
+
+		return nil, nil
+	}
+}
+
+// parseVersions parses a comma-separated list of version numbers.
+func parseVersions(input string) ([]uint64, error) {
+	var versions []uint64
+	for _, v := range strutil.ParseStrings(input) {
+		version, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", v)
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+const pathDeleteHelpSyn = `
+Soft deletes versions of a key.
+`
+
+const pathDeleteHelpDesc = `
+Marks the given versions of the key as deleted. Their data is retained and
+they can be restored through "undelete/<path>", but reads of the versions
+return only their metadata.
+`
+
+const pathUndeleteHelpSyn = `
+Restores soft deleted versions of a key.
+`
+
+const pathUndeleteHelpDesc = `
+Clears the deletion marker of the given versions of the key, making their
+data readable again. Destroyed versions can not be restored.
+`
+
+const pathDestroyHelpSyn = `
+Permanently removes versions of a key.
+`
+
+const pathDestroyHelpDesc = `
+Removes the data of the given versions of the key from storage. The versions
+remain in the history of the key, marked as destroyed, but their data can not
+be recovered.
+`
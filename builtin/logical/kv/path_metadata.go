@@ -0,0 +1,372 @@
+package kv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	metadataPrefix = "metadata/"
+	versionPrefix  = "versions/"
+)
+
+func pathMetadata(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "metadata/?(?P<path>.*)",
+		Fields: map[string]*framework.FieldSchema{
+			"path": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Location of the secret.",
+			},
+			"max_versions": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `The number of versions to keep for this key. If
+unset or 0, the backend configuration is used.`,
+			},
+			"cas_required": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If true, writes to this key will require the "cas"
+option to be set. If false, the backend configuration is used.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathMetadataRead,
+			logical.CreateOperation: b.pathMetadataWrite,
+			logical.UpdateOperation: b.pathMetadataWrite,
+			logical.DeleteOperation: b.pathMetadataDelete,
+			logical.ListOperation:   b.pathMetadataList,
+		},
+
+		ExistenceCheck: b.pathMetadataExistenceCheck,
+
+		HelpSynopsis:    pathMetadataHelpSyn,
+		HelpDescription: pathMetadataHelpDesc,
+	}
+}
+
+// keyMetadata is the version history of a single key. It is stored
+// separately from the version data so that it can be read and managed
+// without access to the secret values.
+type keyMetadata struct {
+	Key            string                      `json:"key"`
+	Versions       map[uint64]*versionMetadata `json:"versions"`
+	CurrentVersion uint64                      `json:"current_version"`
+	OldestVersion  uint64                      `json:"oldest_version"`
+	MaxVersions    int                         `json:"max_versions"`
+	CASRequired    bool                        `json:"cas_required"`
+	CreatedTime    time.Time                   `json:"created_time"`
+	UpdatedTime    time.Time                   `json:"updated_time"`
+}
+
+// versionMetadata describes the state of a single version of a key.
+type versionMetadata struct {
+	CreatedTime  time.Time `json:"created_time"`
+	DeletionTime time.Time `json:"deletion_time"`
+	Destroyed    bool      `json:"destroyed"`
+}
+
+// Deleted returns true if the version was soft deleted.
+func (v *versionMetadata) Deleted() bool {
+	return !v.DeletionTime.IsZero()
+}
+
+func (v *versionMetadata) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"created_time":  formatTime(v.CreatedTime),
+		"deletion_time": formatTime(v.DeletionTime),
+		"destroyed":     v.Destroyed,
+	}
+}
+
+// addVersion records a new current version of the key.
+func (m *keyMetadata) addVersion(now time.Time) uint64 {
+	m.CurrentVersion++
+	m.Versions[m.CurrentVersion] = &versionMetadata{
+		CreatedTime: now,
+	}
+	if m.OldestVersion == 0 {
+		m.OldestVersion = m.CurrentVersion
+	}
+	if m.CreatedTime.IsZero() {
+		m.CreatedTime = now
+	}
+	m.UpdatedTime = now
+
+	return m.CurrentVersion
+}
+
+// prune drops the oldest versions from the history until at most
+// maxVersions remain, returning the versions which were dropped so that
+// their data can be removed from storage.
+func (m *keyMetadata) prune(maxVersions int) []uint64 {
+	var pruned []uint64
+	for len(m.Versions) > maxVersions && m.OldestVersion <= m.CurrentVersion {
+		if _, ok := m.Versions[m.OldestVersion]; ok {
+			delete(m.Versions, m.OldestVersion)
+			pruned = append(pruned, m.OldestVersion)
+		}
+		m.OldestVersion++
+	}
+
+	return pruned
+}
+
+// maxVersions returns the effective version limit for the key, falling back
+// to the backend configuration and then the default.
+func (m *keyMetadata) maxVersions(config *configEntry) int {
+	switch {
+	case m.MaxVersions > 0:
+		return m.MaxVersions
+	case config.MaxVersions > 0:
+		return config.MaxVersions
+	default:
+		return defaultMaxVersions
+	}
+}
+
+// versionKey returns the storage location of the data of a version. The key
+// path is hashed so that arbitrary paths can't collide with each other.
+func versionKey(key string, version uint64) string {
+	return versionPrefixForKey(key) + strconv.FormatUint(version, 10)
+}
+
+func versionPrefixForKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return versionPrefix + hex.EncodeToString(sum[:]) + "/"
+}
+
+// keyMetadata reads the metadata of the given key. The caller is expected to
+// hold the lock for the key.
+func (b *backend) keyMetadata(s logical.Storage, key string) (*keyMetadata, error) {
+	entry, err := s.Get(metadataPrefix + key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result keyMetadata
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	if result.Versions == nil {
+		result.Versions = map[uint64]*versionMetadata{}
+	}
+
+	return &result, nil
+}
+
+func (b *backend) putKeyMetadata(s logical.Storage, meta *keyMetadata) error {
+	entry, err := logical.StorageEntryJSON(metadataPrefix+meta.Key, meta)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(entry)
+}
+
+// putPrunedKeyMetadata trims the version history of the key to its
+// configured limit, writes the metadata, then removes the data of the
+// dropped versions. The metadata is written first so that an interrupted
+// write never leaves it referencing versions whose data is gone.
+func (b *backend) putPrunedKeyMetadata(s logical.Storage, config *configEntry, meta *keyMetadata) error {
+	pruned := meta.prune(meta.maxVersions(config))
+	if err := b.putKeyMetadata(s, meta); err != nil {
+		return fmt.Errorf("failed to write metadata: %v", err)
+	}
+
+	for _, version := range pruned {
+		if err := s.Delete(versionKey(meta.Key, version)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *backend) pathMetadataExistenceCheck(
+	req *logical.Request, d *framework.FieldData) (bool, error) {
+	key := d.Get("path").(string)
+
+	lock := b.lockForKey(key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	meta, err := b.keyMetadata(req.Storage, key)
+	if err != nil {
+		return false, fmt.Errorf("existence check failed: %v", err)
+	}
+
+	return meta != nil, nil
+}
+
+func (b *backend) pathMetadataRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("path").(string)
+	if key == "" {
+		return logical.ErrorResponse("missing path"), nil
+	}
+
+	lock := b.lockForKey(key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	meta, err := b.keyMetadata(req.Storage, key)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, nil
+	}
+
+	versions := make(map[string]interface{}, len(meta.Versions))
+	for version, versionMeta := range meta.Versions {
+		versions[strconv.FormatUint(version, 10)] = versionMeta.toMap()
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"versions":        versions,
+			"current_version": meta.CurrentVersion,
+			"oldest_version":  meta.OldestVersion,
+			"max_versions":    meta.MaxVersions,
+			"cas_required":    meta.CASRequired,
+			"created_time":    formatTime(meta.CreatedTime),
+			"updated_time":    formatTime(meta.UpdatedTime),
+		},
+	}, nil
+}
+
+func (b *backend) pathMetadataWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("path").(string)
+	if key == "" {
+		return logical.ErrorResponse("missing path"), nil
+	}
+
+	config, err := b.config(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := b.lockForKey(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.keyMetadata(req.Storage, key)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		now := time.Now().UTC()
+		meta = &keyMetadata{
+			Key:         key,
+			Versions:    map[uint64]*versionMetadata{},
+			CreatedTime: now,
+			UpdatedTime: now,
+		}
+	}
+
+	if maxVersionsRaw, ok := d.GetOk("max_versions"); ok {
+		meta.MaxVersions = maxVersionsRaw.(int)
+	}
+	if meta.MaxVersions < 0 {
+		return logical.ErrorResponse("max_versions cannot be negative"), nil
+	}
+
+	if casRequiredRaw, ok := d.GetOk("cas_required"); ok {
+		meta.CASRequired = casRequiredRaw.(bool)
+	}
+
+	// Lowering the version limit takes effect immediately
+	if err := b.putPrunedKeyMetadata(req.Storage, config, meta); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathMetadataDelete(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("path").(string)
+	if key == "" {
+		return logical.ErrorResponse("missing path"), nil
+	}
+
+	lock := b.lockForKey(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Remove the data of every version, including any which may have been
+	// left behind by an interrupted earlier delete
+	prefix := versionPrefixForKey(key)
+	versions, err := req.Storage.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		if err := req.Storage.Delete(prefix + version); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := req.Storage.Delete(metadataPrefix + key); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathMetadataList(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("path").(string)
+	if key != "" && !strings.HasSuffix(key, "/") {
+		key = key + "/"
+	}
+
+	keys, err := req.Storage.List(metadataPrefix + key)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	return logical.ListResponse(keys), nil
+}
+
+// formatTime renders a timestamp for a response, using the empty string for
+// unset times.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+const pathMetadataHelpSyn = `
+Manages the version history and settings of a key.
+`
+
+const pathMetadataHelpDesc = `
+A read of this path returns the version history of the key: the creation,
+deletion and destruction state of every retained version, as well as the
+current and oldest version numbers and the times the key was created and
+last updated.
+
+Writing to this path sets per-key values for "max_versions" and
+"cas_required", overriding the backend configuration.
+
+Deleting this path permanently removes the key along with the data and
+history of all of its versions.
+
+A list on this path returns the keys stored under the given prefix.
+`
@@ -0,0 +1,172 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// jwsHeader is the protected header of the flattened JWS objects sent by
+// ACME clients, as described in RFC 8555 section 6.2
+type jwsHeader struct {
+	Algorithm string          `json:"alg"`
+	Nonce     string          `json:"nonce"`
+	URL       string          `json:"url"`
+	JWK       json.RawMessage `json:"jwk"`
+	KeyID     string          `json:"kid"`
+}
+
+// jsonWebKey is the public part of an RSA or EC JSON Web Key (RFC 7517)
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+}
+
+// parseJWSHeader decodes the protected header of a JWS
+func parseJWSHeader(protected string) (*jwsHeader, error) {
+	headerBytes, err := base64.RawURLEncoding.DecodeString(protected)
+	if err != nil {
+		return nil, fmt.Errorf("protected header is not valid base64url: %s", err)
+	}
+
+	var header jwsHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("protected header is not valid JSON: %s", err)
+	}
+
+	return &header, nil
+}
+
+// parseJWK decodes a JSON Web Key, returning the key along with its
+// RFC 7638 thumbprint
+func parseJWK(raw []byte) (crypto.PublicKey, string, error) {
+	var jwk jsonWebKey
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return nil, "", fmt.Errorf("key is not valid JSON: %s", err)
+	}
+
+	var pub crypto.PublicKey
+	var canonical string
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, "", err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, "", err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 || e.Int64() < 3 {
+			return nil, "", fmt.Errorf("invalid RSA public exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, "", fmt.Errorf("RSA keys < 2048 bits are unsafe and not supported")
+		}
+		pub = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, "", fmt.Errorf("unsupported curve %q", jwk.Curve)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, "", err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, "", err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, "", fmt.Errorf("EC point is not on the curve")
+		}
+		pub = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Curve, jwk.X, jwk.Y)
+
+	default:
+		return nil, "", fmt.Errorf("unsupported key type %q", jwk.KeyType)
+	}
+
+	thumbprint := sha256.Sum256([]byte(canonical))
+	return pub, base64.RawURLEncoding.EncodeToString(thumbprint[:]), nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	if value == "" {
+		return nil, fmt.Errorf("missing key parameter")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("key parameter is not valid base64url: %s", err)
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// verifyJWS checks the signature of a flattened JWS with the given key
+func verifyJWS(pub crypto.PublicKey, alg, protected, payload, signature string) error {
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("signature is not valid base64url: %s", err)
+	}
+	signingInput := []byte(protected + "." + payload)
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return fmt.Errorf("algorithm %q does not match an RSA key", alg)
+		}
+		digest := sha256.Sum256(signingInput)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+
+	case *ecdsa.PublicKey:
+		var digest []byte
+		switch {
+		case alg == "ES256" && key.Curve == elliptic.P256():
+			sum := sha256.Sum256(signingInput)
+			digest = sum[:]
+		case alg == "ES384" && key.Curve == elliptic.P384():
+			sum := sha512.Sum384(signingInput)
+			digest = sum[:]
+		case alg == "ES512" && key.Curve == elliptic.P521():
+			sum := sha512.Sum512(signingInput)
+			digest = sum[:]
+		default:
+			return fmt.Errorf("algorithm %q does not match the EC key", alg)
+		}
+
+		// JWS ECDSA signatures are the fixed-size concatenation of R and S
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported key type")
+	}
+}
@@ -0,0 +1,357 @@
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	acmeNonceTTL         = 15 * time.Minute
+	acmeMaxNonces        = 10000
+	acmeAuthorizationTTL = 24 * time.Hour
+
+	acmeStatusPending     = "pending"
+	acmeStatusReady       = "ready"
+	acmeStatusValid       = "valid"
+	acmeStatusInvalid     = "invalid"
+	acmeStatusDeactivated = "deactivated"
+
+	acmeChallengeHTTP01 = "http-01"
+	acmeChallengeDNS01  = "dns-01"
+
+	acmeErrorPrefix = "urn:ietf:params:acme:error:"
+)
+
+type acmeAccount struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Contact    []string        `json:"contact"`
+	Key        json.RawMessage `json:"key"`
+	Thumbprint string          `json:"thumbprint"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	ID                string           `json:"id"`
+	AccountID         string           `json:"account_id"`
+	Role              string           `json:"role"`
+	Status            string           `json:"status"`
+	Expires           time.Time        `json:"expires"`
+	Identifiers       []acmeIdentifier `json:"identifiers"`
+	AuthorizationIDs  []string         `json:"authorization_ids"`
+	CertificateSerial string           `json:"certificate_serial"`
+}
+
+type acmeAuthorization struct {
+	ID         string           `json:"id"`
+	AccountID  string           `json:"account_id"`
+	Identifier acmeIdentifier   `json:"identifier"`
+	Wildcard   bool             `json:"wildcard"`
+	Status     string           `json:"status"`
+	Expires    time.Time        `json:"expires"`
+	Challenges []*acmeChallenge `json:"challenges"`
+}
+
+type acmeChallenge struct {
+	Type      string       `json:"type"`
+	Token     string       `json:"token"`
+	Status    string       `json:"status"`
+	Validated time.Time    `json:"validated"`
+	Error     *acmeProblem `json:"error"`
+}
+
+// acmeProblem is an RFC 7807 problem document as used by ACME
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+func (p *acmeProblem) Error() string {
+	return p.Detail
+}
+
+func newACMEProblem(status int, errType, format string, args ...interface{}) *acmeProblem {
+	return &acmeProblem{
+		Type:   acmeErrorPrefix + errType,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+// acmeResponse returns a raw response carrying the JSON encoding of body
+func acmeResponse(status int, contentType string, body interface{}) (*logical.Response, error) {
+	var raw []byte
+	switch v := body.(type) {
+	case nil:
+		raw = []byte{}
+	case []byte:
+		raw = v
+	default:
+		var err error
+		raw, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: contentType,
+			logical.HTTPRawBody:     raw,
+			logical.HTTPStatusCode:  status,
+			logical.HTTPRawHeaders:  map[string][]string{},
+		},
+	}, nil
+}
+
+func acmeJSONResponse(status int, body interface{}) (*logical.Response, error) {
+	return acmeResponse(status, "application/json", body)
+}
+
+func acmeProblemResponse(problem *acmeProblem) (*logical.Response, error) {
+	return acmeResponse(problem.Status, "application/problem+json", problem)
+}
+
+// setACMEHeader adds a header to a response built by acmeResponse
+func setACMEHeader(resp *logical.Response, key, value string) {
+	headers := resp.Data[logical.HTTPRawHeaders].(map[string][]string)
+	headers[key] = append(headers[key], value)
+}
+
+// newACMEToken returns a random base64url string, used for nonces and
+// challenge tokens
+func newACMEToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// newACMENonce issues a nonce that can be used once in a JWS header. As
+// nonces are issued to anonymous clients, at most acmeMaxNonces are kept;
+// past that, an outstanding nonce is dropped and its client has to retry
+// with a new one.
+func (b *backend) newACMENonce() (string, error) {
+	nonce, err := newACMEToken()
+	if err != nil {
+		return "", err
+	}
+
+	b.acmeNonceLock.Lock()
+	defer b.acmeNonceLock.Unlock()
+
+	if len(b.acmeNonces) >= acmeMaxNonces {
+		for n := range b.acmeNonces {
+			delete(b.acmeNonces, n)
+			break
+		}
+	}
+	b.acmeNonces[nonce] = time.Now().Add(acmeNonceTTL)
+
+	return nonce, nil
+}
+
+// tidyACMENonces removes the expired nonces
+func (b *backend) tidyACMENonces() {
+	b.acmeNonceLock.Lock()
+	defer b.acmeNonceLock.Unlock()
+
+	now := time.Now()
+	for n, expiry := range b.acmeNonces {
+		if now.After(expiry) {
+			delete(b.acmeNonces, n)
+		}
+	}
+}
+
+// consumeACMENonce returns whether the nonce was issued by this backend and
+// not used yet
+func (b *backend) consumeACMENonce(nonce string) bool {
+	b.acmeNonceLock.Lock()
+	defer b.acmeNonceLock.Unlock()
+
+	expiry, ok := b.acmeNonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(b.acmeNonces, nonce)
+
+	return time.Now().Before(expiry)
+}
+
+func getACMEEntry(s logical.Storage, key string, out interface{}) (bool, error) {
+	entry, err := s.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if entry == nil {
+		return false, nil
+	}
+
+	if err := entry.DecodeJSON(out); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func putACMEEntry(s logical.Storage, key string, value interface{}) error {
+	entry, err := logical.StorageEntryJSON(key, value)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(entry)
+}
+
+func acmeAccountKey(id string) string {
+	return "acme/accounts/" + id
+}
+
+func acmeAccountThumbprintKey(thumbprint string) string {
+	return "acme/account-keys/" + thumbprint
+}
+
+// Orders and authorizations are stored under the account that created
+// them, so that they can only be reached by their owner
+func acmeOrderKey(accountID, id string) string {
+	return "acme/orders/" + accountID + "/" + id
+}
+
+func acmeAuthorizationKey(accountID, id string) string {
+	return "acme/authorizations/" + accountID + "/" + id
+}
+
+func getACMEAccount(s logical.Storage, id string) (*acmeAccount, error) {
+	var account acmeAccount
+	found, err := getACMEEntry(s, acmeAccountKey(id), &account)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func getACMEOrder(s logical.Storage, accountID, id string) (*acmeOrder, error) {
+	var order acmeOrder
+	found, err := getACMEEntry(s, acmeOrderKey(accountID, id), &order)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func getACMEAuthorization(s logical.Storage, accountID, id string) (*acmeAuthorization, error) {
+	var authz acmeAuthorization
+	found, err := getACMEEntry(s, acmeAuthorizationKey(accountID, id), &authz)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	if authz.Status == acmeStatusPending && time.Now().After(authz.Expires) {
+		authz.Status = acmeStatusInvalid
+	}
+
+	return &authz, nil
+}
+
+// updateACMEOrderStatus derives the status of a pending order from the
+// status of its authorizations
+func updateACMEOrderStatus(s logical.Storage, order *acmeOrder) error {
+	if order.Status != acmeStatusPending {
+		return nil
+	}
+
+	if time.Now().After(order.Expires) {
+		order.Status = acmeStatusInvalid
+		return putACMEEntry(s, acmeOrderKey(order.AccountID, order.ID), order)
+	}
+
+	ready := true
+	for _, id := range order.AuthorizationIDs {
+		authz, err := getACMEAuthorization(s, order.AccountID, id)
+		if err != nil {
+			return err
+		}
+		if authz == nil || authz.Status == acmeStatusInvalid || authz.Status == acmeStatusDeactivated {
+			order.Status = acmeStatusInvalid
+			return putACMEEntry(s, acmeOrderKey(order.AccountID, order.ID), order)
+		}
+		if authz.Status != acmeStatusValid {
+			ready = false
+		}
+	}
+	if !ready {
+		return nil
+	}
+
+	order.Status = acmeStatusReady
+	return putACMEEntry(s, acmeOrderKey(order.AccountID, order.ID), order)
+}
+
+// keyAuthorization builds the RFC 8555 section 8.1 key authorization of a
+// challenge token for an account key
+func keyAuthorization(token, thumbprint string) string {
+	return token + "." + thumbprint
+}
+
+// validateHTTP01 fetches the key authorization from the well-known path on
+// port 80 of the domain
+func (b *backend) validateHTTP01(domain, token, keyAuth string) *acmeProblem {
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", domain, token)
+	resp, err := b.acmeHTTPClient.Get(url)
+	if err != nil {
+		return newACMEProblem(http.StatusBadRequest, "connection", "error fetching %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newACMEProblem(http.StatusForbidden, "unauthorized", "fetching %s returned status %d", url, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return newACMEProblem(http.StatusBadRequest, "connection", "error reading %s: %s", url, err)
+	}
+	if strings.TrimSpace(string(body)) != keyAuth {
+		return newACMEProblem(http.StatusForbidden, "incorrectResponse", "the key authorization served at %s is incorrect", url)
+	}
+
+	return nil
+}
+
+// validateDNS01 looks for the digest of the key authorization in the TXT
+// records of the _acme-challenge subdomain
+func (b *backend) validateDNS01(domain, keyAuth string) *acmeProblem {
+	name := "_acme-challenge." + domain
+	records, err := b.acmeLookupTXT(name)
+	if err != nil {
+		return newACMEProblem(http.StatusBadRequest, "dns", "error looking up TXT records for %s: %s", name, err)
+	}
+
+	digest := sha256.Sum256([]byte(keyAuth))
+	expected := base64.RawURLEncoding.EncodeToString(digest[:])
+	for _, record := range records {
+		if strings.TrimSpace(record) == expected {
+			return nil
+		}
+	}
+
+	return newACMEProblem(http.StatusForbidden, "incorrectResponse", "no TXT record for %s matches the key authorization", name)
+}
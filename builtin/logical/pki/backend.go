@@ -1,10 +1,13 @@
 package pki
 
 import (
+	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -27,6 +30,16 @@ func Backend() *backend {
 				"ca",
 				"crl/pem",
 				"crl",
+				"ocsp",
+				"ocsp/*",
+				"acme/*",
+			},
+
+			// ACME clients fetch nonces with HEAD requests; the nonce paths
+			// of the roles can not be listed, and the only other ACME reads
+			// are the directories
+			Head: []string{
+				"acme/*",
 			},
 		},
 
@@ -40,6 +53,8 @@ func Backend() *backend {
 			pathConfigCA(&b),
 			pathConfigCRL(&b),
 			pathConfigURLs(&b),
+			pathConfigOCSP(&b),
+			pathConfigACME(&b),
 			pathSignVerbatim(&b),
 			pathSign(&b),
 			pathIssue(&b),
@@ -49,6 +64,8 @@ func Backend() *backend {
 			pathFetchCRLViaCertPath(&b),
 			pathFetchValid(&b),
 			pathFetchListCerts(&b),
+			pathOCSP(&b),
+			pathOCSPGet(&b),
 			pathRevoke(&b),
 			pathTidy(&b),
 		},
@@ -56,9 +73,17 @@ func Backend() *backend {
 		Secrets: []*framework.Secret{
 			secretCerts(&b),
 		},
+
+		PeriodicFunc: b.periodicFunc,
 	}
 
+	b.Backend.Paths = append(b.Backend.Paths, pathsACME(&b)...)
+
 	b.crlLifetime = time.Hour * 72
+	b.acmeNonces = make(map[string]time.Time)
+	b.acmeHTTPClient = cleanhttp.DefaultClient()
+	b.acmeHTTPClient.Timeout = 10 * time.Second
+	b.acmeLookupTXT = net.LookupTXT
 
 	return &b
 }
@@ -68,6 +93,21 @@ type backend struct {
 
 	crlLifetime       time.Duration
 	revokeStorageLock sync.RWMutex
+
+	acmeLock      sync.Mutex
+	acmeNonceLock sync.Mutex
+	acmeNonces    map[string]time.Time
+
+	// Used to validate ACME challenges; replaced in tests
+	acmeHTTPClient *http.Client
+	acmeLookupTXT  func(name string) ([]string, error)
+}
+
+// periodicFunc is invoked once in a minute by the RollbackManager. It drops
+// the expired ACME nonces, rather than sweeping them on every response.
+func (b *backend) periodicFunc(req *logical.Request) error {
+	b.tidyACMENonces()
+	return nil
 }
 
 const backendHelp = `
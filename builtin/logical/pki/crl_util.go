@@ -165,17 +165,9 @@ func buildCRL(b *backend, req *logical.Request) error {
 		return certutil.InternalError{Err: fmt.Sprintf("Error fetching CA certificate: %s", caErr)}
 	}
 
-	crlLifetime := b.crlLifetime
-	crlInfo, err := b.CRL(req.Storage)
+	crlLifetime, err := b.getCRLLifetime(req)
 	if err != nil {
-		return certutil.InternalError{Err: fmt.Sprintf("Error fetching CRL config information: %s", err)}
-	}
-	if crlInfo != nil {
-		crlDur, err := time.ParseDuration(crlInfo.Expiry)
-		if err != nil {
-			return certutil.InternalError{Err: fmt.Sprintf("Error parsing CRL duration of %s", crlInfo.Expiry)}
-		}
-		crlLifetime = crlDur
+		return err
 	}
 
 	crlBytes, err := signingBundle.Certificate.CreateCRL(rand.Reader, signingBundle.PrivateKey, revokedCerts, time.Now(), time.Now().Add(crlLifetime))
@@ -193,3 +185,21 @@ func buildCRL(b *backend, req *logical.Request) error {
 
 	return nil
 }
+
+// getCRLLifetime returns the lifetime of the CRLs, which is configured
+// through "config/crl"
+func (b *backend) getCRLLifetime(req *logical.Request) (time.Duration, error) {
+	crlInfo, err := b.CRL(req.Storage)
+	if err != nil {
+		return 0, certutil.InternalError{Err: fmt.Sprintf("Error fetching CRL config information: %s", err)}
+	}
+	if crlInfo == nil {
+		return b.crlLifetime, nil
+	}
+
+	crlDur, err := time.ParseDuration(crlInfo.Expiry)
+	if err != nil {
+		return 0, certutil.InternalError{Err: fmt.Sprintf("Error parsing CRL duration of %s", crlInfo.Expiry)}
+	}
+	return crlDur, nil
+}
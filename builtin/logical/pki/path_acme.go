@@ -0,0 +1,1008 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// All ACME endpoints are available under "acme/", issuing with the default
+// role, and under "acme/roles/<role>/", issuing with the named role
+var acmePrefixPattern = "acme/(roles/" + framework.GenericNameRegex("role") + "/)?"
+
+func acmeFields(fields map[string]*framework.FieldSchema) map[string]*framework.FieldSchema {
+	fields["role"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `The role used to issue certificates for orders`,
+	}
+	fields["protected"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `The protected header of the JWS`,
+	}
+	fields["payload"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `The payload of the JWS`,
+	}
+	fields["signature"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `The signature of the JWS`,
+	}
+
+	return fields
+}
+
+func pathsACME(b *backend) []*framework.Path {
+	idField := map[string]*framework.FieldSchema{
+		"id": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: `The identifier of the ACME object`,
+		},
+	}
+
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: acmePrefixPattern + "directory",
+			Fields:  acmeFields(map[string]*framework.FieldSchema{}),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.acmeOperation(b.pathACMEDirectory),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		&framework.Path{
+			Pattern: acmePrefixPattern + "new-nonce",
+			Fields:  acmeFields(map[string]*framework.FieldSchema{}),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.acmeOperation(b.pathACMENewNonce),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		&framework.Path{
+			Pattern: acmePrefixPattern + "new-account",
+			Fields:  acmeFields(map[string]*framework.FieldSchema{}),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeJWSOperation(true, b.pathACMENewAccount),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		&framework.Path{
+			Pattern: acmePrefixPattern + "account/(?P<id>[\\w-]+)",
+			Fields:  acmeFields(idField),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeJWSOperation(false, b.pathACMEAccount),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		&framework.Path{
+			Pattern: acmePrefixPattern + "account/(?P<id>[\\w-]+)/orders",
+			Fields:  acmeFields(idField),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeJWSOperation(false, b.pathACMEAccountOrders),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		&framework.Path{
+			Pattern: acmePrefixPattern + "new-order",
+			Fields:  acmeFields(map[string]*framework.FieldSchema{}),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeJWSOperation(false, b.pathACMENewOrder),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		&framework.Path{
+			Pattern: acmePrefixPattern + "order/(?P<id>[\\w-]+)",
+			Fields:  acmeFields(idField),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeJWSOperation(false, b.pathACMEOrder),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		&framework.Path{
+			Pattern: acmePrefixPattern + "order/(?P<id>[\\w-]+)/finalize",
+			Fields:  acmeFields(idField),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeJWSOperation(false, b.pathACMEFinalize),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		&framework.Path{
+			Pattern: acmePrefixPattern + "order/(?P<id>[\\w-]+)/cert",
+			Fields:  acmeFields(idField),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeJWSOperation(false, b.pathACMECertificate),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		&framework.Path{
+			Pattern: acmePrefixPattern + "authz/(?P<id>[\\w-]+)",
+			Fields:  acmeFields(idField),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeJWSOperation(false, b.pathACMEAuthorization),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		&framework.Path{
+			Pattern: acmePrefixPattern + "challenge/(?P<id>[\\w-]+)/(?P<type>http-01|dns-01)",
+			Fields: acmeFields(map[string]*framework.FieldSchema{
+				"id": idField["id"],
+				"type": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: `The type of the challenge`,
+				},
+			}),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeJWSOperation(false, b.pathACMEChallenge),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+	}
+}
+
+// acmeContext holds the state shared by all ACME operations of a request
+type acmeContext struct {
+	config *acmeConfig
+	role   string
+	prefix string
+}
+
+func (ac *acmeContext) url(p string) string {
+	return ac.prefix + "/" + p
+}
+
+func (ac *acmeContext) accountURL(id string) string {
+	return ac.url("account/" + id)
+}
+
+func (ac *acmeContext) orderURL(id string) string {
+	return ac.url("order/" + id)
+}
+
+func (ac *acmeContext) authorizationURL(id string) string {
+	return ac.url("authz/" + id)
+}
+
+func (ac *acmeContext) challengeURL(authzID, challengeType string) string {
+	return ac.url("challenge/" + authzID + "/" + challengeType)
+}
+
+// acmeMessage is a verified JWS request
+type acmeMessage struct {
+	Payload []byte
+
+	// The key that signed the request
+	Key        json.RawMessage
+	Thumbprint string
+
+	// Set for requests signed by the key of an account
+	Account *acmeAccount
+}
+
+type acmeOperationFunc func(*logical.Request, *framework.FieldData, *acmeContext) (*logical.Response, error)
+type acmeJWSOperationFunc func(*logical.Request, *framework.FieldData, *acmeContext, *acmeMessage) (*logical.Response, error)
+
+// acmeOperation wraps an ACME endpoint, turning problems into RFC 7807
+// responses and adding the headers required on every response
+func (b *backend) acmeOperation(f acmeOperationFunc) framework.OperationFunc {
+	return func(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := getACMEConfig(req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if config == nil || !config.Enabled {
+			return acmeProblemResponse(newACMEProblem(http.StatusNotFound, "malformed", "ACME is not enabled on this mount"))
+		}
+
+		ac := &acmeContext{
+			config: config,
+			role:   config.DefaultRole,
+			prefix: config.BaseURL + "/acme",
+		}
+		if roleName := data.Get("role").(string); roleName != "" {
+			if !config.roleAllowed(roleName) {
+				return acmeProblemResponse(newACMEProblem(http.StatusForbidden, "unauthorized", "role %s is not allowed for ACME", roleName))
+			}
+			role, err := b.getRole(req.Storage, roleName)
+			if err != nil {
+				return nil, err
+			}
+			if role == nil {
+				return acmeProblemResponse(newACMEProblem(http.StatusNotFound, "malformed", "unknown role: %s", roleName))
+			}
+			ac.role = roleName
+			ac.prefix += "/roles/" + roleName
+		}
+
+		resp, err := f(req, data, ac)
+		if problem, ok := err.(*acmeProblem); ok {
+			resp, err = acmeProblemResponse(problem)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		nonce, err := b.newACMENonce()
+		if err != nil {
+			return nil, err
+		}
+		setACMEHeader(resp, "Replay-Nonce", nonce)
+		setACMEHeader(resp, "Cache-Control", "no-store")
+		setACMEHeader(resp, "Link", fmt.Sprintf(`<%s>;rel="index"`, ac.url("directory")))
+
+		return resp, nil
+	}
+}
+
+// acmeJWSOperation wraps an ACME endpoint that requires a JWS signed either
+// by an embedded key (useJWK) or by the key of an existing account
+func (b *backend) acmeJWSOperation(useJWK bool, f acmeJWSOperationFunc) framework.OperationFunc {
+	return b.acmeOperation(func(req *logical.Request, data *framework.FieldData, ac *acmeContext) (*logical.Response, error) {
+		msg, err := b.verifyACMEMessage(req, data, ac, useJWK)
+		if err != nil {
+			return nil, err
+		}
+		return f(req, data, ac, msg)
+	})
+}
+
+func (b *backend) verifyACMEMessage(req *logical.Request, data *framework.FieldData, ac *acmeContext, useJWK bool) (*acmeMessage, error) {
+	protected := data.Get("protected").(string)
+	payload := data.Get("payload").(string)
+	signature := data.Get("signature").(string)
+	if protected == "" || signature == "" {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "request must be a flattened JWS")
+	}
+
+	header, err := parseJWSHeader(protected)
+	if err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "%s", err)
+	}
+	if header.URL != ac.config.BaseURL+"/"+req.Path {
+		return nil, newACMEProblem(http.StatusUnauthorized, "unauthorized", "url header does not match the request")
+	}
+	if !b.consumeACMENonce(header.Nonce) {
+		return nil, newACMEProblem(http.StatusBadRequest, "badNonce", "invalid or reused nonce")
+	}
+
+	msg := &acmeMessage{}
+	var keyBytes []byte
+	switch {
+	case useJWK:
+		if len(header.JWK) == 0 || header.KeyID != "" {
+			return nil, newACMEProblem(http.StatusBadRequest, "malformed", "request must be signed with an embedded jwk")
+		}
+		keyBytes = header.JWK
+
+	default:
+		if header.KeyID == "" || len(header.JWK) != 0 {
+			return nil, newACMEProblem(http.StatusBadRequest, "malformed", "request must be signed with the kid of an account")
+		}
+		// Account URLs may have been handed out under any of the prefixes
+		accountID := path.Base(header.KeyID)
+		if !strings.HasPrefix(header.KeyID, ac.config.BaseURL+"/acme/") ||
+			!strings.HasSuffix(header.KeyID, "/account/"+accountID) {
+			return nil, newACMEProblem(http.StatusBadRequest, "accountDoesNotExist", "unknown account")
+		}
+		account, err := getACMEAccount(req.Storage, accountID)
+		if err != nil {
+			return nil, err
+		}
+		if account == nil {
+			return nil, newACMEProblem(http.StatusBadRequest, "accountDoesNotExist", "unknown account")
+		}
+		if account.Status != acmeStatusValid {
+			return nil, newACMEProblem(http.StatusUnauthorized, "unauthorized", "account is %s", account.Status)
+		}
+		msg.Account = account
+		keyBytes = account.Key
+	}
+
+	var pub crypto.PublicKey
+	pub, msg.Thumbprint, err = parseJWK(keyBytes)
+	if err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "badPublicKey", "%s", err)
+	}
+	if err := verifyJWS(pub, header.Algorithm, protected, payload, signature); err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "JWS verification error: %s", err)
+	}
+	msg.Key = keyBytes
+
+	msg.Payload, err = base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "payload is not valid base64url: %s", err)
+	}
+
+	return msg, nil
+}
+
+// decodePayload decodes the JSON payload of a message; POST-as-GET
+// requests have an empty payload and leave out untouched
+func (msg *acmeMessage) decodePayload(out interface{}) error {
+	if len(msg.Payload) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(msg.Payload, out); err != nil {
+		return newACMEProblem(http.StatusBadRequest, "malformed", "payload is not valid JSON: %s", err)
+	}
+	return nil
+}
+
+func (b *backend) pathACMEDirectory(req *logical.Request, data *framework.FieldData, ac *acmeContext) (*logical.Response, error) {
+	return acmeJSONResponse(http.StatusOK, map[string]interface{}{
+		"newNonce":   ac.url("new-nonce"),
+		"newAccount": ac.url("new-account"),
+		"newOrder":   ac.url("new-order"),
+	})
+}
+
+func (b *backend) pathACMENewNonce(req *logical.Request, data *framework.FieldData, ac *acmeContext) (*logical.Response, error) {
+	return acmeResponse(http.StatusOK, "text/plain", nil)
+}
+
+func (b *backend) pathACMENewAccount(req *logical.Request, data *framework.FieldData, ac *acmeContext, msg *acmeMessage) (*logical.Response, error) {
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := msg.decodePayload(&payload); err != nil {
+		return nil, err
+	}
+
+	b.acmeLock.Lock()
+	defer b.acmeLock.Unlock()
+
+	// Accounts are identified by their key
+	entry, err := req.Storage.Get(acmeAccountThumbprintKey(msg.Thumbprint))
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		account, err := getACMEAccount(req.Storage, string(entry.Value))
+		if err != nil {
+			return nil, err
+		}
+		if account != nil {
+			return ac.accountResponse(http.StatusOK, account)
+		}
+	}
+	if payload.OnlyReturnExisting {
+		return nil, newACMEProblem(http.StatusBadRequest, "accountDoesNotExist", "no account exists with this key")
+	}
+	if err := validateACMEContacts(payload.Contact); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	account := &acmeAccount{
+		ID:         id,
+		Status:     acmeStatusValid,
+		Contact:    payload.Contact,
+		Key:        msg.Key,
+		Thumbprint: msg.Thumbprint,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := putACMEEntry(req.Storage, acmeAccountKey(id), account); err != nil {
+		return nil, err
+	}
+	err = req.Storage.Put(&logical.StorageEntry{
+		Key:   acmeAccountThumbprintKey(msg.Thumbprint),
+		Value: []byte(id),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ac.accountResponse(http.StatusCreated, account)
+}
+
+func validateACMEContacts(contacts []string) error {
+	for _, contact := range contacts {
+		if !strings.HasPrefix(contact, "mailto:") {
+			return newACMEProblem(http.StatusBadRequest, "unsupportedContact", "only mailto contacts are supported: %s", contact)
+		}
+	}
+	return nil
+}
+
+func (b *backend) pathACMEAccount(req *logical.Request, data *framework.FieldData, ac *acmeContext, msg *acmeMessage) (*logical.Response, error) {
+	if data.Get("id").(string) != msg.Account.ID {
+		return nil, newACMEProblem(http.StatusForbidden, "unauthorized", "the request is not signed by this account")
+	}
+
+	var payload struct {
+		Contact *[]string `json:"contact"`
+		Status  string    `json:"status"`
+	}
+	if err := msg.decodePayload(&payload); err != nil {
+		return nil, err
+	}
+	if payload.Contact == nil && payload.Status == "" {
+		return ac.accountResponse(http.StatusOK, msg.Account)
+	}
+
+	b.acmeLock.Lock()
+	defer b.acmeLock.Unlock()
+
+	account := msg.Account
+	if payload.Contact != nil {
+		if err := validateACMEContacts(*payload.Contact); err != nil {
+			return nil, err
+		}
+		account.Contact = *payload.Contact
+	}
+	switch payload.Status {
+	case "":
+	case acmeStatusDeactivated:
+		account.Status = acmeStatusDeactivated
+	default:
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "invalid account status %q", payload.Status)
+	}
+
+	if err := putACMEEntry(req.Storage, acmeAccountKey(account.ID), account); err != nil {
+		return nil, err
+	}
+
+	return ac.accountResponse(http.StatusOK, account)
+}
+
+func (b *backend) pathACMEAccountOrders(req *logical.Request, data *framework.FieldData, ac *acmeContext, msg *acmeMessage) (*logical.Response, error) {
+	if data.Get("id").(string) != msg.Account.ID {
+		return nil, newACMEProblem(http.StatusForbidden, "unauthorized", "the request is not signed by this account")
+	}
+
+	ids, err := req.Storage.List(acmeOrderKey(msg.Account.ID, ""))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+
+	orders := make([]string, 0, len(ids))
+	for _, id := range ids {
+		orders = append(orders, ac.orderURL(id))
+	}
+
+	return acmeJSONResponse(http.StatusOK, map[string]interface{}{
+		"orders": orders,
+	})
+}
+
+func (b *backend) pathACMENewOrder(req *logical.Request, data *framework.FieldData, ac *acmeContext, msg *acmeMessage) (*logical.Response, error) {
+	if ac.role == "" {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "no role is configured for this directory")
+	}
+	role, err := b.getRole(req.Storage, ac.role)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "unknown role: %s", ac.role)
+	}
+
+	var payload struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+		NotBefore   string           `json:"notBefore"`
+		NotAfter    string           `json:"notAfter"`
+	}
+	if err := msg.decodePayload(&payload); err != nil {
+		return nil, err
+	}
+	if len(payload.Identifiers) == 0 {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "no identifiers given")
+	}
+	if payload.NotBefore != "" || payload.NotAfter != "" {
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "notBefore and notAfter are not supported; validity is set by the role")
+	}
+
+	seen := map[string]bool{}
+	var identifiers []acmeIdentifier
+	for _, identifier := range payload.Identifiers {
+		if identifier.Type != "dns" {
+			return nil, newACMEProblem(http.StatusBadRequest, "unsupportedIdentifier", "unsupported identifier type %q", identifier.Type)
+		}
+		value := strings.ToLower(strings.TrimSpace(identifier.Value))
+		if value == "" || strings.Contains(value, "@") {
+			return nil, newACMEProblem(http.StatusBadRequest, "rejectedIdentifier", "invalid identifier %q", identifier.Value)
+		}
+		badName, err := validateNames(req, []string{value}, role)
+		if err != nil {
+			return nil, err
+		}
+		if badName != "" {
+			return nil, newACMEProblem(http.StatusBadRequest, "rejectedIdentifier", "name %s not allowed by this role", badName)
+		}
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		identifiers = append(identifiers, acmeIdentifier{Type: "dns", Value: value})
+	}
+
+	orderID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(acmeAuthorizationTTL).UTC()
+	order := &acmeOrder{
+		ID:          orderID,
+		AccountID:   msg.Account.ID,
+		Role:        ac.role,
+		Status:      acmeStatusPending,
+		Expires:     expires,
+		Identifiers: identifiers,
+	}
+
+	for _, identifier := range identifiers {
+		authz, err := newACMEAuthorization(msg.Account.ID, identifier, expires)
+		if err != nil {
+			return nil, err
+		}
+		if err := putACMEEntry(req.Storage, acmeAuthorizationKey(authz.AccountID, authz.ID), authz); err != nil {
+			return nil, err
+		}
+		order.AuthorizationIDs = append(order.AuthorizationIDs, authz.ID)
+	}
+
+	if err := putACMEEntry(req.Storage, acmeOrderKey(order.AccountID, order.ID), order); err != nil {
+		return nil, err
+	}
+
+	resp, err := acmeJSONResponse(http.StatusCreated, ac.orderJSON(order))
+	if err != nil {
+		return nil, err
+	}
+	setACMEHeader(resp, "Location", ac.orderURL(order.ID))
+	return resp, nil
+}
+
+func newACMEAuthorization(accountID string, identifier acmeIdentifier, expires time.Time) (*acmeAuthorization, error) {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	authz := &acmeAuthorization{
+		ID:         id,
+		AccountID:  accountID,
+		Identifier: identifier,
+		Status:     acmeStatusPending,
+		Expires:    expires,
+	}
+
+	// Wildcard names can only be validated through DNS
+	challengeTypes := []string{acmeChallengeHTTP01, acmeChallengeDNS01}
+	if strings.HasPrefix(identifier.Value, "*.") {
+		authz.Identifier.Value = identifier.Value[2:]
+		authz.Wildcard = true
+		challengeTypes = []string{acmeChallengeDNS01}
+	}
+
+	for _, challengeType := range challengeTypes {
+		token, err := newACMEToken()
+		if err != nil {
+			return nil, err
+		}
+		authz.Challenges = append(authz.Challenges, &acmeChallenge{
+			Type:   challengeType,
+			Token:  token,
+			Status: acmeStatusPending,
+		})
+	}
+
+	return authz, nil
+}
+
+func (b *backend) getACMEOrder(req *logical.Request, data *framework.FieldData, msg *acmeMessage) (*acmeOrder, error) {
+	order, err := getACMEOrder(req.Storage, msg.Account.ID, data.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, newACMEProblem(http.StatusNotFound, "malformed", "order not found")
+	}
+	if err := updateACMEOrderStatus(req.Storage, order); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func (b *backend) pathACMEOrder(req *logical.Request, data *framework.FieldData, ac *acmeContext, msg *acmeMessage) (*logical.Response, error) {
+	b.acmeLock.Lock()
+	defer b.acmeLock.Unlock()
+
+	order, err := b.getACMEOrder(req, data, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return acmeJSONResponse(http.StatusOK, ac.orderJSON(order))
+}
+
+func (b *backend) pathACMEFinalize(req *logical.Request, data *framework.FieldData, ac *acmeContext, msg *acmeMessage) (*logical.Response, error) {
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := msg.decodePayload(&payload); err != nil {
+		return nil, err
+	}
+
+	b.acmeLock.Lock()
+	defer b.acmeLock.Unlock()
+
+	order, err := b.getACMEOrder(req, data, msg)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != acmeStatusReady {
+		return nil, newACMEProblem(http.StatusForbidden, "orderNotReady", "order is %s", order.Status)
+	}
+
+	csrBytes, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "badCSR", "csr is not valid base64url: %s", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "badCSR", "csr could not be parsed: %s", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, newACMEProblem(http.StatusBadRequest, "badCSR", "invalid csr signature: %s", err)
+	}
+
+	// The CSR must request exactly the identifiers of the order
+	if len(csr.EmailAddresses) != 0 || len(csr.IPAddresses) != 0 {
+		return nil, newACMEProblem(http.StatusBadRequest, "badCSR", "csr may only contain DNS names")
+	}
+	requested := map[string]bool{}
+	if csr.Subject.CommonName != "" {
+		requested[strings.ToLower(csr.Subject.CommonName)] = true
+	}
+	for _, name := range csr.DNSNames {
+		requested[strings.ToLower(name)] = true
+	}
+	var names []string
+	for _, identifier := range order.Identifiers {
+		if !requested[identifier.Value] {
+			return nil, newACMEProblem(http.StatusBadRequest, "badCSR", "csr does not include %s", identifier.Value)
+		}
+		delete(requested, identifier.Value)
+		names = append(names, identifier.Value)
+	}
+	if len(requested) != 0 {
+		return nil, newACMEProblem(http.StatusBadRequest, "badCSR", "csr requests names that are not part of the order")
+	}
+
+	role, err := b.getRole(req.Storage, order.Role)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, newACMEProblem(http.StatusInternalServerError, "serverInternal", "role %s no longer exists", order.Role)
+	}
+
+	signingBundle, err := fetchCAInfo(req)
+	if err != nil {
+		return nil, err
+	}
+
+	commonName := strings.ToLower(csr.Subject.CommonName)
+	if commonName == "" {
+		commonName = names[0]
+	}
+	signData := &framework.FieldData{
+		Raw: map[string]interface{}{
+			"csr": string(pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE REQUEST",
+				Bytes: csrBytes,
+			})),
+			"common_name": commonName,
+			"alt_names":   strings.Join(names, ","),
+
+			// The common name is one of the names of the order, which
+			// are all given as alternative names
+			"exclude_cn_from_sans": true,
+		},
+		Schema: addNonCACommonFields(map[string]*framework.FieldSchema{
+			"csr": &framework.FieldSchema{
+				Type: framework.TypeString,
+			},
+		}),
+	}
+	parsedBundle, err := signCert(b, role, signingBundle, false, false, req, signData)
+	if err != nil {
+		switch err.(type) {
+		case certutil.UserError:
+			return nil, newACMEProblem(http.StatusBadRequest, "badCSR", "%s", err)
+		default:
+			return nil, err
+		}
+	}
+
+	cb, err := parsedBundle.ToCertBundle()
+	if err != nil {
+		return nil, fmt.Errorf("Error converting raw cert bundle to cert bundle: %s", err)
+	}
+	err = req.Storage.Put(&logical.StorageEntry{
+		Key:   "certs/" + cb.SerialNumber,
+		Value: parsedBundle.CertificateBytes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to store certificate locally")
+	}
+
+	order.Status = acmeStatusValid
+	order.CertificateSerial = cb.SerialNumber
+	if err := putACMEEntry(req.Storage, acmeOrderKey(order.AccountID, order.ID), order); err != nil {
+		return nil, err
+	}
+
+	resp, err := acmeJSONResponse(http.StatusOK, ac.orderJSON(order))
+	if err != nil {
+		return nil, err
+	}
+	setACMEHeader(resp, "Location", ac.orderURL(order.ID))
+	return resp, nil
+}
+
+func (b *backend) pathACMECertificate(req *logical.Request, data *framework.FieldData, ac *acmeContext, msg *acmeMessage) (*logical.Response, error) {
+	order, err := getACMEOrder(req.Storage, msg.Account.ID, data.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if order == nil || order.Status != acmeStatusValid {
+		return nil, newACMEProblem(http.StatusNotFound, "malformed", "certificate not found")
+	}
+
+	certEntry, err := fetchCertBySerial(req, "certs/", order.CertificateSerial)
+	if err != nil {
+		return nil, err
+	}
+	if certEntry == nil {
+		return nil, newACMEProblem(http.StatusNotFound, "malformed", "certificate not found")
+	}
+	caInfo, err := fetchCAInfo(req)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: certEntry.Value,
+	})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: caInfo.CertificateBytes,
+	})...)
+
+	return acmeResponse(http.StatusOK, "application/pem-certificate-chain", chain)
+}
+
+func (b *backend) pathACMEAuthorization(req *logical.Request, data *framework.FieldData, ac *acmeContext, msg *acmeMessage) (*logical.Response, error) {
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if err := msg.decodePayload(&payload); err != nil {
+		return nil, err
+	}
+
+	b.acmeLock.Lock()
+	defer b.acmeLock.Unlock()
+
+	authz, err := getACMEAuthorization(req.Storage, msg.Account.ID, data.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if authz == nil {
+		return nil, newACMEProblem(http.StatusNotFound, "malformed", "authorization not found")
+	}
+
+	switch payload.Status {
+	case "":
+	case acmeStatusDeactivated:
+		if authz.Status != acmeStatusPending && authz.Status != acmeStatusValid {
+			return nil, newACMEProblem(http.StatusBadRequest, "malformed", "authorization is %s", authz.Status)
+		}
+		authz.Status = acmeStatusDeactivated
+		if err := putACMEEntry(req.Storage, acmeAuthorizationKey(authz.AccountID, authz.ID), authz); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, newACMEProblem(http.StatusBadRequest, "malformed", "invalid authorization status %q", payload.Status)
+	}
+
+	return acmeJSONResponse(http.StatusOK, ac.authorizationJSON(authz))
+}
+
+func (b *backend) pathACMEChallenge(req *logical.Request, data *framework.FieldData, ac *acmeContext, msg *acmeMessage) (*logical.Response, error) {
+	authzID := data.Get("id").(string)
+	challengeType := data.Get("type").(string)
+
+	authz, err := getACMEAuthorization(req.Storage, msg.Account.ID, authzID)
+	if err != nil {
+		return nil, err
+	}
+	if authz == nil || authz.challenge(challengeType) == nil {
+		return nil, newACMEProblem(http.StatusNotFound, "malformed", "challenge not found")
+	}
+	challenge := authz.challenge(challengeType)
+
+	// An empty payload fetches the challenge, while an empty object asks
+	// for it to be validated
+	if len(msg.Payload) == 0 || authz.Status != acmeStatusPending || challenge.Status != acmeStatusPending {
+		return ac.challengeResponse(authz, challenge)
+	}
+
+	// Validation happens without holding the lock, as it involves network
+	// requests
+	keyAuth := keyAuthorization(challenge.Token, msg.Account.Thumbprint)
+	var problem *acmeProblem
+	switch challengeType {
+	case acmeChallengeHTTP01:
+		problem = b.validateHTTP01(authz.Identifier.Value, challenge.Token, keyAuth)
+	case acmeChallengeDNS01:
+		problem = b.validateDNS01(authz.Identifier.Value, keyAuth)
+	}
+
+	b.acmeLock.Lock()
+	defer b.acmeLock.Unlock()
+
+	authz, err = getACMEAuthorization(req.Storage, msg.Account.ID, authzID)
+	if err != nil {
+		return nil, err
+	}
+	if authz == nil {
+		return nil, newACMEProblem(http.StatusNotFound, "malformed", "challenge not found")
+	}
+	challenge = authz.challenge(challengeType)
+	if authz.Status != acmeStatusPending || challenge.Status != acmeStatusPending {
+		return ac.challengeResponse(authz, challenge)
+	}
+
+	if problem != nil {
+		challenge.Status = acmeStatusInvalid
+		challenge.Error = problem
+		authz.Status = acmeStatusInvalid
+	} else {
+		challenge.Status = acmeStatusValid
+		challenge.Validated = time.Now().UTC()
+		authz.Status = acmeStatusValid
+	}
+	if err := putACMEEntry(req.Storage, acmeAuthorizationKey(authz.AccountID, authz.ID), authz); err != nil {
+		return nil, err
+	}
+
+	return ac.challengeResponse(authz, challenge)
+}
+
+func (authz *acmeAuthorization) challenge(challengeType string) *acmeChallenge {
+	for _, challenge := range authz.Challenges {
+		if challenge.Type == challengeType {
+			return challenge
+		}
+	}
+	return nil
+}
+
+func (ac *acmeContext) accountResponse(status int, account *acmeAccount) (*logical.Response, error) {
+	contact := account.Contact
+	if contact == nil {
+		contact = []string{}
+	}
+
+	resp, err := acmeJSONResponse(status, map[string]interface{}{
+		"status":  account.Status,
+		"contact": contact,
+		"orders":  ac.url("account/" + account.ID + "/orders"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	setACMEHeader(resp, "Location", ac.accountURL(account.ID))
+	return resp, nil
+}
+
+func (ac *acmeContext) orderJSON(order *acmeOrder) map[string]interface{} {
+	authorizations := make([]string, 0, len(order.AuthorizationIDs))
+	for _, id := range order.AuthorizationIDs {
+		authorizations = append(authorizations, ac.authorizationURL(id))
+	}
+
+	result := map[string]interface{}{
+		"status":         order.Status,
+		"expires":        order.Expires.Format(time.RFC3339),
+		"identifiers":    order.Identifiers,
+		"authorizations": authorizations,
+		"finalize":       ac.orderURL(order.ID) + "/finalize",
+	}
+	if order.Status == acmeStatusValid {
+		result["certificate"] = ac.orderURL(order.ID) + "/cert"
+	}
+
+	return result
+}
+
+func (ac *acmeContext) authorizationJSON(authz *acmeAuthorization) map[string]interface{} {
+	challenges := make([]map[string]interface{}, 0, len(authz.Challenges))
+	for _, challenge := range authz.Challenges {
+		challenges = append(challenges, ac.challengeJSON(authz, challenge))
+	}
+
+	result := map[string]interface{}{
+		"identifier": authz.Identifier,
+		"status":     authz.Status,
+		"expires":    authz.Expires.Format(time.RFC3339),
+		"challenges": challenges,
+	}
+	if authz.Wildcard {
+		result["wildcard"] = true
+	}
+
+	return result
+}
+
+func (ac *acmeContext) challengeJSON(authz *acmeAuthorization, challenge *acmeChallenge) map[string]interface{} {
+	result := map[string]interface{}{
+		"type":   challenge.Type,
+		"url":    ac.challengeURL(authz.ID, challenge.Type),
+		"status": challenge.Status,
+		"token":  challenge.Token,
+	}
+	if !challenge.Validated.IsZero() {
+		result["validated"] = challenge.Validated.Format(time.RFC3339)
+	}
+	if challenge.Error != nil {
+		result["error"] = challenge.Error
+	}
+
+	return result
+}
+
+func (ac *acmeContext) challengeResponse(authz *acmeAuthorization, challenge *acmeChallenge) (*logical.Response, error) {
+	resp, err := acmeJSONResponse(http.StatusOK, ac.challengeJSON(authz, challenge))
+	if err != nil {
+		return nil, err
+	}
+	setACMEHeader(resp, "Link", fmt.Sprintf(`<%s>;rel="up"`, ac.authorizationURL(authz.ID)))
+	return resp, nil
+}
+
+const pathACMEHelpSyn = `
+RFC 8555 ACME server endpoints.
+`
+
+const pathACMEHelpDesc = `
+These endpoints implement an ACME server, allowing standard ACME clients to
+obtain certificates from this mount. Start from the directory at
+"acme/directory", which issues certificates with the default role set in
+"config/acme", or at "acme/roles/<role>/directory" to use a specific role.
+
+Accounts are identified by their key, and orders must have their
+identifiers validated through the http-01 or dns-01 challenges before being
+finalized with a CSR. The names allowed in an order are those allowed by the
+role. ACME must be enabled through "config/acme" before use.
+`
@@ -0,0 +1,596 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+const acmeTestBaseURL = "https://vault.example.com:8200/v1/pki"
+
+// acmeTestClient is a minimal ACME client signing requests with an ECDSA
+// P-256 account key
+type acmeTestClient struct {
+	t       *testing.T
+	b       *backend
+	storage logical.Storage
+	key     *ecdsa.PrivateKey
+	kid     string
+	nonce   string
+}
+
+func newACMETestClient(t *testing.T, b *backend, storage logical.Storage) *acmeTestClient {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &acmeTestClient{t: t, b: b, storage: storage, key: key}
+}
+
+func (c *acmeTestClient) jwk() map[string]string {
+	size := 32
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(padBytes(c.key.X.Bytes(), size)),
+		"y":   base64.RawURLEncoding.EncodeToString(padBytes(c.key.Y.Bytes(), size)),
+	}
+}
+
+func (c *acmeTestClient) thumbprint() string {
+	jwk := c.jwk()
+	canonical := fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk["crv"], jwk["x"], jwk["y"])
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func padBytes(b []byte, size int) []byte {
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func (c *acmeTestClient) request(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	resp, err := c.b.HandleRequest(&logical.Request{
+		Operation: op,
+		Path:      path,
+		Storage:   c.storage,
+		Data:      data,
+	})
+	if err != nil {
+		c.t.Fatalf("bad: %s %s: err: %v", op, path, err)
+	}
+	if headers, ok := resp.Data[logical.HTTPRawHeaders].(map[string][]string); ok && len(headers["Replay-Nonce"]) == 1 {
+		c.nonce = headers["Replay-Nonce"][0]
+	}
+	return resp
+}
+
+// post sends a JWS to the path, returning the status code and raw body of
+// the response. A nil payload sends a POST-as-GET.
+func (c *acmeTestClient) post(path string, payload interface{}) (int, []byte, *logical.Response) {
+	if c.nonce == "" {
+		c.request(logical.ReadOperation, "acme/new-nonce", nil)
+	}
+
+	header := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": c.nonce,
+		"url":   acmeTestBaseURL + "/" + path,
+	}
+	if c.kid == "" {
+		header["jwk"] = c.jwk()
+	} else {
+		header["kid"] = c.kid
+	}
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	var payloadBytes []byte
+	if payload != nil {
+		payloadBytes, err = json.Marshal(payload)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+	}
+
+	protected := base64.RawURLEncoding.EncodeToString(headerBytes)
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payloadBytes)
+	digest := sha256.Sum256([]byte(protected + "." + encodedPayload))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	signature := append(padBytes(r.Bytes(), 32), padBytes(s.Bytes(), 32)...)
+
+	resp := c.request(logical.UpdateOperation, path, map[string]interface{}{
+		"protected": protected,
+		"payload":   encodedPayload,
+		"signature": base64.RawURLEncoding.EncodeToString(signature),
+	})
+	return resp.Data[logical.HTTPStatusCode].(int), resp.Data[logical.HTTPRawBody].([]byte), resp
+}
+
+// postJSON sends a JWS and decodes the JSON response, failing the test
+// unless the status matches
+func (c *acmeTestClient) postJSON(path string, payload interface{}, status int) (map[string]interface{}, *logical.Response) {
+	code, body, resp := c.post(path, payload)
+	if code != status {
+		c.t.Fatalf("bad: %s: expected status %d, got %d: %s", path, status, code, body)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		c.t.Fatalf("bad: %s: %v: %s", path, err, body)
+	}
+	return result, resp
+}
+
+// acmePath returns the backend path of an ACME URL
+func acmePath(t *testing.T, url interface{}) string {
+	s, ok := url.(string)
+	if !ok || !strings.HasPrefix(s, acmeTestBaseURL+"/") {
+		t.Fatalf("bad url: %#v", url)
+	}
+	return strings.TrimPrefix(s, acmeTestBaseURL+"/")
+}
+
+func setupACMETestBackend(t *testing.T) (*backend, logical.Storage) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b := Backend()
+	if _, err := b.Setup(config); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"root/generate/internal", map[string]interface{}{
+			"common_name": "test.com",
+			"ttl":         "6h",
+		}},
+		{"roles/web", map[string]interface{}{
+			"allowed_domains":  "test.com",
+			"allow_subdomains": "true",
+			"max_ttl":          "4h",
+			"key_type":         "ec",
+			"key_bits":         256,
+		}},
+		{"config/acme", map[string]interface{}{
+			"enabled":      true,
+			"base_url":     acmeTestBaseURL + "/",
+			"default_role": "web",
+		}},
+	}
+	for _, step := range steps {
+		resp, err := b.HandleRequest(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      step.path,
+			Storage:   storage,
+			Data:      step.data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: %s: resp: %#v err: %v", step.path, resp, err)
+		}
+	}
+
+	return b, storage
+}
+
+func TestBackend_ACME(t *testing.T) {
+	b, storage := setupACMETestBackend(t)
+
+	// Serve http-01 responses from a local server, which every validation
+	// connects to regardless of the domain
+	var lock sync.Mutex
+	httpResponses := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		body, ok := httpResponses[r.Host+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+	b.acmeHTTPClient = &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial(network, srv.Listener.Addr().String())
+			},
+		},
+	}
+
+	// Answer dns-01 lookups from a stub resolver
+	txtRecords := map[string][]string{}
+	b.acmeLookupTXT = func(name string) ([]string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		records, ok := txtRecords[name]
+		if !ok {
+			return nil, fmt.Errorf("no such host")
+		}
+		return records, nil
+	}
+
+	client := newACMETestClient(t, b, storage)
+
+	// Directory
+	resp := client.request(logical.ReadOperation, "acme/directory", nil)
+	var directory map[string]string
+	if err := json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), &directory); err != nil {
+		t.Fatal(err)
+	}
+	if directory["newAccount"] != acmeTestBaseURL+"/acme/new-account" ||
+		directory["newNonce"] != acmeTestBaseURL+"/acme/new-nonce" ||
+		directory["newOrder"] != acmeTestBaseURL+"/acme/new-order" {
+		t.Fatalf("bad: %#v", directory)
+	}
+
+	// Asking for an account that does not exist fails
+	code, body, _ := client.post("acme/new-account", map[string]interface{}{
+		"onlyReturnExisting": true,
+	})
+	if code != http.StatusBadRequest || !strings.Contains(string(body), "accountDoesNotExist") {
+		t.Fatalf("bad: %d %s", code, body)
+	}
+
+	account, resp := client.postJSON("acme/new-account", map[string]interface{}{
+		"contact":              []string{"mailto:admin@test.com"},
+		"termsOfServiceAgreed": true,
+	}, http.StatusCreated)
+	if account["status"] != "valid" {
+		t.Fatalf("bad: %#v", account)
+	}
+	client.kid = resp.Data[logical.HTTPRawHeaders].(map[string][]string)["Location"][0]
+
+	// Registering the same key again returns the existing account
+	kid := client.kid
+	client.kid = ""
+	_, resp = client.postJSON("acme/new-account", map[string]interface{}{}, http.StatusOK)
+	if resp.Data[logical.HTTPRawHeaders].(map[string][]string)["Location"][0] != kid {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	client.kid = kid
+
+	// Nonces can only be used once
+	nonce := client.nonce
+	client.postJSON(acmePath(t, kid), nil, http.StatusOK)
+	client.nonce = nonce
+	code, body, _ = client.post(acmePath(t, kid), nil)
+	if code != http.StatusBadRequest || !strings.Contains(string(body), "badNonce") {
+		t.Fatalf("bad: %d %s", code, body)
+	}
+
+	// Names not allowed by the role are rejected
+	code, body, _ = client.post("acme/new-order", map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": "www.example.com"}},
+	})
+	if code != http.StatusBadRequest || !strings.Contains(string(body), "rejectedIdentifier") {
+		t.Fatalf("bad: %d %s", code, body)
+	}
+
+	order, resp := client.postJSON("acme/new-order", map[string]interface{}{
+		"identifiers": []map[string]string{
+			{"type": "dns", "value": "www.test.com"},
+			{"type": "dns", "value": "*.api.test.com"},
+		},
+	}, http.StatusCreated)
+	orderPath := acmePath(t, resp.Data[logical.HTTPRawHeaders].(map[string][]string)["Location"][0])
+	if order["status"] != "pending" || len(order["authorizations"].([]interface{})) != 2 {
+		t.Fatalf("bad: %#v", order)
+	}
+
+	// Finalizing before the identifiers are validated fails
+	code, body, _ = client.post(acmePath(t, order["finalize"]), map[string]interface{}{"csr": ""})
+	if code != http.StatusForbidden || !strings.Contains(string(body), "orderNotReady") {
+		t.Fatalf("bad: %d %s", code, body)
+	}
+
+	for _, authzURL := range order["authorizations"].([]interface{}) {
+		authz, _ := client.postJSON(acmePath(t, authzURL), nil, http.StatusOK)
+		identifier := authz["identifier"].(map[string]interface{})["value"].(string)
+
+		challenges := map[string]map[string]interface{}{}
+		for _, c := range authz["challenges"].([]interface{}) {
+			challenge := c.(map[string]interface{})
+			challenges[challenge["type"].(string)] = challenge
+		}
+
+		switch identifier {
+		case "www.test.com":
+			challenge := challenges["http-01"]
+			token := challenge["token"].(string)
+
+			// A wrong key authorization fails the challenge
+			lock.Lock()
+			httpResponses["www.test.com/.well-known/acme-challenge/"+token] = token + ".wrong"
+			lock.Unlock()
+
+			// Use a second order for the failure, so that this one stays valid
+			_, resp := client.postJSON("acme/new-order", map[string]interface{}{
+				"identifiers": []map[string]string{{"type": "dns", "value": "www.test.com"}},
+			}, http.StatusCreated)
+			failedOrderPath := acmePath(t, resp.Data[logical.HTTPRawHeaders].(map[string][]string)["Location"][0])
+			failedOrder, _ := client.postJSON(failedOrderPath, nil, http.StatusOK)
+			failedAuthz, _ := client.postJSON(acmePath(t, failedOrder["authorizations"].([]interface{})[0]), nil, http.StatusOK)
+			var failedChallenge map[string]interface{}
+			for _, c := range failedAuthz["challenges"].([]interface{}) {
+				if c.(map[string]interface{})["type"] == "http-01" {
+					failedChallenge = c.(map[string]interface{})
+				}
+			}
+			result, _ := client.postJSON(acmePath(t, failedChallenge["url"]), map[string]interface{}{}, http.StatusOK)
+			if result["status"] != "invalid" || result["error"] == nil {
+				t.Fatalf("bad: %#v", result)
+			}
+			failedOrder, _ = client.postJSON(failedOrderPath, nil, http.StatusOK)
+			if failedOrder["status"] != "invalid" {
+				t.Fatalf("bad: %#v", failedOrder)
+			}
+
+			lock.Lock()
+			httpResponses["www.test.com/.well-known/acme-challenge/"+token] = token + "." + client.thumbprint()
+			lock.Unlock()
+			result, resp = client.postJSON(acmePath(t, challenge["url"]), map[string]interface{}{}, http.StatusOK)
+			if result["status"] != "valid" {
+				t.Fatalf("bad: %#v", result)
+			}
+			links := resp.Data[logical.HTTPRawHeaders].(map[string][]string)["Link"]
+			if len(links) != 2 || !strings.Contains(links[0], `rel="up"`) {
+				t.Fatalf("bad: %#v", links)
+			}
+
+		case "api.test.com":
+			if authz["wildcard"] != true {
+				t.Fatalf("bad: %#v", authz)
+			}
+			if _, ok := challenges["http-01"]; ok {
+				t.Fatalf("wildcard names must only offer dns-01: %#v", authz)
+			}
+			challenge := challenges["dns-01"]
+			digest := sha256.Sum256([]byte(challenge["token"].(string) + "." + client.thumbprint()))
+			lock.Lock()
+			txtRecords["_acme-challenge.api.test.com"] = []string{"unrelated", base64.RawURLEncoding.EncodeToString(digest[:])}
+			lock.Unlock()
+			result, _ := client.postJSON(acmePath(t, challenge["url"]), map[string]interface{}{}, http.StatusOK)
+			if result["status"] != "valid" {
+				t.Fatalf("bad: %#v", result)
+			}
+
+		default:
+			t.Fatalf("unexpected identifier %s", identifier)
+		}
+	}
+
+	order, _ = client.postJSON(orderPath, nil, http.StatusOK)
+	if order["status"] != "ready" {
+		t.Fatalf("bad: %#v", order)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := func(names ...string) string {
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: names[0]},
+			DNSNames: names,
+		}, certKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(der)
+	}
+
+	// The CSR must match the identifiers of the order
+	code, body, _ = client.post(acmePath(t, order["finalize"]), map[string]interface{}{
+		"csr": csr("www.test.com", "other.test.com"),
+	})
+	if code != http.StatusBadRequest || !strings.Contains(string(body), "badCSR") {
+		t.Fatalf("bad: %d %s", code, body)
+	}
+
+	order, _ = client.postJSON(acmePath(t, order["finalize"]), map[string]interface{}{
+		"csr": csr("www.test.com", "*.api.test.com"),
+	}, http.StatusOK)
+	if order["status"] != "valid" || order["certificate"] == nil {
+		t.Fatalf("bad: %#v", order)
+	}
+
+	code, body, resp = client.post(acmePath(t, order["certificate"]), nil)
+	if code != http.StatusOK || resp.Data[logical.HTTPContentType] != "application/pem-certificate-chain" {
+		t.Fatalf("bad: %d %s", code, body)
+	}
+	block, rest := pem.Decode(body)
+	if block == nil {
+		t.Fatalf("bad: %s", body)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(cert.DNSNames)
+	if !reflect.DeepEqual(cert.DNSNames, []string{"*.api.test.com", "www.test.com"}) {
+		t.Fatalf("bad: %#v", cert.DNSNames)
+	}
+	if block, _ := pem.Decode(rest); block == nil {
+		t.Fatal("expected the issuing CA in the chain")
+	}
+
+	// The certificate is tracked like any other issued certificate
+	serial := certutil.GetOctalFormatted(cert.SerialNumber.Bytes(), ":")
+	if entry, err := storage.Get("certs/" + serial); err != nil || entry == nil {
+		t.Fatalf("certificate %s not stored: %v", serial, err)
+	}
+
+	orders, _ := client.postJSON(acmePath(t, account["orders"]), nil, http.StatusOK)
+	if len(orders["orders"].([]interface{})) != 2 {
+		t.Fatalf("bad: %#v", orders)
+	}
+
+	// Other accounts can not see the order
+	other := newACMETestClient(t, b, storage)
+	_, resp = other.postJSON("acme/new-account", map[string]interface{}{}, http.StatusCreated)
+	other.kid = resp.Data[logical.HTTPRawHeaders].(map[string][]string)["Location"][0]
+	if code, body, _ := other.post(orderPath, nil); code != http.StatusNotFound {
+		t.Fatalf("bad: %d %s", code, body)
+	}
+
+	// Deactivated accounts can no longer be used
+	client.postJSON(acmePath(t, kid), map[string]interface{}{"status": "deactivated"}, http.StatusOK)
+	code, body, _ = client.post(orderPath, nil)
+	if code != http.StatusUnauthorized {
+		t.Fatalf("bad: %d %s", code, body)
+	}
+}
+
+func TestBackend_ACMENonces(t *testing.T) {
+	b := Backend()
+
+	// The number of outstanding nonces is capped
+	for i := 0; i < acmeMaxNonces+10; i++ {
+		if _, err := b.newACMENonce(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if len(b.acmeNonces) != acmeMaxNonces {
+		t.Fatalf("bad: %d", len(b.acmeNonces))
+	}
+
+	// Expired nonces are dropped periodically
+	b.acmeNonces["expired"] = time.Now().Add(-time.Minute)
+	if err := b.Backend.PeriodicFunc(&logical.Request{}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, ok := b.acmeNonces["expired"]; ok || len(b.acmeNonces) != acmeMaxNonces {
+		t.Fatalf("bad: %d", len(b.acmeNonces))
+	}
+	if b.consumeACMENonce("expired") {
+		t.Fatalf("expected expired nonce to be rejected")
+	}
+}
+
+func TestBackend_ACMERoleDirectory(t *testing.T) {
+	b, storage := setupACMETestBackend(t)
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/internal",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"allowed_domains":  "internal.test.com",
+			"allow_subdomains": "true",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v err: %v", resp, err)
+	}
+
+	// Roles other than the default one must be allowed first
+	client := newACMETestClient(t, b, storage)
+	resp = client.request(logical.ReadOperation, "acme/roles/internal/directory", nil)
+	if resp.Data[logical.HTTPStatusCode] != http.StatusForbidden ||
+		!strings.Contains(string(resp.Data[logical.HTTPRawBody].([]byte)), acmeErrorPrefix+"unauthorized") {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/acme",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"allowed_roles": "internal,missing",
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("bad: resp: %#v err: %v", resp, err)
+	}
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/acme",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"allowed_roles": "internal",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v err: %v", resp, err)
+	}
+
+	resp = client.request(logical.ReadOperation, "acme/roles/internal/directory", nil)
+	var directory map[string]string
+	if err := json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), &directory); err != nil {
+		t.Fatal(err)
+	}
+	if directory["newOrder"] != acmeTestBaseURL+"/acme/roles/internal/new-order" {
+		t.Fatalf("bad: %#v", directory)
+	}
+
+	_, resp = client.postJSON("acme/roles/internal/new-account", map[string]interface{}{}, http.StatusCreated)
+	client.kid = resp.Data[logical.HTTPRawHeaders].(map[string][]string)["Location"][0]
+
+	// Orders are checked against the role of the directory
+	code, body, _ := client.post("acme/roles/internal/new-order", map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": "www.test.com"}},
+	})
+	if code != http.StatusBadRequest || !strings.Contains(string(body), "rejectedIdentifier") {
+		t.Fatalf("bad: %d %s", code, body)
+	}
+	client.postJSON("acme/roles/internal/new-order", map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": "db.internal.test.com"}},
+	}, http.StatusCreated)
+
+	// The default role can also be used through its own directory, but no
+	// other role can
+	resp = client.request(logical.ReadOperation, "acme/roles/web/directory", nil)
+	if resp.Data[logical.HTTPStatusCode] != http.StatusOK {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	resp = client.request(logical.ReadOperation, "acme/roles/missing/directory", nil)
+	if resp.Data[logical.HTTPStatusCode] != http.StatusForbidden {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Nothing is served once ACME is disabled
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/acme",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"enabled": false,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v err: %v", resp, err)
+	}
+	resp = client.request(logical.ReadOperation, "acme/directory", nil)
+	if resp.Data[logical.HTTPStatusCode] != http.StatusNotFound {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
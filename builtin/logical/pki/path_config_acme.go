@@ -0,0 +1,188 @@
+package pki
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/fatih/structs"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// acmeConfig holds the configuration of the ACME server of the mount
+type acmeConfig struct {
+	Enabled      bool     `json:"enabled" structs:"enabled" mapstructure:"enabled"`
+	BaseURL      string   `json:"base_url" structs:"base_url" mapstructure:"base_url"`
+	DefaultRole  string   `json:"default_role" structs:"default_role" mapstructure:"default_role"`
+	AllowedRoles []string `json:"allowed_roles" structs:"allowed_roles" mapstructure:"allowed_roles"`
+}
+
+// roleAllowed returns whether orders may be placed with the given role
+// through "acme/roles/<role>/". Unless other roles are allowed, only the
+// default role is.
+func (c *acmeConfig) roleAllowed(name string) bool {
+	if name == c.DefaultRole {
+		return true
+	}
+	for _, allowed := range c.AllowedRoles {
+		if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func pathConfigACME(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/acme",
+		Fields: map[string]*framework.FieldSchema{
+			"enabled": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: `Whether the ACME server of this mount is enabled`,
+			},
+
+			"base_url": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The URL of this mount as seen by ACME clients,
+such as "https://vault.example.com:8200/v1/pki"`,
+			},
+
+			"default_role": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The role used for orders placed through the
+"acme/directory" endpoint. Orders placed through
+"acme/roles/<role>/directory" use that role.`,
+			},
+
+			"allowed_roles": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `A comma-separated list of the roles which may be
+used through "acme/roles/<role>/directory", in
+addition to the default role. Defaults to none.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathACMEConfigRead,
+			logical.UpdateOperation: b.pathACMEConfigWrite,
+		},
+
+		HelpSynopsis:    pathConfigACMEHelpSyn,
+		HelpDescription: pathConfigACMEHelpDesc,
+	}
+}
+
+func getACMEConfig(s logical.Storage) (*acmeConfig, error) {
+	entry, err := s.Get("config/acme")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result acmeConfig
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (b *backend) pathACMEConfigRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := getACMEConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: structs.New(config).Map(),
+	}, nil
+}
+
+func (b *backend) pathACMEConfigWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := getACMEConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &acmeConfig{}
+	}
+
+	if enabledRaw, ok := data.GetOk("enabled"); ok {
+		config.Enabled = enabledRaw.(bool)
+	}
+	if baseURLRaw, ok := data.GetOk("base_url"); ok {
+		config.BaseURL = strings.TrimSuffix(baseURLRaw.(string), "/")
+		if config.BaseURL != "" && !govalidator.IsURL(config.BaseURL) {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid base URL: %s", config.BaseURL)), nil
+		}
+	}
+	if defaultRoleRaw, ok := data.GetOk("default_role"); ok {
+		config.DefaultRole = defaultRoleRaw.(string)
+		if config.DefaultRole != "" {
+			role, err := b.getRole(req.Storage, config.DefaultRole)
+			if err != nil {
+				return nil, err
+			}
+			if role == nil {
+				return logical.ErrorResponse(fmt.Sprintf(
+					"unknown role: %s", config.DefaultRole)), nil
+			}
+		}
+	}
+
+	if allowedRolesRaw, ok := data.GetOk("allowed_roles"); ok {
+		config.AllowedRoles = nil
+		for _, name := range strings.Split(allowedRolesRaw.(string), ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			role, err := b.getRole(req.Storage, name)
+			if err != nil {
+				return nil, err
+			}
+			if role == nil {
+				return logical.ErrorResponse(fmt.Sprintf(
+					"unknown role: %s", name)), nil
+			}
+			config.AllowedRoles = append(config.AllowedRoles, name)
+		}
+	}
+
+	if config.Enabled && config.BaseURL == "" {
+		return logical.ErrorResponse("base_url must be set to enable ACME"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON("config/acme", config)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, req.Storage.Put(entry)
+}
+
+const pathConfigACMEHelpSyn = `
+Configure the ACME server of this mount.
+`
+
+const pathConfigACMEHelpDesc = `
+This endpoint enables the RFC 8555 ACME server of this mount, which allows
+standard ACME clients to obtain certificates after proving control of the
+requested domains through http-01 or dns-01 challenges.
+
+The base URL is used to build the URLs given out in the ACME directory and
+must be the address of this mount as seen by clients. Each order is issued
+according to a role: the default role for the "acme/directory" endpoint, or
+the named role for the "acme/roles/<role>/directory" endpoint. As the ACME
+endpoints are not authenticated, only the default role and the roles listed
+in "allowed_roles" can be used; orders for other roles are rejected.
+`
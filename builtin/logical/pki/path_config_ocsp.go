@@ -0,0 +1,172 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathConfigOCSP(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/ocsp",
+		Fields: map[string]*framework.FieldSchema{
+			"pem_bundle": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `PEM-format, concatenated unencrypted
+secret key and certificate of a delegated
+OCSP responder issued by this mount's CA.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathOCSPSignerWrite,
+			logical.ReadOperation:   b.pathOCSPSignerRead,
+			logical.DeleteOperation: b.pathOCSPSignerDelete,
+		},
+
+		HelpSynopsis:    pathConfigOCSPHelpSyn,
+		HelpDescription: pathConfigOCSPHelpDesc,
+	}
+}
+
+// Fetches the delegated OCSP signer, if one has been configured
+func fetchOCSPSigner(req *logical.Request) (*certutil.ParsedCertBundle, error) {
+	entry, err := req.Storage.Get("config/ocsp_bundle")
+	if err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("unable to fetch OCSP signer: %v", err)}
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var bundle certutil.CertBundle
+	if err := entry.DecodeJSON(&bundle); err != nil {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("unable to decode OCSP signer: %v", err)}
+	}
+
+	parsedBundle, err := bundle.ToParsedCertBundle()
+	if err != nil {
+		return nil, certutil.InternalError{Err: err.Error()}
+	}
+
+	return parsedBundle, nil
+}
+
+func (b *backend) pathOCSPSignerWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	pemBundle := data.Get("pem_bundle").(string)
+
+	parsedBundle, err := certutil.ParsePEMBundle(pemBundle)
+	if err != nil {
+		switch err.(type) {
+		case certutil.InternalError:
+			return nil, err
+		default:
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if parsedBundle.PrivateKey == nil ||
+		parsedBundle.PrivateKeyType == certutil.UnknownPrivateKey {
+		return logical.ErrorResponse("private key not found in the PEM bundle"), nil
+	}
+	if parsedBundle.Certificate == nil {
+		return logical.ErrorResponse("no certificate found in the PEM bundle"), nil
+	}
+
+	equal, err := certutil.ComparePublicKeys(parsedBundle.Certificate.PublicKey, parsedBundle.PrivateKey.Public())
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"could not verify the certificate and private key match: %v", err)), nil
+	}
+	if !equal {
+		return logical.ErrorResponse("certificate and private key do not match"), nil
+	}
+
+	ocspSigning := false
+	for _, usage := range parsedBundle.Certificate.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageOCSPSigning {
+			ocspSigning = true
+		}
+	}
+	if !ocspSigning {
+		return logical.ErrorResponse("the given certificate is not marked for OCSP signing use"), nil
+	}
+
+	caInfo, err := fetchCAInfo(req)
+	if err != nil {
+		switch err.(type) {
+		case certutil.UserError:
+			return logical.ErrorResponse(err.Error()), nil
+		default:
+			return nil, err
+		}
+	}
+	if err := parsedBundle.Certificate.CheckSignatureFrom(caInfo.Certificate); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"the given certificate was not issued by this mount's CA: %v", err)), nil
+	}
+
+	// The issuing CA is not needed to sign responses
+	parsedBundle.IssuingCA = nil
+	parsedBundle.IssuingCABytes = nil
+
+	cb, err := parsedBundle.ToCertBundle()
+	if err != nil {
+		return nil, fmt.Errorf("error converting raw values into cert bundle: %s", err)
+	}
+
+	entry, err := logical.StorageEntryJSON("config/ocsp_bundle", cb)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, req.Storage.Put(entry)
+}
+
+func (b *backend) pathOCSPSignerRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	signer, err := fetchOCSPSigner(req)
+	if err != nil {
+		return nil, err
+	}
+	if signer == nil {
+		return nil, nil
+	}
+
+	block := pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: signer.CertificateBytes,
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"certificate":   string(pem.EncodeToMemory(&block)),
+			"serial_number": certutil.GetOctalFormatted(signer.Certificate.SerialNumber.Bytes(), ":"),
+		},
+	}, nil
+}
+
+func (b *backend) pathOCSPSignerDelete(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return nil, req.Storage.Delete("config/ocsp_bundle")
+}
+
+const pathConfigOCSPHelpSyn = `
+Set a delegated certificate and private key used to sign OCSP responses.
+`
+
+const pathConfigOCSPHelpDesc = `
+By default, OCSP responses served from the "ocsp" endpoint are signed with
+the CA's private key. This path allows setting a delegated OCSP responder
+certificate and private key instead. The certificate must be issued by this
+mount's CA and carry the OCSP signing extended key usage; it is included
+alongside each response so that clients can verify the delegation.
+
+Deleting the configuration reverts to signing with the CA's private key. For
+security reasons, the secret key cannot be retrieved later.
+`
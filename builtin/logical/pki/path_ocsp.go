@@ -0,0 +1,235 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"golang.org/x/crypto/ocsp"
+)
+
+const ocspResponseContentType = "application/ocsp-response"
+
+// Answers DER-encoded OCSP requests sent as the body of a POST
+func pathOCSP(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: `ocsp`,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathOCSPWrite,
+		},
+
+		HelpSynopsis:    pathOCSPHelpSyn,
+		HelpDescription: pathOCSPHelpDesc,
+	}
+}
+
+// Answers base64-encoded OCSP requests given in the URL of a GET
+func pathOCSPGet(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: `ocsp/(?P<request>.+)`,
+		Fields: map[string]*framework.FieldSchema{
+			"request": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `Base64-encoded, DER-encoded OCSP request`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathOCSPRead,
+		},
+
+		HelpSynopsis:    pathOCSPHelpSyn,
+		HelpDescription: pathOCSPHelpDesc,
+	}
+}
+
+func (b *backend) pathOCSPWrite(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	rawReq, ok := req.Data[logical.HTTPRawBody].([]byte)
+	if !ok {
+		return ocspResponse(ocsp.MalformedRequestErrorResponse), nil
+	}
+
+	return b.answerOCSP(req, rawReq), nil
+}
+
+func (b *backend) pathOCSPRead(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	rawReq, err := base64.StdEncoding.DecodeString(data.Get("request").(string))
+	if err != nil {
+		return ocspResponse(ocsp.MalformedRequestErrorResponse), nil
+	}
+
+	return b.answerOCSP(req, rawReq), nil
+}
+
+// answerOCSP builds the response to a single OCSP request. Per RFC 6960,
+// failures are reported through the OCSP response status rather than
+// through Vault errors, so this always returns a raw response.
+func (b *backend) answerOCSP(req *logical.Request, rawReq []byte) *logical.Response {
+	ocspReq, err := ocsp.ParseRequest(rawReq)
+	if err != nil {
+		return ocspResponse(ocsp.MalformedRequestErrorResponse)
+	}
+
+	caInfo, err := fetchCAInfo(req)
+	if err != nil {
+		b.Logger().Printf("[ERR] pki: unable to fetch CA to answer OCSP request: %s", err)
+		return ocspResponse(ocsp.InternalErrorErrorResponse)
+	}
+
+	// Only answer for certificates issued by this mount's CA
+	matches, err := issuerMatches(ocspReq, caInfo.Certificate)
+	if err != nil {
+		return ocspResponse(ocsp.MalformedRequestErrorResponse)
+	}
+	if !matches {
+		return ocspResponse(ocsp.UnauthorizedErrorResponse)
+	}
+
+	template := ocsp.Response{
+		SerialNumber: ocspReq.SerialNumber,
+		IssuerHash:   ocspReq.HashAlgorithm,
+		ThisUpdate:   time.Now(),
+	}
+	template.Status, template.RevokedAt, err = certStatus(req, ocspReq)
+	if err != nil {
+		b.Logger().Printf("[ERR] pki: unable to determine status for OCSP request: %s", err)
+		return ocspResponse(ocsp.InternalErrorErrorResponse)
+	}
+	template.NextUpdate, err = b.ocspNextUpdate(req, template.ThisUpdate)
+	if err != nil {
+		b.Logger().Printf("[ERR] pki: unable to determine next update for OCSP request: %s", err)
+		return ocspResponse(ocsp.InternalErrorErrorResponse)
+	}
+
+	responderCert := caInfo.Certificate
+	var signer crypto.Signer = caInfo.PrivateKey
+	delegated, err := fetchOCSPSigner(req)
+	if err != nil {
+		b.Logger().Printf("[ERR] pki: unable to fetch OCSP signer: %s", err)
+		return ocspResponse(ocsp.InternalErrorErrorResponse)
+	}
+	if delegated != nil {
+		responderCert = delegated.Certificate
+		signer = delegated.PrivateKey
+		template.Certificate = delegated.Certificate
+	}
+
+	resp, err := ocsp.CreateResponse(caInfo.Certificate, responderCert, template, signer)
+	if err != nil {
+		b.Logger().Printf("[ERR] pki: unable to sign OCSP response: %s", err)
+		return ocspResponse(ocsp.InternalErrorErrorResponse)
+	}
+
+	return ocspResponse(resp)
+}
+
+// ocspNextUpdate returns the time until which an OCSP response can be
+// cached. This is the next update of the CRL, as long as it is current, so
+// that OCSP and CRL clients learn of revocations at the same time.
+// Otherwise, the response is valid for the lifetime of a new CRL.
+func (b *backend) ocspNextUpdate(req *logical.Request, now time.Time) (time.Time, error) {
+	entry, err := req.Storage.Get("crl")
+	if err != nil {
+		return time.Time{}, err
+	}
+	if entry != nil {
+		crl, err := x509.ParseCRL(entry.Value)
+		if err == nil && crl.TBSCertList.NextUpdate.After(now) {
+			return crl.TBSCertList.NextUpdate, nil
+		}
+	}
+
+	lifetime, err := b.getCRLLifetime(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(lifetime), nil
+}
+
+// issuerMatches checks the issuer hashes of the request against the given
+// CA certificate, using the hash algorithm chosen by the client.
+func issuerMatches(ocspReq *ocsp.Request, ca *x509.Certificate) (bool, error) {
+	if ocspReq.HashAlgorithm == 0 || !ocspReq.HashAlgorithm.Available() {
+		return false, fmt.Errorf("unsupported hash algorithm")
+	}
+
+	var publicKeyInfo struct {
+		Algorithm asn1.RawValue
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(ca.RawSubjectPublicKeyInfo, &publicKeyInfo); err != nil {
+		return false, err
+	}
+
+	h := ocspReq.HashAlgorithm.New()
+	h.Write(ca.RawSubject)
+	if !bytes.Equal(h.Sum(nil), ocspReq.IssuerNameHash) {
+		return false, nil
+	}
+
+	h.Reset()
+	h.Write(publicKeyInfo.PublicKey.RightAlign())
+	return bytes.Equal(h.Sum(nil), ocspReq.IssuerKeyHash), nil
+}
+
+// certStatus looks up the revocation records written by revokeCert.
+// Certificates that were never issued by this mount are reported as
+// unknown.
+func certStatus(req *logical.Request, ocspReq *ocsp.Request) (int, time.Time, error) {
+	serial := certutil.GetOctalFormatted(ocspReq.SerialNumber.Bytes(), ":")
+
+	revokedEntry, err := req.Storage.Get("revoked/" + serial)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if revokedEntry != nil {
+		var revInfo revocationInfo
+		if err := revokedEntry.DecodeJSON(&revInfo); err != nil {
+			return 0, time.Time{}, fmt.Errorf("error decoding revocation entry for serial %s: %s", serial, err)
+		}
+		return ocsp.Revoked, time.Unix(revInfo.RevocationTime, 0), nil
+	}
+
+	certEntry, err := req.Storage.Get("certs/" + serial)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if certEntry == nil {
+		return ocsp.Unknown, time.Time{}, nil
+	}
+
+	return ocsp.Good, time.Time{}, nil
+}
+
+func ocspResponse(body []byte) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: ocspResponseContentType,
+			logical.HTTPRawBody:     body,
+			logical.HTTPStatusCode:  200,
+		},
+	}
+}
+
+const pathOCSPHelpSyn = `
+Query the revocation status of a certificate using OCSP.
+`
+
+const pathOCSPHelpDesc = `
+This endpoint is an RFC 6960 OCSP responder for certificates issued by this
+mount's CA. Requests can be sent as the DER-encoded body of a POST with a
+Content-Type of "application/ocsp-request", or base64-encoded in the URL of
+a GET to "ocsp/<request>".
+
+Responses are signed with the CA's private key, or with the delegated OCSP
+responder set through "config/ocsp". To have clients find this endpoint,
+add its URL to the "ocsp_servers" values in "config/urls".
+`
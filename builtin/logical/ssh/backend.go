@@ -37,6 +37,7 @@ func Backend(conf *logical.BackendConfig) (*backend, error) {
 		PathsSpecial: &logical.Paths{
 			Unauthenticated: []string{
 				"verify",
+				"public_key",
 			},
 		},
 
@@ -48,6 +49,9 @@ func Backend(conf *logical.BackendConfig) (*backend, error) {
 			pathCredsCreate(&b),
 			pathLookup(&b),
 			pathVerify(&b),
+			pathConfigCA(&b),
+			pathSign(&b),
+			pathFetchPublicKey(&b),
 		},
 
 		Secrets: []*framework.Secret{
@@ -62,13 +66,13 @@ const backendHelp = `
 The SSH backend generates credentials allowing clients to establish SSH
 connections to remote hosts.
 
-There are two variants of the backend, which generate different types of
-credentials: dynamic keys and One-Time Passwords (OTPs). The desired behavior
-is role-specific and chosen at role creation time with the 'key_type'
-parameter.
+There are three variants of the backend, which generate different types of
+credentials: dynamic keys, One-Time Passwords (OTPs) and certificates signed
+by a CA key held by the backend. The desired behavior is role-specific and
+chosen at role creation time with the 'key_type' parameter.
 
-Please see the backend documentation for a thorough description of both
-types. The Vault team strongly recommends the OTP type.
+Please see the backend documentation for a thorough description of all
+types. The Vault team strongly recommends the OTP or CA types.
 
 After mounting this backend, before generating credentials, configure the
 backend's lease behavior using the 'config/lease' endpoint and create roles
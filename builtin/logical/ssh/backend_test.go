@@ -637,3 +637,216 @@ func testCredsWrite(t *testing.T, roleName string, data map[string]interface{},
 		},
 	}
 }
+
+func TestSSHBackend_CA(t *testing.T) {
+	// A user key and a host key to be signed
+	publicKey, _, err := generateRSAKeys(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostPublicKey, _, err := generateRSAKeys(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var caPublicKey ssh.PublicKey
+	logicaltest.Test(t, logicaltest.TestCase{
+		Factory: caTestingFactory,
+		Steps: []logicaltest.TestStep{
+			testSignWrite(t, "userrole", map[string]interface{}{"public_key": publicKey}, true, nil),
+			logicaltest.TestStep{
+				Operation: logical.UpdateOperation,
+				Path:      "config/ca",
+				Check: func(resp *logical.Response) error {
+					if resp == nil || resp.Data["public_key"] == nil {
+						return fmt.Errorf("bad: %#v", resp)
+					}
+					key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(resp.Data["public_key"].(string)))
+					if err != nil {
+						return err
+					}
+					caPublicKey = key
+					return nil
+				},
+			},
+			logicaltest.TestStep{
+				Operation: logical.UpdateOperation,
+				Path:      "config/ca",
+				ErrorOk:   true,
+				Check: func(resp *logical.Response) error {
+					if resp == nil || !resp.IsError() {
+						return fmt.Errorf("expected error when overwriting CA keys, got: %#v", resp)
+					}
+					return nil
+				},
+			},
+			logicaltest.TestStep{
+				Operation: logical.ReadOperation,
+				Path:      "public_key",
+				Check: func(resp *logical.Response) error {
+					body := resp.Data[logical.HTTPRawBody].([]byte)
+					key, _, _, _, err := ssh.ParseAuthorizedKey(body)
+					if err != nil {
+						return err
+					}
+					if string(key.Marshal()) != string(caPublicKey.Marshal()) {
+						return fmt.Errorf("public key mismatch: %s", body)
+					}
+					return nil
+				},
+			},
+			testRoleWrite(t, "userrole", map[string]interface{}{
+				"key_type":                "ca",
+				"allow_user_certificates": true,
+				"allowed_users":           "alice,bob",
+				"default_user":            "alice",
+				"allowed_extensions":      "permit-pty,permit-port-forwarding",
+				"default_extensions": map[string]interface{}{
+					"permit-pty": "",
+				},
+				"default_critical_options": map[string]interface{}{
+					"force-command": "uptime",
+				},
+				"ttl":     "30m",
+				"max_ttl": "1h",
+			}),
+			testRoleWrite(t, "hostrole", map[string]interface{}{
+				"key_type":                "ca",
+				"allow_host_certificates": true,
+				"allowed_domains":         "example.com",
+				"allow_subdomains":        true,
+				"allowed_key_types":       "ssh-rsa",
+				"min_rsa_key_bits":        4096,
+			}),
+			// Default user, default extensions and critical options
+			testSignWrite(t, "userrole", map[string]interface{}{
+				"public_key": publicKey,
+			}, false, func(cert *ssh.Certificate) error {
+				if cert.CertType != ssh.UserCert || !reflect.DeepEqual(cert.ValidPrincipals, []string{"alice"}) {
+					return fmt.Errorf("bad principals: %#v", cert.ValidPrincipals)
+				}
+				if !reflect.DeepEqual(cert.Extensions, map[string]string{"permit-pty": ""}) ||
+					!reflect.DeepEqual(cert.CriticalOptions, map[string]string{"force-command": "uptime"}) {
+					return fmt.Errorf("bad permissions: %#v", cert.Permissions)
+				}
+				if err := checkCertTTL(cert, 30*time.Minute); err != nil {
+					return err
+				}
+				return checkCertSignature(cert, caPublicKey, "alice")
+			}),
+			// Requested principals, extensions and TTL
+			testSignWrite(t, "userrole", map[string]interface{}{
+				"public_key":       publicKey,
+				"valid_principals": "bob,alice",
+				"extensions": map[string]interface{}{
+					"permit-port-forwarding": "",
+				},
+				"ttl": "1h",
+			}, false, func(cert *ssh.Certificate) error {
+				if !reflect.DeepEqual(cert.ValidPrincipals, []string{"bob", "alice"}) {
+					return fmt.Errorf("bad principals: %#v", cert.ValidPrincipals)
+				}
+				if !reflect.DeepEqual(cert.Extensions, map[string]string{"permit-port-forwarding": ""}) {
+					return fmt.Errorf("bad extensions: %#v", cert.Extensions)
+				}
+				if err := checkCertTTL(cert, time.Hour); err != nil {
+					return err
+				}
+				return checkCertSignature(cert, caPublicKey, "bob")
+			}),
+			testSignWrite(t, "userrole", map[string]interface{}{"public_key": publicKey, "valid_principals": "root"}, true, nil),
+			testSignWrite(t, "userrole", map[string]interface{}{"public_key": publicKey, "ttl": "2h"}, true, nil),
+			testSignWrite(t, "userrole", map[string]interface{}{
+				"public_key": publicKey,
+				"extensions": map[string]interface{}{"permit-X11-forwarding": ""},
+			}, true, nil),
+			testSignWrite(t, "userrole", map[string]interface{}{"public_key": publicKey, "cert_type": "host"}, true, nil),
+			// The host role requires 4096-bit RSA keys
+			testSignWrite(t, "hostrole", map[string]interface{}{
+				"public_key":       publicKey,
+				"cert_type":        "host",
+				"valid_principals": "web.example.com",
+			}, true, nil),
+			testSignWrite(t, "hostrole", map[string]interface{}{
+				"public_key":       hostPublicKey,
+				"cert_type":        "host",
+				"valid_principals": "web.example.com",
+			}, false, func(cert *ssh.Certificate) error {
+				if cert.CertType != ssh.HostCert || !reflect.DeepEqual(cert.ValidPrincipals, []string{"web.example.com"}) {
+					return fmt.Errorf("bad certificate: %#v", cert)
+				}
+				// System default lease TTL
+				return checkCertTTL(cert, 2*time.Minute)
+			}),
+			testSignWrite(t, "hostrole", map[string]interface{}{
+				"public_key":       hostPublicKey,
+				"cert_type":        "host",
+				"valid_principals": "example.com",
+			}, true, nil),
+			testSignWrite(t, "hostrole", map[string]interface{}{"public_key": publicKey}, true, nil),
+			testCredsWrite(t, "userrole", map[string]interface{}{"ip": testIP}, true),
+		},
+	})
+}
+
+func caTestingFactory(conf *logical.BackendConfig) (logical.Backend, error) {
+	return Factory(&logical.BackendConfig{
+		Logger:      nil,
+		StorageView: &logical.InmemStorage{},
+		System: &logical.StaticSystemView{
+			DefaultLeaseTTLVal: 2 * time.Minute,
+			MaxLeaseTTLVal:     10 * time.Minute,
+		},
+	})
+}
+
+func testSignWrite(t *testing.T, roleName string, data map[string]interface{}, expectError bool, check func(*ssh.Certificate) error) logicaltest.TestStep {
+	return logicaltest.TestStep{
+		Operation: logical.UpdateOperation,
+		Path:      "sign/" + roleName,
+		Data:      data,
+		ErrorOk:   expectError,
+		Check: func(resp *logical.Response) error {
+			if expectError {
+				if resp == nil || !resp.IsError() {
+					return fmt.Errorf("expected error, got: %#v", resp)
+				}
+				return nil
+			}
+			if resp == nil || resp.Data["signed_key"] == nil {
+				return fmt.Errorf("bad: %#v", resp)
+			}
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(resp.Data["signed_key"].(string)))
+			if err != nil {
+				return err
+			}
+			cert, ok := key.(*ssh.Certificate)
+			if !ok {
+				return fmt.Errorf("signed key is not a certificate: %#v", key)
+			}
+			if fmt.Sprintf("%016x", cert.Serial) != resp.Data["serial_number"] {
+				return fmt.Errorf("serial number mismatch: %#v", resp.Data)
+			}
+			return check(cert)
+		},
+	}
+}
+
+func checkCertTTL(cert *ssh.Certificate, ttl time.Duration) error {
+	validBefore := time.Unix(int64(cert.ValidBefore), 0)
+	expected := time.Now().Add(ttl)
+	if validBefore.Before(expected.Add(-time.Minute)) || validBefore.After(expected.Add(time.Minute)) {
+		return fmt.Errorf("expected certificate to expire around %s, got %s", expected, validBefore)
+	}
+	return nil
+}
+
+func checkCertSignature(cert *ssh.Certificate, caPublicKey ssh.PublicKey, principal string) error {
+	checker := &ssh.CertChecker{
+		IsAuthority: func(auth ssh.PublicKey) bool {
+			return string(auth.Marshal()) == string(caPublicKey.Marshal())
+		},
+		SupportedCriticalOptions: []string{"force-command"},
+	}
+	return checker.CheckCert(principal, cert)
+}
@@ -0,0 +1,172 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const caKeysStoragePath = "config/ca_keys"
+
+// Structure that holds the key pair used to sign SSH certificates
+type sshCAKeys struct {
+	PublicKey  string `json:"public_key"`
+	PrivateKey string `json:"private_key"`
+}
+
+func pathConfigCA(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/ca",
+		Fields: map[string]*framework.FieldSchema{
+			"private_key": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Optional] Private half of the SSH key that will be used to sign certificates",
+			},
+			"public_key": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Optional] Public half of the SSH key that will be used to sign certificates",
+			},
+			"generate_signing_key": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "[Optional] Generate the signing key pair if no private key is given. Defaults to true.",
+				Default:     true,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathConfigCARead,
+			logical.UpdateOperation: b.pathConfigCAWrite,
+			logical.DeleteOperation: b.pathConfigCADelete,
+		},
+
+		HelpSynopsis:    pathConfigCASyn,
+		HelpDescription: pathConfigCADesc,
+	}
+}
+
+func (b *backend) getCAKeys(s logical.Storage) (*sshCAKeys, error) {
+	entry, err := s.Get(caKeysStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result sshCAKeys
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (b *backend) pathConfigCARead(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	keys, err := b.getCAKeys(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": keys.PublicKey,
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigCADelete(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := req.Storage.Delete(caKeysStoragePath); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (b *backend) pathConfigCAWrite(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	existing, err := b.getCAKeys(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return logical.ErrorResponse("CA keys are already configured. Delete them before setting new ones"), nil
+	}
+
+	privateKey := d.Get("private_key").(string)
+	publicKey := d.Get("public_key").(string)
+	generated := false
+
+	if privateKey == "" {
+		if publicKey != "" {
+			return logical.ErrorResponse("Missing private_key"), nil
+		}
+		if !d.Get("generate_signing_key").(bool) {
+			return logical.ErrorResponse("Missing private_key and key generation is disabled"), nil
+		}
+
+		publicKey, privateKey, err = generateRSAKeys(4096)
+		if err != nil {
+			return nil, err
+		}
+		generated = true
+	} else {
+		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("Invalid private_key: %s", err)), nil
+		}
+		derivedPublicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+
+		// The public key is optional as it can be derived from the private
+		// key, but if it is given it has to match
+		if publicKey != "" {
+			parsedPublicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+			if err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("Invalid public_key: %s", err)), nil
+			}
+			if string(parsedPublicKey.Marshal()) != string(signer.PublicKey().Marshal()) {
+				return logical.ErrorResponse("public_key does not match private_key"), nil
+			}
+		}
+		publicKey = derivedPublicKey
+	}
+
+	entry, err := logical.StorageEntryJSON(caKeysStoragePath, &sshCAKeys{
+		PublicKey:  publicKey,
+		PrivateKey: privateKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	if !generated {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": publicKey,
+		},
+	}, nil
+}
+
+const pathConfigCASyn = `
+Set the SSH private key used for signing certificates.
+`
+
+const pathConfigCADesc = `
+This sets the CA information used for roles of type 'ca'. The private key can
+be given through the 'private_key' parameter, otherwise a 4096-bit RSA key
+pair is generated and its public half is returned.
+
+The public key can be read back through this endpoint or through the
+unauthenticated 'public_key' endpoint. Hosts and clients should trust this
+public key to accept the certificates signed through the 'sign/' endpoint.
+The private key cannot be retrieved. To rotate the keys, delete them through
+this endpoint first.
+`
@@ -60,6 +60,10 @@ func (b *backend) pathCredsCreateWrite(
 		return logical.ErrorResponse(fmt.Sprintf("Role '%s' not found", roleName)), nil
 	}
 
+	if role.KeyType == KeyTypeCA {
+		return logical.ErrorResponse("Role of type 'ca' can only be used with the 'sign/' endpoint"), nil
+	}
+
 	// username is an optional parameter.
 	username := d.Get("username").(string)
 
@@ -0,0 +1,49 @@
+package ssh
+
+import (
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathFetchPublicKey(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: `public_key`,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathFetchPublicKey,
+		},
+
+		HelpSynopsis:    pathFetchPublicKeySyn,
+		HelpDescription: pathFetchPublicKeyDesc,
+	}
+}
+
+func (b *backend) pathFetchPublicKey(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	keys, err := b.getCAKeys(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "text/plain",
+			logical.HTTPRawBody:     []byte(keys.PublicKey + "\n"),
+			logical.HTTPStatusCode:  200,
+		},
+	}, nil
+}
+
+const pathFetchPublicKeySyn = `
+Retrieve the public key.
+`
+
+const pathFetchPublicKeyDesc = `
+This allows the public key of the SSH CA certificate that this backend has been
+configured with to be fetched. This is a bare endpoint that does not return
+a standard Vault data structure and is unauthenticated. The output can be
+appended to the TrustedUserCAKeys file of hosts, or prefixed with
+'@cert-authority *' in the known_hosts file of clients.
+`
@@ -11,23 +11,37 @@ import (
 const (
 	KeyTypeOTP     = "otp"
 	KeyTypeDynamic = "dynamic"
+	KeyTypeCA      = "ca"
 )
 
 // Structure that represents a role in SSH backend. This is a common role structure
-// for both OTP and Dynamic roles. Not all the fields are mandatory for both type.
+// for OTP, Dynamic and CA roles. Not all the fields are mandatory for all types.
 // Some are applicable for one and not for other. It doesn't matter.
 type sshRole struct {
-	KeyType         string `mapstructure:"key_type" json:"key_type"`
-	KeyName         string `mapstructure:"key" json:"key"`
-	KeyBits         int    `mapstructure:"key_bits" json:"key_bits"`
-	AdminUser       string `mapstructure:"admin_user" json:"admin_user"`
-	DefaultUser     string `mapstructure:"default_user" json:"default_user"`
-	CIDRList        string `mapstructure:"cidr_list" json:"cidr_list"`
-	ExcludeCIDRList string `mapstructure:"exclude_cidr_list" json:"exclude_cidr_list"`
-	Port            int    `mapstructure:"port" json:"port"`
-	InstallScript   string `mapstructure:"install_script" json:"install_script"`
-	AllowedUsers    string `mapstructure:"allowed_users" json:"allowed_users"`
-	KeyOptionSpecs  string `mapstructure:"key_option_specs" json:"key_option_specs"`
+	KeyType                string            `mapstructure:"key_type" json:"key_type"`
+	KeyName                string            `mapstructure:"key" json:"key"`
+	KeyBits                int               `mapstructure:"key_bits" json:"key_bits"`
+	AdminUser              string            `mapstructure:"admin_user" json:"admin_user"`
+	DefaultUser            string            `mapstructure:"default_user" json:"default_user"`
+	CIDRList               string            `mapstructure:"cidr_list" json:"cidr_list"`
+	ExcludeCIDRList        string            `mapstructure:"exclude_cidr_list" json:"exclude_cidr_list"`
+	Port                   int               `mapstructure:"port" json:"port"`
+	InstallScript          string            `mapstructure:"install_script" json:"install_script"`
+	AllowedUsers           string            `mapstructure:"allowed_users" json:"allowed_users"`
+	KeyOptionSpecs         string            `mapstructure:"key_option_specs" json:"key_option_specs"`
+	AllowUserCertificates  bool              `mapstructure:"allow_user_certificates" json:"allow_user_certificates"`
+	AllowHostCertificates  bool              `mapstructure:"allow_host_certificates" json:"allow_host_certificates"`
+	AllowedDomains         string            `mapstructure:"allowed_domains" json:"allowed_domains"`
+	AllowBareDomains       bool              `mapstructure:"allow_bare_domains" json:"allow_bare_domains"`
+	AllowSubdomains        bool              `mapstructure:"allow_subdomains" json:"allow_subdomains"`
+	AllowedCriticalOptions string            `mapstructure:"allowed_critical_options" json:"allowed_critical_options"`
+	AllowedExtensions      string            `mapstructure:"allowed_extensions" json:"allowed_extensions"`
+	DefaultCriticalOptions map[string]string `mapstructure:"default_critical_options" json:"default_critical_options"`
+	DefaultExtensions      map[string]string `mapstructure:"default_extensions" json:"default_extensions"`
+	AllowedKeyTypes        string            `mapstructure:"allowed_key_types" json:"allowed_key_types"`
+	MinRSAKeyBits          int               `mapstructure:"min_rsa_key_bits" json:"min_rsa_key_bits"`
+	TTL                    string            `mapstructure:"ttl" json:"ttl"`
+	MaxTTL                 string            `mapstructure:"max_ttl" json:"max_ttl"`
 }
 
 func pathListRoles(b *backend) *framework.Path {
@@ -50,7 +64,7 @@ func pathRoles(b *backend) *framework.Path {
 			"role": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `
-				[Required for both types]
+				[Required for all types]
 				Name of the role being created.`,
 			},
 			"key": &framework.FieldSchema{
@@ -73,10 +87,12 @@ func pathRoles(b *backend) *framework.Path {
 			"default_user": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `
-				[Required for both types]
+				[Required for OTP and Dynamic types] [Optional for CA type]
 				Default username for which a credential will be generated.
 				When the endpoint 'creds/' is used without a username, this
-				value will be used as default username.`,
+				value will be used as default username. For the CA type, this
+				is the principal of user certificates signed without
+				'valid_principals'.`,
 			},
 			"cidr_list": &framework.FieldSchema{
 				Type: framework.TypeString,
@@ -105,9 +121,10 @@ func pathRoles(b *backend) *framework.Path {
 			"key_type": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `
-				[Required for both types] 
-				Type of key used to login to hosts. It can be either 'otp' or 'dynamic'.
-				'otp' type requires agent to be installed in remote hosts.`,
+				[Required for all types]
+				Type of key used to login to hosts. It can be 'otp', 'dynamic' or 'ca'.
+				'otp' type requires agent to be installed in remote hosts. 'ca' type
+				signs certificates with the CA key set via the 'config/ca' endpoint.`,
 			},
 			"key_bits": &framework.FieldSchema{
 				Type: framework.TypeInt,
@@ -126,12 +143,13 @@ func pathRoles(b *backend) *framework.Path {
 			"allowed_users": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `
-				[Optional for both types]
+				[Optional for all types]
 				If this option is not specified, client can request for a credential for
 				any valid user at the remote host, including the admin user. If only certain
 				usernames are to be allowed, then this list enforces it. If this field is
 				set, then credentials can only be created for default_user and usernames
-				present in this list.
+				present in this list. For the CA type, this is the list of principals
+				allowed in user certificates; '*' allows any principal.
 				`,
 			},
 			"key_option_specs": &framework.FieldSchema{
@@ -143,6 +161,96 @@ func pathRoles(b *backend) *framework.Path {
 				file format and should not contain spaces.
 				`,
 			},
+			"allow_user_certificates": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				If set, user certificates can be signed with this role.`,
+			},
+			"allow_host_certificates": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				If set, host certificates can be signed with this role.`,
+			},
+			"allowed_domains": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				Comma separated list of domains for which host certificates can be
+				signed. See 'allow_bare_domains' and 'allow_subdomains'.`,
+			},
+			"allow_bare_domains": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				If set, host certificates can be signed for the domains in
+				'allowed_domains' themselves.`,
+			},
+			"allow_subdomains": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				If set, host certificates can be signed for subdomains of the
+				domains in 'allowed_domains'.`,
+			},
+			"allowed_critical_options": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				Comma separated list of critical options that clients can request
+				in user certificates. If empty, any critical option is allowed.`,
+			},
+			"allowed_extensions": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				Comma separated list of extensions that clients can request in
+				user certificates. If empty, any extension is allowed.`,
+			},
+			"default_critical_options": &framework.FieldSchema{
+				Type: framework.TypeMap,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				Critical options given to user certificates when the client does
+				not request any, such as {"force-command": "uptime"}.`,
+			},
+			"default_extensions": &framework.FieldSchema{
+				Type: framework.TypeMap,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				Extensions given to user certificates when the client does not
+				request any, such as {"permit-pty": ""}.`,
+			},
+			"allowed_key_types": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				Comma separated list of the types of public keys that can be
+				signed, such as 'ssh-rsa,ssh-ed25519'. If empty, any type is
+				allowed.`,
+			},
+			"min_rsa_key_bits": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				Minimum length in bits of the RSA public keys that can be signed.
+				Defaults to 2048.`,
+			},
+			"ttl": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				The lease duration of signed certificates if no specific lease
+				duration is requested. Defaults to the system default lease TTL.`,
+			},
+			"max_ttl": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Optional for CA type] [Not applicable for OTP and Dynamic types]
+				The maximum allowed lease duration of signed certificates.
+				Defaults to the system maximum lease TTL.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -162,11 +270,18 @@ func (b *backend) pathRoleWrite(req *logical.Request, d *framework.FieldData) (*
 		return logical.ErrorResponse("Missing role name"), nil
 	}
 
-	// Allowed users is an optional field, applicable for both OTP and Dynamic types.
+	keyType := d.Get("key_type").(string)
+	if keyType == "" {
+		return logical.ErrorResponse("Missing key type"), nil
+	}
+	keyType = strings.ToLower(keyType)
+
+	// Allowed users is an optional field, applicable for all types.
 	allowedUsers := d.Get("allowed_users").(string)
 
+	// Default user is only optional for CA type
 	defaultUser := d.Get("default_user").(string)
-	if defaultUser == "" {
+	if defaultUser == "" && keyType != KeyTypeCA {
 		return logical.ErrorResponse("Missing default user"), nil
 	}
 
@@ -199,12 +314,6 @@ func (b *backend) pathRoleWrite(req *logical.Request, d *framework.FieldData) (*
 		port = 22
 	}
 
-	keyType := d.Get("key_type").(string)
-	if keyType == "" {
-		return logical.ErrorResponse("Missing key type"), nil
-	}
-	keyType = strings.ToLower(keyType)
-
 	var roleEntry sshRole
 	if keyType == KeyTypeOTP {
 		// Admin user is not used if OTP key type is used because there is
@@ -273,6 +382,12 @@ func (b *backend) pathRoleWrite(req *logical.Request, d *framework.FieldData) (*
 			AllowedUsers:    allowedUsers,
 			KeyOptionSpecs:  keyOptionSpecs,
 		}
+	} else if keyType == KeyTypeCA {
+		var err error
+		roleEntry, err = createCARole(allowedUsers, defaultUser, d)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 	} else {
 		return logical.ErrorResponse("Invalid key type"), nil
 	}
@@ -288,6 +403,57 @@ func (b *backend) pathRoleWrite(req *logical.Request, d *framework.FieldData) (*
 	return nil, nil
 }
 
+func createCARole(allowedUsers, defaultUser string, d *framework.FieldData) (sshRole, error) {
+	roleEntry := sshRole{
+		KeyType:                KeyTypeCA,
+		AllowedUsers:           allowedUsers,
+		DefaultUser:            defaultUser,
+		AllowUserCertificates:  d.Get("allow_user_certificates").(bool),
+		AllowHostCertificates:  d.Get("allow_host_certificates").(bool),
+		AllowedDomains:         d.Get("allowed_domains").(string),
+		AllowBareDomains:       d.Get("allow_bare_domains").(bool),
+		AllowSubdomains:        d.Get("allow_subdomains").(bool),
+		AllowedCriticalOptions: d.Get("allowed_critical_options").(string),
+		AllowedExtensions:      d.Get("allowed_extensions").(string),
+		AllowedKeyTypes:        d.Get("allowed_key_types").(string),
+		MinRSAKeyBits:          d.Get("min_rsa_key_bits").(int),
+		TTL:                    d.Get("ttl").(string),
+		MaxTTL:                 d.Get("max_ttl").(string),
+	}
+
+	if !roleEntry.AllowUserCertificates && !roleEntry.AllowHostCertificates {
+		return roleEntry, fmt.Errorf("Either 'allow_user_certificates' or 'allow_host_certificates' must be set")
+	}
+
+	if roleEntry.MinRSAKeyBits == 0 {
+		roleEntry.MinRSAKeyBits = 2048
+	}
+
+	var err error
+	roleEntry.DefaultCriticalOptions, err = convertMapToStringValue(d.Get("default_critical_options").(map[string]interface{}))
+	if err != nil {
+		return roleEntry, fmt.Errorf("Invalid default_critical_options: %s", err)
+	}
+	roleEntry.DefaultExtensions, err = convertMapToStringValue(d.Get("default_extensions").(map[string]interface{}))
+	if err != nil {
+		return roleEntry, fmt.Errorf("Invalid default_extensions: %s", err)
+	}
+
+	ttl, err := parseRoleTTL(roleEntry.TTL)
+	if err != nil {
+		return roleEntry, fmt.Errorf("Invalid ttl: %s", err)
+	}
+	maxTTL, err := parseRoleTTL(roleEntry.MaxTTL)
+	if err != nil {
+		return roleEntry, fmt.Errorf("Invalid max_ttl: %s", err)
+	}
+	if ttl != 0 && maxTTL != 0 && ttl > maxTTL {
+		return roleEntry, fmt.Errorf("ttl cannot be greater than max_ttl")
+	}
+
+	return roleEntry, nil
+}
+
 func (b *backend) getRole(s logical.Storage, n string) (*sshRole, error) {
 	entry, err := s.Get("roles/" + n)
 	if err != nil {
@@ -324,7 +490,28 @@ func (b *backend) pathRoleRead(req *logical.Request, d *framework.FieldData) (*l
 	}
 
 	// Return information should be based on the key type of the role
-	if role.KeyType == KeyTypeOTP {
+	if role.KeyType == KeyTypeCA {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"key_type":                 role.KeyType,
+				"allowed_users":            role.AllowedUsers,
+				"default_user":             role.DefaultUser,
+				"allow_user_certificates":  role.AllowUserCertificates,
+				"allow_host_certificates":  role.AllowHostCertificates,
+				"allowed_domains":          role.AllowedDomains,
+				"allow_bare_domains":       role.AllowBareDomains,
+				"allow_subdomains":         role.AllowSubdomains,
+				"allowed_critical_options": role.AllowedCriticalOptions,
+				"allowed_extensions":       role.AllowedExtensions,
+				"default_critical_options": role.DefaultCriticalOptions,
+				"default_extensions":       role.DefaultExtensions,
+				"allowed_key_types":        role.AllowedKeyTypes,
+				"min_rsa_key_bits":         role.MinRSAKeyBits,
+				"ttl":                      role.TTL,
+				"max_ttl":                  role.MaxTTL,
+			},
+		}, nil
+	} else if role.KeyType == KeyTypeOTP {
 		return &logical.Response{
 			Data: map[string]interface{}{
 				"default_user":      role.DefaultUser,
@@ -385,7 +572,9 @@ This path allows you to manage the roles that are used to generate credentials.
 
 Role takes a 'key_type' parameter that decides what type of credential this role
 can generate. If remote hosts have Vault SSH Agent installed, an 'otp' type can
-be used, otherwise 'dynamic' type can be used.
+be used, otherwise 'dynamic' type can be used. If remote hosts trust the CA key
+of this backend, a 'ca' type can be used to sign SSH certificates through the
+'sign/' endpoint.
 
 If the backend is mounted at "ssh" and the role is created at "ssh/roles/web",
 then a user could request for a credential at "ssh/creds/web" for an IP that
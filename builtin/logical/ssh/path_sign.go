@@ -0,0 +1,362 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	certTypeUser = "user"
+	certTypeHost = "host"
+)
+
+func pathSign(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "sign/" + framework.GenericNameRegex("role"),
+		Fields: map[string]*framework.FieldSchema{
+			"role": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Required] Name of the role, which must be of type 'ca'",
+			},
+			"public_key": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Required] SSH public key that should be signed, in authorized_keys format",
+			},
+			"cert_type": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Optional] Type of certificate to be created; either 'user' or 'host'",
+				Default:     certTypeUser,
+			},
+			"valid_principals": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `[Optional] Comma separated list of usernames or hostnames the
+certificate is valid for. Defaults to the default user of the role for user
+certificates.`,
+			},
+			"ttl": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `[Optional] The requested validity of the certificate. Cannot
+be larger than the max TTL of the role.`,
+			},
+			"critical_options": &framework.FieldSchema{
+				Type:        framework.TypeMap,
+				Description: "[Optional] Critical options that the certificate should be signed for",
+			},
+			"extensions": &framework.FieldSchema{
+				Type:        framework.TypeMap,
+				Description: "[Optional] Extensions that the certificate should be signed for",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathSignCertificate,
+		},
+
+		HelpSynopsis:    pathSignHelpSyn,
+		HelpDescription: pathSignHelpDesc,
+	}
+}
+
+func (b *backend) pathSignCertificate(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get("role").(string)
+	role, err := b.getRole(req.Storage, roleName)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving role: %s", err)
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("Role '%s' not found", roleName)), nil
+	}
+	if role.KeyType != KeyTypeCA {
+		return logical.ErrorResponse(fmt.Sprintf("Role '%s' is not of type '%s'", roleName, KeyTypeCA)), nil
+	}
+
+	keys, err := b.getCAKeys(req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving CA keys: %s", err)
+	}
+	if keys == nil {
+		return logical.ErrorResponse("No CA keys configured. Use the 'config/ca' endpoint"), nil
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(keys.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA private key: %s", err)
+	}
+
+	publicKeyRaw := d.Get("public_key").(string)
+	if publicKeyRaw == "" {
+		return logical.ErrorResponse("Missing public_key"), nil
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKeyRaw))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("Invalid public_key: %s", err)), nil
+	}
+	if err := validatePublicKeyType(publicKey, role); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	var certType uint32
+	switch d.Get("cert_type").(string) {
+	case certTypeUser:
+		if !role.AllowUserCertificates {
+			return logical.ErrorResponse("Role does not allow user certificates"), nil
+		}
+		certType = ssh.UserCert
+	case certTypeHost:
+		if !role.AllowHostCertificates {
+			return logical.ErrorResponse("Role does not allow host certificates"), nil
+		}
+		certType = ssh.HostCert
+	default:
+		return logical.ErrorResponse("cert_type must be either 'user' or 'host'"), nil
+	}
+
+	principals, err := validatePrincipals(parsePrincipals(d.Get("valid_principals").(string)), certType, role)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	ttl, err := b.certificateTTL(d.Get("ttl").(string), role)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	var criticalOptions, extensions map[string]string
+	if certType == ssh.UserCert {
+		criticalOptions, err = validateCertificateOptions(d, "critical_options", role.AllowedCriticalOptions, role.DefaultCriticalOptions)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		extensions, err = validateCertificateOptions(d, "extensions", role.AllowedExtensions, role.DefaultExtensions)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	} else {
+		// OpenSSH does not define any critical options or extensions for
+		// host certificates
+		if len(d.Get("critical_options").(map[string]interface{})) != 0 ||
+			len(d.Get("extensions").(map[string]interface{})) != 0 {
+			return logical.ErrorResponse("Host certificates cannot have critical options or extensions"), nil
+		}
+	}
+
+	serialBytes := make([]byte, 8)
+	if _, err := rand.Read(serialBytes); err != nil {
+		return nil, fmt.Errorf("error generating serial number: %s", err)
+	}
+	serial := binary.BigEndian.Uint64(serialBytes)
+
+	fingerprint := sha256.Sum256(publicKey.Marshal())
+	keyID := fmt.Sprintf("vault-%s-%s", req.DisplayName, hex.EncodeToString(fingerprint[:]))
+
+	// Backdate the certificate a little to allow for clock skew
+	now := time.Now()
+	certificate := &ssh.Certificate{
+		Key:             publicKey,
+		Serial:          serial,
+		CertType:        certType,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-30 * time.Second).Unix()),
+		ValidBefore:     uint64(now.Add(ttl).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: criticalOptions,
+			Extensions:      extensions,
+		},
+	}
+	if err := certificate.SignCert(rand.Reader, signer); err != nil {
+		return nil, fmt.Errorf("error signing certificate: %s", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"serial_number": fmt.Sprintf("%016x", serial),
+			"signed_key":    string(ssh.MarshalAuthorizedKey(certificate)),
+		},
+	}, nil
+}
+
+func parsePrincipals(principals string) []string {
+	var result []string
+	for _, principal := range strings.Split(principals, ",") {
+		principal = strings.TrimSpace(principal)
+		if principal != "" {
+			result = append(result, principal)
+		}
+	}
+	return result
+}
+
+// Checks the requested principals against the role, defaulting to the
+// default user of the role for user certificates
+func validatePrincipals(principals []string, certType uint32, role *sshRole) ([]string, error) {
+	if certType == ssh.UserCert {
+		if len(principals) == 0 {
+			if role.DefaultUser == "" {
+				return nil, fmt.Errorf("Missing valid_principals and the role has no default user")
+			}
+			return []string{role.DefaultUser}, nil
+		}
+		for _, principal := range principals {
+			if principal == role.DefaultUser {
+				continue
+			}
+			if err := validateUsername(principal, role.AllowedUsers); err != nil {
+				return nil, fmt.Errorf("Principal '%s' is not in the allowed users list", principal)
+			}
+		}
+		return principals, nil
+	}
+
+	if len(principals) == 0 {
+		return nil, fmt.Errorf("Missing valid_principals, which are required for host certificates")
+	}
+	for _, principal := range principals {
+		if !validateHostname(principal, role) {
+			return nil, fmt.Errorf("Principal '%s' is not an allowed domain", principal)
+		}
+	}
+	return principals, nil
+}
+
+func validateHostname(hostname string, role *sshRole) bool {
+	hostname = strings.ToLower(hostname)
+	for _, domain := range strings.Split(role.AllowedDomains, ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		if role.AllowBareDomains && hostname == domain {
+			return true
+		}
+		if role.AllowSubdomains && strings.HasSuffix(hostname, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the type and, for RSA keys, the length of a public key to be
+// signed against the role
+func validatePublicKeyType(publicKey ssh.PublicKey, role *sshRole) error {
+	if role.AllowedKeyTypes != "" {
+		allowed := false
+		for _, keyType := range strings.Split(role.AllowedKeyTypes, ",") {
+			if strings.TrimSpace(keyType) == publicKey.Type() {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("Public keys of type '%s' are not allowed by the role", publicKey.Type())
+		}
+	}
+
+	if publicKey.Type() == ssh.KeyAlgoRSA {
+		// The wire format of RSA public keys is the key type followed by
+		// the exponent and the modulus
+		var rsaPublicKey struct {
+			Name string
+			E    *big.Int
+			N    *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(publicKey.Marshal(), &rsaPublicKey); err != nil {
+			return fmt.Errorf("Unable to read the RSA public key: %s", err)
+		}
+		if rsaPublicKey.N.BitLen() < role.MinRSAKeyBits {
+			return fmt.Errorf("RSA public keys must be at least %d bits", role.MinRSAKeyBits)
+		}
+	}
+
+	return nil
+}
+
+// Returns the requested critical options or extensions after checking them
+// against the allowed list of the role, or the defaults of the role if none
+// were requested
+func validateCertificateOptions(d *framework.FieldData, field, allowedList string, defaults map[string]string) (map[string]string, error) {
+	requested, err := convertMapToStringValue(d.Get(field).(map[string]interface{}))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s: %s", field, err)
+	}
+	if len(requested) == 0 {
+		return defaults, nil
+	}
+
+	if allowedList != "" {
+		allowed := make(map[string]bool)
+		for _, name := range strings.Split(allowedList, ",") {
+			allowed[strings.TrimSpace(name)] = true
+		}
+		for name := range requested {
+			if !allowed[name] {
+				return nil, fmt.Errorf("'%s' is not in the allowed %s of the role", name, field)
+			}
+		}
+	}
+
+	return requested, nil
+}
+
+// Determines the validity of a certificate from the requested TTL, the TTLs
+// of the role and the system defaults
+func (b *backend) certificateTTL(requested string, role *sshRole) (time.Duration, error) {
+	ttl, err := parseRoleTTL(requested)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid requested ttl: %s", err)
+	}
+	if ttl == 0 {
+		ttl, err = parseRoleTTL(role.TTL)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid ttl in role: %s", err)
+		}
+	}
+	if ttl == 0 {
+		ttl = b.System().DefaultLeaseTTL()
+	}
+
+	maxTTL, err := parseRoleTTL(role.MaxTTL)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid max_ttl in role: %s", err)
+	}
+	if maxTTL == 0 {
+		maxTTL = b.System().MaxLeaseTTL()
+	}
+
+	if ttl > maxTTL {
+		// Only error if the caller specifically chose a TTL that is too
+		// large
+		if requested != "" {
+			return 0, fmt.Errorf("ttl is larger than maximum allowed (%d)", maxTTL/time.Second)
+		}
+		ttl = maxTTL
+	}
+
+	return ttl, nil
+}
+
+const pathSignHelpSyn = `
+Request signing an SSH key using a certain role with the provided details.
+`
+
+const pathSignHelpDesc = `
+This path allows SSH keys to be signed according to the policy of the given
+role, which must be of type 'ca'. The returned certificate is signed by the CA
+key set via the 'config/ca' endpoint.
+
+User certificates are valid for the given principals, or for the default user
+of the role if none are given, and carry the requested critical options and
+extensions, or the defaults of the role. Host certificates must name the
+hostnames they are valid for.
+`
@@ -238,3 +238,24 @@ func createSSHComm(username, ip string, port int, hostkey string) (*comm, error)
 
 	return SSHCommNew(fmt.Sprintf("%s:%d", ip, port), config)
 }
+
+// Converts the values of a map read from a TypeMap field to strings
+func convertMapToStringValue(initial map[string]interface{}) (map[string]string, error) {
+	result := make(map[string]string, len(initial))
+	for key, value := range initial {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("value of '%s' is not a string", key)
+		}
+		result[key] = str
+	}
+	return result, nil
+}
+
+// Parses a TTL of a role. An empty TTL is returned as zero.
+func parseRoleTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(ttl)
+}
@@ -1,6 +1,8 @@
 package transit
 
 import (
+	"sync"
+
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -23,11 +25,22 @@ func Backend(conf *logical.BackendConfig) *backend {
 			// as the handler is greedy
 			b.pathConfig(),
 			b.pathRotate(),
+			b.pathImport(),
+			b.pathImportVersion(),
 			b.pathRewrap(),
 			b.pathKeys(),
 			b.pathEncrypt(),
 			b.pathDecrypt(),
 			b.pathDatakey(),
+			b.pathSign(),
+			b.pathVerify(),
+			b.pathHMAC(),
+			b.pathHash(),
+			b.pathRandom(),
+			b.pathExportKeys(),
+			b.pathBackup(),
+			b.pathRestore(),
+			b.pathWrappingKey(),
 		},
 
 		Secrets: []*framework.Secret{},
@@ -41,4 +54,8 @@ func Backend(conf *logical.BackendConfig) *backend {
 type backend struct {
 	*framework.Backend
 	lm *lockManager
+
+	// wrappingKeyLock serializes the creation of the wrapping key used for
+	// importing keys
+	wrappingKeyLock sync.Mutex
 }
@@ -1,11 +1,13 @@
 package transit
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
 
+	"github.com/hashicorp/vault/helper/certutil"
 	"github.com/hashicorp/vault/logical"
 )
 
@@ -101,46 +103,86 @@ func (lm *lockManager) UnlockPolicy(lock *sync.RWMutex, lockType bool) {
 	}
 }
 
+// policyRequest holds the parameters used when looking up, and possibly
+// creating, a policy
+type policyRequest struct {
+	// The storage to use
+	storage logical.Storage
+
+	// The name of the policy
+	name string
+
+	// The key type of the policy, used when creating it
+	keyType KeyType
+
+	// Whether it should be derived, used when creating it
+	derived bool
+
+	// Whether to enable convergent encryption, used when creating it
+	convergent bool
+
+	// Whether the key material can be exported, used when creating it
+	exportable bool
+
+	// Whether the key can be backed up, used when creating it
+	allowPlaintextBackup bool
+
+	// Whether to upsert
+	upsert bool
+}
+
 // Get the policy with a read lock. If we get an error saying an exclusive lock
 // is needed (for instance, for an upgrade/migration), give up the read lock,
 // call again with an exclusive lock, then swap back out for a read lock.
 func (lm *lockManager) GetPolicyShared(storage logical.Storage, name string) (*Policy, *sync.RWMutex, error) {
-	p, lock, _, err := lm.getPolicyCommon(storage, name, false, false, false, shared)
+	req := policyRequest{
+		storage: storage,
+		name:    name,
+	}
+
+	p, lock, _, err := lm.getPolicyCommon(req, shared)
 	if err == nil ||
 		(err != nil && err != errNeedExclusiveLock) {
 		return p, lock, err
 	}
 
 	// Try again while asking for an exlusive lock
-	p, lock, _, err = lm.getPolicyCommon(storage, name, false, false, false, exclusive)
+	p, lock, _, err = lm.getPolicyCommon(req, exclusive)
 	if err != nil || p == nil || lock == nil {
 		return p, lock, err
 	}
 
 	lock.Unlock()
 
-	p, lock, _, err = lm.getPolicyCommon(storage, name, false, false, false, shared)
+	p, lock, _, err = lm.getPolicyCommon(req, shared)
 	return p, lock, err
 }
 
 // Get the policy with an exclusive lock
 func (lm *lockManager) GetPolicyExclusive(storage logical.Storage, name string) (*Policy, *sync.RWMutex, error) {
-	p, lock, _, err := lm.getPolicyCommon(storage, name, false, false, false, exclusive)
+	req := policyRequest{
+		storage: storage,
+		name:    name,
+	}
+
+	p, lock, _, err := lm.getPolicyCommon(req, exclusive)
 	return p, lock, err
 }
 
 // Get the policy with a read lock; if it returns that an exclusive lock is
 // needed, retry. If successful, call one more time to get a read lock and
 // return the value.
-func (lm *lockManager) GetPolicyUpsert(storage logical.Storage, name string, derived bool, convergent bool) (*Policy, *sync.RWMutex, bool, error) {
-	p, lock, _, err := lm.getPolicyCommon(storage, name, true, derived, convergent, shared)
+func (lm *lockManager) GetPolicyUpsert(req policyRequest) (*Policy, *sync.RWMutex, bool, error) {
+	req.upsert = true
+
+	p, lock, _, err := lm.getPolicyCommon(req, shared)
 	if err == nil ||
 		(err != nil && err != errNeedExclusiveLock) {
 		return p, lock, false, err
 	}
 
 	// Try again while asking for an exlusive lock
-	p, lock, upserted, err := lm.getPolicyCommon(storage, name, true, derived, convergent, exclusive)
+	p, lock, upserted, err := lm.getPolicyCommon(req, exclusive)
 	if err != nil || p == nil || lock == nil {
 		return p, lock, upserted, err
 	}
@@ -148,14 +190,17 @@ func (lm *lockManager) GetPolicyUpsert(storage logical.Storage, name string, der
 	lock.Unlock()
 
 	// Now get a shared lock for the return, but preserve the value of upsert
-	p, lock, _, err = lm.getPolicyCommon(storage, name, true, derived, convergent, shared)
+	p, lock, _, err = lm.getPolicyCommon(req, shared)
 
 	return p, lock, upserted, err
 }
 
 // When the function returns, a lock will be held on the policy if err == nil.
 // It is the caller's responsibility to unlock.
-func (lm *lockManager) getPolicyCommon(storage logical.Storage, name string, upsert, derived, convergent, lockType bool) (*Policy, *sync.RWMutex, bool, error) {
+func (lm *lockManager) getPolicyCommon(req policyRequest, lockType bool) (*Policy, *sync.RWMutex, bool, error) {
+	name := req.name
+	storage := req.storage
+
 	lock := lm.policyLock(name, lockType)
 
 	var p *Policy
@@ -182,7 +227,7 @@ func (lm *lockManager) getPolicyCommon(storage logical.Storage, name string, ups
 	if p == nil {
 		// This is the only place we upsert a new policy, so if upsert is not
 		// specified, or the lock type is wrong, unllock before returning
-		if !upsert {
+		if !req.upsert {
 			lm.UnlockPolicy(lock, lockType)
 			return nil, nil, false, nil
 		}
@@ -192,18 +237,10 @@ func (lm *lockManager) getPolicyCommon(storage logical.Storage, name string, ups
 			return nil, nil, false, errNeedExclusiveLock
 		}
 
-		if !derived && convergent {
-			return nil, nil, false, fmt.Errorf("convergent encryption requires derivation to be enabled")
-		}
-
-		p = &Policy{
-			Name:       name,
-			CipherMode: "aes-gcm",
-			Derived:    derived,
-		}
-		if derived {
-			p.KDFMode = kdfMode
-			p.ConvergentEncryption = convergent
+		p, err = newPolicy(req)
+		if err != nil {
+			lm.UnlockPolicy(lock, lockType)
+			return nil, nil, false, err
 		}
 
 		err = p.rotate(storage)
@@ -264,6 +301,75 @@ func (lm *lockManager) getPolicyCommon(storage logical.Storage, name string, ups
 	return p, lock, false, nil
 }
 
+// newPolicy validates the creation parameters of the request and returns a
+// policy without any key versions
+func newPolicy(req policyRequest) (*Policy, error) {
+	switch req.keyType {
+	case KeyType_AES256_GCM96:
+		if req.convergent && !req.derived {
+			return nil, fmt.Errorf("convergent encryption requires derivation to be enabled")
+		}
+
+	case KeyType_ECDSA_P256, KeyType_ED25519, KeyType_RSA2048, KeyType_RSA4096:
+		if req.derived || req.convergent {
+			return nil, fmt.Errorf("key derivation and convergent encryption not supported for keys of type %s", req.keyType)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", req.keyType)
+	}
+
+	p := &Policy{
+		Name:                 req.name,
+		Type:                 req.keyType,
+		Derived:              req.derived,
+		Exportable:           req.exportable,
+		AllowPlaintextBackup: req.allowPlaintextBackup,
+	}
+	if req.keyType == KeyType_AES256_GCM96 {
+		p.CipherMode = "aes-gcm"
+	}
+	if req.derived {
+		p.KDFMode = kdfMode
+		p.ConvergentEncryption = req.convergent
+	}
+
+	return p, nil
+}
+
+// ImportPolicy creates a new policy whose first version is the given key
+// material. An existing policy is never overwritten.
+func (lm *lockManager) ImportPolicy(req policyRequest, key []byte) error {
+	lock := lm.policyLock(req.name, exclusive)
+	defer lock.Unlock()
+
+	exists, err := lm.policyExists(req.storage, req.name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return certutil.UserError{Err: fmt.Sprintf("key %q already exists", req.name)}
+	}
+
+	p, err := newPolicy(req)
+	if err != nil {
+		return certutil.UserError{Err: err.Error()}
+	}
+	p.Imported = true
+
+	if err := p.importVersion(req.storage, key); err != nil {
+		return err
+	}
+
+	if lm.CacheActive() {
+		lm.cacheMutex.Lock()
+		lm.cache[req.name] = p
+		lm.cacheMutex.Unlock()
+	}
+
+	return nil
+}
+
 func (lm *lockManager) DeletePolicy(storage logical.Storage, name string) error {
 	lm.cacheMutex.Lock()
 	lock := lm.policyLock(name, exclusive)
@@ -307,6 +413,126 @@ func (lm *lockManager) DeletePolicy(storage logical.Storage, name string) error
 	return nil
 }
 
+// keyData is the content of a key backup: the policy along with its archived
+// key versions
+type keyData struct {
+	Policy       *Policy       `json:"policy"`
+	ArchivedKeys *ArchivedKeys `json:"archived_keys"`
+}
+
+// BackupPolicy returns a backup of the named policy, including its archived
+// key versions, as a base64-encoded blob
+func (lm *lockManager) BackupPolicy(storage logical.Storage, name string) (string, error) {
+	p, lock, err := lm.GetPolicyShared(storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return "", err
+	}
+	if p == nil {
+		return "", fmt.Errorf("key %q not found", name)
+	}
+
+	if !p.AllowPlaintextBackup {
+		return "", fmt.Errorf("plaintext backup is disallowed on the key %q", name)
+	}
+
+	archive, err := p.loadArchive(storage)
+	if err != nil {
+		return "", fmt.Errorf("error loading archive of key %q: %s", name, err)
+	}
+
+	buf, err := json.Marshal(&keyData{
+		Policy:       p,
+		ArchivedKeys: archive,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// RestorePolicy restores a policy from a backup created by BackupPolicy. If
+// name is empty, the name of the backed up policy is used. An existing policy
+// is never overwritten.
+func (lm *lockManager) RestorePolicy(storage logical.Storage, name, backup string) error {
+	backupBytes, err := base64.StdEncoding.DecodeString(backup)
+	if err != nil {
+		return certutil.UserError{Err: "failed to decode backup as base64"}
+	}
+
+	data := keyData{
+		Policy: &Policy{
+			Keys: KeyEntryMap{},
+		},
+	}
+	if err := json.Unmarshal(backupBytes, &data); err != nil {
+		return certutil.UserError{Err: fmt.Sprintf("failed to parse backup: %s", err)}
+	}
+	if data.Policy == nil || data.ArchivedKeys == nil {
+		return certutil.UserError{Err: "backup does not contain a key"}
+	}
+	if data.Policy.LatestVersion < 1 || len(data.Policy.Keys) == 0 ||
+		len(data.ArchivedKeys.Keys) < data.Policy.ArchiveVersion+1 {
+		return certutil.UserError{Err: "backup contains an invalid key"}
+	}
+
+	if name == "" {
+		name = data.Policy.Name
+	}
+	data.Policy.Name = name
+
+	lock := lm.policyLock(name, exclusive)
+	defer lock.Unlock()
+
+	// Refuse to overwrite an existing policy
+	exists, err := lm.policyExists(storage, name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return certutil.UserError{Err: fmt.Sprintf("key %q already exists", name)}
+	}
+
+	// The archive needs to be in place before the policy is persisted, as
+	// persisting moves key versions in and out of it
+	if err := data.Policy.storeArchive(data.ArchivedKeys, storage); err != nil {
+		return err
+	}
+	if err := data.Policy.Persist(storage); err != nil {
+		return err
+	}
+
+	if lm.CacheActive() {
+		lm.cacheMutex.Lock()
+		lm.cache[name] = data.Policy
+		lm.cacheMutex.Unlock()
+	}
+
+	return nil
+}
+
+// policyExists returns whether the named policy exists. The caller must hold
+// the lock of the policy.
+func (lm *lockManager) policyExists(storage logical.Storage, name string) (bool, error) {
+	if lm.CacheActive() {
+		lm.cacheMutex.RLock()
+		p := lm.cache[name]
+		lm.cacheMutex.RUnlock()
+		if p != nil {
+			return true, nil
+		}
+	}
+
+	p, err := lm.getStoredPolicy(storage, name)
+	if err != nil {
+		return false, err
+	}
+	return p != nil, nil
+}
+
 func (lm *lockManager) getStoredPolicy(storage logical.Storage, name string) (*Policy, error) {
 	// Check if the policy already exists
 	raw, err := storage.Get("policy/" + name)
@@ -0,0 +1,48 @@
+package transit
+
+import (
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathBackup() *framework.Path {
+	return &framework.Path{
+		Pattern: "backup/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathBackupRead,
+		},
+
+		HelpSynopsis:    pathBackupHelpSyn,
+		HelpDescription: pathBackupHelpDesc,
+	}
+}
+
+func (b *backend) pathBackupRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	backup, err := b.lm.BackupPolicy(req.Storage, d.Get("name").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"backup": backup,
+		},
+	}, nil
+}
+
+const pathBackupHelpSyn = `Backup the named key`
+
+const pathBackupHelpDesc = `
+This path is used to back up the named key, including all of its versions.
+The backup can be restored on this or another Vault through the "restore"
+path. Since the backup contains the key material in plaintext, the key must
+have "allow_plaintext_backup" set.
+`
@@ -26,6 +26,18 @@ to be decrypted.`,
 				Type:        framework.TypeBool,
 				Description: "Whether to allow deletion of the key",
 			},
+
+			"exportable": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Enables export of the key. Once set, this cannot be
+disabled.`,
+			},
+
+			"allow_plaintext_backup": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Enables taking a backup of the named key in
+plaintext format. Once set, this cannot be disabled.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -59,6 +71,25 @@ func (b *backend) pathConfigWrite(
 
 	persistNeeded := false
 
+	// Exporting and backing up can be enabled, but since the key material
+	// may already have left Vault, they can never be disabled again
+	exportableRaw, setExportable := d.GetOk("exportable")
+	if setExportable && p.Exportable && !exportableRaw.(bool) {
+		return logical.ErrorResponse("exportable key cannot be made non-exportable"), nil
+	}
+	allowPlaintextBackupRaw, setAllowPlaintextBackup := d.GetOk("allow_plaintext_backup")
+	if setAllowPlaintextBackup && p.AllowPlaintextBackup && !allowPlaintextBackupRaw.(bool) {
+		return logical.ErrorResponse("plaintext backup cannot be disabled once allowed"), nil
+	}
+	if setExportable && exportableRaw.(bool) && !p.Exportable {
+		p.Exportable = true
+		persistNeeded = true
+	}
+	if setAllowPlaintextBackup && allowPlaintextBackupRaw.(bool) && !p.AllowPlaintextBackup {
+		p.AllowPlaintextBackup = true
+		persistNeeded = true
+	}
+
 	minDecryptionVersionRaw, ok := d.GetOk("min_decryption_version")
 	if ok {
 		minDecryptionVersion := minDecryptionVersionRaw.(int)
@@ -116,5 +147,7 @@ const pathConfigHelpSyn = `Configure a named encryption key`
 const pathConfigHelpDesc = `
 This path is used to configure the named key. Currently, this
 supports adjusting the minimum version of the key allowed to
-be used for decryption via the min_decryption_version paramter.
+be used for decryption via the min_decryption_version paramter,
+allowing deletion of the key, and enabling export and plaintext
+backup of the key.
 `
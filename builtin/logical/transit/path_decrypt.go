@@ -1,7 +1,6 @@
 package transit
 
 import (
-	"encoding/base64"
 	"fmt"
 
 	"github.com/hashicorp/vault/helper/certutil"
@@ -27,6 +26,13 @@ func (b *backend) pathDecrypt() *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Context for key derivation. Required for derived keys.",
 			},
+
+			"batch_input": &framework.FieldSchema{
+				Type: framework.TypeSlice,
+				Description: `List of items to decrypt, each holding its own "ciphertext" and
+"context". If given, the results are returned in "batch_results" and the
+individual parameters must not be set.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -41,20 +47,13 @@ func (b *backend) pathDecrypt() *framework.Path {
 func (b *backend) pathDecryptWrite(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	name := d.Get("name").(string)
-	ciphertext := d.Get("ciphertext").(string)
-	if len(ciphertext) == 0 {
-		return logical.ErrorResponse("missing ciphertext to decrypt"), logical.ErrInvalidRequest
-	}
 
-	// Decode the context if any
-	contextRaw := d.Get("context").(string)
-	var context []byte
-	if len(contextRaw) != 0 {
-		var err error
-		context, err = base64.StdEncoding.DecodeString(contextRaw)
-		if err != nil {
-			return logical.ErrorResponse("failed to decode context as base64"), logical.ErrInvalidRequest
-		}
+	items, batch, err := batchInput(d, "ciphertext", "context")
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	if !batch && len(items[0].Ciphertext) == 0 {
+		return logical.ErrorResponse("missing ciphertext to decrypt"), logical.ErrInvalidRequest
 	}
 
 	// Get the policy
@@ -69,34 +68,40 @@ func (b *backend) pathDecryptWrite(
 		return logical.ErrorResponse("policy not found"), logical.ErrInvalidRequest
 	}
 
-	plaintext, err := p.Decrypt(context, ciphertext)
-	if err != nil {
-		switch err.(type) {
-		case certutil.UserError:
-			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
-		case certutil.InternalError:
-			return nil, err
-		default:
-			return nil, err
+	results, err := processBatch(batch, items, func(item *batchRequestItem) (batchResponseItem, error) {
+		if len(item.Ciphertext) == 0 {
+			return batchResponseItem{}, certutil.UserError{Err: "missing ciphertext to decrypt"}
+		}
+
+		plaintext, err := p.Decrypt(item.decodedContext, item.Ciphertext)
+		if err != nil {
+			return batchResponseItem{}, err
+		}
+		if plaintext == "" {
+			return batchResponseItem{}, fmt.Errorf("empty plaintext returned")
 		}
-	}
 
-	if plaintext == "" {
-		return nil, fmt.Errorf("empty plaintext returned")
+		return batchResponseItem{Plaintext: plaintext}, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Generate the response
-	resp := &logical.Response{
-		Data: map[string]interface{}{
-			"plaintext": plaintext,
-		},
+	resp := batchResponse(batch, results, "plaintext")
+	if resp.IsError() {
+		return resp, logical.ErrInvalidRequest
 	}
 	return resp, nil
 }
 
-const pathDecryptHelpSyn = `Decrypt a ciphertext value using a named key`
+const pathDecryptHelpSyn = `Decrypt a ciphertext value or a batch of ciphertext values using a named key`
 
 const pathDecryptHelpDesc = `
 This path uses the named key from the request path to decrypt a user
 provided ciphertext. The plaintext is returned base64 encoded.
+
+Multiple ciphertexts can be decrypted in one request by giving them in
+"batch_input". The results, including any error for an individual item, are
+returned in "batch_results" in the same order.
 `
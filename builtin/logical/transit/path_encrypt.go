@@ -8,8 +8,36 @@ import (
 	"github.com/hashicorp/vault/helper/certutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
+	"github.com/mitchellh/mapstructure"
 )
 
+// batchRequestItem is a single item of the batch_input of an encrypt,
+// decrypt or rewrap request
+type batchRequestItem struct {
+	// Context for key derivation, base64-encoded
+	Context string `mapstructure:"context"`
+
+	// Plaintext to encrypt, base64-encoded
+	Plaintext string `mapstructure:"plaintext"`
+
+	// Ciphertext to decrypt or rewrap
+	Ciphertext string `mapstructure:"ciphertext"`
+
+	// Nonce to use for convergent encryption, base64-encoded
+	Nonce string `mapstructure:"nonce"`
+
+	decodedContext []byte
+	decodedNonce   []byte
+}
+
+// batchResponseItem is the result of a single item of a batch request. If
+// processing the item failed, only Error is set.
+type batchResponseItem struct {
+	Ciphertext string `json:"ciphertext,omitempty" structs:"ciphertext" mapstructure:"ciphertext"`
+	Plaintext  string `json:"plaintext,omitempty" structs:"plaintext" mapstructure:"plaintext"`
+	Error      string `json:"error,omitempty" structs:"error" mapstructure:"error"`
+}
+
 func (b *backend) pathEncrypt() *framework.Path {
 	return &framework.Path{
 		Pattern: "encrypt/" + framework.GenericNameRegex("name"),
@@ -28,6 +56,19 @@ func (b *backend) pathEncrypt() *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Context for key derivation. Required for derived keys.",
 			},
+
+			"nonce": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Nonce for convergent encryption. Only allowed for keys using convergent
+encryption. If not given, the context is used as the nonce.`,
+			},
+
+			"batch_input": &framework.FieldSchema{
+				Type: framework.TypeSlice,
+				Description: `List of items to encrypt, each holding its own "plaintext", "context"
+and "nonce". If given, the results are returned in "batch_results" and the
+individual parameters must not be set.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -42,6 +83,104 @@ func (b *backend) pathEncrypt() *framework.Path {
 	}
 }
 
+// batchInput returns the items of the request. If batch_input is not set, a
+// single item is built from the given individual fields. The returned bool is
+// true if the request is a batch request.
+func batchInput(d *framework.FieldData, fields ...string) ([]*batchRequestItem, bool, error) {
+	batchRaw, ok := d.GetOk("batch_input")
+	if !ok {
+		single := map[string]interface{}{}
+		for _, field := range fields {
+			single[field] = d.Get(field)
+		}
+		var item batchRequestItem
+		if err := mapstructure.Decode(single, &item); err != nil {
+			return nil, false, err
+		}
+		return []*batchRequestItem{&item}, false, nil
+	}
+
+	for _, field := range fields {
+		if _, ok := d.GetOk(field); ok {
+			return nil, true, fmt.Errorf("%q cannot be used together with batch_input", field)
+		}
+	}
+
+	var items []*batchRequestItem
+	if err := mapstructure.Decode(batchRaw, &items); err != nil {
+		return nil, true, fmt.Errorf("failed to parse batch_input: %v", err)
+	}
+	if len(items) == 0 {
+		return nil, true, fmt.Errorf("missing batch_input to process")
+	}
+
+	return items, true, nil
+}
+
+// processBatch decodes the context and nonce of each item and runs f against
+// it. The errors of an item are recorded against it, so that one bad item does
+// not fail the others. Outside of a batch, errors other than user errors are
+// returned as is.
+func processBatch(batch bool, items []*batchRequestItem, f func(*batchRequestItem) (batchResponseItem, error)) ([]batchResponseItem, error) {
+	results := make([]batchResponseItem, len(items))
+	for i, item := range items {
+		var err error
+		if len(item.Context) != 0 {
+			item.decodedContext, err = base64.StdEncoding.DecodeString(item.Context)
+			if err != nil {
+				results[i].Error = "failed to decode context as base64"
+				continue
+			}
+		}
+		if len(item.Nonce) != 0 {
+			item.decodedNonce, err = base64.StdEncoding.DecodeString(item.Nonce)
+			if err != nil {
+				results[i].Error = "failed to decode nonce as base64"
+				continue
+			}
+		}
+
+		result, err := f(item)
+		if err != nil {
+			if _, ok := err.(certutil.UserError); !ok && !batch {
+				return nil, err
+			}
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+// batchResponse builds the response of a batch request, or of a single
+// request from its only result
+func batchResponse(batch bool, results []batchResponseItem, field string) *logical.Response {
+	if batch {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"batch_results": results,
+			},
+		}
+	}
+
+	result := results[0]
+	if result.Error != "" {
+		return logical.ErrorResponse(result.Error)
+	}
+
+	value := result.Ciphertext
+	if field == "plaintext" {
+		value = result.Plaintext
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			field: value,
+		},
+	}
+}
+
 func (b *backend) pathEncryptExistenceCheck(
 	req *logical.Request, d *framework.FieldData) (bool, error) {
 	name := d.Get("name").(string)
@@ -58,19 +197,22 @@ func (b *backend) pathEncryptExistenceCheck(
 func (b *backend) pathEncryptWrite(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	name := d.Get("name").(string)
-	value := d.Get("plaintext").(string)
-	if len(value) == 0 {
+
+	items, batch, err := batchInput(d, "plaintext", "context", "nonce")
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	if !batch && len(items[0].Plaintext) == 0 {
 		return logical.ErrorResponse("missing plaintext to encrypt"), logical.ErrInvalidRequest
 	}
 
-	// Decode the context if any
-	contextRaw := d.Get("context").(string)
-	var context []byte
-	var err error
-	if len(contextRaw) != 0 {
-		context, err = base64.StdEncoding.DecodeString(contextRaw)
-		if err != nil {
-			return logical.ErrorResponse("failed to decode context as base64"), logical.ErrInvalidRequest
+	// A key created by this request is derived if any of the items carries
+	// a context
+	derived := false
+	for _, item := range items {
+		if len(item.Context) != 0 {
+			derived = true
+			break
 		}
 	}
 
@@ -79,7 +221,12 @@ func (b *backend) pathEncryptWrite(
 	var lock *sync.RWMutex
 	var upserted bool
 	if req.Operation == logical.CreateOperation {
-		p, lock, upserted, err = b.lm.GetPolicyUpsert(req.Storage, name, len(context) != 0, false)
+		p, lock, upserted, err = b.lm.GetPolicyUpsert(policyRequest{
+			storage: req.Storage,
+			name:    name,
+			keyType: KeyType_AES256_GCM96,
+			derived: derived,
+		})
 	} else {
 		p, lock, err = b.lm.GetPolicyShared(req.Storage, name)
 	}
@@ -93,27 +240,29 @@ func (b *backend) pathEncryptWrite(
 		return logical.ErrorResponse("policy not found"), logical.ErrInvalidRequest
 	}
 
-	ciphertext, err := p.Encrypt(context, value)
-	if err != nil {
-		switch err.(type) {
-		case certutil.UserError:
-			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
-		case certutil.InternalError:
-			return nil, err
-		default:
-			return nil, err
+	results, err := processBatch(batch, items, func(item *batchRequestItem) (batchResponseItem, error) {
+		if len(item.Plaintext) == 0 {
+			return batchResponseItem{}, certutil.UserError{Err: "missing plaintext to encrypt"}
 		}
-	}
 
-	if ciphertext == "" {
-		return nil, fmt.Errorf("empty ciphertext returned")
+		ciphertext, err := p.Encrypt(item.decodedContext, item.decodedNonce, item.Plaintext)
+		if err != nil {
+			return batchResponseItem{}, err
+		}
+		if ciphertext == "" {
+			return batchResponseItem{}, fmt.Errorf("empty ciphertext returned")
+		}
+
+		return batchResponseItem{Ciphertext: ciphertext}, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Generate the response
-	resp := &logical.Response{
-		Data: map[string]interface{}{
-			"ciphertext": ciphertext,
-		},
+	resp := batchResponse(batch, results, "ciphertext")
+	if resp.IsError() {
+		return resp, logical.ErrInvalidRequest
 	}
 
 	if req.Operation == logical.CreateOperation && !upserted {
@@ -123,9 +272,14 @@ func (b *backend) pathEncryptWrite(
 	return resp, nil
 }
 
-const pathEncryptHelpSyn = `Encrypt a plaintext value using a named key`
+const pathEncryptHelpSyn = `Encrypt a plaintext value or a batch of plaintext values using a named key`
 
 const pathEncryptHelpDesc = `
 This path uses the named key from the request path to encrypt a user
 provided plaintext. The plaintext must be base64 encoded.
+
+Multiple plaintexts can be encrypted in one request by giving them in
+"batch_input". Each item of the batch is encrypted independently and the
+results, including any error for an individual item, are returned in
+"batch_results" in the same order.
 `
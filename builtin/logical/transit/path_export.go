@@ -0,0 +1,183 @@
+package transit
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	exportTypeEncryptionKey = "encryption-key"
+	exportTypeSigningKey    = "signing-key"
+	exportTypeHMACKey       = "hmac-key"
+)
+
+func (b *backend) pathExportKeys() *framework.Path {
+	return &framework.Path{
+		Pattern: "export/" + framework.GenericNameRegex("type") + "/" + framework.GenericNameRegex("name") + framework.OptionalParamRegex("version"),
+		Fields: map[string]*framework.FieldSchema{
+			"type": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `Type of key to export ("encryption-key", "signing-key" or "hmac-key")`,
+			},
+
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+
+			"version": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `Version of the key to export, or "latest". Defaults to all accessible versions.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathPolicyExportRead,
+		},
+
+		HelpSynopsis:    pathExportHelpSyn,
+		HelpDescription: pathExportHelpDesc,
+	}
+}
+
+func (b *backend) pathPolicyExportRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	exportType := d.Get("type").(string)
+	name := d.Get("name").(string)
+	version := d.Get("version").(string)
+
+	switch exportType {
+	case exportTypeEncryptionKey:
+	case exportTypeSigningKey:
+	case exportTypeHMACKey:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid export type: %s", exportType)), logical.ErrInvalidRequest
+	}
+
+	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, nil
+	}
+
+	if !p.Exportable {
+		return logical.ErrorResponse("key is not exportable"), logical.ErrInvalidRequest
+	}
+
+	switch exportType {
+	case exportTypeEncryptionKey:
+		if !p.Type.EncryptionSupported() {
+			return logical.ErrorResponse("encryption not supported for the key"), logical.ErrInvalidRequest
+		}
+	case exportTypeSigningKey:
+		if !p.Type.SigningSupported() {
+			return logical.ErrorResponse("signing not supported for the key"), logical.ErrInvalidRequest
+		}
+	}
+
+	retKeys := map[string]string{}
+	switch version {
+	case "":
+		for k := range p.Keys {
+			exportKey, err := getExportKey(p, k, exportType)
+			if err != nil {
+				return nil, err
+			}
+			retKeys[strconv.Itoa(k)] = exportKey
+		}
+
+	default:
+		var versionValue int
+		if version == "latest" {
+			versionValue = p.LatestVersion
+		} else {
+			version = strings.TrimPrefix(version, "v")
+			versionValue, err = strconv.Atoi(version)
+			if err != nil {
+				return logical.ErrorResponse("invalid key version"), logical.ErrInvalidRequest
+			}
+		}
+
+		if versionValue < p.MinDecryptionVersion {
+			return logical.ErrorResponse("version for export is below minimum decryption version"), logical.ErrInvalidRequest
+		}
+		if _, ok := p.Keys[versionValue]; !ok {
+			return logical.ErrorResponse("version does not exist or cannot be found"), logical.ErrInvalidRequest
+		}
+
+		exportKey, err := getExportKey(p, versionValue, exportType)
+		if err != nil {
+			return nil, err
+		}
+		retKeys[strconv.Itoa(versionValue)] = exportKey
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"name": p.Name,
+			"type": p.Type.String(),
+			"keys": retKeys,
+		},
+	}
+
+	return resp, nil
+}
+
+// getExportKey returns the key of the given type and version in its export
+// format: base64 for raw keys and PEM for ECDSA and RSA private keys
+func getExportKey(p *Policy, version int, exportType string) (string, error) {
+	key := p.Keys[version]
+
+	switch exportType {
+	case exportTypeHMACKey:
+		hmacKey, err := p.HMACKey(version)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(hmacKey), nil
+
+	case exportTypeEncryptionKey:
+		return base64.StdEncoding.EncodeToString(key.Key), nil
+
+	case exportTypeSigningKey:
+		switch p.Type {
+		case KeyType_ED25519:
+			return base64.StdEncoding.EncodeToString(key.Key), nil
+
+		case KeyType_ECDSA_P256:
+			return string(pem.EncodeToMemory(&pem.Block{
+				Type:  "EC PRIVATE KEY",
+				Bytes: key.Key,
+			})), nil
+
+		case KeyType_RSA2048, KeyType_RSA4096:
+			return string(pem.EncodeToMemory(&pem.Block{
+				Type:  "RSA PRIVATE KEY",
+				Bytes: key.Key,
+			})), nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown key type %v for export type %s", p.Type, exportType)
+}
+
+const pathExportHelpSyn = `Export named encryption, signing or HMAC keys`
+
+const pathExportHelpDesc = `
+This path is used to export the keys that are configured as exportable.
+The "encryption-key" type returns the raw key of encryption keys, the
+"signing-key" type returns the private key of signing keys and the
+"hmac-key" type returns the HMAC key of any key. Without a version, all
+versions that are still accessible are returned.
+`
@@ -0,0 +1,97 @@
+package transit
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathHash() *framework.Path {
+	return &framework.Path{
+		Pattern: "hash" + framework.OptionalParamRegex("urlalgorithm"),
+		Fields: map[string]*framework.FieldSchema{
+			"input": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The base64-encoded input data",
+			},
+
+			"algorithm": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: defaultHashAlgorithm,
+				Description: `Algorithm to use (POST body parameter). Valid values are:
+
+* sha2-224
+* sha2-256
+* sha2-384
+* sha2-512
+
+Defaults to "sha2-256".`,
+			},
+
+			"urlalgorithm": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `Algorithm to use (POST URL parameter)`,
+			},
+
+			"format": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "hex",
+				Description: `Encoding format to use. Can be "hex" or "base64". Defaults to "hex".`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathHashWrite,
+		},
+
+		HelpSynopsis:    pathHashHelpSyn,
+		HelpDescription: pathHashHelpDesc,
+	}
+}
+
+func (b *backend) pathHashWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	input, err := base64.StdEncoding.DecodeString(d.Get("input").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to decode input as base64: %s", err)), logical.ErrInvalidRequest
+	}
+
+	format := d.Get("format").(string)
+	switch format {
+	case "hex", "base64":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported encoding format %s; must be \"hex\" or \"base64\"", format)), nil
+	}
+
+	sum, _, err := hashInput(input, hashAlgorithm(d))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	// Generate the response
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"sum": encodeBytes(sum, format),
+		},
+	}
+	return resp, nil
+}
+
+// encodeBytes encodes the given bytes as either hex or base64
+func encodeBytes(input []byte, format string) string {
+	if format == "hex" {
+		return hex.EncodeToString(input)
+	}
+	return base64.StdEncoding.EncodeToString(input)
+}
+
+const pathHashHelpSyn = `Generate a hash sum for input data`
+
+const pathHashHelpDesc = `
+Generates a hash sum of the given algorithm against the given input data.
+No key is involved; this is a convenience for clients without access to the
+hash functions.
+`
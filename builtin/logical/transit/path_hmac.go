@@ -0,0 +1,102 @@
+package transit
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathHMAC() *framework.Path {
+	return &framework.Path{
+		Pattern: "hmac/" + framework.GenericNameRegex("name") + framework.OptionalParamRegex("urlalgorithm"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The key to use for the HMAC function",
+			},
+
+			"input": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The base64-encoded input data",
+			},
+
+			"algorithm": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: defaultHashAlgorithm,
+				Description: `Algorithm to use (POST body parameter). Valid values are:
+
+* sha2-224
+* sha2-256
+* sha2-384
+* sha2-512
+
+Defaults to "sha2-256".`,
+			},
+
+			"urlalgorithm": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `Algorithm to use (POST URL parameter)`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathHMACWrite,
+		},
+
+		HelpSynopsis:    pathHMACHelpSyn,
+		HelpDescription: pathHMACHelpDesc,
+	}
+}
+
+func (b *backend) pathHMACWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	algorithm := hashAlgorithm(d)
+
+	input, err := base64.StdEncoding.DecodeString(d.Get("input").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to decode input as base64: %s", err)), logical.ErrInvalidRequest
+	}
+
+	// Get the policy
+	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("policy not found"), logical.ErrInvalidRequest
+	}
+
+	hmac, err := p.HMAC(input, algorithm)
+	if err != nil {
+		switch err.(type) {
+		case certutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+
+	// Generate the response
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"hmac": hmac,
+		},
+	}
+	return resp, nil
+}
+
+const pathHMACHelpSyn = `Generate an HMAC for input data using the named key`
+
+const pathHMACHelpDesc = `
+Generates an HMAC of the input data using the named key and the given hash
+algorithm. The HMAC key is derived from the latest version of the named key and
+the output is prefixed with that version, so that it can still be verified
+through the "verify" endpoint after the key has been rotated.
+`
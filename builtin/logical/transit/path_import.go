@@ -0,0 +1,238 @@
+package transit
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/helper/keywrap"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const defaultImportHashFunction = "SHA256"
+
+func importFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"name": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Name of the key",
+		},
+
+		"ciphertext": &framework.FieldSchema{
+			Type: framework.TypeString,
+			Description: `The base64-encoded key material to import, wrapped with the
+wrapping key of the mount. It consists of an ephemeral AES key wrapped with
+RSA-OAEP using the public key from "wrapping_key", followed by the key
+material wrapped with the ephemeral AES key using AES key wrap with padding
+(RFC 5649).`,
+		},
+
+		"hash_function": &framework.FieldSchema{
+			Type:    framework.TypeString,
+			Default: defaultImportHashFunction,
+			Description: `The hash function used for RSA-OAEP when wrapping the
+ephemeral AES key. One of "SHA1", "SHA224", "SHA256", "SHA384" or "SHA512".
+Defaults to "SHA256".`,
+		},
+	}
+}
+
+func (b *backend) pathImport() *framework.Path {
+	fields := importFields()
+	fields["type"] = &framework.FieldSchema{
+		Type:    framework.TypeString,
+		Default: "aes256-gcm96",
+		Description: `The type of the imported key. Currently,
+"aes256-gcm96", "ecdsa-p256", "ed25519", "rsa-2048" and
+"rsa-4096" are supported. Defaults to "aes256-gcm96".`,
+	}
+	fields["derived"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Enables key derivation mode. This allows for per-transaction unique keys",
+	}
+	fields["convergent_encryption"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Whether to use convergent encryption. Requires key derivation.",
+	}
+	fields["exportable"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Enables keys to be exportable. Once set, this cannot be disabled.",
+	}
+	fields["allow_plaintext_backup"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Enables taking a backup of the key in plaintext format. Once set, this cannot be disabled.",
+	}
+
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/import",
+		Fields:  fields,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathImportWrite,
+		},
+
+		HelpSynopsis:    pathImportHelpSyn,
+		HelpDescription: pathImportHelpDesc,
+	}
+}
+
+func (b *backend) pathImportVersion() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/import_version",
+		Fields:  importFields(),
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathImportVersionWrite,
+		},
+
+		HelpSynopsis:    pathImportVersionHelpSyn,
+		HelpDescription: pathImportVersionHelpDesc,
+	}
+}
+
+func (b *backend) pathImportWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	derived := d.Get("derived").(bool)
+	convergent := d.Get("convergent_encryption").(bool)
+
+	keyType, err := parseKeyType(d.Get("type").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	key, err := b.unwrapImportedKey(req.Storage, d)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = b.lm.ImportPolicy(policyRequest{
+		storage:    req.Storage,
+		name:       name,
+		keyType:    keyType,
+		derived:    derived,
+		convergent: convergent,
+
+		exportable:           d.Get("exportable").(bool),
+		allowPlaintextBackup: d.Get("allow_plaintext_backup").(bool),
+	}, key)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathImportVersionWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	key, err := b.unwrapImportedKey(req.Storage, d)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	p, lock, err := b.lm.GetPolicyExclusive(req.Storage, name)
+	if lock != nil {
+		defer lock.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+
+	if err := p.importVersion(req.Storage, key); err != nil {
+		return errorResponse(err)
+	}
+
+	return nil, nil
+}
+
+// unwrapImportedKey returns the key material of an import request, unwrapped
+// with the wrapping key of the mount
+func (b *backend) unwrapImportedKey(storage logical.Storage, d *framework.FieldData) ([]byte, error) {
+	ciphertextRaw := d.Get("ciphertext").(string)
+	if ciphertextRaw == "" {
+		return nil, certutil.UserError{Err: "missing ciphertext of the key to import"}
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(ciphertextRaw)
+	if err != nil {
+		return nil, certutil.UserError{Err: "failed to decode ciphertext as base64"}
+	}
+
+	var hash crypto.Hash
+	switch strings.ToUpper(d.Get("hash_function").(string)) {
+	case "SHA1":
+		hash = crypto.SHA1
+	case "SHA224":
+		hash = crypto.SHA224
+	case "SHA256":
+		hash = crypto.SHA256
+	case "SHA384":
+		hash = crypto.SHA384
+	case "SHA512":
+		hash = crypto.SHA512
+	default:
+		return nil, certutil.UserError{Err: fmt.Sprintf("unsupported hash function %q", d.Get("hash_function").(string))}
+	}
+
+	wrappingKey, err := b.getWrappingKey(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// The ciphertext starts with the wrapped ephemeral key, which is as long
+	// as the modulus of the wrapping key
+	wrappedKeySize := (wrappingKey.N.BitLen() + 7) / 8
+	if len(ciphertext) <= wrappedKeySize {
+		return nil, certutil.UserError{Err: "ciphertext is too short"}
+	}
+
+	ephemeralKey, err := rsa.DecryptOAEP(hash.New(), rand.Reader, wrappingKey, ciphertext[:wrappedKeySize], nil)
+	if err != nil {
+		return nil, certutil.UserError{Err: "failed to unwrap the ephemeral key"}
+	}
+
+	key, err := keywrap.UnwrapWithPadding(ephemeralKey, ciphertext[wrappedKeySize:])
+	if err != nil {
+		return nil, certutil.UserError{Err: fmt.Sprintf("failed to unwrap the key: %v", err)}
+	}
+
+	return key, nil
+}
+
+// errorResponse turns user errors into error responses and passes through
+// any other error
+func errorResponse(err error) (*logical.Response, error) {
+	switch err.(type) {
+	case certutil.UserError:
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	default:
+		return nil, err
+	}
+}
+
+const pathImportHelpSyn = `Imports an externally generated key as a new named key`
+
+const pathImportHelpDesc = `
+This path is used to import key material generated outside of Vault as the
+first version of a new named key. The key material must be wrapped with the
+public key returned by "wrapping_key". Imported keys are versioned, rotated
+and configured like any other key.
+`
+
+const pathImportVersionHelpSyn = `Imports an externally generated key as a new version of a named key`
+
+const pathImportVersionHelpDesc = `
+This path is used to import key material generated outside of Vault as the
+new latest version of an existing named key. The key material must be of the
+type of the named key and wrapped with the public key returned by
+"wrapping_key".
+`
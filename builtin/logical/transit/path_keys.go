@@ -17,6 +17,16 @@ func (b *backend) pathKeys() *framework.Path {
 				Description: "Name of the key",
 			},
 
+			"type": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "aes256-gcm96",
+				Description: `The type of key to create. Currently,
+"aes256-gcm96" (symmetric), "ecdsa-p256" (asymmetric),
+"ed25519" (asymmetric), "rsa-2048" (asymmetric) and
+"rsa-4096" (asymmetric) are supported. Defaults to
+"aes256-gcm96".`,
+			},
+
 			"derived": &framework.FieldSchema{
 				Type:        framework.TypeBool,
 				Description: "Enables key derivation mode. This allows for per-transaction unique keys",
@@ -36,6 +46,21 @@ this mode that you ensure that all contexts
 are *globally unique*. Failing to do so will
 severely impact the security of the key.`,
 			},
+
+			"exportable": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Enables keys to be exportable.
+This allows for all the valid keys
+in the key ring to be exported. Once
+set, this cannot be disabled.`,
+			},
+
+			"allow_plaintext_backup": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Enables taking a backup of the
+named key in plaintext format. Once
+set, this cannot be disabled.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -54,12 +79,32 @@ func (b *backend) pathPolicyWrite(
 	name := d.Get("name").(string)
 	derived := d.Get("derived").(bool)
 	convergent := d.Get("convergent_encryption").(bool)
+	exportable := d.Get("exportable").(bool)
+	allowPlaintextBackup := d.Get("allow_plaintext_backup").(bool)
+
+	keyType, err := parseKeyType(d.Get("type").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
 
 	if !derived && convergent {
 		return logical.ErrorResponse("convergent encryption requires derivation to be enabled"), nil
 	}
 
-	p, lock, upserted, err := b.lm.GetPolicyUpsert(req.Storage, name, derived, convergent)
+	if derived && !keyType.DerivationSupported() {
+		return logical.ErrorResponse(fmt.Sprintf("key derivation not supported for keys of type %v", keyType)), logical.ErrInvalidRequest
+	}
+
+	p, lock, upserted, err := b.lm.GetPolicyUpsert(policyRequest{
+		storage:    req.Storage,
+		name:       name,
+		keyType:    keyType,
+		derived:    derived,
+		convergent: convergent,
+
+		exportable:           exportable,
+		allowPlaintextBackup: allowPlaintextBackup,
+	})
 	if lock != nil {
 		defer lock.RUnlock()
 	}
@@ -97,11 +142,19 @@ func (b *backend) pathPolicyRead(
 	resp := &logical.Response{
 		Data: map[string]interface{}{
 			"name":                   p.Name,
+			"type":                   p.Type.String(),
 			"cipher_mode":            p.CipherMode,
 			"derived":                p.Derived,
 			"deletion_allowed":       p.DeletionAllowed,
+			"exportable":             p.Exportable,
+			"allow_plaintext_backup": p.AllowPlaintextBackup,
+			"imported_key":           p.Imported,
 			"min_decryption_version": p.MinDecryptionVersion,
 			"latest_version":         p.LatestVersion,
+			"supports_encryption":    p.Type.EncryptionSupported(),
+			"supports_decryption":    p.Type.DecryptionSupported(),
+			"supports_signing":       p.Type.SigningSupported(),
+			"supports_derivation":    p.Type.DerivationSupported(),
 		},
 	}
 	if p.Derived {
@@ -109,11 +162,26 @@ func (b *backend) pathPolicyRead(
 		resp.Data["convergent_encryption"] = p.ConvergentEncryption
 	}
 
-	retKeys := map[string]int64{}
-	for k, v := range p.Keys {
-		retKeys[strconv.Itoa(k)] = v.CreationTime
+	switch {
+	case p.Type.SigningSupported():
+		// Asymmetric keys return the public key of every version so that
+		// signatures can be verified outside of Vault
+		retKeys := map[string]map[string]interface{}{}
+		for k, v := range p.Keys {
+			retKeys[strconv.Itoa(k)] = map[string]interface{}{
+				"creation_time": v.CreationTime,
+				"public_key":    v.FormattedPublicKey,
+			}
+		}
+		resp.Data["keys"] = retKeys
+
+	default:
+		retKeys := map[string]int64{}
+		for k, v := range p.Keys {
+			retKeys[strconv.Itoa(k)] = v.CreationTime
+		}
+		resp.Data["keys"] = retKeys
 	}
-	resp.Data["keys"] = retKeys
 
 	return resp, nil
 }
@@ -136,5 +204,8 @@ const pathPolicyHelpSyn = `Managed named encryption keys`
 const pathPolicyHelpDesc = `
 This path is used to manage the named keys that are available.
 Doing a write with no value against a new named key will create
-it using a randomly generated key.
+it using a randomly generated key. The "type" parameter selects
+between a symmetric encryption key and an asymmetric signing key.
+Reading an asymmetric key returns the public key of every version
+that is still accessible.
 `
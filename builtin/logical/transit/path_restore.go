@@ -0,0 +1,58 @@
+package transit
+
+import (
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathRestore() *framework.Path {
+	return &framework.Path{
+		Pattern: "restore" + framework.OptionalParamRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"backup": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The backup of the key, as returned by the backup path",
+			},
+
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "If set, the name to restore the key under. Defaults to the name of the backed up key.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathRestoreUpdate,
+		},
+
+		HelpSynopsis:    pathRestoreHelpSyn,
+		HelpDescription: pathRestoreHelpDesc,
+	}
+}
+
+func (b *backend) pathRestoreUpdate(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	backup := d.Get("backup").(string)
+	if backup == "" {
+		return logical.ErrorResponse("missing backup"), logical.ErrInvalidRequest
+	}
+
+	err := b.lm.RestorePolicy(req.Storage, d.Get("name").(string), backup)
+	if err != nil {
+		switch err.(type) {
+		case certutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+const pathRestoreHelpSyn = `Restore the named key`
+
+const pathRestoreHelpDesc = `
+This path is used to restore a key from a backup created through the
+"backup" path. An existing key is never overwritten.
+`
@@ -1,7 +1,6 @@
 package transit
 
 import (
-	"encoding/base64"
 	"fmt"
 
 	"github.com/hashicorp/vault/helper/certutil"
@@ -27,6 +26,20 @@ func (b *backend) pathRewrap() *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Context for key derivation. Required for derived keys.",
 			},
+
+			"nonce": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Nonce for convergent encryption of the rewrapped value. Only allowed for
+keys using convergent encryption. If not given, the context is used as the
+nonce.`,
+			},
+
+			"batch_input": &framework.FieldSchema{
+				Type: framework.TypeSlice,
+				Description: `List of items to rewrap, each holding its own "ciphertext", "context"
+and "nonce". If given, the results are returned in "batch_results" and the
+individual parameters must not be set.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -42,20 +55,12 @@ func (b *backend) pathRewrapWrite(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	name := d.Get("name").(string)
 
-	value := d.Get("ciphertext").(string)
-	if len(value) == 0 {
-		return logical.ErrorResponse("missing ciphertext to decrypt"), logical.ErrInvalidRequest
+	items, batch, err := batchInput(d, "ciphertext", "context", "nonce")
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 	}
-
-	// Decode the context if any
-	contextRaw := d.Get("context").(string)
-	var context []byte
-	if len(contextRaw) != 0 {
-		var err error
-		context, err = base64.StdEncoding.DecodeString(contextRaw)
-		if err != nil {
-			return logical.ErrorResponse("failed to decode context as base64"), logical.ErrInvalidRequest
-		}
+	if !batch && len(items[0].Ciphertext) == 0 {
+		return logical.ErrorResponse("missing ciphertext to decrypt"), logical.ErrInvalidRequest
 	}
 
 	// Get the policy
@@ -71,43 +76,37 @@ func (b *backend) pathRewrapWrite(
 		return logical.ErrorResponse("policy not found"), logical.ErrInvalidRequest
 	}
 
-	plaintext, err := p.Decrypt(context, value)
-	if err != nil {
-		switch err.(type) {
-		case certutil.UserError:
-			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
-		case certutil.InternalError:
-			return nil, err
-		default:
-			return nil, err
+	results, err := processBatch(batch, items, func(item *batchRequestItem) (batchResponseItem, error) {
+		if len(item.Ciphertext) == 0 {
+			return batchResponseItem{}, certutil.UserError{Err: "missing ciphertext to decrypt"}
 		}
-	}
 
-	if plaintext == "" {
-		return nil, fmt.Errorf("empty plaintext returned during rewrap")
-	}
+		plaintext, err := p.Decrypt(item.decodedContext, item.Ciphertext)
+		if err != nil {
+			return batchResponseItem{}, err
+		}
+		if plaintext == "" {
+			return batchResponseItem{}, fmt.Errorf("empty plaintext returned during rewrap")
+		}
 
-	ciphertext, err := p.Encrypt(context, plaintext)
-	if err != nil {
-		switch err.(type) {
-		case certutil.UserError:
-			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
-		case certutil.InternalError:
-			return nil, err
-		default:
-			return nil, err
+		ciphertext, err := p.Encrypt(item.decodedContext, item.decodedNonce, plaintext)
+		if err != nil {
+			return batchResponseItem{}, err
+		}
+		if ciphertext == "" {
+			return batchResponseItem{}, fmt.Errorf("empty ciphertext returned")
 		}
-	}
 
-	if ciphertext == "" {
-		return nil, fmt.Errorf("empty ciphertext returned")
+		return batchResponseItem{Ciphertext: ciphertext}, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Generate the response
-	resp := &logical.Response{
-		Data: map[string]interface{}{
-			"ciphertext": ciphertext,
-		},
+	resp := batchResponse(batch, results, "ciphertext")
+	if resp.IsError() {
+		return resp, logical.ErrInvalidRequest
 	}
 	return resp, nil
 }
@@ -119,4 +118,9 @@ After key rotation, this function can be used to rewrap the
 given ciphertext with the latest version of the named key.
 If the given ciphertext is already using the latest version
 of the key, this function is a no-op.
+
+Multiple ciphertexts can be rewrapped in one request by giving
+them in "batch_input". The results, including any error for an
+individual item, are returned in "batch_results" in the same
+order.
 `
@@ -0,0 +1,233 @@
+package transit
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func (b *backend) pathSign() *framework.Path {
+	return &framework.Path{
+		Pattern: "sign/" + framework.GenericNameRegex("name") + framework.OptionalParamRegex("urlalgorithm"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+
+			"input": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The base64-encoded input data",
+			},
+
+			"algorithm": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: defaultHashAlgorithm,
+				Description: `Hash algorithm to use (POST body parameter). Valid values are:
+
+* sha2-224
+* sha2-256
+* sha2-384
+* sha2-512
+
+Defaults to "sha2-256". Not valid for all key types,
+including ed25519.`,
+			},
+
+			"urlalgorithm": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `Hash algorithm to use (POST URL parameter)`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathSignWrite,
+		},
+
+		HelpSynopsis:    pathSignHelpSyn,
+		HelpDescription: pathSignHelpDesc,
+	}
+}
+
+func (b *backend) pathVerify() *framework.Path {
+	return &framework.Path{
+		Pattern: "verify/" + framework.GenericNameRegex("name") + framework.OptionalParamRegex("urlalgorithm"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+
+			"signature": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The signature, including vault header/key version",
+			},
+
+			"hmac": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The HMAC, including vault header/key version",
+			},
+
+			"input": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The base64-encoded input data to verify",
+			},
+
+			"urlalgorithm": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `Hash algorithm to use (POST URL parameter)`,
+			},
+
+			"algorithm": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: defaultHashAlgorithm,
+				Description: `Hash algorithm to use (POST body parameter). Valid values are:
+
+* sha2-224
+* sha2-256
+* sha2-384
+* sha2-512
+
+Defaults to "sha2-256". Not valid for all key types,
+including ed25519.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathVerifyWrite,
+		},
+
+		HelpSynopsis:    pathVerifyHelpSyn,
+		HelpDescription: pathVerifyHelpDesc,
+	}
+}
+
+// hashAlgorithm returns the hash algorithm of a sign or verify request,
+// preferring the one given in the URL
+func hashAlgorithm(d *framework.FieldData) string {
+	algorithm := d.Get("urlalgorithm").(string)
+	if algorithm == "" {
+		algorithm = d.Get("algorithm").(string)
+	}
+	return algorithm
+}
+
+func (b *backend) pathSignWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	algorithm := hashAlgorithm(d)
+
+	input, err := base64.StdEncoding.DecodeString(d.Get("input").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to decode input as base64: %s", err)), logical.ErrInvalidRequest
+	}
+
+	// Get the policy
+	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("policy not found"), logical.ErrInvalidRequest
+	}
+
+	signature, err := p.Sign(input, algorithm)
+	if err != nil {
+		switch err.(type) {
+		case certutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+
+	if signature == "" {
+		return nil, fmt.Errorf("signature could not be computed")
+	}
+
+	// Generate the response
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"signature": signature,
+		},
+	}
+	return resp, nil
+}
+
+func (b *backend) pathVerifyWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	algorithm := hashAlgorithm(d)
+
+	signature := d.Get("signature").(string)
+	hmac := d.Get("hmac").(string)
+	switch {
+	case signature != "" && hmac != "":
+		return logical.ErrorResponse("provide one of 'signature' or 'hmac'"), logical.ErrInvalidRequest
+	case signature == "" && hmac == "":
+		return logical.ErrorResponse("missing 'signature' or 'hmac' to verify"), logical.ErrInvalidRequest
+	}
+
+	input, err := base64.StdEncoding.DecodeString(d.Get("input").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to decode input as base64: %s", err)), logical.ErrInvalidRequest
+	}
+
+	// Get the policy
+	p, lock, err := b.lm.GetPolicyShared(req.Storage, name)
+	if lock != nil {
+		defer lock.RUnlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return logical.ErrorResponse("policy not found"), logical.ErrInvalidRequest
+	}
+
+	var valid bool
+	if hmac != "" {
+		valid, err = p.VerifyHMAC(input, hmac, algorithm)
+	} else {
+		valid, err = p.VerifySignature(input, signature, algorithm)
+	}
+	if err != nil {
+		switch err.(type) {
+		case certutil.UserError:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		default:
+			return nil, err
+		}
+	}
+
+	// Generate the response
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"valid": valid,
+		},
+	}
+	return resp, nil
+}
+
+const pathSignHelpSyn = `Generate a signature for input data using the named key`
+
+const pathSignHelpDesc = `
+Generates a signature of the input data using the named key and the given hash
+algorithm. The key must be of a type supporting signing. The signature is
+prefixed with the version of the key used to create it, so that it can still be
+verified after the key has been rotated.
+`
+
+const pathVerifyHelpSyn = `Verify a signature or HMAC for input data created using the named key`
+
+const pathVerifyHelpDesc = `
+Verifies a signature or HMAC of the input data using the named key and the
+given hash algorithm. The version of the key used is taken from the signature
+or HMAC, and must not be older than the minimum decryption version of the key.
+`
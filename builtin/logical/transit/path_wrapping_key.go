@@ -0,0 +1,110 @@
+package transit
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	// wrappingKeyPath is the storage path of the wrapping key
+	wrappingKeyPath = "config/wrapping_key"
+
+	// wrappingKeyBits is the size of the RSA wrapping key
+	wrappingKeyBits = 4096
+)
+
+// wrappingKeyEntry is the stored wrapping key of the mount
+type wrappingKeyEntry struct {
+	// Key is the PKCS #1 encoded private key
+	Key []byte `json:"key"`
+}
+
+func (b *backend) pathWrappingKey() *framework.Path {
+	return &framework.Path{
+		Pattern: "wrapping_key",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathWrappingKeyRead,
+		},
+
+		HelpSynopsis:    pathWrappingKeyHelpSyn,
+		HelpDescription: pathWrappingKeyHelpDesc,
+	}
+}
+
+func (b *backend) pathWrappingKeyRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key, err := b.getWrappingKey(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	derBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling public key: %v", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": string(pem.EncodeToMemory(&pem.Block{
+				Type:  "PUBLIC KEY",
+				Bytes: derBytes,
+			})),
+		},
+	}, nil
+}
+
+// getWrappingKey returns the wrapping key of the mount, generating it on first
+// use
+func (b *backend) getWrappingKey(storage logical.Storage) (*rsa.PrivateKey, error) {
+	b.wrappingKeyLock.Lock()
+	defer b.wrappingKeyLock.Unlock()
+
+	raw, err := storage.Get(wrappingKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw != nil {
+		var entry wrappingKeyEntry
+		if err := json.Unmarshal(raw.Value, &entry); err != nil {
+			return nil, err
+		}
+		return x509.ParsePKCS1PrivateKey(entry.Key)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, wrappingKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("error generating wrapping key: %v", err)
+	}
+
+	buf, err := json.Marshal(&wrappingKeyEntry{
+		Key: x509.MarshalPKCS1PrivateKey(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.Put(&logical.StorageEntry{
+		Key:   wrappingKeyPath,
+		Value: buf,
+	}); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+const pathWrappingKeyHelpSyn = `Returns the public key to use for wrapping imported keys`
+
+const pathWrappingKeyHelpDesc = `
+This path is used to retrieve the RSA-4096 public key of this mount, which is
+used to wrap key material that is imported through "keys/<name>/import" or
+"keys/<name>/import_version". The key is generated on first use.
+`
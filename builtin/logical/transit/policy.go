@@ -1,16 +1,28 @@
 package transit
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/crypto/ed25519"
+
 	"github.com/hashicorp/vault/helper/certutil"
 	"github.com/hashicorp/vault/helper/kdf"
 	"github.com/hashicorp/vault/logical"
@@ -21,12 +33,109 @@ const (
 	kdfMode = "hmac-sha256-counter"
 
 	ErrTooOld = "ciphertext version is disallowed by policy (too old)"
+
+	// defaultHashAlgorithm is the hash used for signatures if none is given
+	defaultHashAlgorithm = "sha2-256"
+
+	// hmacKeyContext is the context used to derive the HMAC key of a version
+	// from its key material
+	hmacKeyContext = "transit-hmac-key"
 )
 
+// KeyType is the type of the key material held by a policy
+type KeyType int
+
+const (
+	KeyType_AES256_GCM96 KeyType = iota
+	KeyType_ECDSA_P256
+	KeyType_ED25519
+	KeyType_RSA2048
+	KeyType_RSA4096
+)
+
+// parseKeyType returns the key type for the given name
+func parseKeyType(name string) (KeyType, error) {
+	switch name {
+	case "aes256-gcm96":
+		return KeyType_AES256_GCM96, nil
+	case "ecdsa-p256":
+		return KeyType_ECDSA_P256, nil
+	case "ed25519":
+		return KeyType_ED25519, nil
+	case "rsa-2048":
+		return KeyType_RSA2048, nil
+	case "rsa-4096":
+		return KeyType_RSA4096, nil
+	default:
+		return 0, fmt.Errorf("unknown key type %q", name)
+	}
+}
+
+func (kt KeyType) EncryptionSupported() bool {
+	switch kt {
+	case KeyType_AES256_GCM96:
+		return true
+	}
+	return false
+}
+
+func (kt KeyType) DecryptionSupported() bool {
+	switch kt {
+	case KeyType_AES256_GCM96:
+		return true
+	}
+	return false
+}
+
+func (kt KeyType) SigningSupported() bool {
+	switch kt {
+	case KeyType_ECDSA_P256, KeyType_ED25519, KeyType_RSA2048, KeyType_RSA4096:
+		return true
+	}
+	return false
+}
+
+func (kt KeyType) DerivationSupported() bool {
+	switch kt {
+	case KeyType_AES256_GCM96:
+		return true
+	}
+	return false
+}
+
+func (kt KeyType) String() string {
+	switch kt {
+	case KeyType_AES256_GCM96:
+		return "aes256-gcm96"
+	case KeyType_ECDSA_P256:
+		return "ecdsa-p256"
+	case KeyType_ED25519:
+		return "ed25519"
+	case KeyType_RSA2048:
+		return "rsa-2048"
+	case KeyType_RSA4096:
+		return "rsa-4096"
+	}
+
+	return "[unknown]"
+}
+
 // KeyEntry stores the key and metadata
 type KeyEntry struct {
+	// Key holds the raw key for AES keys and the private key for asymmetric
+	// keys: the seed and public key for ed25519, the SEC 1 encoding for ECDSA
+	// and the PKCS #1 encoding for RSA.
 	Key          []byte `json:"key"`
 	CreationTime int64  `json:"creation_time"`
+
+	// FormattedPublicKey is the public key of asymmetric keys, PEM encoded
+	// for ECDSA and RSA and base64 encoded for ed25519
+	FormattedPublicKey string `json:"public_key,omitempty"`
+}
+
+// ecdsaSignature is the ASN.1 structure of ECDSA signatures
+type ecdsaSignature struct {
+	R, S *big.Int
 }
 
 // KeyEntryMap is used to allow JSON marshal/unmarshal
@@ -66,9 +175,13 @@ type Policy struct {
 	Keys       KeyEntryMap `json:"keys"`
 	CipherMode string      `json:"cipher"`
 
+	// The type of the key material. Policies written before key types were
+	// introduced unmarshal to the zero value, which is AES256-GCM96.
+	Type KeyType `json:"type"`
+
 	// Derived keys MUST provide a context and the master underlying key is
 	// never used. If convergent encryption is true, the context will be used
-	// as the nonce as well.
+	// as the nonce as well, unless a nonce is given explicitly.
 	Derived              bool   `json:"derived"`
 	KDFMode              string `json:"kdf_mode"`
 	ConvergentEncryption bool   `json:"convergent_encryption"`
@@ -86,6 +199,18 @@ type Policy struct {
 
 	// Whether the key is allowed to be deleted
 	DeletionAllowed bool `json:"deletion_allowed"`
+
+	// Whether the key material can be exported. Once enabled, this cannot be
+	// disabled again.
+	Exportable bool `json:"exportable"`
+
+	// Whether the key can be backed up, which includes its key material in
+	// plaintext. Once enabled, this cannot be disabled again.
+	AllowPlaintextBackup bool `json:"allow_plaintext_backup"`
+
+	// Whether the first version of the key was imported rather than
+	// generated by Vault
+	Imported bool `json:"imported_key"`
 }
 
 // ArchivedKeys stores old keys. This is used to keep the key loading time sane
@@ -335,7 +460,14 @@ func (p *Policy) DeriveKey(context []byte, ver int) ([]byte, error) {
 	}
 }
 
-func (p *Policy) Encrypt(context []byte, value string) (string, error) {
+// Encrypt encrypts the base64-encoded value with the latest version of the
+// key. The nonce may only be given for convergent keys; if it is empty, the
+// context is used as the nonce.
+func (p *Policy) Encrypt(context, nonce []byte, value string) (string, error) {
+	if !p.Type.EncryptionSupported() {
+		return "", certutil.UserError{Err: fmt.Sprintf("message encryption not supported for key type %v", p.Type)}
+	}
+
 	// Decode the plaintext value
 	plaintext, err := base64.StdEncoding.DecodeString(value)
 	if err != nil {
@@ -367,15 +499,21 @@ func (p *Policy) Encrypt(context []byte, value string) (string, error) {
 		return "", certutil.InternalError{Err: err.Error()}
 	}
 
-	if p.ConvergentEncryption && len(context) != gcm.NonceSize() {
-		return "", certutil.UserError{Err: fmt.Sprintf("base64-decoded context must be %d bytes long when using convergent encryption with this key", gcm.NonceSize())}
+	if len(nonce) != 0 && !p.ConvergentEncryption {
+		return "", certutil.UserError{Err: "a nonce can only be provided when using convergent encryption"}
 	}
 
-	// Compute random nonce
-	var nonce []byte
 	if p.ConvergentEncryption {
-		nonce = context
+		if len(nonce) == 0 {
+			if len(context) != gcm.NonceSize() {
+				return "", certutil.UserError{Err: fmt.Sprintf("base64-decoded context must be %d bytes long when using convergent encryption with this key", gcm.NonceSize())}
+			}
+			nonce = context
+		} else if len(nonce) != gcm.NonceSize() {
+			return "", certutil.UserError{Err: fmt.Sprintf("base64-decoded nonce must be %d bytes long when using convergent encryption with this key", gcm.NonceSize())}
+		}
 	} else {
+		// Compute random nonce
 		nonce = make([]byte, gcm.NonceSize())
 		_, err = rand.Read(nonce)
 		if err != nil {
@@ -399,29 +537,13 @@ func (p *Policy) Encrypt(context []byte, value string) (string, error) {
 }
 
 func (p *Policy) Decrypt(context []byte, value string) (string, error) {
-	// Verify the prefix
-	if !strings.HasPrefix(value, "vault:v") {
-		return "", certutil.UserError{Err: "invalid ciphertext: no prefix"}
-	}
-
-	splitVerCiphertext := strings.SplitN(strings.TrimPrefix(value, "vault:v"), ":", 2)
-	if len(splitVerCiphertext) != 2 {
-		return "", certutil.UserError{Err: "invalid ciphertext: wrong number of fields"}
+	if !p.Type.DecryptionSupported() {
+		return "", certutil.UserError{Err: fmt.Sprintf("message decryption not supported for key type %v", p.Type)}
 	}
 
-	ver, err := strconv.Atoi(splitVerCiphertext[0])
+	ver, encoded, err := p.parseVersionedValue("ciphertext", value)
 	if err != nil {
-		return "", certutil.UserError{Err: "invalid ciphertext: version number could not be decoded"}
-	}
-
-	if ver == 0 {
-		// Compatibility mode with initial implementation, where keys start at
-		// zero
-		ver = 1
-	}
-
-	if ver > p.LatestVersion {
-		return "", certutil.UserError{Err: "invalid ciphertext: version is too new"}
+		return "", err
 	}
 
 	if p.MinDecryptionVersion > 0 && ver < p.MinDecryptionVersion {
@@ -442,7 +564,7 @@ func (p *Policy) Decrypt(context []byte, value string) (string, error) {
 	}
 
 	// Decode the base64
-	decoded, err := base64.StdEncoding.DecodeString(splitVerCiphertext[1])
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
 		return "", certutil.UserError{Err: "invalid ciphertext: could not decode base64"}
 	}
@@ -473,27 +595,40 @@ func (p *Policy) Decrypt(context []byte, value string) (string, error) {
 }
 
 func (p *Policy) rotate(storage logical.Storage) error {
-	if p.Keys == nil {
-		// This is an initial key rotation when generating a new policy. We
-		// don't need to call migrate here because if we've called getPolicy to
-		// get the policy in the first place it will have been run.
-		p.Keys = KeyEntryMap{}
+	entry, err := generateKeyEntry(p.Type)
+	if err != nil {
+		return err
 	}
 
-	// Generate a 256bit key
-	newKey := make([]byte, 32)
-	_, err := rand.Read(newKey)
+	return p.addVersion(storage, entry)
+}
+
+// importVersion adds the given imported key material as the new latest
+// version of the key
+func (p *Policy) importVersion(storage logical.Storage, key []byte) error {
+	entry, err := importKeyEntry(p.Type, key)
 	if err != nil {
 		return err
 	}
 
-	p.LatestVersion += 1
+	return p.addVersion(storage, entry)
+}
 
-	p.Keys[p.LatestVersion] = KeyEntry{
-		Key:          newKey,
-		CreationTime: time.Now().Unix(),
+// addVersion adds the key entry as the new latest version and persists the
+// policy
+func (p *Policy) addVersion(storage logical.Storage, entry *KeyEntry) error {
+	if p.Keys == nil {
+		// This is the initial version when generating or importing a new
+		// policy. We don't need to call migrate here because if we've called
+		// getPolicy to get the policy in the first place it will have been
+		// run.
+		p.Keys = KeyEntryMap{}
 	}
 
+	p.LatestVersion += 1
+
+	p.Keys[p.LatestVersion] = *entry
+
 	// This ensures that with new key creations min decryption version is set
 	// to 1 rather than the int default of 0, since keys start at 1 (either
 	// fresh or after migration to the key map)
@@ -501,8 +636,6 @@ func (p *Policy) rotate(storage logical.Storage) error {
 		p.MinDecryptionVersion = 1
 	}
 
-	//fmt.Printf("policy %s rotated to %d\n", p.Name, p.LatestVersion)
-
 	return p.Persist(storage)
 }
 
@@ -515,3 +648,411 @@ func (p *Policy) migrateKeyToKeysMap() {
 	}
 	p.Key = nil
 }
+
+// parseVersionedValue splits a value of the form "vault:v<version>:<data>"
+// into its version and data. The kind of value is used in error messages.
+func (p *Policy) parseVersionedValue(kind, value string) (int, string, error) {
+	// Verify the prefix
+	if !strings.HasPrefix(value, "vault:v") {
+		return 0, "", certutil.UserError{Err: fmt.Sprintf("invalid %s: no prefix", kind)}
+	}
+
+	splitVerValue := strings.SplitN(strings.TrimPrefix(value, "vault:v"), ":", 2)
+	if len(splitVerValue) != 2 {
+		return 0, "", certutil.UserError{Err: fmt.Sprintf("invalid %s: wrong number of fields", kind)}
+	}
+
+	ver, err := strconv.Atoi(splitVerValue[0])
+	if err != nil {
+		return 0, "", certutil.UserError{Err: fmt.Sprintf("invalid %s: version number could not be decoded", kind)}
+	}
+
+	if ver == 0 {
+		// Compatibility mode with initial implementation, where keys start at
+		// zero
+		ver = 1
+	}
+
+	if ver > p.LatestVersion {
+		return 0, "", certutil.UserError{Err: fmt.Sprintf("invalid %s: version is too new", kind)}
+	}
+
+	return ver, splitVerValue[1], nil
+}
+
+// HMACKey returns the HMAC key of the given version. It is derived from the
+// key material of the version, so it rotates along with the key and works for
+// every key type.
+func (p *Policy) HMACKey(version int) ([]byte, error) {
+	keyEntry, ok := p.Keys[version]
+	if !ok {
+		return nil, certutil.InternalError{Err: fmt.Sprintf("unable to access version %d of the key", version)}
+	}
+
+	return kdf.CounterMode(kdf.HMACSHA256PRF, kdf.HMACSHA256PRFLen, keyEntry.Key, []byte(hmacKeyContext), 256)
+}
+
+// HMAC computes the HMAC of the input with the latest version of the key
+func (p *Policy) HMAC(input []byte, algorithm string) (string, error) {
+	sum, err := p.computeHMAC(p.LatestVersion, input, algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	return "vault:v" + strconv.Itoa(p.LatestVersion) + ":" + base64.StdEncoding.EncodeToString(sum), nil
+}
+
+// VerifyHMAC checks the given versioned HMAC of the input
+func (p *Policy) VerifyHMAC(input []byte, value, algorithm string) (bool, error) {
+	ver, encoded, err := p.parseVersionedValue("hmac", value)
+	if err != nil {
+		return false, err
+	}
+
+	if p.MinDecryptionVersion > 0 && ver < p.MinDecryptionVersion {
+		return false, certutil.UserError{Err: "hmac version is disallowed by policy (too old)"}
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false, certutil.UserError{Err: "invalid hmac: could not decode base64"}
+	}
+
+	sum, err := p.computeHMAC(ver, input, algorithm)
+	if err != nil {
+		return false, err
+	}
+
+	return hmac.Equal(sum, expected), nil
+}
+
+func (p *Policy) computeHMAC(version int, input []byte, algorithm string) ([]byte, error) {
+	hashType, err := parseHashAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := p.HMACKey(version)
+	if err != nil {
+		return nil, err
+	}
+
+	hf := hmac.New(hashType.New, key)
+	hf.Write(input)
+	return hf.Sum(nil), nil
+}
+
+// Sign signs the input with the latest version of the key. The hash
+// algorithm is ignored for ed25519 keys, which sign the input directly.
+func (p *Policy) Sign(input []byte, algorithm string) (string, error) {
+	if !p.Type.SigningSupported() {
+		return "", certutil.UserError{Err: fmt.Sprintf("message signing not supported for key type %v", p.Type)}
+	}
+
+	keyEntry, ok := p.Keys[p.LatestVersion]
+	if !ok {
+		return "", certutil.InternalError{Err: "unable to access the key; no key versions found"}
+	}
+
+	var sig []byte
+	switch p.Type {
+	case KeyType_ED25519:
+		sig = ed25519.Sign(ed25519.PrivateKey(keyEntry.Key), input)
+
+	case KeyType_ECDSA_P256:
+		digest, _, err := hashInput(input, algorithm)
+		if err != nil {
+			return "", err
+		}
+
+		key, err := x509.ParseECPrivateKey(keyEntry.Key)
+		if err != nil {
+			return "", certutil.InternalError{Err: fmt.Sprintf("error parsing key: %v", err)}
+		}
+
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			return "", certutil.InternalError{Err: err.Error()}
+		}
+
+		sig, err = asn1.Marshal(ecdsaSignature{R: r, S: s})
+		if err != nil {
+			return "", certutil.InternalError{Err: err.Error()}
+		}
+
+	case KeyType_RSA2048, KeyType_RSA4096:
+		digest, hashType, err := hashInput(input, algorithm)
+		if err != nil {
+			return "", err
+		}
+
+		key, err := x509.ParsePKCS1PrivateKey(keyEntry.Key)
+		if err != nil {
+			return "", certutil.InternalError{Err: fmt.Sprintf("error parsing key: %v", err)}
+		}
+
+		sig, err = rsa.SignPSS(rand.Reader, key, hashType, digest, nil)
+		if err != nil {
+			return "", certutil.InternalError{Err: err.Error()}
+		}
+	}
+
+	encoded := "vault:v" + strconv.Itoa(p.LatestVersion) + ":" + base64.StdEncoding.EncodeToString(sig)
+
+	return encoded, nil
+}
+
+// VerifySignature checks the given versioned signature of the input
+func (p *Policy) VerifySignature(input []byte, signature, algorithm string) (bool, error) {
+	if !p.Type.SigningSupported() {
+		return false, certutil.UserError{Err: fmt.Sprintf("message verification not supported for key type %v", p.Type)}
+	}
+
+	ver, encoded, err := p.parseVersionedValue("signature", signature)
+	if err != nil {
+		return false, err
+	}
+
+	if p.MinDecryptionVersion > 0 && ver < p.MinDecryptionVersion {
+		return false, certutil.UserError{Err: "signature version is disallowed by policy (too old)"}
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false, certutil.UserError{Err: "invalid signature: could not decode base64"}
+	}
+
+	keyEntry, ok := p.Keys[ver]
+	if !ok {
+		return false, certutil.InternalError{Err: fmt.Sprintf("unable to access version %d of the key", ver)}
+	}
+
+	switch p.Type {
+	case KeyType_ED25519:
+		key := ed25519.PrivateKey(keyEntry.Key)
+		return ed25519.Verify(key.Public().(ed25519.PublicKey), input, sig), nil
+
+	case KeyType_ECDSA_P256:
+		digest, _, err := hashInput(input, algorithm)
+		if err != nil {
+			return false, err
+		}
+
+		var ecdsaSig ecdsaSignature
+		rest, err := asn1.Unmarshal(sig, &ecdsaSig)
+		if err != nil || len(rest) != 0 {
+			return false, certutil.UserError{Err: "invalid signature: could not decode signature"}
+		}
+
+		key, err := x509.ParseECPrivateKey(keyEntry.Key)
+		if err != nil {
+			return false, certutil.InternalError{Err: fmt.Sprintf("error parsing key: %v", err)}
+		}
+
+		return ecdsa.Verify(&key.PublicKey, digest, ecdsaSig.R, ecdsaSig.S), nil
+
+	case KeyType_RSA2048, KeyType_RSA4096:
+		digest, hashType, err := hashInput(input, algorithm)
+		if err != nil {
+			return false, err
+		}
+
+		key, err := x509.ParsePKCS1PrivateKey(keyEntry.Key)
+		if err != nil {
+			return false, certutil.InternalError{Err: fmt.Sprintf("error parsing key: %v", err)}
+		}
+
+		err = rsa.VerifyPSS(&key.PublicKey, hashType, digest, sig, nil)
+		return err == nil, nil
+	}
+
+	return false, certutil.InternalError{Err: fmt.Sprintf("unsupported key type %v", p.Type)}
+}
+
+// parseHashAlgorithm returns the hash for the named algorithm
+func parseHashAlgorithm(algorithm string) (crypto.Hash, error) {
+	switch algorithm {
+	case "sha2-224":
+		return crypto.SHA224, nil
+	case "sha2-256", "":
+		return crypto.SHA256, nil
+	case "sha2-384":
+		return crypto.SHA384, nil
+	case "sha2-512":
+		return crypto.SHA512, nil
+	default:
+		return 0, certutil.UserError{Err: fmt.Sprintf("unsupported hash algorithm %s", algorithm)}
+	}
+}
+
+// hashInput hashes the input with the named algorithm
+func hashInput(input []byte, algorithm string) ([]byte, crypto.Hash, error) {
+	hashType, err := parseHashAlgorithm(algorithm)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hasher := hashType.New()
+	hasher.Write(input)
+	return hasher.Sum(nil), hashType, nil
+}
+
+// generateKeyEntry creates a new key of the given type
+func generateKeyEntry(keyType KeyType) (*KeyEntry, error) {
+	entry := &KeyEntry{
+		CreationTime: time.Now().Unix(),
+	}
+
+	var pub crypto.PublicKey
+	switch keyType {
+	case KeyType_AES256_GCM96:
+		// Generate a 256bit key
+		entry.Key = make([]byte, 32)
+		_, err := rand.Read(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		return entry, nil
+
+	case KeyType_ED25519:
+		pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		entry.Key = privKey
+		entry.FormattedPublicKey = base64.StdEncoding.EncodeToString(pubKey)
+		return entry, nil
+
+	case KeyType_ECDSA_P256:
+		privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		entry.Key, err = x509.MarshalECPrivateKey(privKey)
+		if err != nil {
+			return nil, err
+		}
+		pub = &privKey.PublicKey
+
+	case KeyType_RSA2048, KeyType_RSA4096:
+		bits := 2048
+		if keyType == KeyType_RSA4096 {
+			bits = 4096
+		}
+		privKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+		entry.Key = x509.MarshalPKCS1PrivateKey(privKey)
+		pub = &privKey.PublicKey
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", keyType)
+	}
+
+	if err := entry.setPublicKey(pub); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// importKeyEntry creates a key entry of the given type from imported key
+// material: a raw 256-bit key for AES, the 32-byte seed or 64-byte private key
+// for ed25519, and a PKCS #8 encoded private key for ECDSA and RSA. The SEC 1
+// and PKCS #1 encodings are accepted for ECDSA and RSA as well.
+func importKeyEntry(keyType KeyType, key []byte) (*KeyEntry, error) {
+	entry := &KeyEntry{
+		CreationTime: time.Now().Unix(),
+	}
+
+	var pub crypto.PublicKey
+	switch keyType {
+	case KeyType_AES256_GCM96:
+		if len(key) != 32 {
+			return nil, certutil.UserError{Err: fmt.Sprintf("imported key must be 32 bytes long, got %d", len(key))}
+		}
+		entry.Key = key
+		return entry, nil
+
+	case KeyType_ED25519:
+		if len(key) != 32 && len(key) != ed25519.PrivateKeySize {
+			return nil, certutil.UserError{Err: "imported ed25519 key must be a 32-byte seed or a 64-byte private key"}
+		}
+		// Key generation reads the seed from the given reader
+		pubKey, privKey, err := ed25519.GenerateKey(bytes.NewReader(key[:32]))
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == ed25519.PrivateKeySize && !bytes.Equal(privKey, key) {
+			return nil, certutil.UserError{Err: "imported ed25519 private key does not match its seed"}
+		}
+		entry.Key = privKey
+		entry.FormattedPublicKey = base64.StdEncoding.EncodeToString(pubKey)
+		return entry, nil
+
+	case KeyType_ECDSA_P256:
+		var privKey *ecdsa.PrivateKey
+		parsed, err := x509.ParsePKCS8PrivateKey(key)
+		if err == nil {
+			var ok bool
+			if privKey, ok = parsed.(*ecdsa.PrivateKey); !ok {
+				return nil, certutil.UserError{Err: "imported key is not an ECDSA key"}
+			}
+		} else if privKey, err = x509.ParseECPrivateKey(key); err != nil {
+			return nil, certutil.UserError{Err: "failed to parse imported ECDSA key"}
+		}
+		if privKey.Curve != elliptic.P256() {
+			return nil, certutil.UserError{Err: "imported ECDSA key must use the P-256 curve"}
+		}
+		entry.Key, err = x509.MarshalECPrivateKey(privKey)
+		if err != nil {
+			return nil, err
+		}
+		pub = &privKey.PublicKey
+
+	case KeyType_RSA2048, KeyType_RSA4096:
+		var privKey *rsa.PrivateKey
+		parsed, err := x509.ParsePKCS8PrivateKey(key)
+		if err == nil {
+			var ok bool
+			if privKey, ok = parsed.(*rsa.PrivateKey); !ok {
+				return nil, certutil.UserError{Err: "imported key is not an RSA key"}
+			}
+		} else if privKey, err = x509.ParsePKCS1PrivateKey(key); err != nil {
+			return nil, certutil.UserError{Err: "failed to parse imported RSA key"}
+		}
+		bits := 2048
+		if keyType == KeyType_RSA4096 {
+			bits = 4096
+		}
+		if privKey.N.BitLen() != bits {
+			return nil, certutil.UserError{Err: fmt.Sprintf("imported RSA key must be %d bits long, got %d", bits, privKey.N.BitLen())}
+		}
+		entry.Key = x509.MarshalPKCS1PrivateKey(privKey)
+		pub = &privKey.PublicKey
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", keyType)
+	}
+
+	if err := entry.setPublicKey(pub); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// setPublicKey sets the PEM encoded public key of an ECDSA or RSA key entry
+func (k *KeyEntry) setPublicKey(pub crypto.PublicKey) error {
+	derBytes, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("error marshaling public key: %v", err)
+	}
+	k.FormattedPublicKey = string(pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: derBytes,
+	}))
+
+	return nil
+}
@@ -22,7 +22,10 @@ func Test_KeyUpgrade(t *testing.T) {
 
 func testKeyUpgradeCommon(t *testing.T, lm *lockManager) {
 	storage := &logical.InmemStorage{}
-	p, lock, upserted, err := lm.GetPolicyUpsert(storage, "test", false, false)
+	p, lock, upserted, err := lm.GetPolicyUpsert(policyRequest{
+		storage: storage,
+		name:    "test",
+	})
 	if lock != nil {
 		defer lock.RUnlock()
 	}
@@ -68,7 +71,10 @@ func testArchivingUpgradeCommon(t *testing.T, lm *lockManager) {
 
 	storage := &logical.InmemStorage{}
 
-	p, lock, _, err := lm.GetPolicyUpsert(storage, "test", false, false)
+	p, lock, _, err := lm.GetPolicyUpsert(policyRequest{
+		storage: storage,
+		name:    "test",
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -198,7 +204,10 @@ func testArchivingCommon(t *testing.T, lm *lockManager) {
 
 	storage := &logical.InmemStorage{}
 
-	p, lock, _, err := lm.GetPolicyUpsert(storage, "test", false, false)
+	p, lock, _, err := lm.GetPolicyUpsert(policyRequest{
+		storage: storage,
+		name:    "test",
+	})
 	if lock != nil {
 		defer lock.RUnlock()
 	}
@@ -9,6 +9,7 @@ import (
 	"github.com/hashicorp/vault/version"
 
 	credAppId "github.com/hashicorp/vault/builtin/credential/app-id"
+	credAppRole "github.com/hashicorp/vault/builtin/credential/approle"
 	credAwsEc2 "github.com/hashicorp/vault/builtin/credential/aws-ec2"
 	credCert "github.com/hashicorp/vault/builtin/credential/cert"
 	credGitHub "github.com/hashicorp/vault/builtin/credential/github"
@@ -18,6 +19,7 @@ import (
 	"github.com/hashicorp/vault/builtin/logical/aws"
 	"github.com/hashicorp/vault/builtin/logical/cassandra"
 	"github.com/hashicorp/vault/builtin/logical/consul"
+	"github.com/hashicorp/vault/builtin/logical/kv"
 	"github.com/hashicorp/vault/builtin/logical/mssql"
 	"github.com/hashicorp/vault/builtin/logical/mysql"
 	"github.com/hashicorp/vault/builtin/logical/pki"
@@ -67,6 +69,7 @@ func Commands(metaPtr *meta.Meta) map[string]cli.CommandFactory {
 					"cert":     credCert.Factory,
 					"aws-ec2":  credAwsEc2.Factory,
 					"app-id":   credAppId.Factory,
+					"approle":  credAppRole.Factory,
 					"github":   credGitHub.Factory,
 					"userpass": credUserpass.Factory,
 					"ldap":     credLdap.Factory,
@@ -82,6 +85,7 @@ func Commands(metaPtr *meta.Meta) map[string]cli.CommandFactory {
 					"mysql":      mysql.Factory,
 					"ssh":        ssh.Factory,
 					"rabbitmq":   rabbitmq.Factory,
+					"kv":         kv.Factory,
 				},
 				ShutdownCh:  command.MakeShutdownCh(),
 				SighupCh:    command.MakeSighupCh(),
@@ -179,6 +183,24 @@ func Commands(metaPtr *meta.Meta) map[string]cli.CommandFactory {
 			}, nil
 		},
 
+		"wrap": func() (cli.Command, error) {
+			return &command.WrapCommand{
+				Meta: *metaPtr,
+			}, nil
+		},
+
+		"rewrap": func() (cli.Command, error) {
+			return &command.RewrapCommand{
+				Meta: *metaPtr,
+			}, nil
+		},
+
+		"wrapping-lookup": func() (cli.Command, error) {
+			return &command.WrappingLookupCommand{
+				Meta: *metaPtr,
+			}, nil
+		},
+
 		"list": func() (cli.Command, error) {
 			return &command.ListCommand{
 				Meta: *metaPtr,
@@ -221,6 +243,18 @@ func Commands(metaPtr *meta.Meta) map[string]cli.CommandFactory {
 			}, nil
 		},
 
+		"lease lookup": func() (cli.Command, error) {
+			return &command.LeaseLookupCommand{
+				Meta: *metaPtr,
+			}, nil
+		},
+
+		"lease list": func() (cli.Command, error) {
+			return &command.LeaseListCommand{
+				Meta: *metaPtr,
+			}, nil
+		},
+
 		"seal": func() (cli.Command, error) {
 			return &command.SealCommand{
 				Meta: *metaPtr,
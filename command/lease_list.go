@@ -0,0 +1,85 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/meta"
+)
+
+// LeaseListCommand is a Command that lists the leases under a prefix
+type LeaseListCommand struct {
+	meta.Meta
+}
+
+func (c *LeaseListCommand) Run(args []string) int {
+	var format string
+	flags := c.Meta.FlagSet("lease list", meta.FlagSetDefault)
+	flags.StringVar(&format, "format", "table", "")
+	flags.Usage = func() { c.Ui.Error(c.Help()) }
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = flags.Args()
+	if len(args) > 1 {
+		flags.Usage()
+		c.Ui.Error("\nlease list expects at most one argument: the prefix")
+		return 1
+	}
+
+	var prefix string
+	if len(args) == 1 {
+		prefix = strings.TrimPrefix(args[0], "/")
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	client, err := c.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(
+			"Error initializing client: %s", err))
+		return 2
+	}
+
+	secret, err := client.Sys().LeaseList(prefix)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(
+			"Error listing leases under '%s': %s", prefix, err))
+		return 1
+	}
+	if secret == nil || secret.Data["keys"] == nil {
+		c.Ui.Error("No leases found")
+		return 0
+	}
+
+	return OutputList(c.Ui, format, secret)
+}
+
+func (c *LeaseListCommand) Synopsis() string {
+	return "List the leases under a prefix"
+}
+
+func (c *LeaseListCommand) Help() string {
+	helpText := `
+Usage: vault lease list [options] [prefix]
+
+  List the leases under a prefix.
+
+  The lease IDs directly under the prefix are listed, along with the nested
+  prefixes, which end with a slash. Without a prefix, the top-level prefixes
+  are listed. This can be used to review the leases of a mount before
+  revoking them with 'vault revoke -prefix'. This requires a token with sudo
+  capability on 'sys/leases/lookup/'.
+
+General Options:
+` + meta.GeneralOptionsUsage() + `
+Lease List Options:
+
+  -format=table           The format for output. By default it is a whitespace-
+                          delimited table. This can also be json or yaml.
+
+`
+	return strings.TrimSpace(helpText)
+}
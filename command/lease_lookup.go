@@ -0,0 +1,69 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/meta"
+)
+
+// LeaseLookupCommand is a Command that outputs the times of a lease
+type LeaseLookupCommand struct {
+	meta.Meta
+}
+
+func (c *LeaseLookupCommand) Run(args []string) int {
+	var format string
+	flags := c.Meta.FlagSet("lease lookup", meta.FlagSetDefault)
+	flags.StringVar(&format, "format", "table", "")
+	flags.Usage = func() { c.Ui.Error(c.Help()) }
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = flags.Args()
+	if len(args) != 1 || len(args[0]) == 0 {
+		flags.Usage()
+		c.Ui.Error("\nlease lookup expects one argument: the lease ID")
+		return 1
+	}
+
+	client, err := c.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(
+			"Error initializing client: %s", err))
+		return 2
+	}
+
+	secret, err := client.Sys().LeaseLookup(args[0])
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(
+			"Error looking up lease: %s", err))
+		return 1
+	}
+	return OutputSecret(c.Ui, format, secret)
+}
+
+func (c *LeaseLookupCommand) Synopsis() string {
+	return "Display the issue, expiration and renewal times of a lease"
+}
+
+func (c *LeaseLookupCommand) Help() string {
+	helpText := `
+Usage: vault lease lookup [options] id
+
+  Display the issue, expiration and last renewal times of a lease.
+
+  The remaining TTL of the lease and whether it can be renewed are displayed
+  as well.
+
+General Options:
+` + meta.GeneralOptionsUsage() + `
+Lease Lookup Options:
+
+  -format=table           The format for output. By default it is a whitespace-
+                          delimited table. This can also be json or yaml.
+
+`
+	return strings.TrimSpace(helpText)
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/meta"
+)
+
+// RewrapCommand is a Command that moves the response of a response-wrapping
+// token to a new token
+type RewrapCommand struct {
+	meta.Meta
+}
+
+func (c *RewrapCommand) Run(args []string) int {
+	var format, field string
+	flags := c.Meta.FlagSet("rewrap", meta.FlagSetDefault)
+	flags.StringVar(&format, "format", "table", "")
+	flags.StringVar(&field, "field", "", "")
+	flags.Usage = func() { c.Ui.Error(c.Help()) }
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = flags.Args()
+	if len(args) != 1 || len(args[0]) == 0 {
+		c.Ui.Error("rewrap expects one argument: the ID of the wrapping token")
+		flags.Usage()
+		return 1
+	}
+
+	client, err := c.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(
+			"Error initializing client: %s", err))
+		return 2
+	}
+
+	secret, err := client.Sys().Rewrap(args[0])
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(
+			"Error rewrapping token: %s", err))
+		return 1
+	}
+	if secret == nil || secret.WrapInfo == nil {
+		c.Ui.Error("No wrapping information was returned")
+		return 1
+	}
+
+	// Handle single field output
+	if field != "" {
+		return PrintRawField(c.Ui, secret, field)
+	}
+
+	return OutputSecret(c.Ui, format, secret)
+}
+
+func (c *RewrapCommand) Synopsis() string {
+	return "Move a wrapped response to a new wrapping token"
+}
+
+func (c *RewrapCommand) Help() string {
+	helpText := `
+Usage: vault rewrap [options] <wrapping token ID>
+
+  Move a wrapped response to a new wrapping token.
+
+  The response wrapped by the given token is moved to a new token, with the
+  same TTL and creation path, and the given token is revoked. This can be
+  used to refresh wrapping tokens stored for a long time.
+
+General Options:
+` + meta.GeneralOptionsUsage() + `
+Rewrap Options:
+
+  -format=table           The format for output. By default it is a whitespace-
+                          delimited table. This can also be json or yaml.
+
+  -field=field            If included, the raw value of the specified field
+                          will be output raw to stdout.
+
+`
+	return strings.TrimSpace(helpText)
+}
@@ -164,6 +164,30 @@ func (c *ServerCommand) Run(args []string) int {
 	info := make(map[string]string)
 
 	var seal vault.Seal = &vault.DefaultSeal{}
+	var migrationSeal vault.Seal
+	if dev && (config.Seal != nil || config.MigrationSeal != nil) {
+		c.Ui.Error("A seal cannot be configured in dev mode")
+		return 1
+	}
+	if config.Seal != nil {
+		seal, err = newSeal(config.Seal)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf(
+				"Error initializing seal of type %s: %s",
+				config.Seal.Type, err))
+			return 1
+		}
+	}
+	if config.MigrationSeal != nil {
+		migrationSeal, err = newSeal(config.MigrationSeal)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf(
+				"Error initializing disabled seal of type %s: %s",
+				config.MigrationSeal.Type, err))
+			seal.Finalize()
+			return 1
+		}
+	}
 
 	// Ensure that the seal finalizer is called, even if using verify-only
 	defer func() {
@@ -171,6 +195,11 @@ func (c *ServerCommand) Run(args []string) int {
 		if err != nil {
 			c.Ui.Error(fmt.Sprintf("Error finalizing seals: %v", err))
 		}
+		if migrationSeal != nil {
+			if err := migrationSeal.Finalize(); err != nil {
+				c.Ui.Error(fmt.Sprintf("Error finalizing seals: %v", err))
+			}
+		}
 	}()
 
 	coreConfig := &vault.CoreConfig{
@@ -178,6 +207,7 @@ func (c *ServerCommand) Run(args []string) int {
 		AdvertiseAddr:      config.Backend.AdvertiseAddr,
 		HAPhysical:         nil,
 		Seal:               seal,
+		MigrationSeal:      migrationSeal,
 		AuditBackends:      c.AuditBackends,
 		CredentialBackends: c.CredentialBackends,
 		LogicalBackends:    c.LogicalBackends,
@@ -205,15 +235,20 @@ func (c *ServerCommand) Run(args []string) int {
 			return 1
 		}
 		coreConfig.AdvertiseAddr = config.HABackend.AdvertiseAddr
+		coreConfig.ClusterAddr = config.HABackend.ClusterAddr
 	} else {
 		if coreConfig.HAPhysical, ok = backend.(physical.HABackend); ok {
 			coreConfig.AdvertiseAddr = config.Backend.AdvertiseAddr
+			coreConfig.ClusterAddr = config.Backend.ClusterAddr
 		}
 	}
 
 	if envAA := os.Getenv("VAULT_ADVERTISE_ADDR"); envAA != "" {
 		coreConfig.AdvertiseAddr = envAA
 	}
+	if envCA := os.Getenv("VAULT_CLUSTER_ADDR"); envCA != "" {
+		coreConfig.ClusterAddr = envCA
+	}
 
 	// Attempt to detect the advertise address, if possible
 	var detect physical.AdvertiseDetect
@@ -233,6 +268,33 @@ func (c *ServerCommand) Run(args []string) int {
 		}
 	}
 
+	// Derive the cluster address from the advertise address if not given;
+	// the cluster port defaults to the one following the API port
+	if coreConfig.HAPhysical != nil && coreConfig.ClusterAddr == "" && coreConfig.AdvertiseAddr != "" {
+		clusterAddr, err := clusterAddrFromAdvertise(coreConfig.AdvertiseAddr)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error deriving cluster address: %s", err))
+			return 1
+		}
+		coreConfig.ClusterAddr = clusterAddr
+	}
+
+	// Compute the addresses of the cluster listeners, which accept the
+	// requests forwarded by standbys while this node is active
+	if coreConfig.HAPhysical != nil {
+		for _, lnConfig := range config.Listeners {
+			if lnConfig.Type != "tcp" {
+				continue
+			}
+			addr, err := clusterListenerAddress(lnConfig.Config)
+			if err != nil {
+				c.Ui.Error(fmt.Sprintf("Error parsing cluster address of listener: %s", err))
+				return 1
+			}
+			coreConfig.ClusterListenerAddrs = append(coreConfig.ClusterListenerAddrs, addr)
+		}
+	}
+
 	// Initialize the core
 	core, newCoreError := vault.NewCore(coreConfig)
 	if newCoreError != nil {
@@ -283,6 +345,15 @@ func (c *ServerCommand) Run(args []string) int {
 		mlock.Supported(), !config.DisableMlock)
 	infoKeys = append(infoKeys, "log level", "mlock", "backend")
 
+	if config.Seal != nil {
+		info["seal"] = config.Seal.Type
+		infoKeys = append(infoKeys, "seal")
+	}
+	if config.MigrationSeal != nil {
+		info["disabled seal"] = config.MigrationSeal.Type
+		infoKeys = append(infoKeys, "disabled seal")
+	}
+
 	if config.HABackend != nil {
 		info["HA backend"] = config.HABackend.Type
 		info["advertise address"] = coreConfig.AdvertiseAddr
@@ -295,13 +366,17 @@ func (c *ServerCommand) Run(args []string) int {
 			infoKeys = append(infoKeys, "advertise address")
 		}
 	}
+	if coreConfig.ClusterAddr != "" {
+		info["cluster address"] = coreConfig.ClusterAddr
+		infoKeys = append(infoKeys, "cluster address")
+	}
 
 	// If the backend supports service discovery, run service discovery
 	if coreConfig.HAPhysical != nil {
 		sd, ok := coreConfig.HAPhysical.(physical.ServiceDiscovery)
 		if ok {
 			activeFunc := func() bool {
-				if isLeader, _, err := core.Leader(); err == nil {
+				if isLeader, _, _, err := core.Leader(); err == nil {
 					return isLeader
 				}
 				return false
@@ -487,6 +562,67 @@ func (c *ServerCommand) enableDev(core *vault.Core, rootTokenID string) (*vault.
 	return init, nil
 }
 
+// newSeal creates the seal described by a seal stanza of the configuration
+func newSeal(config *server.Seal) (vault.Seal, error) {
+	switch config.Type {
+	case "transit":
+		return vault.NewTransitSeal(config.Config)
+	case "pkcs11":
+		return vault.NewPKCS11Seal(config.Config)
+	case "shamir":
+		return &vault.DefaultSeal{}, nil
+	default:
+		return nil, fmt.Errorf("unknown seal type: %s", config.Type)
+	}
+}
+
+// clusterAddrFromAdvertise derives the cluster address from the advertise
+// address, using the port following the advertised one
+func clusterAddrFromAdvertise(advertiseAddr string) (string, error) {
+	u, err := url.Parse(advertiseAddr)
+	if err != nil {
+		return "", fmt.Errorf("invalid advertise address: %v", err)
+	}
+
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		// No port given; use the default one of the scheme
+		host = u.Host
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	nPort, err := strconv.Atoi(port)
+	if err != nil {
+		return "", fmt.Errorf("invalid port in advertise address: %v", err)
+	}
+
+	u.Scheme = "https"
+	u.Host = net.JoinHostPort(host, strconv.Itoa(nPort+1))
+	u.Path = ""
+	return u.String(), nil
+}
+
+// clusterListenerAddress returns the address the cluster listener of a tcp
+// listener binds to, which defaults to the port following the listener's
+func clusterListenerAddress(config map[string]string) (*net.TCPAddr, error) {
+	if addr, ok := config["cluster_address"]; ok {
+		return net.ResolveTCPAddr("tcp", addr)
+	}
+
+	addr, ok := config["address"]
+	if !ok {
+		addr = "127.0.0.1:8200"
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tcpAddr.Port++
+	return tcpAddr, nil
+}
+
 // detectAdvertise is used to attempt advertise address detection
 func (c *ServerCommand) detectAdvertise(detect physical.AdvertiseDetect,
 	config *server.Config) (string, error) {
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,6 +24,10 @@ type Config struct {
 	Listeners []*Listener `hcl:"-"`
 	Backend   *Backend    `hcl:"-"`
 	HABackend *Backend    `hcl:"-"`
+	Seal      *Seal       `hcl:"-"`
+
+	// MigrationSeal is the disabled seal the storage is migrated from
+	MigrationSeal *Seal `hcl:"-"`
 
 	DisableCache bool `hcl:"disable_cache"`
 	DisableMlock bool `hcl:"disable_mlock"`
@@ -76,6 +81,7 @@ func (l *Listener) GoString() string {
 type Backend struct {
 	Type          string
 	AdvertiseAddr string
+	ClusterAddr   string
 	Config        map[string]string
 }
 
@@ -83,6 +89,16 @@ func (b *Backend) GoString() string {
 	return fmt.Sprintf("*%#v", *b)
 }
 
+// Seal is the seal configuration for the server
+type Seal struct {
+	Type   string
+	Config map[string]string
+}
+
+func (s *Seal) GoString() string {
+	return fmt.Sprintf("*%#v", *s)
+}
+
 // Telemetry is the telemetry configuration for the server
 type Telemetry struct {
 	StatsiteAddr string `hcl:"statsite_address"`
@@ -115,6 +131,16 @@ func (c *Config) Merge(c2 *Config) *Config {
 		result.HABackend = c2.HABackend
 	}
 
+	result.Seal = c.Seal
+	if c2.Seal != nil {
+		result.Seal = c2.Seal
+	}
+
+	result.MigrationSeal = c.MigrationSeal
+	if c2.MigrationSeal != nil {
+		result.MigrationSeal = c2.MigrationSeal
+	}
+
 	result.Telemetry = c.Telemetry
 	if c2.Telemetry != nil {
 		result.Telemetry = c2.Telemetry
@@ -203,6 +229,7 @@ func ParseConfig(d string) (*Config, error) {
 		"atlas",
 		"backend",
 		"ha_backend",
+		"seal",
 		"listener",
 		"disable_cache",
 		"disable_mlock",
@@ -256,6 +283,12 @@ func ParseConfig(d string) (*Config, error) {
 		}
 	}
 
+	if o := list.Filter("seal"); len(o.Items) > 0 {
+		if err := parseSeal(&result, o); err != nil {
+			return nil, fmt.Errorf("error parsing 'seal': %s", err)
+		}
+	}
+
 	if o := list.Filter("listener"); len(o.Items) > 0 {
 		if err := parseListeners(&result, o); err != nil {
 			return nil, fmt.Errorf("error parsing 'listener': %s", err)
@@ -366,15 +399,21 @@ func parseBackends(result *Config, list *ast.ObjectList) error {
 		return multierror.Prefix(err, fmt.Sprintf("backend.%s:", key))
 	}
 
-	// Pull out the advertise address since it's common to all backends
-	var advertiseAddr string
+	// Pull out the advertise and cluster addresses since they're common to
+	// all backends
+	var advertiseAddr, clusterAddr string
 	if v, ok := m["advertise_addr"]; ok {
 		advertiseAddr = v
 		delete(m, "advertise_addr")
 	}
+	if v, ok := m["cluster_addr"]; ok {
+		clusterAddr = v
+		delete(m, "cluster_addr")
+	}
 
 	result.Backend = &Backend{
 		AdvertiseAddr: advertiseAddr,
+		ClusterAddr:   clusterAddr,
 		Type:          strings.ToLower(key),
 		Config:        m,
 	}
@@ -399,21 +438,72 @@ func parseHABackends(result *Config, list *ast.ObjectList) error {
 		return multierror.Prefix(err, fmt.Sprintf("ha_backend.%s:", key))
 	}
 
-	// Pull out the advertise address since it's common to all backends
-	var advertiseAddr string
+	// Pull out the advertise and cluster addresses since they're common to
+	// all backends
+	var advertiseAddr, clusterAddr string
 	if v, ok := m["advertise_addr"]; ok {
 		advertiseAddr = v
 		delete(m, "advertise_addr")
 	}
+	if v, ok := m["cluster_addr"]; ok {
+		clusterAddr = v
+		delete(m, "cluster_addr")
+	}
 
 	result.HABackend = &Backend{
 		AdvertiseAddr: advertiseAddr,
+		ClusterAddr:   clusterAddr,
 		Type:          strings.ToLower(key),
 		Config:        m,
 	}
 	return nil
 }
 
+func parseSeal(result *Config, list *ast.ObjectList) error {
+	if len(list.Items) > 2 {
+		return fmt.Errorf("only one 'seal' block is permitted, besides a disabled one for migration")
+	}
+
+	for _, item := range list.Items {
+		if len(item.Keys) == 0 {
+			return fmt.Errorf("seal type must be specified")
+		}
+		key := item.Keys[0].Token.Value().(string)
+
+		var m map[string]string
+		if err := hcl.DecodeObject(&m, item.Val); err != nil {
+			return multierror.Prefix(err, fmt.Sprintf("seal.%s:", key))
+		}
+
+		// A disabled seal is the one the storage is migrated from
+		var disabled bool
+		if v, ok := m["disabled"]; ok {
+			var err error
+			disabled, err = strconv.ParseBool(v)
+			if err != nil {
+				return multierror.Prefix(err, fmt.Sprintf("seal.%s.disabled:", key))
+			}
+			delete(m, "disabled")
+		}
+
+		seal := &Seal{
+			Type:   strings.ToLower(key),
+			Config: m,
+		}
+		switch {
+		case disabled && result.MigrationSeal != nil:
+			return fmt.Errorf("only one disabled 'seal' block is permitted")
+		case disabled:
+			result.MigrationSeal = seal
+		case result.Seal != nil:
+			return fmt.Errorf("only one 'seal' block is permitted, besides a disabled one for migration")
+		default:
+			result.Seal = seal
+		}
+	}
+	return nil
+}
+
 func parseListeners(result *Config, list *ast.ObjectList) error {
 	var foundAtlas bool
 
@@ -426,6 +516,7 @@ func parseListeners(result *Config, list *ast.ObjectList) error {
 
 		valid := []string{
 			"address",
+			"cluster_address",
 			"endpoint",
 			"infrastructure",
 			"node_id",
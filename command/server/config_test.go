@@ -43,11 +43,20 @@ func TestLoadConfigFile(t *testing.T) {
 		HABackend: &Backend{
 			Type:          "consul",
 			AdvertiseAddr: "snafu",
+			ClusterAddr:   "https://snafu:8201",
 			Config: map[string]string{
 				"bar": "baz",
 			},
 		},
 
+		Seal: &Seal{
+			Type: "transit",
+			Config: map[string]string{
+				"address":  "https://transit.example.com:8200",
+				"key_name": "unseal",
+			},
+		},
+
 		Telemetry: &Telemetry{
 			StatsdAddr:      "bar",
 			StatsiteAddr:    "foo",
@@ -265,3 +274,75 @@ telemetry {
 		t.Errorf("bad error: %q", err)
 	}
 }
+
+func TestParseConfig_badSeal(t *testing.T) {
+	_, err := ParseConfig(strings.TrimSpace(`
+seal "transit" {
+	key_name = "foo"
+}
+seal "transit" {
+	key_name = "bar"
+}
+`))
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if !strings.Contains(err.Error(), "only one 'seal' block is permitted") {
+		t.Errorf("bad error: %q", err)
+	}
+}
+
+func TestParseConfig_sealMigration(t *testing.T) {
+	config, err := ParseConfig(strings.TrimSpace(`
+seal "transit" {
+	address = "https://transit.example.com:8200"
+	key_name = "unseal"
+	disabled = "true"
+}
+seal "pkcs11" {
+	lib = "/usr/lib/softhsm/libsofthsm2.so"
+	key_label = "vault"
+}
+`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expectedSeal := &Seal{
+		Type: "pkcs11",
+		Config: map[string]string{
+			"lib":       "/usr/lib/softhsm/libsofthsm2.so",
+			"key_label": "vault",
+		},
+	}
+	if !reflect.DeepEqual(config.Seal, expectedSeal) {
+		t.Fatalf("expected \n\n%#v\n\n to be \n\n%#v\n\n", config.Seal, expectedSeal)
+	}
+
+	expectedMigrationSeal := &Seal{
+		Type: "transit",
+		Config: map[string]string{
+			"address":  "https://transit.example.com:8200",
+			"key_name": "unseal",
+		},
+	}
+	if !reflect.DeepEqual(config.MigrationSeal, expectedMigrationSeal) {
+		t.Fatalf("expected \n\n%#v\n\n to be \n\n%#v\n\n", config.MigrationSeal, expectedMigrationSeal)
+	}
+
+	_, err = ParseConfig(strings.TrimSpace(`
+seal "transit" {
+	key_name = "foo"
+	disabled = "true"
+}
+seal "pkcs11" {
+	key_label = "bar"
+	disabled = "true"
+}
+`))
+	if err == nil || !strings.Contains(err.Error(), "only one disabled 'seal' block is permitted") {
+		t.Fatalf("bad error: %v", err)
+	}
+}
@@ -22,6 +22,12 @@ backend "consul" {
 ha_backend "consul" {
     bar = "baz"
     advertise_addr = "snafu"
+    cluster_addr = "https://snafu:8201"
+}
+
+seal "transit" {
+    address = "https://transit.example.com:8200"
+    key_name = "unseal"
 }
 
 max_lease_ttl = "10h"
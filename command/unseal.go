@@ -19,9 +19,10 @@ type UnsealCommand struct {
 }
 
 func (c *UnsealCommand) Run(args []string) int {
-	var reset bool
+	var reset, migrate bool
 	flags := c.Meta.FlagSet("unseal", meta.FlagSetDefault)
 	flags.BoolVar(&reset, "reset", false, "")
+	flags.BoolVar(&migrate, "migrate", false, "")
 	flags.Usage = func() { c.Ui.Error(c.Help()) }
 	if err := flags.Parse(args); err != nil {
 		return 1
@@ -71,7 +72,11 @@ func (c *UnsealCommand) Run(args []string) int {
 				return 1
 			}
 		}
-		sealStatus, err = client.Sys().Unseal(strings.TrimSpace(value))
+		if migrate {
+			sealStatus, err = client.Sys().UnsealMigrate(strings.TrimSpace(value))
+		} else {
+			sealStatus, err = client.Sys().Unseal(strings.TrimSpace(value))
+		}
 	}
 
 	if err != nil {
@@ -80,6 +85,9 @@ func (c *UnsealCommand) Run(args []string) int {
 		return 1
 	}
 
+	if sealStatus.Migration {
+		c.Ui.Output("Seal Migration: pending")
+	}
 	c.Ui.Output(fmt.Sprintf(
 		"Sealed: %v\n"+
 			"Key Shares: %d\n"+
@@ -121,6 +129,11 @@ Unseal Options:
   -reset                  Reset the unsealing process by throwing away
                           prior keys in process to unseal the vault.
 
+  -migrate                Complete a pending seal migration. The keys to
+                          enter are the unseal keys when migrating from
+                          Shamir, and the recovery keys when migrating from
+                          an auto seal.
+
 `
 	return strings.TrimSpace(helpText)
 }
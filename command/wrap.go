@@ -0,0 +1,106 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault/helper/kv-builder"
+	"github.com/hashicorp/vault/meta"
+)
+
+// WrapCommand is a Command that wraps arbitrary data in a response-wrapping
+// token
+type WrapCommand struct {
+	meta.Meta
+
+	// The fields below can be overwritten for tests
+	testStdin io.Reader
+}
+
+func (c *WrapCommand) Run(args []string) int {
+	var format, field string
+	flags := c.Meta.FlagSet("wrap", meta.FlagSetDefault)
+	flags.StringVar(&format, "format", "table", "")
+	flags.StringVar(&field, "field", "", "")
+	flags.Usage = func() { c.Ui.Error(c.Help()) }
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		c.Ui.Error("wrap expects at least one key=value pair of data")
+		flags.Usage()
+		return 1
+	}
+
+	var stdin io.Reader = os.Stdin
+	if c.testStdin != nil {
+		stdin = c.testStdin
+	}
+	builder := &kvbuilder.Builder{Stdin: stdin}
+	if err := builder.Add(args...); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error loading data: %s", err))
+		return 1
+	}
+
+	if c.Meta.DefaultWrappingLookupFunc("PUT", "sys/wrapping/wrap") == "" {
+		c.Ui.Error("A wrapping TTL must be specified with -wrap-ttl")
+		return 1
+	}
+
+	client, err := c.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(
+			"Error initializing client: %s", err))
+		return 2
+	}
+
+	secret, err := client.Sys().Wrap(builder.Map(), "")
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(
+			"Error wrapping data: %s", err))
+		return 1
+	}
+	if secret == nil || secret.WrapInfo == nil {
+		c.Ui.Error("No wrapping information was returned")
+		return 1
+	}
+
+	// Handle single field output
+	if field != "" {
+		return PrintRawField(c.Ui, secret, field)
+	}
+
+	return OutputSecret(c.Ui, format, secret)
+}
+
+func (c *WrapCommand) Synopsis() string {
+	return "Wrap arbitrary data in a response-wrapping token"
+}
+
+func (c *WrapCommand) Help() string {
+	helpText := `
+Usage: vault wrap [options] -wrap-ttl=<duration> key=value [key=value...]
+
+  Wrap arbitrary data in a response-wrapping token.
+
+  The data is given as key=value pairs, like for the write command. The
+  returned token can be given to another party, which retrieves the data
+  with the unwrap command. The -wrap-ttl option is required.
+
+General Options:
+` + meta.GeneralOptionsUsage() + `
+Wrap Options:
+
+  -format=table           The format for output. By default it is a whitespace-
+                          delimited table. This can also be json or yaml.
+
+  -field=field            If included, the raw value of the specified field
+                          will be output raw to stdout.
+
+`
+	return strings.TrimSpace(helpText)
+}
@@ -0,0 +1,90 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/meta"
+	"github.com/hashicorp/vault/vault"
+	"github.com/mitchellh/cli"
+)
+
+func TestWrap(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := http.TestServer(t, core)
+	defer ln.Close()
+
+	ui := new(cli.MockUi)
+	c := &WrapCommand{
+		Meta: meta.Meta{
+			ClientToken: token,
+			Ui:          ui,
+		},
+	}
+
+	// A wrapping TTL is required
+	if code := c.Run([]string{"-address", addr, "zip=zap"}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+
+	args := []string{
+		"-address", addr,
+		"-wrap-ttl", "60s",
+		"-field", "wrapping_token",
+		"zip=zap",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	wrappingToken := strings.TrimSpace(ui.OutputWriter.String())
+
+	// Look it up
+	ui = new(cli.MockUi)
+	lookup := &WrappingLookupCommand{
+		Meta: meta.Meta{
+			ClientToken: token,
+			Ui:          ui,
+		},
+	}
+	args = []string{"-address", addr, "-field", "creation_path", wrappingToken}
+	if code := lookup.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); output != "sys/wrapping/wrap\n" {
+		t.Fatalf("unexpected output:\n%s", output)
+	}
+
+	// Rewrap it
+	ui = new(cli.MockUi)
+	rewrap := &RewrapCommand{
+		Meta: meta.Meta{
+			ClientToken: token,
+			Ui:          ui,
+		},
+	}
+	args = []string{"-address", addr, "-field", "wrapping_token", wrappingToken}
+	if code := rewrap.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	rewrappedToken := strings.TrimSpace(ui.OutputWriter.String())
+	if rewrappedToken == "" || rewrappedToken == wrappingToken {
+		t.Fatalf("bad: %s", rewrappedToken)
+	}
+
+	// Unwrap it
+	ui = new(cli.MockUi)
+	unwrap := &UnwrapCommand{
+		Meta: meta.Meta{
+			ClientToken: token,
+			Ui:          ui,
+		},
+	}
+	args = []string{"-address", addr, "-field", "zip", rewrappedToken}
+	if code := unwrap.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); output != "zap\n" {
+		t.Fatalf("unexpected output:\n%s", output)
+	}
+}
@@ -0,0 +1,86 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/meta"
+)
+
+// WrappingLookupCommand is a Command that looks up the properties of a
+// response-wrapping token without unwrapping it
+type WrappingLookupCommand struct {
+	meta.Meta
+}
+
+func (c *WrappingLookupCommand) Run(args []string) int {
+	var format, field string
+	flags := c.Meta.FlagSet("wrapping-lookup", meta.FlagSetDefault)
+	flags.StringVar(&format, "format", "table", "")
+	flags.StringVar(&field, "field", "", "")
+	flags.Usage = func() { c.Ui.Error(c.Help()) }
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = flags.Args()
+	if len(args) != 1 || len(args[0]) == 0 {
+		c.Ui.Error("wrapping-lookup expects one argument: the ID of the wrapping token")
+		flags.Usage()
+		return 1
+	}
+
+	client, err := c.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(
+			"Error initializing client: %s", err))
+		return 2
+	}
+
+	secret, err := client.Sys().WrappingLookup(args[0])
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(
+			"Error looking up wrapping token: %s", err))
+		return 1
+	}
+	if secret == nil {
+		c.Ui.Error("Secret returned was nil")
+		return 1
+	}
+
+	// Handle single field output
+	if field != "" {
+		return PrintRawField(c.Ui, secret, field)
+	}
+
+	return OutputSecret(c.Ui, format, secret)
+}
+
+func (c *WrappingLookupCommand) Synopsis() string {
+	return "Look up the properties of a wrapping token"
+}
+
+func (c *WrappingLookupCommand) Help() string {
+	helpText := `
+Usage: vault wrapping-lookup [options] <wrapping token ID>
+
+  Look up the properties of a wrapping token.
+
+  Returns the creation TTL, creation time and creation path of the token,
+  without unwrapping it. The creation path is the path of the request whose
+  response was wrapped; checking it ensures that the token comes from the
+  expected endpoint and was not substituted.
+
+General Options:
+` + meta.GeneralOptionsUsage() + `
+Wrapping Lookup Options:
+
+  -format=table           The format for output. By default it is a whitespace-
+                          delimited table. This can also be json or yaml.
+
+  -field=field            If included, the raw value of the specified field
+                          will be output raw to stdout.
+
+`
+	return strings.TrimSpace(helpText)
+}
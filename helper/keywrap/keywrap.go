@@ -0,0 +1,116 @@
+// Package keywrap implements the AES Key Wrap with Padding algorithm of
+// RFC 5649. It is used to transport key material encrypted under a key
+// encryption key, as done by the CKM_RSA_AES_KEY_WRAP mechanism of PKCS #11.
+package keywrap
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+// aivPrefix is the constant part of the alternative initial value
+var aivPrefix = []byte{0xA6, 0x59, 0x59, 0xA6}
+
+// WrapWithPadding wraps the given key with the key encryption key kek,
+// which must be a valid AES key.
+func WrapWithPadding(kek, key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("key to wrap must not be empty")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	// The alternative initial value carries the length of the key
+	aiv := make([]byte, 8)
+	copy(aiv, aivPrefix)
+	binary.BigEndian.PutUint32(aiv[4:], uint32(len(key)))
+
+	// Pad the key with zeros to a multiple of 64 bits
+	n := (len(key) + 7) / 8
+	padded := make([]byte, n*8)
+	copy(padded, key)
+
+	// A single block is encrypted directly
+	if n == 1 {
+		out := make([]byte, 16)
+		block.Encrypt(out, append(aiv, padded...))
+		return out, nil
+	}
+
+	a := aiv
+	r := padded
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b, a)
+			copy(b[8:], r[(i-1)*8:i*8])
+			block.Encrypt(b, b)
+
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(b[:8])^t)
+			copy(r[(i-1)*8:i*8], b[8:])
+		}
+	}
+
+	return append(a, r...), nil
+}
+
+// UnwrapWithPadding unwraps a key wrapped by WrapWithPadding with the key
+// encryption key kek. An error is returned if the integrity check fails.
+func UnwrapWithPadding(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, fmt.Errorf("invalid wrapped key length %d", len(wrapped))
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	var a, r []byte
+	if n == 1 {
+		b := make([]byte, 16)
+		block.Decrypt(b, wrapped)
+		a, r = b[:8], b[8:]
+	} else {
+		a = make([]byte, 8)
+		copy(a, wrapped[:8])
+		r = make([]byte, n*8)
+		copy(r, wrapped[8:])
+
+		b := make([]byte, 16)
+		for j := 5; j >= 0; j-- {
+			for i := n; i >= 1; i-- {
+				t := uint64(n*j + i)
+				binary.BigEndian.PutUint64(b, binary.BigEndian.Uint64(a)^t)
+				copy(b[8:], r[(i-1)*8:i*8])
+				block.Decrypt(b, b)
+
+				copy(a, b[:8])
+				copy(r[(i-1)*8:i*8], b[8:])
+			}
+		}
+	}
+
+	// Check the alternative initial value and the padding
+	if subtle.ConstantTimeCompare(a[:4], aivPrefix) != 1 {
+		return nil, fmt.Errorf("integrity check failed")
+	}
+	length := int(binary.BigEndian.Uint32(a[4:]))
+	if length <= 8*(n-1) || length > 8*n {
+		return nil, fmt.Errorf("integrity check failed")
+	}
+	for _, p := range r[length:] {
+		if p != 0 {
+			return nil, fmt.Errorf("integrity check failed")
+		}
+	}
+
+	return r[:length], nil
+}
@@ -0,0 +1,62 @@
+package keywrap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// The test vectors are from section 6 of RFC 5649
+func TestWrapWithPadding(t *testing.T) {
+	kek, _ := hex.DecodeString("5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+
+	cases := []struct {
+		key     string
+		wrapped string
+	}{
+		{
+			"c37b7e6492584340bed12207808941155068f738",
+			"138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a",
+		},
+		{
+			"466f7250617369",
+			"afbeb0f07dfbf5419200f2ccb50bb24f",
+		},
+	}
+
+	for _, c := range cases {
+		key, _ := hex.DecodeString(c.key)
+		expected, _ := hex.DecodeString(c.wrapped)
+
+		wrapped, err := WrapWithPadding(kek, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wrapped, expected) {
+			t.Fatalf("bad: expected %x, got %x", expected, wrapped)
+		}
+
+		unwrapped, err := UnwrapWithPadding(kek, wrapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Fatalf("bad: expected %x, got %x", key, unwrapped)
+		}
+
+		// Any modification must be detected
+		wrapped[len(wrapped)-1] ^= 1
+		if _, err := UnwrapWithPadding(kek, wrapped); err == nil {
+			t.Fatal("expected integrity check failure")
+		}
+	}
+}
+
+func TestUnwrapWithPadding_badLength(t *testing.T) {
+	kek := make([]byte, 32)
+	for _, wrapped := range [][]byte{nil, make([]byte, 8), make([]byte, 20)} {
+		if _, err := UnwrapWithPadding(kek, wrapped); err == nil {
+			t.Fatalf("expected error for length %d", len(wrapped))
+		}
+	}
+}
@@ -26,6 +26,18 @@ func StrListSubset(super, sub []string) bool {
 	return true
 }
 
+// StrListDelete returns a copy of a list of strings without the
+// occurrences of a given string
+func StrListDelete(s []string, d string) []string {
+	out := make([]string, 0, len(s))
+	for _, item := range s {
+		if item != d {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 // Parses a comma separated list of strings into a slice of strings.
 // The return slice will be sorted and will not contain duplicate or
 // empty items. The values will be converted to lower case.
@@ -57,3 +69,23 @@ func RemoveDuplicates(items []string) []string {
 	sort.Strings(items)
 	return items
 }
+
+// GlobbedStringsMatch checks if the value matches the item, which may start
+// or end with a '*' matching any characters. A lone '*' matches any value.
+func GlobbedStringsMatch(item, val string) bool {
+	if item == "*" {
+		return true
+	}
+
+	hasPrefix := strings.HasPrefix(item, "*")
+	hasSuffix := strings.HasSuffix(item, "*")
+	switch {
+	case hasPrefix && hasSuffix:
+		return strings.Contains(val, item[1:len(item)-1])
+	case hasPrefix:
+		return strings.HasSuffix(val, item[1:])
+	case hasSuffix:
+		return strings.HasPrefix(val, item[:len(item)-1])
+	}
+	return val == item
+}
@@ -1,6 +1,9 @@
 package strutil
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestStrListContains(t *testing.T) {
 	haystack := []string{
@@ -47,3 +50,39 @@ func TestStrListSubset(t *testing.T) {
 		t.Fatalf("Bad")
 	}
 }
+
+func TestStrListDelete(t *testing.T) {
+	actual := StrListDelete([]string{"dev", "ops", "dev", "prod"}, "dev")
+	if !reflect.DeepEqual(actual, []string{"ops", "prod"}) {
+		t.Fatalf("bad: %#v", actual)
+	}
+	if actual := StrListDelete(nil, "dev"); len(actual) != 0 {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestGlobbedStringsMatch(t *testing.T) {
+	tcases := []struct {
+		item   string
+		val    string
+		expect bool
+	}{
+		{"", "", true},
+		{"*", "", true},
+		{"*", "foo", true},
+		{"foo", "foo", true},
+		{"foo", "foobar", false},
+		{"foo*", "foobar", true},
+		{"foo*", "barfoo", false},
+		{"*foo", "barfoo", true},
+		{"*foo", "foobar", false},
+		{"*foo*", "barfoobar", true},
+		{"*foo*", "barbaz", false},
+	}
+
+	for _, tc := range tcases {
+		if actual := GlobbedStringsMatch(tc.item, tc.val); actual != tc.expect {
+			t.Fatalf("bad: %q %q: %v", tc.item, tc.val, actual)
+		}
+	}
+}
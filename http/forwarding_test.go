@@ -0,0 +1,164 @@
+package http
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/physical"
+	"github.com/hashicorp/vault/vault"
+)
+
+// testClusterListenerAddr returns a free local address for a cluster
+// listener
+func testClusterListenerAddr(t *testing.T) *net.TCPAddr {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr)
+}
+
+// testWaitForActive waits until one of the cores becomes active
+func testWaitForActive(t *testing.T, cores []*vault.Core) *vault.Core {
+	for i := 0; i < 50; i++ {
+		for _, core := range cores {
+			if sealed, _ := core.Sealed(); sealed {
+				continue
+			}
+			if standby, _ := core.Standby(); !standby {
+				return core
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("no core became active")
+	return nil
+}
+
+func TestHTTP_Forwarding(t *testing.T) {
+	inmha := physical.NewInmemHA(logger)
+
+	var cores []*vault.Core
+	var addrs []string
+	var clusterAddrs []string
+	for i := 0; i < 3; i++ {
+		ln, addr := TestListener(t)
+		defer ln.Close()
+
+		clusterListenerAddr := testClusterListenerAddr(t)
+		clusterAddr := fmt.Sprintf("https://%s", clusterListenerAddr)
+
+		// The advertise addresses are unreachable, so that requests only
+		// succeed on standbys if they are forwarded rather than redirected
+		core, err := vault.NewCore(&vault.CoreConfig{
+			Physical:             inmha,
+			HAPhysical:           inmha,
+			AdvertiseAddr:        "http://127.0.0.1:1",
+			ClusterAddr:          clusterAddr,
+			ClusterListenerAddrs: []*net.TCPAddr{clusterListenerAddr},
+			DisableMlock:         true,
+		})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		TestServerWithListener(t, ln, addr, core)
+
+		cores = append(cores, core)
+		addrs = append(addrs, addr)
+		clusterAddrs = append(clusterAddrs, clusterAddr)
+	}
+
+	key, root := vault.TestCoreInit(t, cores[0])
+	if _, err := cores[0].Unseal(vault.TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+	testWaitForActive(t, cores[:1])
+	for _, core := range cores[1:] {
+		if _, err := core.Unseal(vault.TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+
+	// The standbys show the cluster address of the leader
+	resp := testHttpGet(t, "", addrs[1]+"/v1/sys/leader")
+	var leader map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &leader)
+	expectedLeader := map[string]interface{}{
+		"ha_enabled":             true,
+		"is_self":                false,
+		"leader_address":         "http://127.0.0.1:1",
+		"leader_cluster_address": clusterAddrs[0],
+	}
+	if !reflect.DeepEqual(leader, expectedLeader) {
+		t.Fatalf("bad: %#v", leader)
+	}
+
+	// The health of a node shows its own cluster address
+	resp = testHttpGet(t, "", addrs[1]+"/v1/sys/health?standbyok")
+	var health map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &health)
+	if health["standby"] != true || health["cluster_address"] != clusterAddrs[1] {
+		t.Fatalf("bad: %#v", health)
+	}
+
+	// Write through a standby and read through the other one
+	resp = testHttpPut(t, root, addrs[1]+"/v1/secret/foo", map[string]interface{}{
+		"data": "bar",
+	})
+	testResponseStatus(t, resp, 204)
+
+	resp = testHttpGet(t, root, addrs[2]+"/v1/secret/foo")
+	var secret map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &secret)
+	if data, ok := secret["data"].(map[string]interface{}); !ok || data["data"] != "bar" {
+		t.Fatalf("bad: %#v", secret)
+	}
+
+	// Errors of the active node are passed on as they are
+	resp = testHttpGet(t, "", addrs[1]+"/v1/secret/foo")
+	testResponseStatus(t, resp, 400)
+
+	// The cluster port only accepts clients presenting the cluster
+	// certificate
+	client := cleanhttp.DefaultClient()
+	client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true,
+	}
+	if resp, err := client.Get(clusterAddrs[0] + "/v1/sys/health"); err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected the cluster port to reject a client without certificate")
+	}
+
+	// Seal the active node; requests are forwarded to the new leader
+	if err := cores[0].Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	active := testWaitForActive(t, cores[1:])
+	standbyAddr := addrs[1]
+	if active == cores[1] {
+		standbyAddr = addrs[2]
+	}
+
+	resp = testHttpPut(t, root, standbyAddr+"/v1/secret/foo", map[string]interface{}{
+		"data": "baz",
+	})
+	testResponseStatus(t, resp, 204)
+
+	resp = testHttpGet(t, root, standbyAddr+"/v1/secret/foo")
+	secret = nil
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &secret)
+	if data, ok := secret["data"].(map[string]interface{}); !ok || data["data"] != "baz" {
+		t.Fatalf("bad: %#v", secret)
+	}
+}
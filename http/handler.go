@@ -22,6 +22,10 @@ const (
 	// WrapHeaderName is the name of the header containing a directive to wrap the
 	// response.
 	WrapTTLHeaderName = "X-Vault-Wrap-TTL"
+
+	// NamespaceHeaderName is the name of the header containing the path of
+	// the namespace of the request.
+	NamespaceHeaderName = "X-Vault-Namespace"
 )
 
 // Handler returns an http.Handler for the API. This can be used on
@@ -34,7 +38,8 @@ func Handler(core *vault.Core) http.Handler {
 	mux.Handle("/v1/sys/seal", handleSysSeal(core))
 	mux.Handle("/v1/sys/step-down", handleSysStepDown(core))
 	mux.Handle("/v1/sys/unseal", handleSysUnseal(core))
-	mux.Handle("/v1/sys/renew/", handleLogical(core, false, nil))
+	mux.Handle("/v1/sys/renew/", handleRequestForwarding(core, handleLogical(core, false, nil)))
+	mux.Handle("/v1/sys/leases/", handleRequestForwarding(core, handleLogical(core, false, nil)))
 	mux.Handle("/v1/sys/leader", handleSysLeader(core))
 	mux.Handle("/v1/sys/health", handleSysHealth(core))
 	mux.Handle("/v1/sys/generate-root/attempt", handleSysGenerateRootAttempt(core))
@@ -43,16 +48,125 @@ func Handler(core *vault.Core) http.Handler {
 	mux.Handle("/v1/sys/rekey/update", handleSysRekeyUpdate(core, false))
 	mux.Handle("/v1/sys/rekey-recovery-key/init", handleSysRekeyInit(core, true))
 	mux.Handle("/v1/sys/rekey-recovery-key/update", handleSysRekeyUpdate(core, true))
-	mux.Handle("/v1/sys/capabilities-self", handleLogical(core, true, sysCapabilitiesSelfCallback))
-	mux.Handle("/v1/sys/", handleLogical(core, true, nil))
-	mux.Handle("/v1/", handleLogical(core, false, nil))
+	mux.Handle("/v1/sys/capabilities-self", handleRequestForwarding(core, handleLogical(core, true, sysCapabilitiesSelfCallback)))
+	mux.Handle("/v1/sys/wrapping/", handleRequestForwarding(core, handleLogical(core, false, nil)))
+	mux.Handle("/v1/sys/", handleRequestForwarding(core, handleLogical(core, true, nil)))
+	mux.Handle("/v1/", handleRequestForwarding(core, handleLogical(core, false, nil)))
+
+	// The namespaces other than the root one only serve their logical paths
+	nsMux := http.NewServeMux()
+	nsMux.Handle("/v1/sys/capabilities-self", handleRequestForwarding(core, handleLogical(core, true, sysCapabilitiesSelfCallback)))
+	nsMux.Handle("/v1/sys/renew/", handleRequestForwarding(core, handleLogical(core, false, nil)))
+	nsMux.Handle("/v1/sys/", handleRequestForwarding(core, handleLogical(core, true, nil)))
+	nsMux.Handle("/v1/", handleRequestForwarding(core, handleLogical(core, false, nil)))
+
+	// Wrap the handlers in other handlers to trigger all help paths and to
+	// dispatch the requests of the namespaces.
+	handler := handleNamespace(core, handleHelpHandler(mux, core), handleHelpHandler(nsMux, core))
 
-	// Wrap the handler in another handler to trigger all help paths.
-	handler := handleHelpHandler(mux, core)
+	// Requests forwarded by standbys are served by the same handler
+	core.SetClusterHandler(handler)
 
 	return handler
 }
 
+// handleRequestForwarding forwards the request to the active node if this
+// node is a standby. If the active node cannot be reached over the cluster
+// port, the request is handled locally, which redirects the client.
+func handleRequestForwarding(core *vault.Core, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests received over the cluster port were already forwarded
+		if r.Header.Get(vault.IntNoForwardingHeaderName) != "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		// Note: on a standby this also sets up the connection to the leader,
+		// as it reads the cluster information the leader advertises
+		isLeader, leaderAddr, _, err := core.Leader()
+		if err != nil {
+			if errwrap.Contains(err, vault.ErrHANotEnabled.Error()) ||
+				errwrap.Contains(err, vault.ErrSealed.Error()) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if isLeader || leaderAddr == "" {
+			// Without a known leader the local handling responds accordingly
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		statusCode, header, body, err := core.ForwardRequest(r)
+		if err == vault.ErrCannotForward {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(statusCode)
+		w.Write(body)
+	})
+}
+
+// handleNamespace dispatches the requests of the namespaces other than the
+// root one to the namespace handler. The namespace of a request is selected
+// with the namespace header, a path prefix or both, in which case the path is
+// relative to the namespace of the header. The path of a namespace request is
+// rewritten to the one within the namespace, and the header is set to the
+// path of the namespace, which the logical requests are prefixed with.
+func handleNamespace(core *vault.Core, rootHandler, nsHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := stripPrefix("/v1/", r.URL.Path)
+		if !ok {
+			rootHandler.ServeHTTP(w, r)
+			return
+		}
+		header := requestNamespace(r)
+
+		// The namespaces are only known once unsealed
+		if sealed, err := core.Sealed(); err != nil || sealed {
+			r.Header.Del(NamespaceHeaderName)
+			rootHandler.ServeHTTP(w, r)
+			return
+		}
+
+		nsPath, rest := core.SplitNamespacePath(header + path)
+		if !strings.HasPrefix(nsPath, header) {
+			respondError(w, http.StatusNotFound, fmt.Errorf("namespace %q not found", header))
+			return
+		}
+		if nsPath == "" {
+			r.Header.Del(NamespaceHeaderName)
+			rootHandler.ServeHTTP(w, r)
+			return
+		}
+
+		r.URL.Path = "/v1/" + rest
+		r.Header.Set(NamespaceHeaderName, nsPath)
+		nsHandler.ServeHTTP(w, r)
+	})
+}
+
+// requestNamespace returns the path of the namespace given with the
+// namespace header, ending in a slash, or the empty string for the root
+// namespace
+func requestNamespace(r *http.Request) string {
+	ns := strings.Trim(r.Header.Get(NamespaceHeaderName), "/")
+	if ns == "" {
+		return ""
+	}
+	return ns + "/"
+}
+
 // ClientToken is required in the handler of sys/capabilities-self endpoint in
 // system backend. But the ClientToken gets obfuscated before the request gets
 // forwarded to any logical backend. So, setting the ClientToken in the data
@@ -89,6 +203,21 @@ func parseRequest(r *http.Request, out interface{}) error {
 	return err
 }
 
+// parseQuery converts the query parameters of a request into request data.
+// Only the first value of each parameter is used. It returns nil if there
+// are no parameters.
+func parseQuery(values url.Values) map[string]interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+
+	data := make(map[string]interface{}, len(values))
+	for k := range values {
+		data[k] = values.Get(k)
+	}
+	return data
+}
+
 // request is a helper to perform a request and properly exit in the
 // case of an error.
 func request(core *vault.Core, w http.ResponseWriter, rawReq *http.Request, r *logical.Request) (*logical.Response, bool) {
@@ -107,7 +236,7 @@ func request(core *vault.Core, w http.ResponseWriter, rawReq *http.Request, r *l
 // respondStandby is used to trigger a redirect in the case that this Vault is currently a hot standby
 func respondStandby(core *vault.Core, w http.ResponseWriter, reqURL *url.URL) {
 	// Request the leader address
-	_, advertise, err := core.Leader()
+	_, advertise, _, err := core.Leader()
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, err)
 		return
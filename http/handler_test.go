@@ -58,6 +58,14 @@ func TestSysMounts_headerAuth(t *testing.T) {
 				"max_lease_ttl":     float64(0),
 			},
 		},
+		"identity/": map[string]interface{}{
+			"description": "identity store",
+			"type":        "identity",
+			"config": map[string]interface{}{
+				"default_lease_ttl": float64(0),
+				"max_lease_ttl":     float64(0),
+			},
+		},
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
@@ -137,3 +145,22 @@ func TestHandler_error(t *testing.T) {
 	}
 
 }
+
+func TestHandler_parseQuery(t *testing.T) {
+	if data := parseQuery(nil); data != nil {
+		t.Fatalf("bad: %#v", data)
+	}
+
+	req, err := http.NewRequest("GET", "http://127.0.0.1/v1/secret/foo?version=2&a=b&a=c", nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"version": "2",
+		"a":       "b",
+	}
+	if data := parseQuery(req.URL.Query()); !reflect.DeepEqual(data, expected) {
+		t.Fatalf("bad: %#v", data)
+	}
+}
@@ -29,7 +29,7 @@ func handleHelp(core *vault.Core, w http.ResponseWriter, req *http.Request) {
 
 	resp, err := core.HandleRequest(requestAuth(req, &logical.Request{
 		Operation:  logical.HelpOperation,
-		Path:       path,
+		Path:       requestNamespace(req) + path,
 		Connection: getConnection(req),
 	}))
 	if err != nil {
@@ -2,6 +2,7 @@ package http
 
 import (
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"strconv"
@@ -12,6 +13,14 @@ import (
 	"github.com/hashicorp/vault/vault"
 )
 
+// ocspRequestContentType is the Content-Type of OCSP requests sent via POST,
+// as defined in RFC 6960.
+const ocspRequestContentType = "application/ocsp-request"
+
+// binaryContentType is the Content-Type of requests carrying arbitrary
+// binary data, such as raft snapshots.
+const binaryContentType = "application/octet-stream"
+
 type PrepareRequestFunc func(req *logical.Request) error
 
 func buildLogicalRequest(w http.ResponseWriter, r *http.Request) (*logical.Request, int, error) {
@@ -23,13 +32,14 @@ func buildLogicalRequest(w http.ResponseWriter, r *http.Request) (*logical.Reque
 	if path == "" {
 		return nil, http.StatusNotFound, nil
 	}
+	path = requestNamespace(r) + path
 
 	// Determine the operation
 	var op logical.Operation
 	switch r.Method {
 	case "DELETE":
 		op = logical.DeleteOperation
-	case "GET":
+	case "GET", "HEAD":
 		op = logical.ReadOperation
 		// Need to call ParseForm to get query params loaded
 		queryVals := r.URL.Query()
@@ -53,7 +63,20 @@ func buildLogicalRequest(w http.ResponseWriter, r *http.Request) (*logical.Reque
 
 	// Parse the request if we can
 	var data map[string]interface{}
-	if op == logical.UpdateOperation {
+	contentType := r.Header.Get("Content-Type")
+	if op == logical.UpdateOperation &&
+		(contentType == ocspRequestContentType || contentType == binaryContentType) {
+		// OCSP requests are DER-encoded rather than JSON, and binary
+		// requests are not encoded at all, so pass the body through
+		// untouched for the backend to parse
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		data = map[string]interface{}{
+			logical.HTTPRawBody: body,
+		}
+	} else if op == logical.UpdateOperation {
 		err := parseRequest(r, &data)
 		if err == io.EOF {
 			data = nil
@@ -87,6 +110,20 @@ func handleLogical(core *vault.Core, dataOnly bool, prepareRequestCallback Prepa
 			return
 		}
 
+		// HEAD requests are only served by the backends asking for them,
+		// e.g. to issue ACME nonces
+		if r.Method == "HEAD" && !core.HeadPath(req.Path) {
+			respondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		// Query parameters are only passed through to the reads of the
+		// backends asking for them, e.g. to select a particular version of
+		// a secret
+		if req.Operation == logical.ReadOperation && core.QueryParamsPath(req.Path) {
+			req.Data = parseQuery(r.URL.Query())
+		}
+
 		// Certain endpoints may require changes to the request object. They
 		// will have a callback registered to do the needed operations, so
 		// invoke it before proceeding.
@@ -167,6 +204,7 @@ func respondLogical(w http.ResponseWriter, r *http.Request, path string, dataOnl
 					TTL:             int(resp.WrapInfo.TTL.Seconds()),
 					CreationTime:    resp.WrapInfo.CreationTime,
 					WrappedAccessor: resp.WrapInfo.WrappedAccessor,
+					CreationPath:    resp.WrapInfo.CreationPath,
 				},
 			}
 		} else {
@@ -225,6 +263,20 @@ func respondRaw(w http.ResponseWriter, r *http.Request, path string, resp *logic
 		return
 	}
 
+	// Get the optional headers
+	if headersRaw, ok := resp.Data[logical.HTTPRawHeaders]; ok {
+		headers, ok := headersRaw.(map[string][]string)
+		if !ok {
+			respondError(w, http.StatusInternalServerError, nil)
+			return
+		}
+		for k, values := range headers {
+			for _, v := range values {
+				w.Header().Add(k, v)
+			}
+		}
+	}
+
 	// Write the response
 	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
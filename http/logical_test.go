@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"reflect"
 	"testing"
@@ -220,6 +221,9 @@ func TestLogical_RawHTTP(t *testing.T) {
 	if resp.Header.Get("Content-Type") != "plain/text" {
 		t.Fatalf("Bad: %#v", resp.Header)
 	}
+	if resp.Header.Get("Cache-Control") != "no-store" {
+		t.Fatalf("Bad: %#v", resp.Header)
+	}
 
 	// Get the body
 	body := new(bytes.Buffer)
@@ -227,4 +231,73 @@ func TestLogical_RawHTTP(t *testing.T) {
 	if string(body.Bytes()) != "hello world" {
 		t.Fatalf("Bad: %s", body.Bytes())
 	}
+
+	// HEAD requests are reads without a body
+	resp, err := http.Head(addr + "/v1/foo/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testResponseStatus(t, resp, 200)
+	if resp.Header.Get("Cache-Control") != "no-store" {
+		t.Fatalf("Bad: %#v", resp.Header)
+	}
+	// Other paths do not serve HEAD requests
+	resp, err = http.Head(addr + "/v1/foo/query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testResponseStatus(t, resp, 405)
+}
+
+func TestLogical_QueryParams(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPost(t, token, addr+"/v1/sys/mounts/foo", map[string]interface{}{
+		"type": "http",
+	})
+	testResponseStatus(t, resp, 204)
+
+	// Query parameters are only passed to the paths asking for them
+	for path, expected := range map[string]string{
+		"raw":   "hello world",
+		"query": "bar",
+	} {
+		resp = testHttpGet(t, token, addr+"/v1/foo/"+path+"?body=bar")
+		testResponseStatus(t, resp, 200)
+
+		body := new(bytes.Buffer)
+		io.Copy(body, resp.Body)
+		if string(body.Bytes()) != expected {
+			t.Fatalf("bad: %s: %s", path, body.Bytes())
+		}
+	}
+}
+
+func TestLogical_RawRequestBody(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPost(t, token, addr+"/v1/sys/mounts/foo", map[string]interface{}{
+		"type": "http",
+	})
+	testResponseStatus(t, resp, 204)
+
+	// OCSP requests are not JSON and must reach the backend untouched
+	reqBody := []byte{0x30, 0x03, 0x0A, 0x01, 0x01}
+	resp, err := http.Post(addr+"/v1/foo/ocsp", "application/ocsp-request", bytes.NewReader(reqBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testResponseStatus(t, resp, 200)
+
+	body := new(bytes.Buffer)
+	io.Copy(body, resp.Body)
+	if !bytes.Equal(body.Bytes(), reqBody) {
+		t.Fatalf("Bad: %v", body.Bytes())
+	}
 }
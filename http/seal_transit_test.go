@@ -0,0 +1,311 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/builtin/logical/transit"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
+	"github.com/hashicorp/vault/vault"
+)
+
+// testTransitSealCore returns an unsealed core with a transit backend
+// mounted at transit/ and a key named "unseal"
+func testTransitSealCore(t *testing.T) (*vault.Core, string) {
+	if err := vault.AddTestLogicalBackend("transit", transit.Factory); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	core, _, root := vault.TestCoreUnsealed(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/mounts/transit")
+	req.ClientToken = root
+	req.Data["type"] = "transit"
+	if _, err := core.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "transit/keys/unseal")
+	req.ClientToken = root
+	if _, err := core.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	return core, root
+}
+
+func testTransitSeal(t *testing.T, addr, token string) vault.Seal {
+	seal, err := vault.NewTransitSeal(map[string]string{
+		"address":  addr,
+		"token":    token,
+		"key_name": "unseal",
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return seal
+}
+
+func testSealedStatus(t *testing.T, core *vault.Core, expected bool) {
+	sealed, err := core.Sealed()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if sealed != expected {
+		t.Fatalf("bad: sealed is %v, expected %v", sealed, expected)
+	}
+}
+
+func testGenerateRootWithKeys(t *testing.T, core *vault.Core, keys [][]byte) *vault.GenerateRootResult {
+	otp, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := core.GenerateRootInit(base64.StdEncoding.EncodeToString(otp), ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	config, err := core.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var result *vault.GenerateRootResult
+	for _, key := range keys {
+		result, err = core.GenerateRootUpdate(key, config.Nonce)
+		if err != nil {
+			core.GenerateRootCancel()
+			return nil
+		}
+	}
+	return result
+}
+
+func TestSealTransit(t *testing.T) {
+	transitCore, transitRoot := testTransitSealCore(t)
+	transitLn, transitAddr := TestServer(t, transitCore)
+	defer transitLn.Close()
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	inm := physical.NewInmem(logger)
+	core, err := vault.NewCore(&vault.CoreConfig{
+		Physical:     inm,
+		Seal:         testTransitSeal(t, transitAddr, transitRoot),
+		DisableMlock: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	// The barrier key must be stored by the seal
+	resp := testHttpPut(t, "", addr+"/v1/sys/init", map[string]interface{}{
+		"secret_shares":    5,
+		"secret_threshold": 3,
+	})
+	testResponseStatus(t, resp, 400)
+
+	resp = testHttpPut(t, "", addr+"/v1/sys/init", map[string]interface{}{
+		"secret_shares":      1,
+		"secret_threshold":   1,
+		"stored_shares":      1,
+		"recovery_shares":    3,
+		"recovery_threshold": 2,
+	})
+	var initResp map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &initResp)
+	if keys := initResp["keys"].([]interface{}); len(keys) != 0 {
+		t.Fatalf("bad: %#v", initResp)
+	}
+	var recoveryKeys [][]byte
+	for _, key := range initResp["recovery_keys"].([]interface{}) {
+		decoded, err := hex.DecodeString(key.(string))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		recoveryKeys = append(recoveryKeys, decoded)
+	}
+	if len(recoveryKeys) != 3 {
+		t.Fatalf("bad: %#v", initResp)
+	}
+	root := initResp["root_token"].(string)
+
+	// Initializing unseals the core with the stored key
+	testSealedStatus(t, core, false)
+
+	// The stored key is not kept in plaintext
+	entry, err := inm.Get("core/hsm/barrier-unseal-keys")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if entry == nil || string(entry.Value[:9]) != "vault:v1:" {
+		t.Fatalf("bad: %#v", entry)
+	}
+
+	// A restarted core unseals itself
+	if err := core.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testSealedStatus(t, core, true)
+	core2, err := vault.NewCore(&vault.CoreConfig{
+		Physical:     inm,
+		Seal:         testTransitSeal(t, transitAddr, transitRoot),
+		DisableMlock: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testSealedStatus(t, core2, false)
+	ln2, addr2 := TestServer(t, core2)
+	defer ln2.Close()
+
+	// Root generation uses the recovery keys
+	if result := testGenerateRootWithKeys(t, core2, recoveryKeys[:1]); result == nil || result.Required != 2 {
+		t.Fatalf("bad: %#v", result)
+	}
+	core2.GenerateRootCancel()
+	if result := testGenerateRootWithKeys(t, core2, recoveryKeys[1:]); result == nil || result.EncodedRootToken == "" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// The barrier cannot be rekeyed, but the recovery key can
+	resp = testHttpPut(t, root, addr2+"/v1/sys/rekey/init", map[string]interface{}{
+		"secret_shares":    1,
+		"secret_threshold": 1,
+	})
+	testResponseStatus(t, resp, 400)
+
+	resp = testHttpPut(t, root, addr2+"/v1/sys/rekey-recovery-key/init", map[string]interface{}{
+		"secret_shares":    1,
+		"secret_threshold": 1,
+	})
+	testResponseStatus(t, resp, 200)
+	rekeyConfig, err := core2.RekeyConfig(true)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var rekeyResult *vault.RekeyResult
+	for _, key := range recoveryKeys[:2] {
+		rekeyResult, err = core2.RekeyUpdate(key, rekeyConfig.Nonce, true)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if rekeyResult == nil || len(rekeyResult.SecretShares) != 1 {
+		t.Fatalf("bad: %#v", rekeyResult)
+	}
+
+	// The old recovery keys are no longer valid
+	if result := testGenerateRootWithKeys(t, core2, recoveryKeys[:2]); result != nil {
+		t.Fatalf("bad: %#v", result)
+	}
+	if result := testGenerateRootWithKeys(t, core2, rekeyResult.SecretShares); result == nil || result.EncodedRootToken == "" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// The core stays sealed if the transit backend is unavailable
+	if err := core2.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := transitCore.Seal(transitRoot); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	core3, err := vault.NewCore(&vault.CoreConfig{
+		Physical:     inm,
+		Seal:         testTransitSeal(t, transitAddr, transitRoot),
+		DisableMlock: true,
+	})
+	if err == nil {
+		t.Fatalf("expected error unsealing without the transit backend")
+	}
+	testSealedStatus(t, core3, true)
+}
+
+func TestSealTransit_migration(t *testing.T) {
+	transitCore, transitRoot := testTransitSealCore(t)
+	transitLn, transitAddr := TestServer(t, transitCore)
+	defer transitLn.Close()
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	inm := physical.NewInmem(logger)
+	core, err := vault.NewCore(&vault.CoreConfig{
+		Physical:     inm,
+		DisableMlock: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	result, err := core.Initialize(&vault.SealConfig{
+		SecretShares:    3,
+		SecretThreshold: 2,
+	}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Starting with the transit seal, the migration is pending
+	core, err = vault.NewCore(&vault.CoreConfig{
+		Physical:     inm,
+		Seal:         testTransitSeal(t, transitAddr, transitRoot),
+		DisableMlock: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	resp := testHttpGet(t, "", addr+"/v1/sys/seal-status")
+	var actual map[string]interface{}
+	expected := map[string]interface{}{
+		"sealed":    true,
+		"t":         float64(2),
+		"n":         float64(3),
+		"progress":  float64(0),
+		"migration": true,
+	}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// Unsealing requires the migrate option
+	resp = testHttpPut(t, "", addr+"/v1/sys/unseal", map[string]interface{}{
+		"key": hex.EncodeToString(result.SecretShares[0]),
+	})
+	testResponseStatus(t, resp, 400)
+
+	for _, key := range result.SecretShares[:2] {
+		resp = testHttpPut(t, "", addr+"/v1/sys/unseal", map[string]interface{}{
+			"key":     hex.EncodeToString(key),
+			"migrate": true,
+		})
+		testResponseStatus(t, resp, 200)
+	}
+	testSealedStatus(t, core, false)
+
+	// The unseal keys are now the recovery keys
+	if result := testGenerateRootWithKeys(t, core, result.SecretShares[1:]); result == nil || result.EncodedRootToken == "" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	resp = testHttpGet(t, "", addr+"/v1/sys/seal-status")
+	actual = nil
+	expected = map[string]interface{}{
+		"sealed":   false,
+		"t":        float64(1),
+		"n":        float64(1),
+		"progress": float64(0),
+	}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
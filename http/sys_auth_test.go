@@ -2,6 +2,7 @@ package http
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/vault/vault"
@@ -28,6 +29,7 @@ func TestSysAuth(t *testing.T) {
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
+	testAuthAccessors(t, expected, actual)
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("bad: expected:%#v\nactual:%#v", expected, actual)
 	}
@@ -68,6 +70,7 @@ func TestSysEnableAuth(t *testing.T) {
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
+	testAuthAccessors(t, expected, actual)
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("bad: expected:%#v\nactual:%#v", expected, actual)
 	}
@@ -103,7 +106,24 @@ func TestSysDisableAuth(t *testing.T) {
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
+	testAuthAccessors(t, expected, actual)
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("bad: expected:%#v\nactual:%#v", expected, actual)
 	}
 }
+
+// testAuthAccessors checks the format of the random accessors of the
+// listed credential backends, and copies them to the expected listing
+func testAuthAccessors(t *testing.T, expected, actual map[string]interface{}) {
+	for path, raw := range expected {
+		mount, ok := actual[path].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		accessor, _ := mount["accessor"].(string)
+		if !strings.HasPrefix(accessor, "auth_"+raw.(map[string]interface{})["type"].(string)+"_") {
+			t.Fatalf("bad: %#v", actual)
+		}
+		raw.(map[string]interface{})["accessor"] = accessor
+	}
+}
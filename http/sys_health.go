@@ -115,17 +115,19 @@ func getSysHealth(core *vault.Core, r *http.Request) (int, *HealthResponse, erro
 
 	// Format the body
 	body := &HealthResponse{
-		Initialized:   init,
-		Sealed:        sealed,
-		Standby:       standby,
-		ServerTimeUTC: time.Now().UTC().Unix(),
+		Initialized:    init,
+		Sealed:         sealed,
+		Standby:        standby,
+		ServerTimeUTC:  time.Now().UTC().Unix(),
+		ClusterAddress: core.ClusterAddr(),
 	}
 	return code, body, nil
 }
 
 type HealthResponse struct {
-	Initialized   bool  `json:"initialized"`
-	Sealed        bool  `json:"sealed"`
-	Standby       bool  `json:"standby"`
-	ServerTimeUTC int64 `json:"server_time_utc"`
+	Initialized    bool   `json:"initialized"`
+	Sealed         bool   `json:"sealed"`
+	Standby        bool   `json:"standby"`
+	ServerTimeUTC  int64  `json:"server_time_utc"`
+	ClusterAddress string `json:"cluster_address,omitempty"`
 }
@@ -0,0 +1,82 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/vault"
+)
+
+// testHttpNamespace sends a request with the given namespace header
+func testHttpNamespace(t *testing.T, method, token, namespace, addr string, body interface{}) *http.Response {
+	bodyReader := new(bytes.Buffer)
+	if body != nil {
+		if err := json.NewEncoder(bodyReader).Encode(body); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	req, err := http.NewRequest(method, addr, bodyReader)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(AuthHeaderName, token)
+	req.Header.Set(NamespaceHeaderName, namespace)
+
+	resp, err := cleanhttp.DefaultClient().Do(req)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return resp
+}
+
+func TestSysNamespaces(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPost(t, token, addr+"/v1/sys/namespaces/team", nil)
+	testResponseStatus(t, resp, 204)
+
+	// Child namespaces are created with the header of their parent
+	resp = testHttpNamespace(t, "POST", token, "team", addr+"/v1/sys/namespaces/sub", nil)
+	testResponseStatus(t, resp, 204)
+
+	resp = testHttpNamespace(t, "LIST", token, "team", addr+"/v1/sys/namespaces", nil)
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if !reflect.DeepEqual(actual["keys"], []interface{}{"sub/"}) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// The header and the path prefix select the same namespace
+	resp = testHttpNamespace(t, "POST", token, "team", addr+"/v1/sys/mounts/secret", map[string]interface{}{
+		"type": "generic",
+	})
+	testResponseStatus(t, resp, 204)
+	resp = testHttpPut(t, token, addr+"/v1/team/secret/foo", map[string]interface{}{
+		"value": "bar",
+	})
+	testResponseStatus(t, resp, 204)
+	resp = testHttpNamespace(t, "GET", token, "team/", addr+"/v1/secret/foo", nil)
+	actual = nil
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if !reflect.DeepEqual(actual["data"], map[string]interface{}{"value": "bar"}) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// The system paths of the root namespace are not served in namespaces
+	resp = testHttpNamespace(t, "PUT", token, "team", addr+"/v1/sys/seal", nil)
+	testResponseStatus(t, resp, 404)
+
+	resp = testHttpNamespace(t, "GET", token, "other", addr+"/v1/secret/foo", nil)
+	testResponseStatus(t, resp, 404)
+}
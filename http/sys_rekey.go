@@ -100,8 +100,9 @@ func handleSysRekeyInitPut(core *vault.Core, recovery bool, w http.ResponseWrite
 
 	// Right now we don't support this, but the rest of the code is ready for
 	// when we do, hence the check below for this to be false if
-	// StoredShares is greater than zero
-	if core.SealAccess().StoredKeysSupported() {
+	// StoredShares is greater than zero. The recovery key can still be
+	// rekeyed.
+	if !recovery && core.SealAccess().StoredKeysSupported() {
 		respondError(w, http.StatusBadRequest, fmt.Errorf("rekeying of barrier not supported when stored key support is available"))
 		return
 	}
@@ -105,8 +105,18 @@ func handleSysUnseal(core *vault.Core) http.Handler {
 				return
 			}
 
-			// Attempt the unseal
-			if _, err := core.Unseal(key); err != nil {
+			// Attempt the unseal, completing a pending seal migration if
+			// requested
+			unseal := core.Unseal
+			if req.Migrate {
+				unseal = core.UnsealMigrate
+			}
+			if _, err := unseal(key); err != nil {
+				if err == vault.ErrSealMigrationPending {
+					respondError(w, http.StatusBadRequest, err)
+					return
+				}
+
 				// Ignore ErrInvalidKey because its a user error that we
 				// mask away. We just show them the seal status.
 				if !errwrap.ContainsType(err, new(vault.ErrInvalidKey)) {
@@ -150,22 +160,36 @@ func handleSysSealStatusRaw(core *vault.Core, w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// While a seal migration is pending, the keys to provide are the ones
+	// of the migration
+	migrationConfig, err := core.SealMigrationKeyConfig()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if migrationConfig != nil {
+		sealConfig = migrationConfig
+	}
+
 	respondOk(w, &SealStatusResponse{
-		Sealed:   sealed,
-		T:        sealConfig.SecretThreshold,
-		N:        sealConfig.SecretShares,
-		Progress: core.SecretProgress(),
+		Sealed:    sealed,
+		T:         sealConfig.SecretThreshold,
+		N:         sealConfig.SecretShares,
+		Progress:  core.SecretProgress(),
+		Migration: migrationConfig != nil,
 	})
 }
 
 type SealStatusResponse struct {
-	Sealed   bool `json:"sealed"`
-	T        int  `json:"t"`
-	N        int  `json:"n"`
-	Progress int  `json:"progress"`
+	Sealed    bool `json:"sealed"`
+	T         int  `json:"t"`
+	N         int  `json:"n"`
+	Progress  int  `json:"progress"`
+	Migration bool `json:"migration,omitempty"`
 }
 
 type UnsealRequest struct {
-	Key   string
-	Reset bool
+	Key     string
+	Reset   bool
+	Migrate bool
 }
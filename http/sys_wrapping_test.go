@@ -0,0 +1,77 @@
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/vault"
+)
+
+func TestSysWrapping(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	// Wrap
+	req, err := http.NewRequest("PUT", addr+"/v1/sys/wrapping/wrap", bytes.NewBufferString(`{"foo":"bar"}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	req.Header.Set(WrapTTLHeaderName, "60s")
+	resp, err := cleanhttp.DefaultClient().Do(req)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var wrapped map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &wrapped)
+	wrapInfo, ok := wrapped["wrap_info"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("bad: %#v", wrapped)
+	}
+	if wrapInfo["creation_path"] != "sys/wrapping/wrap" {
+		t.Fatalf("bad: %#v", wrapInfo)
+	}
+	wrappingToken := wrapInfo["token"].(string)
+
+	// Lookup
+	resp = testHttpPut(t, token, addr+"/v1/sys/wrapping/lookup", map[string]interface{}{
+		"token": wrappingToken,
+	})
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	data, ok := actual["data"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("bad: %#v", actual)
+	}
+	if data["creation_ttl"] != float64(60) || data["creation_path"] != "sys/wrapping/wrap" {
+		t.Fatalf("bad: %#v", data)
+	}
+
+	// Unwrap
+	resp = testHttpPut(t, token, addr+"/v1/sys/wrapping/unwrap", map[string]interface{}{
+		"token": wrappingToken,
+	})
+	actual = nil
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	expected := map[string]interface{}{
+		"foo": "bar",
+	}
+	if !reflect.DeepEqual(actual["data"], expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// The token was consumed
+	resp = testHttpPut(t, token, addr+"/v1/sys/wrapping/unwrap", map[string]interface{}{
+		"token": wrappingToken,
+	})
+	testResponseStatus(t, resp, 400)
+}
@@ -40,6 +40,30 @@ type Auth struct {
 	// to revoke a ClientToken and to lookup the capabilities of the ClientToken,
 	// both without actually knowing the ClientToken.
 	Accessor string
+
+	// Alias is the name of the authenticated user within the backend. If
+	// set, Vault core maps it to an identity entity, which is created on
+	// the first login.
+	Alias *Alias
+
+	// GroupAliases are the names of the groups of the authenticated user
+	// within the backend, such as LDAP groups or GitHub teams. Vault core
+	// makes the entity a member of the external identity groups they are
+	// mapped to.
+	GroupAliases []*Alias
+
+	// EntityID is the identifier of the identity entity of the
+	// ClientToken. This will be filled in by Vault core.
+	EntityID string
+}
+
+// Alias is the name of a user or a group within a credential backend
+type Alias struct {
+	// Name is the name of the user or group, unique within the backend
+	Name string
+
+	// Metadata is attached to the alias of the identity entity
+	Metadata map[string]string
 }
 
 func (a *Auth) GoString() string {
@@ -520,6 +520,8 @@ func (t FieldType) Zero() interface{} {
 		return map[string]interface{}{}
 	case TypeDurationSecond:
 		return 0
+	case TypeSlice:
+		return []interface{}{}
 	default:
 		panic("unknown type: " + t.String())
 	}
@@ -92,6 +92,26 @@ func TestFieldDataGet(t *testing.T) {
 			},
 		},
 
+		"slice type, slice value": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeSlice},
+			},
+			map[string]interface{}{
+				"foo": []interface{}{"bar", 42},
+			},
+			"foo",
+			[]interface{}{"bar", 42},
+		},
+
+		"slice type, missing value": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeSlice},
+			},
+			map[string]interface{}{},
+			"foo",
+			[]interface{}{},
+		},
+
 		"duration type, string value": {
 			map[string]*FieldSchema{
 				"foo": &FieldSchema{Type: TypeDurationSecond},
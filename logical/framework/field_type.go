@@ -13,6 +13,9 @@ const (
 	// TypeDurationSecond represent as seconds, this can be either an
 	// integer or go duration format string (e.g. 24h)
 	TypeDurationSecond
+
+	// TypeSlice represents a list of arbitrary values
+	TypeSlice
 )
 
 func (t FieldType) String() string {
@@ -27,6 +30,8 @@ func (t FieldType) String() string {
 		return "map"
 	case TypeDurationSecond:
 		return "duration (sec)"
+	case TypeSlice:
+		return "slice"
 	default:
 		return "unknown type"
 	}
@@ -64,4 +64,12 @@ type Paths struct {
 
 	// Unauthenticated are the paths that can be accessed without any auth.
 	Unauthenticated []string
+
+	// QueryParams are the paths whose reads are given the query parameters
+	// of the HTTP request as data.
+	QueryParams []string
+
+	// Head are the paths which also serve HTTP HEAD requests, handled as
+	// reads whose response body is dropped.
+	Head []string
 }
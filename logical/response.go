@@ -19,12 +19,19 @@ const (
 	// HTTPRawBody is the raw content of the HTTP body that goes with the HTTPContentType.
 	// This can only be specified for non-secrets, and should should be similarly
 	// avoided like the HTTPContentType. The value must be a byte slice.
+	// It is also set in the Data field of requests whose body is not JSON,
+	// such as OCSP requests.
 	HTTPRawBody = "http_raw_body"
 
 	// HTTPStatusCode is the response code of the HTTP body that goes with the HTTPContentType.
 	// This can only be specified for non-secrets, and should should be similarly
 	// avoided like the HTTPContentType. The value must be an integer.
 	HTTPStatusCode = "http_status_code"
+
+	// HTTPRawHeaders are additional headers sent with the HTTPRawBody, such
+	// as those required by a specification. This can only be specified for
+	// non-secrets. The value must be a map[string][]string.
+	HTTPRawHeaders = "http_raw_headers"
 )
 
 type WrapInfo struct {
@@ -42,6 +49,10 @@ type WrapInfo struct {
 	// If the contained response is the output of a token creation call, the
 	// created token's accessor will be accessible here
 	WrappedAccessor string
+
+	// The path of the request whose response was wrapped. Recipients can
+	// check it to ensure the response comes from the expected endpoint.
+	CreationPath string
 }
 
 // Response is a struct that stores the response of a request.
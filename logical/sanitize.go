@@ -28,6 +28,7 @@ func SanitizeResponse(input *Response) *HTTPResponse {
 			Metadata:      input.Auth.Metadata,
 			LeaseDuration: int(input.Auth.TTL.Seconds()),
 			Renewable:     input.Auth.Renewable,
+			EntityID:      input.Auth.EntityID,
 		}
 	}
 
@@ -51,6 +52,7 @@ type HTTPAuth struct {
 	Metadata      map[string]string `json:"metadata"`
 	LeaseDuration int               `json:"lease_duration"`
 	Renewable     bool              `json:"renewable"`
+	EntityID      string            `json:"entity_id,omitempty"`
 }
 
 type HTTPWrapInfo struct {
@@ -58,4 +60,5 @@ type HTTPWrapInfo struct {
 	TTL             int       `json:"ttl"`
 	CreationTime    time.Time `json:"creation_time"`
 	WrappedAccessor string    `json:"wrapped_accessor,omitempty"`
+	CreationPath    string    `json:"creation_path"`
 }
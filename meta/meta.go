@@ -43,6 +43,7 @@ type Meta struct {
 	flagCAPath     string
 	flagClientCert string
 	flagClientKey  string
+	flagNamespace  string
 	flagWrapTTL    string
 	flagInsecure   bool
 
@@ -111,6 +112,10 @@ func (m *Meta) Client() (*api.Client, error) {
 
 	client.SetWrappingLookupFunc(m.DefaultWrappingLookupFunc)
 
+	if m.flagNamespace != "" {
+		client.SetNamespace(m.flagNamespace)
+	}
+
 	// If we have a token directly, then set that
 	token := m.ClientToken
 
@@ -157,6 +162,7 @@ func (m *Meta) FlagSet(n string, fs FlagSetFlags) *flag.FlagSet {
 		f.StringVar(&m.flagCAPath, "ca-path", "", "")
 		f.StringVar(&m.flagClientCert, "client-cert", "", "")
 		f.StringVar(&m.flagClientKey, "client-key", "", "")
+		f.StringVar(&m.flagNamespace, "namespace", "", "")
 		f.StringVar(&m.flagWrapTTL, "wrap-ttl", "", "")
 		f.BoolVar(&m.flagInsecure, "insecure", false, "")
 		f.BoolVar(&m.flagInsecure, "tls-skip-verify", false, "")
@@ -204,6 +210,10 @@ func GeneralOptionsUsage() string {
                           Overrides the VAULT_CLIENT_KEY environment variable
                           if set.
 
+  -namespace=path         The namespace the paths of the requests are relative
+                          to. Overrides the VAULT_NAMESPACE environment
+                          variable if set.
+
   -tls-skip-verify        Do not verify TLS certificate. This is highly
                           not recommended. Verification will also be skipped
                           if VAULT_SKIP_VERIFY is set.
@@ -1,6 +1,7 @@
 package physical
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 )
@@ -71,6 +72,18 @@ type ServiceDiscovery interface {
 	RunServiceDiscovery(shutdownCh ShutdownChannel, advertiseAddr string, activeFunc activeFunction, sealedFunc sealedFunction) error
 }
 
+// Clustered is an optional interface that an HABackend can implement if its
+// nodes communicate with each other directly. Vault sets up the cluster once
+// it is unsealed, with a TLS configuration that authenticates the nodes by
+// the cluster certificate, and tears it down when it is sealed again.
+type Clustered interface {
+	// SetupCluster starts the communication with the other nodes
+	SetupCluster(tlsConfig *tls.Config) error
+
+	// TeardownCluster stops the communication with the other nodes
+	TeardownCluster() error
+}
+
 type Lock interface {
 	// Lock is used to acquire the given lock
 	// The stopCh is optional and if closed should interrupt the lock
@@ -120,6 +133,7 @@ var builtinBackends = map[string]Factory{
 	"mysql":      newMySQLBackend,
 	"postgresql": newPostgreSQLBackend,
 	"swift":      newSwiftBackend,
+	"raft":       newRaftBackend,
 }
 
 // PermitPool is a wrapper around a semaphore library to keep things
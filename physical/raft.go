@@ -0,0 +1,852 @@
+package physical
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+const (
+	// raftLogFileName is the name of the BoltDB file holding the raft log
+	raftLogFileName = "raft.db"
+
+	// raftNodeIDFileName is the name of the file the generated node ID is
+	// persisted in
+	raftNodeIDFileName = "node-id"
+
+	// raftDefaultAddress is the default address of the raft transport
+	raftDefaultAddress = "127.0.0.1:8202"
+
+	// raftApplyTimeout is the time to wait for a write to be replicated
+	raftApplyTimeout = 10 * time.Second
+
+	// raftRetainSnapshots is the number of snapshots kept on disk
+	raftRetainSnapshots = 2
+
+	// raftSnapshotMetaName and raftSnapshotStateName are the names of the
+	// files within the archive of a snapshot
+	raftSnapshotMetaName  = "meta.json"
+	raftSnapshotStateName = "state.bin"
+)
+
+// RaftBackend is a physical backend which replicates its data to the other
+// nodes of the cluster using the Raft consensus protocol, and persists it to
+// local BoltDB files. It is also an HA backend: only the leader of the Raft
+// cluster is able to write, so the HA lock is tied to Raft leadership.
+//
+// Raft only runs while Vault is unsealed, between SetupCluster and
+// TeardownCluster, so that a sealed node neither votes nor runs for leader.
+// Reads are served from the local state at all times.
+type RaftBackend struct {
+	path       string
+	localID    raft.ServerID
+	fsm        *raftFSM
+	stream     *raftStreamLayer
+	logStore   *raftboltdb.BoltStore
+	snapStore  raft.SnapshotStore
+	permitPool *PermitPool
+	logger     *log.Logger
+	logOutput  io.Writer
+
+	// raftLock protects raft, which is nil while raft is not running, and
+	// the channel closed when it stops
+	raftLock   sync.RWMutex
+	raft       *raft.Raft
+	raftStopCh chan struct{}
+
+	// l protects the leadership state and the bookkeeping of the locks
+	// held locally. stateCh is closed and replaced whenever any of them
+	// changes, to wake up the lock waiters.
+	l            sync.Mutex
+	leader       bool
+	leaderLostCh chan struct{}
+	heldLocks    map[string]bool
+	lockWaiters  map[string]int
+	stateCh      chan struct{}
+}
+
+// RaftPeer describes a member of the Raft cluster
+type RaftPeer struct {
+	NodeID  string `json:"node_id"`
+	Address string `json:"address"`
+	Leader  bool   `json:"leader"`
+	Voter   bool   `json:"voter"`
+}
+
+// newRaftBackend constructs a raft backend using the given configuration
+func newRaftBackend(conf map[string]string, logger *log.Logger) (Backend, error) {
+	return NewRaftBackend(conf, logger)
+}
+
+// NewRaftBackend constructs a raft backend storing its data in the
+// directory given by 'path'. The node listens for Raft traffic on
+// 'address'. If 'bootstrap' is set and the node has no state yet, a new
+// cluster is formed with this node as its only member; other nodes are
+// added to it using AddPeer.
+//
+// The node bootstrapping the cluster runs raft before Vault is unsealed for
+// the first time, as the initialization can only be written by the leader.
+func NewRaftBackend(conf map[string]string, logger *log.Logger) (*RaftBackend, error) {
+	path, ok := conf["path"]
+	if !ok {
+		return nil, fmt.Errorf("'path' must be set")
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create raft directory: %v", err)
+	}
+
+	var bootstrap bool
+	if bootstrapStr, ok := conf["bootstrap"]; ok && bootstrapStr != "" {
+		var err error
+		bootstrap, err = strconv.ParseBool(bootstrapStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing bootstrap parameter: %v", err)
+		}
+	}
+
+	localID, err := raftNodeID(path, conf["node_id"])
+	if err != nil {
+		return nil, err
+	}
+
+	address, ok := conf["address"]
+	if !ok {
+		address = raftDefaultAddress
+	}
+
+	var logOutput io.Writer = ioutil.Discard
+	if logger != nil {
+		logOutput = logger.Writer()
+	}
+
+	fsm, err := newRaftFSM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	logStore, err := raftboltdb.NewBoltStore(filepath.Join(path, raftLogFileName))
+	if err != nil {
+		fsm.Close()
+		return nil, fmt.Errorf("failed to open raft log: %v", err)
+	}
+	snapStore, err := raft.NewFileSnapshotStore(path, raftRetainSnapshots, logOutput)
+	if err != nil {
+		fsm.Close()
+		logStore.Close()
+		return nil, fmt.Errorf("failed to create raft snapshot store: %v", err)
+	}
+
+	b := &RaftBackend{
+		path:        path,
+		localID:     localID,
+		fsm:         fsm,
+		logStore:    logStore,
+		snapStore:   snapStore,
+		permitPool:  NewPermitPool(DefaultParallelOperations),
+		logger:      logger,
+		logOutput:   logOutput,
+		heldLocks:   make(map[string]bool),
+		lockWaiters: make(map[string]int),
+		stateCh:     make(chan struct{}),
+	}
+
+	b.stream, err = newRaftStreamLayer(address, b.provision, logger)
+	if err != nil {
+		fsm.Close()
+		logStore.Close()
+		return nil, fmt.Errorf("failed to create raft transport: %v", err)
+	}
+
+	if bootstrap {
+		hasState, err := raft.HasExistingState(logStore, logStore, snapStore)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		keys, err := fsm.list("")
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		if len(keys) == 0 {
+			b.raftLock.Lock()
+			err = b.startRaft(!hasState)
+			b.raftLock.Unlock()
+			if err != nil {
+				b.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return b, nil
+}
+
+// raftNodeID returns the configured node ID, or the one generated on the
+// first start of the node
+func raftNodeID(path, configured string) (raft.ServerID, error) {
+	if configured != "" {
+		return raft.ServerID(configured), nil
+	}
+
+	idPath := filepath.Join(path, raftNodeIDFileName)
+	existing, err := ioutil.ReadFile(idPath)
+	switch {
+	case err == nil:
+		return raft.ServerID(strings.TrimSpace(string(existing))), nil
+	case !os.IsNotExist(err):
+		return "", fmt.Errorf("failed to read raft node ID: %v", err)
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(idPath, []byte(id), 0600); err != nil {
+		return "", fmt.Errorf("failed to persist raft node ID: %v", err)
+	}
+	return raft.ServerID(id), nil
+}
+
+// startRaft starts raft over a new transport of the stream layer. If
+// bootstrap is set, a new cluster is formed with this node as its only
+// member first. The raft lock must be held.
+func (b *RaftBackend) startRaft(bootstrap bool) error {
+	transport := raft.NewNetworkTransport(b.stream.newStream(), 3, 10*time.Second, b.logOutput)
+	logCache, err := raft.NewLogCache(512, b.logStore)
+	if err != nil {
+		transport.Close()
+		return err
+	}
+
+	notifyCh := make(chan bool, 16)
+	raftConfig := raft.DefaultConfig()
+	raftConfig.LocalID = b.localID
+	raftConfig.NotifyCh = notifyCh
+	raftConfig.Logger = hclog.New(&hclog.LoggerOptions{
+		Name:   "raft",
+		Output: b.logOutput,
+		Level:  hclog.Info,
+	})
+
+	if bootstrap {
+		configuration := raft.Configuration{
+			Servers: []raft.Server{
+				{
+					Suffrage: raft.Voter,
+					ID:       b.localID,
+					Address:  transport.LocalAddr(),
+				},
+			},
+		}
+		if err := raft.BootstrapCluster(raftConfig, logCache, b.logStore, b.snapStore, transport, configuration); err != nil {
+			transport.Close()
+			return fmt.Errorf("failed to bootstrap raft cluster: %v", err)
+		}
+	}
+
+	r, err := raft.NewRaft(raftConfig, b.fsm, logCache, b.logStore, b.snapStore, transport)
+	if err != nil {
+		transport.Close()
+		return fmt.Errorf("failed to start raft: %v", err)
+	}
+
+	b.raft = r
+	b.raftStopCh = make(chan struct{})
+	go b.observeLeadership(notifyCh, b.raftStopCh)
+	return nil
+}
+
+// stopRaft shuts raft down, along with its transport. The raft lock must be
+// held.
+func (b *RaftBackend) stopRaft() error {
+	if b.raft == nil {
+		return nil
+	}
+
+	close(b.raftStopCh)
+	err := b.raft.Shutdown().Error()
+	b.raft = nil
+
+	b.l.Lock()
+	if b.leader {
+		b.leader = false
+		close(b.leaderLostCh)
+		b.leaderLostCh = nil
+	}
+	b.notifyStateChange()
+	b.l.Unlock()
+	return err
+}
+
+// raftNode returns the running raft node, or nil if raft is not running
+func (b *RaftBackend) raftNode() *raft.Raft {
+	b.raftLock.RLock()
+	defer b.raftLock.RUnlock()
+	return b.raft
+}
+
+// SetupCluster starts raft, exchanging its traffic with the other nodes
+// over TLS with the given configuration. Vault calls this once unsealed,
+// with a configuration presenting and requiring the cluster certificate.
+func (b *RaftBackend) SetupCluster(tlsConfig *tls.Config) error {
+	b.raftLock.Lock()
+	defer b.raftLock.Unlock()
+
+	b.stream.setup(tlsConfig)
+	if b.raft != nil {
+		return nil
+	}
+	return b.startRaft(false)
+}
+
+// TeardownCluster stops raft. Vault calls this when sealed, so that a
+// sealed node cannot be elected leader.
+func (b *RaftBackend) TeardownCluster() error {
+	b.raftLock.Lock()
+	defer b.raftLock.Unlock()
+
+	err := b.stopRaft()
+	b.stream.teardown()
+	return err
+}
+
+// provision restores the state sent by ProvisionPeer. It is only accepted
+// while raft is not running, by a node which has neither raft state nor
+// data.
+func (b *RaftBackend) provision(r io.Reader) error {
+	b.raftLock.Lock()
+	defer b.raftLock.Unlock()
+
+	if b.raft != nil {
+		return fmt.Errorf("raft is running")
+	}
+	hasState, err := raft.HasExistingState(b.logStore, b.logStore, b.snapStore)
+	if err != nil {
+		return err
+	}
+	if hasState {
+		return fmt.Errorf("node already has raft state")
+	}
+	keys, err := b.fsm.list("")
+	if err != nil {
+		return err
+	}
+	if len(keys) != 0 {
+		return fmt.Errorf("node already has data")
+	}
+
+	return b.fsm.Restore(ioutil.NopCloser(r))
+}
+
+// observeLeadership keeps track of the Raft leadership of this node until
+// raft stops
+func (b *RaftBackend) observeLeadership(notifyCh <-chan bool, stopCh <-chan struct{}) {
+	for {
+		select {
+		case isLeader := <-notifyCh:
+			b.l.Lock()
+			switch {
+			case isLeader && !b.leader:
+				b.leader = true
+				b.leaderLostCh = make(chan struct{})
+			case !isLeader && b.leader:
+				b.leader = false
+				close(b.leaderLostCh)
+				b.leaderLostCh = nil
+			}
+			b.notifyStateChange()
+			b.l.Unlock()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// notifyStateChange wakes up the lock waiters. The lock must be held.
+func (b *RaftBackend) notifyStateChange() {
+	close(b.stateCh)
+	b.stateCh = make(chan struct{})
+}
+
+// NodeID returns the ID of this node within the Raft cluster
+func (b *RaftBackend) NodeID() string {
+	return string(b.localID)
+}
+
+// Address returns the address of the Raft transport of this node
+func (b *RaftBackend) Address() string {
+	return b.stream.Addr().String()
+}
+
+// Close shuts down Raft and closes the local state
+func (b *RaftBackend) Close() error {
+	b.raftLock.Lock()
+	err := b.stopRaft()
+	b.raftLock.Unlock()
+
+	b.stream.Close()
+	b.logStore.Close()
+	b.fsm.Close()
+	return err
+}
+
+// apply replicates the command through the raft log. Only the leader can
+// apply commands.
+func (b *RaftBackend) apply(cmd *raftCommand) error {
+	r := b.raftNode()
+	if r == nil {
+		return errRaftClusterNotSetUp
+	}
+
+	buf, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	f := r.Apply(buf, raftApplyTimeout)
+	if err := f.Error(); err != nil {
+		if err == raft.ErrNotLeader {
+			return fmt.Errorf("node is not the raft leader")
+		}
+		return err
+	}
+	if err, ok := f.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Put is used to insert or update an entry
+func (b *RaftBackend) Put(entry *Entry) error {
+	defer metrics.MeasureSince([]string{"raft", "put"}, time.Now())
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	return b.apply(&raftCommand{
+		Op:    raftOpPut,
+		Key:   entry.Key,
+		Value: entry.Value,
+	})
+}
+
+// Get is used to fetch an entry. Entries are read from the local state,
+// which may lag behind the leader on other nodes.
+func (b *RaftBackend) Get(key string) (*Entry, error) {
+	defer metrics.MeasureSince([]string{"raft", "get"}, time.Now())
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	value, err := b.fsm.get(raftDataBucket, key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return &Entry{
+		Key:   key,
+		Value: value,
+	}, nil
+}
+
+// Delete is used to permanently delete an entry
+func (b *RaftBackend) Delete(key string) error {
+	defer metrics.MeasureSince([]string{"raft", "delete"}, time.Now())
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	return b.apply(&raftCommand{
+		Op:  raftOpDelete,
+		Key: key,
+	})
+}
+
+// List is used to list all the keys under a given
+// prefix, up to the next prefix.
+func (b *RaftBackend) List(prefix string) ([]string, error) {
+	defer metrics.MeasureSince([]string{"raft", "list"}, time.Now())
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	return b.fsm.list(prefix)
+}
+
+// AddPeer adds a node as a voter to the Raft cluster. This must be called
+// on the leader. The node must be unsealed, so that it accepts the cluster
+// certificate; ErrRaftPeerUnavailable is returned otherwise. A node which
+// has no state yet can be unsealed once provisioned with ProvisionPeer.
+func (b *RaftBackend) AddPeer(nodeID, address string) error {
+	r := b.raftNode()
+	if r == nil {
+		return errRaftClusterNotSetUp
+	}
+
+	if err := b.stream.probe(raft.ServerAddress(address)); err != nil {
+		if b.logger != nil {
+			b.logger.Printf("[DEBUG]: raft: node %s at %s not reachable: %v", nodeID, address, err)
+		}
+		return ErrRaftPeerUnavailable
+	}
+	return r.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(address), 0, raftApplyTimeout).Error()
+}
+
+// ProvisionPeer sends a copy of the local state to the node at the given
+// address, which must have no state, so that it can be unsealed before it
+// is added to the cluster. Apart from the seal configuration, the state is
+// encrypted by the barrier, so the node does not need to be authenticated.
+func (b *RaftBackend) ProvisionPeer(address string) error {
+	return b.stream.provisionPeer(address, b.fsm.writeTo)
+}
+
+// RemovePeer removes a node from the Raft cluster. This must be called on
+// the leader.
+func (b *RaftBackend) RemovePeer(nodeID string) error {
+	r := b.raftNode()
+	if r == nil {
+		return errRaftClusterNotSetUp
+	}
+	return r.RemoveServer(raft.ServerID(nodeID), 0, raftApplyTimeout).Error()
+}
+
+// Peers returns the members of the Raft cluster
+func (b *RaftBackend) Peers() ([]*RaftPeer, error) {
+	r := b.raftNode()
+	if r == nil {
+		return nil, errRaftClusterNotSetUp
+	}
+
+	future := r.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+
+	leaderAddr := r.Leader()
+	var peers []*RaftPeer
+	for _, server := range future.Configuration().Servers {
+		peers = append(peers, &RaftPeer{
+			NodeID:  string(server.ID),
+			Address: string(server.Address),
+			Leader:  server.Address == leaderAddr,
+			Voter:   server.Suffrage == raft.Voter,
+		})
+	}
+	return peers, nil
+}
+
+// Snapshot writes a snapshot of the replicated state to the writer, as a
+// gzipped tar archive of the snapshot metadata and state
+func (b *RaftBackend) Snapshot(w io.Writer) error {
+	r := b.raftNode()
+	if r == nil {
+		return errRaftClusterNotSetUp
+	}
+
+	future := r.Snapshot()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	meta, rc, err := future.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	metaBuf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{
+		Name: raftSnapshotMetaName,
+		Mode: 0600,
+		Size: int64(len(metaBuf)),
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(metaBuf); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name: raftSnapshotStateName,
+		Mode: 0600,
+		Size: meta.Size,
+	}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, rc); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Restore replaces the replicated state of the whole cluster with the
+// snapshot read from the reader. This must be called on the leader.
+func (b *RaftBackend) Restore(r io.Reader) error {
+	node := b.raftNode()
+	if node == nil {
+		return errRaftClusterNotSetUp
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var meta *raft.SnapshotMeta
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("snapshot does not contain the state")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot: %v", err)
+		}
+
+		switch hdr.Name {
+		case raftSnapshotMetaName:
+			meta = &raft.SnapshotMeta{}
+			if err := json.NewDecoder(tr).Decode(meta); err != nil {
+				return fmt.Errorf("failed to decode snapshot metadata: %v", err)
+			}
+		case raftSnapshotStateName:
+			if meta == nil {
+				return fmt.Errorf("snapshot metadata is missing")
+			}
+			if hdr.Size != meta.Size {
+				return fmt.Errorf("snapshot state size does not match its metadata")
+			}
+			return node.Restore(meta, tr, 0)
+		}
+	}
+}
+
+// LockWith is used for mutual exclusion based on the given key.
+func (b *RaftBackend) LockWith(key, value string) (Lock, error) {
+	return &RaftLock{
+		b:     b,
+		key:   key,
+		value: value,
+	}, nil
+}
+
+// RaftLock is a Lock held by the node which is the leader of the Raft
+// cluster. Only one lock per key can be held on that node.
+type RaftLock struct {
+	b     *RaftBackend
+	key   string
+	value string
+
+	l        sync.Mutex
+	held     bool
+	unlockCh chan struct{}
+}
+
+// Lock waits for this node to be the Raft leader and for the key not to be
+// held locally, then publishes the value of the lock. The returned channel
+// is closed when Raft leadership is lost.
+func (l *RaftLock) Lock(stopCh <-chan struct{}) (<-chan struct{}, error) {
+	l.l.Lock()
+	defer l.l.Unlock()
+	if l.held {
+		return nil, fmt.Errorf("lock already held")
+	}
+
+	b := l.b
+	b.l.Lock()
+	b.lockWaiters[l.key]++
+	b.l.Unlock()
+	defer func() {
+		b.l.Lock()
+		b.lockWaiters[l.key]--
+		b.l.Unlock()
+	}()
+
+	for {
+		b.l.Lock()
+		if !b.leader || b.heldLocks[l.key] {
+			stateCh := b.stateCh
+			b.l.Unlock()
+
+			select {
+			case <-stateCh:
+				continue
+			case <-stopCh:
+				return nil, nil
+			}
+		}
+		b.heldLocks[l.key] = true
+		leaderLostCh := b.leaderLostCh
+		b.l.Unlock()
+
+		if err := l.publishValue(); err != nil {
+			if b.logger != nil {
+				b.logger.Printf("[WARN]: raft: failed to publish lock value: %v", err)
+			}
+			b.releaseLocal(l.key)
+
+			// Back off before trying again
+			select {
+			case <-time.After(time.Second):
+				continue
+			case <-stopCh:
+				return nil, nil
+			}
+		}
+
+		leaderCh := make(chan struct{})
+		unlockCh := make(chan struct{})
+		go func() {
+			select {
+			case <-leaderLostCh:
+			case <-unlockCh:
+			}
+			close(leaderCh)
+		}()
+
+		l.held = true
+		l.unlockCh = unlockCh
+		return leaderCh, nil
+	}
+}
+
+// publishValue writes the value of the lock once the state of this node is
+// up to date with all the entries committed by previous leaders
+func (l *RaftLock) publishValue() error {
+	r := l.b.raftNode()
+	if r == nil {
+		return errRaftClusterNotSetUp
+	}
+	if err := r.Barrier(raftApplyTimeout).Error(); err != nil {
+		return err
+	}
+	return l.b.apply(&raftCommand{
+		Op:    raftOpLockPut,
+		Key:   l.key,
+		Value: []byte(l.value),
+	})
+}
+
+// releaseLocal marks the key as no longer held on this node
+func (b *RaftBackend) releaseLocal(key string) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	delete(b.heldLocks, key)
+	b.notifyStateChange()
+}
+
+// Unlock releases the lock. Unless another lock for the key is waiting on
+// this node, Raft leadership is transferred to another unsealed node, so
+// that it can take over.
+func (l *RaftLock) Unlock() error {
+	l.l.Lock()
+	defer l.l.Unlock()
+	if !l.held {
+		return nil
+	}
+
+	close(l.unlockCh)
+	l.unlockCh = nil
+	l.held = false
+
+	b := l.b
+	r := b.raftNode()
+	if r != nil && r.State() == raft.Leader {
+		err := b.apply(&raftCommand{
+			Op:    raftOpLockDelete,
+			Key:   l.key,
+			Value: []byte(l.value),
+		})
+		if err != nil && b.logger != nil {
+			b.logger.Printf("[WARN]: raft: failed to clear lock value: %v", err)
+		}
+	}
+
+	b.l.Lock()
+	delete(b.heldLocks, l.key)
+	waiting := b.lockWaiters[l.key] > 0
+	leader := b.leader
+	b.notifyStateChange()
+	b.l.Unlock()
+
+	if leader && !waiting && r != nil {
+		b.transferLeadership(r)
+	}
+	return nil
+}
+
+// transferLeadership hands Raft leadership over to a voter which can be
+// reached with the cluster certificate, that is one which is unsealed. If
+// there is none, this node remains the leader.
+func (b *RaftBackend) transferLeadership(r *raft.Raft) {
+	future := r.GetConfiguration()
+	if err := future.Error(); err != nil {
+		if b.logger != nil {
+			b.logger.Printf("[WARN]: raft: leadership not transferred: %v", err)
+		}
+		return
+	}
+
+	for _, server := range future.Configuration().Servers {
+		if server.ID == b.localID || server.Suffrage != raft.Voter {
+			continue
+		}
+
+		// Sealed nodes cannot be reached with the cluster certificate
+		if err := b.stream.probe(server.Address); err != nil {
+			continue
+		}
+		err := r.LeadershipTransferToServer(server.ID, server.Address).Error()
+		if err == nil {
+			return
+		}
+		if b.logger != nil {
+			b.logger.Printf("[DEBUG]: raft: leadership not transferred to %s: %v", server.ID, err)
+		}
+	}
+	if b.logger != nil {
+		b.logger.Printf("[DEBUG]: raft: no unsealed node to transfer leadership to")
+	}
+}
+
+// Value returns the value of the lock and whether it is held. A lock is
+// only considered held while the cluster has a leader.
+func (l *RaftLock) Value() (bool, string, error) {
+	r := l.b.raftNode()
+	if r == nil || r.Leader() == "" {
+		return false, "", nil
+	}
+
+	value, err := l.b.fsm.get(raftLocksBucket, l.key)
+	if err != nil {
+		return false, "", err
+	}
+	if value == nil {
+		return false, "", nil
+	}
+	return true, string(value), nil
+}
@@ -0,0 +1,256 @@
+package physical
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/raft"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// raftFSMFileName is the name of the BoltDB file holding the replicated
+	// state of the raft backend
+	raftFSMFileName = "vault.db"
+
+	raftOpPut        = "put"
+	raftOpDelete     = "delete"
+	raftOpLockPut    = "lock-put"
+	raftOpLockDelete = "lock-delete"
+)
+
+var (
+	// raftDataBucket holds the entries stored through the backend
+	raftDataBucket = []byte("data")
+
+	// raftLocksBucket holds the values of the HA locks
+	raftLocksBucket = []byte("locks")
+)
+
+// raftCommand is an operation replicated through the raft log
+type raftCommand struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+// raftFSM is the finite state machine the raft log is applied to. It keeps
+// its state in a BoltDB file, so that it does not have to be held in memory.
+type raftFSM struct {
+	path string
+
+	// l protects db, which is swapped out when a snapshot is restored
+	l  sync.RWMutex
+	db *bolt.DB
+}
+
+// newRaftFSM opens, or creates, the BoltDB file in the given directory
+func newRaftFSM(path string) (*raftFSM, error) {
+	f := &raftFSM{
+		path: path,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *raftFSM) open() error {
+	db, err := bolt.Open(filepath.Join(f.path, raftFSMFileName), 0600, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open raft state: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{raftDataBucket, raftLocksBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to create raft state buckets: %v", err)
+	}
+
+	f.db = db
+	return nil
+}
+
+// Close closes the BoltDB file
+func (f *raftFSM) Close() error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	return f.db.Close()
+}
+
+// Apply applies a committed raft log entry. Errors are returned as the
+// response of the entry, and passed on to the caller of the write.
+func (f *raftFSM) Apply(l *raft.Log) interface{} {
+	var cmd raftCommand
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return fmt.Errorf("failed to decode raft command: %v", err)
+	}
+
+	f.l.RLock()
+	defer f.l.RUnlock()
+
+	return f.db.Update(func(tx *bolt.Tx) error {
+		switch cmd.Op {
+		case raftOpPut:
+			return tx.Bucket(raftDataBucket).Put([]byte(cmd.Key), cmd.Value)
+		case raftOpDelete:
+			return tx.Bucket(raftDataBucket).Delete([]byte(cmd.Key))
+		case raftOpLockPut:
+			return tx.Bucket(raftLocksBucket).Put([]byte(cmd.Key), cmd.Value)
+		case raftOpLockDelete:
+			// Only the holder of the lock clears its value; a new holder may
+			// have overwritten it already
+			locks := tx.Bucket(raftLocksBucket)
+			if bytes.Equal(locks.Get([]byte(cmd.Key)), cmd.Value) {
+				return locks.Delete([]byte(cmd.Key))
+			}
+			return nil
+		default:
+			return fmt.Errorf("unknown raft command: %q", cmd.Op)
+		}
+	})
+}
+
+// get returns a copy of the value stored under the key of the bucket
+func (f *raftFSM) get(bucket []byte, key string) ([]byte, error) {
+	f.l.RLock()
+	defer f.l.RUnlock()
+
+	var value []byte
+	err := f.db.View(func(tx *bolt.Tx) error {
+		// Values are only valid for the life of the transaction
+		if v := tx.Bucket(bucket).Get([]byte(key)); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// list returns the keys under the given prefix, up to the next prefix
+func (f *raftFSM) list(prefix string) ([]string, error) {
+	f.l.RLock()
+	defer f.l.RUnlock()
+
+	var out []string
+	err := f.db.View(func(tx *bolt.Tx) error {
+		seen := make(map[string]struct{})
+		c := tx.Bucket(raftDataBucket).Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+			trimmed := strings.TrimPrefix(string(k), prefix)
+			if sep := strings.Index(trimmed, "/"); sep != -1 {
+				trimmed = trimmed[:sep+1]
+				if _, ok := seen[trimmed]; ok {
+					continue
+				}
+				seen[trimmed] = struct{}{}
+			}
+			out = append(out, trimmed)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// writeTo writes a consistent copy of the BoltDB file to the writer
+func (f *raftFSM) writeTo(w io.Writer) error {
+	f.l.RLock()
+	defer f.l.RUnlock()
+
+	return f.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// Snapshot returns a snapshot of the current state. The read transaction
+// started here provides a consistent view until the snapshot is released.
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.l.RLock()
+	defer f.l.RUnlock()
+
+	tx, err := f.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &raftFSMSnapshot{tx: tx}, nil
+}
+
+// Restore replaces the current state with the given snapshot
+func (f *raftFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	// Write the snapshot next to the current state first, so that a failure
+	// does not leave a partial state behind
+	tmpPath := filepath.Join(f.path, raftFSMFileName+".restore")
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, rc); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write raft snapshot: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Make sure the snapshot is a valid state before replacing the current
+	// one with it
+	db, err := bolt.Open(tmpPath, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("invalid raft snapshot: %v", err)
+	}
+	db.Close()
+
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	if err := f.db.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(f.path, raftFSMFileName)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// raftFSMSnapshot is a point-in-time snapshot of the raft state
+type raftFSMSnapshot struct {
+	tx *bolt.Tx
+}
+
+// Persist writes the BoltDB file as seen by the snapshot transaction
+func (s *raftFSMSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := s.tx.WriteTo(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release ends the snapshot transaction
+func (s *raftFSMSnapshot) Release() {
+	s.tx.Rollback()
+}
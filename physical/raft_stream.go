@@ -0,0 +1,382 @@
+package physical
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// raftConnTLS and raftConnProvision are sent as the first byte of a
+	// connection to the raft address, to tell what it is used for
+	raftConnTLS       byte = 0x01
+	raftConnProvision byte = 0x02
+
+	// raftHandshakeTimeout bounds the time a new connection takes to tell
+	// what it is used for and to complete the TLS handshake
+	raftHandshakeTimeout = 10 * time.Second
+
+	// raftProvisionTimeout bounds the time taken to send the state to a
+	// node being provisioned
+	raftProvisionTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrRaftPeerUnavailable is returned when a node cannot be reached with
+	// the cluster certificate, which is the case while it is sealed
+	ErrRaftPeerUnavailable = errors.New("node cannot be reached with the cluster certificate; it may be sealed")
+
+	errRaftClusterNotSetUp = errors.New("raft cluster is not set up; the node is sealed")
+	errRaftStreamClosed    = errors.New("raft stream layer is closed")
+)
+
+// raftStreamLayer listens on the raft address for the whole life of the
+// backend. Raft traffic is only exchanged while the cluster is set up, over
+// TLS connections on which both sides present the cluster certificate; the
+// connections of peers which do not are rejected. Otherwise, the only
+// connections accepted are those provisioning a node which has no state.
+type raftStreamLayer struct {
+	listener  net.Listener
+	provision func(io.Reader) error
+	logger    *log.Logger
+
+	// l protects the TLS configuration, which is only set while the cluster
+	// is set up, and the stream of the running transport
+	l         sync.Mutex
+	tlsConfig *tls.Config
+	stream    *raftStream
+
+	closeCh chan struct{}
+}
+
+// newRaftStreamLayer listens on the given address. The provision function
+// is called with the state sent by ProvisionPeer.
+func newRaftStreamLayer(address string, provision func(io.Reader) error, logger *log.Logger) (*raftStreamLayer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	// The address is advertised to the other nodes as it is
+	if addr, ok := listener.Addr().(*net.TCPAddr); !ok || addr.IP.IsUnspecified() {
+		listener.Close()
+		return nil, fmt.Errorf("raft address %q is not advertisable", address)
+	}
+
+	s := &raftStreamLayer{
+		listener:  listener,
+		provision: provision,
+		logger:    logger,
+		closeCh:   make(chan struct{}),
+	}
+	go s.acceptLoop()
+	return s, nil
+}
+
+// Addr returns the address the stream layer listens on
+func (s *raftStreamLayer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops listening
+func (s *raftStreamLayer) Close() error {
+	close(s.closeCh)
+	return s.listener.Close()
+}
+
+// setup enables raft traffic over TLS with the given configuration
+func (s *raftStreamLayer) setup(tlsConfig *tls.Config) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.tlsConfig = tlsConfig
+}
+
+// teardown disables raft traffic
+func (s *raftStreamLayer) teardown() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.tlsConfig = nil
+}
+
+// newStream returns the stream layer of a new raft transport, to which the
+// accepted raft connections are handed over
+func (s *raftStreamLayer) newStream() *raftStream {
+	stream := &raftStream{
+		layer:   s,
+		connCh:  make(chan net.Conn),
+		closeCh: make(chan struct{}),
+		conns:   make(map[net.Conn]struct{}),
+	}
+
+	s.l.Lock()
+	s.stream = stream
+	s.l.Unlock()
+	return stream
+}
+
+func (s *raftStreamLayer) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.closeCh:
+				return
+			default:
+			}
+			if s.logger != nil {
+				s.logger.Printf("[WARN]: raft: failed to accept connection: %v", err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn hands a raft connection over to the running transport once
+// the peer is authenticated, or restores the state sent to provision the
+// node
+func (s *raftStreamLayer) handleConn(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(raftHandshakeTimeout))
+	var kind [1]byte
+	if _, err := io.ReadFull(conn, kind[:]); err != nil {
+		conn.Close()
+		return
+	}
+
+	switch kind[0] {
+	case raftConnTLS:
+		s.l.Lock()
+		tlsConfig := s.tlsConfig
+		stream := s.stream
+		s.l.Unlock()
+		if tlsConfig == nil || stream == nil {
+			conn.Close()
+			return
+		}
+
+		tlsConn := tls.Server(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			if s.logger != nil {
+				s.logger.Printf("[WARN]: raft: rejected connection from %s: %v", conn.RemoteAddr(), err)
+			}
+			conn.Close()
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+		stream.deliver(tlsConn)
+
+	case raftConnProvision:
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(raftProvisionTimeout))
+
+		var status string
+		if err := s.provision(conn); err != nil {
+			if s.logger != nil {
+				s.logger.Printf("[WARN]: raft: rejected provisioning from %s: %v", conn.RemoteAddr(), err)
+			}
+			status = err.Error()
+		}
+		fmt.Fprintln(conn, status)
+
+	default:
+		conn.Close()
+	}
+}
+
+// dial connects to a peer over TLS
+func (s *raftStreamLayer) dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	s.l.Lock()
+	tlsConfig := s.tlsConfig
+	s.l.Unlock()
+	if tlsConfig == nil {
+		return nil, errRaftClusterNotSetUp
+	}
+
+	return dialRaftTLS(address, tlsConfig, timeout)
+}
+
+// probe checks that the peer can be reached with the cluster certificate
+func (s *raftStreamLayer) probe(address raft.ServerAddress) error {
+	conn, err := s.dial(address, raftHandshakeTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// dialRaftTLS connects to a peer, and completes the TLS handshake during
+// which both sides authenticate each other
+func dialRaftTLS(address raft.ServerAddress, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", string(address), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte{raftConnTLS}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// provisionPeer sends the state written by writeState to the node at the
+// given address, and waits for the node to have restored it
+func (s *raftStreamLayer) provisionPeer(address string, writeState func(io.Writer) error) error {
+	conn, err := net.DialTimeout("tcp", address, raftHandshakeTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(raftProvisionTimeout))
+
+	w := bufio.NewWriter(conn)
+	if err := w.WriteByte(raftConnProvision); err != nil {
+		return err
+	}
+	if err := writeState(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// The end of the state is marked by closing the connection for writing
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.CloseWrite(); err != nil {
+			return err
+		}
+	}
+
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read provisioning status: %v", err)
+	}
+	if status = strings.TrimSpace(status); status != "" {
+		return fmt.Errorf("node rejected provisioning: %s", status)
+	}
+	return nil
+}
+
+// raftStream is the stream layer of a single raft transport. Closing it
+// closes the connections of the transport, but not the listener of the
+// raft stream layer, so that a new transport can be started later on.
+type raftStream struct {
+	layer   *raftStreamLayer
+	connCh  chan net.Conn
+	closeCh chan struct{}
+
+	l      sync.Mutex
+	closed bool
+	conns  map[net.Conn]struct{}
+}
+
+// deliver hands an accepted connection over to the transport
+func (s *raftStream) deliver(conn net.Conn) {
+	tracked, ok := s.track(conn)
+	if !ok {
+		return
+	}
+	select {
+	case s.connCh <- tracked:
+	case <-s.closeCh:
+	}
+}
+
+// track records the connection, so that it is closed along with the
+// stream
+func (s *raftStream) track(conn net.Conn) (net.Conn, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.closed {
+		conn.Close()
+		return nil, false
+	}
+	tracked := &raftStreamConn{
+		Conn:   conn,
+		stream: s,
+	}
+	s.conns[tracked] = struct{}{}
+	return tracked, true
+}
+
+func (s *raftStream) untrack(conn net.Conn) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	delete(s.conns, conn)
+}
+
+// Accept waits for the next raft connection
+func (s *raftStream) Accept() (net.Conn, error) {
+	select {
+	case conn := <-s.connCh:
+		return conn, nil
+	case <-s.closeCh:
+		return nil, errRaftStreamClosed
+	}
+}
+
+// Close closes the connections of the stream
+func (s *raftStream) Close() error {
+	s.l.Lock()
+	if s.closed {
+		s.l.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.closeCh)
+	conns := s.conns
+	s.conns = nil
+	s.l.Unlock()
+
+	for conn := range conns {
+		conn.(*raftStreamConn).Conn.Close()
+	}
+	return nil
+}
+
+// Addr returns the address of the raft stream layer
+func (s *raftStream) Addr() net.Addr {
+	return s.layer.Addr()
+}
+
+// Dial connects to a peer over TLS
+func (s *raftStream) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := s.layer.dial(address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	tracked, ok := s.track(conn)
+	if !ok {
+		return nil, errRaftStreamClosed
+	}
+	return tracked, nil
+}
+
+// raftStreamConn is a connection of a raft stream, which stops being
+// tracked once closed
+type raftStreamConn struct {
+	net.Conn
+	stream *raftStream
+}
+
+func (c *raftStreamConn) Close() error {
+	c.stream.untrack(c)
+	return c.Conn.Close()
+}
@@ -0,0 +1,421 @@
+package physical
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+// testRaftTLSConfig returns a TLS configuration presenting and requiring a
+// new self-signed certificate, like the cluster certificate of Vault
+func testRaftTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName: "raft",
+		},
+		DNSNames: []string{"raft"},
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-30 * time.Second),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{
+			tls.Certificate{
+				Certificate: [][]byte{certBytes},
+				PrivateKey:  key,
+			},
+		},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		RootCAs:    pool,
+		ServerName: "raft",
+	}
+}
+
+// testRaftBackend creates a raft backend listening on a random local port.
+// Unless the TLS configuration is nil, the cluster is set up with it, as
+// when Vault is unsealed.
+func testRaftBackend(t *testing.T, bootstrap bool, tlsConfig *tls.Config) (*RaftBackend, func()) {
+	dir, err := ioutil.TempDir("", "vault-raft")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	bootstrapStr := "false"
+	if bootstrap {
+		bootstrapStr = "true"
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	b, err := NewRaftBackend(map[string]string{
+		"path":      dir,
+		"address":   "127.0.0.1:0",
+		"bootstrap": bootstrapStr,
+	}, logger)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("err: %s", err)
+	}
+	if tlsConfig != nil {
+		if err := b.SetupCluster(tlsConfig); err != nil {
+			b.Close()
+			os.RemoveAll(dir)
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	return b, func() {
+		b.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// testRaftWaitLeader waits until one of the backends is the raft leader
+func testRaftWaitLeader(t *testing.T, backends ...*RaftBackend) *RaftBackend {
+	for i := 0; i < 100; i++ {
+		for _, b := range backends {
+			b.l.Lock()
+			leader := b.leader
+			b.l.Unlock()
+			if leader {
+				return b
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("no raft leader elected")
+	return nil
+}
+
+// testRaftWaitEntry waits until the entry has the given value on the backend
+func testRaftWaitEntry(t *testing.T, b *RaftBackend, key string, value []byte) {
+	for i := 0; i < 100; i++ {
+		entry, err := b.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if (entry == nil && value == nil) || (entry != nil && bytes.Equal(entry.Value, value)) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("entry %q not replicated to %s", key, b.NodeID())
+}
+
+func TestRaftBackend(t *testing.T) {
+	b, cleanup := testRaftBackend(t, true, testRaftTLSConfig(t))
+	defer cleanup()
+	testRaftWaitLeader(t, b)
+
+	testBackend(t, b)
+	testBackend_ListPrefix(t, b)
+	testHABackend(t, b, b)
+}
+
+func TestRaftBackend_NotBootstrapped(t *testing.T) {
+	b, cleanup := testRaftBackend(t, false, testRaftTLSConfig(t))
+	defer cleanup()
+
+	// Without a cluster to join, there is no leader and no writes
+	lock, err := b.LockWith("foo", "bar")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	held, _, err := lock.Value()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if held {
+		t.Fatalf("should not be held")
+	}
+
+	if err := b.Put(&Entry{Key: "foo", Value: []byte("bar")}); err == nil {
+		t.Fatalf("expected error writing to a node which is not the leader")
+	}
+}
+
+func TestRaftBackend_Cluster(t *testing.T) {
+	tlsConfig := testRaftTLSConfig(t)
+	b1, cleanup1 := testRaftBackend(t, true, tlsConfig)
+	defer cleanup1()
+	b2, cleanup2 := testRaftBackend(t, false, tlsConfig)
+	defer cleanup2()
+	b3, cleanup3 := testRaftBackend(t, false, tlsConfig)
+	defer cleanup3()
+	testRaftWaitLeader(t, b1)
+
+	for _, b := range []*RaftBackend{b2, b3} {
+		if err := b1.AddPeer(b.NodeID(), b.Address()); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	peers, err := b1.Peers()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(peers) != 3 {
+		t.Fatalf("bad: %#v", peers)
+	}
+	for _, peer := range peers {
+		if peer.Leader != (peer.NodeID == b1.NodeID()) || !peer.Voter {
+			t.Fatalf("bad: %#v", peer)
+		}
+	}
+
+	// Writes are replicated to the followers
+	if err := b1.Put(&Entry{Key: "foo", Value: []byte("bar")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testRaftWaitEntry(t, b2, "foo", []byte("bar"))
+	testRaftWaitEntry(t, b3, "foo", []byte("bar"))
+
+	// Followers cannot write
+	if err := b2.Put(&Entry{Key: "foo", Value: []byte("baz")}); err == nil {
+		t.Fatalf("expected error writing to a follower")
+	}
+
+	// The lock is held by the leader, and visible on the followers
+	lock, err := b1.LockWith("core/lock", "leader1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	leaderCh, err := lock.Lock(nil)
+	if err != nil || leaderCh == nil {
+		t.Fatalf("bad: %v %v", leaderCh, err)
+	}
+	testRaftWaitEntry(t, b2, "foo", []byte("bar"))
+	for i := 0; ; i++ {
+		lock2, _ := b2.LockWith("core/lock", "")
+		held, value, err := lock2.Value()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if held && value == "leader1" {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("bad: %v %v", held, value)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Releasing the lock hands leadership over to a follower, which can
+	// then acquire the lock
+	lock2, _ := b2.LockWith("core/lock", "leader2")
+	lock3, _ := b3.LockWith("core/lock", "leader3")
+	acquired := make(chan string, 2)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go func() {
+		if ch, _ := lock2.Lock(stopCh); ch != nil {
+			acquired <- "leader2"
+		}
+	}()
+	go func() {
+		if ch, _ := lock3.Lock(stopCh); ch != nil {
+			acquired <- "leader3"
+		}
+	}()
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case <-leaderCh:
+	default:
+		t.Fatalf("leader channel should be closed")
+	}
+
+	var newLeader *RaftBackend
+	select {
+	case value := <-acquired:
+		newLeader = b2
+		if value == "leader3" {
+			newLeader = b3
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("lock not acquired by a follower")
+	}
+	if err := newLeader.Put(&Entry{Key: "foo", Value: []byte("baz")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testRaftWaitEntry(t, b1, "foo", []byte("baz"))
+
+	// Remove the former leader from the cluster
+	if err := newLeader.RemovePeer(b1.NodeID()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	peers, err = newLeader.Peers()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("bad: %#v", peers)
+	}
+	for _, peer := range peers {
+		if peer.NodeID == b1.NodeID() {
+			t.Fatalf("peer not removed: %#v", peers)
+		}
+	}
+}
+
+func TestRaftBackend_SnapshotRestore(t *testing.T) {
+	tlsConfig := testRaftTLSConfig(t)
+	b1, cleanup1 := testRaftBackend(t, true, tlsConfig)
+	defer cleanup1()
+	b2, cleanup2 := testRaftBackend(t, false, tlsConfig)
+	defer cleanup2()
+	testRaftWaitLeader(t, b1)
+	if err := b1.AddPeer(b2.NodeID(), b2.Address()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := b1.Put(&Entry{Key: "foo", Value: []byte("bar")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	snap := new(bytes.Buffer)
+	if err := b1.Snapshot(snap); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Change the state after the snapshot
+	if err := b1.Put(&Entry{Key: "zip", Value: []byte("zap")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b1.Delete("foo"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testRaftWaitEntry(t, b2, "zip", []byte("zap"))
+
+	// Restoring brings the whole cluster back to the snapshot
+	if err := b1.Restore(bytes.NewReader(snap.Bytes())); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, b := range []*RaftBackend{b1, b2} {
+		testRaftWaitEntry(t, b, "foo", []byte("bar"))
+		testRaftWaitEntry(t, b, "zip", nil)
+	}
+
+	// The restored state can be written to
+	if err := b1.Put(&Entry{Key: "zip", Value: []byte("zop")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testRaftWaitEntry(t, b2, "zip", []byte("zop"))
+
+	// Garbage is rejected
+	if err := b1.Restore(bytes.NewReader([]byte("garbage"))); err == nil {
+		t.Fatalf("expected error restoring an invalid snapshot")
+	}
+}
+
+func TestRaftBackend_ClusterCertificate(t *testing.T) {
+	tlsConfig := testRaftTLSConfig(t)
+	b1, cleanup1 := testRaftBackend(t, true, tlsConfig)
+	defer cleanup1()
+	testRaftWaitLeader(t, b1)
+	if err := b1.Put(&Entry{Key: "foo", Value: []byte("bar")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A node presenting another certificate is rejected
+	other, cleanupOther := testRaftBackend(t, false, testRaftTLSConfig(t))
+	defer cleanupOther()
+	if err := b1.AddPeer(other.NodeID(), other.Address()); err != ErrRaftPeerUnavailable {
+		t.Fatalf("err: %v", err)
+	}
+
+	// So is a node which is sealed, but it can be provisioned
+	b2, cleanup2 := testRaftBackend(t, false, nil)
+	defer cleanup2()
+	if err := b1.AddPeer(b2.NodeID(), b2.Address()); err != ErrRaftPeerUnavailable {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b1.ProvisionPeer(b2.Address()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testRaftWaitEntry(t, b2, "foo", []byte("bar"))
+	if err := b1.ProvisionPeer(b2.Address()); err == nil {
+		t.Fatalf("expected error provisioning a node which has data")
+	}
+
+	// Once unsealed, the node can join
+	if err := b2.SetupCluster(tlsConfig); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b1.AddPeer(b2.NodeID(), b2.Address()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b1.Put(&Entry{Key: "foo", Value: []byte("baz")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testRaftWaitEntry(t, b2, "foo", []byte("baz"))
+}
+
+func TestRaftBackend_SealedNode(t *testing.T) {
+	tlsConfig := testRaftTLSConfig(t)
+	b1, cleanup1 := testRaftBackend(t, true, tlsConfig)
+	defer cleanup1()
+	b2, cleanup2 := testRaftBackend(t, false, tlsConfig)
+	defer cleanup2()
+	b3, cleanup3 := testRaftBackend(t, false, tlsConfig)
+	defer cleanup3()
+	testRaftWaitLeader(t, b1)
+	for _, b := range []*RaftBackend{b2, b3} {
+		if err := b1.AddPeer(b.NodeID(), b.Address()); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	lock, _ := b1.LockWith("core/lock", "leader1")
+	if _, err := lock.Lock(nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Seal the second node, then release the lock: leadership is only
+	// handed over to the unsealed node
+	if err := b2.TeardownCluster(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b1.Put(&Entry{Key: "foo", Value: []byte("bar")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if leader := testRaftWaitLeader(t, b2, b3); leader != b3 {
+		t.Fatalf("bad leader: %s", leader.NodeID())
+	}
+}
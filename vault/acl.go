@@ -1,10 +1,36 @@
 package vault
 
 import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
 	"github.com/armon/go-radix"
+	"github.com/hashicorp/golang-lru"
+	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 )
 
+const (
+	// aclExpansionCacheSize is the number of ACLs expanded from the
+	// templated paths of an ACL which are cached
+	aclExpansionCacheSize = 256
+)
+
+// aclTemplateRegexp matches the placeholders of templated policy paths
+var aclTemplateRegexp = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+
+// ACLTemplateData holds the values of the placeholders of templated policy
+// paths, which are those of the token of a request and of its entity. The
+// metadata is the one set by the auth backend at login, as the metadata of
+// tokens created through the token store is chosen by their creator.
+type ACLTemplateData struct {
+	DisplayName string
+	Metadata    map[string]string
+	Entity      *Entity
+}
+
 // ACL is used to wrap a set of policies to provide
 // an efficient interface for access control.
 type ACL struct {
@@ -14,10 +40,156 @@ type ACL struct {
 	// globRules contains the path policies that glob
 	globRules *radix.Tree
 
+	// segmentRules contains the path policies with '+' segment wildcards,
+	// sorted by precedence
+	segmentRules aclWildcardRules
+
 	// root is enabled if the "root" named policy is present.
 	root bool
+
+	// policies are the policies of the ACL, kept to expand their templated
+	// paths
+	policies []*Policy
+
+	// templated is set if the policies have templated paths, which only
+	// the ACLs returned by Expand match
+	templated bool
+
+	// expansions caches the ACLs returned by Expand, keyed by the expanded
+	// templated paths
+	expansions *lru.TwoQueueCache
+}
+
+// aclRule is the rule of an ACL on a path, merged from those of its
+// policies
+type aclRule struct {
+	capabilities uint32
+	permissions  *PathPermissions
+}
+
+// mergeACLRule returns the rule merging the capabilities and permissions
+// of the path into the existing rule, which may be nil
+func mergeACLRule(existing *aclRule, pc *PathCapabilities) *aclRule {
+	switch {
+	case existing == nil:
+		return &aclRule{
+			capabilities: pc.CapabilitiesBitmap,
+			permissions:  pc.Permissions,
+		}
+
+	case existing.capabilities&DenyCapabilityInt > 0:
+		// If we are explicitly denied in the existing capability set,
+		// don't save anything else
+		return existing
+
+	case pc.CapabilitiesBitmap&DenyCapabilityInt > 0:
+		// If this new policy explicitly denies, only save the deny value
+		return &aclRule{capabilities: DenyCapabilityInt}
+	}
+
+	// Insert the capabilities and permissions in this new policy into the
+	// existing value
+	return &aclRule{
+		capabilities: existing.capabilities | pc.CapabilitiesBitmap,
+		permissions:  mergePathPermissions(existing.permissions, pc.Permissions),
+	}
+}
+
+// aclWildcardRule is a rule of an ACL on a path with wildcards, which are
+// either '+' segments matching exactly one path segment or a trailing glob
+type aclWildcardRule struct {
+	// prefix is the path of the rule, stripped of its glob character
+	prefix string
+	glob   bool
+
+	// segments are the segments of the prefix
+	segments []string
+
+	// wildcards is the number of wildcards of the path, and prefixLen the
+	// length of its prefix before the first one
+	wildcards int
+	prefixLen int
+
+	rule *aclRule
+}
+
+func newACLWildcardRule(prefix string, glob bool) *aclWildcardRule {
+	wr := &aclWildcardRule{
+		prefix:    prefix,
+		glob:      glob,
+		segments:  strings.Split(prefix, "/"),
+		prefixLen: len(prefix),
+	}
+	if glob {
+		wr.wildcards++
+	}
+
+	offset := 0
+	for _, segment := range wr.segments {
+		if segment == "+" {
+			if offset < wr.prefixLen {
+				wr.prefixLen = offset
+			}
+			wr.wildcards++
+		}
+		offset += len(segment) + 1
+	}
+	return wr
+}
+
+// hasSegmentWildcards checks if one of the segments of the path is a '+'
+// wildcard
+func hasSegmentWildcards(path string) bool {
+	return strutil.StrListContains(strings.Split(path, "/"), "+")
+}
+
+// precedes checks if the rule takes precedence over the other one when both
+// match a path. The rule with fewer wildcards wins, then the one with the
+// longer prefix before its first wildcard. The remaining ties are broken by
+// preferring the rule without a glob, then the longer and the lexically
+// smaller path, so that the precedence does not depend on the policies.
+func (r *aclWildcardRule) precedes(o *aclWildcardRule) bool {
+	switch {
+	case r.wildcards != o.wildcards:
+		return r.wildcards < o.wildcards
+	case r.prefixLen != o.prefixLen:
+		return r.prefixLen > o.prefixLen
+	case r.glob != o.glob:
+		return !r.glob
+	case len(r.prefix) != len(o.prefix):
+		return len(r.prefix) > len(o.prefix)
+	}
+	return r.prefix < o.prefix
+}
+
+// matches checks if the rule matches the segments of a path
+func (r *aclWildcardRule) matches(segments []string) bool {
+	if len(segments) < len(r.segments) || (!r.glob && len(segments) != len(r.segments)) {
+		return false
+	}
+
+	last := len(r.segments) - 1
+	for i, segment := range r.segments {
+		switch {
+		case segment == "+":
+		case r.glob && i == last:
+			if !strings.HasPrefix(segments[i], segment) {
+				return false
+			}
+		case segments[i] != segment:
+			return false
+		}
+	}
+	return true
 }
 
+// aclWildcardRules sorts the rules by precedence
+type aclWildcardRules []*aclWildcardRule
+
+func (s aclWildcardRules) Len() int           { return len(s) }
+func (s aclWildcardRules) Less(i, j int) bool { return s[i].precedes(s[j]) }
+func (s aclWildcardRules) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 // New is used to construct a policy based ACL from a set of policies.
 func NewACL(policies []*Policy) (*ACL, error) {
 	// Initialize
@@ -28,6 +200,7 @@ func NewACL(policies []*Policy) (*ACL, error) {
 	}
 
 	// Inject each policy
+	segmentRules := make(map[string]*aclWildcardRule)
 	for _, policy := range policies {
 		// Ignore a nil policy object
 		if policy == nil {
@@ -37,38 +210,232 @@ func NewACL(policies []*Policy) (*ACL, error) {
 		if policy.Name == "root" {
 			a.root = true
 		}
+		a.policies = append(a.policies, policy)
 		for _, pc := range policy.Paths {
+			// Templated paths are only inserted once expanded
+			if pc.Templated {
+				a.templated = true
+				continue
+			}
+
+			// Paths with segment wildcards are matched in order of
+			// precedence
+			if hasSegmentWildcards(pc.Prefix) {
+				key := pc.Prefix
+				if pc.Glob {
+					key += "*"
+				}
+				wr, ok := segmentRules[key]
+				if !ok {
+					wr = newACLWildcardRule(pc.Prefix, pc.Glob)
+					segmentRules[key] = wr
+				}
+				wr.rule = mergeACLRule(wr.rule, pc)
+				continue
+			}
+
 			// Check which tree to use
 			tree := a.exactRules
 			if pc.Glob {
 				tree = a.globRules
 			}
 
-			// Check for an existing policy
-			raw, ok := tree.Get(pc.Prefix)
-			if !ok {
-				tree.Insert(pc.Prefix, pc.CapabilitiesBitmap)
+			// Merge with an existing policy
+			var existing *aclRule
+			if raw, ok := tree.Get(pc.Prefix); ok {
+				existing = raw.(*aclRule)
+			}
+			tree.Insert(pc.Prefix, mergeACLRule(existing, pc))
+		}
+	}
+
+	for _, wr := range segmentRules {
+		a.segmentRules = append(a.segmentRules, wr)
+	}
+	sort.Sort(a.segmentRules)
+
+	if a.templated {
+		cache, err := lru.New2Q(aclExpansionCacheSize)
+		if err != nil {
+			return nil, err
+		}
+		a.expansions = cache
+	}
+	return a, nil
+}
+
+// Expand returns the ACL with the templated paths of its policies expanded
+// with the given values. A path with a placeholder that cannot be resolved
+// matches nothing. The compiled ACLs are cached by their expanded paths.
+func (a *ACL) Expand(data *ACLTemplateData) (*ACL, error) {
+	if a.root || !a.templated {
+		return a, nil
+	}
+
+	// Expand the templated paths, an unresolved one being left empty
+	var prefixes []string
+	for _, policy := range a.policies {
+		for _, pc := range policy.Paths {
+			if pc.Templated {
+				prefix, _ := data.expandPath(pc.Prefix)
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+
+	key := strings.Join(prefixes, "\x00")
+	if raw, ok := a.expansions.Get(key); ok {
+		return raw.(*ACL), nil
+	}
+
+	expanded := make([]*Policy, 0, len(a.policies))
+	for _, policy := range a.policies {
+		p := &Policy{
+			Name:  policy.Name,
+			Paths: make([]*PathCapabilities, 0, len(policy.Paths)),
+			Raw:   policy.Raw,
+		}
+		for _, pc := range policy.Paths {
+			if !pc.Templated {
+				p.Paths = append(p.Paths, pc)
+				continue
+			}
+
+			prefix := prefixes[0]
+			prefixes = prefixes[1:]
+			if prefix == "" {
 				continue
 			}
-			existing := raw.(uint32)
+			epc := *pc
+			epc.Prefix = prefix
+			epc.Templated = false
+			p.Paths = append(p.Paths, &epc)
+		}
+		expanded = append(expanded, p)
+	}
+
+	acl, err := NewACL(expanded)
+	if err != nil {
+		return nil, err
+	}
+	a.expansions.Add(key, acl)
+	return acl, nil
+}
+
+// expandPath replaces the placeholders of a templated path with their
+// values. The path is not resolved if a value is missing, or is not a
+// single path segment without wildcards.
+func (d *ACLTemplateData) expandPath(path string) (string, bool) {
+	resolved := true
+	expanded := aclTemplateRegexp.ReplaceAllStringFunc(path, func(match string) string {
+		value, _ := d.value(strings.TrimSpace(match[2 : len(match)-2]))
+		if value == "" || strings.ContainsAny(value, "/*+") {
+			resolved = false
+		}
+		return value
+	})
+	if !resolved {
+		return "", false
+	}
+	return expanded, true
+}
+
+// value returns the value of a placeholder, which is empty if it is not
+// set. The second value is false if the placeholder is not valid.
+func (d *ACLTemplateData) value(placeholder string) (string, bool) {
+	if d == nil {
+		d = &ACLTemplateData{}
+	}
+	entity := d.Entity
+	if entity == nil {
+		entity = &Entity{}
+	}
+
+	const aliasPrefix, aliasSuffix = "identity.entity.aliases.", ".name"
+	switch {
+	case placeholder == "token.display_name":
+		return d.DisplayName, true
+
+	case strings.HasPrefix(placeholder, "token.metadata."):
+		key := strings.TrimPrefix(placeholder, "token.metadata.")
+		return d.Metadata[key], key != ""
+
+	case placeholder == "identity.entity.id":
+		return entity.ID, true
+
+	case placeholder == "identity.entity.name":
+		return entity.Name, true
+
+	case strings.HasPrefix(placeholder, "identity.entity.metadata."):
+		key := strings.TrimPrefix(placeholder, "identity.entity.metadata.")
+		return entity.Metadata[key], key != ""
+
+	case len(placeholder) > len(aliasPrefix)+len(aliasSuffix) &&
+		strings.HasPrefix(placeholder, aliasPrefix) && strings.HasSuffix(placeholder, aliasSuffix):
+		accessor := placeholder[len(aliasPrefix) : len(placeholder)-len(aliasSuffix)]
+		for _, alias := range entity.Aliases {
+			if alias.MountAccessor == accessor {
+				return alias.Name, true
+			}
+		}
+		return "", true
+	}
+	return "", false
+}
 
-			switch {
-			case existing&DenyCapabilityInt > 0:
-				// If we are explicitly denied in the existing capability set,
-				// don't save anything else
+// validateTemplatedPath checks that the placeholders of a templated policy
+// path are valid
+func validateTemplatedPath(path string) error {
+	for _, match := range aclTemplateRegexp.FindAllStringSubmatch(path, -1) {
+		if _, ok := (*ACLTemplateData)(nil).value(strings.TrimSpace(match[1])); !ok {
+			return fmt.Errorf("invalid placeholder '%s'", match[0])
+		}
+	}
+	rest := aclTemplateRegexp.ReplaceAllString(path, "")
+	if strings.Contains(rest, "{{") || strings.Contains(rest, "}}") {
+		return fmt.Errorf("unterminated placeholder")
+	}
+	return nil
+}
 
-			case pc.CapabilitiesBitmap&DenyCapabilityInt > 0:
-				// If this new policy explicitly denies, only save the deny value
-				tree.Insert(pc.Prefix, DenyCapabilityInt)
+// rule returns the rule of the ACL matching the path. Exact rules take
+// precedence over the rules with wildcards, of which the one with the
+// fewest wildcards and then the longest prefix matches.
+func (a *ACL) rule(path string) (*aclRule, bool) {
+	if raw, ok := a.exactRules.Get(path); ok {
+		return raw.(*aclRule), true
+	}
 
-			default:
-				// Insert the capabilities in this new policy into the existing
-				// value
-				tree.Insert(pc.Prefix, existing|pc.CapabilitiesBitmap)
+	var match *aclWildcardRule
+	if prefix, raw, ok := a.globRules.LongestPrefix(path); ok {
+		match = &aclWildcardRule{
+			prefix:    prefix,
+			glob:      true,
+			wildcards: 1,
+			prefixLen: len(prefix),
+			rule:      raw.(*aclRule),
+		}
+	}
+
+	// The segment wildcard rules are sorted by precedence, so the first
+	// matching one is the best
+	if len(a.segmentRules) > 0 {
+		segments := strings.Split(path, "/")
+		for _, wr := range a.segmentRules {
+			if match != nil && !wr.precedes(match) {
+				break
+			}
+			if wr.matches(segments) {
+				match = wr
+				break
 			}
 		}
 	}
-	return a, nil
+
+	if match == nil {
+		return nil, false
+	}
+	return match.rule, true
 }
 
 func (a *ACL) Capabilities(path string) (pathCapabilities []string) {
@@ -77,23 +444,13 @@ func (a *ACL) Capabilities(path string) (pathCapabilities []string) {
 		return []string{RootCapability}
 	}
 
-	// Find an exact matching rule, look for glob if no match
-	var capabilities uint32
-	raw, ok := a.exactRules.Get(path)
-	if ok {
-		capabilities = raw.(uint32)
-		goto CHECK
-	}
-
-	// Find a glob rule, default deny if no match
-	_, raw, ok = a.globRules.LongestPrefix(path)
+	// Find a matching rule, default deny if no match
+	rule, ok := a.rule(path)
 	if !ok {
 		return []string{DenyCapability}
-	} else {
-		capabilities = raw.(uint32)
 	}
+	capabilities := rule.capabilities
 
-CHECK:
 	if capabilities&SudoCapabilityInt > 0 {
 		pathCapabilities = append(pathCapabilities, SudoCapability)
 	}
@@ -121,37 +478,44 @@ CHECK:
 	return
 }
 
-// AllowOperation is used to check if the given operation is permitted. The
-// first bool indicates if an op is allowed, the second whether sudo priviliges
-// exist for that op and path.
-func (a *ACL) AllowOperation(op logical.Operation, path string) (allowed bool, sudo bool) {
+// Permissions returns the constraints of the ACL on the parameters and the
+// response wrapping of the requests to the path, which are nil if there are
+// none
+func (a *ACL) Permissions(path string) *PathPermissions {
+	if a.root {
+		return nil
+	}
+	rule, ok := a.rule(path)
+	if !ok {
+		return nil
+	}
+	return rule.permissions
+}
+
+// AllowOperation is used to check if the operation of the request is
+// permitted on its path. The first bool indicates if an op is allowed, the
+// second whether sudo priviliges exist for that op and path. If the
+// capabilities allow the op but the request does not meet the constraints
+// of the path on its parameters or response wrapping, the reason is set.
+func (a *ACL) AllowOperation(req *logical.Request) (allowed bool, sudo bool, reason string) {
 	// Fast-path root
 	if a.root {
-		return true, true
+		return true, true, ""
 	}
 
 	// Help is always allowed
+	op := req.Operation
 	if op == logical.HelpOperation {
-		return true, false
-	}
-
-	// Find an exact matching rule, look for glob if no match
-	var capabilities uint32
-	raw, ok := a.exactRules.Get(path)
-	if ok {
-		capabilities = raw.(uint32)
-		goto CHECK
+		return true, false, ""
 	}
 
-	// Find a glob rule, default deny if no match
-	_, raw, ok = a.globRules.LongestPrefix(path)
+	// Find a matching rule, default deny if no match
+	rule, ok := a.rule(req.Path)
 	if !ok {
-		return false, false
-	} else {
-		capabilities = raw.(uint32)
+		return false, false, ""
 	}
+	capabilities := rule.capabilities
 
-CHECK:
 	// Check if the minimum permissions are met
 	// If "deny" has been explicitly set, only deny will be in the map, so we
 	// only need to check for the existence of other values
@@ -173,7 +537,173 @@ CHECK:
 		allowed = capabilities&UpdateCapabilityInt > 0
 
 	default:
-		return false, false
+		return false, false, ""
+	}
+	if !allowed || rule.permissions == nil {
+		return
+	}
+
+	if reason = rule.permissions.check(req); reason != "" {
+		return false, sudo, reason
 	}
 	return
 }
+
+// check returns the reason why the request does not meet the constraints,
+// which is empty if it does. The parameters are only checked for the
+// operations which write data.
+func (p *PathPermissions) check(req *logical.Request) string {
+	if p.MinWrappingTTL != 0 || p.MaxWrappingTTL != 0 {
+		switch {
+		case req.WrapTTL == 0:
+			return "response wrapping is required"
+		case p.MinWrappingTTL != 0 && req.WrapTTL < p.MinWrappingTTL:
+			return fmt.Sprintf("wrapping TTL %s is below the minimum of %s", req.WrapTTL, p.MinWrappingTTL)
+		case p.MaxWrappingTTL != 0 && req.WrapTTL > p.MaxWrappingTTL:
+			return fmt.Sprintf("wrapping TTL %s is above the maximum of %s", req.WrapTTL, p.MaxWrappingTTL)
+		}
+	}
+
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return ""
+	}
+
+	// Sort the parameters so that the reason does not vary between requests
+	names := make([]string, 0, len(req.Data))
+	for name := range req.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		values := parameterValues(req.Data[name])
+
+		denied, ok := p.DeniedParameters[name]
+		if !ok {
+			denied, ok = p.DeniedParameters["*"]
+		}
+		if ok {
+			if len(denied) == 0 {
+				return fmt.Sprintf("parameter %q is denied", name)
+			}
+			for _, value := range values {
+				if globListMatch(denied, value) {
+					return fmt.Sprintf("value %q of parameter %q is denied", value, name)
+				}
+			}
+		}
+
+		if p.AllowedParameters == nil {
+			continue
+		}
+		allowed, ok := p.AllowedParameters[name]
+		if !ok {
+			allowed, ok = p.AllowedParameters["*"]
+		}
+		if !ok {
+			return fmt.Sprintf("parameter %q is not allowed", name)
+		}
+		if len(allowed) == 0 {
+			continue
+		}
+		for _, value := range values {
+			if !globListMatch(allowed, value) {
+				return fmt.Sprintf("value %q of parameter %q is not allowed", value, name)
+			}
+		}
+	}
+
+	for _, name := range p.RequiredParameters {
+		if _, ok := req.Data[name]; !ok {
+			return fmt.Sprintf("required parameter %q is missing", name)
+		}
+	}
+	return ""
+}
+
+// parameterValues returns the values of a request parameter as strings,
+// which are its elements if it is a list
+func parameterValues(raw interface{}) []string {
+	switch v := raw.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			values = append(values, fmt.Sprint(value))
+		}
+		return values
+	}
+	return []string{fmt.Sprint(raw)}
+}
+
+// globListMatch checks if the value matches one of the globs
+func globListMatch(globs []string, value string) bool {
+	for _, glob := range globs {
+		if strutil.GlobbedStringsMatch(glob, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePathPermissions returns the permissions of two policies on the same
+// path. The allowed and denied values and the required parameters are
+// merged, an empty list of values taking precedence. The lowest minimum and
+// the highest maximum wrapping TTLs are kept.
+func mergePathPermissions(a, b *PathPermissions) *PathPermissions {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+
+	merged := &PathPermissions{
+		AllowedParameters:  mergeParameterValues(a.AllowedParameters, b.AllowedParameters),
+		DeniedParameters:   mergeParameterValues(a.DeniedParameters, b.DeniedParameters),
+		RequiredParameters: append([]string{}, a.RequiredParameters...),
+		MinWrappingTTL:     a.MinWrappingTTL,
+		MaxWrappingTTL:     a.MaxWrappingTTL,
+	}
+	for _, name := range b.RequiredParameters {
+		if !strutil.StrListContains(merged.RequiredParameters, name) {
+			merged.RequiredParameters = append(merged.RequiredParameters, name)
+		}
+	}
+	if b.MinWrappingTTL != 0 && (merged.MinWrappingTTL == 0 || b.MinWrappingTTL < merged.MinWrappingTTL) {
+		merged.MinWrappingTTL = b.MinWrappingTTL
+	}
+	if b.MaxWrappingTTL > merged.MaxWrappingTTL {
+		merged.MaxWrappingTTL = b.MaxWrappingTTL
+	}
+	return merged
+}
+
+// mergeParameterValues merges the values of the parameters, an empty list
+// of values taking precedence
+func mergeParameterValues(a, b map[string][]string) map[string][]string {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+
+	merged := make(map[string][]string, len(a)+len(b))
+	for name, values := range a {
+		merged[name] = values
+	}
+	for name, values := range b {
+		existing, ok := merged[name]
+		switch {
+		case !ok:
+			merged[name] = values
+		case len(existing) == 0 || len(values) == 0:
+			merged[name] = []string{}
+		default:
+			merged[name] = append(append([]string{}, existing...), values...)
+		}
+	}
+	return merged
+}
@@ -3,6 +3,7 @@ package vault
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/logical"
 )
@@ -59,7 +60,7 @@ func TestACL_Root(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 
-	allowed, rootPrivs := acl.AllowOperation(logical.UpdateOperation, "sys/mount/foo")
+	allowed, rootPrivs, _ := acl.AllowOperation(&logical.Request{Operation: logical.UpdateOperation, Path: "sys/mount/foo"})
 	if !rootPrivs {
 		t.Fatalf("expected root")
 	}
@@ -81,7 +82,7 @@ func TestACL_Single(t *testing.T) {
 
 	// Type of operation is not important here as we only care about checking
 	// sudo/root
-	_, rootPrivs := acl.AllowOperation(logical.ReadOperation, "sys/mount/foo")
+	_, rootPrivs, _ := acl.AllowOperation(&logical.Request{Operation: logical.ReadOperation, Path: "sys/mount/foo"})
 	if rootPrivs {
 		t.Fatalf("unexpected root")
 	}
@@ -117,7 +118,7 @@ func TestACL_Single(t *testing.T) {
 	}
 
 	for _, tc := range tcases {
-		allowed, rootPrivs := acl.AllowOperation(tc.op, tc.path)
+		allowed, rootPrivs, _ := acl.AllowOperation(&logical.Request{Operation: tc.op, Path: tc.path})
 		if allowed != tc.allowed {
 			t.Fatalf("bad: case %#v: %v, %v", tc, allowed, rootPrivs)
 		}
@@ -148,7 +149,7 @@ func TestACL_Layered(t *testing.T) {
 func testLayeredACL(t *testing.T, acl *ACL) {
 	// Type of operation is not important here as we only care about checking
 	// sudo/root
-	_, rootPrivs := acl.AllowOperation(logical.ReadOperation, "sys/mount/foo")
+	_, rootPrivs, _ := acl.AllowOperation(&logical.Request{Operation: logical.ReadOperation, Path: "sys/mount/foo"})
 	if rootPrivs {
 		t.Fatalf("unexpected root")
 	}
@@ -189,7 +190,7 @@ func testLayeredACL(t *testing.T, acl *ACL) {
 	}
 
 	for _, tc := range tcases {
-		allowed, rootPrivs := acl.AllowOperation(tc.op, tc.path)
+		allowed, rootPrivs, _ := acl.AllowOperation(&logical.Request{Operation: tc.op, Path: tc.path})
 		if allowed != tc.allowed {
 			t.Fatalf("bad: case %#v: %v, %v", tc, allowed, rootPrivs)
 		}
@@ -199,6 +200,267 @@ func testLayeredACL(t *testing.T, acl *ACL) {
 	}
 }
 
+func TestACL_Templated(t *testing.T) {
+	policy, err := Parse(aclTemplatedPolicy)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	acl, err := NewACL([]*Policy{policy})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Templated paths match nothing until expanded
+	if allowed, _, _ := acl.AllowOperation(&logical.Request{Operation: logical.ReadOperation, Path: "secret/users/armon/foo"}); allowed {
+		t.Fatalf("unexpected allowed")
+	}
+
+	data := &ACLTemplateData{
+		DisplayName: "userpass-armon",
+		Metadata: map[string]string{
+			"team": "core",
+			"path": "a/b",
+		},
+		Entity: &Entity{
+			ID:   "entity-id",
+			Name: "armon",
+			Aliases: []*Alias{
+				&Alias{MountAccessor: "auth_userpass_1234", Name: "armon"},
+			},
+		},
+	}
+	expanded, err := acl.Expand(data)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type tcase struct {
+		op      logical.Operation
+		path    string
+		allowed bool
+	}
+	tcases := []tcase{
+		{logical.ReadOperation, "static/foo", true},
+		{logical.UpdateOperation, "secret/users/armon/foo", true},
+		{logical.UpdateOperation, "secret/users/mitchellh/foo", false},
+		{logical.ReadOperation, "secret/teams/core", true},
+		{logical.ReadOperation, "secret/teams/core/foo", false},
+		{logical.ReadOperation, "secret/entities/entity-id/foo", true},
+		{logical.ReadOperation, "secret/names/armon", true},
+		{logical.ReadOperation, "secret/display/userpass-armon", true},
+
+		// Unresolved placeholders and values which are not a single path
+		// segment match nothing
+		{logical.ReadOperation, "secret/region/", false},
+		{logical.ReadOperation, "secret/paths/a/b", false},
+		{logical.ReadOperation, "secret/github/", false},
+	}
+	for _, tc := range tcases {
+		allowed, _, _ := expanded.AllowOperation(&logical.Request{Operation: tc.op, Path: tc.path})
+		if allowed != tc.allowed {
+			t.Fatalf("bad: case %#v: %v", tc, allowed)
+		}
+	}
+
+	// The expansions are cached by their values
+	again, err := acl.Expand(data)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if again != expanded {
+		t.Fatalf("expected cached expansion")
+	}
+
+	// A token without an entity only gets the token placeholders
+	expanded, err = acl.Expand(&ACLTemplateData{
+		DisplayName: "token",
+		Metadata: map[string]string{
+			"team": "ops",
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if allowed, _, _ := expanded.AllowOperation(&logical.Request{Operation: logical.ReadOperation, Path: "secret/teams/ops"}); !allowed {
+		t.Fatalf("expected allowed")
+	}
+	if allowed, _, _ := expanded.AllowOperation(&logical.Request{Operation: logical.UpdateOperation, Path: "secret/users/armon/foo"}); allowed {
+		t.Fatalf("unexpected allowed")
+	}
+}
+
+func TestACL_Permissions(t *testing.T) {
+	policy1, err := Parse(aclPermissionsPolicy)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	policy2, err := Parse(aclPermissionsPolicy2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	acl, err := NewACL([]*Policy{policy1, policy2})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type tcase struct {
+		op      logical.Operation
+		path    string
+		data    map[string]interface{}
+		wrapTTL time.Duration
+		reason  string
+	}
+	tcases := []tcase{
+		{logical.UpdateOperation, "pki/issue/web", map[string]interface{}{"common_name": "www.example.com"}, 0, ""},
+		{logical.UpdateOperation, "pki/issue/web", map[string]interface{}{"common_name": "www.example.com", "ttl": "1h"}, 0, ""},
+		{logical.UpdateOperation, "pki/issue/web", map[string]interface{}{"common_name": "www.example.org"}, 0, `value "www.example.org" of parameter "common_name" is not allowed`},
+		{logical.UpdateOperation, "pki/issue/web", map[string]interface{}{"common_name": "www.example.com", "ttl": "8760h"}, 0, `value "8760h" of parameter "ttl" is denied`},
+		{logical.UpdateOperation, "pki/issue/web", map[string]interface{}{"common_name": "www.example.com", "format": "der"}, 0, `parameter "format" is not allowed`},
+		{logical.UpdateOperation, "pki/issue/web", map[string]interface{}{"common_name": "www.example.com", "alt_names": []interface{}{"a.example.com", "b.example.org"}}, 0, `value "b.example.org" of parameter "alt_names" is not allowed`},
+		{logical.UpdateOperation, "pki/issue/web", map[string]interface{}{"ttl": "1h"}, 0, `required parameter "common_name" is missing`},
+
+		// The parameters are only checked for the operations writing data
+		{logical.ReadOperation, "pki/issue/web", map[string]interface{}{"format": "der"}, 0, ""},
+
+		// The allowed values of both policies are merged
+		{logical.UpdateOperation, "pki/issue/web", map[string]interface{}{"common_name": "www.example.net"}, 0, ""},
+
+		{logical.UpdateOperation, "secret/foo", map[string]interface{}{"value": "bar"}, 0, ""},
+		{logical.UpdateOperation, "secret/foo", map[string]interface{}{"value": "bar", "password": "baz"}, 0, `parameter "password" is denied`},
+
+		{logical.ReadOperation, "secret/wrapped/foo", nil, 0, "response wrapping is required"},
+		{logical.ReadOperation, "secret/wrapped/foo", nil, 30 * time.Second, "wrapping TTL 30s is below the minimum of 1m0s"},
+		{logical.ReadOperation, "secret/wrapped/foo", nil, 2 * time.Hour, "wrapping TTL 2h0m0s is above the maximum of 1h0m0s"},
+		{logical.ReadOperation, "secret/wrapped/foo", nil, 5 * time.Minute, ""},
+	}
+	for _, tc := range tcases {
+		allowed, _, reason := acl.AllowOperation(&logical.Request{
+			Operation: tc.op,
+			Path:      tc.path,
+			Data:      tc.data,
+			WrapTTL:   tc.wrapTTL,
+		})
+		if allowed != (tc.reason == "") || reason != tc.reason {
+			t.Fatalf("bad: case %#v: %v, %q", tc, allowed, reason)
+		}
+	}
+
+	// Denied capabilities do not give a reason
+	allowed, _, reason := acl.AllowOperation(&logical.Request{Operation: logical.DeleteOperation, Path: "pki/issue/web"})
+	if allowed || reason != "" {
+		t.Fatalf("bad: %v, %q", allowed, reason)
+	}
+
+	permissions := acl.Permissions("pki/issue/web")
+	if permissions == nil {
+		t.Fatalf("expected permissions")
+	}
+	if !reflect.DeepEqual(permissions.AllowedParameters["common_name"], []string{"*.example.com", "*.example.net"}) {
+		t.Fatalf("bad: %#v", permissions.AllowedParameters)
+	}
+	if permissions := acl.Permissions("prod/foo"); permissions != nil {
+		t.Fatalf("bad: %#v", permissions)
+	}
+}
+
+func TestACL_SegmentWildcards(t *testing.T) {
+	policy, err := Parse(aclSegmentWildcardPolicy)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	acl, err := NewACL([]*Policy{policy})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type tcase struct {
+		path         string
+		capabilities []string
+	}
+	tcases := []tcase{
+		// '+' matches exactly one segment
+		{"kv/ops/config", []string{"read"}},
+		{"kv/dev/config", []string{"read"}},
+		{"kv/config", []string{"deny"}},
+		{"kv/ops/eu/config", []string{"deny"}},
+		{"kv/ops/configs", []string{"deny"}},
+
+		// The exact path wins over the wildcards
+		{"secret/core/config", []string{"update"}},
+
+		// Fewer wildcards win, whatever the length of their prefix
+		{"secret/ops/config/foo", []string{"create"}},
+		{"secret/core/config/foo", []string{"create"}},
+
+		// Then the longer prefix before the first wildcard wins
+		{"teams/dev/shared/foo", []string{"delete"}},
+		{"teams/ops/shared/foo", []string{"sudo"}},
+		{"teams/a/b", []string{"read"}},
+
+		// Then the path without a glob wins
+		{"secret/ops/config", []string{"read"}},
+		{"apps/web/keys/tls", []string{"list"}},
+
+		// A glob after a '+' matches the rest of the path
+		{"apps/web/keys/tls/foo", []string{"update"}},
+		{"apps/web/other", []string{"deny"}},
+
+		// Several '+' segments
+		{"db/eu/prod/creds", []string{"read"}},
+		{"db/eu/creds", []string{"deny"}},
+
+		// '+' within a segment is not a wildcard
+		{"literal/a+b", []string{"read"}},
+		{"literal/ab", []string{"deny"}},
+	}
+	for _, tc := range tcases {
+		actual := acl.Capabilities(tc.path)
+		if !reflect.DeepEqual(actual, tc.capabilities) {
+			t.Fatalf("bad: path %q: got %#v, expected %#v", tc.path, actual, tc.capabilities)
+		}
+	}
+
+	allowed, _, _ := acl.AllowOperation(&logical.Request{Operation: logical.ReadOperation, Path: "kv/ops/config"})
+	if !allowed {
+		t.Fatalf("expected allowed")
+	}
+}
+
+func TestACL_SegmentWildcardsLayered(t *testing.T) {
+	policy1, err := Parse(`
+path "secret/+/config" {
+	capabilities = ["read"]
+}`)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	policy2, err := Parse(`
+path "secret/+/config" {
+	capabilities = ["update"]
+}
+path "secret/+/+" {
+	capabilities = ["deny"]
+}`)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The rules of the same path are merged, and the order of the policies
+	// does not change the precedence
+	for _, policies := range [][]*Policy{{policy1, policy2}, {policy2, policy1}} {
+		acl, err := NewACL(policies)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if actual := acl.Capabilities("secret/ops/config"); !reflect.DeepEqual(actual, []string{"read", "update"}) {
+			t.Fatalf("bad: %#v", actual)
+		}
+		if actual := acl.Capabilities("secret/ops/other"); !reflect.DeepEqual(actual, []string{"deny"}) {
+			t.Fatalf("bad: %#v", actual)
+		}
+	}
+}
+
 var aclPolicy = `
 name = "dev"
 path "dev/*" {
@@ -248,3 +510,111 @@ path "foo/bar" {
 	capabilities = ["deny"]
 }
 `
+
+var aclTemplatedPolicy = `
+name = "templated"
+path "static/*" {
+	capabilities = ["read"]
+}
+path "secret/users/{{identity.entity.aliases.auth_userpass_1234.name}}/*" {
+	capabilities = ["read", "update"]
+}
+path "secret/teams/{{token.metadata.team}}" {
+	capabilities = ["read"]
+}
+path "secret/entities/{{ identity.entity.id }}/*" {
+	capabilities = ["read"]
+}
+path "secret/names/{{identity.entity.name}}" {
+	capabilities = ["read"]
+}
+path "secret/display/{{token.display_name}}" {
+	capabilities = ["read"]
+}
+path "secret/region/{{token.metadata.region}}*" {
+	capabilities = ["read"]
+}
+path "secret/paths/{{token.metadata.path}}" {
+	capabilities = ["read"]
+}
+path "secret/github/{{identity.entity.aliases.auth_github_1234.name}}*" {
+	capabilities = ["read"]
+}
+`
+
+var aclPermissionsPolicy = `
+name = "pki"
+path "pki/issue/web" {
+	capabilities = ["read", "update"]
+	allowed_parameters = {
+		"common_name" = ["*.example.com"]
+		"alt_names" = ["*.example.com"]
+		"ttl" = []
+	}
+	denied_parameters = {
+		"ttl" = ["*760h"]
+	}
+	required_parameters = ["common_name"]
+}
+path "secret/*" {
+	capabilities = ["update"]
+	denied_parameters = {
+		"password" = []
+	}
+}
+path "secret/wrapped/*" {
+	capabilities = ["read"]
+	min_wrapping_ttl = "1m"
+	max_wrapping_ttl = 3600
+}
+`
+
+var aclPermissionsPolicy2 = `
+name = "pki-net"
+path "pki/issue/web" {
+	capabilities = ["update"]
+	allowed_parameters = {
+		"common_name" = ["*.example.net"]
+	}
+}
+`
+
+var aclSegmentWildcardPolicy = `
+name = "segments"
+path "kv/+/config" {
+	capabilities = ["read"]
+}
+path "secret/+/config" {
+	capabilities = ["read"]
+}
+path "secret/core/config" {
+	capabilities = ["update"]
+}
+path "secret/+/config/*" {
+	capabilities = ["list"]
+}
+path "secret/*" {
+	capabilities = ["create"]
+}
+path "apps/+/keys/*" {
+	capabilities = ["update"]
+}
+path "apps/+/keys/+" {
+	capabilities = ["list"]
+}
+path "teams/+/shared/*" {
+	capabilities = ["delete"]
+}
+path "teams/ops/+/*" {
+	capabilities = ["sudo"]
+}
+path "teams/+/+" {
+	capabilities = ["read"]
+}
+path "db/+/+/creds" {
+	capabilities = ["read"]
+}
+path "literal/a+b" {
+	capabilities = ["read"]
+}
+`
@@ -106,7 +106,7 @@ func (c *Core) disableAudit(path string) error {
 	defer c.auditLock.Unlock()
 
 	newTable := c.audit.ShallowClone()
-	found := newTable.Remove(path)
+	found := newTable.Remove(rootNamespaceID, path)
 
 	// Ensure there was a match
 	if !found {
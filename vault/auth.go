@@ -45,12 +45,20 @@ func (c *Core) enableCredential(entry *MountEntry) error {
 		return fmt.Errorf("backend path must be specified")
 	}
 
+	// Resolve the namespace of the backend
+	ns := c.namespaceByID(entry.NamespaceID)
+	if ns == nil {
+		return fmt.Errorf("namespace %q not found", entry.NamespaceID)
+	}
+	entry.namespace = ns
+
 	c.authLock.Lock()
 	defer c.authLock.Unlock()
 
-	// Look for matching name
+	// Look for matching name within the namespace
 	for _, ent := range c.auth.Entries {
 		switch {
+		case ent.NamespaceID != entry.NamespaceID:
 		// Existing is oauth/github/ new is oauth/ or
 		// existing is oauth/ and new is oauth/github/
 		case strings.HasPrefix(ent.Path, entry.Path):
@@ -71,7 +79,12 @@ func (c *Core) enableCredential(entry *MountEntry) error {
 		return err
 	}
 	entry.UUID = entryUUID
-	view := NewBarrierView(c.barrier, credentialBarrierPrefix+entry.UUID+"/")
+	accessor, err := credentialAccessor(entry.Type)
+	if err != nil {
+		return err
+	}
+	entry.Accessor = accessor
+	view := NewBarrierView(c.barrier, ns.barrierPrefix()+credentialBarrierPrefix+entry.UUID+"/")
 
 	// Create the new backend
 	backend, err := c.newCredentialBackend(entry.Type, c.mountEntrySysView(entry), view, nil)
@@ -89,17 +102,18 @@ func (c *Core) enableCredential(entry *MountEntry) error {
 	c.auth = newTable
 
 	// Mount the backend
-	path := credentialRoutePrefix + entry.Path
+	path := entry.routePath()
 	if err := c.router.Mount(backend, path, entry, view); err != nil {
 		return err
 	}
 	c.logger.Printf("[INFO] core: enabled credential backend '%s' type: %s",
-		entry.Path, entry.Type)
+		path, entry.Type)
 	return nil
 }
 
-// disableCredential is used to disable an existing credential backend
-func (c *Core) disableCredential(path string) error {
+// disableCredential is used to disable an existing credential backend of a
+// namespace
+func (c *Core) disableCredential(ns *Namespace, path string) error {
 	// Ensure we end the path in a slash
 	if !strings.HasSuffix(path, "/") {
 		path += "/"
@@ -111,7 +125,7 @@ func (c *Core) disableCredential(path string) error {
 	}
 
 	// Store the view for this backend
-	fullPath := credentialRoutePrefix + path
+	fullPath := ns.Path + credentialRoutePrefix + path
 	view := c.router.MatchingStorageView(fullPath)
 	if view == nil {
 		return fmt.Errorf("no matching backend")
@@ -121,7 +135,7 @@ func (c *Core) disableCredential(path string) error {
 	defer c.authLock.Unlock()
 
 	// Mark the entry as tainted
-	if err := c.taintCredEntry(path); err != nil {
+	if err := c.taintCredEntry(ns.ID, path); err != nil {
 		return err
 	}
 
@@ -147,19 +161,26 @@ func (c *Core) disableCredential(path string) error {
 		}
 	}
 
+	// Remove the identity aliases of the backend
+	if entry := c.auth.Find(ns.ID, path); entry != nil && c.identityStore != nil {
+		if err := c.identityStore.deleteMountAliases(entry.Accessor); err != nil {
+			return err
+		}
+	}
+
 	// Remove the mount table entry
-	if err := c.removeCredEntry(path); err != nil {
+	if err := c.removeCredEntry(ns.ID, path); err != nil {
 		return err
 	}
-	c.logger.Printf("[INFO] core: disabled credential backend '%s'", path)
+	c.logger.Printf("[INFO] core: disabled credential backend '%s'", fullPath)
 	return nil
 }
 
 // removeCredEntry is used to remove an entry in the auth table
-func (c *Core) removeCredEntry(path string) error {
+func (c *Core) removeCredEntry(namespaceID, path string) error {
 	// Taint the entry from the auth table
 	newTable := c.auth.ShallowClone()
-	newTable.Remove(path)
+	newTable.Remove(namespaceID, path)
 
 	// Update the auth table
 	if err := c.persistAuth(newTable); err != nil {
@@ -172,11 +193,11 @@ func (c *Core) removeCredEntry(path string) error {
 }
 
 // taintCredEntry is used to mark an entry in the auth table as tainted
-func (c *Core) taintCredEntry(path string) error {
+func (c *Core) taintCredEntry(namespaceID, path string) error {
 	// Taint the entry from the auth table
 	// We do this on the original since setting the taint operates
 	// on the entries which a shallow clone shares anyways
-	found := c.auth.SetTaint(path, true)
+	found := c.auth.SetTaint(namespaceID, path, true)
 
 	// Ensure there was a match
 	if !found {
@@ -234,6 +255,17 @@ func (c *Core) loadCredentials() error {
 				entry.Table = c.auth.Type
 				needPersist = true
 			}
+
+			// Upgrade to entries with an accessor
+			if entry.Accessor == "" {
+				accessor, err := credentialAccessor(entry.Type)
+				if err != nil {
+					c.logger.Printf("[ERR] core: failed to generate auth entry accessor: %v", err)
+					return errLoadAuthFailed
+				}
+				entry.Accessor = accessor
+				needPersist = true
+			}
 		}
 
 		if needPersist {
@@ -305,6 +337,7 @@ func (c *Core) setupCredentials() error {
 	c.authLock.Lock()
 	defer c.authLock.Unlock()
 
+	var namespaceTokenEntries []*MountEntry
 	for _, entry := range c.auth.Entries {
 		// Work around some problematic code that existed in master for a while
 		if strings.HasPrefix(entry.Path, credentialRoutePrefix) {
@@ -312,8 +345,25 @@ func (c *Core) setupCredentials() error {
 			persistNeeded = true
 		}
 
+		// Resolve the namespace of the entry, whose storage is prefixed
+		// with the one of the namespace
+		entry.namespace = c.namespaceByID(entry.NamespaceID)
+		if entry.namespace == nil {
+			c.logger.Printf(
+				"[ERR] core: namespace %s of credential entry %s not found",
+				entry.NamespaceID, entry.Path)
+			return errLoadAuthFailed
+		}
+
+		// The token store of the root namespace is shared by the other
+		// namespaces, so their entries are mounted once it is set up
+		if entry.Type == "token" && entry.NamespaceID != rootNamespaceID {
+			namespaceTokenEntries = append(namespaceTokenEntries, entry)
+			continue
+		}
+
 		// Create a barrier view using the UUID
-		view = NewBarrierView(c.barrier, credentialBarrierPrefix+entry.UUID+"/")
+		view = NewBarrierView(c.barrier, entry.namespace.barrierPrefix()+credentialBarrierPrefix+entry.UUID+"/")
 
 		// Initialize the backend
 		backend, err = c.newCredentialBackend(entry.Type, c.mountEntrySysView(entry), view, nil)
@@ -325,7 +375,7 @@ func (c *Core) setupCredentials() error {
 		}
 
 		// Mount the backend
-		path := credentialRoutePrefix + entry.Path
+		path := entry.routePath()
 		err = c.router.Mount(backend, path, entry, view)
 		if err != nil {
 			c.logger.Printf("[ERR] core: failed to mount auth entry %s: %v", entry.Path, err)
@@ -347,6 +397,15 @@ func (c *Core) setupCredentials() error {
 		}
 	}
 
+	for _, entry := range namespaceTokenEntries {
+		view = NewBarrierView(c.barrier, entry.namespace.barrierPrefix()+credentialBarrierPrefix+entry.UUID+"/")
+		path := entry.routePath()
+		if err := c.router.Mount(c.tokenStore, path, entry, view); err != nil {
+			c.logger.Printf("[ERR] core: failed to mount auth entry %s: %v", path, err)
+			return errLoadAuthFailed
+		}
+	}
+
 	if persistNeeded {
 		return c.persistAuth(c.auth)
 	}
@@ -397,13 +456,46 @@ func defaultAuthTable() *MountTable {
 	if err != nil {
 		panic(fmt.Sprintf("could not generate UUID for default auth table token entry: %v", err))
 	}
+	tokenAccessor, err := credentialAccessor("token")
+	if err != nil {
+		panic(fmt.Sprintf("could not generate accessor for default auth table token entry: %v", err))
+	}
 	tokenAuth := &MountEntry{
 		Table:       credentialTableType,
 		Path:        "token/",
 		Type:        "token",
 		Description: "token based credentials",
 		UUID:        tokenUUID,
+		Accessor:    tokenAccessor,
 	}
 	table.Entries = append(table.Entries, tokenAuth)
 	return table
 }
+
+// credentialByAccessor returns a copy of the entry of the credential
+// backend of the root namespace with the given accessor, or nil if there is
+// none
+func (c *Core) credentialByAccessor(accessor string) *MountEntry {
+	c.authLock.RLock()
+	defer c.authLock.RUnlock()
+
+	if c.auth == nil {
+		return nil
+	}
+	for _, entry := range c.auth.Entries {
+		if entry.NamespaceID == rootNamespaceID && entry.Accessor == accessor {
+			return entry.Clone()
+		}
+	}
+	return nil
+}
+
+// credentialAccessor generates the accessor of a credential backend, which
+// identifies the backend without revealing the UUID of its barrier view
+func credentialAccessor(entryType string) (string, error) {
+	accessorUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("auth_%s_%s", entryType, accessorUUID[:8]), nil
+}
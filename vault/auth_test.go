@@ -188,7 +188,7 @@ func TestCore_DisableCredential(t *testing.T) {
 		return &NoopBackend{}, nil
 	}
 
-	err := c.disableCredential("foo")
+	err := c.disableCredential(rootNamespace, "foo")
 	if err.Error() != "no matching backend" {
 		t.Fatalf("err: %v", err)
 	}
@@ -203,7 +203,7 @@ func TestCore_DisableCredential(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 
-	err = c.disableCredential("foo")
+	err = c.disableCredential(rootNamespace, "foo")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -237,7 +237,7 @@ func TestCore_DisableCredential(t *testing.T) {
 
 func TestCore_DisableCredential_Protected(t *testing.T) {
 	c, _, _ := TestCoreUnsealed(t)
-	err := c.disableCredential("token")
+	err := c.disableCredential(rootNamespace, "token")
 	if err.Error() != "token credential backend cannot be disabled" {
 		t.Fatalf("err: %v", err)
 	}
@@ -293,7 +293,7 @@ func TestCore_DisableCredential_Cleanup(t *testing.T) {
 	}
 
 	// Disable should cleanup
-	err = c.disableCredential("foo")
+	err = c.disableCredential(rootNamespace, "foo")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
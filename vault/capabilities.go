@@ -16,45 +16,46 @@ func (s *StatusBadRequest) Error() string {
 
 // Capabilities is used to fetch the capabilities of the given token on the given path
 func (c *Core) Capabilities(token, path string) ([]string, error) {
+	capabilities, _, err := c.capabilitiesAndPermissions(token, path)
+	return capabilities, err
+}
+
+// capabilitiesAndPermissions is used to fetch the capabilities of the given
+// token on the given path, along with the constraints of the path on the
+// parameters and response wrapping of the requests
+func (c *Core) capabilitiesAndPermissions(token, path string) ([]string, *PathPermissions, error) {
 	if path == "" {
-		return nil, &StatusBadRequest{Err: "missing path"}
+		return nil, nil, &StatusBadRequest{Err: "missing path"}
 	}
 
 	if token == "" {
-		return nil, &StatusBadRequest{Err: "missing token"}
+		return nil, nil, &StatusBadRequest{Err: "missing token"}
 	}
 
 	te, err := c.tokenStore.Lookup(token)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if te == nil {
-		return nil, &StatusBadRequest{Err: "invalid token"}
-	}
-
-	if te.Policies == nil {
-		return []string{DenyCapability}, nil
+		return nil, nil, &StatusBadRequest{Err: "invalid token"}
 	}
 
-	var policies []*Policy
-	for _, tePolicy := range te.Policies {
-		policy, err := c.policyStore.GetPolicy(tePolicy)
-		if err != nil {
-			return nil, err
-		}
-		policies = append(policies, policy)
+	// Paths outside of the namespace of the token are denied
+	nsPath, ok := c.tokenNamespacePath(te, path)
+	if !ok || c.tokenPolicies(te) == nil {
+		return []string{DenyCapability}, nil, nil
 	}
+	path = nsPath
 
-	if len(policies) == 0 {
-		return []string{DenyCapability}, nil
-	}
-
-	acl, err := NewACL(policies)
+	acl, err := c.tokenACL(te)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	capabilities := acl.Capabilities(path)
 	sort.Strings(capabilities)
-	return capabilities, nil
+	if len(capabilities) == 1 && capabilities[0] == DenyCapability {
+		return capabilities, nil, nil
+	}
+	return capabilities, acl.Permissions(path), nil
 }
@@ -0,0 +1,506 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/physical"
+)
+
+const (
+	// IntNoForwardingHeaderName is set on requests received through the
+	// cluster listener. Those requests have already been forwarded once and
+	// must be handled locally.
+	IntNoForwardingHeaderName = "X-Vault-Internal-No-Request-Forwarding"
+
+	// intForwardedRemoteAddrHeaderName carries the remote address of the
+	// original client of a forwarded request
+	intForwardedRemoteAddrHeaderName = "X-Vault-Internal-Forwarded-Remote-Addr"
+
+	// clusterCertLifetime is the validity of the cluster certificate. The
+	// certificate is generated once and shared by all the nodes of the
+	// cluster.
+	clusterCertLifetime = 262980 * time.Hour
+
+	// coreLocalClusterInfoPath is the path of the cluster certificate and
+	// its key within the barrier
+	coreLocalClusterInfoPath = "core/cluster/local/info"
+)
+
+var (
+	// ErrCannotForward is returned when the request cannot be forwarded to
+	// the active node, for instance because it does not advertise a cluster
+	// address. The caller should fall back to redirecting the client.
+	ErrCannotForward = errors.New("cannot forward request; no connection or address not known")
+)
+
+// clusterKeyParams holds the private key of the cluster certificate in a
+// form that can be encoded in the leader advertisement
+type clusterKeyParams struct {
+	Type string   `json:"type"`
+	X    *big.Int `json:"x"`
+	Y    *big.Int `json:"y"`
+	D    *big.Int `json:"d"`
+}
+
+// clusterInfo is the cluster certificate and its key, as stored in the
+// barrier
+type clusterInfo struct {
+	ClusterCert      []byte            `json:"cluster_cert"`
+	ClusterKeyParams *clusterKeyParams `json:"cluster_key_params"`
+}
+
+// privateKey returns the private key described by the parameters
+func (p *clusterKeyParams) privateKey() (*ecdsa.PrivateKey, error) {
+	if p.Type != "P-521" {
+		return nil, fmt.Errorf("unsupported cluster key type %q", p.Type)
+	}
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P521(),
+			X:     p.X,
+			Y:     p.Y,
+		},
+		D: p.D,
+	}, nil
+}
+
+// activeAdvertisement is stored by the active node under the leader prefix.
+// Besides the address clients are redirected to, it contains what standbys
+// need to forward requests over the cluster port: the cluster address, and
+// the certificate and key that both sides use to authenticate each other.
+type activeAdvertisement struct {
+	AdvertiseAddr    string            `json:"advertise_addr"`
+	ClusterAddr      string            `json:"cluster_addr,omitempty"`
+	ClusterCert      []byte            `json:"cluster_cert,omitempty"`
+	ClusterKeyParams *clusterKeyParams `json:"cluster_key_params,omitempty"`
+}
+
+// activeConnection is the client a standby uses to forward requests to the
+// active node
+type activeConnection struct {
+	*http.Client
+	clusterAddr string
+	clusterCert []byte
+}
+
+// SetClusterHandler sets the handler that serves the requests forwarded by
+// standby nodes to this node when it is active. The handler is expected to
+// be the same one that serves the API.
+func (c *Core) SetClusterHandler(handler http.Handler) {
+	c.clusterHandlerLock.Lock()
+	c.clusterHandler = handler
+	c.clusterHandlerLock.Unlock()
+
+	// If we became active before the handler was set, the cluster listeners
+	// could not be started then
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if c.ha != nil && !c.sealed && !c.standby && c.clusterServer == nil {
+		if err := c.startClusterListener(); err != nil {
+			c.logger.Printf("[ERR] core: %v", err)
+		}
+	}
+}
+
+// ClusterAddr returns the address this node advertises to the other nodes
+// of the cluster for request forwarding
+func (c *Core) ClusterAddr() string {
+	return c.clusterAddr
+}
+
+// setupCluster loads the key pair and self-signed certificate shared by the
+// nodes of the cluster, generating and storing them the first time. It must
+// be called with the state lock held, while the barrier is unsealed.
+func (c *Core) setupCluster() error {
+	found, err := c.loadClusterCert()
+	if err != nil || found {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate cluster private key: %v", err)
+	}
+
+	hostID, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	host := fmt.Sprintf("fw-%s", hostID)
+
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName: host,
+		},
+		DNSNames: []string{host},
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement | x509.KeyUsageCertSign,
+		SerialNumber:          big.NewInt(mathrand.Int63()),
+		NotBefore:             time.Now().Add(-30 * time.Second),
+		NotAfter:              time.Now().Add(clusterCertLifetime),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return fmt.Errorf("failed to generate cluster certificate: %v", err)
+	}
+	parsedCert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse generated cluster certificate: %v", err)
+	}
+
+	value, err := json.Marshal(&clusterInfo{
+		ClusterCert: certBytes,
+		ClusterKeyParams: &clusterKeyParams{
+			Type: "P-521",
+			X:    key.X,
+			Y:    key.Y,
+			D:    key.D,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.barrier.Put(&Entry{
+		Key:   coreLocalClusterInfoPath,
+		Value: value,
+	}); err != nil {
+		return fmt.Errorf("failed to store cluster certificate: %v", err)
+	}
+
+	c.localClusterPrivateKey = key
+	c.localClusterCert = certBytes
+	c.localClusterParsedCert = parsedCert
+	return nil
+}
+
+// loadClusterCert reads the cluster certificate and its key from the
+// barrier, returning whether they are stored
+func (c *Core) loadClusterCert() (bool, error) {
+	entry, err := c.barrier.Get(coreLocalClusterInfoPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read cluster certificate: %v", err)
+	}
+	if entry == nil {
+		return false, nil
+	}
+
+	var info clusterInfo
+	if err := json.Unmarshal(entry.Value, &info); err != nil {
+		return false, fmt.Errorf("failed to decode cluster certificate: %v", err)
+	}
+	if info.ClusterKeyParams == nil {
+		return false, fmt.Errorf("cluster certificate has no key")
+	}
+	key, err := info.ClusterKeyParams.privateKey()
+	if err != nil {
+		return false, err
+	}
+	parsedCert, err := x509.ParseCertificate(info.ClusterCert)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse cluster certificate: %v", err)
+	}
+
+	c.localClusterPrivateKey = key
+	c.localClusterCert = info.ClusterCert
+	c.localClusterParsedCert = parsedCert
+	return true, nil
+}
+
+// setupClusteredBackend starts the communication between the nodes of an
+// HA backend which implements physical.Clustered. The nodes authenticate
+// each other with the cluster certificate, so only unsealed nodes take part.
+// It must be called with the state lock held, once the barrier is unsealed.
+func (c *Core) setupClusteredBackend() error {
+	clustered, ok := c.ha.(physical.Clustered)
+	if !ok {
+		return nil
+	}
+
+	found, err := c.loadClusterCert()
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("cluster certificate not found")
+	}
+
+	tlsConfig := c.clusterTLSConfig()
+	tlsConfig.RootCAs = tlsConfig.ClientCAs
+	tlsConfig.ServerName = c.localClusterParsedCert.Subject.CommonName
+	return clustered.SetupCluster(tlsConfig)
+}
+
+// teardownClusteredBackend stops the communication between the nodes of an
+// HA backend which implements physical.Clustered. It must be called with the
+// state lock held, when sealing.
+func (c *Core) teardownClusteredBackend() error {
+	clustered, ok := c.ha.(physical.Clustered)
+	if !ok {
+		return nil
+	}
+	return clustered.TeardownCluster()
+}
+
+// activeAdvertisementValue returns the value stored under the leader prefix
+// by the active node
+func (c *Core) activeAdvertisementValue() ([]byte, error) {
+	adv := &activeAdvertisement{
+		AdvertiseAddr: c.advertiseAddr,
+	}
+	if c.clusterAddr != "" && c.localClusterPrivateKey != nil {
+		adv.ClusterAddr = c.clusterAddr
+		adv.ClusterCert = c.localClusterCert
+		adv.ClusterKeyParams = &clusterKeyParams{
+			Type: "P-521",
+			X:    c.localClusterPrivateKey.X,
+			Y:    c.localClusterPrivateKey.Y,
+			D:    c.localClusterPrivateKey.D,
+		}
+	}
+	return json.Marshal(adv)
+}
+
+// parseActiveAdvertisement decodes the value stored under the leader prefix.
+// Nodes of earlier versions stored the plain advertise address.
+func parseActiveAdvertisement(value []byte) *activeAdvertisement {
+	var adv activeAdvertisement
+	if err := json.Unmarshal(value, &adv); err != nil {
+		return &activeAdvertisement{
+			AdvertiseAddr: string(value),
+		}
+	}
+	return &adv
+}
+
+// clusterTLSConfig returns the TLS configuration of the cluster listener.
+// Only clients presenting the cluster certificate are accepted.
+func (c *Core) clusterTLSConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.localClusterParsedCert)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{
+			tls.Certificate{
+				Certificate: [][]byte{c.localClusterCert},
+				PrivateKey:  c.localClusterPrivateKey,
+			},
+		},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// startClusterListener starts listening for forwarded requests on the
+// cluster listener addresses. It must be called with the state lock held,
+// after setupCluster.
+func (c *Core) startClusterListener() error {
+	if c.clusterAddr == "" || len(c.clusterListenerAddrs) == 0 {
+		return nil
+	}
+
+	c.clusterHandlerLock.RLock()
+	handler := c.clusterHandler
+	c.clusterHandlerLock.RUnlock()
+	if handler == nil {
+		c.logger.Printf("[WARN] core: no cluster handler set, not starting cluster listeners")
+		return nil
+	}
+
+	tlsConfig := c.clusterTLSConfig()
+	c.clusterServer = &http.Server{
+		Handler: forwardedRequestHandler(handler),
+	}
+
+	for _, addr := range c.clusterListenerAddrs {
+		ln, err := tls.Listen("tcp", addr.String(), tlsConfig)
+		if err != nil {
+			c.stopClusterListener()
+			return fmt.Errorf("failed to start cluster listener on %s: %v", addr, err)
+		}
+		c.logger.Printf("[INFO] core: starting cluster listener on %s", addr)
+		go c.clusterServer.Serve(ln)
+	}
+
+	return nil
+}
+
+// stopClusterListener closes the cluster listeners along with the
+// connections of the standbys. It must be called with the state lock held.
+func (c *Core) stopClusterListener() {
+	if c.clusterServer == nil {
+		return
+	}
+	if err := c.clusterServer.Close(); err != nil {
+		c.logger.Printf("[WARN] core: error closing cluster listeners: %v", err)
+	}
+	c.clusterServer = nil
+}
+
+// forwardedRequestHandler restores the remote address of the original client
+// and marks the request so that it is not forwarded again
+func forwardedRequestHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if remoteAddr := r.Header.Get(intForwardedRemoteAddrHeaderName); remoteAddr != "" {
+			r.RemoteAddr = remoteAddr
+		}
+		r.Header.Del(intForwardedRemoteAddrHeaderName)
+		r.Header.Set(IntNoForwardingHeaderName, "true")
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// refreshRequestForwardingConnection sets up the connection to the active
+// node from its advertisement, if it has changed
+func (c *Core) refreshRequestForwardingConnection(adv *activeAdvertisement) error {
+	c.requestForwardingConnectionLock.Lock()
+	defer c.requestForwardingConnectionLock.Unlock()
+
+	if adv.ClusterAddr == "" || len(adv.ClusterCert) == 0 || adv.ClusterKeyParams == nil {
+		c.clearRequestForwardingConnection()
+		return nil
+	}
+
+	conn := c.requestForwardingConnection
+	if conn != nil && conn.clusterAddr == adv.ClusterAddr && bytes.Equal(conn.clusterCert, adv.ClusterCert) {
+		return nil
+	}
+	c.clearRequestForwardingConnection()
+
+	key, err := adv.ClusterKeyParams.privateKey()
+	if err != nil {
+		return err
+	}
+
+	parsedCert, err := x509.ParseCertificate(adv.ClusterCert)
+	if err != nil {
+		return fmt.Errorf("failed to parse cluster certificate of the active node: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(parsedCert)
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{
+			tls.Certificate{
+				Certificate: [][]byte{adv.ClusterCert},
+				PrivateKey:  key,
+			},
+		},
+		RootCAs:    pool,
+		ServerName: parsedCert.Subject.CommonName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	c.requestForwardingConnection = &activeConnection{
+		Client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+			// Responses of the active node are passed on to the client as
+			// they are, including redirects
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		clusterAddr: adv.ClusterAddr,
+		clusterCert: adv.ClusterCert,
+	}
+	return nil
+}
+
+// resetRequestForwardingConnection drops the connection to the active node
+func (c *Core) resetRequestForwardingConnection() {
+	c.requestForwardingConnectionLock.Lock()
+	defer c.requestForwardingConnectionLock.Unlock()
+	c.clearRequestForwardingConnection()
+}
+
+// clearRequestForwardingConnection drops the connection to the active node.
+// It must be called with the request forwarding connection lock held.
+func (c *Core) clearRequestForwardingConnection() {
+	if c.requestForwardingConnection == nil {
+		return
+	}
+	if transport, ok := c.requestForwardingConnection.Transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+	c.requestForwardingConnection = nil
+}
+
+// ForwardRequest forwards the given request to the active node over the
+// cluster port and returns the status code, headers and body of the
+// response. ErrCannotForward is returned if no connection to the active node
+// is known; Leader sets up the connection when it reads the advertisement of
+// the active node.
+func (c *Core) ForwardRequest(req *http.Request) (int, http.Header, []byte, error) {
+	c.requestForwardingConnectionLock.RLock()
+	defer c.requestForwardingConnectionLock.RUnlock()
+
+	conn := c.requestForwardingConnection
+	if conn == nil {
+		return 0, nil, nil, ErrCannotForward
+	}
+
+	clusterURL, err := url.Parse(conn.clusterAddr)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid cluster address of the active node: %v", err)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("failed to read request body: %v", err)
+		}
+	}
+
+	target := url.URL{
+		Scheme:   "https",
+		Host:     clusterURL.Host,
+		Path:     req.URL.Path,
+		RawQuery: req.URL.RawQuery,
+	}
+	forwarded, err := http.NewRequest(req.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	for k, v := range req.Header {
+		forwarded.Header[k] = v
+	}
+	forwarded.Header.Set(intForwardedRemoteAddrHeaderName, req.RemoteAddr)
+
+	resp, err := conn.Do(forwarded)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("error forwarding request to the active node: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("error reading response of the active node: %v", err)
+	}
+
+	return resp.StatusCode, resp.Header, respBody, nil
+}
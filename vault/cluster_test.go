@@ -0,0 +1,87 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/physical"
+)
+
+func TestCluster_ParseActiveAdvertisement(t *testing.T) {
+	// Values written by older versions only contain the advertise address
+	adv := parseActiveAdvertisement([]byte("http://127.0.0.1:8200"))
+	if adv.AdvertiseAddr != "http://127.0.0.1:8200" || adv.ClusterAddr != "" {
+		t.Fatalf("bad: %#v", adv)
+	}
+}
+
+func TestCluster_NewCoreBadClusterAddr(t *testing.T) {
+	inm := physical.NewInmem(logger)
+	inmha := physical.NewInmemHA(logger)
+	_, err := NewCore(&CoreConfig{
+		Physical:      inm,
+		HAPhysical:    inmha,
+		AdvertiseAddr: "http://127.0.0.1:8200",
+		ClusterAddr:   "http://127.0.0.1:8201",
+		DisableMlock:  true,
+	})
+	if err == nil {
+		t.Fatalf("expected error for a cluster address without https")
+	}
+}
+
+func TestCluster_LeaderClusterAddr(t *testing.T) {
+	inm := physical.NewInmem(logger)
+	inmha := physical.NewInmemHA(logger)
+	clusterAddr := "https://127.0.0.1:8201"
+	core, err := NewCore(&CoreConfig{
+		Physical:      inm,
+		HAPhysical:    inmha,
+		AdvertiseAddr: "http://127.0.0.1:8200",
+		ClusterAddr:   clusterAddr,
+		DisableMlock:  true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	key, _ := TestCoreInit(t, core)
+	if _, err := core.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+	testWaitActive(t, core)
+
+	core2, err := NewCore(&CoreConfig{
+		Physical:      inm,
+		HAPhysical:    inmha,
+		AdvertiseAddr: "http://127.0.0.1:8300",
+		ClusterAddr:   "https://127.0.0.1:8301",
+		DisableMlock:  true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := core2.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+
+	isLeader, advertise, leaderClusterAddr, err := core2.Leader()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if isLeader {
+		t.Fatalf("should be standby")
+	}
+	if advertise != "http://127.0.0.1:8200" {
+		t.Fatalf("bad advertise: %v", advertise)
+	}
+	if leaderClusterAddr != clusterAddr {
+		t.Fatalf("bad cluster addr: %v", leaderClusterAddr)
+	}
+
+	// The standby has a connection to forward requests over
+	core2.requestForwardingConnectionLock.RLock()
+	conn := core2.requestForwardingConnection
+	core2.requestForwardingConnectionLock.RUnlock()
+	if conn == nil || conn.clusterAddr != clusterAddr {
+		t.Fatalf("bad forwarding connection: %#v", conn)
+	}
+}
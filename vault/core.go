@@ -2,20 +2,26 @@ package vault
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/armon/go-metrics"
+	"github.com/armon/go-radix"
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/audit"
 	"github.com/hashicorp/vault/helper/mlock"
+	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/physical"
 	"github.com/hashicorp/vault/shamir"
@@ -75,6 +81,10 @@ var (
 	// ErrHANotEnabled is returned if the operation only makes sense
 	// in an HA setting
 	ErrHANotEnabled = errors.New("Vault is not configured for highly-available mode")
+
+	// ErrSealMigrationPending is returned if Unseal is used while the seal
+	// of the storage differs from the configured seal
+	ErrSealMigrationPending = errors.New("seal migration pending; unseal with the migrate option")
 )
 
 // NonFatalError is an error that can be returned during NewCore that should be
@@ -111,12 +121,44 @@ type Core struct {
 	// AdvertiseAddr is the address we advertise as leader if held
 	advertiseAddr string
 
+	// clusterAddr is the address we advertise to the other nodes of the
+	// cluster for request forwarding if we are the leader
+	clusterAddr string
+
+	// clusterListenerAddrs are the addresses the cluster listeners bind to
+	// while we are the leader
+	clusterListenerAddrs []*net.TCPAddr
+
+	// clusterServer serves forwarded requests on the cluster listeners
+	clusterServer *http.Server
+
+	// clusterHandler serves the requests forwarded by the standbys
+	clusterHandler     http.Handler
+	clusterHandlerLock sync.RWMutex
+
+	// The key pair and certificate used on the cluster port. They are
+	// generated every time this node becomes the leader and are shared with
+	// the standbys through the leader advertisement.
+	localClusterPrivateKey *ecdsa.PrivateKey
+	localClusterCert       []byte
+	localClusterParsedCert *x509.Certificate
+
+	// requestForwardingConnection is used by standbys to forward requests
+	// to the leader
+	requestForwardingConnection     *activeConnection
+	requestForwardingConnectionLock sync.RWMutex
+
 	// physical backend is the un-trusted backend with durable data
 	physical physical.Backend
 
 	// Our Seal, for seal configuration information
 	seal Seal
 
+	// migrationSeal is the configured seal while a seal migration is
+	// pending. Until the migration is completed by UnsealMigrate, seal is
+	// the seal the storage was sealed with.
+	migrationSeal Seal
+
 	// barrier is the security barrier wrapping the physical backend
 	barrier SecurityBarrier
 
@@ -176,6 +218,22 @@ type Core struct {
 	// change underneath a calling function
 	authLock sync.RWMutex
 
+	// namespaces is loaded after unseal since it is a protected
+	// configuration
+	namespaces *NamespaceTable
+
+	// namespacePaths indexes the namespaces by their paths, to find the
+	// namespace of a request path
+	namespacePaths *radix.Tree
+
+	// namespacePolicyStores are the policy stores of the namespaces other
+	// than the root namespace, by namespace ID
+	namespacePolicyStores map[string]*PolicyStore
+
+	// namespaceLock is used to ensure that the namespace table does not
+	// change underneath a calling function
+	namespaceLock sync.RWMutex
+
 	// audit is loaded after unseal since it is a protected
 	// configuration
 	audit *MountTable
@@ -204,6 +262,9 @@ type Core struct {
 	// token store is used to manage authentication tokens
 	tokenStore *TokenStore
 
+	// identity store is used to manage the entities and groups
+	identityStore *IdentityStore
+
 	// metricsCh is used to stop the metrics streaming
 	metricsCh chan struct{}
 
@@ -222,19 +283,22 @@ type Core struct {
 
 // CoreConfig is used to parameterize a core
 type CoreConfig struct {
-	LogicalBackends    map[string]logical.Factory
-	CredentialBackends map[string]logical.Factory
-	AuditBackends      map[string]audit.Factory
-	Physical           physical.Backend
-	HAPhysical         physical.HABackend // May be nil, which disables HA operations
-	Seal               Seal
-	Logger             *log.Logger
-	DisableCache       bool   // Disables the LRU cache on the physical backend
-	DisableMlock       bool   // Disables mlock syscall
-	CacheSize          int    // Custom cache size of zero for default
-	AdvertiseAddr      string // Set as the leader address for HA
-	DefaultLeaseTTL    time.Duration
-	MaxLeaseTTL        time.Duration
+	LogicalBackends      map[string]logical.Factory
+	CredentialBackends   map[string]logical.Factory
+	AuditBackends        map[string]audit.Factory
+	Physical             physical.Backend
+	HAPhysical           physical.HABackend // May be nil, which disables HA operations
+	Seal                 Seal
+	MigrationSeal        Seal // Seal to migrate from, if it is not Shamir
+	Logger               *log.Logger
+	DisableCache         bool           // Disables the LRU cache on the physical backend
+	DisableMlock         bool           // Disables mlock syscall
+	CacheSize            int            // Custom cache size of zero for default
+	AdvertiseAddr        string         // Set as the leader address for HA
+	ClusterAddr          string         // Set as the leader address for request forwarding
+	ClusterListenerAddrs []*net.TCPAddr // Addresses to listen on for forwarded requests
+	DefaultLeaseTTL      time.Duration
+	MaxLeaseTTL          time.Duration
 }
 
 // NewCore is used to construct a new core
@@ -265,6 +329,18 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		}
 	}
 
+	// Validate the cluster addr if its given to us
+	if conf.ClusterAddr != "" {
+		u, err := url.Parse(conf.ClusterAddr)
+		if err != nil {
+			return nil, fmt.Errorf("cluster address is not valid url: %s", err)
+		}
+
+		if u.Scheme != "https" {
+			return nil, fmt.Errorf("cluster address must use the 'https' scheme")
+		}
+	}
+
 	// Wrap the backend in a cache unless disabled
 	if !conf.DisableCache {
 		_, isCache := conf.Physical.(*physical.Cache)
@@ -304,18 +380,20 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 
 	// Setup the core
 	c := &Core{
-		ha:              conf.HAPhysical,
-		advertiseAddr:   conf.AdvertiseAddr,
-		physical:        conf.Physical,
-		seal:            conf.Seal,
-		barrier:         barrier,
-		router:          NewRouter(),
-		sealed:          true,
-		standby:         true,
-		logger:          conf.Logger,
-		defaultLeaseTTL: conf.DefaultLeaseTTL,
-		maxLeaseTTL:     conf.MaxLeaseTTL,
-		cachingDisabled: conf.DisableCache,
+		ha:                   conf.HAPhysical,
+		advertiseAddr:        conf.AdvertiseAddr,
+		clusterAddr:          conf.ClusterAddr,
+		clusterListenerAddrs: conf.ClusterListenerAddrs,
+		physical:             conf.Physical,
+		seal:                 conf.Seal,
+		barrier:              barrier,
+		router:               NewRouter(),
+		sealed:               true,
+		standby:              true,
+		logger:               conf.Logger,
+		defaultLeaseTTL:      conf.DefaultLeaseTTL,
+		maxLeaseTTL:          conf.MaxLeaseTTL,
+		cachingDisabled:      conf.DisableCache,
 	}
 
 	// Setup the backends
@@ -331,6 +409,9 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 	logicalBackends["system"] = func(config *logical.BackendConfig) (logical.Backend, error) {
 		return NewSystemBackend(c, config), nil
 	}
+	logicalBackends["identity"] = func(config *logical.BackendConfig) (logical.Backend, error) {
+		return NewIdentityStore(c, config)
+	}
 	c.logicalBackends = logicalBackends
 
 	credentialBackends := make(map[string]logical.Factory)
@@ -353,6 +434,10 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 	}
 	c.seal.SetCore(c)
 
+	if err := c.setupSealMigration(conf.MigrationSeal); err != nil {
+		return nil, err
+	}
+
 	// Attempt unsealing with stored keys; if there are no stored keys this
 	// returns nil, otherwise returns nil or an error
 	storedKeyErr := c.UnsealWithStoredKeys()
@@ -395,13 +480,13 @@ func (c *Core) fetchACLandTokenEntry(req *logical.Request) (*ACL, *TokenEntry, e
 		return nil, nil, ErrInternalError
 	}
 
-	// Ensure the token is valid
-	if te == nil {
+	// Ensure the token is valid, and that its namespace still exists
+	if te == nil || c.namespaceByID(te.NamespaceID) == nil {
 		return nil, nil, logical.ErrPermissionDenied
 	}
 
 	// Construct the corresponding ACL object
-	acl, err := c.policyStore.ACL(te.Policies...)
+	acl, err := c.tokenACL(te)
 	if err != nil {
 		c.logger.Printf("[ERR] core: failed to construct ACL: %v", err)
 		return nil, nil, ErrInternalError
@@ -410,6 +495,59 @@ func (c *Core) fetchACLandTokenEntry(req *logical.Request) (*ACL, *TokenEntry, e
 	return acl, te, nil
 }
 
+// tokenPolicies returns the policies which apply to a token: its own, and
+// those of its identity entity and of the groups of the entity
+func (c *Core) tokenPolicies(te *TokenEntry) []string {
+	if te.EntityID == "" || c.identityStore == nil {
+		return te.Policies
+	}
+	identityPolicies := c.identityStore.EntityPolicies(te.EntityID)
+	if len(identityPolicies) == 0 {
+		return te.Policies
+	}
+	return strutil.RemoveDuplicates(append(append([]string{}, te.Policies...), identityPolicies...))
+}
+
+// tokenACL returns the ACL of the policies of a token, with their templated
+// paths expanded for the token and its entity. The policies are the ones of
+// the namespace of the token, and the ACL applies to paths relative to it.
+func (c *Core) tokenACL(te *TokenEntry) (*ACL, error) {
+	ps := c.namespacePolicyStore(te.NamespaceID)
+	if ps == nil {
+		return nil, fmt.Errorf("namespace %q of token not found", te.NamespaceID)
+	}
+	acl, err := ps.ACL(c.tokenPolicies(te)...)
+	if err != nil || !acl.templated {
+		return acl, err
+	}
+
+	data := &ACLTemplateData{
+		DisplayName: te.DisplayName,
+		Metadata:    te.LoginMeta,
+	}
+	if te.EntityID != "" && c.identityStore != nil {
+		data.Entity = c.identityStore.Entity(te.EntityID)
+	}
+	return acl.Expand(data)
+}
+
+// tokenAllowOperation checks if the operation of a request is allowed by
+// the ACL of a token. The path of the request is checked relative to the
+// namespace of the token, and requests outside of it are denied.
+func (c *Core) tokenAllowOperation(acl *ACL, te *TokenEntry, req *logical.Request) (bool, bool, string) {
+	nsPath, ok := c.tokenNamespacePath(te, req.Path)
+	if !ok {
+		return false, false, ""
+	}
+
+	path := req.Path
+	req.Path = nsPath
+	defer func() {
+		req.Path = path
+	}()
+	return acl.AllowOperation(req)
+}
+
 func (c *Core) checkToken(req *logical.Request) (*logical.Auth, *TokenEntry, error) {
 	defer metrics.MeasureSince([]string{"core", "check_token"}, time.Now())
 
@@ -456,8 +594,11 @@ func (c *Core) checkToken(req *logical.Request) (*logical.Auth, *TokenEntry, err
 
 	// Check the standard non-root ACLs. Return the token entry if it's not
 	// allowed so we can decrement the use count.
-	allowed, rootPrivs := acl.AllowOperation(req.Operation, req.Path)
+	allowed, rootPrivs, reason := c.tokenAllowOperation(acl, te, req)
 	if !allowed {
+		if reason != "" {
+			return nil, te, errwrap.Wrap(fmt.Errorf("%v: %s", logical.ErrPermissionDenied, reason), logical.ErrPermissionDenied)
+		}
 		return nil, te, logical.ErrPermissionDenied
 	}
 	if rootPath && !rootPrivs {
@@ -470,6 +611,7 @@ func (c *Core) checkToken(req *logical.Request) (*logical.Auth, *TokenEntry, err
 		Policies:    te.Policies,
 		Metadata:    te.Meta,
 		DisplayName: te.DisplayName,
+		EntityID:    te.EntityID,
 	}
 	return auth, te, nil
 }
@@ -488,52 +630,58 @@ func (c *Core) Standby() (bool, error) {
 	return c.standby, nil
 }
 
-// Leader is used to get the current active leader
-func (c *Core) Leader() (isLeader bool, leaderAddr string, err error) {
+// Leader is used to get the current active leader. On a standby, this also
+// sets up the connection used to forward requests to the leader.
+func (c *Core) Leader() (isLeader bool, leaderAddr string, clusterAddr string, err error) {
 	c.stateLock.RLock()
 	defer c.stateLock.RUnlock()
 	// Check if HA enabled
 	if c.ha == nil {
-		return false, "", ErrHANotEnabled
+		return false, "", "", ErrHANotEnabled
 	}
 
 	// Check if sealed
 	if c.sealed {
-		return false, "", ErrSealed
+		return false, "", "", ErrSealed
 	}
 
 	// Check if we are the leader
 	if !c.standby {
-		return true, c.advertiseAddr, nil
+		return true, c.advertiseAddr, c.clusterAddr, nil
 	}
 
 	// Initialize a lock
 	lock, err := c.ha.LockWith(coreLockPath, "read")
 	if err != nil {
-		return false, "", err
+		return false, "", "", err
 	}
 
 	// Read the value
 	held, value, err := lock.Value()
 	if err != nil {
-		return false, "", err
+		return false, "", "", err
 	}
 	if !held {
-		return false, "", nil
+		return false, "", "", nil
 	}
 
 	// Value is the UUID of the leader, fetch the key
 	key := coreLeaderPrefix + value
 	entry, err := c.barrier.Get(key)
 	if err != nil {
-		return false, "", err
+		return false, "", "", err
 	}
 	if entry == nil {
-		return false, "", nil
+		return false, "", "", nil
+	}
+
+	// Leader addresses are in the entry
+	adv := parseActiveAdvertisement(entry.Value)
+	if err := c.refreshRequestForwardingConnection(adv); err != nil {
+		c.logger.Printf("[ERR] core: failed to set up request forwarding connection: %v", err)
 	}
 
-	// Leader address is in the entry
-	return false, string(entry.Value), nil
+	return false, adv.AdvertiseAddr, adv.ClusterAddr, nil
 }
 
 // SecretProgress returns the number of keys provided so far
@@ -572,6 +720,20 @@ func (c *Core) Unseal(key []byte) (bool, error) {
 		return false, &ErrInvalidKey{fmt.Sprintf("key is longer than maximum %d bytes", max)}
 	}
 
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	// Check if already unsealed
+	if !c.sealed {
+		return true, nil
+	}
+
+	// The keys must be provided through UnsealMigrate until a pending seal
+	// migration is completed
+	if c.migrationSeal != nil {
+		return false, ErrSealMigrationPending
+	}
+
 	// Get the seal configuration
 	config, err := c.seal.BarrierConfig()
 	if err != nil {
@@ -583,14 +745,6 @@ func (c *Core) Unseal(key []byte) (bool, error) {
 		return false, ErrNotInit
 	}
 
-	c.stateLock.Lock()
-	defer c.stateLock.Unlock()
-
-	// Check if already unsealed
-	if !c.sealed {
-		return true, nil
-	}
-
 	// Check if we already have this piece
 	for _, existing := range c.unlockParts {
 		if bytes.Equal(existing, key) {
@@ -628,6 +782,26 @@ func (c *Core) Unseal(key []byte) (bool, error) {
 	}
 	c.logger.Printf("[INFO] core: vault is unsealed")
 
+	return c.unsealInternal()
+}
+
+// unsealInternal finishes unsealing once the barrier is unsealed. The state
+// lock must be held.
+func (c *Core) unsealInternal() (bool, error) {
+	// The cluster certificate can now be read, so the nodes of the HA
+	// backend can communicate
+	if err := c.setupClusteredBackend(); err != nil {
+		c.logger.Printf("[ERR] core: cluster setup failed: %v", err)
+		c.barrier.Seal()
+		c.logger.Printf("[WARN] core: vault is sealed")
+		return false, err
+	}
+
+	// Remove the leftovers of a seal migration away from an auto seal
+	if !c.seal.StoredKeysSupported() {
+		c.cleanupSealMigration()
+	}
+
 	// Do post-unseal setup if HA is not enabled
 	if c.ha == nil {
 		if err := c.postUnseal(); err != nil {
@@ -728,6 +902,7 @@ func (c *Core) sealInitCommon(req *logical.Request) (retErr error) {
 		Policies:    te.Policies,
 		Metadata:    te.Meta,
 		DisplayName: te.DisplayName,
+		EntityID:    te.EntityID,
 	}
 
 	if err := c.auditBroker.LogRequest(auth, req, nil); err != nil {
@@ -764,7 +939,7 @@ func (c *Core) sealInitCommon(req *logical.Request) (retErr error) {
 	}
 
 	// Verify that this operation is allowed
-	allowed, rootPrivs := acl.AllowOperation(req.Operation, req.Path)
+	allowed, rootPrivs, _ := c.tokenAllowOperation(acl, te, req)
 	if !allowed {
 		retErr = multierror.Append(retErr, logical.ErrPermissionDenied)
 		return retErr
@@ -815,6 +990,7 @@ func (c *Core) StepDown(req *logical.Request) (retErr error) {
 		Policies:    te.Policies,
 		Metadata:    te.Meta,
 		DisplayName: te.DisplayName,
+		EntityID:    te.EntityID,
 	}
 
 	if err := c.auditBroker.LogRequest(auth, req, nil); err != nil {
@@ -850,7 +1026,7 @@ func (c *Core) StepDown(req *logical.Request) (retErr error) {
 	}
 
 	// Verify that this operation is allowed
-	allowed, rootPrivs := acl.AllowOperation(req.Operation, req.Path)
+	allowed, rootPrivs, _ := c.tokenAllowOperation(acl, te, req)
 	if !allowed {
 		retErr = multierror.Append(retErr, logical.ErrPermissionDenied)
 		return retErr
@@ -891,6 +1067,11 @@ func (c *Core) sealInternal() error {
 		c.stateLock.Unlock()
 		<-c.standbyDoneCh
 		c.stateLock.Lock()
+
+		// A sealed node must not take part in the cluster of the HA backend
+		if err := c.teardownClusteredBackend(); err != nil {
+			c.logger.Printf("[ERR] core: cluster teardown failed: %v", err)
+		}
 	}
 
 	if err := c.barrier.Seal(); err != nil {
@@ -940,6 +1121,9 @@ func (c *Core) postUnseal() (retErr error) {
 			return err
 		}
 	}
+	if err := c.loadNamespaces(); err != nil {
+		return err
+	}
 	if err := c.loadMounts(); err != nil {
 		return err
 	}
@@ -1008,6 +1192,9 @@ func (c *Core) preSeal() error {
 	if err := c.unloadMounts(); err != nil {
 		result = multierror.Append(result, errwrap.Wrapf("[ERR] error unloading mounts: {{err}}", err))
 	}
+	if err := c.teardownNamespaces(); err != nil {
+		result = multierror.Append(result, errwrap.Wrapf("[ERR] error tearing down namespaces: {{err}}", err))
+	}
 	if cache, ok := c.physical.(*physical.Cache); ok {
 		cache.Purge()
 	}
@@ -1021,6 +1208,7 @@ func (c *Core) preSeal() error {
 func (c *Core) runStandby(doneCh, stopCh, manualStepDownCh chan struct{}) {
 	defer close(doneCh)
 	defer close(manualStepDownCh)
+	defer c.resetRequestForwardingConnection()
 	c.logger.Printf("[INFO] core: entering standby mode")
 
 	// Monitor for key rotation
@@ -1061,6 +1249,20 @@ func (c *Core) runStandby(doneCh, stopCh, manualStepDownCh chan struct{}) {
 		}
 		c.logger.Printf("[INFO] core: acquired lock, enabling active operation")
 
+		// Requests are no longer forwarded once we are active
+		c.resetRequestForwardingConnection()
+
+		// Load the certificate for the cluster port, which is part of the
+		// advertisement
+		c.stateLock.Lock()
+		err = c.setupCluster()
+		c.stateLock.Unlock()
+		if err != nil {
+			c.logger.Printf("[ERR] core: cluster setup failed: %v", err)
+			lock.Unlock()
+			continue
+		}
+
 		// Advertise ourself as leader
 		if err := c.advertiseLeader(uuid, leaderLostCh); err != nil {
 			c.logger.Printf("[ERR] core: leader advertisement setup failed: %v", err)
@@ -1073,6 +1275,12 @@ func (c *Core) runStandby(doneCh, stopCh, manualStepDownCh chan struct{}) {
 		err = c.postUnseal()
 		if err == nil {
 			c.standby = false
+			if err = c.startClusterListener(); err != nil {
+				// Request forwarding is not required to serve requests, so
+				// this is not fatal
+				c.logger.Printf("[ERR] core: %v", err)
+				err = nil
+			}
 		}
 		c.stateLock.Unlock()
 
@@ -1103,6 +1311,7 @@ func (c *Core) runStandby(doneCh, stopCh, manualStepDownCh chan struct{}) {
 		// Attempt the pre-seal process
 		c.stateLock.Lock()
 		c.standby = true
+		c.stopClusterListener()
 		preSealErr := c.preSeal()
 		c.stateLock.Unlock()
 
@@ -1212,12 +1421,15 @@ func (c *Core) acquireLock(lock physical.Lock, stopCh <-chan struct{}) <-chan st
 // advertiseLeader is used to advertise the current node as leader
 func (c *Core) advertiseLeader(uuid string, leaderLostCh <-chan struct{}) error {
 	go c.cleanLeaderPrefix(uuid, leaderLostCh)
+	value, err := c.activeAdvertisementValue()
+	if err != nil {
+		return err
+	}
 	ent := &Entry{
 		Key:   coreLeaderPrefix + uuid,
-		Value: []byte(c.advertiseAddr),
+		Value: value,
 	}
-	err := c.barrier.Put(ent)
-	if err != nil {
+	if err := c.barrier.Put(ent); err != nil {
 		return err
 	}
 
@@ -1282,6 +1494,9 @@ func (c *Core) emitMetrics(stopCh chan struct{}) {
 }
 
 func (c *Core) SealAccess() *SealAccess {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+
 	sa := &SealAccess{}
 	sa.SetSeal(c.seal)
 	return sa
@@ -13,6 +13,7 @@ import (
 	"github.com/armon/go-metrics"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
 )
 
 const (
@@ -55,6 +56,29 @@ type ExpirationManager struct {
 
 	pending     map[string]*time.Timer
 	pendingLock sync.Mutex
+
+	// stopped is set by Stop, after which no revocation is scheduled. It is
+	// guarded by pendingLock.
+	stopped bool
+
+	// revokeAttempts holds the number of failed revocation attempts of the
+	// expired leases waiting for a retry, and irrevocable the last error of
+	// the leases that could not be revoked within maxRevokeAttempts. Both
+	// are guarded by pendingLock.
+	revokeAttempts map[string]uint
+	irrevocable    map[string]string
+
+	// restoreLoaded records the leases restored while Restore runs in the
+	// background, either by the restore or on demand. It is nil once the
+	// restore is over.
+	restoreLoaded map[string]struct{}
+	restoreTotal  int
+	restoreCount  int
+	restoreQuitCh chan struct{}
+	restoreDoneCh chan struct{}
+	restoreLock   sync.Mutex
+
+	tidy tidyStatus
 }
 
 // NewExpirationManager creates a new ExpirationManager that is backed
@@ -70,6 +94,11 @@ func NewExpirationManager(router *Router, view *BarrierView, ts *TokenStore, log
 		tokenStore: ts,
 		logger:     logger,
 		pending:    make(map[string]*time.Timer),
+
+		revokeAttempts: make(map[string]uint),
+		irrevocable:    make(map[string]string),
+
+		tidy: tidyStatus{name: "expire"},
 	}
 	return exp
 }
@@ -89,8 +118,15 @@ func (c *Core) setupExpiration() error {
 	// Link the token store to this
 	c.tokenStore.SetExpirationManager(mgr)
 
-	// Restore the existing state
-	if err := c.expiration.Restore(); err != nil {
+	// Restore the existing state. If the leases fail to load, the vault is
+	// sealed as their revocation can no longer be guaranteed.
+	errorFunc := func() {
+		c.logger.Printf("[ERR] core: shutting down after the expiration state restore failed")
+		if err := c.Shutdown(); err != nil {
+			c.logger.Printf("[ERR] core: failed to shut down: %v", err)
+		}
+	}
+	if err := c.expiration.Restore(errorFunc); err != nil {
 		return fmt.Errorf("expiration state restore failed: %v", err)
 	}
 	return nil
@@ -111,61 +147,179 @@ func (c *Core) stopExpiration() error {
 }
 
 // Restore is used to recover the lease states when starting.
-// This is used after starting the vault.
-func (m *ExpirationManager) Restore() error {
-	m.pendingLock.Lock()
-	defer m.pendingLock.Unlock()
-
+// This is used after starting the vault. The leases are listed before
+// returning, then loaded in parallel in the background so that requests
+// can be served meanwhile. Until the restore is over, a lease that has not
+// been restored yet is restored when it is loaded, such as on renewal or
+// revocation. If loading the leases fails, errorFunc is invoked.
+func (m *ExpirationManager) Restore(errorFunc func()) error {
 	// Accumulate existing leases
 	existing, err := CollectKeys(m.idView)
 	if err != nil {
 		return fmt.Errorf("failed to scan for leases: %v", err)
 	}
 
-	// Restore each key
+	quitCh, doneCh := m.beginRestore(len(existing))
+	go m.restore(existing, quitCh, doneCh, errorFunc)
+	return nil
+}
+
+// beginRestore enables the on demand restore of the leases
+func (m *ExpirationManager) beginRestore(total int) (chan struct{}, chan struct{}) {
+	m.restoreLock.Lock()
+	defer m.restoreLock.Unlock()
+
+	m.restoreLoaded = make(map[string]struct{})
+	m.restoreTotal = total
+	m.restoreCount = 0
+	m.restoreQuitCh = make(chan struct{})
+	m.restoreDoneCh = make(chan struct{})
+	return m.restoreQuitCh, m.restoreDoneCh
+}
+
+// restore loads the given leases with parallel workers and sets up their
+// revocation timers, until all are loaded or quitCh is closed
+func (m *ExpirationManager) restore(existing []string, quitCh, doneCh chan struct{}, errorFunc func()) {
+	defer metrics.MeasureSince([]string{"expire", "restore"}, time.Now())
+
+	keyCh := make(chan string)
+	errCh := make(chan error, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < physical.DefaultParallelOperations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for leaseID := range keyCh {
+				le, err := m.loadEntryInternal(leaseID)
+				if err != nil {
+					select {
+					case errCh <- err:
+					default:
+					}
+					continue
+				}
+
+				// If there is no entry, nothing to restore
+				if le != nil {
+					m.restoreEntry(le)
+				}
+
+				m.restoreLock.Lock()
+				m.restoreCount++
+				m.restoreLock.Unlock()
+			}
+		}()
+	}
+
+	var err error
+	var quit bool
+LOOP:
 	for _, leaseID := range existing {
-		// Load the entry
-		le, err := m.loadEntry(leaseID)
-		if err != nil {
-			return err
+		select {
+		case keyCh <- leaseID:
+		case err = <-errCh:
+			break LOOP
+		case <-quitCh:
+			quit = true
+			break LOOP
 		}
-
-		// If there is no entry, nothing to restore
-		if le == nil {
-			continue
+	}
+	close(keyCh)
+	wg.Wait()
+	if err == nil && !quit {
+		select {
+		case err = <-errCh:
+		default:
 		}
+	}
 
-		// If there is no expiry time, don't do anything
-		if le.ExpireTime.IsZero() {
-			continue
-		}
+	// End the on demand restore
+	m.restoreLock.Lock()
+	count := len(m.restoreLoaded)
+	m.restoreLoaded = nil
+	m.restoreLock.Unlock()
+	close(doneCh)
 
-		// Determine the remaining time to expiration
-		expires := le.ExpireTime.Sub(time.Now().UTC())
-		if expires <= 0 {
-			expires = minRevokeDelay
+	switch {
+	case quit:
+	case err != nil:
+		m.logger.Printf("[ERR] expire: failed to restore leases: %v", err)
+		if errorFunc != nil {
+			errorFunc()
 		}
+	case count > 0:
+		m.logger.Printf("[INFO] expire: restored %d leases", count)
+	}
+}
 
-		// Setup revocation timer
-		m.pending[le.LeaseID] = time.AfterFunc(expires, func() {
-			m.expireID(le.LeaseID)
-		})
+// restoreEntry sets up the revocation timer of a lease loaded while the
+// leases are restored, unless the lease has already been restored
+func (m *ExpirationManager) restoreEntry(le *leaseEntry) {
+	m.restoreLock.Lock()
+	defer m.restoreLock.Unlock()
+	if m.restoreLoaded == nil {
+		return
+	}
+	if _, ok := m.restoreLoaded[le.LeaseID]; ok {
+		return
 	}
-	if len(m.pending) > 0 {
-		m.logger.Printf("[INFO] expire: restored %d leases", len(m.pending))
+	m.restoreLoaded[le.LeaseID] = struct{}{}
+
+	// An irrevocable lease is not revoked again automatically
+	if le.RevokeErr != "" {
+		m.pendingLock.Lock()
+		m.irrevocable[le.LeaseID] = le.RevokeErr
+		m.pendingLock.Unlock()
+		return
 	}
-	return nil
+
+	// If there is no expiry time, don't do anything
+	if le.ExpireTime.IsZero() {
+		return
+	}
+
+	// Determine the remaining time to expiration
+	expires := le.ExpireTime.Sub(time.Now().UTC())
+	if expires <= 0 {
+		expires = minRevokeDelay
+	}
+
+	// Setup revocation timer
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+	if _, ok := m.pending[le.LeaseID]; ok {
+		return
+	}
+	leaseID := le.LeaseID
+	m.pending[leaseID] = time.AfterFunc(expires, func() {
+		m.expireID(leaseID)
+	})
 }
 
 // Stop is used to prevent further automatic revocations.
 // This must be called before sealing the view.
 func (m *ExpirationManager) Stop() error {
-	// Stop all the pending expiration timers
+	// Stop a running restore, and wait for its workers to finish so that
+	// no timer is set up afterwards
+	m.restoreLock.Lock()
+	quitCh, doneCh := m.restoreQuitCh, m.restoreDoneCh
+	m.restoreQuitCh, m.restoreDoneCh = nil, nil
+	m.restoreLock.Unlock()
+	if quitCh != nil {
+		close(quitCh)
+		<-doneCh
+	}
+
+	// Stop all the pending expiration timers, and prevent the revocations
+	// in progress from scheduling retries
 	m.pendingLock.Lock()
+	m.stopped = true
 	for _, timer := range m.pending {
 		timer.Stop()
 	}
 	m.pending = make(map[string]*time.Timer)
+	m.revokeAttempts = make(map[string]uint)
+	m.irrevocable = make(map[string]string)
 	m.pendingLock.Unlock()
 	return nil
 }
@@ -219,6 +373,8 @@ func (m *ExpirationManager) revokeCommon(leaseID string, force, skipToken bool)
 		timer.Stop()
 		delete(m.pending, leaseID)
 	}
+	delete(m.revokeAttempts, leaseID)
+	delete(m.irrevocable, leaseID)
 	m.pendingLock.Unlock()
 	return nil
 }
@@ -509,6 +665,7 @@ func (m *ExpirationManager) FetchLeaseTimes(leaseID string) (*leaseEntry, error)
 		IssueTime:       le.IssueTime,
 		ExpireTime:      le.ExpireTime,
 		LastRenewalTime: le.LastRenewalTime,
+		RevokeErr:       le.RevokeErr,
 	}
 	if le.Secret != nil {
 		ret.Secret = &logical.Secret{}
@@ -524,6 +681,23 @@ func (m *ExpirationManager) FetchLeaseTimes(leaseID string) (*leaseEntry, error)
 	return ret, nil
 }
 
+// ListLeaseIDs returns the lease IDs directly under the given prefix, along
+// with the nested prefixes, which end with a slash
+func (m *ExpirationManager) ListLeaseIDs(prefix string) ([]string, error) {
+	defer metrics.MeasureSince([]string{"expire", "list-lease-ids"}, time.Now())
+
+	// Ensure there is a trailing slash
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	keys, err := m.idView.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list leases: %v", err)
+	}
+	return keys, nil
+}
+
 // updatePending is used to update a pending invocation for a lease
 func (m *ExpirationManager) updatePending(le *leaseEntry, leaseTotal time.Duration) {
 	m.pendingLock.Lock()
@@ -554,23 +728,95 @@ func (m *ExpirationManager) updatePending(le *leaseEntry, leaseTotal time.Durati
 	}
 }
 
-// expireID is invoked when a given ID is expired
+// expireID is invoked when a given ID is expired. A failed revocation is
+// retried with an exponential backoff, up to maxRevokeAttempts, after which
+// the lease is marked irrevocable.
 func (m *ExpirationManager) expireID(leaseID string) {
 	// Clear from the pending expiration
 	m.pendingLock.Lock()
 	delete(m.pending, leaseID)
+	attempts := m.revokeAttempts[leaseID]
+	m.pendingLock.Unlock()
+
+	err := m.Revoke(leaseID)
+	if err == nil {
+		m.logger.Printf("[INFO] expire: revoked '%s'", leaseID)
+		return
+	}
+	attempts++
+	m.logger.Printf("[ERR] expire: failed to revoke '%s' (attempt %d of %d): %v",
+		leaseID, attempts, maxRevokeAttempts, err)
+
+	// Nothing is scheduled or recorded once stopped, as the view may be
+	// sealed
+	m.pendingLock.Lock()
+	if m.stopped {
+		m.pendingLock.Unlock()
+		return
+	}
+	if attempts < maxRevokeAttempts {
+		m.revokeAttempts[leaseID] = attempts
+		m.pending[leaseID] = time.AfterFunc((1<<(attempts-1))*revokeRetryBase, func() {
+			m.expireID(leaseID)
+		})
+		m.pendingLock.Unlock()
+		return
+	}
+	m.pendingLock.Unlock()
+
+	m.logger.Printf("[ERR] expire: maximum revoke attempts for '%s' reached, marking the lease irrevocable", leaseID)
+	if err := m.markIrrevocable(leaseID, err); err != nil {
+		m.logger.Printf("[ERR] expire: failed to mark '%s' irrevocable: %v", leaseID, err)
+	}
+}
+
+// markIrrevocable records the last revocation error of a lease that could
+// not be revoked, so that it is no longer revoked automatically
+func (m *ExpirationManager) markIrrevocable(leaseID string, revokeErr error) error {
+	m.pendingLock.Lock()
+	delete(m.revokeAttempts, leaseID)
 	m.pendingLock.Unlock()
 
-	for attempt := uint(0); attempt < maxRevokeAttempts; attempt++ {
-		err := m.Revoke(leaseID)
-		if err == nil {
-			m.logger.Printf("[INFO] expire: revoked '%s'", leaseID)
-			return
+	le, err := m.loadEntryInternal(leaseID)
+	if err != nil {
+		return err
+	}
+
+	// If there is no entry, it has been revoked meanwhile
+	if le == nil {
+		return nil
+	}
+
+	le.RevokeErr = revokeErr.Error()
+	if err := m.persistEntry(le); err != nil {
+		return err
+	}
+
+	m.pendingLock.Lock()
+	m.irrevocable[leaseID] = le.RevokeErr
+	m.pendingLock.Unlock()
+	metrics.IncrCounter([]string{"expire", "irrevocable"}, 1)
+	return nil
+}
+
+// IrrevocableLeases returns the last revocation error of the irrevocable
+// leases among the keys listed under a prefix by ListLeaseIDs
+func (m *ExpirationManager) IrrevocableLeases(prefix string, keys []string) map[string]string {
+	// Ensure there is a trailing slash
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	out := make(map[string]string)
+	for _, key := range keys {
+		if revokeErr, ok := m.irrevocable[prefix+key]; ok {
+			out[key] = revokeErr
 		}
-		m.logger.Printf("[ERR] expire: failed to revoke '%s': %v", leaseID, err)
-		time.Sleep((1 << attempt) * revokeRetryBase)
 	}
-	m.logger.Printf("[ERR] expire: maximum revoke attempts for '%s' reached", leaseID)
+	return out
 }
 
 // revokeEntry is used to attempt revocation of an internal entry
@@ -630,8 +876,18 @@ func (m *ExpirationManager) renewAuthEntry(req *logical.Request, le *leaseEntry,
 	return resp, nil
 }
 
-// loadEntry is used to read a lease entry
+// loadEntry is used to read a lease entry. While the leases are restored,
+// the lease is restored if it has not been yet.
 func (m *ExpirationManager) loadEntry(leaseID string) (*leaseEntry, error) {
+	le, err := m.loadEntryInternal(leaseID)
+	if le != nil {
+		m.restoreEntry(le)
+	}
+	return le, err
+}
+
+// loadEntryInternal reads a lease entry from storage
+func (m *ExpirationManager) loadEntryInternal(leaseID string) (*leaseEntry, error) {
 	out, err := m.idView.Get(leaseID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read lease entry: %v", err)
@@ -728,12 +984,108 @@ func (m *ExpirationManager) lookupByToken(token string) ([]string, error) {
 	return leaseIDs, nil
 }
 
+// Tidy revokes the leases whose token no longer exists, then removes the
+// token index entries pointing at leases that no longer exist. These are
+// left behind when a revocation fails halfway. It can run while leases are
+// created and revoked: entries are only removed once they point at nothing,
+// which a concurrent operation cannot undo.
+func (m *ExpirationManager) Tidy() (map[string]int, error) {
+	defer metrics.MeasureSince([]string{"expire", "tidy"}, time.Now())
+
+	counts := map[string]int{
+		"leases_checked":        0,
+		"leases_revoked":        0,
+		"token_indexes_checked": 0,
+		"token_indexes_removed": 0,
+	}
+
+	existing, err := CollectKeys(m.idView)
+	if err != nil {
+		return counts, fmt.Errorf("failed to scan for leases: %v", err)
+	}
+	for _, leaseID := range existing {
+		le, err := m.loadEntry(leaseID)
+		if err != nil {
+			return counts, err
+		}
+		if le == nil || le.ClientToken == "" {
+			continue
+		}
+		counts["leases_checked"]++
+
+		exists, err := m.tokenStore.tokenExistsSalted(m.tokenStore.SaltID(le.ClientToken))
+		if err != nil {
+			return counts, err
+		}
+		if exists {
+			continue
+		}
+
+		// The token is gone, so the lease should have been revoked with it
+		if err := m.Revoke(leaseID); err != nil {
+			m.logger.Printf("[WARN] expire: tidy failed to revoke '%s': %v", leaseID, err)
+			continue
+		}
+		counts["leases_revoked"]++
+	}
+
+	tokens, err := m.tokenView.List("")
+	if err != nil {
+		return counts, fmt.Errorf("failed to scan for lease indexes: %v", err)
+	}
+	for _, token := range tokens {
+		subKeys, err := m.tokenView.List(token)
+		if err != nil {
+			return counts, fmt.Errorf("failed to list lease indexes: %v", err)
+		}
+		for _, sub := range subKeys {
+			counts["token_indexes_checked"]++
+			key := token + sub
+			out, err := m.tokenView.Get(key)
+			if err != nil {
+				return counts, fmt.Errorf("failed to read lease index: %v", err)
+			}
+			if out == nil {
+				continue
+			}
+
+			le, err := m.idView.Get(string(out.Value))
+			if err != nil {
+				return counts, fmt.Errorf("failed to read lease entry: %v", err)
+			}
+			if le != nil {
+				continue
+			}
+			if err := m.tokenView.Delete(key); err != nil {
+				return counts, fmt.Errorf("failed to delete lease index entry: %v", err)
+			}
+			counts["token_indexes_removed"]++
+		}
+	}
+
+	return counts, nil
+}
+
 // emitMetrics is invoked periodically to emit statistics
 func (m *ExpirationManager) emitMetrics() {
 	m.pendingLock.Lock()
 	num := len(m.pending)
+	retries := len(m.revokeAttempts)
+	irrevocable := len(m.irrevocable)
 	m.pendingLock.Unlock()
 	metrics.SetGauge([]string{"expire", "num_leases"}, float32(num))
+	metrics.SetGauge([]string{"expire", "num_revoke_retries"}, float32(retries))
+	metrics.SetGauge([]string{"expire", "num_irrevocable_leases"}, float32(irrevocable))
+
+	// Report the progress of a running restore
+	m.restoreLock.Lock()
+	restoring := m.restoreLoaded != nil
+	total, count := m.restoreTotal, m.restoreCount
+	m.restoreLock.Unlock()
+	if restoring {
+		metrics.SetGauge([]string{"expire", "restore", "total"}, float32(total))
+		metrics.SetGauge([]string{"expire", "restore", "loaded"}, float32(count))
+	}
 }
 
 // leaseEntry is used to structure the values the expiration
@@ -748,6 +1100,9 @@ type leaseEntry struct {
 	IssueTime       time.Time              `json:"issue_time"`
 	ExpireTime      time.Time              `json:"expire_time"`
 	LastRenewalTime time.Time              `json:"last_renewal_time"`
+
+	// RevokeErr is the last revocation error of an irrevocable lease
+	RevokeErr string `json:"revoke_err,omitempty"`
 }
 
 // encode is used to JSON encode the lease entry
@@ -64,7 +64,7 @@ func TestExpiration_Restore(t *testing.T) {
 	}
 
 	// Restore
-	err = exp.Restore()
+	err = exp.Restore(nil)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -89,6 +89,87 @@ func TestExpiration_Restore(t *testing.T) {
 	}
 }
 
+func TestExpiration_RestoreOnDemand(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	meUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp.router.Mount(noop, "prod/aws/", &MountEntry{UUID: meUUID}, view)
+
+	var leaseIDs []string
+	for _, path := range []string{"prod/aws/foo", "prod/aws/bar", "prod/aws/zip"} {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+		}
+		resp := &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Hour,
+				},
+			},
+			Data: map[string]interface{}{
+				"access_key": "xyz",
+				"secret_key": "abcd",
+			},
+		}
+		leaseID, err := exp.Register(req, resp)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		leaseIDs = append(leaseIDs, leaseID)
+	}
+
+	if err := exp.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Start a restore without loading any lease
+	quitCh, doneCh := exp.beginRestore(len(leaseIDs))
+
+	// Loading a lease restores its timer
+	if _, err := exp.FetchLeaseTimes(leaseIDs[0]); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp.pendingLock.Lock()
+	_, ok := exp.pending[leaseIDs[0]]
+	exp.pendingLock.Unlock()
+	if !ok {
+		t.Fatalf("lease not restored")
+	}
+
+	// A lease revoked before being restored is not restored afterwards
+	if err := exp.Revoke(leaseIDs[1]); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	exp.restore(leaseIDs, quitCh, doneCh, func() {
+		t.Fatalf("restore failed")
+	})
+	<-doneCh
+
+	exp.pendingLock.Lock()
+	defer exp.pendingLock.Unlock()
+	if len(exp.pending) != 2 {
+		t.Fatalf("bad: %#v", exp.pending)
+	}
+	for _, leaseID := range []string{leaseIDs[0], leaseIDs[2]} {
+		if _, ok := exp.pending[leaseID]; !ok {
+			t.Fatalf("lease %s not restored", leaseID)
+		}
+	}
+
+	exp.restoreLock.Lock()
+	defer exp.restoreLock.Unlock()
+	if exp.restoreLoaded != nil || exp.restoreCount != 3 {
+		t.Fatalf("bad: %v %d", exp.restoreLoaded, exp.restoreCount)
+	}
+}
+
 func TestExpiration_Register(t *testing.T) {
 	exp := mockExpiration(t)
 	req := &logical.Request{
@@ -335,6 +416,153 @@ func TestExpiration_RevokePrefix(t *testing.T) {
 	}
 }
 
+func TestExpiration_RevokeOnExpire_Irrevocable(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	meUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp.router.Mount(noop, "prod/aws/", &MountEntry{UUID: meUUID}, view)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "prod/aws/foo",
+	}
+	resp := &logical.Response{
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				TTL: time.Hour,
+			},
+		},
+		Data: map[string]interface{}{
+			"access_key": "xyz",
+			"secret_key": "abcd",
+		},
+	}
+	id, err := exp.Register(req, resp)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The backend fails to revoke
+	noop.Lock()
+	noop.Response = logical.ErrorResponse("backend down")
+	noop.Unlock()
+
+	for attempt := uint(1); attempt <= maxRevokeAttempts; attempt++ {
+		exp.pendingLock.Lock()
+		if timer, ok := exp.pending[id]; ok {
+			timer.Stop()
+		}
+		exp.pendingLock.Unlock()
+
+		exp.expireID(id)
+
+		exp.pendingLock.Lock()
+		_, retry := exp.pending[id]
+		attempts := exp.revokeAttempts[id]
+		exp.pendingLock.Unlock()
+		if attempt < maxRevokeAttempts && (!retry || attempts != attempt) {
+			t.Fatalf("bad: attempt %d: %v %d", attempt, retry, attempts)
+		}
+		if attempt == maxRevokeAttempts && (retry || attempts != 0) {
+			t.Fatalf("bad: %v %d", retry, attempts)
+		}
+	}
+
+	// The lease is irrevocable, with the last error recorded
+	le, err := exp.loadEntry(id)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if le == nil || !strings.Contains(le.RevokeErr, "backend down") {
+		t.Fatalf("bad: %#v", le)
+	}
+	if irrevocable := exp.IrrevocableLeases("prod/aws/foo", []string{strings.TrimPrefix(id, "prod/aws/foo/")}); len(irrevocable) != 1 {
+		t.Fatalf("bad: %#v", irrevocable)
+	}
+
+	// It is not revoked automatically after a restore
+	if err := exp.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	quitCh, doneCh := exp.beginRestore(1)
+	exp.restore([]string{id}, quitCh, doneCh, nil)
+	exp.pendingLock.Lock()
+	_, pending := exp.pending[id]
+	_, irrevocable := exp.irrevocable[id]
+	exp.pendingLock.Unlock()
+	if pending || !irrevocable {
+		t.Fatalf("bad: %v %v", pending, irrevocable)
+	}
+
+	// It can still be revoked explicitly
+	noop.Lock()
+	noop.Response = nil
+	noop.Unlock()
+	if err := exp.Revoke(id); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp.pendingLock.Lock()
+	defer exp.pendingLock.Unlock()
+	if len(exp.irrevocable) != 0 {
+		t.Fatalf("bad: %#v", exp.irrevocable)
+	}
+}
+
+func TestExpiration_RevokeOnExpire_Stop(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	meUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp.router.Mount(noop, "prod/aws/", &MountEntry{UUID: meUUID}, view)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "prod/aws/foo",
+	}
+	resp := &logical.Response{
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				TTL: time.Hour,
+			},
+		},
+	}
+	id, err := exp.Register(req, resp)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The revocation is held by the backend while the manager is stopped,
+	// then fails
+	noop.Lock()
+	noop.Response = logical.ErrorResponse("backend down")
+	doneCh := make(chan struct{})
+	go func() {
+		exp.expireID(id)
+		close(doneCh)
+	}()
+	if err := exp.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	noop.Unlock()
+	<-doneCh
+
+	// No retry is scheduled
+	exp.pendingLock.Lock()
+	defer exp.pendingLock.Unlock()
+	if len(exp.pending) != 0 || len(exp.revokeAttempts) != 0 {
+		t.Fatalf("bad: %#v %#v", exp.pending, exp.revokeAttempts)
+	}
+}
+
 func TestExpiration_RevokeByToken(t *testing.T) {
 	exp := mockExpiration(t)
 	noop := &NoopBackend{}
@@ -1040,3 +1268,86 @@ func badRenewFactory(conf *logical.BackendConfig) (logical.Backend, error) {
 
 	return be.Setup(conf)
 }
+
+func TestExpiration_Tidy(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	meUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp.router.Mount(noop, "prod/aws/", &MountEntry{UUID: meUUID}, view)
+
+	root, err := exp.tokenStore.rootToken()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// One lease belongs to an existing token, the other to a missing one
+	var leaseIDs []string
+	for _, token := range []string{root.ID, "foobarbaz"} {
+		req := &logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        "prod/aws/foo",
+			ClientToken: token,
+		}
+		resp := &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Hour,
+				},
+			},
+		}
+		leaseID, err := exp.Register(req, resp)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		leaseIDs = append(leaseIDs, leaseID)
+	}
+
+	// A token index points at a missing lease
+	if err := exp.createIndexByToken(root.ID, "prod/aws/foo/missing"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	counts, err := exp.Tidy()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := map[string]int{
+		"leases_checked":        2,
+		"leases_revoked":        1,
+		"token_indexes_checked": 2,
+		"token_indexes_removed": 1,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("bad: %#v", counts)
+	}
+
+	if len(noop.Requests) != 1 || noop.Requests[0].Operation != logical.RevokeOperation {
+		t.Fatalf("bad: %#v", noop.Requests)
+	}
+	le, err := exp.loadEntry(leaseIDs[0])
+	if err != nil || le == nil {
+		t.Fatalf("bad: %#v %v", le, err)
+	}
+	le, err = exp.loadEntry(leaseIDs[1])
+	if err != nil || le != nil {
+		t.Fatalf("bad: %#v %v", le, err)
+	}
+	index, err := exp.indexByToken(root.ID, "prod/aws/foo/missing")
+	if err != nil || index != nil {
+		t.Fatalf("bad: %#v %v", index, err)
+	}
+
+	// Nothing is left to tidy
+	counts, err = exp.Tidy()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if counts["leases_revoked"] != 0 || counts["token_indexes_removed"] != 0 {
+		t.Fatalf("bad: %#v", counts)
+	}
+}
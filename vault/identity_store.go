@@ -0,0 +1,659 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/strutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	// identityEntityPrefix is the storage prefix of the identity entities
+	identityEntityPrefix = "entity/"
+
+	// identityGroupPrefix is the storage prefix of the identity groups
+	identityGroupPrefix = "group/"
+
+	// identityGroupTypeInternal is the type of the groups whose members are
+	// managed with the API
+	identityGroupTypeInternal = "internal"
+
+	// identityGroupTypeExternal is the type of the groups whose members are
+	// set on login, from the groups the credential backend reports
+	identityGroupTypeExternal = "external"
+)
+
+// Entity is the identity of a client. The tokens created by the logins of
+// its aliases are attached to it, and its policies apply to them.
+type Entity struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Policies       []string          `json:"policies"`
+	Metadata       map[string]string `json:"metadata"`
+	Aliases        []*Alias          `json:"aliases"`
+	CreationTime   time.Time         `json:"creation_time"`
+	LastUpdateTime time.Time         `json:"last_update_time"`
+}
+
+// Alias maps the name of a user or a group within a credential backend,
+// identified by its mount accessor, to an entity or an external group
+type Alias struct {
+	ID             string            `json:"id"`
+	CanonicalID    string            `json:"canonical_id"`
+	MountAccessor  string            `json:"mount_accessor"`
+	MountType      string            `json:"mount_type"`
+	MountPath      string            `json:"mount_path"`
+	Name           string            `json:"name"`
+	Metadata       map[string]string `json:"metadata"`
+	CreationTime   time.Time         `json:"creation_time"`
+	LastUpdateTime time.Time         `json:"last_update_time"`
+}
+
+// Group is a set of entities sharing policies. The members of an internal
+// group are managed with the API, while the members of an external group
+// are the entities whose logins report the group of its alias.
+type Group struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	Type            string            `json:"type"`
+	Policies        []string          `json:"policies"`
+	Metadata        map[string]string `json:"metadata"`
+	MemberEntityIDs []string          `json:"member_entity_ids"`
+	Alias           *Alias            `json:"alias"`
+	CreationTime    time.Time         `json:"creation_time"`
+	LastUpdateTime  time.Time         `json:"last_update_time"`
+}
+
+// aliasKey identifies an alias by its mount accessor and name
+type aliasKey struct {
+	mountAccessor string
+	name          string
+}
+
+// IdentityStore is the backend mounted at identity/ which manages the
+// identity entities, their aliases and the identity groups. They are kept
+// in memory, so that logins are mapped to entities and the policies of the
+// entities are resolved without reading the storage.
+type IdentityStore struct {
+	*framework.Backend
+
+	core   *Core
+	view   logical.Storage
+	logger *log.Logger
+
+	// lock guards the entities, groups and their indexes
+	lock            sync.RWMutex
+	entities        map[string]*Entity
+	entityNames     map[string]string
+	entityAliases   map[string]*Alias
+	entityAliasKeys map[aliasKey]*Alias
+	groups          map[string]*Group
+	groupNames      map[string]string
+	groupAliases    map[string]*Alias
+	groupAliasKeys  map[aliasKey]*Alias
+	entityGroups    map[string]map[string]struct{}
+}
+
+// NewIdentityStore creates the identity store backend, and loads the
+// entities and groups from its storage
+func NewIdentityStore(c *Core, config *logical.BackendConfig) (*IdentityStore, error) {
+	if config == nil {
+		return nil, fmt.Errorf("configuration passed into backend is nil")
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	i := &IdentityStore{
+		core:            c,
+		view:            config.StorageView,
+		logger:          logger,
+		entities:        make(map[string]*Entity),
+		entityNames:     make(map[string]string),
+		entityAliases:   make(map[string]*Alias),
+		entityAliasKeys: make(map[aliasKey]*Alias),
+		groups:          make(map[string]*Group),
+		groupNames:      make(map[string]string),
+		groupAliases:    make(map[string]*Alias),
+		groupAliasKeys:  make(map[aliasKey]*Alias),
+		entityGroups:    make(map[string]map[string]struct{}),
+	}
+
+	i.Backend = &framework.Backend{
+		Help:  strings.TrimSpace(identityHelp["identity"][0]),
+		Paths: append(i.entityPaths(), i.groupPaths()...),
+	}
+	i.Backend.Setup(config)
+
+	if err := i.load(); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// load reads the entities and groups from the storage
+func (i *IdentityStore) load() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	entityIDs, err := i.view.List(identityEntityPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list identity entities: %v", err)
+	}
+	for _, id := range entityIDs {
+		raw, err := i.view.Get(identityEntityPrefix + id)
+		if err != nil {
+			return fmt.Errorf("failed to read identity entity: %v", err)
+		}
+		if raw == nil {
+			continue
+		}
+		var entity Entity
+		if err := json.Unmarshal(raw.Value, &entity); err != nil {
+			return fmt.Errorf("failed to decode identity entity: %v", err)
+		}
+		i.indexEntity(&entity)
+	}
+
+	groupIDs, err := i.view.List(identityGroupPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list identity groups: %v", err)
+	}
+	for _, id := range groupIDs {
+		raw, err := i.view.Get(identityGroupPrefix + id)
+		if err != nil {
+			return fmt.Errorf("failed to read identity group: %v", err)
+		}
+		if raw == nil {
+			continue
+		}
+		var group Group
+		if err := json.Unmarshal(raw.Value, &group); err != nil {
+			return fmt.Errorf("failed to decode identity group: %v", err)
+		}
+		i.indexGroup(&group)
+	}
+
+	if len(i.entities) > 0 || len(i.groups) > 0 {
+		i.logger.Printf("[INFO] identity: loaded %d entities and %d groups",
+			len(i.entities), len(i.groups))
+	}
+	return nil
+}
+
+// indexEntity adds an entity to the in-memory indexes. The lock must be
+// held for writing.
+func (i *IdentityStore) indexEntity(entity *Entity) {
+	i.entities[entity.ID] = entity
+	i.entityNames[entity.Name] = entity.ID
+	for _, alias := range entity.Aliases {
+		i.entityAliases[alias.ID] = alias
+		i.entityAliasKeys[aliasKey{alias.MountAccessor, alias.Name}] = alias
+	}
+}
+
+// unindexEntity removes an entity from the in-memory indexes. The lock
+// must be held for writing.
+func (i *IdentityStore) unindexEntity(entity *Entity) {
+	delete(i.entities, entity.ID)
+	delete(i.entityNames, entity.Name)
+	for _, alias := range entity.Aliases {
+		delete(i.entityAliases, alias.ID)
+		delete(i.entityAliasKeys, aliasKey{alias.MountAccessor, alias.Name})
+	}
+}
+
+// indexGroup adds a group to the in-memory indexes. The lock must be held
+// for writing.
+func (i *IdentityStore) indexGroup(group *Group) {
+	i.groups[group.ID] = group
+	i.groupNames[group.Name] = group.ID
+	if alias := group.Alias; alias != nil {
+		i.groupAliases[alias.ID] = alias
+		i.groupAliasKeys[aliasKey{alias.MountAccessor, alias.Name}] = alias
+	}
+	for _, entityID := range group.MemberEntityIDs {
+		if i.entityGroups[entityID] == nil {
+			i.entityGroups[entityID] = make(map[string]struct{})
+		}
+		i.entityGroups[entityID][group.ID] = struct{}{}
+	}
+}
+
+// unindexGroup removes a group from the in-memory indexes. The lock must
+// be held for writing.
+func (i *IdentityStore) unindexGroup(group *Group) {
+	delete(i.groups, group.ID)
+	delete(i.groupNames, group.Name)
+	if alias := group.Alias; alias != nil {
+		delete(i.groupAliases, alias.ID)
+		delete(i.groupAliasKeys, aliasKey{alias.MountAccessor, alias.Name})
+	}
+	for _, entityID := range group.MemberEntityIDs {
+		delete(i.entityGroups[entityID], group.ID)
+		if len(i.entityGroups[entityID]) == 0 {
+			delete(i.entityGroups, entityID)
+		}
+	}
+}
+
+// storeEntity persists an entity and replaces it in the in-memory indexes.
+// The lock must be held for writing.
+func (i *IdentityStore) storeEntity(entity *Entity) error {
+	buf, err := json.Marshal(entity)
+	if err != nil {
+		return fmt.Errorf("failed to encode identity entity: %v", err)
+	}
+	if err := i.view.Put(&logical.StorageEntry{
+		Key:   identityEntityPrefix + entity.ID,
+		Value: buf,
+	}); err != nil {
+		return fmt.Errorf("failed to persist identity entity: %v", err)
+	}
+
+	if old, ok := i.entities[entity.ID]; ok {
+		i.unindexEntity(old)
+	}
+	i.indexEntity(entity)
+	return nil
+}
+
+// storeGroup persists a group and replaces it in the in-memory indexes.
+// The lock must be held for writing.
+func (i *IdentityStore) storeGroup(group *Group) error {
+	buf, err := json.Marshal(group)
+	if err != nil {
+		return fmt.Errorf("failed to encode identity group: %v", err)
+	}
+	if err := i.view.Put(&logical.StorageEntry{
+		Key:   identityGroupPrefix + group.ID,
+		Value: buf,
+	}); err != nil {
+		return fmt.Errorf("failed to persist identity group: %v", err)
+	}
+
+	if old, ok := i.groups[group.ID]; ok {
+		i.unindexGroup(old)
+	}
+	i.indexGroup(group)
+	return nil
+}
+
+// deleteEntity removes an entity, and its membership of the groups. The
+// lock must be held for writing.
+func (i *IdentityStore) deleteEntity(entity *Entity) error {
+	for groupID := range i.entityGroups[entity.ID] {
+		group := i.groups[groupID].clone()
+		group.MemberEntityIDs = strutil.StrListDelete(group.MemberEntityIDs, entity.ID)
+		group.LastUpdateTime = time.Now().UTC()
+		if err := i.storeGroup(group); err != nil {
+			return err
+		}
+	}
+
+	if err := i.view.Delete(identityEntityPrefix + entity.ID); err != nil {
+		return fmt.Errorf("failed to delete identity entity: %v", err)
+	}
+	i.unindexEntity(entity)
+	return nil
+}
+
+// deleteGroup removes a group. The lock must be held for writing.
+func (i *IdentityStore) deleteGroup(group *Group) error {
+	if err := i.view.Delete(identityGroupPrefix + group.ID); err != nil {
+		return fmt.Errorf("failed to delete identity group: %v", err)
+	}
+	i.unindexGroup(group)
+	return nil
+}
+
+// deleteMountAliases removes the entity and group aliases of a credential
+// backend that is disabled
+func (i *IdentityStore) deleteMountAliases(mountAccessor string) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, alias := range i.entityAliases {
+		if alias.MountAccessor != mountAccessor {
+			continue
+		}
+		entity := i.entities[alias.CanonicalID].clone()
+		entity.Aliases = removeAlias(entity.Aliases, alias.ID)
+		entity.LastUpdateTime = time.Now().UTC()
+		if err := i.storeEntity(entity); err != nil {
+			return err
+		}
+	}
+
+	for _, alias := range i.groupAliases {
+		if alias.MountAccessor != mountAccessor {
+			continue
+		}
+		group := i.groups[alias.CanonicalID].clone()
+		group.Alias = nil
+		group.MemberEntityIDs = nil
+		group.LastUpdateTime = time.Now().UTC()
+		if err := i.storeGroup(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loginEntity returns the entity of the alias of a login on a credential
+// backend, which is created on the first login. The entity is also made a
+// member of the external groups of the backend mapped from the group
+// aliases of the login, and only of those.
+func (i *IdentityStore) loginEntity(me *MountEntry, loginAlias *logical.Alias, groupAliases []*logical.Alias) (*Entity, error) {
+	defer metrics.MeasureSince([]string{"identity", "login_entity"}, time.Now())
+
+	if me == nil || me.Accessor == "" || loginAlias == nil || loginAlias.Name == "" {
+		return nil, nil
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	now := time.Now().UTC()
+	var entity *Entity
+	if alias, ok := i.entityAliasKeys[aliasKey{me.Accessor, loginAlias.Name}]; ok {
+		entity = i.entities[alias.CanonicalID]
+
+		// Keep the metadata of the alias up to date
+		if loginAlias.Metadata != nil && !reflect.DeepEqual(alias.Metadata, loginAlias.Metadata) {
+			entity = entity.clone()
+			for _, a := range entity.Aliases {
+				if a.ID == alias.ID {
+					a.Metadata = loginAlias.Metadata
+					a.LastUpdateTime = now
+				}
+			}
+			entity.LastUpdateTime = now
+			if err := i.storeEntity(entity); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		entityID, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		aliasID, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+
+		name := "entity_" + entityID[:8]
+		if _, ok := i.entityNames[name]; ok {
+			name = "entity_" + entityID
+		}
+		entity = &Entity{
+			ID:   entityID,
+			Name: name,
+			Aliases: []*Alias{
+				&Alias{
+					ID:             aliasID,
+					CanonicalID:    entityID,
+					MountAccessor:  me.Accessor,
+					MountType:      me.Type,
+					MountPath:      credentialRoutePrefix + me.Path,
+					Name:           loginAlias.Name,
+					Metadata:       loginAlias.Metadata,
+					CreationTime:   now,
+					LastUpdateTime: now,
+				},
+			},
+			CreationTime:   now,
+			LastUpdateTime: now,
+		}
+		if err := i.storeEntity(entity); err != nil {
+			return nil, err
+		}
+		i.logger.Printf("[INFO] identity: created entity %s for alias '%s' of %s",
+			entity.ID, loginAlias.Name, me.Accessor)
+	}
+
+	// Update the membership of the external groups of the backend
+	memberOf := make(map[string]bool)
+	for _, groupAlias := range groupAliases {
+		if groupAlias == nil {
+			continue
+		}
+		if alias, ok := i.groupAliasKeys[aliasKey{me.Accessor, groupAlias.Name}]; ok {
+			memberOf[alias.CanonicalID] = true
+		}
+	}
+	for _, alias := range i.groupAliases {
+		if alias.MountAccessor != me.Accessor {
+			continue
+		}
+		group := i.groups[alias.CanonicalID]
+		_, isMember := i.entityGroups[entity.ID][group.ID]
+		if isMember == memberOf[group.ID] {
+			continue
+		}
+
+		group = group.clone()
+		if isMember {
+			group.MemberEntityIDs = strutil.StrListDelete(group.MemberEntityIDs, entity.ID)
+		} else {
+			group.MemberEntityIDs = append(group.MemberEntityIDs, entity.ID)
+		}
+		group.LastUpdateTime = now
+		if err := i.storeGroup(group); err != nil {
+			return nil, err
+		}
+	}
+
+	return entity, nil
+}
+
+// Entity returns a copy of an entity, or nil if there is none with the
+// given ID
+func (i *IdentityStore) Entity(entityID string) *Entity {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	entity, ok := i.entities[entityID]
+	if !ok {
+		return nil
+	}
+	return entity.clone()
+}
+
+// EntityPolicies returns the policies of an entity and of the groups it
+// is a member of
+func (i *IdentityStore) EntityPolicies(entityID string) []string {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	entity, ok := i.entities[entityID]
+	if !ok {
+		return nil
+	}
+
+	policies := append([]string{}, entity.Policies...)
+	for groupID := range i.entityGroups[entityID] {
+		policies = append(policies, i.groups[groupID].Policies...)
+	}
+	return strutil.RemoveDuplicates(policies)
+}
+
+// clone returns a deep copy of the entity, which can be modified before
+// it is stored
+func (e *Entity) clone() *Entity {
+	out := *e
+	out.Policies = append([]string(nil), e.Policies...)
+	out.Metadata = cloneMetadata(e.Metadata)
+	out.Aliases = make([]*Alias, 0, len(e.Aliases))
+	for _, alias := range e.Aliases {
+		out.Aliases = append(out.Aliases, alias.clone())
+	}
+	return &out
+}
+
+// clone returns a deep copy of the alias
+func (a *Alias) clone() *Alias {
+	out := *a
+	out.Metadata = cloneMetadata(a.Metadata)
+	return &out
+}
+
+// clone returns a deep copy of the group, which can be modified before it
+// is stored
+func (g *Group) clone() *Group {
+	out := *g
+	out.Policies = append([]string(nil), g.Policies...)
+	out.Metadata = cloneMetadata(g.Metadata)
+	out.MemberEntityIDs = append([]string(nil), g.MemberEntityIDs...)
+	if g.Alias != nil {
+		out.Alias = g.Alias.clone()
+	}
+	return &out
+}
+
+func cloneMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	out := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		out[k] = v
+	}
+	return out
+}
+
+// removeAlias returns the aliases without the one with the given ID
+func removeAlias(aliases []*Alias, id string) []*Alias {
+	out := make([]*Alias, 0, len(aliases))
+	for _, alias := range aliases {
+		if alias.ID != id {
+			out = append(out, alias)
+		}
+	}
+	return out
+}
+
+var identityHelp = map[string][2]string{
+	"identity": {
+		"The identity store manages the entities and groups of the clients.",
+		`
+An entity is the identity of a client, which the tokens created by its logins
+on the credential backends are attached to. Each login name of the client on a
+backend is an alias of the entity, and an entity is created on the first login
+of an alias. The policies of an entity, and of the groups it is a member of,
+apply to its tokens in addition to their own policies.
+
+The members of an internal group are managed with the API. The members of an
+external group are the entities whose logins report the group of its alias,
+such as an LDAP group or a GitHub team.
+		`,
+	},
+
+	"entity": {
+		"Create an entity, or update an entity by ID.",
+		`
+Creates an entity with the given name, policies and metadata. The name is
+generated if it is not set. When an ID is given, the fields which are set
+update the entity.
+		`,
+	},
+
+	"entity-id": {
+		"Read, update or delete an entity by ID.",
+		`
+Deleting an entity also deletes its aliases and its membership of the groups.
+The tokens attached to the entity are kept, but lose its policies.
+		`,
+	},
+
+	"entity-list": {
+		"List the IDs of the entities.",
+		"",
+	},
+
+	"entity-name": {
+		"Read an entity by name.",
+		"",
+	},
+
+	"entity-alias": {
+		"Create an alias of an entity.",
+		`
+An alias maps the name of a user within a credential backend, identified by
+its mount accessor, to an entity. The logins of the alias create tokens
+attached to the entity. Name, mount_accessor and canonical_id are required.
+		`,
+	},
+
+	"entity-alias-id": {
+		"Read, update or delete an alias of an entity by ID.",
+		`
+Setting canonical_id moves the alias to another entity.
+		`,
+	},
+
+	"entity-alias-list": {
+		"List the IDs of the aliases of the entities.",
+		"",
+	},
+
+	"group": {
+		"Create a group, or update a group by ID.",
+		`
+Creates a group with the given name, type, policies and metadata. The members
+of an internal group are set with member_entity_ids. The members of an external
+group are the entities whose logins report the group of its alias. The type of
+a group cannot be changed.
+		`,
+	},
+
+	"group-id": {
+		"Read, update or delete a group by ID.",
+		"",
+	},
+
+	"group-list": {
+		"List the IDs of the groups.",
+		"",
+	},
+
+	"group-name": {
+		"Read a group by name.",
+		"",
+	},
+
+	"group-alias": {
+		"Create the alias of an external group.",
+		`
+The alias maps the name of a group within a credential backend, such as an LDAP
+group or a GitHub team, to an external group. An external group has at most one
+alias. Name, mount_accessor and canonical_id are required.
+		`,
+	},
+
+	"group-alias-id": {
+		"Read, update or delete the alias of a group by ID.",
+		`
+Changing or deleting the alias resets the members of the group, which are
+mapped again on the following logins.
+		`,
+	},
+
+	"group-alias-list": {
+		"List the IDs of the aliases of the groups.",
+		"",
+	},
+}
@@ -0,0 +1,466 @@
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/policyutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// entityPaths returns the paths used to manage the entities and their
+// aliases
+func (i *IdentityStore) entityPaths() []*framework.Path {
+	entityFields := map[string]*framework.FieldSchema{
+		"id": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "ID of the entity. If set, the entity is updated.",
+		},
+		"name": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Name of the entity. Generated if not set on creation.",
+		},
+		"policies": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Comma-separated list of policies of the entity.",
+		},
+		"metadata": &framework.FieldSchema{
+			Type:        framework.TypeMap,
+			Description: "Metadata of the entity, as string keys and values.",
+		},
+	}
+
+	aliasFields := map[string]*framework.FieldSchema{
+		"id": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "ID of the alias. If set, the alias is updated.",
+		},
+		"name": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Name of the user within the credential backend.",
+		},
+		"mount_accessor": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Accessor of the credential backend, as listed by sys/auth.",
+		},
+		"canonical_id": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "ID of the entity the alias belongs to.",
+		},
+		"metadata": &framework.FieldSchema{
+			Type:        framework.TypeMap,
+			Description: "Metadata of the alias, as string keys and values.",
+		},
+	}
+
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "entity$",
+			Fields:  entityFields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: i.handleEntityUpdate,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["entity"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["entity"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "entity/id/" + framework.GenericNameRegex("id"),
+			Fields:  entityFields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   i.handleEntityRead,
+				logical.UpdateOperation: i.handleEntityUpdate,
+				logical.DeleteOperation: i.handleEntityDelete,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["entity-id"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["entity-id"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "entity/id/?$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: i.handleEntityList,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["entity-list"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["entity-list"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "entity/name/" + framework.GenericNameRegex("name"),
+			Fields:  entityFields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: i.handleEntityRead,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["entity-name"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["entity-name"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "entity-alias$",
+			Fields:  aliasFields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: i.handleEntityAliasUpdate,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["entity-alias"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["entity-alias"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "entity-alias/id/" + framework.GenericNameRegex("id"),
+			Fields:  aliasFields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   i.handleEntityAliasRead,
+				logical.UpdateOperation: i.handleEntityAliasUpdate,
+				logical.DeleteOperation: i.handleEntityAliasDelete,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["entity-alias-id"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["entity-alias-id"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "entity-alias/id/?$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: i.handleEntityAliasList,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["entity-alias-list"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["entity-alias-list"][1]),
+		},
+	}
+}
+
+// handleEntityUpdate creates an entity, or updates the given one
+func (i *IdentityStore) handleEntityUpdate(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	policies, policiesOk, err := identityPoliciesField(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	metadata, metadataOk, err := identityMetadataField(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	id := d.Get("id").(string)
+	name := d.Get("name").(string)
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	now := time.Now().UTC()
+	var entity *Entity
+	if id == "" {
+		entityID, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		entity = &Entity{
+			ID:           entityID,
+			Name:         "entity_" + entityID[:8],
+			CreationTime: now,
+		}
+	} else {
+		existing, ok := i.entities[id]
+		if !ok {
+			return logical.ErrorResponse("entity not found"), logical.ErrInvalidRequest
+		}
+		entity = existing.clone()
+	}
+
+	if name != "" {
+		if otherID, ok := i.entityNames[name]; ok && otherID != entity.ID {
+			return logical.ErrorResponse(fmt.Sprintf("entity name '%s' is already in use", name)), logical.ErrInvalidRequest
+		}
+		entity.Name = name
+	}
+	if policiesOk {
+		entity.Policies = policies
+	}
+	if metadataOk {
+		entity.Metadata = metadata
+	}
+	entity.LastUpdateTime = now
+
+	if err := i.storeEntity(entity); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"id":   entity.ID,
+			"name": entity.Name,
+		},
+	}, nil
+}
+
+// handleEntityRead returns an entity by ID or by name
+func (i *IdentityStore) handleEntityRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	id := d.Get("id").(string)
+	if name := d.Get("name").(string); id == "" && name != "" {
+		id = i.entityNames[name]
+	}
+	entity, ok := i.entities[id]
+	if !ok {
+		return nil, nil
+	}
+
+	aliases := make([]interface{}, 0, len(entity.Aliases))
+	for _, alias := range entity.Aliases {
+		aliases = append(aliases, aliasResponseData(alias))
+	}
+	groupIDs := make([]string, 0, len(i.entityGroups[entity.ID]))
+	for groupID := range i.entityGroups[entity.ID] {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"id":               entity.ID,
+			"name":             entity.Name,
+			"policies":         entity.Policies,
+			"metadata":         entity.Metadata,
+			"aliases":          aliases,
+			"group_ids":        groupIDs,
+			"creation_time":    entity.CreationTime,
+			"last_update_time": entity.LastUpdateTime,
+		},
+	}, nil
+}
+
+// handleEntityDelete removes an entity, its aliases, and its membership of
+// the groups
+func (i *IdentityStore) handleEntityDelete(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	entity, ok := i.entities[d.Get("id").(string)]
+	if !ok {
+		return nil, nil
+	}
+	return nil, i.deleteEntity(entity)
+}
+
+// handleEntityList lists the IDs of the entities
+func (i *IdentityStore) handleEntityList(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	ids := make([]string, 0, len(i.entities))
+	for id := range i.entities {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return logical.ListResponse(ids), nil
+}
+
+// handleEntityAliasUpdate creates an alias of an entity, or updates the
+// given one, which can be moved to another entity
+func (i *IdentityStore) handleEntityAliasUpdate(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	metadata, metadataOk, err := identityMetadataField(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	id := d.Get("id").(string)
+	name := d.Get("name").(string)
+	canonicalID := d.Get("canonical_id").(string)
+
+	// Resolve the backend before locking the store, as disabling a backend
+	// locks the store while holding the auth table lock
+	var me *MountEntry
+	if mountAccessor := d.Get("mount_accessor").(string); mountAccessor != "" {
+		if me = i.core.credentialByAccessor(mountAccessor); me == nil {
+			return logical.ErrorResponse("invalid mount accessor"), logical.ErrInvalidRequest
+		}
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	now := time.Now().UTC()
+	var alias *Alias
+	if id == "" {
+		if name == "" || me == nil || canonicalID == "" {
+			return logical.ErrorResponse("name, mount_accessor and canonical_id are required"), logical.ErrInvalidRequest
+		}
+		aliasID, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		alias = &Alias{
+			ID:           aliasID,
+			CreationTime: now,
+		}
+	} else {
+		existing, ok := i.entityAliases[id]
+		if !ok {
+			return logical.ErrorResponse("alias not found"), logical.ErrInvalidRequest
+		}
+		alias = existing.clone()
+	}
+
+	previousID := alias.CanonicalID
+	if name != "" {
+		alias.Name = name
+	}
+	if me != nil {
+		alias.MountAccessor = me.Accessor
+		alias.MountType = me.Type
+		alias.MountPath = credentialRoutePrefix + me.Path
+	}
+	if canonicalID != "" {
+		alias.CanonicalID = canonicalID
+	}
+	if metadataOk {
+		alias.Metadata = metadata
+	}
+	alias.LastUpdateTime = now
+
+	entity, ok := i.entities[alias.CanonicalID]
+	if !ok {
+		return logical.ErrorResponse("entity not found"), logical.ErrInvalidRequest
+	}
+	if other, ok := i.entityAliasKeys[aliasKey{alias.MountAccessor, alias.Name}]; ok && other.ID != alias.ID {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"alias '%s' of %s already belongs to entity %s", alias.Name, alias.MountAccessor, other.CanonicalID)), logical.ErrInvalidRequest
+	}
+
+	// Move the alias from its previous entity
+	if previousID != "" && previousID != alias.CanonicalID {
+		previous := i.entities[previousID].clone()
+		previous.Aliases = removeAlias(previous.Aliases, alias.ID)
+		previous.LastUpdateTime = now
+		if err := i.storeEntity(previous); err != nil {
+			return nil, err
+		}
+	}
+
+	entity = entity.clone()
+	entity.Aliases = append(removeAlias(entity.Aliases, alias.ID), alias)
+	entity.LastUpdateTime = now
+	if err := i.storeEntity(entity); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"id":           alias.ID,
+			"canonical_id": alias.CanonicalID,
+		},
+	}, nil
+}
+
+// handleEntityAliasRead returns an alias of an entity
+func (i *IdentityStore) handleEntityAliasRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	alias, ok := i.entityAliases[d.Get("id").(string)]
+	if !ok {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: aliasResponseData(alias),
+	}, nil
+}
+
+// handleEntityAliasDelete removes an alias from its entity
+func (i *IdentityStore) handleEntityAliasDelete(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	alias, ok := i.entityAliases[d.Get("id").(string)]
+	if !ok {
+		return nil, nil
+	}
+	entity := i.entities[alias.CanonicalID].clone()
+	entity.Aliases = removeAlias(entity.Aliases, alias.ID)
+	entity.LastUpdateTime = time.Now().UTC()
+	return nil, i.storeEntity(entity)
+}
+
+// handleEntityAliasList lists the IDs of the aliases of the entities
+func (i *IdentityStore) handleEntityAliasList(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	ids := make([]string, 0, len(i.entityAliases))
+	for id := range i.entityAliases {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return logical.ListResponse(ids), nil
+}
+
+// aliasResponseData returns the response data describing an alias
+func aliasResponseData(alias *Alias) map[string]interface{} {
+	return map[string]interface{}{
+		"id":               alias.ID,
+		"canonical_id":     alias.CanonicalID,
+		"mount_accessor":   alias.MountAccessor,
+		"mount_type":       alias.MountType,
+		"mount_path":       alias.MountPath,
+		"name":             alias.Name,
+		"metadata":         alias.Metadata,
+		"creation_time":    alias.CreationTime,
+		"last_update_time": alias.LastUpdateTime,
+	}
+}
+
+// identityPoliciesField parses the policies of an entity or a group. The
+// root policy cannot be assigned to them.
+func identityPoliciesField(d *framework.FieldData) ([]string, bool, error) {
+	raw, ok, err := d.GetOkErr("policies")
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	policies := policyutil.SanitizePolicies(strings.Split(raw.(string), ","), false)
+	for _, policy := range policies {
+		if policy == "root" {
+			return nil, false, fmt.Errorf("the root policy cannot be assigned")
+		}
+	}
+	return policies, true, nil
+}
+
+// identityMetadataField parses the metadata of an entity, an alias or a
+// group, whose values must be strings
+func identityMetadataField(d *framework.FieldData) (map[string]string, bool, error) {
+	raw, ok, err := d.GetOkErr("metadata")
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	metadata := make(map[string]string)
+	for k, v := range raw.(map[string]interface{}) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, false, fmt.Errorf("value of metadata key '%s' is not a string", k)
+		}
+		metadata[k] = s
+	}
+	return metadata, true, nil
+}
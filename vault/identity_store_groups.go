@@ -0,0 +1,448 @@
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/strutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// groupPaths returns the paths used to manage the groups and their aliases
+func (i *IdentityStore) groupPaths() []*framework.Path {
+	groupFields := map[string]*framework.FieldSchema{
+		"id": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "ID of the group. If set, the group is updated.",
+		},
+		"name": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Name of the group. Generated if not set on creation.",
+		},
+		"type": &framework.FieldSchema{
+			Type: framework.TypeString,
+			Description: `Type of the group, 'internal' or 'external'. Defaults
+to 'internal', and cannot be changed.`,
+		},
+		"policies": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Comma-separated list of policies of the group.",
+		},
+		"metadata": &framework.FieldSchema{
+			Type:        framework.TypeMap,
+			Description: "Metadata of the group, as string keys and values.",
+		},
+		"member_entity_ids": &framework.FieldSchema{
+			Type: framework.TypeString,
+			Description: `Comma-separated list of the IDs of the member entities.
+Only for internal groups.`,
+		},
+	}
+
+	aliasFields := map[string]*framework.FieldSchema{
+		"id": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "ID of the alias. If set, the alias is updated.",
+		},
+		"name": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Name of the group within the credential backend.",
+		},
+		"mount_accessor": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Accessor of the credential backend, as listed by sys/auth.",
+		},
+		"canonical_id": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "ID of the external group the alias belongs to.",
+		},
+	}
+
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "group$",
+			Fields:  groupFields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: i.handleGroupUpdate,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["group"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["group"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "group/id/" + framework.GenericNameRegex("id"),
+			Fields:  groupFields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   i.handleGroupRead,
+				logical.UpdateOperation: i.handleGroupUpdate,
+				logical.DeleteOperation: i.handleGroupDelete,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["group-id"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["group-id"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "group/id/?$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: i.handleGroupList,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["group-list"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["group-list"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "group/name/" + framework.GenericNameRegex("name"),
+			Fields:  groupFields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: i.handleGroupRead,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["group-name"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["group-name"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "group-alias$",
+			Fields:  aliasFields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: i.handleGroupAliasUpdate,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["group-alias"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["group-alias"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "group-alias/id/" + framework.GenericNameRegex("id"),
+			Fields:  aliasFields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   i.handleGroupAliasRead,
+				logical.UpdateOperation: i.handleGroupAliasUpdate,
+				logical.DeleteOperation: i.handleGroupAliasDelete,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["group-alias-id"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["group-alias-id"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "group-alias/id/?$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: i.handleGroupAliasList,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(identityHelp["group-alias-list"][0]),
+			HelpDescription: strings.TrimSpace(identityHelp["group-alias-list"][1]),
+		},
+	}
+}
+
+// handleGroupUpdate creates a group, or updates the given one
+func (i *IdentityStore) handleGroupUpdate(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	policies, policiesOk, err := identityPoliciesField(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	metadata, metadataOk, err := identityMetadataField(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	id := d.Get("id").(string)
+	name := d.Get("name").(string)
+	groupType := strings.ToLower(d.Get("type").(string))
+	switch groupType {
+	case "", identityGroupTypeInternal, identityGroupTypeExternal:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid group type '%s'", groupType)), logical.ErrInvalidRequest
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	now := time.Now().UTC()
+	var group *Group
+	if id == "" {
+		groupID, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		if groupType == "" {
+			groupType = identityGroupTypeInternal
+		}
+		group = &Group{
+			ID:           groupID,
+			Name:         "group_" + groupID[:8],
+			Type:         groupType,
+			CreationTime: now,
+		}
+	} else {
+		existing, ok := i.groups[id]
+		if !ok {
+			return logical.ErrorResponse("group not found"), logical.ErrInvalidRequest
+		}
+		if groupType != "" && groupType != existing.Type {
+			return logical.ErrorResponse("the type of a group cannot be changed"), logical.ErrInvalidRequest
+		}
+		group = existing.clone()
+	}
+
+	if name != "" {
+		if otherID, ok := i.groupNames[name]; ok && otherID != group.ID {
+			return logical.ErrorResponse(fmt.Sprintf("group name '%s' is already in use", name)), logical.ErrInvalidRequest
+		}
+		group.Name = name
+	}
+	if policiesOk {
+		group.Policies = policies
+	}
+	if metadataOk {
+		group.Metadata = metadata
+	}
+	if raw, ok := d.GetOk("member_entity_ids"); ok {
+		if group.Type != identityGroupTypeInternal {
+			return logical.ErrorResponse("the members of an external group are set on login"), logical.ErrInvalidRequest
+		}
+		members := strutil.ParseStrings(raw.(string))
+		for _, entityID := range members {
+			if _, ok := i.entities[entityID]; !ok {
+				return logical.ErrorResponse(fmt.Sprintf("entity '%s' not found", entityID)), logical.ErrInvalidRequest
+			}
+		}
+		group.MemberEntityIDs = members
+	}
+	group.LastUpdateTime = now
+
+	if err := i.storeGroup(group); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"id":   group.ID,
+			"name": group.Name,
+		},
+	}, nil
+}
+
+// handleGroupRead returns a group by ID or by name
+func (i *IdentityStore) handleGroupRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	id := d.Get("id").(string)
+	if name := d.Get("name").(string); id == "" && name != "" {
+		id = i.groupNames[name]
+	}
+	group, ok := i.groups[id]
+	if !ok {
+		return nil, nil
+	}
+
+	var alias interface{}
+	if group.Alias != nil {
+		alias = aliasResponseData(group.Alias)
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"id":                group.ID,
+			"name":              group.Name,
+			"type":              group.Type,
+			"policies":          group.Policies,
+			"metadata":          group.Metadata,
+			"member_entity_ids": group.MemberEntityIDs,
+			"alias":             alias,
+			"creation_time":     group.CreationTime,
+			"last_update_time":  group.LastUpdateTime,
+		},
+	}, nil
+}
+
+// handleGroupDelete removes a group and its alias
+func (i *IdentityStore) handleGroupDelete(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	group, ok := i.groups[d.Get("id").(string)]
+	if !ok {
+		return nil, nil
+	}
+	return nil, i.deleteGroup(group)
+}
+
+// handleGroupList lists the IDs of the groups
+func (i *IdentityStore) handleGroupList(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	ids := make([]string, 0, len(i.groups))
+	for id := range i.groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return logical.ListResponse(ids), nil
+}
+
+// handleGroupAliasUpdate creates the alias of an external group, or updates
+// the given one. The members of the group are reset when the alias is
+// changed, as they were mapped from the previous one.
+func (i *IdentityStore) handleGroupAliasUpdate(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	id := d.Get("id").(string)
+	name := d.Get("name").(string)
+	canonicalID := d.Get("canonical_id").(string)
+
+	// Resolve the backend before locking the store, as disabling a backend
+	// locks the store while holding the auth table lock
+	var me *MountEntry
+	if mountAccessor := d.Get("mount_accessor").(string); mountAccessor != "" {
+		if me = i.core.credentialByAccessor(mountAccessor); me == nil {
+			return logical.ErrorResponse("invalid mount accessor"), logical.ErrInvalidRequest
+		}
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	now := time.Now().UTC()
+	var alias *Alias
+	if id == "" {
+		if name == "" || me == nil || canonicalID == "" {
+			return logical.ErrorResponse("name, mount_accessor and canonical_id are required"), logical.ErrInvalidRequest
+		}
+		aliasID, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		alias = &Alias{
+			ID:           aliasID,
+			CreationTime: now,
+		}
+	} else {
+		existing, ok := i.groupAliases[id]
+		if !ok {
+			return logical.ErrorResponse("alias not found"), logical.ErrInvalidRequest
+		}
+		alias = existing.clone()
+	}
+
+	previousID := alias.CanonicalID
+	previousKey := aliasKey{alias.MountAccessor, alias.Name}
+	if name != "" {
+		alias.Name = name
+	}
+	if me != nil {
+		alias.MountAccessor = me.Accessor
+		alias.MountType = me.Type
+		alias.MountPath = credentialRoutePrefix + me.Path
+	}
+	if canonicalID != "" {
+		alias.CanonicalID = canonicalID
+	}
+	alias.LastUpdateTime = now
+
+	group, ok := i.groups[alias.CanonicalID]
+	if !ok {
+		return logical.ErrorResponse("group not found"), logical.ErrInvalidRequest
+	}
+	if group.Type != identityGroupTypeExternal {
+		return logical.ErrorResponse("only external groups can have an alias"), logical.ErrInvalidRequest
+	}
+	if group.Alias != nil && group.Alias.ID != alias.ID {
+		return logical.ErrorResponse(fmt.Sprintf("group already has alias %s", group.Alias.ID)), logical.ErrInvalidRequest
+	}
+	if other, ok := i.groupAliasKeys[aliasKey{alias.MountAccessor, alias.Name}]; ok && other.ID != alias.ID {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"alias '%s' of %s already belongs to group %s", alias.Name, alias.MountAccessor, other.CanonicalID)), logical.ErrInvalidRequest
+	}
+
+	// Move the alias from its previous group
+	if previousID != "" && previousID != alias.CanonicalID {
+		previous := i.groups[previousID].clone()
+		previous.Alias = nil
+		previous.MemberEntityIDs = nil
+		previous.LastUpdateTime = now
+		if err := i.storeGroup(previous); err != nil {
+			return nil, err
+		}
+	}
+
+	group = group.clone()
+	if group.Alias == nil || previousKey != (aliasKey{alias.MountAccessor, alias.Name}) {
+		group.MemberEntityIDs = nil
+	}
+	group.Alias = alias
+	group.LastUpdateTime = now
+	if err := i.storeGroup(group); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"id":           alias.ID,
+			"canonical_id": alias.CanonicalID,
+		},
+	}, nil
+}
+
+// handleGroupAliasRead returns the alias of a group
+func (i *IdentityStore) handleGroupAliasRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	alias, ok := i.groupAliases[d.Get("id").(string)]
+	if !ok {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: aliasResponseData(alias),
+	}, nil
+}
+
+// handleGroupAliasDelete removes the alias of a group, along with the
+// members mapped from it
+func (i *IdentityStore) handleGroupAliasDelete(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	alias, ok := i.groupAliases[d.Get("id").(string)]
+	if !ok {
+		return nil, nil
+	}
+	group := i.groups[alias.CanonicalID].clone()
+	group.Alias = nil
+	group.MemberEntityIDs = nil
+	group.LastUpdateTime = time.Now().UTC()
+	return nil, i.storeGroup(group)
+}
+
+// handleGroupAliasList lists the IDs of the aliases of the groups
+func (i *IdentityStore) handleGroupAliasList(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	ids := make([]string, 0, len(i.groupAliases))
+	for id := range i.groupAliases {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return logical.ListResponse(ids), nil
+}
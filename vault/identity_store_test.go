@@ -0,0 +1,396 @@
+package vault
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/logical"
+)
+
+// testIdentityRequest handles a request with the given token, failing the
+// test on an error
+func testIdentityRequest(t *testing.T, c *Core, token string, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	t.Helper()
+	req := logical.TestRequest(t, op, path)
+	req.ClientToken = token
+	if data != nil {
+		req.Data = data
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %#v", err, resp)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatalf("bad: %#v", resp)
+	}
+	return resp
+}
+
+// testIdentityLoginBackend enables a credential backend at auth/foo whose
+// logins report the given alias and group aliases
+func testIdentityLoginBackend(t *testing.T, c *Core, root string) (*NoopBackend, string) {
+	t.Helper()
+	noop := &NoopBackend{
+		Login: []string{"login"},
+		Response: &logical.Response{
+			Auth: &logical.Auth{
+				Policies:    []string{"foo"},
+				DisplayName: "armon",
+				Alias: &logical.Alias{
+					Name: "armon",
+				},
+			},
+		},
+	}
+	c.credentialBackends["noop"] = func(conf *logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+	testIdentityRequest(t, c, root, logical.UpdateOperation, "sys/auth/foo", map[string]interface{}{
+		"type": "noop",
+	})
+
+	entry := c.auth.Find(rootNamespaceID, "foo/")
+	if entry == nil || entry.Accessor == "" {
+		t.Fatalf("bad: %#v", entry)
+	}
+	return noop, entry.Accessor
+}
+
+func testIdentityLogin(t *testing.T, c *Core) *logical.Auth {
+	t.Helper()
+	resp, err := c.HandleRequest(&logical.Request{
+		Path: "auth/foo/login",
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Auth == nil || resp.Auth.ClientToken == "" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	auth := *resp.Auth
+	return &auth
+}
+
+func TestIdentityStore_Login(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+	noop, accessor := testIdentityLoginBackend(t, c, root)
+
+	testIdentityRequest(t, c, root, logical.UpdateOperation, "sys/policy/entity", map[string]interface{}{
+		"rules": `
+path "secret/entity" { capabilities = ["read"] }
+path "auth/token/create" { capabilities = ["update"] }
+`,
+	})
+	testIdentityRequest(t, c, root, logical.UpdateOperation, "sys/policy/group", map[string]interface{}{
+		"rules": `path "secret/group" { capabilities = ["read"] }`,
+	})
+	for _, path := range []string{"secret/entity", "secret/group"} {
+		testIdentityRequest(t, c, root, logical.UpdateOperation, path, map[string]interface{}{
+			"value": "bar",
+		})
+	}
+
+	// The first login creates the entity and its alias
+	auth := testIdentityLogin(t, c)
+	if auth.EntityID == "" {
+		t.Fatalf("bad: %#v", auth)
+	}
+	te, err := c.tokenStore.Lookup(auth.ClientToken)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te.EntityID != auth.EntityID {
+		t.Fatalf("bad: %#v", te)
+	}
+	entityID := auth.EntityID
+
+	resp := testIdentityRequest(t, c, root, logical.ReadOperation, "identity/entity/id/"+entityID, nil)
+	aliases := resp.Data["aliases"].([]interface{})
+	if len(aliases) != 1 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	alias := aliases[0].(map[string]interface{})
+	if alias["name"] != "armon" || alias["mount_accessor"] != accessor || alias["mount_path"] != "auth/foo/" {
+		t.Fatalf("bad: %#v", alias)
+	}
+
+	// Another login maps to the same entity
+	if auth = testIdentityLogin(t, c); auth.EntityID != entityID {
+		t.Fatalf("bad: %#v", auth)
+	}
+
+	// The policies of the entity apply to its tokens
+	req := logical.TestRequest(t, logical.ReadOperation, "secret/entity")
+	req.ClientToken = auth.ClientToken
+	if _, err := c.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+	testIdentityRequest(t, c, root, logical.UpdateOperation, "identity/entity/id/"+entityID, map[string]interface{}{
+		"policies": "entity",
+	})
+	testIdentityRequest(t, c, auth.ClientToken, logical.ReadOperation, "secret/entity", nil)
+
+	// The entity is a member of the external group mapped from the group
+	// reported by its logins
+	resp = testIdentityRequest(t, c, root, logical.UpdateOperation, "identity/group", map[string]interface{}{
+		"name":     "admins",
+		"type":     "external",
+		"policies": "group",
+	})
+	groupID := resp.Data["id"].(string)
+	testIdentityRequest(t, c, root, logical.UpdateOperation, "identity/group-alias", map[string]interface{}{
+		"name":           "ldap-admins",
+		"mount_accessor": accessor,
+		"canonical_id":   groupID,
+	})
+
+	noop.Response.Auth.GroupAliases = []*logical.Alias{&logical.Alias{Name: "ldap-admins"}}
+	auth = testIdentityLogin(t, c)
+	testIdentityRequest(t, c, auth.ClientToken, logical.ReadOperation, "secret/group", nil)
+
+	resp = testIdentityRequest(t, c, root, logical.ReadOperation, "identity/group/name/admins", nil)
+	if !reflect.DeepEqual(resp.Data["member_entity_ids"], []string{entityID}) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Child tokens belong to the same entity
+	resp = testIdentityRequest(t, c, auth.ClientToken, logical.UpdateOperation, "auth/token/create", nil)
+	resp = testIdentityRequest(t, c, resp.Auth.ClientToken, logical.ReadOperation, "auth/token/lookup-self", nil)
+	if resp.Data["entity_id"] != entityID {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if !reflect.DeepEqual(resp.Data["identity_policies"], []string{"entity", "group"}) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// A login which no longer reports the group removes the membership
+	noop.Response.Auth.GroupAliases = nil
+	auth = testIdentityLogin(t, c)
+	req = logical.TestRequest(t, logical.ReadOperation, "secret/group")
+	req.ClientToken = auth.ClientToken
+	if _, err := c.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The entities are loaded again after an unseal
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if auth = testIdentityLogin(t, c); auth.EntityID != entityID {
+		t.Fatalf("bad: %#v", auth)
+	}
+	testIdentityRequest(t, c, auth.ClientToken, logical.ReadOperation, "secret/entity", nil)
+
+	// Disabling the backend removes the aliases of its logins
+	testIdentityRequest(t, c, root, logical.DeleteOperation, "sys/auth/foo", nil)
+	resp = testIdentityRequest(t, c, root, logical.ReadOperation, "identity/entity/id/"+entityID, nil)
+	if len(resp.Data["aliases"].([]interface{})) != 0 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	resp = testIdentityRequest(t, c, root, logical.ListOperation, "identity/entity-alias/id/", nil)
+	if _, ok := resp.Data["keys"]; ok {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
+func TestIdentityStore_EntityAlias(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	_, accessor := testIdentityLoginBackend(t, c, root)
+
+	resp := testIdentityRequest(t, c, root, logical.UpdateOperation, "identity/entity", map[string]interface{}{
+		"name": "armon",
+		"metadata": map[string]interface{}{
+			"team": "core",
+		},
+	})
+	entityID := resp.Data["id"].(string)
+
+	resp = testIdentityRequest(t, c, root, logical.UpdateOperation, "identity/entity-alias", map[string]interface{}{
+		"name":           "armon",
+		"mount_accessor": accessor,
+		"canonical_id":   entityID,
+	})
+	aliasID := resp.Data["id"].(string)
+
+	// The login of a preexisting alias maps to its entity
+	if auth := testIdentityLogin(t, c); auth.EntityID != entityID {
+		t.Fatalf("bad: %#v", auth)
+	}
+
+	resp = testIdentityRequest(t, c, root, logical.ReadOperation, "identity/entity/name/armon", nil)
+	if resp.Data["id"] != entityID || resp.Data["metadata"].(map[string]string)["team"] != "core" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Names and aliases are unique
+	req := logical.TestRequest(t, logical.UpdateOperation, "identity/entity")
+	req.ClientToken = root
+	req.Data["name"] = "armon"
+	if _, err := c.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrInvalidRequest.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+	resp = testIdentityRequest(t, c, root, logical.UpdateOperation, "identity/entity", nil)
+	otherID := resp.Data["id"].(string)
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "identity/entity-alias")
+	req.ClientToken = root
+	req.Data["name"] = "armon"
+	req.Data["mount_accessor"] = accessor
+	req.Data["canonical_id"] = otherID
+	if _, err := c.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrInvalidRequest.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The root policy cannot be assigned
+	req = logical.TestRequest(t, logical.UpdateOperation, "identity/entity/id/"+otherID)
+	req.ClientToken = root
+	req.Data["policies"] = "root"
+	if _, err := c.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrInvalidRequest.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Moving the alias maps its logins to the other entity
+	testIdentityRequest(t, c, root, logical.UpdateOperation, "identity/entity-alias/id/"+aliasID, map[string]interface{}{
+		"canonical_id": otherID,
+	})
+	if auth := testIdentityLogin(t, c); auth.EntityID != otherID {
+		t.Fatalf("bad: %#v", auth)
+	}
+	resp = testIdentityRequest(t, c, root, logical.ReadOperation, "identity/entity/id/"+entityID, nil)
+	if len(resp.Data["aliases"].([]interface{})) != 0 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Deleting the entity deletes its alias
+	testIdentityRequest(t, c, root, logical.DeleteOperation, "identity/entity/id/"+otherID, nil)
+	resp = testIdentityRequest(t, c, root, logical.ReadOperation, "identity/entity-alias/id/"+aliasID, nil)
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+	resp = testIdentityRequest(t, c, root, logical.ListOperation, "identity/entity/id/", nil)
+	if !reflect.DeepEqual(resp.Data["keys"], []string{entityID}) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
+func TestIdentityStore_InternalGroup(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	resp := testIdentityRequest(t, c, root, logical.UpdateOperation, "identity/entity", map[string]interface{}{
+		"policies": "foo",
+	})
+	entityID := resp.Data["id"].(string)
+
+	// Members must exist
+	req := logical.TestRequest(t, logical.UpdateOperation, "identity/group")
+	req.ClientToken = root
+	req.Data["member_entity_ids"] = "nope"
+	if _, err := c.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrInvalidRequest.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+
+	resp = testIdentityRequest(t, c, root, logical.UpdateOperation, "identity/group", map[string]interface{}{
+		"policies":          "bar,foo",
+		"member_entity_ids": entityID,
+	})
+	groupID := resp.Data["id"].(string)
+
+	if policies := c.identityStore.EntityPolicies(entityID); !reflect.DeepEqual(policies, []string{"bar", "foo"}) {
+		t.Fatalf("bad: %#v", policies)
+	}
+	resp = testIdentityRequest(t, c, root, logical.ReadOperation, "identity/entity/id/"+entityID, nil)
+	if !reflect.DeepEqual(resp.Data["group_ids"], []string{groupID}) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// The type of a group is immutable
+	req = logical.TestRequest(t, logical.UpdateOperation, "identity/group/id/"+groupID)
+	req.ClientToken = root
+	req.Data["type"] = "external"
+	if _, err := c.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrInvalidRequest.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Deleting the entity removes it from the group
+	testIdentityRequest(t, c, root, logical.DeleteOperation, "identity/entity/id/"+entityID, nil)
+	resp = testIdentityRequest(t, c, root, logical.ReadOperation, "identity/group/id/"+groupID, nil)
+	if len(resp.Data["member_entity_ids"].([]string)) != 0 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if policies := c.identityStore.EntityPolicies(entityID); len(policies) != 0 {
+		t.Fatalf("bad: %#v", policies)
+	}
+}
+
+func TestIdentityStore_TemplatedPolicy(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	noop, accessor := testIdentityLoginBackend(t, c, root)
+	noop.Response.Auth.Policies = []string{"users"}
+	noop.Response.Auth.Metadata = map[string]string{
+		"team": "core",
+	}
+
+	testIdentityRequest(t, c, root, logical.UpdateOperation, "sys/policy/users", map[string]interface{}{
+		"rules": fmt.Sprintf(`
+path "secret/users/{{identity.entity.aliases.%s.name}}/*" {
+	capabilities = ["create", "read", "update"]
+}
+path "secret/teams/{{token.metadata.team}}" {
+	capabilities = ["read"]
+}
+path "auth/token/create" {
+	capabilities = ["update"]
+}
+`, accessor),
+	})
+
+	auth := testIdentityLogin(t, c)
+	testIdentityRequest(t, c, auth.ClientToken, logical.UpdateOperation, "secret/users/armon/foo", map[string]interface{}{
+		"value": "bar",
+	})
+	req := logical.TestRequest(t, logical.UpdateOperation, "secret/users/mitchellh/foo")
+	req.ClientToken = auth.ClientToken
+	req.Data["value"] = "bar"
+	if _, err := c.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+
+	capabilities, err := c.Capabilities(auth.ClientToken, "secret/teams/core")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(capabilities, []string{"read"}) {
+		t.Fatalf("bad: %#v", capabilities)
+	}
+
+	// The metadata given when creating a child token is chosen by the
+	// caller, so it does not expand the templated paths
+	resp := testIdentityRequest(t, c, auth.ClientToken, logical.UpdateOperation, "auth/token/create", map[string]interface{}{
+		"meta": map[string]interface{}{
+			"team": "other",
+		},
+	})
+	child := resp.Auth.ClientToken
+	testIdentityRequest(t, c, root, logical.UpdateOperation, "secret/teams/other", map[string]interface{}{
+		"value": "bar",
+	})
+	req = logical.TestRequest(t, logical.ReadOperation, "secret/teams/other")
+	req.ClientToken = child
+	if _, err := c.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The child keeps the login metadata of its parent
+	capabilities, err = c.Capabilities(child, "secret/teams/core")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(capabilities, []string{"read"}) {
+		t.Fatalf("bad: %#v", capabilities)
+	}
+}
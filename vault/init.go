@@ -178,6 +178,13 @@ func (c *Core) Initialize(barrierConfig, recoveryConfig *SealConfig) (*InitResul
 		return nil, err
 	}
 
+	// Generate the cluster certificate, which the nodes of a clustered HA
+	// backend need as soon as they are unsealed
+	if err := c.setupCluster(); err != nil {
+		c.logger.Printf("[ERR] core: cluster setup failed: %v", err)
+		return nil, err
+	}
+
 	// Save the configuration regardless, but only generate a key if it's not
 	// disabled. When using recovery keys they are stored in the barrier, so
 	// this must happen post-unseal.
@@ -227,6 +234,13 @@ func (c *Core) UnsealWithStoredKeys() error {
 		return nil
 	}
 
+	// The keys stored by the seal migrated from must not be used, as the
+	// migration is completed by providing the recovery keys
+	if c.SealMigrationPending() {
+		c.logger.Printf("[INFO] core: seal migration pending, not unsealing with stored keys")
+		return nil
+	}
+
 	sealed, err := c.Sealed()
 	if err != nil {
 		c.logger.Printf("[ERR] core: error checking sealed status in auto-unseal: %s", err)
@@ -17,11 +17,41 @@ var (
 	protectedPaths = []string{
 		"core",
 	}
+
+	// namespaceSystemPaths are the prefixes of the patterns of the paths of
+	// the system backends of the namespaces other than the root one. The
+	// seal, audit backends, storage and keyring of Vault are only managed
+	// from the root namespace.
+	namespaceSystemPaths = []string{
+		"capabilities",
+		"mounts",
+		"remount",
+		"auth",
+		"policy",
+		"renew/",
+		"revoke/",
+		"revoke-prefix/",
+		"namespaces",
+	}
 )
 
 func NewSystemBackend(core *Core, config *logical.BackendConfig) logical.Backend {
+	return newSystemBackend(core, rootNamespace, config, nil)
+}
+
+// NewNamespaceSystemBackend returns the system backend of a namespace other
+// than the root one, which only manages the namespace
+func NewNamespaceSystemBackend(core *Core, ns *Namespace, config *logical.BackendConfig) logical.Backend {
+	return newSystemBackend(core, ns, config, namespaceSystemPaths)
+}
+
+// newSystemBackend returns the system backend of a namespace. If prefixes
+// are given, only the paths with patterns starting with one of them are
+// kept.
+func newSystemBackend(core *Core, ns *Namespace, config *logical.BackendConfig, prefixes []string) logical.Backend {
 	b := &SystemBackend{
-		Core: core,
+		Core:      core,
+		namespace: ns,
 	}
 
 	b.Backend = &framework.Backend{
@@ -32,10 +62,14 @@ func NewSystemBackend(core *Core, config *logical.BackendConfig) logical.Backend
 				"auth/*",
 				"remount",
 				"revoke-prefix/*",
+				"leases/lookup/*",
+				"leases/tidy",
 				"audit",
 				"audit/*",
 				"raw/*",
 				"rotate",
+				"storage/raft/*",
+				"namespaces/*",
 			},
 		},
 
@@ -536,6 +570,24 @@ func NewSystemBackend(core *Core, config *logical.BackendConfig) logical.Backend
 		},
 	}
 
+	b.Backend.Paths = append(b.Backend.Paths, b.raftStoragePaths()...)
+	b.Backend.Paths = append(b.Backend.Paths, b.wrappingPaths()...)
+	b.Backend.Paths = append(b.Backend.Paths, b.leasePaths()...)
+	b.Backend.Paths = append(b.Backend.Paths, b.namespacePaths()...)
+
+	if prefixes != nil {
+		var paths []*framework.Path
+		for _, path := range b.Backend.Paths {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(path.Pattern, prefix) {
+					paths = append(paths, path)
+					break
+				}
+			}
+		}
+		b.Backend.Paths = paths
+	}
+
 	b.Backend.Setup(config)
 
 	return b.Backend
@@ -547,20 +599,19 @@ func NewSystemBackend(core *Core, config *logical.BackendConfig) logical.Backend
 type SystemBackend struct {
 	Core    *Core
 	Backend *framework.Backend
+
+	// namespace is the namespace managed by the backend
+	namespace *Namespace
 }
 
 // handleCapabilitiesreturns the ACL capabilities of the token for a given path
 func (b *SystemBackend) handleCapabilities(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	capabilities, err := b.Core.Capabilities(d.Get("token").(string), d.Get("path").(string))
+	capabilities, permissions, err := b.Core.capabilitiesAndPermissions(d.Get("token").(string), b.namespace.Path+d.Get("path").(string))
 	if err != nil {
 		return nil, err
 	}
 
-	return &logical.Response{
-		Data: map[string]interface{}{
-			"capabilities": capabilities,
-		},
-	}, nil
+	return capabilitiesResponse(capabilities, permissions), nil
 }
 
 // handleCapabilitiesAccessor returns the ACL capabilities of the token associted
@@ -576,16 +627,45 @@ func (b *SystemBackend) handleCapabilitiesAccessor(req *logical.Request, d *fram
 		return nil, err
 	}
 
-	capabilities, err := b.Core.Capabilities(token, d.Get("path").(string))
+	capabilities, permissions, err := b.Core.capabilitiesAndPermissions(token, b.namespace.Path+d.Get("path").(string))
 	if err != nil {
 		return nil, err
 	}
 
-	return &logical.Response{
+	return capabilitiesResponse(capabilities, permissions), nil
+}
+
+// capabilitiesResponse returns the response listing the capabilities of a
+// token on a path, along with the constraints of the path on the parameters
+// and response wrapping of the requests if it has any
+func capabilitiesResponse(capabilities []string, permissions *PathPermissions) *logical.Response {
+	resp := &logical.Response{
 		Data: map[string]interface{}{
 			"capabilities": capabilities,
 		},
-	}, nil
+	}
+	if permissions == nil {
+		return resp
+	}
+
+	data := make(map[string]interface{})
+	if permissions.AllowedParameters != nil {
+		data["allowed_parameters"] = permissions.AllowedParameters
+	}
+	if permissions.DeniedParameters != nil {
+		data["denied_parameters"] = permissions.DeniedParameters
+	}
+	if len(permissions.RequiredParameters) != 0 {
+		data["required_parameters"] = permissions.RequiredParameters
+	}
+	if permissions.MinWrappingTTL != 0 {
+		data["min_wrapping_ttl"] = int64(permissions.MinWrappingTTL.Seconds())
+	}
+	if permissions.MaxWrappingTTL != 0 {
+		data["max_wrapping_ttl"] = int64(permissions.MaxWrappingTTL.Seconds())
+	}
+	resp.Data["permissions"] = data
+	return resp
 }
 
 // handleRekeyRetrieve returns backed-up, PGP-encrypted unseal keys from a
@@ -657,6 +737,9 @@ func (b *SystemBackend) handleMountTable(
 	}
 
 	for _, entry := range b.Core.mounts.Entries {
+		if entry.NamespaceID != b.namespace.ID {
+			continue
+		}
 		info := map[string]interface{}{
 			"type":        entry.Type,
 			"description": entry.Description,
@@ -749,6 +832,7 @@ func (b *SystemBackend) handleMount(
 		Type:        logicalType,
 		Description: description,
 		Config:      config,
+		NamespaceID: b.namespace.ID,
 	}
 
 	// Attempt mount
@@ -782,7 +866,7 @@ func (b *SystemBackend) handleUnmount(
 	suffix = sanitizeMountPath(suffix)
 
 	// Attempt unmount
-	if err := b.Core.unmount(suffix); err != nil {
+	if err := b.Core.unmount(b.namespace, suffix); err != nil {
 		b.Backend.Logger().Printf("[ERR] sys: unmount '%s' failed: %v", suffix, err)
 		return handleError(err)
 	}
@@ -806,7 +890,7 @@ func (b *SystemBackend) handleRemount(
 	toPath = sanitizeMountPath(toPath)
 
 	// Attempt remount
-	if err := b.Core.remount(fromPath, toPath); err != nil {
+	if err := b.Core.remount(b.namespace, fromPath, toPath); err != nil {
 		b.Backend.Logger().Printf("[ERR] sys: remount '%s' to '%s' failed: %v", fromPath, toPath, err)
 		return handleError(err)
 	}
@@ -846,7 +930,10 @@ func (b *SystemBackend) handleMountTuneRead(
 func (b *SystemBackend) handleTuneReadCommon(path string) (*logical.Response, error) {
 	path = sanitizeMountPath(path)
 
-	sysView := b.Core.router.MatchingSystemView(path)
+	var sysView logical.SystemView
+	if b.namespaceMountEntry(path) != nil {
+		sysView = b.Core.router.MatchingSystemView(b.namespace.Path + path)
+	}
 	if sysView == nil {
 		err := fmt.Errorf("[ERR] sys: cannot fetch sysview for path %s", path)
 		b.Backend.Logger().Print(err)
@@ -902,7 +989,7 @@ func (b *SystemBackend) handleTuneWriteCommon(
 		}
 	}
 
-	mountEntry := b.Core.router.MatchingMountEntry(path)
+	mountEntry := b.namespaceMountEntry(path)
 	if mountEntry == nil {
 		err := fmt.Errorf("[ERR] sys: tune of path '%s' failed: no mount entry found", path)
 		b.Backend.Logger().Print(err)
@@ -962,6 +1049,26 @@ func (b *SystemBackend) handleTuneWriteCommon(
 	return nil, nil
 }
 
+// namespaceMountEntry returns the entry of the mount or auth backend matching
+// a path within the namespace of the backend, or nil if there is none
+func (b *SystemBackend) namespaceMountEntry(path string) *MountEntry {
+	entry := b.Core.router.MatchingMountEntry(b.namespace.Path + path)
+	if entry == nil || entry.NamespaceID != b.namespace.ID {
+		return nil
+	}
+	return entry
+}
+
+// checkLeaseNamespace returns an error if a lease does not belong to the
+// namespace of the backend or to one of the namespaces below it. The IDs of
+// the leases start with the paths of their namespaces.
+func (b *SystemBackend) checkLeaseNamespace(leaseID string) error {
+	if !strings.HasPrefix(leaseID, b.namespace.Path) {
+		return logical.ErrPermissionDenied
+	}
+	return nil
+}
+
 // handleRenew is used to renew a lease with a given LeaseID
 func (b *SystemBackend) handleRenew(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -972,6 +1079,10 @@ func (b *SystemBackend) handleRenew(
 	// Convert the increment
 	increment := time.Duration(incrementRaw) * time.Second
 
+	if err := b.checkLeaseNamespace(leaseID); err != nil {
+		return logical.ErrorResponse("lease belongs to another namespace"), err
+	}
+
 	// Invoke the expiration manager directly
 	resp, err := b.Core.expiration.Renew(leaseID, increment)
 	if err != nil {
@@ -987,6 +1098,10 @@ func (b *SystemBackend) handleRevoke(
 	// Get all the options
 	leaseID := data.Get("lease_id").(string)
 
+	if err := b.checkLeaseNamespace(leaseID); err != nil {
+		return logical.ErrorResponse("lease belongs to another namespace"), err
+	}
+
 	// Invoke the expiration manager directly
 	if err := b.Core.expiration.Revoke(leaseID); err != nil {
 		b.Backend.Logger().Printf("[ERR] sys: revoke '%s' failed: %v", leaseID, err)
@@ -1010,8 +1125,8 @@ func (b *SystemBackend) handleRevokeForce(
 // handleRevokePrefixCommon is used to revoke a prefix with many LeaseIDs
 func (b *SystemBackend) handleRevokePrefixCommon(
 	req *logical.Request, data *framework.FieldData, force bool) (*logical.Response, error) {
-	// Get all the options
-	prefix := data.Get("prefix").(string)
+	// Get all the options. The prefix is relative to the namespace.
+	prefix := b.namespace.Path + data.Get("prefix").(string)
 
 	// Invoke the expiration manager directly
 	var err error
@@ -1037,9 +1152,13 @@ func (b *SystemBackend) handleAuthTable(
 		Data: make(map[string]interface{}),
 	}
 	for _, entry := range b.Core.auth.Entries {
+		if entry.NamespaceID != b.namespace.ID {
+			continue
+		}
 		info := map[string]interface{}{
 			"type":        entry.Type,
 			"description": entry.Description,
+			"accessor":    entry.Accessor,
 			"config": map[string]interface{}{
 				"default_lease_ttl": int64(entry.Config.DefaultLeaseTTL.Seconds()),
 				"max_lease_ttl":     int64(entry.Config.MaxLeaseTTL.Seconds()),
@@ -1072,6 +1191,7 @@ func (b *SystemBackend) handleEnableAuth(
 		Path:        path,
 		Type:        logicalType,
 		Description: description,
+		NamespaceID: b.namespace.ID,
 	}
 
 	// Attempt enabling
@@ -1093,7 +1213,7 @@ func (b *SystemBackend) handleDisableAuth(
 	suffix = sanitizeMountPath(suffix)
 
 	// Attempt disable
-	if err := b.Core.disableCredential(suffix); err != nil {
+	if err := b.Core.disableCredential(b.namespace, suffix); err != nil {
 		b.Backend.Logger().Printf("[ERR] sys: disable auth '%s' failed: %v", suffix, err)
 		return handleError(err)
 	}
@@ -1103,8 +1223,13 @@ func (b *SystemBackend) handleDisableAuth(
 // handlePolicyList handles the "policy" endpoint to provide the enabled policies
 func (b *SystemBackend) handlePolicyList(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ps, err := b.policyStore()
+	if err != nil {
+		return handleError(err)
+	}
+
 	// Get all the configured policies
-	policies, err := b.Core.policyStore.ListPolicies()
+	policies, err := ps.ListPolicies()
 
 	// Add the special "root" policy
 	policies = append(policies, "root")
@@ -1121,7 +1246,12 @@ func (b *SystemBackend) handlePolicyRead(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	name := data.Get("name").(string)
 
-	policy, err := b.Core.policyStore.GetPolicy(name)
+	ps, err := b.policyStore()
+	if err != nil {
+		return handleError(err)
+	}
+
+	policy, err := ps.GetPolicy(name)
 	if err != nil {
 		return handleError(err)
 	}
@@ -1153,8 +1283,13 @@ func (b *SystemBackend) handlePolicySet(
 	// Override the name
 	parse.Name = strings.ToLower(name)
 
+	ps, err := b.policyStore()
+	if err != nil {
+		return handleError(err)
+	}
+
 	// Update the policy
-	if err := b.Core.policyStore.SetPolicy(parse); err != nil {
+	if err := ps.SetPolicy(parse); err != nil {
 		return handleError(err)
 	}
 	return nil, nil
@@ -1165,12 +1300,26 @@ func (b *SystemBackend) handlePolicyDelete(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	name := data.Get("name").(string)
 
-	if err := b.Core.policyStore.DeletePolicy(name); err != nil {
+	ps, err := b.policyStore()
+	if err != nil {
+		return handleError(err)
+	}
+
+	if err := ps.DeletePolicy(name); err != nil {
 		return handleError(err)
 	}
 	return nil, nil
 }
 
+// policyStore returns the policy store of the namespace of the backend
+func (b *SystemBackend) policyStore() (*PolicyStore, error) {
+	ps := b.Core.namespacePolicyStore(b.namespace.ID)
+	if ps == nil {
+		return nil, fmt.Errorf("namespace '%s' not found", b.namespace.Path)
+	}
+	return ps, nil
+}
+
 // handleAuditTable handles the "audit" endpoint to provide the audit table
 func (b *SystemBackend) handleAuditTable(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -1609,6 +1758,48 @@ of external secrets. Access to this prefix should be tightly controlled.
 		"",
 	},
 
+	"lease-lookup": {
+		"Look up the times of a lease",
+		`
+Returns the issue time, expiration time, last renewal time and remaining TTL
+of the lease with the given ID, and whether it can be renewed. A lease that
+could not be revoked after several attempts once expired is marked
+irrevocable, and its last revocation error is returned.
+		`,
+	},
+
+	"lease-lookup-id": {
+		"The lease identifier to look up.",
+		"",
+	},
+
+	"lease-list": {
+		"List the leases under a prefix",
+		`
+Lists the lease IDs directly under the given prefix, along with the nested
+prefixes, which end with a slash. The last revocation error of the
+irrevocable leases among them is returned in 'irrevocable'. This can be used
+to review the leases of a mount before revoking them with 'revoke-prefix'.
+This requires sudo capability.
+		`,
+	},
+
+	"lease-list-prefix": {
+		`The prefix to list the leases under. Example: "aws/creds/deploy/"`,
+		"",
+	},
+
+	"lease-tidy": {
+		"Remove dangling lease entries in the background",
+		`
+Starts a background operation that revokes the leases whose token no longer
+exists, and removes the token index entries pointing at leases that no longer
+exist. These are left behind when a revocation fails halfway. It is safe to
+run while leases are created and revoked. Reading this path returns the state
+and counts of the last operation. This requires sudo capability.
+		`,
+	},
+
 	"auth-table": {
 		"List the currently enabled credential backends.",
 		`
@@ -1752,6 +1943,90 @@ Enable a new audit backend or disable an existing backend.
 		`,
 	},
 
+	"raft-join": {
+		"Adds a node to the cluster of the raft storage backend.",
+		`
+		The node is identified by its node ID and the address of its raft
+		transport. It must be started without bootstrapping a cluster of its
+		own. This must be called on the active node.
+
+		Raft traffic is only exchanged between unsealed nodes, which
+		authenticate each other with the cluster certificate. A new node is
+		sealed, so it is first provisioned with the data it needs to be
+		unsealed; once unsealed, it is added to the cluster by calling this
+		again.
+		`,
+	},
+
+	"raft-remove-peer": {
+		"Removes a node from the cluster of the raft storage backend.",
+		`
+		The node is identified by its node ID, as returned by the
+		list-peers endpoint.
+		`,
+	},
+
+	"raft-list-peers": {
+		"Lists the nodes of the cluster of the raft storage backend.",
+		`
+		Returns the node ID and raft address of every member of the cluster,
+		along with whether it is the leader and whether it is a voter.
+		`,
+	},
+
+	"raft-snapshot": {
+		"Saves or restores a snapshot of the raft storage backend.",
+		`
+		A GET returns a snapshot of the replicated storage as a binary
+		archive. A POST with a snapshot as the request body, using the
+		application/octet-stream content type, replaces the storage of the
+		whole cluster with it; the active node then steps down so that the
+		restored data is loaded.
+		`,
+	},
+
+	"wrapping_token": {
+		"The response-wrapping token.",
+		"",
+	},
+
+	"wrapping-lookup": {
+		"Looks up the properties of a response-wrapping token.",
+		`
+		Returns the creation TTL, creation time and creation path of the
+		given response-wrapping token, without unwrapping it. The creation
+		path is the path of the request whose response was wrapped, which
+		can be checked to ensure the token comes from the expected endpoint.
+		`,
+	},
+
+	"wrapping-rewrap": {
+		"Rotates a response-wrapping token.",
+		`
+		Moves the response wrapped by the given token to a new token, with
+		the same creation TTL and creation path, and revokes the given token.
+		This can be used to refresh long-lived wrapping tokens.
+		`,
+	},
+
+	"wrapping-unwrap": {
+		"Unwraps a response-wrapping token.",
+		`
+		Returns the original response wrapped by the given token, and revokes
+		the token. If no token is given, the client token is used as the
+		response-wrapping token.
+		`,
+	},
+
+	"wrapping-wrap": {
+		"Wraps the request data in a response-wrapping token.",
+		`
+		Returns a response-wrapping token whose response contains the data
+		of the request. The TTL of the token is given with the
+		X-Vault-Wrap-TTL header.
+		`,
+	},
+
 	"rekey_backup": {
 		"Allows fetching or deleting the backup of the rotated unseal keys.",
 		"",
@@ -1760,13 +2035,17 @@ Enable a new audit backend or disable an existing backend.
 	"capabilities": {
 		"Fetches the capabilities of the given token on the given path.",
 		`Returns the capabilities of the given token on the path.
-		The path will be searched for a path match in all the policies associated with the token.`,
+		The path will be searched for a path match in all the policies associated with the token.
+		If the path constrains the parameters or the response wrapping of the requests, the
+		constraints are returned under "permissions".`,
 	},
 
 	"capabilities_self": {
 		"Fetches the capabilities of the given token on the given path.",
 		`Returns the capabilities of the client token on the path.
-		The path will be searched for a path match in all the policies associated with the client token.`,
+		The path will be searched for a path match in all the policies associated with the client token.
+		If the path constrains the parameters or the response wrapping of the requests, the
+		constraints are returned under "permissions".`,
 	},
 
 	"capabilities_accessor": {
@@ -1774,4 +2053,28 @@ Enable a new audit backend or disable an existing backend.
 		`When there is no access to the token, token accessor can be used to fetch the token's capabilities
 		on a given path.`,
 	},
+
+	"namespace-list": {
+		"Lists the child namespaces of the namespace.",
+		"",
+	},
+
+	"namespace-name": {
+		"The name of the child namespace, which is a single path segment.",
+		"",
+	},
+
+	"namespace": {
+		"Creates, reads or deletes a child namespace.",
+		`
+		A namespace has its own mounts, auth backends, policies and tokens,
+		which are reached with the path of the namespace as a prefix. Its
+		storage is isolated from the one of the other namespaces, and its
+		tokens cannot be used outside of it and of its child namespaces.
+
+		A new namespace gets a system backend and a token store. Deleting a
+		namespace revokes all its leases and tokens and removes its data.
+		A namespace cannot be deleted while it has child namespaces.
+		`,
+	},
 }
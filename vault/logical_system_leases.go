@@ -0,0 +1,143 @@
+package vault
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// leasePaths returns the paths used to inspect leases
+func (b *SystemBackend) leasePaths() []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "leases/lookup$",
+
+			Fields: map[string]*framework.FieldSchema{
+				"lease_id": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: strings.TrimSpace(sysHelp["lease-lookup-id"][0]),
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.handleLeaseLookup,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["lease-lookup"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["lease-lookup"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "leases/lookup/(?P<prefix>.*)$",
+
+			Fields: map[string]*framework.FieldSchema{
+				"prefix": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: strings.TrimSpace(sysHelp["lease-list-prefix"][0]),
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.handleLeaseList,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["lease-list"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["lease-list"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "leases/tidy$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.handleLeaseTidyStatus,
+				logical.UpdateOperation: b.handleLeaseTidy,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["lease-tidy"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["lease-tidy"][1]),
+		},
+	}
+}
+
+// handleLeaseLookup returns the issue, expiration and last renewal times of
+// a lease, and the last revocation error of an irrevocable lease
+func (b *SystemBackend) handleLeaseLookup(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	leaseID := data.Get("lease_id").(string)
+	if leaseID == "" {
+		return logical.ErrorResponse("missing lease_id"), logical.ErrInvalidRequest
+	}
+
+	le, err := b.Core.expiration.FetchLeaseTimes(leaseID)
+	if err != nil {
+		b.Backend.Logger().Printf("[ERR] sys: lookup of lease '%s' failed: %v", leaseID, err)
+		return handleError(err)
+	}
+	if le == nil {
+		return logical.ErrorResponse("invalid lease"), logical.ErrInvalidRequest
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"id":                leaseID,
+			"issue_time":        le.IssueTime,
+			"expire_time":       nil,
+			"last_renewal_time": nil,
+			"renewable":         le.renewable() == nil,
+			"ttl":               int64(0),
+			"irrevocable":       le.RevokeErr != "",
+			"revoke_error":      nil,
+		},
+	}
+	if !le.ExpireTime.IsZero() {
+		resp.Data["expire_time"] = le.ExpireTime
+		if ttl := le.ExpireTime.Sub(time.Now()); ttl > 0 {
+			resp.Data["ttl"] = int64(ttl.Seconds())
+		}
+	}
+	if !le.LastRenewalTime.IsZero() {
+		resp.Data["last_renewal_time"] = le.LastRenewalTime
+	}
+	if le.RevokeErr != "" {
+		resp.Data["revoke_error"] = le.RevokeErr
+	}
+	return resp, nil
+}
+
+// handleLeaseList lists the lease IDs and the nested prefixes under a prefix,
+// along with the last revocation error of the irrevocable leases
+func (b *SystemBackend) handleLeaseList(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	prefix := data.Get("prefix").(string)
+
+	keys, err := b.Core.expiration.ListLeaseIDs(prefix)
+	if err != nil {
+		b.Backend.Logger().Printf("[ERR] sys: listing leases under '%s' failed: %v", prefix, err)
+		return handleError(err)
+	}
+
+	resp := logical.ListResponse(keys)
+	if irrevocable := b.Core.expiration.IrrevocableLeases(prefix, keys); len(irrevocable) > 0 {
+		resp.Data["irrevocable"] = irrevocable
+	}
+	return resp, nil
+}
+
+// handleLeaseTidy starts a tidy operation of the leases in the background
+func (b *SystemBackend) handleLeaseTidy(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	m := b.Core.expiration
+	if !m.tidy.start(m.Tidy, m.logger) {
+		return logical.ErrorResponse("tidy operation already in progress"), logical.ErrInvalidRequest
+	}
+	return m.tidy.response(), nil
+}
+
+// handleLeaseTidyStatus returns the status of the last tidy operation of the
+// leases
+func (b *SystemBackend) handleLeaseTidyStatus(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.Core.expiration.tidy.response(), nil
+}
@@ -0,0 +1,194 @@
+package vault
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestSystemBackend_leases(t *testing.T) {
+	core, _, root := TestCoreUnsealed(t)
+
+	// Create a key with a lease
+	req := logical.TestRequest(t, logical.UpdateOperation, "secret/foo")
+	req.Data["foo"] = "bar"
+	req.Data["lease"] = "1h"
+	req.ClientToken = root
+	if _, err := core.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Read a key with a LeaseID
+	req = logical.TestRequest(t, logical.ReadOperation, "secret/foo")
+	req.ClientToken = root
+	resp, err := core.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Secret == nil || resp.Secret.LeaseID == "" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	leaseID := resp.Secret.LeaseID
+
+	// Lookup the lease
+	req = logical.TestRequest(t, logical.UpdateOperation, "sys/leases/lookup")
+	req.Data["lease_id"] = leaseID
+	req.ClientToken = root
+	resp, err = core.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["id"] != leaseID || resp.Data["renewable"] != true {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if resp.Data["last_renewal_time"] != nil || resp.Data["expire_time"] == nil {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if ttl := resp.Data["ttl"].(int64); ttl <= 0 || ttl > 3600 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Lookup an unknown lease
+	req.Data["lease_id"] = "secret/foo/bar"
+	if _, err := core.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrInvalidRequest.Error()) {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// List the leases under a prefix
+	req = logical.TestRequest(t, logical.ListOperation, "sys/leases/lookup/")
+	req.ClientToken = root
+	resp, err = core.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"secret/"}) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	req = logical.TestRequest(t, logical.ListOperation, "sys/leases/lookup/secret/foo")
+	req.ClientToken = root
+	resp, err = core.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := []string{strings.TrimPrefix(leaseID, "secret/foo/")}
+	if !reflect.DeepEqual(resp.Data["keys"], expected) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Listing requires sudo
+	req = logical.TestRequest(t, logical.UpdateOperation, "sys/policy/leases")
+	req.Data["rules"] = `
+path "sys/leases/lookup/*" {
+	capabilities = ["list"]
+}
+path "sys/leases/lookup" {
+	capabilities = ["update"]
+}
+`
+	req.ClientToken = root
+	if _, err := core.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testCoreMakeToken(t, core, root, "client", "", []string{"leases"})
+
+	req = logical.TestRequest(t, logical.ListOperation, "sys/leases/lookup/secret/")
+	req.ClientToken = "client"
+	if _, err := core.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
+		t.Fatalf("bad: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "sys/leases/lookup")
+	req.Data["lease_id"] = leaseID
+	req.ClientToken = "client"
+	if _, err := core.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestSystemBackend_leasesIrrevocable(t *testing.T) {
+	core, _, root := TestCoreUnsealed(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "secret/foo")
+	req.Data["foo"] = "bar"
+	req.Data["lease"] = "1h"
+	req.ClientToken = root
+	if _, err := core.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "secret/foo")
+	req.ClientToken = root
+	resp, err := core.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Secret == nil || resp.Secret.LeaseID == "" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	leaseID := resp.Secret.LeaseID
+
+	if err := core.expiration.markIrrevocable(leaseID, fmt.Errorf("backend down")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "sys/leases/lookup")
+	req.Data["lease_id"] = leaseID
+	req.ClientToken = root
+	resp, err = core.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["irrevocable"] != true || resp.Data["revoke_error"] != "backend down" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	req = logical.TestRequest(t, logical.ListOperation, "sys/leases/lookup/secret/foo")
+	req.ClientToken = root
+	resp, err = core.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := map[string]string{
+		strings.TrimPrefix(leaseID, "secret/foo/"): "backend down",
+	}
+	if !reflect.DeepEqual(resp.Data["irrevocable"], expected) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
+func TestSystemBackend_leasesTidy(t *testing.T) {
+	core, _, root := TestCoreUnsealed(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/leases/tidy")
+	req.ClientToken = root
+	resp, err := core.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["start_time"] == nil {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Wait for the tidy operation to finish
+	for i := 0; i < 100; i++ {
+		req = logical.TestRequest(t, logical.ReadOperation, "sys/leases/tidy")
+		req.ClientToken = root
+		resp, err = core.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if resp.Data["state"] != "running" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp.Data["state"] != "finished" || resp.Data["leases_revoked"] != 0 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
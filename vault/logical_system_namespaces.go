@@ -0,0 +1,115 @@
+package vault
+
+import (
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// namespacePaths returns the paths used to manage the child namespaces of
+// the namespace of the backend
+func (b *SystemBackend) namespacePaths() []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "namespaces/?$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.handleNamespaceList,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["namespace-list"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["namespace-list"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "namespaces/(?P<name>[^/]+)/?$",
+
+			Fields: map[string]*framework.FieldSchema{
+				"name": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: strings.TrimSpace(sysHelp["namespace-name"][0]),
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.handleNamespaceRead,
+				logical.UpdateOperation: b.handleNamespaceCreate,
+				logical.DeleteOperation: b.handleNamespaceDelete,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["namespace"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["namespace"][1]),
+		},
+	}
+}
+
+// childNamespace returns the child namespace of the namespace of the backend
+// with the given name, or nil if there is none
+func (b *SystemBackend) childNamespace(name string) *Namespace {
+	path := b.namespace.Path + name + "/"
+	for _, ns := range b.Core.childNamespaces(b.namespace) {
+		if ns.Path == path {
+			return ns
+		}
+	}
+	return nil
+}
+
+// handleNamespaceList lists the names of the child namespaces
+func (b *SystemBackend) handleNamespaceList(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var names []string
+	for _, ns := range b.Core.childNamespaces(b.namespace) {
+		names = append(names, strings.TrimPrefix(ns.Path, b.namespace.Path))
+	}
+	return logical.ListResponse(names), nil
+}
+
+// handleNamespaceRead returns the ID and full path of a child namespace
+func (b *SystemBackend) handleNamespaceRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ns := b.childNamespace(data.Get("name").(string))
+	if ns == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"id":   ns.ID,
+			"path": ns.Path,
+		},
+	}, nil
+}
+
+// handleNamespaceCreate creates a child namespace
+func (b *SystemBackend) handleNamespaceCreate(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("namespace name must be specified"), logical.ErrInvalidRequest
+	}
+
+	if _, err := b.Core.createNamespace(b.namespace, name); err != nil {
+		b.Backend.Logger().Printf("[ERR] sys: create namespace '%s' failed: %v", name, err)
+		return handleError(err)
+	}
+	return nil, nil
+}
+
+// handleNamespaceDelete deletes a child namespace, revoking all its leases
+// and tokens
+func (b *SystemBackend) handleNamespaceDelete(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	ns := b.childNamespace(name)
+	if ns == nil {
+		return nil, nil
+	}
+
+	if err := b.Core.deleteNamespace(ns); err != nil {
+		b.Backend.Logger().Printf("[ERR] sys: delete namespace '%s' failed: %v", ns.Path, err)
+		return handleError(err)
+	}
+	return nil, nil
+}
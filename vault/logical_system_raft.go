@@ -0,0 +1,225 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"github.com/hashicorp/vault/physical"
+)
+
+// raftStoragePaths returns the paths used to manage the cluster of the raft
+// storage backend
+func (b *SystemBackend) raftStoragePaths() []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "storage/raft/join$",
+
+			Fields: map[string]*framework.FieldSchema{
+				"node_id": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "ID of the node to add to the cluster.",
+				},
+				"address": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Address of the raft transport of the node.",
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.handleRaftJoin,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["raft-join"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["raft-join"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "storage/raft/remove-peer$",
+
+			Fields: map[string]*framework.FieldSchema{
+				"node_id": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "ID of the node to remove from the cluster.",
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.handleRaftRemovePeer,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["raft-remove-peer"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["raft-remove-peer"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "storage/raft/list-peers$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.handleRaftListPeers,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["raft-list-peers"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["raft-list-peers"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "storage/raft/snapshot$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.handleRaftSnapshotSave,
+				logical.UpdateOperation: b.handleRaftSnapshotRestore,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["raft-snapshot"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["raft-snapshot"][1]),
+		},
+	}
+}
+
+// raftBackend returns the raft storage backend, or an error response if
+// raft storage is not in use
+func (b *SystemBackend) raftBackend() (*physical.RaftBackend, *logical.Response) {
+	raftBackend, ok := b.Core.ha.(*physical.RaftBackend)
+	if !ok {
+		return nil, logical.ErrorResponse("raft storage is not in use")
+	}
+	return raftBackend, nil
+}
+
+// handleRaftJoin adds a node to the raft cluster. A node which is sealed is
+// provisioned instead, so that it can be unsealed and joined afterwards.
+func (b *SystemBackend) handleRaftJoin(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	raftBackend, resp := b.raftBackend()
+	if resp != nil {
+		return resp, nil
+	}
+
+	nodeID := data.Get("node_id").(string)
+	if nodeID == "" {
+		return logical.ErrorResponse("missing node_id"), nil
+	}
+	address := data.Get("address").(string)
+	if address == "" {
+		return logical.ErrorResponse("missing address"), nil
+	}
+
+	err := raftBackend.AddPeer(nodeID, address)
+	switch {
+	case err == physical.ErrRaftPeerUnavailable:
+		// The node is sealed. If it is new, send it the data it needs to be
+		// unsealed; it can only be added once unsealed.
+		if err := raftBackend.ProvisionPeer(address); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("node is sealed and cannot be provisioned: %v", err)), nil
+		}
+		resp := &logical.Response{}
+		resp.AddWarning("The node has been provisioned with the data of the cluster but has not joined it yet. Unseal it, then join it again.")
+		return resp, nil
+	case err != nil:
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	return nil, nil
+}
+
+// handleRaftRemovePeer removes a node from the raft cluster
+func (b *SystemBackend) handleRaftRemovePeer(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	raftBackend, resp := b.raftBackend()
+	if resp != nil {
+		return resp, nil
+	}
+
+	nodeID := data.Get("node_id").(string)
+	if nodeID == "" {
+		return logical.ErrorResponse("missing node_id"), nil
+	}
+
+	if err := raftBackend.RemovePeer(nodeID); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	return nil, nil
+}
+
+// handleRaftListPeers returns the members of the raft cluster
+func (b *SystemBackend) handleRaftListPeers(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	raftBackend, resp := b.raftBackend()
+	if resp != nil {
+		return resp, nil
+	}
+
+	peers, err := raftBackend.Peers()
+	if err != nil {
+		return nil, err
+	}
+
+	peerList := make([]map[string]interface{}, 0, len(peers))
+	for _, peer := range peers {
+		peerList = append(peerList, map[string]interface{}{
+			"node_id": peer.NodeID,
+			"address": peer.Address,
+			"leader":  peer.Leader,
+			"voter":   peer.Voter,
+		})
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"peers": peerList,
+		},
+	}, nil
+}
+
+// handleRaftSnapshotSave returns a snapshot of the raft storage as the raw
+// response body
+func (b *SystemBackend) handleRaftSnapshotSave(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	raftBackend, resp := b.raftBackend()
+	if resp != nil {
+		return resp, nil
+	}
+
+	var buf bytes.Buffer
+	if err := raftBackend.Snapshot(&buf); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "application/octet-stream",
+			logical.HTTPRawBody:     buf.Bytes(),
+			logical.HTTPStatusCode:  200,
+		},
+	}, nil
+}
+
+// handleRaftSnapshotRestore restores the raft storage from the snapshot
+// sent as the raw request body. The active node steps down afterwards, so
+// that the restored state is loaded by the next active node.
+func (b *SystemBackend) handleRaftSnapshotRestore(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	raftBackend, resp := b.raftBackend()
+	if resp != nil {
+		return resp, nil
+	}
+
+	snapshot, ok := req.Data[logical.HTTPRawBody].([]byte)
+	if !ok || len(snapshot) == 0 {
+		return logical.ErrorResponse("missing snapshot; it must be sent as the request body with the application/octet-stream content type"), nil
+	}
+
+	if err := raftBackend.Restore(bytes.NewReader(snapshot)); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	select {
+	case b.Core.manualStepDownCh <- struct{}{}:
+	default:
+		b.Core.logger.Printf("[WARN] core: manual step-down operation already queued")
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,252 @@
+package vault
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
+)
+
+// testRaftCore creates a core using a raft backend listening on a random
+// local port
+func testRaftCore(t *testing.T, advertiseAddr string, bootstrap bool) (*Core, *physical.RaftBackend, func()) {
+	dir, err := ioutil.TempDir("", "vault-raft")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	bootstrapStr := "false"
+	if bootstrap {
+		bootstrapStr = "true"
+	}
+	raft, err := physical.NewRaftBackend(map[string]string{
+		"path":      dir,
+		"address":   "127.0.0.1:0",
+		"bootstrap": bootstrapStr,
+	}, logger)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("err: %s", err)
+	}
+
+	core, err := NewCore(&CoreConfig{
+		Physical:      raft,
+		HAPhysical:    raft,
+		AdvertiseAddr: advertiseAddr,
+		DisableMlock:  true,
+	})
+	if err != nil {
+		raft.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("err: %s", err)
+	}
+
+	return core, raft, func() {
+		core.Shutdown()
+		raft.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// testRaftWaitActive waits until one of the cores becomes active. A node
+// stepping down waits for manualStepDownSleepPeriod before taking the lock
+// again, so this waits longer than that.
+func testRaftWaitActive(t *testing.T, cores ...*Core) *Core {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		for _, core := range cores {
+			if sealed, _ := core.Sealed(); sealed {
+				continue
+			}
+			if standby, _ := core.Standby(); !standby {
+				return core
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("no core became active")
+	return nil
+}
+
+func testRaftRequest(t *testing.T, core *Core, req *logical.Request) *logical.Response {
+	resp, err := core.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %#v", err, resp)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatalf("bad: %#v", resp)
+	}
+	return resp
+}
+
+func TestSystemBackend_raftStorage(t *testing.T) {
+	core1, raft1, cleanup1 := testRaftCore(t, "http://127.0.0.1:8200", true)
+	defer cleanup1()
+	core2, raft2, cleanup2 := testRaftCore(t, "http://127.0.0.1:8300", false)
+	defer cleanup2()
+	core3, raft3, cleanup3 := testRaftCore(t, "http://127.0.0.1:8400", false)
+	defer cleanup3()
+
+	// The bootstrapped node has to be the raft leader to be initialized
+	for i := 0; ; i++ {
+		lock, _ := raft1.LockWith("probe", "")
+		if _, err := lock.Lock(nil); err == nil {
+			lock.Unlock()
+			break
+		}
+		if i == 100 {
+			t.Fatalf("raft leader not elected")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	key, root := TestCoreInit(t, core1)
+	if _, err := core1.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+	testRaftWaitActive(t, core1)
+
+	// The other nodes are sealed, so joining them only provisions them
+	// with the data they need to be unsealed
+	for _, raft := range []*physical.RaftBackend{raft2, raft3} {
+		req := logical.TestRequest(t, logical.UpdateOperation, "sys/storage/raft/join")
+		req.ClientToken = root
+		req.Data["node_id"] = raft.NodeID()
+		req.Data["address"] = raft.Address()
+		resp := testRaftRequest(t, core1, req)
+		if resp == nil || len(resp.Warnings()) == 0 {
+			t.Fatalf("bad: %#v", resp)
+		}
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "sys/storage/raft/list-peers")
+	req.ClientToken = root
+	resp := testRaftRequest(t, core1, req)
+	peers := resp.Data["peers"].([]map[string]interface{})
+	if len(peers) != 1 {
+		t.Fatalf("bad: %#v", peers)
+	}
+
+	// Once unsealed, they join the cluster
+	for _, core := range []*Core{core2, core3} {
+		if _, err := core.Unseal(TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+	for _, raft := range []*physical.RaftBackend{raft2, raft3} {
+		req := logical.TestRequest(t, logical.UpdateOperation, "sys/storage/raft/join")
+		req.ClientToken = root
+		req.Data["node_id"] = raft.NodeID()
+		req.Data["address"] = raft.Address()
+		if resp := testRaftRequest(t, core1, req); resp != nil {
+			t.Fatalf("bad: %#v", resp)
+		}
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "sys/storage/raft/list-peers")
+	req.ClientToken = root
+	resp = testRaftRequest(t, core1, req)
+	peers = resp.Data["peers"].([]map[string]interface{})
+	if len(peers) != 3 {
+		t.Fatalf("bad: %#v", peers)
+	}
+	for _, peer := range peers {
+		if peer["leader"] != (peer["node_id"] == raft1.NodeID()) {
+			t.Fatalf("bad: %#v", peers)
+		}
+	}
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "secret/foo")
+	req.ClientToken = root
+	req.Data["value"] = "bar"
+	testRaftRequest(t, core1, req)
+
+	// Sealing the active node hands raft leadership over to another node,
+	// which becomes active
+	if err := core1.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	active := testRaftWaitActive(t, core2, core3)
+
+	req = logical.TestRequest(t, logical.ReadOperation, "secret/foo")
+	req.ClientToken = root
+	resp = testRaftRequest(t, active, req)
+	if resp == nil || resp.Data["value"] != "bar" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Take a snapshot, then change the data
+	req = logical.TestRequest(t, logical.ReadOperation, "sys/storage/raft/snapshot")
+	req.ClientToken = root
+	resp = testRaftRequest(t, active, req)
+	snapshot, ok := resp.Data[logical.HTTPRawBody].([]byte)
+	if !ok || len(snapshot) == 0 {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "secret/foo")
+	req.ClientToken = root
+	req.Data["value"] = "baz"
+	testRaftRequest(t, active, req)
+
+	// Restoring the snapshot brings the data back
+	req = logical.TestRequest(t, logical.UpdateOperation, "sys/storage/raft/snapshot")
+	req.ClientToken = root
+	req.Data = map[string]interface{}{
+		logical.HTTPRawBody: snapshot,
+	}
+	testRaftRequest(t, active, req)
+
+	for i := 0; ; i++ {
+		active = testRaftWaitActive(t, core2, core3)
+		req = logical.TestRequest(t, logical.ReadOperation, "secret/foo")
+		req.ClientToken = root
+		resp, err := active.HandleRequest(req)
+		if err == nil && resp != nil && resp.Data["value"] == "bar" {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("snapshot not restored: %#v %v", resp, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Remove the sealed node from the cluster
+	req = logical.TestRequest(t, logical.UpdateOperation, "sys/storage/raft/remove-peer")
+	req.ClientToken = root
+	req.Data["node_id"] = raft1.NodeID()
+	testRaftRequest(t, active, req)
+
+	req = logical.TestRequest(t, logical.ReadOperation, "sys/storage/raft/list-peers")
+	req.ClientToken = root
+	resp = testRaftRequest(t, active, req)
+	peers = resp.Data["peers"].([]map[string]interface{})
+	if len(peers) != 2 {
+		t.Fatalf("bad: %#v", peers)
+	}
+	for _, peer := range peers {
+		if peer["node_id"] == raft1.NodeID() {
+			t.Fatalf("peer not removed: %#v", peers)
+		}
+	}
+}
+
+func TestSystemBackend_raftStorageNotInUse(t *testing.T) {
+	c, b, root := testCoreSystemBackend(t)
+	if c.ha != nil {
+		t.Fatalf("expected no HA backend")
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "storage/raft/list-peers")
+	req.ClientToken = root
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
@@ -18,10 +18,14 @@ func TestSystemBackend_RootPaths(t *testing.T) {
 		"auth/*",
 		"remount",
 		"revoke-prefix/*",
+		"leases/lookup/*",
+		"leases/tidy",
 		"audit",
 		"audit/*",
 		"raw/*",
 		"rotate",
+		"storage/raft/*",
+		"namespaces/*",
 	}
 
 	b := testSystemBackend(t)
@@ -66,6 +70,14 @@ func TestSystemBackend_mounts(t *testing.T) {
 				"max_lease_ttl":     resp.Data["cubbyhole/"].(map[string]interface{})["config"].(map[string]interface{})["max_lease_ttl"].(int64),
 			},
 		},
+		"identity/": map[string]interface{}{
+			"description": "identity store",
+			"type":        "identity",
+			"config": map[string]interface{}{
+				"default_lease_ttl": resp.Data["identity/"].(map[string]interface{})["config"].(map[string]interface{})["default_lease_ttl"].(int64),
+				"max_lease_ttl":     resp.Data["identity/"].(map[string]interface{})["config"].(map[string]interface{})["max_lease_ttl"].(int64),
+			},
+		},
 	}
 	if !reflect.DeepEqual(resp.Data, exp) {
 		t.Fatalf("Got:\n%#v\nExpected:\n%#v", resp.Data, exp)
@@ -119,6 +131,16 @@ name = "test"
 path "foo/bar*" {
 	capabilities = ["create", "sudo", "update"]
 }
+path "foo/baz" {
+	capabilities = ["update"]
+	denied_parameters = {
+		"ttl" = []
+	}
+	max_wrapping_ttl = "1h"
+}
+path "foo/+/qux" {
+	capabilities = ["read", "list"]
+}
 path "sys/capabilities*" {
 	capabilities = ["update"]
 }
@@ -173,6 +195,58 @@ func testCapabilities(t *testing.T, endpoint string) {
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("bad: got\n%#v\nexpected\n%#v\n", actual, expected)
 	}
+	if _, ok := resp.Data["permissions"]; ok {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// The constraints of the path are returned along with the capabilities
+	req = logical.TestRequest(t, logical.UpdateOperation, endpoint)
+	req.Data["token"] = "tokenid"
+	req.Data["path"] = "foo/baz"
+
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expectedPermissions := map[string]interface{}{
+		"denied_parameters": map[string][]string{
+			"ttl": []string{},
+		},
+		"max_wrapping_ttl": int64(3600),
+	}
+	if !reflect.DeepEqual(resp.Data["permissions"], expectedPermissions) {
+		t.Fatalf("bad: got\n%#v\nexpected\n%#v\n", resp.Data["permissions"], expectedPermissions)
+	}
+
+	testSegmentWildcardCapabilities(t, b, endpoint, "token", "tokenid")
+}
+
+// testSegmentWildcardCapabilities checks the capabilities returned for the
+// paths matched by the '+' segment wildcard of capabilitiesPolicy
+func testSegmentWildcardCapabilities(t *testing.T, b logical.Backend, endpoint, field, value string) {
+	t.Helper()
+
+	tcases := map[string][]string{
+		"foo/baz/qux":     []string{"list", "read"},
+		"foo/baz/qux/foo": []string{"deny"},
+		"foo/qux":         []string{"deny"},
+
+		// The glob has as many wildcards and a longer prefix
+		"foo/bar/qux": []string{"create", "sudo", "update"},
+	}
+	for path, expected := range tcases {
+		req := logical.TestRequest(t, logical.UpdateOperation, endpoint)
+		req.Data[field] = value
+		req.Data["path"] = path
+
+		resp, err := b.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if actual := resp.Data["capabilities"]; !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("bad: path %q: got\n%#v\nexpected\n%#v\n", path, actual, expected)
+		}
+	}
 }
 
 func TestSystemBackend_CapabilitiesAccessor(t *testing.T) {
@@ -231,6 +305,8 @@ func TestSystemBackend_CapabilitiesAccessor(t *testing.T) {
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("bad: got\n%#v\nexpected\n%#v\n", actual, expected)
 	}
+
+	testSegmentWildcardCapabilities(t, b, "capabilities-accessor", "accessor", te.Accessor)
 }
 
 func TestSystemBackend_unmount_invalid(t *testing.T) {
@@ -524,10 +600,17 @@ func TestSystemBackend_authTable(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 
+	// The accessor is random, so simply check its format
+	accessor := resp.Data["token/"].(map[string]interface{})["accessor"].(string)
+	if !strings.HasPrefix(accessor, "auth_token_") {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
 	exp := map[string]interface{}{
 		"token/": map[string]interface{}{
 			"type":        "token",
 			"description": "token based credentials",
+			"accessor":    accessor,
 			"config": map[string]interface{}{
 				"default_lease_ttl": int64(0),
 				"max_lease_ttl":     int64(0),
@@ -0,0 +1,280 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// wrappingPaths returns the paths used to manage response-wrapping tokens
+func (b *SystemBackend) wrappingPaths() []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "wrapping/lookup$",
+
+			Fields: map[string]*framework.FieldSchema{
+				"token": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: strings.TrimSpace(sysHelp["wrapping_token"][0]),
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.handleWrappingLookup,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["wrapping-lookup"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["wrapping-lookup"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "wrapping/rewrap$",
+
+			Fields: map[string]*framework.FieldSchema{
+				"token": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: strings.TrimSpace(sysHelp["wrapping_token"][0]),
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.handleWrappingRewrap,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["wrapping-rewrap"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["wrapping-rewrap"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "wrapping/unwrap$",
+
+			Fields: map[string]*framework.FieldSchema{
+				"token": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: strings.TrimSpace(sysHelp["wrapping_token"][0]),
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.handleWrappingUnwrap,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["wrapping-unwrap"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["wrapping-unwrap"][1]),
+		},
+
+		&framework.Path{
+			Pattern: "wrapping/wrap$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.handleWrappingWrap,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["wrapping-wrap"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["wrapping-wrap"][1]),
+		},
+	}
+}
+
+// wrappingTokenEntry returns the entry of a valid response-wrapping token,
+// or an error response
+func (b *SystemBackend) wrappingTokenEntry(token string) (*TokenEntry, *logical.Response, error) {
+	if token == "" {
+		return nil, logical.ErrorResponse("missing token"), logical.ErrInvalidRequest
+	}
+
+	te, err := b.Core.tokenStore.Lookup(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if te == nil || len(te.Policies) != 1 || te.Policies[0] != cubbyholeResponseWrappingPolicyName {
+		return nil, logical.ErrorResponse("token is not a valid response-wrapping token"), logical.ErrInvalidRequest
+	}
+	return te, nil, nil
+}
+
+// readWrappedResponse returns the response stored in the cubbyhole of a
+// response-wrapping token, or an empty string if there is none
+func (b *SystemBackend) readWrappedResponse(token string) (string, error) {
+	cubbyResp, err := b.Core.router.Route(&logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "cubbyhole/response",
+		ClientToken: token,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read wrapped response: %v", err)
+	}
+	if cubbyResp == nil || cubbyResp.Data == nil {
+		return "", nil
+	}
+	if cubbyResp.IsError() {
+		return "", fmt.Errorf("failed to read wrapped response: %v", cubbyResp.Data["error"])
+	}
+
+	response, _ := cubbyResp.Data["response"].(string)
+	return response, nil
+}
+
+// consumeWrappingToken reads the response of a response-wrapping token
+// given in a request, then revokes the token. The use of the token is
+// recorded before reading, so that a response is only returned once.
+func (b *SystemBackend) consumeWrappingToken(te *TokenEntry) (string, error) {
+	te, err := b.Core.tokenStore.UseToken(te)
+	if err != nil {
+		return "", err
+	}
+	if te == nil {
+		return "", fmt.Errorf("token is not a valid response-wrapping token")
+	}
+
+	response, err := b.readWrappedResponse(te.ID)
+	if revokeErr := b.Core.tokenStore.Revoke(te.ID); revokeErr != nil {
+		b.Backend.Logger().Printf("[ERR] sys: failed to revoke response-wrapping token: %v", revokeErr)
+		if err == nil {
+			err = ErrInternalError
+		}
+	}
+	return response, err
+}
+
+// handleWrappingLookup returns the creation information of a
+// response-wrapping token, without unwrapping it
+func (b *SystemBackend) handleWrappingLookup(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	te, resp, err := b.wrappingTokenEntry(data.Get("token").(string))
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"creation_ttl":  int64(te.TTL.Seconds()),
+			"creation_time": time.Unix(te.CreationTime, 0).UTC().Format(time.RFC3339),
+			"creation_path": te.Path,
+		},
+	}, nil
+}
+
+// handleWrappingRewrap moves the response of a response-wrapping token to a
+// new token with the same TTL and creation path. The new token is created
+// before the old one is revoked, so that the response is never lost.
+func (b *SystemBackend) handleWrappingRewrap(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	te, resp, err := b.wrappingTokenEntry(data.Get("token").(string))
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	response, err := b.readWrappedResponse(te.ID)
+	if err != nil {
+		return nil, err
+	}
+	if response == "" {
+		return logical.ErrorResponse("no wrapped response found"), logical.ErrInvalidRequest
+	}
+
+	// Keep the accessor of a wrapped token
+	var wrapped logical.HTTPResponse
+	if err := json.Unmarshal([]byte(response), &wrapped); err != nil {
+		return nil, fmt.Errorf("failed to decode wrapped response: %v", err)
+	}
+	wrapInfo := &logical.WrapInfo{
+		TTL:          te.TTL,
+		CreationPath: te.Path,
+	}
+	if wrapped.Auth != nil {
+		wrapInfo.WrappedAccessor = wrapped.Auth.Accessor
+	}
+
+	// The response is stored as is, so that it is not nested in another
+	// response
+	newTE, err := b.Core.createWrappingToken(req.Path, wrapInfo)
+	if err != nil {
+		return nil, err
+	}
+	if resp, err := b.Core.storeWrappedResponse(newTE, response); resp != nil || err != nil {
+		return resp, err
+	}
+
+	// Use the old token only now, failing if it was used in the meantime
+	used, err := b.Core.tokenStore.UseToken(te)
+	if err != nil || used == nil {
+		if revokeErr := b.Core.tokenStore.Revoke(newTE.ID); revokeErr != nil {
+			b.Backend.Logger().Printf("[ERR] sys: failed to revoke response-wrapping token: %v", revokeErr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return logical.ErrorResponse("token is not a valid response-wrapping token"), logical.ErrInvalidRequest
+	}
+	if err := b.Core.tokenStore.Revoke(used.ID); err != nil {
+		// The old token can not be used anymore, so the new one is still
+		// returned
+		b.Backend.Logger().Printf("[ERR] sys: failed to revoke response-wrapping token: %v", err)
+	}
+
+	return &logical.Response{
+		WrapInfo: wrapInfo,
+	}, nil
+}
+
+// handleWrappingUnwrap returns the original response of a response-wrapping
+// token given in the request. Without a token in the request, the client
+// token is used, which is then revoked after the request as it only has a
+// single use.
+func (b *SystemBackend) handleWrappingUnwrap(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var response string
+	if token := data.Get("token").(string); token != "" {
+		te, resp, err := b.wrappingTokenEntry(token)
+		if resp != nil || err != nil {
+			return resp, err
+		}
+		response, err = b.consumeWrappingToken(te)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		response, err = b.readWrappedResponse(req.ClientToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if response == "" {
+		return logical.ErrorResponse("no wrapped response found"), logical.ErrInvalidRequest
+	}
+
+	// The original response is returned as is
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "application/json",
+			logical.HTTPRawBody:     []byte(response),
+			logical.HTTPStatusCode:  200,
+		},
+	}, nil
+}
+
+// handleWrappingWrap returns the data of the request, so that it is wrapped
+// in a response-wrapping token
+func (b *SystemBackend) handleWrappingWrap(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if req.WrapTTL == 0 {
+		return logical.ErrorResponse("wrapping TTL must be specified with the X-Vault-Wrap-TTL header"), logical.ErrInvalidRequest
+	}
+	if len(req.Data) == 0 {
+		return logical.ErrorResponse("missing data to wrap"), logical.ErrInvalidRequest
+	}
+
+	wrapData := make(map[string]interface{}, len(req.Data))
+	for k, v := range req.Data {
+		wrapData[k] = v
+	}
+	return &logical.Response{
+		Data: wrapData,
+	}, nil
+}
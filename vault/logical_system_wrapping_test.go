@@ -0,0 +1,202 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
+)
+
+// failingLeasePhysical fails the writes of leases, to make the registration
+// of tokens with the expiration manager fail
+type failingLeasePhysical struct {
+	physical.Backend
+}
+
+func (f *failingLeasePhysical) Put(entry *physical.Entry) error {
+	if strings.HasPrefix(entry.Key, systemBarrierPrefix+expirationSubPath) {
+		return fmt.Errorf("failed to write lease")
+	}
+	return f.Backend.Put(entry)
+}
+
+func testWrappingWrap(t *testing.T, c *Core, token string, data map[string]interface{}) string {
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/wrapping/wrap")
+	req.ClientToken = token
+	req.Data = data
+	req.WrapTTL = time.Minute
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.WrapInfo == nil || resp.WrapInfo.Token == "" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	if resp.WrapInfo.CreationPath != "sys/wrapping/wrap" {
+		t.Fatalf("bad: %#v", resp.WrapInfo)
+	}
+	return resp.WrapInfo.Token
+}
+
+func testWrappingRequest(t *testing.T, c *Core, token, path, wrappingToken string) (*logical.Response, error) {
+	req := logical.TestRequest(t, logical.UpdateOperation, path)
+	req.ClientToken = token
+	if wrappingToken != "" {
+		req.Data["token"] = wrappingToken
+	}
+	return c.HandleRequest(req)
+}
+
+func testWrappingUnwrapped(t *testing.T, resp *logical.Response) map[string]interface{} {
+	if resp == nil {
+		t.Fatalf("nil response")
+	}
+	body, ok := resp.Data[logical.HTTPRawBody].([]byte)
+	if !ok {
+		t.Fatalf("bad: %#v", resp)
+	}
+	var unwrapped logical.HTTPResponse
+	if err := json.Unmarshal(body, &unwrapped); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return unwrapped.Data
+}
+
+func TestSystemBackend_wrapping(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	// Wrapping requires a TTL
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/wrapping/wrap")
+	req.ClientToken = root
+	req.Data["foo"] = "bar"
+	if _, err := c.HandleRequest(req); err == nil || !errwrap.Contains(err, logical.ErrInvalidRequest.Error()) {
+		t.Fatalf("bad: %v", err)
+	}
+
+	token := testWrappingWrap(t, c, root, map[string]interface{}{"foo": "bar"})
+
+	// Lookup does not consume the token
+	for i := 0; i < 2; i++ {
+		resp, err := testWrappingRequest(t, c, root, "sys/wrapping/lookup", token)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if resp.Data["creation_ttl"] != int64(60) || resp.Data["creation_path"] != "sys/wrapping/wrap" {
+			t.Fatalf("bad: %#v", resp.Data)
+		}
+		if _, err := time.Parse(time.RFC3339, resp.Data["creation_time"].(string)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Only response-wrapping tokens can be looked up
+	if _, err := testWrappingRequest(t, c, root, "sys/wrapping/lookup", root); err == nil || !errwrap.Contains(err, logical.ErrInvalidRequest.Error()) {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Rewrapping revokes the token and keeps its properties
+	resp, err := testWrappingRequest(t, c, root, "sys/wrapping/rewrap", token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.WrapInfo == nil || resp.WrapInfo.Token == "" || resp.WrapInfo.Token == token {
+		t.Fatalf("bad: %#v", resp)
+	}
+	if resp.WrapInfo.TTL != time.Minute || resp.WrapInfo.CreationPath != "sys/wrapping/wrap" {
+		t.Fatalf("bad: %#v", resp.WrapInfo)
+	}
+	if _, err := testWrappingRequest(t, c, root, "sys/wrapping/lookup", token); err == nil || !errwrap.Contains(err, logical.ErrInvalidRequest.Error()) {
+		t.Fatalf("bad: %v", err)
+	}
+	token = resp.WrapInfo.Token
+
+	resp, err = testWrappingRequest(t, c, root, "sys/wrapping/lookup", token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["creation_path"] != "sys/wrapping/wrap" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Unwrapping returns the original data, only once
+	resp, err = testWrappingRequest(t, c, root, "sys/wrapping/unwrap", token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if data := testWrappingUnwrapped(t, resp); data["foo"] != "bar" {
+		t.Fatalf("bad: %#v", data)
+	}
+	if _, err := testWrappingRequest(t, c, root, "sys/wrapping/unwrap", token); err == nil || !errwrap.Contains(err, logical.ErrInvalidRequest.Error()) {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// The wrapping token can unwrap itself
+	token = testWrappingWrap(t, c, root, map[string]interface{}{"foo": "baz"})
+	resp, err = testWrappingRequest(t, c, token, "sys/wrapping/unwrap", "")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if data := testWrappingUnwrapped(t, resp); data["foo"] != "baz" {
+		t.Fatalf("bad: %#v", data)
+	}
+	if _, err := testWrappingRequest(t, c, token, "sys/wrapping/unwrap", ""); err == nil || !errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestSystemBackend_wrappingRewrapFailure(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	token := testWrappingWrap(t, c, root, map[string]interface{}{"foo": "bar"})
+
+	// A failed rewrap keeps the original token
+	barrier := c.barrier.(*AESGCMBarrier)
+	backend := barrier.backend
+	barrier.backend = &failingLeasePhysical{Backend: backend}
+	if _, err := testWrappingRequest(t, c, root, "sys/wrapping/rewrap", token); err == nil {
+		t.Fatalf("expected error")
+	}
+	barrier.backend = backend
+
+	resp, err := testWrappingRequest(t, c, root, "sys/wrapping/unwrap", token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if data := testWrappingUnwrapped(t, resp); data["foo"] != "bar" {
+		t.Fatalf("bad: %#v", data)
+	}
+}
+
+func TestSystemBackend_wrappingCreationPath(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "secret/foo")
+	req.ClientToken = root
+	req.Data["value"] = "bar"
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "secret/foo")
+	req.ClientToken = root
+	req.WrapTTL = time.Minute
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.WrapInfo == nil || resp.WrapInfo.CreationPath != "secret/foo" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	resp, err = testWrappingRequest(t, c, root, "sys/wrapping/lookup", resp.WrapInfo.Token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["creation_path"] != "secret/foo" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
@@ -41,12 +41,14 @@ var (
 		"auth/",
 		"sys/",
 		"cubbyhole/",
+		"identity/",
 	}
 
 	untunableMounts = []string{
 		"cubbyhole/",
 		"sys/",
 		"audit/",
+		"identity/",
 	}
 
 	// singletonMounts can only exist in one location and are
@@ -54,6 +56,7 @@ var (
 	singletonMounts = []string{
 		"cubbyhole",
 		"system",
+		"identity",
 	}
 )
 
@@ -88,22 +91,22 @@ func (t *MountTable) Hash() ([]byte, error) {
 	return hash[:], nil
 }
 
-// Find is used to lookup an entry
-func (t *MountTable) Find(path string) *MountEntry {
+// Find is used to lookup an entry of a namespace
+func (t *MountTable) Find(namespaceID, path string) *MountEntry {
 	n := len(t.Entries)
 	for i := 0; i < n; i++ {
-		if t.Entries[i].Path == path {
+		if t.Entries[i].NamespaceID == namespaceID && t.Entries[i].Path == path {
 			return t.Entries[i]
 		}
 	}
 	return nil
 }
 
-// SetTaint is used to set the taint on given entry
-func (t *MountTable) SetTaint(path string, value bool) bool {
+// SetTaint is used to set the taint on given entry of a namespace
+func (t *MountTable) SetTaint(namespaceID, path string, value bool) bool {
 	n := len(t.Entries)
 	for i := 0; i < n; i++ {
-		if t.Entries[i].Path == path {
+		if t.Entries[i].NamespaceID == namespaceID && t.Entries[i].Path == path {
 			t.Entries[i].Tainted = value
 			return true
 		}
@@ -111,11 +114,11 @@ func (t *MountTable) SetTaint(path string, value bool) bool {
 	return false
 }
 
-// Remove is used to remove a given path entry
-func (t *MountTable) Remove(path string) bool {
+// Remove is used to remove a given path entry of a namespace
+func (t *MountTable) Remove(namespaceID, path string) bool {
 	n := len(t.Entries)
 	for i := 0; i < n; i++ {
-		if t.Entries[i].Path == path {
+		if t.Entries[i].NamespaceID == namespaceID && t.Entries[i].Path == path {
 			t.Entries[i], t.Entries[n-1] = t.Entries[n-1], nil
 			t.Entries = t.Entries[:n-1]
 			return true
@@ -126,14 +129,19 @@ func (t *MountTable) Remove(path string) bool {
 
 // MountEntry is used to represent a mount table entry
 type MountEntry struct {
-	Table       string            `json:"table"`             // The table it belongs to
-	Path        string            `json:"path"`              // Mount Path
-	Type        string            `json:"type"`              // Logical backend Type
-	Description string            `json:"description"`       // User-provided description
-	UUID        string            `json:"uuid"`              // Barrier view UUID
-	Config      MountConfig       `json:"config"`            // Configuration related to this mount (but not backend-derived)
-	Options     map[string]string `json:"options"`           // Backend options
-	Tainted     bool              `json:"tainted,omitempty"` // Set as a Write-Ahead flag for unmount/remount
+	Table       string            `json:"table"`                  // The table it belongs to
+	Path        string            `json:"path"`                   // Mount Path
+	Type        string            `json:"type"`                   // Logical backend Type
+	Description string            `json:"description"`            // User-provided description
+	UUID        string            `json:"uuid"`                   // Barrier view UUID
+	Config      MountConfig       `json:"config"`                 // Configuration related to this mount (but not backend-derived)
+	Options     map[string]string `json:"options"`                // Backend options
+	Tainted     bool              `json:"tainted,omitempty"`      // Set as a Write-Ahead flag for unmount/remount
+	Accessor    string            `json:"accessor"`               // Identifier of a credential backend, used by identity aliases
+	NamespaceID string            `json:"namespace_id,omitempty"` // Namespace of the entry, empty for the root namespace
+
+	// namespace is the namespace of the entry, set once it is mounted
+	namespace *Namespace
 }
 
 // MountConfig is used to hold settable options
@@ -156,9 +164,25 @@ func (e *MountEntry) Clone() *MountEntry {
 		UUID:        e.UUID,
 		Config:      e.Config,
 		Options:     optClone,
+		Accessor:    e.Accessor,
+		NamespaceID: e.NamespaceID,
+		namespace:   e.namespace,
 	}
 }
 
+// routePath returns the path the entry is mounted at in the router, which
+// is prefixed with the path of its namespace
+func (e *MountEntry) routePath() string {
+	path := e.Path
+	if e.Table == credentialTableType {
+		path = credentialRoutePrefix + path
+	}
+	if e.namespace != nil {
+		path = e.namespace.Path + path
+	}
+	return path
+}
+
 // Mount is used to mount a new backend to the mount table.
 func (c *Core) mount(me *MountEntry) error {
 	// Ensure we end the path in a slash
@@ -180,10 +204,21 @@ func (c *Core) mount(me *MountEntry) error {
 		}
 	}
 
-	// Verify there is no conflicting mount
-	if match := c.router.MatchingMount(me.Path); match != "" {
+	// Resolve the namespace of the mount
+	ns := c.namespaceByID(me.NamespaceID)
+	if ns == nil {
+		return fmt.Errorf("namespace %q not found", me.NamespaceID)
+	}
+	me.namespace = ns
+	path := me.routePath()
+
+	// Verify there is no conflicting mount or child namespace
+	if match := c.router.MatchingMount(path); match != "" {
 		return logical.CodedError(409, fmt.Sprintf("existing mount at %s", match))
 	}
+	if other := c.namespaceForPath(path); other.ID != ns.ID {
+		return logical.CodedError(409, fmt.Sprintf("existing namespace at %s", other.Path))
+	}
 
 	c.mountsLock.Lock()
 	defer c.mountsLock.Unlock()
@@ -194,7 +229,7 @@ func (c *Core) mount(me *MountEntry) error {
 		return err
 	}
 	me.UUID = meUUID
-	view := NewBarrierView(c.barrier, backendBarrierPrefix+me.UUID+"/")
+	view := NewBarrierView(c.barrier, ns.barrierPrefix()+backendBarrierPrefix+me.UUID+"/")
 
 	backend, err := c.newLogicalBackend(me.Type, c.mountEntrySysView(me), view, nil)
 	if err != nil {
@@ -210,15 +245,15 @@ func (c *Core) mount(me *MountEntry) error {
 	c.mounts = newTable
 
 	// Mount the backend
-	if err := c.router.Mount(backend, me.Path, me, view); err != nil {
+	if err := c.router.Mount(backend, path, me, view); err != nil {
 		return err
 	}
-	c.logger.Printf("[INFO] core: mounted '%s' type: %s", me.Path, me.Type)
+	c.logger.Printf("[INFO] core: mounted '%s' type: %s", path, me.Type)
 	return nil
 }
 
-// Unmount is used to unmount a path.
-func (c *Core) unmount(path string) error {
+// Unmount is used to unmount a path of a namespace.
+func (c *Core) unmount(ns *Namespace, path string) error {
 	// Ensure we end the path in a slash
 	if !strings.HasSuffix(path, "/") {
 		path += "/"
@@ -231,40 +266,40 @@ func (c *Core) unmount(path string) error {
 		}
 	}
 
-	// Verify exact match of the route
-	match := c.router.MatchingMount(path)
-	if match == "" || path != match {
+	// Verify exact match of the route, within the namespace
+	route := ns.Path + path
+	if !c.namespaceMountMatch(ns, route) {
 		return fmt.Errorf("no matching mount")
 	}
 
 	// Store the view for this backend
-	view := c.router.MatchingStorageView(path)
+	view := c.router.MatchingStorageView(route)
 
 	c.mountsLock.Lock()
 	defer c.mountsLock.Unlock()
 
 	// Mark the entry as tainted
-	if err := c.taintMountEntry(path); err != nil {
+	if err := c.taintMountEntry(ns.ID, path); err != nil {
 		return err
 	}
 
 	// Taint the router path to prevent routing
-	if err := c.router.Taint(path); err != nil {
+	if err := c.router.Taint(route); err != nil {
 		return err
 	}
 
 	// Invoke the rollback manager a final time
-	if err := c.rollback.Rollback(path); err != nil {
+	if err := c.rollback.Rollback(route); err != nil {
 		return err
 	}
 
 	// Revoke all the dynamic keys
-	if err := c.expiration.RevokePrefix(path); err != nil {
+	if err := c.expiration.RevokePrefix(route); err != nil {
 		return err
 	}
 
 	// Unmount the backend entirely
-	if err := c.router.Unmount(path); err != nil {
+	if err := c.router.Unmount(route); err != nil {
 		return err
 	}
 
@@ -274,18 +309,28 @@ func (c *Core) unmount(path string) error {
 	}
 
 	// Remove the mount table entry
-	if err := c.removeMountEntry(path); err != nil {
+	if err := c.removeMountEntry(ns.ID, path); err != nil {
 		return err
 	}
-	c.logger.Printf("[INFO] core: unmounted '%s'", path)
+	c.logger.Printf("[INFO] core: unmounted '%s'", route)
 	return nil
 }
 
+// namespaceMountMatch checks if a backend of the given namespace is mounted
+// exactly at a route
+func (c *Core) namespaceMountMatch(ns *Namespace, route string) bool {
+	if match := c.router.MatchingMount(route); match == "" || route != match {
+		return false
+	}
+	entry := c.router.MatchingMountEntry(route)
+	return entry == nil || entry.NamespaceID == ns.ID
+}
+
 // removeMountEntry is used to remove an entry from the mount table
-func (c *Core) removeMountEntry(path string) error {
+func (c *Core) removeMountEntry(namespaceID, path string) error {
 	// Remove the entry from the mount table
 	newTable := c.mounts.ShallowClone()
-	newTable.Remove(path)
+	newTable.Remove(namespaceID, path)
 
 	// Update the mount table
 	if err := c.persistMounts(newTable); err != nil {
@@ -297,10 +342,10 @@ func (c *Core) removeMountEntry(path string) error {
 }
 
 // taintMountEntry is used to mark an entry in the mount table as tainted
-func (c *Core) taintMountEntry(path string) error {
+func (c *Core) taintMountEntry(namespaceID, path string) error {
 	// As modifying the taint of an entry affects shallow clones,
 	// we simply use the original
-	c.mounts.SetTaint(path, true)
+	c.mounts.SetTaint(namespaceID, path, true)
 
 	// Update the mount table
 	if err := c.persistMounts(c.mounts); err != nil {
@@ -310,8 +355,9 @@ func (c *Core) taintMountEntry(path string) error {
 	return nil
 }
 
-// Remount is used to remount a path at a new mount point.
-func (c *Core) remount(src, dst string) error {
+// Remount is used to remount a path of a namespace at a new mount point
+// within the namespace.
+func (c *Core) remount(ns *Namespace, src, dst string) error {
 	// Ensure we end the path in a slash
 	if !strings.HasSuffix(src, "/") {
 		src += "/"
@@ -327,42 +373,45 @@ func (c *Core) remount(src, dst string) error {
 		}
 	}
 
-	// Verify exact match of the route
-	match := c.router.MatchingMount(src)
-	if match == "" || src != match {
-		return fmt.Errorf("no matching mount at '%s'", src)
+	// Verify exact match of the route, within the namespace
+	srcRoute, dstRoute := ns.Path+src, ns.Path+dst
+	if !c.namespaceMountMatch(ns, srcRoute) {
+		return fmt.Errorf("no matching mount at '%s'", srcRoute)
 	}
 
-	if match := c.router.MatchingMount(dst); match != "" {
+	if match := c.router.MatchingMount(dstRoute); match != "" {
 		return fmt.Errorf("existing mount at '%s'", match)
 	}
+	if other := c.namespaceForPath(dstRoute); other.ID != ns.ID {
+		return fmt.Errorf("existing namespace at '%s'", other.Path)
+	}
 
 	c.mountsLock.Lock()
 	defer c.mountsLock.Unlock()
 
 	// Mark the entry as tainted
-	if err := c.taintMountEntry(src); err != nil {
+	if err := c.taintMountEntry(ns.ID, src); err != nil {
 		return err
 	}
 
 	// Taint the router path to prevent routing
-	if err := c.router.Taint(src); err != nil {
+	if err := c.router.Taint(srcRoute); err != nil {
 		return err
 	}
 
 	// Invoke the rollback manager a final time
-	if err := c.rollback.Rollback(src); err != nil {
+	if err := c.rollback.Rollback(srcRoute); err != nil {
 		return err
 	}
 
 	// Revoke all the dynamic keys
-	if err := c.expiration.RevokePrefix(src); err != nil {
+	if err := c.expiration.RevokePrefix(srcRoute); err != nil {
 		return err
 	}
 
 	var ent *MountEntry
 	for _, ent = range c.mounts.Entries {
-		if ent.Path == src {
+		if ent.NamespaceID == ns.ID && ent.Path == src {
 			ent.Path = dst
 			ent.Tainted = false
 			break
@@ -377,16 +426,16 @@ func (c *Core) remount(src, dst string) error {
 	}
 
 	// Remount the backend
-	if err := c.router.Remount(src, dst); err != nil {
+	if err := c.router.Remount(srcRoute, dstRoute); err != nil {
 		return err
 	}
 
 	// Un-taint the path
-	if err := c.router.Untaint(dst); err != nil {
+	if err := c.router.Untaint(dstRoute); err != nil {
 		return err
 	}
 
-	c.logger.Printf("[INFO] core: remounted '%s' to '%s'", src, dst)
+	c.logger.Printf("[INFO] core: remounted '%s' to '%s'", srcRoute, dstRoute)
 	return nil
 }
 
@@ -427,7 +476,7 @@ func (c *Core) loadMounts() error {
 		for _, requiredMount := range requiredMountTable().Entries {
 			foundRequired := false
 			for _, coreMount := range c.mounts.Entries {
-				if coreMount.Type == requiredMount.Type {
+				if coreMount.NamespaceID == rootNamespaceID && coreMount.Type == requiredMount.Type {
 					foundRequired = true
 					break
 				}
@@ -438,6 +487,26 @@ func (c *Core) loadMounts() error {
 			}
 		}
 
+		// Upgrade the namespaces created without a cubbyhole
+		for _, ns := range c.namespaceEntries() {
+			foundCubbyhole := false
+			for _, coreMount := range c.mounts.Entries {
+				if coreMount.NamespaceID == ns.ID && coreMount.Type == "cubbyhole" {
+					foundCubbyhole = true
+					break
+				}
+			}
+			if !foundCubbyhole {
+				entry, err := namespaceCubbyholeEntry(ns)
+				if err != nil {
+					c.logger.Printf("[ERR] core: failed to create cubbyhole entry of namespace %s: %v", ns.Path, err)
+					return errLoadMountsFailed
+				}
+				c.mounts.Entries = append(c.mounts.Entries, entry)
+				needPersist = true
+			}
+		}
+
 		// Upgrade to table-scoped entries
 		for _, entry := range c.mounts.Entries {
 			if entry.Table == "" {
@@ -514,11 +583,29 @@ func (c *Core) setupMounts() error {
 	var view *BarrierView
 	var err error
 
+	var namespaceCubbyholeEntries []*MountEntry
 	for _, entry := range c.mounts.Entries {
+		// Resolve the namespace of the entry, whose storage is prefixed
+		// with the one of the namespace
+		entry.namespace = c.namespaceByID(entry.NamespaceID)
+		if entry.namespace == nil {
+			c.logger.Printf(
+				"[ERR] core: namespace %s of mount entry %s not found",
+				entry.NamespaceID, entry.Path)
+			return errLoadMountsFailed
+		}
+
+		// The cubbyhole backend of the root namespace is shared by the
+		// other namespaces, so their entries are mounted once it is set up
+		if entry.Type == "cubbyhole" && entry.NamespaceID != rootNamespaceID {
+			namespaceCubbyholeEntries = append(namespaceCubbyholeEntries, entry)
+			continue
+		}
+
 		// Initialize the backend, special casing for system
-		barrierPath := backendBarrierPrefix + entry.UUID + "/"
+		barrierPath := entry.namespace.barrierPrefix() + backendBarrierPrefix + entry.UUID + "/"
 		if entry.Type == "system" {
-			barrierPath = systemBarrierPrefix
+			barrierPath = entry.namespace.barrierPrefix() + systemBarrierPrefix
 		}
 
 		// Create a barrier view using the UUID
@@ -526,35 +613,47 @@ func (c *Core) setupMounts() error {
 
 		// Initialize the backend
 		// Create the new backend
-		backend, err = c.newLogicalBackend(entry.Type, c.mountEntrySysView(entry), view, nil)
+		backend, err = c.newMountBackend(entry, view)
 		if err != nil {
 			c.logger.Printf(
 				"[ERR] core: failed to create mount entry %s: %v",
-				entry.Path, err)
+				entry.routePath(), err)
 			return errLoadMountsFailed
 		}
 
-		switch entry.Type {
-		case "system":
+		// The builtin backends of the root namespace are used by the core
+		switch {
+		case entry.NamespaceID != rootNamespaceID:
+		case entry.Type == "system":
 			c.systemBarrierView = view
-		case "cubbyhole":
+		case entry.Type == "cubbyhole":
 			ch := backend.(*CubbyholeBackend)
 			ch.saltUUID = entry.UUID
 			ch.storageView = view
+		case entry.Type == "identity":
+			c.identityStore = backend.(*IdentityStore)
 		}
 
 		// Mount the backend
-		err = c.router.Mount(backend, entry.Path, entry, view)
+		path := entry.routePath()
+		err = c.router.Mount(backend, path, entry, view)
 		if err != nil {
-			c.logger.Printf("[ERR] core: failed to mount entry %s: %v", entry.Path, err)
+			c.logger.Printf("[ERR] core: failed to mount entry %s: %v", path, err)
 			return errLoadMountsFailed
 		} else {
-			c.logger.Printf("[INFO] core: mounted backend of type %s at %s", entry.Type, entry.Path)
+			c.logger.Printf("[INFO] core: mounted backend of type %s at %s", entry.Type, path)
 		}
 
 		// Ensure the path is tainted if set in the mount table
 		if entry.Tainted {
-			c.router.Taint(entry.Path)
+			c.router.Taint(path)
+		}
+	}
+
+	for _, entry := range namespaceCubbyholeEntries {
+		if err := c.mountNamespaceCubbyhole(entry); err != nil {
+			c.logger.Printf("[ERR] core: failed to mount entry %s: %v", entry.routePath(), err)
+			return errLoadMountsFailed
 		}
 	}
 	return nil
@@ -569,7 +668,7 @@ func (c *Core) unloadMounts() error {
 	if c.mounts != nil {
 		mountTable := c.mounts.ShallowClone()
 		for _, e := range mountTable.Entries {
-			prefix := e.Path
+			prefix := e.routePath()
 			b, ok := c.router.root.Get(prefix)
 			if ok {
 				b.(*routeEntry).backend.Cleanup()
@@ -580,6 +679,7 @@ func (c *Core) unloadMounts() error {
 	c.mounts = nil
 	c.router = NewRouter()
 	c.systemBarrierView = nil
+	c.identityStore = nil
 	return nil
 }
 
@@ -604,6 +704,22 @@ func (c *Core) newLogicalBackend(t string, sysView logical.SystemView, view logi
 	return b, nil
 }
 
+// newMountBackend creates the backend of a mount table entry. The system
+// backends of the namespaces other than the root namespace are limited to
+// their namespace.
+func (c *Core) newMountBackend(entry *MountEntry, view logical.Storage) (logical.Backend, error) {
+	if entry.Type != "system" || entry.NamespaceID == rootNamespaceID {
+		return c.newLogicalBackend(entry.Type, c.mountEntrySysView(entry), view, nil)
+	}
+
+	config := &logical.BackendConfig{
+		StorageView: view,
+		Logger:      c.logger,
+		System:      c.mountEntrySysView(entry),
+	}
+	return NewNamespaceSystemBackend(c, entry.namespace, config), nil
+}
+
 // mountEntrySysView creates a logical.SystemView from global and
 // mount-specific entries; because this should be called when setting
 // up a mountEntry, it doesn't check to ensure that me is not nil
@@ -664,7 +780,19 @@ func requiredMountTable() *MountTable {
 		Description: "system endpoints used for control, policy and debugging",
 		UUID:        sysUUID,
 	}
+	identityUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		panic(fmt.Sprintf("could not create identity UUID: %v", err))
+	}
+	identityMount := &MountEntry{
+		Table:       mountTableType,
+		Path:        "identity/",
+		Type:        "identity",
+		Description: "identity store",
+		UUID:        identityUUID,
+	}
 	table.Entries = append(table.Entries, cubbyholeMount)
 	table.Entries = append(table.Entries, sysMount)
+	table.Entries = append(table.Entries, identityMount)
 	return table
 }
@@ -78,7 +78,7 @@ func TestCore_Mount(t *testing.T) {
 
 func TestCore_Unmount(t *testing.T) {
 	c, key, _ := TestCoreUnsealed(t)
-	err := c.unmount("secret")
+	err := c.unmount(rootNamespace, "secret")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -167,7 +167,7 @@ func TestCore_Unmount_Cleanup(t *testing.T) {
 	}
 
 	// Unmount, this should cleanup
-	if err := c.unmount("test/"); err != nil {
+	if err := c.unmount(rootNamespace, "test/"); err != nil {
 		t.Fatalf("err: %v", err)
 	}
 
@@ -196,7 +196,7 @@ func TestCore_Unmount_Cleanup(t *testing.T) {
 
 func TestCore_Remount(t *testing.T) {
 	c, key, _ := TestCoreUnsealed(t)
-	err := c.remount("secret", "foo")
+	err := c.remount(rootNamespace, "secret", "foo")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -285,7 +285,7 @@ func TestCore_Remount_Cleanup(t *testing.T) {
 	}
 
 	// Remount, this should cleanup
-	if err := c.remount("test/", "new/"); err != nil {
+	if err := c.remount(rootNamespace, "test/", "new/"); err != nil {
 		t.Fatalf("err: %v", err)
 	}
 
@@ -314,7 +314,7 @@ func TestCore_Remount_Cleanup(t *testing.T) {
 
 func TestCore_Remount_Protected(t *testing.T) {
 	c, _, _ := TestCoreUnsealed(t)
-	err := c.remount("sys", "foo")
+	err := c.remount(rootNamespace, "sys", "foo")
 	if err.Error() != "cannot remount 'sys/'" {
 		t.Fatalf("err: %v", err)
 	}
@@ -463,7 +463,7 @@ func testCore_MountTable_UpgradeToTyped_Common(
 }
 
 func verifyDefaultTable(t *testing.T, table *MountTable) {
-	if len(table.Entries) != 3 {
+	if len(table.Entries) != 4 {
 		t.Fatalf("bad: %v", table.Entries)
 	}
 	for idx, entry := range table.Entries {
@@ -489,6 +489,13 @@ func verifyDefaultTable(t *testing.T, table *MountTable) {
 			if entry.Type != "system" {
 				t.Fatalf("bad: %v", entry)
 			}
+		case 3:
+			if entry.Path != "identity/" {
+				t.Fatalf("bad: %v", entry)
+			}
+			if entry.Type != "identity" {
+				t.Fatalf("bad: %v", entry)
+			}
 		}
 		if entry.Table != mountTableType {
 			t.Fatalf("bad: %v", entry)
@@ -0,0 +1,575 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/armon/go-radix"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	// coreNamespaceConfigPath is used to store the namespace table.
+	// Namespaces are protected within the Vault itself, which means they
+	// can only be viewed or modified after an unseal.
+	coreNamespaceConfigPath = "core/namespaces"
+
+	// namespaceBarrierPrefix is the prefix to the ID used in the barrier
+	// view for the storage of a namespace. The mounts, auth backends and
+	// policies of a namespace are stored below it.
+	namespaceBarrierPrefix = "namespaces/"
+
+	// rootNamespaceID is the ID of the root namespace
+	rootNamespaceID = ""
+)
+
+var (
+	// errLoadNamespacesFailed if loadNamespaces encounters an error
+	errLoadNamespacesFailed = errors.New("failed to setup namespace table")
+
+	// rootNamespace is the namespace all other namespaces descend from.
+	// Its path is empty, so that its requests are not prefixed.
+	rootNamespace = &Namespace{
+		ID: rootNamespaceID,
+	}
+
+	// namespaceNameRegex is the format of the name of a namespace, which is
+	// a single path segment
+	namespaceNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+)
+
+// Namespace is an isolated part of Vault with its own mounts, auth backends,
+// policies and tokens. Its requests are the ones whose paths are prefixed
+// with the path of the namespace.
+type Namespace struct {
+	ID       string `json:"id"`        // Identifier, used for the barrier view
+	Path     string `json:"path"`      // Full path, ending in a slash
+	ParentID string `json:"parent_id"` // Namespace the namespace was created in
+}
+
+// NamespaceTable is used to represent the internal namespace table
+type NamespaceTable struct {
+	Entries []*Namespace `json:"entries"`
+}
+
+// barrierPrefix returns the prefix of the barrier view of the storage of
+// the namespace
+func (n *Namespace) barrierPrefix() string {
+	if n.ID == rootNamespaceID {
+		return ""
+	}
+	return namespaceBarrierPrefix + n.ID + "/"
+}
+
+// contains checks if a namespace is the namespace itself or one of its
+// descendants
+func (n *Namespace) contains(other *Namespace) bool {
+	return other != nil && strings.HasPrefix(other.Path, n.Path)
+}
+
+// loadNamespaces is invoked as part of postUnseal to load the namespace table
+func (c *Core) loadNamespaces() error {
+	table := &NamespaceTable{}
+	raw, err := c.barrier.Get(coreNamespaceConfigPath)
+	if err != nil {
+		c.logger.Printf("[ERR] core: failed to read namespace table: %v", err)
+		return errLoadNamespacesFailed
+	}
+	if raw != nil {
+		if err := json.Unmarshal(raw.Value, table); err != nil {
+			c.logger.Printf("[ERR] core: failed to decode namespace table: %v", err)
+			return errLoadNamespacesFailed
+		}
+	}
+
+	c.namespaceLock.Lock()
+	defer c.namespaceLock.Unlock()
+
+	c.setNamespaces(table)
+	return nil
+}
+
+// persistNamespaces is used to persist the namespace table after modification
+func (c *Core) persistNamespaces(table *NamespaceTable) error {
+	raw, err := json.Marshal(table)
+	if err != nil {
+		c.logger.Printf("[ERR] core: failed to encode namespace table: %v", err)
+		return err
+	}
+
+	entry := &Entry{
+		Key:   coreNamespaceConfigPath,
+		Value: raw,
+	}
+	if err := c.barrier.Put(entry); err != nil {
+		c.logger.Printf("[ERR] core: failed to persist namespace table: %v", err)
+		return err
+	}
+	return nil
+}
+
+// setNamespaces replaces the namespace table and the index of the paths of
+// the namespaces. This must be called with the namespaceLock held.
+func (c *Core) setNamespaces(table *NamespaceTable) {
+	paths := radix.New()
+	for _, ns := range table.Entries {
+		paths.Insert(ns.Path, ns)
+	}
+	c.namespaces = table
+	c.namespacePaths = paths
+}
+
+// teardownNamespaces is used before we seal the vault to reset the
+// namespaces to their unloaded state. This is reversed by loadNamespaces.
+func (c *Core) teardownNamespaces() error {
+	c.namespaceLock.Lock()
+	defer c.namespaceLock.Unlock()
+
+	c.namespaces = nil
+	c.namespacePaths = nil
+	c.namespacePolicyStores = nil
+	return nil
+}
+
+// namespaceByID returns the namespace with the given ID, or nil if there is
+// none
+func (c *Core) namespaceByID(id string) *Namespace {
+	if id == rootNamespaceID {
+		return rootNamespace
+	}
+
+	c.namespaceLock.RLock()
+	defer c.namespaceLock.RUnlock()
+
+	if c.namespaces == nil {
+		return nil
+	}
+	for _, ns := range c.namespaces.Entries {
+		if ns.ID == id {
+			return ns
+		}
+	}
+	return nil
+}
+
+// namespaceForPath returns the namespace a request path belongs to, which
+// is the namespace with the longest path prefixing it
+func (c *Core) namespaceForPath(path string) *Namespace {
+	c.namespaceLock.RLock()
+	defer c.namespaceLock.RUnlock()
+
+	if c.namespacePaths == nil {
+		return rootNamespace
+	}
+	_, raw, ok := c.namespacePaths.LongestPrefix(path)
+	if !ok {
+		return rootNamespace
+	}
+	return raw.(*Namespace)
+}
+
+// SplitNamespacePath splits a request path into the path of the namespace
+// it belongs to, which is empty for the root namespace, and the path within
+// that namespace
+func (c *Core) SplitNamespacePath(path string) (string, string) {
+	ns := c.namespaceForPath(path)
+	return ns.Path, strings.TrimPrefix(path, ns.Path)
+}
+
+// childNamespaces returns the namespaces created in the given namespace
+func (c *Core) childNamespaces(parent *Namespace) []*Namespace {
+	c.namespaceLock.RLock()
+	defer c.namespaceLock.RUnlock()
+
+	var children []*Namespace
+	if c.namespaces == nil {
+		return children
+	}
+	for _, ns := range c.namespaces.Entries {
+		if ns.ParentID == parent.ID {
+			children = append(children, ns)
+		}
+	}
+	return children
+}
+
+// namespaceEntries returns the namespaces other than the root namespace
+func (c *Core) namespaceEntries() []*Namespace {
+	c.namespaceLock.RLock()
+	defer c.namespaceLock.RUnlock()
+
+	if c.namespaces == nil {
+		return nil
+	}
+	return c.namespaces.Entries
+}
+
+// namespacePolicyStore returns the policy store of a namespace, or nil if
+// the namespace does not exist
+func (c *Core) namespacePolicyStore(id string) *PolicyStore {
+	if id == rootNamespaceID {
+		return c.policyStore
+	}
+
+	c.namespaceLock.RLock()
+	defer c.namespaceLock.RUnlock()
+	return c.namespacePolicyStores[id]
+}
+
+// setupNamespacePolicyStore creates the policy store of a namespace, along
+// with its default policy. This must be called with the namespaceLock held.
+func (c *Core) setupNamespacePolicyStore(ns *Namespace) error {
+	view := NewBarrierView(c.barrier, ns.barrierPrefix()+systemBarrierPrefix+policySubPath)
+	ps := NewPolicyStore(view, &dynamicSystemView{core: c})
+
+	policy, err := ps.GetPolicy("default")
+	if err != nil {
+		return fmt.Errorf("error fetching default policy of namespace %s: %v", ns.Path, err)
+	}
+	if policy == nil {
+		if err := ps.createDefaultPolicy(); err != nil {
+			return err
+		}
+	} else if err := ps.upgradeDefaultPolicy(); err != nil {
+		return err
+	}
+
+	if c.namespacePolicyStores == nil {
+		c.namespacePolicyStores = make(map[string]*PolicyStore)
+	}
+	c.namespacePolicyStores[ns.ID] = ps
+	return nil
+}
+
+// tokenNamespacePath returns a request path relative to the namespace of a
+// token. A token cannot be used outside of its namespace and of the
+// namespaces below it, nor once its namespace is deleted, in which case
+// false is returned.
+func (c *Core) tokenNamespacePath(te *TokenEntry, path string) (string, bool) {
+	ns := c.namespaceByID(te.NamespaceID)
+	if ns == nil || !strings.HasPrefix(path, ns.Path) {
+		return "", false
+	}
+	return strings.TrimPrefix(path, ns.Path), true
+}
+
+// createNamespace creates a namespace in the given parent namespace, along
+// with its policy store, system backend and token store mount
+func (c *Core) createNamespace(parent *Namespace, name string) (*Namespace, error) {
+	if !namespaceNameRegex.MatchString(name) {
+		return nil, fmt.Errorf("invalid namespace name '%s'", name)
+	}
+
+	// The paths of protected mounts are used by every namespace
+	path := parent.Path + name + "/"
+	for _, p := range protectedMounts {
+		if name+"/" == p {
+			return nil, logical.CodedError(403, fmt.Sprintf("cannot create namespace '%s'", path))
+		}
+	}
+
+	// Verify there is no conflicting namespace or mount, at or below the
+	// path
+	if c.namespaceForPath(path).Path == path {
+		return nil, logical.CodedError(409, fmt.Sprintf("existing namespace at %s", path))
+	}
+	if match := c.router.MatchingMount(path); match != "" {
+		return nil, logical.CodedError(409, fmt.Sprintf("existing mount at %s", match))
+	}
+	if match := c.mountBelow(path); match != "" {
+		return nil, logical.CodedError(409, fmt.Sprintf("existing mount at %s", match))
+	}
+
+	nsID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	ns := &Namespace{
+		ID:       nsID,
+		Path:     path,
+		ParentID: parent.ID,
+	}
+
+	c.namespaceLock.Lock()
+	if c.namespaces == nil {
+		c.namespaceLock.Unlock()
+		return nil, fmt.Errorf("namespace table is not loaded")
+	}
+	for _, existing := range c.namespaces.Entries {
+		if existing.Path == path {
+			c.namespaceLock.Unlock()
+			return nil, logical.CodedError(409, fmt.Sprintf("existing namespace at %s", path))
+		}
+	}
+
+	newTable := &NamespaceTable{
+		Entries: append(append([]*Namespace{}, c.namespaces.Entries...), ns),
+	}
+	if err := c.persistNamespaces(newTable); err != nil {
+		c.namespaceLock.Unlock()
+		return nil, errors.New("failed to update namespace table")
+	}
+	c.setNamespaces(newTable)
+	err = c.setupNamespacePolicyStore(ns)
+	c.namespaceLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.mountNamespaceBackends(ns); err != nil {
+		return nil, err
+	}
+	c.logger.Printf("[INFO] core: created namespace '%s'", path)
+	return ns, nil
+}
+
+// deleteNamespace deletes a namespace without children. The leases and
+// tokens of the namespace are revoked, its mounts and auth backends are
+// removed and its storage is cleared.
+func (c *Core) deleteNamespace(ns *Namespace) error {
+	if len(c.childNamespaces(ns)) != 0 {
+		return fmt.Errorf("namespace '%s' has child namespaces", ns.Path)
+	}
+
+	// Remove the namespace first, so that its tokens can no longer be used
+	c.namespaceLock.Lock()
+	newTable := &NamespaceTable{}
+	for _, existing := range c.namespaces.Entries {
+		if existing.ID != ns.ID {
+			newTable.Entries = append(newTable.Entries, existing)
+		}
+	}
+	if err := c.persistNamespaces(newTable); err != nil {
+		c.namespaceLock.Unlock()
+		return errors.New("failed to update namespace table")
+	}
+	c.setNamespaces(newTable)
+	delete(c.namespacePolicyStores, ns.ID)
+	c.namespaceLock.Unlock()
+
+	// Taint the routes of the namespace, so that only the revocations of
+	// its leases are routed to them
+	mounts := c.namespaceMountEntries(ns)
+	for _, entry := range mounts {
+		if err := c.router.Taint(entry.routePath()); err != nil {
+			return err
+		}
+	}
+
+	// Revoke all the leases and tokens of the namespace
+	if err := c.expiration.RevokePrefix(ns.Path); err != nil {
+		return err
+	}
+
+	for _, entry := range mounts {
+		if err := c.router.Unmount(entry.routePath()); err != nil {
+			return err
+		}
+	}
+	if err := c.removeNamespaceEntries(ns); err != nil {
+		return err
+	}
+
+	// Clear the data of the namespace
+	if err := ClearView(NewBarrierView(c.barrier, ns.barrierPrefix())); err != nil {
+		return err
+	}
+	if err := ClearView(c.tokenStore.view.SubView(namespaceRolesPrefix + ns.ID + "/")); err != nil {
+		return err
+	}
+	c.logger.Printf("[INFO] core: deleted namespace '%s'", ns.Path)
+	return nil
+}
+
+// mountNamespaceBackends adds the system backend, the cubbyhole backend and
+// the token store of a new namespace to the mount and auth tables, and
+// mounts them
+func (c *Core) mountNamespaceBackends(ns *Namespace) error {
+	sysUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	sysEntry := &MountEntry{
+		Table:       mountTableType,
+		Path:        "sys/",
+		Type:        "system",
+		Description: "system endpoints used for control, policy and debugging",
+		UUID:        sysUUID,
+		NamespaceID: ns.ID,
+		namespace:   ns,
+	}
+	cubbyholeEntry, err := namespaceCubbyholeEntry(ns)
+	if err != nil {
+		return err
+	}
+
+	c.mountsLock.Lock()
+	view := NewBarrierView(c.barrier, ns.barrierPrefix()+systemBarrierPrefix)
+	backend, err := c.newMountBackend(sysEntry, view)
+	if err != nil {
+		c.mountsLock.Unlock()
+		return err
+	}
+	newTable := c.mounts.ShallowClone()
+	newTable.Entries = append(newTable.Entries, sysEntry, cubbyholeEntry)
+	if err := c.persistMounts(newTable); err != nil {
+		c.mountsLock.Unlock()
+		return errors.New("failed to update mount table")
+	}
+	c.mounts = newTable
+	err = c.router.Mount(backend, sysEntry.routePath(), sysEntry, view)
+	if err == nil {
+		err = c.mountNamespaceCubbyhole(cubbyholeEntry)
+	}
+	c.mountsLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tokenUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	tokenAccessor, err := credentialAccessor("token")
+	if err != nil {
+		return err
+	}
+	tokenEntry := &MountEntry{
+		Table:       credentialTableType,
+		Path:        "token/",
+		Type:        "token",
+		Description: "token based credentials",
+		UUID:        tokenUUID,
+		Accessor:    tokenAccessor,
+		NamespaceID: ns.ID,
+		namespace:   ns,
+	}
+
+	c.authLock.Lock()
+	defer c.authLock.Unlock()
+
+	newAuthTable := c.auth.ShallowClone()
+	newAuthTable.Entries = append(newAuthTable.Entries, tokenEntry)
+	if err := c.persistAuth(newAuthTable); err != nil {
+		return errors.New("failed to update auth table")
+	}
+	c.auth = newAuthTable
+
+	// All namespaces share the token store, which keeps the namespaces of
+	// the tokens
+	view = NewBarrierView(c.barrier, ns.barrierPrefix()+credentialBarrierPrefix+tokenEntry.UUID+"/")
+	return c.router.Mount(c.tokenStore, tokenEntry.routePath(), tokenEntry, view)
+}
+
+// namespaceCubbyholeEntry returns the mount table entry of the cubbyhole
+// backend of a namespace
+func namespaceCubbyholeEntry(ns *Namespace) (*MountEntry, error) {
+	cubbyholeUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	return &MountEntry{
+		Table:       mountTableType,
+		Path:        "cubbyhole/",
+		Type:        "cubbyhole",
+		Description: "per-token private secret storage",
+		UUID:        cubbyholeUUID,
+		NamespaceID: ns.ID,
+		namespace:   ns,
+	}, nil
+}
+
+// mountNamespaceCubbyhole mounts the cubbyhole backend of the root namespace
+// for a namespace. As the cubbyholes belong to tokens rather than to
+// namespaces, all namespaces share the backend and its storage, and the
+// cubbyhole of a token is cleared when it is revoked.
+func (c *Core) mountNamespaceCubbyhole(entry *MountEntry) error {
+	ch, ok := c.router.MatchingBackend("cubbyhole/").(*CubbyholeBackend)
+	if !ok || ch.storageView == nil {
+		return fmt.Errorf("cubbyhole backend of the root namespace not mounted")
+	}
+	return c.router.Mount(ch, entry.routePath(), entry, ch.storageView.(*BarrierView))
+}
+
+// namespaceMountEntries returns the entries of the mount and auth tables
+// which belong to a namespace
+func (c *Core) namespaceMountEntries(ns *Namespace) []*MountEntry {
+	var entries []*MountEntry
+
+	c.mountsLock.RLock()
+	for _, entry := range c.mounts.Entries {
+		if entry.NamespaceID == ns.ID {
+			entries = append(entries, entry)
+		}
+	}
+	c.mountsLock.RUnlock()
+
+	c.authLock.RLock()
+	for _, entry := range c.auth.Entries {
+		if entry.NamespaceID == ns.ID {
+			entries = append(entries, entry)
+		}
+	}
+	c.authLock.RUnlock()
+
+	return entries
+}
+
+// removeNamespaceEntries removes the entries of a namespace from the mount
+// and auth tables
+func (c *Core) removeNamespaceEntries(ns *Namespace) error {
+	c.mountsLock.Lock()
+	newTable := &MountTable{
+		Type: c.mounts.Type,
+	}
+	for _, entry := range c.mounts.Entries {
+		if entry.NamespaceID != ns.ID {
+			newTable.Entries = append(newTable.Entries, entry)
+		}
+	}
+	if err := c.persistMounts(newTable); err != nil {
+		c.mountsLock.Unlock()
+		return errors.New("failed to update mount table")
+	}
+	c.mounts = newTable
+	c.mountsLock.Unlock()
+
+	c.authLock.Lock()
+	defer c.authLock.Unlock()
+
+	newAuthTable := &MountTable{
+		Type: c.auth.Type,
+	}
+	for _, entry := range c.auth.Entries {
+		if entry.NamespaceID != ns.ID {
+			newAuthTable.Entries = append(newAuthTable.Entries, entry)
+		}
+	}
+	if err := c.persistAuth(newAuthTable); err != nil {
+		return errors.New("failed to update auth table")
+	}
+	c.auth = newAuthTable
+	return nil
+}
+
+// mountBelow returns the route of a mount or auth backend below the given
+// path, or the empty string if there is none
+func (c *Core) mountBelow(path string) string {
+	c.mountsLock.RLock()
+	defer c.mountsLock.RUnlock()
+	c.authLock.RLock()
+	defer c.authLock.RUnlock()
+
+	for _, table := range []*MountTable{c.mounts, c.auth} {
+		for _, entry := range table.Entries {
+			if route := entry.routePath(); strings.HasPrefix(route, path) {
+				return route
+			}
+		}
+	}
+	return ""
+}
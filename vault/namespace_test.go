@@ -0,0 +1,326 @@
+package vault
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/logical"
+)
+
+const testNamespaceAdminPolicy = `
+path "*" {
+	capabilities = ["create", "read", "update", "delete", "list", "sudo"]
+}
+`
+
+// testNamespaceAdmin creates a namespace in the root namespace, with a
+// generic backend mounted at secret/, and returns it along with a token of
+// the namespace having the given rules
+func testNamespaceAdmin(t *testing.T, c *Core, root, name, rules string) (*Namespace, string) {
+	t.Helper()
+	testIdentityRequest(t, c, root, logical.UpdateOperation, "sys/namespaces/"+name, nil)
+	ns := c.namespaceForPath(name + "/")
+	if ns.Path != name+"/" {
+		t.Fatalf("bad: %#v", ns)
+	}
+
+	testIdentityRequest(t, c, root, logical.UpdateOperation, name+"/sys/mounts/secret", map[string]interface{}{
+		"type": "generic",
+	})
+	testIdentityRequest(t, c, root, logical.UpdateOperation, name+"/sys/policy/admin", map[string]interface{}{
+		"rules": rules,
+	})
+	resp := testIdentityRequest(t, c, root, logical.UpdateOperation, name+"/auth/token/create", map[string]interface{}{
+		"policies": []string{"admin"},
+	})
+	if resp == nil || resp.Auth == nil || resp.Auth.ClientToken == "" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	return ns, resp.Auth.ClientToken
+}
+
+func TestNamespace_Isolation(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	ns, token := testNamespaceAdmin(t, c, root, "team", testNamespaceAdminPolicy)
+
+	resp := testIdentityRequest(t, c, root, logical.ListOperation, "sys/namespaces", nil)
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"team/"}) {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The token of the namespace uses the paths of the namespace
+	testIdentityRequest(t, c, token, logical.UpdateOperation, "team/secret/foo", map[string]interface{}{
+		"value": "team",
+	})
+	resp = testIdentityRequest(t, c, token, logical.ReadOperation, "team/secret/foo", nil)
+	if resp.Data["value"] != "team" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The data of the namespace is stored below its barrier prefix
+	keys, err := c.barrier.List(ns.barrierPrefix() + "logical/")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	// The mount of the root namespace is distinct
+	resp = testIdentityRequest(t, c, root, logical.ReadOperation, "secret/foo", nil)
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The token cannot be used outside of its namespace
+	for _, path := range []string{"secret/foo", "sys/mounts", "sys/policy", "sys/namespaces/other"} {
+		req := logical.TestRequest(t, logical.ReadOperation, path)
+		req.ClientToken = token
+		if _, err := c.HandleRequest(req); !errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
+			t.Fatalf("path %s: err: %v", path, err)
+		}
+	}
+
+	// The mounts and policies of the namespace are its own
+	resp = testIdentityRequest(t, c, token, logical.ReadOperation, "team/sys/mounts", nil)
+	var mounts []string
+	for path := range resp.Data {
+		mounts = append(mounts, path)
+	}
+	sort.Strings(mounts)
+	if !reflect.DeepEqual(mounts, []string{"cubbyhole/", "secret/", "sys/"}) {
+		t.Fatalf("bad: %#v", mounts)
+	}
+
+	resp = testIdentityRequest(t, c, token, logical.ListOperation, "team/sys/policy", nil)
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"admin", "default", "root"}) {
+		t.Fatalf("bad: %#v", resp)
+	}
+	resp = testIdentityRequest(t, c, root, logical.ReadOperation, "sys/policy/admin", nil)
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The token store reports the namespace of the token
+	resp = testIdentityRequest(t, c, token, logical.ReadOperation, "team/auth/token/lookup-self", nil)
+	if resp.Data["namespace_path"] != "team/" || resp.Data["path"] != "team/auth/token/create" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The root token cannot be looked up from the namespace
+	req := logical.TestRequest(t, logical.UpdateOperation, "team/auth/token/lookup")
+	req.ClientToken = token
+	req.Data["token"] = root
+	if _, err := c.HandleRequest(req); !errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The token indexes of all the namespaces are only tidied from the root
+	// namespace
+	for _, op := range []logical.Operation{logical.UpdateOperation, logical.ReadOperation} {
+		req = logical.TestRequest(t, op, "team/auth/token/tidy")
+		req.ClientToken = token
+		if _, err := c.HandleRequest(req); !errwrap.Contains(err, logical.ErrUnsupportedPath.Error()) {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	testIdentityRequest(t, c, root, logical.ReadOperation, "auth/token/tidy", nil)
+}
+
+func TestNamespace_ChildNamespaces(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	ns, token := testNamespaceAdmin(t, c, root, "team", testNamespaceAdminPolicy)
+
+	// The namespace admin manages the child namespaces
+	testIdentityRequest(t, c, token, logical.UpdateOperation, "team/sys/namespaces/sub", nil)
+	resp := testIdentityRequest(t, c, token, logical.ReadOperation, "team/sys/namespaces/sub", nil)
+	if resp.Data["path"] != "team/sub/" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	testIdentityRequest(t, c, token, logical.UpdateOperation, "team/sub/sys/mounts/secret", map[string]interface{}{
+		"type": "generic",
+	})
+	testIdentityRequest(t, c, token, logical.UpdateOperation, "team/sub/sys/policy/reader", map[string]interface{}{
+		"rules": `path "secret/*" { capabilities = ["read"] }`,
+	})
+	testIdentityRequest(t, c, token, logical.UpdateOperation, "team/sub/secret/foo", map[string]interface{}{
+		"value": "sub",
+	})
+	resp = testIdentityRequest(t, c, token, logical.UpdateOperation, "team/sub/auth/token/create", map[string]interface{}{
+		"policies": []string{"reader"},
+	})
+	subToken := resp.Auth.ClientToken
+
+	resp = testIdentityRequest(t, c, subToken, logical.ReadOperation, "team/sub/secret/foo", nil)
+	if resp.Data["value"] != "sub" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The token of the child namespace cannot reach its parent
+	req := logical.TestRequest(t, logical.ListOperation, "team/secret/")
+	req.ClientToken = subToken
+	if _, err := c.HandleRequest(req); !errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Creating a token in another namespace requires sudo
+	testIdentityRequest(t, c, root, logical.UpdateOperation, "team/sys/policy/creator", map[string]interface{}{
+		"rules": `path "sub/auth/token/create" { capabilities = ["update"] }`,
+	})
+	resp = testIdentityRequest(t, c, root, logical.UpdateOperation, "team/auth/token/create", map[string]interface{}{
+		"policies": []string{"creator"},
+	})
+	req = logical.TestRequest(t, logical.UpdateOperation, "team/sub/auth/token/create")
+	req.ClientToken = resp.Auth.ClientToken
+	if _, err := c.HandleRequest(req); !errwrap.Contains(err, logical.ErrInvalidRequest.Error()) {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A namespace with children cannot be deleted
+	req = logical.TestRequest(t, logical.DeleteOperation, "sys/namespaces/team")
+	req.ClientToken = root
+	if _, err := c.HandleRequest(req); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	testIdentityRequest(t, c, token, logical.DeleteOperation, "team/sys/namespaces/sub", nil)
+	testIdentityRequest(t, c, root, logical.DeleteOperation, "sys/namespaces/team", nil)
+
+	// The tokens of the deleted namespaces are revoked
+	for _, id := range []string{token, subToken} {
+		te, err := c.tokenStore.Lookup(id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if te != nil {
+			t.Fatalf("bad: %#v", te)
+		}
+	}
+	if entries := c.namespaceMountEntries(ns); len(entries) != 0 {
+		t.Fatalf("bad: %#v", entries)
+	}
+	keys, err := c.barrier.List(namespaceBarrierPrefix)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
+func TestNamespace_Conflicts(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	testNamespaceAdmin(t, c, root, "team", testNamespaceAdminPolicy)
+
+	for _, path := range []string{"sys/namespaces/team", "sys/namespaces/secret", "sys/namespaces/sys", "sys/mounts/team"} {
+		req := logical.TestRequest(t, logical.UpdateOperation, path)
+		req.ClientToken = root
+		req.Data["type"] = "generic"
+		if _, err := c.HandleRequest(req); err == nil {
+			t.Fatalf("path %s: expected error", path)
+		}
+	}
+}
+
+func TestNamespace_Cubbyhole(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	_, token := testNamespaceAdmin(t, c, root, "team", "")
+
+	// The default policy of the namespace gives access to the cubbyhole of
+	// the token
+	testIdentityRequest(t, c, token, logical.UpdateOperation, "team/cubbyhole/foo", map[string]interface{}{
+		"value": "team",
+	})
+	resp := testIdentityRequest(t, c, token, logical.ReadOperation, "team/cubbyhole/foo", nil)
+	if resp == nil || resp.Data["value"] != "team" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	resp = testIdentityRequest(t, c, root, logical.ReadOperation, "team/cubbyhole/foo", nil)
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Responses of the namespace can be wrapped
+	req := logical.TestRequest(t, logical.ReadOperation, "team/auth/token/lookup-self")
+	req.ClientToken = token
+	req.WrapTTL = time.Minute
+	resp, err := c.HandleRequest(req)
+	if err != nil || resp == nil || resp.WrapInfo == nil || resp.WrapInfo.Token == "" {
+		t.Fatalf("bad: %#v err: %v", resp, err)
+	}
+	resp = testIdentityRequest(t, c, resp.WrapInfo.Token, logical.UpdateOperation, "sys/wrapping/unwrap", nil)
+	if resp == nil || !strings.Contains(string(resp.Data[logical.HTTPRawBody].([]byte)), `"namespace_path":"team/"`) {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The cubbyhole is cleared along with the token
+	view := c.router.MatchingStorageView("cubbyhole/")
+	keys, err := CollectKeys(view)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("bad: %#v", keys)
+	}
+	testIdentityRequest(t, c, root, logical.UpdateOperation, "team/auth/token/revoke", map[string]interface{}{
+		"token": token,
+	})
+	keys, err = CollectKeys(view)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
+func TestNamespace_Persist(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+	ns, token := testNamespaceAdmin(t, c, root, "team", testNamespaceAdminPolicy)
+	testIdentityRequest(t, c, token, logical.UpdateOperation, "team/secret/foo", map[string]interface{}{
+		"value": "team",
+	})
+	testIdentityRequest(t, c, token, logical.UpdateOperation, "team/cubbyhole/foo", map[string]interface{}{
+		"value": "team",
+	})
+
+	// Namespaces created before they had a cubbyhole get one when the mount
+	// table is loaded
+	c.mountsLock.Lock()
+	table := &MountTable{
+		Type: c.mounts.Type,
+	}
+	for _, entry := range c.mounts.Entries {
+		if entry.NamespaceID != ns.ID || entry.Type != "cubbyhole" {
+			table.Entries = append(table.Entries, entry)
+		}
+	}
+	err := c.persistMounts(table)
+	c.mountsLock.Unlock()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if unseal, err := c.Unseal(key); err != nil || !unseal {
+		t.Fatalf("err: %v", err)
+	}
+
+	if actual := c.namespaceForPath("team/"); !reflect.DeepEqual(actual, ns) {
+		t.Fatalf("bad: %#v", actual)
+	}
+	resp := testIdentityRequest(t, c, token, logical.ReadOperation, "team/secret/foo", nil)
+	if resp.Data["value"] != "team" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	resp = testIdentityRequest(t, c, token, logical.ReadOperation, "team/cubbyhole/foo", nil)
+	if resp == nil || resp.Data["value"] != "team" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
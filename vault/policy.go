@@ -2,7 +2,9 @@ package vault
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/hcl"
@@ -63,6 +65,40 @@ type PathCapabilities struct {
 	Capabilities       []string
 	CapabilitiesBitmap uint32 `hcl:"-"`
 	Glob               bool
+	Templated          bool             `hcl:"-"`
+	Permissions        *PathPermissions `hcl:"-"`
+}
+
+// PathPermissions holds the constraints of a path on the parameters of the
+// requests made to it and on the response wrapping they ask for
+type PathPermissions struct {
+	// AllowedParameters maps the parameters which may be set to the globs
+	// of their allowed values, an empty list allowing any value. The "*"
+	// key matches any parameter. Any parameter may be set if it is nil.
+	AllowedParameters map[string][]string
+
+	// DeniedParameters maps the parameters which may not be set to the
+	// globs of their denied values, an empty list denying any value. The
+	// "*" key matches any parameter.
+	DeniedParameters map[string][]string
+
+	// RequiredParameters are the parameters which must be set
+	RequiredParameters []string
+
+	// MinWrappingTTL and MaxWrappingTTL bound the TTL of the response
+	// wrapping which requests must ask for, if set
+	MinWrappingTTL time.Duration
+	MaxWrappingTTL time.Duration
+}
+
+// pathPermissionsHCL is used to decode the keys of a path which are parsed
+// into its PathPermissions
+type pathPermissionsHCL struct {
+	AllowedParameters  map[string][]interface{} `hcl:"allowed_parameters"`
+	DeniedParameters   map[string][]interface{} `hcl:"denied_parameters"`
+	RequiredParameters []string                 `hcl:"required_parameters"`
+	MinWrappingTTL     interface{}              `hcl:"min_wrapping_ttl"`
+	MaxWrappingTTL     interface{}              `hcl:"max_wrapping_ttl"`
 }
 
 // Parse is used to parse the specified ACL rules into an
@@ -117,6 +153,11 @@ func parsePaths(result *Policy, list *ast.ObjectList) error {
 		valid := []string{
 			"policy",
 			"capabilities",
+			"allowed_parameters",
+			"denied_parameters",
+			"required_parameters",
+			"min_wrapping_ttl",
+			"max_wrapping_ttl",
 		}
 		if err := checkHCLKeys(item.Val, valid); err != nil {
 			return multierror.Prefix(err, fmt.Sprintf("path %q:", key))
@@ -139,6 +180,15 @@ func parsePaths(result *Policy, list *ast.ObjectList) error {
 			pc.Glob = true
 		}
 
+		// Check the placeholders of a templated path, which is expanded for
+		// the token of each request
+		if strings.Contains(pc.Prefix, "{{") || strings.Contains(pc.Prefix, "}}") {
+			if err := validateTemplatedPath(pc.Prefix); err != nil {
+				return fmt.Errorf("path %q: %v", key, err)
+			}
+			pc.Templated = true
+		}
+
 		// Map old-style policies into capabilities
 		if len(pc.Policy) > 0 {
 			switch pc.Policy {
@@ -173,6 +223,18 @@ func parsePaths(result *Policy, list *ast.ObjectList) error {
 
 	PathFinished:
 
+		// Parse the constraints on the parameters and the response wrapping
+		// of the requests
+		var ph pathPermissionsHCL
+		if err := hcl.DecodeObject(&ph, item.Val); err != nil {
+			return multierror.Prefix(err, fmt.Sprintf("path %q:", key))
+		}
+		permissions, err := parsePathPermissions(&ph)
+		if err != nil {
+			return fmt.Errorf("path %q: %v", key, err)
+		}
+		pc.Permissions = permissions
+
 		paths = append(paths, &pc)
 	}
 
@@ -180,6 +242,80 @@ func parsePaths(result *Policy, list *ast.ObjectList) error {
 	return nil
 }
 
+// parsePathPermissions returns the permissions of a path from its decoded
+// keys, which are nil if none is set
+func parsePathPermissions(ph *pathPermissionsHCL) (*PathPermissions, error) {
+	if ph.AllowedParameters == nil && ph.DeniedParameters == nil &&
+		len(ph.RequiredParameters) == 0 && ph.MinWrappingTTL == nil && ph.MaxWrappingTTL == nil {
+		return nil, nil
+	}
+
+	var err error
+	permissions := &PathPermissions{
+		AllowedParameters:  parseParameterValues(ph.AllowedParameters),
+		DeniedParameters:   parseParameterValues(ph.DeniedParameters),
+		RequiredParameters: ph.RequiredParameters,
+	}
+	if ph.MinWrappingTTL != nil {
+		permissions.MinWrappingTTL, err = parseWrappingTTL(ph.MinWrappingTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_wrapping_ttl: %v", err)
+		}
+	}
+	if ph.MaxWrappingTTL != nil {
+		permissions.MaxWrappingTTL, err = parseWrappingTTL(ph.MaxWrappingTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_wrapping_ttl: %v", err)
+		}
+	}
+	if permissions.MaxWrappingTTL != 0 && permissions.MinWrappingTTL > permissions.MaxWrappingTTL {
+		return nil, fmt.Errorf("min_wrapping_ttl is greater than max_wrapping_ttl")
+	}
+	return permissions, nil
+}
+
+// parseParameterValues converts the decoded values of the parameters to
+// strings
+func parseParameterValues(raw map[string][]interface{}) map[string][]string {
+	if raw == nil {
+		return nil
+	}
+	params := make(map[string][]string, len(raw))
+	for name, values := range raw {
+		params[name] = make([]string, 0, len(values))
+		for _, value := range values {
+			params[name] = append(params[name], fmt.Sprint(value))
+		}
+	}
+	return params
+}
+
+// parseWrappingTTL parses a wrapping TTL, given either as a duration string
+// or as a number of seconds
+func parseWrappingTTL(raw interface{}) (time.Duration, error) {
+	var ttl time.Duration
+	switch v := raw.(type) {
+	case int:
+		ttl = time.Duration(v) * time.Second
+	case string:
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			seconds, serr := strconv.ParseInt(v, 10, 64)
+			if serr != nil {
+				return 0, err
+			}
+			dur = time.Duration(seconds) * time.Second
+		}
+		ttl = dur
+	default:
+		return 0, fmt.Errorf("unexpected type %T", raw)
+	}
+	if ttl < 0 {
+		return 0, fmt.Errorf("negative TTL")
+	}
+	return ttl, nil
+}
+
 func checkHCLKeys(node ast.Node, valid []string) error {
 	var list *ast.ObjectList
 	switch n := node.(type) {
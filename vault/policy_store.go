@@ -2,6 +2,7 @@ package vault
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/armon/go-metrics"
@@ -19,6 +20,14 @@ const (
 	// policyCacheSize is the number of policies that are kept cached
 	policyCacheSize = 1024
 
+	// templatedACLCacheSize is the number of ACLs with templated paths that
+	// are kept cached, along with their expansions
+	templatedACLCacheSize = 256
+
+	// policyEntryVersion is the version of the stored policy entries. The
+	// default policy is upgraded when stored with an earlier version.
+	policyEntryVersion = 3
+
 	// cubbyholeResponseWrappingPolicyName is the name of the fixed policy
 	cubbyholeResponseWrappingPolicyName = "response-wrapping"
 
@@ -28,6 +37,10 @@ const (
 path "cubbyhole/response" {
     capabilities = ["create", "read"]
 }
+
+path "sys/wrapping/unwrap" {
+    capabilities = ["update"]
+}
 `
 )
 
@@ -36,6 +49,15 @@ var (
 		"root",
 		cubbyholeResponseWrappingPolicyName,
 	}
+
+	// defaultPolicyWrappingPaths are the paths of the response-wrapping
+	// endpoints the default policy gives access to. They are added to the
+	// default policy of existing stores on upgrade.
+	defaultPolicyWrappingPaths = []string{
+		"sys/wrapping/lookup",
+		"sys/wrapping/unwrap",
+		"sys/wrapping/wrap",
+	}
 )
 
 // PolicyStore is used to provide durable storage of policy, and to
@@ -43,6 +65,17 @@ var (
 type PolicyStore struct {
 	view *BarrierView
 	lru  *lru.TwoQueueCache
+
+	// templatedACLs caches the ACLs with templated paths by the names of
+	// their policies
+	templatedACLs *lru.TwoQueueCache
+}
+
+// cachedACL is an ACL with templated paths, cached along with the policies
+// it was compiled from
+type cachedACL struct {
+	policies []*Policy
+	acl      *ACL
 }
 
 // PolicyEntry is used to store a policy by name
@@ -60,6 +93,8 @@ func NewPolicyStore(view *BarrierView, system logical.SystemView) *PolicyStore {
 	if !system.CachingDisabled() {
 		cache, _ := lru.New2Q(policyCacheSize)
 		p.lru = cache
+		aclCache, _ := lru.New2Q(templatedACLCacheSize)
+		p.templatedACLs = aclCache
 	}
 
 	return p
@@ -84,6 +119,8 @@ func (c *Core) setupPolicyStore() error {
 		if err != nil {
 			return err
 		}
+	} else if err := c.policyStore.upgradeDefaultPolicy(); err != nil {
+		return err
 	}
 
 	// Ensure that the cubbyhole response wrapping policy exists
@@ -98,6 +135,15 @@ func (c *Core) setupPolicyStore() error {
 		}
 	}
 
+	// Create the policy stores of the namespaces
+	c.namespaceLock.Lock()
+	defer c.namespaceLock.Unlock()
+	for _, ns := range c.namespaces.Entries {
+		if err := c.setupNamespacePolicyStore(ns); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -124,7 +170,7 @@ func (ps *PolicyStore) SetPolicy(p *Policy) error {
 func (ps *PolicyStore) setPolicyInternal(p *Policy) error {
 	// Create the entry
 	entry, err := logical.StorageEntryJSON(p.Name, &PolicyEntry{
-		Version: 2,
+		Version: policyEntryVersion,
 		Raw:     p.Raw,
 	})
 	if err != nil {
@@ -246,11 +292,34 @@ func (ps *PolicyStore) ACL(names ...string) (*ACL, error) {
 		policy = append(policy, p)
 	}
 
+	// Reuse an ACL with templated paths, so that the ACLs expanded from it
+	// are cached, unless one of its policies has changed since
+	key := strings.Join(names, ",")
+	if ps.templatedACLs != nil {
+		if raw, ok := ps.templatedACLs.Get(key); ok {
+			cached := raw.(*cachedACL)
+			same := len(cached.policies) == len(policy)
+			for i := 0; same && i < len(policy); i++ {
+				same = cached.policies[i] == policy[i]
+			}
+			if same {
+				return cached.acl, nil
+			}
+		}
+	}
+
 	// Construct the ACL
 	acl, err := NewACL(policy)
 	if err != nil {
 		return nil, fmt.Errorf("failed to construct ACL: %v", err)
 	}
+
+	if acl.templated && ps.templatedACLs != nil {
+		ps.templatedACLs.Add(key, &cachedACL{
+			policies: policy,
+			acl:      acl,
+		})
+	}
 	return acl, nil
 }
 
@@ -275,7 +344,7 @@ path "cubbyhole/*" {
 path "cubbyhole" {
     capabilities = ["list"]
 }
-`)
+` + defaultPolicyWrappingRules(defaultPolicyWrappingPaths))
 	if err != nil {
 		return errwrap.Wrapf("error parsing default policy: {{err}}", err)
 	}
@@ -288,6 +357,69 @@ path "cubbyhole" {
 	return ps.setPolicyInternal(policy)
 }
 
+// defaultPolicyWrappingRules returns the rules of the default policy on the
+// given response-wrapping paths
+func defaultPolicyWrappingRules(paths []string) string {
+	var rules string
+	for _, path := range paths {
+		rules += fmt.Sprintf(`
+path "%s" {
+    capabilities = ["update"]
+}
+`, path)
+	}
+	return rules
+}
+
+// upgradeDefaultPolicy adds the rules on the response-wrapping endpoints to
+// a default policy stored before they were part of it, so that tokens keep
+// the access to them which new stores give. Paths the policy already has a
+// rule for, including through a glob, are left as they are. Once upgraded,
+// the policy is stored with the current version, so that later changes to
+// it are kept.
+func (ps *PolicyStore) upgradeDefaultPolicy() error {
+	out, err := ps.view.Get("default")
+	if err != nil {
+		return fmt.Errorf("failed to read default policy: %v", err)
+	}
+	if out == nil {
+		return nil
+	}
+
+	// Policies stored by Vault 0.1.X are not upgraded
+	entry := new(PolicyEntry)
+	if err := out.DecodeJSON(entry); err != nil || entry.Version >= policyEntryVersion {
+		return nil
+	}
+
+	policy, err := Parse(entry.Raw)
+	if err != nil {
+		return errwrap.Wrapf("error parsing default policy: {{err}}", err)
+	}
+
+	// The paths are matched the way requests are, so that any rule of the
+	// policy covering a path keeps it untouched
+	acl, err := NewACL([]*Policy{policy})
+	if err != nil {
+		return errwrap.Wrapf("error parsing default policy: {{err}}", err)
+	}
+	var missing []string
+	for _, path := range defaultPolicyWrappingPaths {
+		if _, ok := acl.rule(path); !ok {
+			missing = append(missing, path)
+		}
+	}
+
+	if len(missing) != 0 {
+		policy, err = Parse(strings.TrimRight(entry.Raw, "\n") + "\n" + defaultPolicyWrappingRules(missing))
+		if err != nil {
+			return errwrap.Wrapf("error parsing default policy: {{err}}", err)
+		}
+	}
+	policy.Name = "default"
+	return ps.setPolicyInternal(policy)
+}
+
 func (ps *PolicyStore) createCubbyholeResponseWrappingPolicy() error {
 	policy, err := Parse(cubbyholeResponseWrappingPolicy)
 	if err != nil {
@@ -215,3 +215,72 @@ func TestPolicyStore_v1Upgrade(t *testing.T) {
 		t.Fatalf("should enable glob")
 	}
 }
+
+func TestPolicyStore_defaultPolicyUpgrade(t *testing.T) {
+	ps := mockPolicyStore(t)
+
+	// A default policy stored before the response-wrapping rules, with a
+	// rule covering one of their paths
+	raw := `
+path "auth/token/lookup-self" {
+    capabilities = ["read"]
+}
+
+path "sys/wrapping/w*" {
+    capabilities = ["deny"]
+}
+
+path "sys/+/lookup" {
+    capabilities = ["read"]
+}
+`
+	entry, err := logical.StorageEntryJSON("default", &PolicyEntry{
+		Version: 2,
+		Raw:     raw,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ps.view.Put(entry); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := ps.upgradeDefaultPolicy(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	acl, err := ps.ACL("default")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := map[string][]string{
+		"auth/token/lookup-self": []string{"read"},
+		"sys/wrapping/lookup":    []string{"read"},
+		"sys/wrapping/unwrap":    []string{"update"},
+		"sys/wrapping/wrap":      []string{"deny"},
+	}
+	for path, caps := range expected {
+		if actual := acl.Capabilities(path); !reflect.DeepEqual(actual, caps) {
+			t.Fatalf("path %s: bad: %#v", path, actual)
+		}
+	}
+
+	// Changes made to the upgraded policy are kept
+	p, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	p.Name = "default"
+	if err := ps.SetPolicy(p); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ps.upgradeDefaultPolicy(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	p, err = ps.GetPolicy("default")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if p.Raw != raw {
+		t.Fatalf("bad: %s", p.Raw)
+	}
+}
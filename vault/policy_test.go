@@ -1,9 +1,11 @@
 package vault
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 var rawPolicy = strings.TrimSpace(`
@@ -52,7 +54,7 @@ func TestPolicy_Parse(t *testing.T) {
 		&PathCapabilities{"", "deny",
 			[]string{
 				"deny",
-			}, DenyCapabilityInt, true},
+			}, DenyCapabilityInt, true, false, nil},
 		&PathCapabilities{"stage/", "sudo",
 			[]string{
 				"create",
@@ -62,22 +64,22 @@ func TestPolicy_Parse(t *testing.T) {
 				"list",
 				"sudo",
 			}, CreateCapabilityInt | ReadCapabilityInt | UpdateCapabilityInt |
-				DeleteCapabilityInt | ListCapabilityInt | SudoCapabilityInt, true},
+				DeleteCapabilityInt | ListCapabilityInt | SudoCapabilityInt, true, false, nil},
 		&PathCapabilities{"prod/version", "read",
 			[]string{
 				"read",
 				"list",
-			}, ReadCapabilityInt | ListCapabilityInt, false},
+			}, ReadCapabilityInt | ListCapabilityInt, false, false, nil},
 		&PathCapabilities{"foo/bar", "read",
 			[]string{
 				"read",
 				"list",
-			}, ReadCapabilityInt | ListCapabilityInt, false},
+			}, ReadCapabilityInt | ListCapabilityInt, false, false, nil},
 		&PathCapabilities{"foo/bar", "",
 			[]string{
 				"create",
 				"sudo",
-			}, CreateCapabilityInt | SudoCapabilityInt, false},
+			}, CreateCapabilityInt | SudoCapabilityInt, false, false, nil},
 	}
 	if !reflect.DeepEqual(p.Paths, expect) {
 		t.Errorf("expected \n\n%#v\n\n to be \n\n%#v\n\n", p.Paths, expect)
@@ -119,6 +121,88 @@ path "/" {
 	}
 }
 
+func TestPolicy_ParseTemplated(t *testing.T) {
+	p, err := Parse(strings.TrimSpace(`
+path "secret/{{identity.entity.id}}/*" {
+	capabilities = ["read"]
+}
+path "secret/static" {
+	capabilities = ["read"]
+}
+`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !p.Paths[0].Templated || p.Paths[0].Prefix != "secret/{{identity.entity.id}}/" || !p.Paths[0].Glob {
+		t.Fatalf("bad: %#v", p.Paths[0])
+	}
+	if p.Paths[1].Templated {
+		t.Fatalf("bad: %#v", p.Paths[1])
+	}
+
+	for _, path := range []string{"secret/{{identity.nope}}", "secret/{{token.metadata.}}", "secret/{{token.display_name"} {
+		_, err := Parse(fmt.Sprintf(`path %q { capabilities = ["read"] }`, path))
+		if err == nil {
+			t.Fatalf("expected error for %q", path)
+		}
+		if !strings.Contains(err.Error(), "placeholder") {
+			t.Errorf("bad error: %s", err)
+		}
+	}
+}
+
+func TestPolicy_ParsePermissions(t *testing.T) {
+	p, err := Parse(strings.TrimSpace(`
+path "pki/issue/web" {
+	capabilities = ["update"]
+	allowed_parameters = {
+		"common_name" = ["*.example.com"]
+		"ttl" = []
+	}
+	denied_parameters {
+		"format" = ["der"]
+	}
+	required_parameters = ["common_name"]
+	min_wrapping_ttl = "1m"
+	max_wrapping_ttl = 3600
+}
+path "secret/*" {
+	capabilities = ["read"]
+}
+`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expect := &PathPermissions{
+		AllowedParameters: map[string][]string{
+			"common_name": []string{"*.example.com"},
+			"ttl":         []string{},
+		},
+		DeniedParameters: map[string][]string{
+			"format": []string{"der"},
+		},
+		RequiredParameters: []string{"common_name"},
+		MinWrappingTTL:     time.Minute,
+		MaxWrappingTTL:     time.Hour,
+	}
+	if !reflect.DeepEqual(p.Paths[0].Permissions, expect) {
+		t.Fatalf("bad: %#v", p.Paths[0].Permissions)
+	}
+	if p.Paths[1].Permissions != nil {
+		t.Fatalf("bad: %#v", p.Paths[1].Permissions)
+	}
+
+	_, err = Parse(`path "secret/*" { min_wrapping_ttl = "1h" max_wrapping_ttl = "1m" }`)
+	if err == nil || !strings.Contains(err.Error(), "min_wrapping_ttl is greater than max_wrapping_ttl") {
+		t.Fatalf("bad error: %v", err)
+	}
+	_, err = Parse(`path "secret/*" { max_wrapping_ttl = "banana" }`)
+	if err == nil || !strings.Contains(err.Error(), `path "secret/*": invalid max_wrapping_ttl`) {
+		t.Fatalf("bad error: %v", err)
+	}
+}
+
 func TestPolicy_ParseBadPolicy(t *testing.T) {
 	_, err := Parse(strings.TrimSpace(`
 path "/" {
@@ -7,11 +7,24 @@ import (
 	"time"
 
 	"github.com/armon/go-metrics"
+	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 )
 
+// QueryParamsPath checks if the backend serving the given path takes the
+// query parameters of the HTTP request as data on reads
+func (c *Core) QueryParamsPath(path string) bool {
+	return c.router.QueryParamsPath(path)
+}
+
+// HeadPath checks if the backend serving the given path handles HEAD
+// requests
+func (c *Core) HeadPath(path string) bool {
+	return c.router.HeadPath(path)
+}
+
 // HandleRequest is used to handle a new incoming request
 func (c *Core) HandleRequest(req *logical.Request) (resp *logical.Response, err error) {
 	c.stateLock.RLock()
@@ -53,10 +66,11 @@ func (c *Core) HandleRequest(req *logical.Request) (resp *logical.Response, err
 	}
 
 	// We are wrapping if there is anything to wrap (not a nil response) and a
-	// TTL was specified for the token
+	// TTL was specified for the token. A rewrapped response already has its
+	// token.
 	wrapping := resp != nil && resp.WrapInfo != nil && resp.WrapInfo.TTL != 0
 
-	if wrapping {
+	if wrapping && !c.isRewrapPath(req.Path) {
 		cubbyResp, err := c.wrapInCubbyhole(req, resp)
 		// If not successful, returns either an error response from the
 		// cubbyhole backend or an error; if either is set, return
@@ -130,8 +144,8 @@ func (c *Core) handleRequest(req *logical.Request) (retResp *logical.Response, r
 		// If it is an internal error we return that, otherwise we
 		// return invalid request so that the status codes can be correct
 		var errType error
-		switch ctErr {
-		case ErrInternalError, logical.ErrPermissionDenied:
+		switch {
+		case ctErr == ErrInternalError, errwrap.Contains(ctErr, logical.ErrPermissionDenied.Error()):
 			errType = ctErr
 		default:
 			errType = logical.ErrInvalidRequest
@@ -159,13 +173,21 @@ func (c *Core) handleRequest(req *logical.Request) (retResp *logical.Response, r
 		return nil, auth, retErr
 	}
 
+	// The path within the namespace of the request decides the special
+	// handling of renewals and tokens
+	relPath := strings.TrimPrefix(req.Path, c.namespaceForPath(req.Path).Path)
+
 	// Route the request
 	resp, err := c.router.Route(req)
 	if resp != nil {
-		// We don't allow backends to specify this, so ensure it's not set
-		resp.WrapInfo = nil
+		// We don't allow backends to specify this, so ensure it's not set.
+		// Rewrapping is the exception, as it wraps the response of the
+		// original wrapping token itself.
+		if !c.isRewrapPath(req.Path) {
+			resp.WrapInfo = nil
+		}
 
-		if req.WrapTTL != 0 {
+		if req.WrapTTL != 0 && resp.WrapInfo == nil {
 			resp.WrapInfo = &logical.WrapInfo{
 				TTL: req.WrapTTL,
 			}
@@ -174,7 +196,7 @@ func (c *Core) handleRequest(req *logical.Request) (retResp *logical.Response, r
 
 	// If there is a secret, we must register it with the expiration manager.
 	// We exclude renewal of a lease, since it does not need to be re-registered
-	if resp != nil && resp.Secret != nil && !strings.HasPrefix(req.Path, "sys/renew/") {
+	if resp != nil && resp.Secret != nil && !strings.HasPrefix(relPath, "sys/renew/") {
 		// Get the SystemView for the mount
 		sysView := c.router.MatchingSystemView(req.Path)
 		if sysView == nil {
@@ -226,8 +248,8 @@ func (c *Core) handleRequest(req *logical.Request) (retResp *logical.Response, r
 	// Only the token store is allowed to return an auth block, for any
 	// other request this is an internal error. We exclude renewal of a token,
 	// since it does not need to be re-registered
-	if resp != nil && resp.Auth != nil && !strings.HasPrefix(req.Path, "auth/token/renew") {
-		if !strings.HasPrefix(req.Path, "auth/token/") {
+	if resp != nil && resp.Auth != nil && !strings.HasPrefix(relPath, "auth/token/renew") {
+		if !strings.HasPrefix(relPath, "auth/token/") {
 			c.logger.Printf(
 				"[ERR] core: unexpected Auth response for non-token backend "+
 					"(request path: %s)", req.Path)
@@ -287,7 +309,16 @@ func (c *Core) handleLoginRequest(req *logical.Request) (*logical.Response, *log
 		auth = resp.Auth
 
 		// Determine the source of the login
+		entry := c.router.MatchingMountEntry(req.Path)
+		if entry == nil {
+			c.logger.Printf("[ERR] core: unable to look up mount entry for login path"+
+				"(request path: %s)", req.Path)
+			return nil, nil, ErrInternalError
+		}
 		source := c.router.MatchingMount(req.Path)
+		if entry.namespace != nil {
+			source = strings.TrimPrefix(source, entry.namespace.Path)
+		}
 		source = strings.TrimPrefix(source, credentialRoutePrefix)
 		source = strings.Replace(source, "/", "-", -1)
 
@@ -316,9 +347,28 @@ func (c *Core) handleLoginRequest(req *logical.Request) (*logical.Response, *log
 			Path:         req.Path,
 			Policies:     auth.Policies,
 			Meta:         auth.Metadata,
+			LoginMeta:    auth.Metadata,
 			DisplayName:  auth.DisplayName,
 			CreationTime: time.Now().Unix(),
 			TTL:          auth.TTL,
+			NamespaceID:  entry.NamespaceID,
+		}
+
+		// Attach the token to the entity of the login alias, if the
+		// backend reports one. Identities are only kept for the root
+		// namespace.
+		if auth.Alias != nil && c.identityStore != nil && entry.NamespaceID == rootNamespaceID {
+			entity, err := c.identityStore.loginEntity(
+				entry, auth.Alias, auth.GroupAliases)
+			if err != nil {
+				c.logger.Printf("[ERR] core: failed to map login to an identity entity "+
+					"(request path: %s): %v", req.Path, err)
+				return nil, auth, ErrInternalError
+			}
+			if entity != nil {
+				te.EntityID = entity.ID
+				auth.EntityID = entity.ID
+			}
 		}
 
 		if strutil.StrListSubset(te.Policies, []string{"root"}) {
@@ -375,24 +425,11 @@ func (c *Core) wrapInCubbyhole(req *logical.Request, resp *logical.Response) (*l
 	// before auditing so that resp.WrapInfo.Token can contain the HMAC'd
 	// wrapping token ID in the audit logs, so that it can be determined from
 	// the audit logs whether the token was ever actually used.
-	creationTime := time.Now()
-	te := TokenEntry{
-		Path:           req.Path,
-		Policies:       []string{"response-wrapping"},
-		CreationTime:   creationTime.Unix(),
-		TTL:            resp.WrapInfo.TTL,
-		NumUses:        1,
-		ExplicitMaxTTL: resp.WrapInfo.TTL,
-	}
-
-	if err := c.tokenStore.create(&te); err != nil {
-		c.logger.Printf("[ERR] core: failed to create wrapping token: %v", err)
-		return nil, ErrInternalError
+	te, err := c.createWrappingToken(req.Path, resp.WrapInfo)
+	if err != nil {
+		return nil, err
 	}
 
-	resp.WrapInfo.Token = te.ID
-	resp.WrapInfo.CreationTime = creationTime
-
 	// This will only be non-nil if this response contains a token, so in that
 	// case put the accessor in the wrap info.
 	if resp.Auth != nil {
@@ -410,16 +447,56 @@ func (c *Core) wrapInCubbyhole(req *logical.Request, resp *logical.Response) (*l
 
 	marshaledResponse, err := json.Marshal(httpResponse)
 	if err != nil {
+		// Revoke since it's not yet being tracked for expiration
+		c.tokenStore.Revoke(te.ID)
 		c.logger.Printf("[ERR] core: failed to marshal wrapped response: %v", err)
 		return nil, ErrInternalError
 	}
 
+	return c.storeWrappedResponse(te, string(marshaledResponse))
+}
+
+// createWrappingToken creates a response-wrapping token with the TTL of the
+// wrap info, and fills in the rest of the wrap info. The creation path is
+// the one of the wrap info if set, e.g. when rewrapping.
+func (c *Core) createWrappingToken(path string, wrapInfo *logical.WrapInfo) (*TokenEntry, error) {
+	creationTime := time.Now()
+	creationPath := path
+	if wrapInfo.CreationPath != "" {
+		creationPath = wrapInfo.CreationPath
+	}
+
+	te := TokenEntry{
+		Path:           creationPath,
+		Policies:       []string{"response-wrapping"},
+		CreationTime:   creationTime.Unix(),
+		TTL:            wrapInfo.TTL,
+		NumUses:        1,
+		ExplicitMaxTTL: wrapInfo.TTL,
+	}
+
+	if err := c.tokenStore.create(&te); err != nil {
+		c.logger.Printf("[ERR] core: failed to create wrapping token: %v", err)
+		return nil, ErrInternalError
+	}
+
+	wrapInfo.Token = te.ID
+	wrapInfo.CreationTime = creationTime
+	wrapInfo.CreationPath = creationPath
+
+	return &te, nil
+}
+
+// storeWrappedResponse stores the marshaled response in the cubbyhole of a
+// response-wrapping token and registers the token with the expiration
+// manager. The token is revoked if either fails.
+func (c *Core) storeWrappedResponse(te *TokenEntry, response string) (*logical.Response, error) {
 	cubbyReq := &logical.Request{
 		Operation:   logical.CreateOperation,
 		Path:        "cubbyhole/response",
 		ClientToken: te.ID,
 		Data: map[string]interface{}{
-			"response": string(marshaledResponse),
+			"response": response,
 		},
 	}
 
@@ -450,9 +527,19 @@ func (c *Core) wrapInCubbyhole(req *logical.Request, resp *logical.Response) (*l
 		// Revoke since it's not yet being tracked for expiration
 		c.tokenStore.Revoke(te.ID)
 		c.logger.Printf("[ERR] core: failed to register cubbyhole wrapping token lease "+
-			"(request path: %s): %v", req.Path, err)
+			"(request path: %s): %v", te.Path, err)
 		return nil, ErrInternalError
 	}
 
 	return nil, nil
 }
+
+// isRewrapPath checks if the path is the rewrap path of the system backend
+// of the root namespace, whose responses are wrapped by the backend itself
+func (c *Core) isRewrapPath(path string) bool {
+	entry := c.router.MatchingMountEntry(path)
+	if entry == nil || entry.Type != "system" || entry.NamespaceID != rootNamespaceID {
+		return false
+	}
+	return strings.TrimPrefix(path, c.router.MatchingMount(path)) == "wrapping/rewrap"
+}
@@ -112,10 +112,7 @@ func (m *RollbackManager) triggerRollbacks() {
 	backends := m.backends()
 
 	for _, e := range backends {
-		path := e.Path
-		if e.Table == credentialTableType {
-			path = "auth/" + path
-		}
+		path := e.routePath()
 		if _, ok := m.inflight[path]; !ok {
 			m.startRollback(path)
 		}
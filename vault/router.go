@@ -35,6 +35,8 @@ type routeEntry struct {
 	storageView *BarrierView
 	rootPaths   *radix.Tree
 	loginPaths  *radix.Tree
+	queryPaths  *radix.Tree
+	headPaths   *radix.Tree
 }
 
 // SaltID is used to apply a salt and hash to an ID to make sure its not reversible
@@ -67,6 +69,8 @@ func (r *Router) Mount(backend logical.Backend, prefix string, mountEntry *Mount
 		storageView: storageView,
 		rootPaths:   pathsToRadix(paths.Root),
 		loginPaths:  pathsToRadix(paths.Unauthenticated),
+		queryPaths:  pathsToRadix(paths.QueryParams),
+		headPaths:   pathsToRadix(paths.Head),
 	}
 	r.root.Insert(prefix, re)
 
@@ -237,10 +241,20 @@ func (r *Router) routeCommon(req *logical.Request, existenceCheck bool) (*logica
 	clientToken := req.ClientToken
 	switch {
 	case strings.HasPrefix(original, "auth/token/"):
+	case re.mountEntry != nil && re.mountEntry.Table == credentialTableType && re.mountEntry.Type == "token":
+		// The token stores of the namespaces share the root one
+	case re.mountEntry != nil && re.mountEntry.Type == "system" &&
+		re.mountEntry.NamespaceID == rootNamespaceID && strings.HasPrefix(req.Path, "wrapping/"):
+		// Unwrapping reads the cubbyhole of the client token
 	case strings.HasPrefix(original, "cubbyhole/"):
 		// In order for the token store to revoke later, we need to have the same
 		// salted ID, so we double-salt what's going to the cubbyhole backend
 		req.ClientToken = re.SaltID(r.tokenStoreSalt.SaltID(req.ClientToken))
+	case re.mountEntry != nil && re.mountEntry.Type == "cubbyhole":
+		// The cubbyholes of the namespaces share the root one, so the salt
+		// of its entry is used
+		ch := re.backend.(*CubbyholeBackend)
+		req.ClientToken = salt.SaltID(ch.saltUUID, r.tokenStoreSalt.SaltID(req.ClientToken), salt.SHA1Hash)
 	default:
 		req.ClientToken = re.SaltID(req.ClientToken)
 	}
@@ -325,6 +339,64 @@ func (r *Router) LoginPath(path string) bool {
 	return match == remain
 }
 
+// QueryParamsPath checks if the given path takes query parameters on reads
+func (r *Router) QueryParamsPath(path string) bool {
+	r.l.RLock()
+	mount, raw, ok := r.root.LongestPrefix(path)
+	r.l.RUnlock()
+	if !ok {
+		return false
+	}
+	re := raw.(*routeEntry)
+
+	// Trim to get remaining path
+	remain := strings.TrimPrefix(path, mount)
+
+	// Check the queryPaths of this backend
+	match, raw, ok := re.queryPaths.LongestPrefix(remain)
+	if !ok {
+		return false
+	}
+	prefixMatch := raw.(bool)
+
+	// Handle the prefix match case
+	if prefixMatch {
+		return strings.HasPrefix(remain, match)
+	}
+
+	// Handle the exact match case
+	return match == remain
+}
+
+// HeadPath checks if the given path serves HEAD requests
+func (r *Router) HeadPath(path string) bool {
+	r.l.RLock()
+	mount, raw, ok := r.root.LongestPrefix(path)
+	r.l.RUnlock()
+	if !ok {
+		return false
+	}
+	re := raw.(*routeEntry)
+
+	// Trim to get remaining path
+	remain := strings.TrimPrefix(path, mount)
+
+	// Check the headPaths of this backend
+	match, raw, ok := re.headPaths.LongestPrefix(remain)
+	if !ok {
+		return false
+	}
+	prefixMatch := raw.(bool)
+
+	// Handle the prefix match case
+	if prefixMatch {
+		return strings.HasPrefix(remain, match)
+	}
+
+	// Handle the exact match case
+	return match == remain
+}
+
 // pathsToRadix converts a the mapping of special paths to a mapping
 // of special paths to radix trees.
 func pathsToRadix(paths []string) *radix.Tree {
@@ -16,6 +16,7 @@ type NoopBackend struct {
 
 	Root     []string
 	Login    []string
+	Query    []string
 	Paths    []string
 	Requests []*logical.Request
 	Response *logical.Response
@@ -43,6 +44,7 @@ func (n *NoopBackend) SpecialPaths() *logical.Paths {
 	return &logical.Paths{
 		Root:            n.Root,
 		Unauthenticated: n.Login,
+		QueryParams:     n.Query,
 	}
 }
 
@@ -272,6 +274,46 @@ func TestRouter_LoginPath(t *testing.T) {
 	}
 }
 
+func TestRouter_QueryParamsPath(t *testing.T) {
+	r := NewRouter()
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+
+	meUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &NoopBackend{
+		Query: []string{
+			"version",
+			"data/*",
+		},
+	}
+	err = r.Mount(n, "prod/aws/", &MountEntry{UUID: meUUID}, view)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type tcase struct {
+		path   string
+		expect bool
+	}
+	tcases := []tcase{
+		{"random", false},
+		{"prod/aws/foo", false},
+		{"prod/aws/version", true},
+		{"prod/aws/data", false},
+		{"prod/aws/data/foo", true},
+	}
+
+	for _, tc := range tcases {
+		out := r.QueryParamsPath(tc.path)
+		if out != tc.expect {
+			t.Fatalf("bad: path: %s expect: %v got %v", tc.path, tc.expect, out)
+		}
+	}
+}
+
 func TestRouter_Taint(t *testing.T) {
 	r := NewRouter()
 	_, barrier, _ := mockBarrier(t)
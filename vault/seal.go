@@ -20,10 +20,12 @@ const (
 	barrierSealConfigPath = "core/seal-config"
 
 	// recoverySealConfigPath is the path to the recovery key seal
-	// configuration. It is inside the barrier.
+	// configuration. Like the barrier seal configuration, it is stored in
+	// plaintext so that it can be read with the Vault sealed.
 	recoverySealConfigPath = "core/recovery-seal-config"
 
-	// recoveryKeyPath is the path to the recovery key
+	// recoveryKeyPath is the path to the recovery key. The value is
+	// encrypted by the seal.
 	recoveryKeyPath = "core/recovery-key"
 )
 
@@ -0,0 +1,291 @@
+package vault
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/vault/physical"
+)
+
+const (
+	// storedBarrierKeysPath is the path used to store the barrier keys of a
+	// seal supporting stored keys. The value is encrypted by the seal.
+	storedBarrierKeysPath = "core/hsm/barrier-unseal-keys"
+)
+
+// sealEncryptor is used by an autoSeal to protect the values it stores,
+// using a key which is kept outside of Vault
+type sealEncryptor interface {
+	// Encrypt encrypts the plaintext with the key of the seal
+	Encrypt(plaintext []byte) ([]byte, error)
+
+	// Decrypt decrypts a ciphertext returned by Encrypt
+	Decrypt(ciphertext []byte) ([]byte, error)
+
+	// Finalize releases any resource held by the encryptor
+	Finalize() error
+}
+
+// autoSeal is a seal storing the barrier key encrypted by an external key,
+// so that Vault can be unsealed without any operator. Recovery keys are used
+// in place of the unseal keys for root token generation.
+type autoSeal struct {
+	sealType  string
+	encryptor sealEncryptor
+
+	core           *Core
+	config         *SealConfig
+	recoveryConfig *SealConfig
+}
+
+func newAutoSeal(sealType string, encryptor sealEncryptor) *autoSeal {
+	return &autoSeal{
+		sealType:  sealType,
+		encryptor: encryptor,
+	}
+}
+
+func (a *autoSeal) checkCore() error {
+	if a.core == nil {
+		return fmt.Errorf("seal does not have a core set")
+	}
+	return nil
+}
+
+func (a *autoSeal) SetCore(core *Core) {
+	a.core = core
+}
+
+func (a *autoSeal) Init() error {
+	return nil
+}
+
+func (a *autoSeal) Finalize() error {
+	return a.encryptor.Finalize()
+}
+
+func (a *autoSeal) BarrierType() string {
+	return a.sealType
+}
+
+func (a *autoSeal) StoredKeysSupported() bool {
+	return true
+}
+
+func (a *autoSeal) RecoveryKeySupported() bool {
+	return true
+}
+
+func (a *autoSeal) RecoveryType() string {
+	return "shamir"
+}
+
+// SetStoredKeys encrypts the keys and stores them in the physical backend
+func (a *autoSeal) SetStoredKeys(keys [][]byte) error {
+	if err := a.checkCore(); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("failed to encode keys for storage: %v", err)
+	}
+
+	if err := a.putEncrypted(storedBarrierKeysPath, buf); err != nil {
+		a.core.logger.Printf("[ERR] core: failed to write stored keys: %v", err)
+		return fmt.Errorf("failed to write stored keys: %v", err)
+	}
+	return nil
+}
+
+// GetStoredKeys fetches the stored keys and decrypts them
+func (a *autoSeal) GetStoredKeys() ([][]byte, error) {
+	if err := a.checkCore(); err != nil {
+		return nil, err
+	}
+
+	buf, err := a.getEncrypted(storedBarrierKeysPath)
+	if err != nil {
+		a.core.logger.Printf("[ERR] core: failed to read stored keys: %v", err)
+		return nil, fmt.Errorf("failed to read stored keys: %v", err)
+	}
+	if buf == nil {
+		return nil, nil
+	}
+
+	var keys [][]byte
+	if err := json.Unmarshal(buf, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode stored keys: %v", err)
+	}
+	return keys, nil
+}
+
+func (a *autoSeal) BarrierConfig() (*SealConfig, error) {
+	if a.config != nil {
+		return a.config.Clone(), nil
+	}
+
+	conf, err := a.readConfig(barrierSealConfigPath, a.sealType)
+	if err != nil {
+		return nil, err
+	}
+	if conf == nil {
+		a.core.logger.Printf("[INFO] core: seal configuration missing, not initialized")
+		return nil, nil
+	}
+
+	a.config = conf
+	return a.config.Clone(), nil
+}
+
+func (a *autoSeal) SetBarrierConfig(config *SealConfig) error {
+	config.Type = a.sealType
+	if err := a.writeConfig(barrierSealConfigPath, config); err != nil {
+		return err
+	}
+
+	a.config = config.Clone()
+	return nil
+}
+
+func (a *autoSeal) RecoveryConfig() (*SealConfig, error) {
+	if a.recoveryConfig != nil {
+		return a.recoveryConfig.Clone(), nil
+	}
+
+	conf, err := a.readConfig(recoverySealConfigPath, a.RecoveryType())
+	if err != nil {
+		return nil, err
+	}
+	if conf == nil {
+		return nil, nil
+	}
+
+	a.recoveryConfig = conf
+	return a.recoveryConfig.Clone(), nil
+}
+
+func (a *autoSeal) SetRecoveryConfig(config *SealConfig) error {
+	config.Type = a.RecoveryType()
+	if err := a.writeConfig(recoverySealConfigPath, config); err != nil {
+		return err
+	}
+
+	a.recoveryConfig = config.Clone()
+	return nil
+}
+
+// SetRecoveryKey encrypts the recovery key and stores it in the physical
+// backend, so that it can be verified even while sealed
+func (a *autoSeal) SetRecoveryKey(key []byte) error {
+	if err := a.checkCore(); err != nil {
+		return err
+	}
+
+	if err := a.putEncrypted(recoveryKeyPath, key); err != nil {
+		a.core.logger.Printf("[ERR] core: failed to write recovery key: %v", err)
+		return fmt.Errorf("failed to write recovery key: %v", err)
+	}
+	return nil
+}
+
+func (a *autoSeal) VerifyRecoveryKey(key []byte) error {
+	if err := a.checkCore(); err != nil {
+		return err
+	}
+
+	stored, err := a.getEncrypted(recoveryKeyPath)
+	if err != nil {
+		a.core.logger.Printf("[ERR] core: failed to read recovery key: %v", err)
+		return fmt.Errorf("failed to read recovery key: %v", err)
+	}
+	if stored == nil {
+		return fmt.Errorf("no recovery key found")
+	}
+
+	if subtle.ConstantTimeCompare(stored, key) != 1 {
+		return fmt.Errorf("recovery key verification failed")
+	}
+	return nil
+}
+
+// readConfig reads a plaintext seal configuration from the physical backend
+func (a *autoSeal) readConfig(path, sealType string) (*SealConfig, error) {
+	if err := a.checkCore(); err != nil {
+		return nil, err
+	}
+
+	pe, err := a.core.physical.Get(path)
+	if err != nil {
+		a.core.logger.Printf("[ERR] core: failed to read seal configuration: %v", err)
+		return nil, fmt.Errorf("failed to check seal configuration: %v", err)
+	}
+	if pe == nil {
+		return nil, nil
+	}
+
+	var conf SealConfig
+	if err := json.Unmarshal(pe.Value, &conf); err != nil {
+		a.core.logger.Printf("[ERR] core: failed to decode seal configuration: %v", err)
+		return nil, fmt.Errorf("failed to decode seal configuration: %v", err)
+	}
+
+	if conf.Type != sealType {
+		a.core.logger.Printf("[ERR] core: seal type of %s does not match loaded type of %s", sealType, conf.Type)
+		return nil, fmt.Errorf("seal type of %s does not match loaded type of %s", sealType, conf.Type)
+	}
+
+	if err := conf.Validate(); err != nil {
+		a.core.logger.Printf("[ERR] core: invalid seal configuration: %v", err)
+		return nil, fmt.Errorf("seal validation failed: %v", err)
+	}
+
+	return &conf, nil
+}
+
+// writeConfig writes a plaintext seal configuration to the physical backend
+func (a *autoSeal) writeConfig(path string, config *SealConfig) error {
+	if err := a.checkCore(); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to encode seal configuration: %v", err)
+	}
+
+	pe := &physical.Entry{
+		Key:   path,
+		Value: buf,
+	}
+	if err := a.core.physical.Put(pe); err != nil {
+		a.core.logger.Printf("[ERR] core: failed to write seal configuration: %v", err)
+		return fmt.Errorf("failed to write seal configuration: %v", err)
+	}
+	return nil
+}
+
+func (a *autoSeal) putEncrypted(path string, plaintext []byte) error {
+	ciphertext, err := a.encryptor.Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+
+	return a.core.physical.Put(&physical.Entry{
+		Key:   path,
+		Value: ciphertext,
+	})
+}
+
+func (a *autoSeal) getEncrypted(path string) ([]byte, error) {
+	pe, err := a.core.physical.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if pe == nil {
+		return nil, nil
+	}
+
+	return a.encryptor.Decrypt(pe.Value)
+}
@@ -0,0 +1,319 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/shamir"
+)
+
+// setupSealMigration compares the type of the stored barrier seal
+// configuration with the configured seal. If they differ, the storage stays
+// sealed with its current seal until the migration is completed with
+// UnsealMigrate. The seal to migrate from is Shamir, unless fromSeal is
+// given.
+func (c *Core) setupSealMigration(fromSeal Seal) error {
+	// Without an auto seal on either side there is nothing to migrate, and
+	// the storage is not accessed before unsealing
+	if fromSeal == nil && !c.seal.StoredKeysSupported() {
+		return nil
+	}
+
+	storedType, err := c.storedBarrierSealType()
+	if err != nil {
+		return err
+	}
+	if storedType == "" || storedType == c.seal.BarrierType() {
+		return nil
+	}
+
+	if fromSeal == nil || fromSeal.BarrierType() != storedType {
+		if storedType != "shamir" {
+			return fmt.Errorf("storage is sealed with a seal of type %s, which is not configured", storedType)
+		}
+		fromSeal = &DefaultSeal{}
+	}
+	fromSeal.SetCore(c)
+
+	c.logger.Printf("[WARN] core: seal migration from %s to %s pending; unseal with the migrate option to complete it",
+		fromSeal.BarrierType(), c.seal.BarrierType())
+	c.migrationSeal = c.seal
+	c.seal = fromSeal
+	return nil
+}
+
+// storedBarrierSealType returns the type of the stored barrier seal
+// configuration, or an empty string if Vault is not initialized
+func (c *Core) storedBarrierSealType() (string, error) {
+	pe, err := c.physical.Get(barrierSealConfigPath)
+	if err != nil {
+		c.logger.Printf("[ERR] core: failed to read seal configuration: %v", err)
+		return "", fmt.Errorf("failed to check seal configuration: %v", err)
+	}
+	if pe == nil {
+		return "", nil
+	}
+
+	var conf SealConfig
+	if err := json.Unmarshal(pe.Value, &conf); err != nil {
+		c.logger.Printf("[ERR] core: failed to decode seal configuration: %v", err)
+		return "", fmt.Errorf("failed to decode seal configuration: %v", err)
+	}
+	if conf.Type == "" {
+		return "shamir", nil
+	}
+	return conf.Type, nil
+}
+
+// SealMigrationPending returns whether the storage must be migrated to the
+// configured seal with UnsealMigrate
+func (c *Core) SealMigrationPending() bool {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	return c.migrationSeal != nil
+}
+
+// sealMigrationKeyConfig returns the configuration of the keys provided to
+// UnsealMigrate: the recovery keys when migrating from an auto seal, the
+// unseal keys otherwise
+func (c *Core) sealMigrationKeyConfig() (*SealConfig, error) {
+	if c.seal.RecoveryKeySupported() {
+		return c.seal.RecoveryConfig()
+	}
+	return c.seal.BarrierConfig()
+}
+
+// SealMigrationKeyConfig returns the configuration of the keys to provide to
+// UnsealMigrate, or nil if no seal migration is pending
+func (c *Core) SealMigrationKeyConfig() (*SealConfig, error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.migrationSeal == nil {
+		return nil, nil
+	}
+	return c.sealMigrationKeyConfig()
+}
+
+// UnsealMigrate is used like Unseal to provide one of the key parts while a
+// seal migration is pending. These are the unseal keys when migrating from
+// Shamir, and the recovery keys when migrating from an auto seal. Once the
+// threshold is reached, the storage is migrated to the configured seal and
+// the Vault is unsealed.
+//
+// The unseal keys become the recovery keys when migrating to an auto seal,
+// and the recovery keys become the unseal keys when migrating to Shamir.
+// Each step of the migration can be repeated, so an interrupted migration
+// is resumed by providing the same keys again.
+//
+// The key given as a parameter will automatically be zerod after this
+// method is done with it.
+func (c *Core) UnsealMigrate(key []byte) (bool, error) {
+	defer metrics.MeasureSince([]string{"core", "unseal_migrate"}, time.Now())
+
+	// Verify the key length
+	min, max := c.barrier.KeyLength()
+	max += shamir.ShareOverhead
+	if len(key) < min {
+		return false, &ErrInvalidKey{fmt.Sprintf("key is shorter than minimum %d bytes", min)}
+	}
+	if len(key) > max {
+		return false, &ErrInvalidKey{fmt.Sprintf("key is longer than maximum %d bytes", max)}
+	}
+
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	// Check if already unsealed
+	if !c.sealed {
+		return true, nil
+	}
+
+	if c.migrationSeal == nil {
+		return false, fmt.Errorf("no seal migration pending")
+	}
+
+	config, err := c.sealMigrationKeyConfig()
+	if err != nil {
+		return false, err
+	}
+	if config == nil {
+		return false, ErrNotInit
+	}
+
+	// Check if we already have this piece
+	for _, existing := range c.unlockParts {
+		if bytes.Equal(existing, key) {
+			return false, nil
+		}
+	}
+
+	// Store this key
+	c.unlockParts = append(c.unlockParts, key)
+
+	// Check if we don't have enough keys to unlock
+	if len(c.unlockParts) < config.SecretThreshold {
+		c.logger.Printf("[DEBUG] core: cannot migrate seal, have %d of %d keys",
+			len(c.unlockParts), config.SecretThreshold)
+		return false, nil
+	}
+
+	// Recover the key
+	var combinedKey []byte
+	if config.SecretThreshold == 1 {
+		combinedKey = c.unlockParts[0]
+		c.unlockParts = nil
+	} else {
+		combinedKey, err = shamir.Combine(c.unlockParts)
+		c.unlockParts = nil
+		if err != nil {
+			return false, fmt.Errorf("failed to compute key: %v", err)
+		}
+	}
+	defer memzero(combinedKey)
+
+	if err := c.migrateSeal(combinedKey, config); err != nil {
+		c.logger.Printf("[ERR] core: seal migration failed: %v", err)
+		if sealErr := c.barrier.Seal(); sealErr != nil {
+			c.logger.Printf("[ERR] core: failed to seal barrier: %v", sealErr)
+		}
+		return false, err
+	}
+	c.logger.Printf("[INFO] core: vault is unsealed")
+
+	return c.unsealInternal()
+}
+
+// migrateSeal unseals the barrier with the combined key, then re-wraps the
+// master key and the seal configurations with the configured seal. Writing
+// the barrier seal configuration completes the migration; every earlier
+// step can be repeated if it is interrupted.
+func (c *Core) migrateSeal(key []byte, keyConfig *SealConfig) error {
+	from, to := c.seal, c.migrationSeal
+
+	masterKey, err := c.unsealForSealMigration(key)
+	if err != nil {
+		return err
+	}
+
+	// The shares of the provided key are kept, whether they become unseal
+	// or recovery keys
+	sharesConfig := &SealConfig{
+		SecretShares:    keyConfig.SecretShares,
+		SecretThreshold: keyConfig.SecretThreshold,
+	}
+
+	var barrierConfig *SealConfig
+	if to.StoredKeysSupported() {
+		// The provided key becomes the recovery key
+		if err := to.SetRecoveryConfig(sharesConfig.Clone()); err != nil {
+			return fmt.Errorf("failed to store recovery configuration: %v", err)
+		}
+		if err := to.SetRecoveryKey(key); err != nil {
+			return fmt.Errorf("failed to store recovery key: %v", err)
+		}
+
+		// When migrating from Shamir the master key is the provided key. A
+		// new one is generated, so that the recovery key cannot decrypt the
+		// barrier.
+		if bytes.Equal(masterKey, key) {
+			masterKey, err = c.barrier.GenerateKey()
+			if err != nil {
+				return fmt.Errorf("master key generation failed: %v", err)
+			}
+			defer memzero(masterKey)
+
+			if err := to.SetStoredKeys([][]byte{masterKey}); err != nil {
+				return fmt.Errorf("failed to store keys: %v", err)
+			}
+			if err := c.barrier.Rekey(masterKey); err != nil {
+				return fmt.Errorf("failed to rekey barrier: %v", err)
+			}
+		} else {
+			if err := to.SetStoredKeys([][]byte{masterKey}); err != nil {
+				return fmt.Errorf("failed to store keys: %v", err)
+			}
+		}
+
+		barrierConfig = &SealConfig{
+			SecretShares:    1,
+			SecretThreshold: 1,
+			StoredShares:    1,
+		}
+	} else {
+		// The recovery key becomes the master key, so that the recovery
+		// keys become the unseal keys
+		if !bytes.Equal(masterKey, key) {
+			if err := c.barrier.Rekey(key); err != nil {
+				return fmt.Errorf("failed to rekey barrier: %v", err)
+			}
+		}
+
+		barrierConfig = sharesConfig
+	}
+
+	if err := to.SetBarrierConfig(barrierConfig); err != nil {
+		return fmt.Errorf("failed to store seal configuration: %v", err)
+	}
+
+	c.logger.Printf("[INFO] core: seal migration from %s to %s complete",
+		from.BarrierType(), to.BarrierType())
+	c.seal = to
+	c.migrationSeal = nil
+	return nil
+}
+
+// unsealForSealMigration unseals the barrier with the master key matching
+// the key provided for the migration. The key is either the master key, or
+// a recovery key of the seal migrated from or to, in which case the master
+// key is the one stored by that seal. Both seals are checked, as an
+// interrupted migration leaves some values written by the seal migrated to.
+func (c *Core) unsealForSealMigration(key []byte) ([]byte, error) {
+	var candidates [][]byte
+	for _, seal := range []Seal{c.seal, c.migrationSeal} {
+		if !seal.StoredKeysSupported() {
+			continue
+		}
+		if err := seal.VerifyRecoveryKey(key); err != nil {
+			continue
+		}
+		keys, err := seal.GetStoredKeys()
+		if err != nil {
+			c.logger.Printf("[WARN] core: failed to fetch the keys stored by the %s seal: %v", seal.BarrierType(), err)
+			continue
+		}
+		candidates = append(candidates, keys...)
+	}
+	candidates = append(candidates, key)
+
+	for _, candidate := range candidates {
+		if err := c.barrier.Unseal(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return nil, &ErrInvalidKey{"key does not match the master key or a recovery key"}
+}
+
+// cleanupSealMigration removes the values stored by an auto seal, which
+// remain if a migration to Shamir was interrupted after completing
+func (c *Core) cleanupSealMigration() {
+	pe, err := c.physical.Get(storedBarrierKeysPath)
+	if err != nil {
+		c.logger.Printf("[WARN] core: failed to check for stored keys: %v", err)
+		return
+	}
+	if pe == nil {
+		return
+	}
+
+	// The stored keys are deleted last, as they mark the leftovers
+	for _, path := range []string{recoveryKeyPath, recoverySealConfigPath, storedBarrierKeysPath} {
+		if err := c.physical.Delete(path); err != nil {
+			c.logger.Printf("[WARN] core: failed to delete %s: %v", path, err)
+			return
+		}
+	}
+	c.logger.Printf("[INFO] core: removed the keys stored by the previous seal")
+}
@@ -0,0 +1,226 @@
+package vault
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/vault/physical"
+)
+
+// testMigrationEncryptor is a reversible encryptor for an auto seal
+type testMigrationEncryptor struct{}
+
+func (e *testMigrationEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	ciphertext := make([]byte, len(plaintext))
+	for i, b := range plaintext {
+		ciphertext[i] = b ^ 0x5c
+	}
+	return ciphertext, nil
+}
+
+func (e *testMigrationEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	return e.Encrypt(ciphertext)
+}
+
+func (e *testMigrationEncryptor) Finalize() error {
+	return nil
+}
+
+func testMigrationAutoSeal() Seal {
+	return newAutoSeal("test-auto", &testMigrationEncryptor{})
+}
+
+// testFailingPutBackend fails to write a given path, which interrupts a
+// seal migration at that step
+type testFailingPutBackend struct {
+	physical.Backend
+	failPath string
+}
+
+func (b *testFailingPutBackend) Put(entry *physical.Entry) error {
+	if entry.Key == b.failPath {
+		return fmt.Errorf("failing put of %s", entry.Key)
+	}
+	return b.Backend.Put(entry)
+}
+
+func testMigrationCore(t *testing.T, phys physical.Backend, seal, migrationSeal Seal) *Core {
+	core, err := NewCore(&CoreConfig{
+		Physical:      phys,
+		Seal:          seal,
+		MigrationSeal: migrationSeal,
+		DisableMlock:  true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return core
+}
+
+func testMigrationUnseal(t *testing.T, core *Core, keys [][]byte) error {
+	var unsealed bool
+	var err error
+	for _, key := range keys {
+		unsealed, err = core.UnsealMigrate(TestKeyCopy(key))
+		if err != nil {
+			return err
+		}
+	}
+	if !unsealed {
+		t.Fatalf("should be unsealed")
+	}
+	return nil
+}
+
+func testCheckSealed(t *testing.T, core *Core, expected bool) {
+	sealed, err := core.Sealed()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if sealed != expected {
+		t.Fatalf("bad: sealed is %v, expected %v", sealed, expected)
+	}
+}
+
+func TestCore_SealMigration(t *testing.T) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	phys := physical.NewInmem(logger)
+
+	core := testMigrationCore(t, phys, nil, nil)
+	result, err := core.Initialize(&SealConfig{
+		SecretShares:    3,
+		SecretThreshold: 2,
+	}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keys := result.SecretShares[:2]
+	for _, key := range keys {
+		if _, err := core.Unseal(TestKeyCopy(key)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := core.barrier.Put(&Entry{Key: "test/foo", Value: []byte("bar")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := core.Seal(result.RootToken); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The migration to the auto seal is interrupted before completing
+	core = testMigrationCore(t, &testFailingPutBackend{
+		Backend:  phys,
+		failPath: barrierSealConfigPath,
+	}, testMigrationAutoSeal(), nil)
+	if !core.SealMigrationPending() {
+		t.Fatalf("seal migration should be pending")
+	}
+	if _, err := core.Unseal(TestKeyCopy(keys[0])); err != ErrSealMigrationPending {
+		t.Fatalf("bad: %v", err)
+	}
+	config, err := core.SealMigrationKeyConfig()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if config.SecretShares != 3 || config.SecretThreshold != 2 {
+		t.Fatalf("bad: %#v", config)
+	}
+	if err := testMigrationUnseal(t, core, keys); err == nil {
+		t.Fatalf("expected error")
+	}
+	testCheckSealed(t, core, true)
+
+	// It is resumed with the same keys
+	core = testMigrationCore(t, phys, testMigrationAutoSeal(), nil)
+	if !core.SealMigrationPending() {
+		t.Fatalf("seal migration should be pending")
+	}
+	if err := testMigrationUnseal(t, core, keys); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testCheckSealed(t, core, false)
+	if core.SealMigrationPending() {
+		t.Fatalf("seal migration should not be pending")
+	}
+
+	// The auto seal unseals the restarted core, and the unseal keys are
+	// now the recovery keys
+	if err := core.Seal(result.RootToken); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	core = testMigrationCore(t, phys, testMigrationAutoSeal(), nil)
+	testCheckSealed(t, core, false)
+	entry, err := core.barrier.Get("test/foo")
+	if err != nil || entry == nil || string(entry.Value) != "bar" {
+		t.Fatalf("bad: %#v %v", entry, err)
+	}
+	config, err = core.seal.RecoveryConfig()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if config.SecretShares != 3 || config.SecretThreshold != 2 {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// The migration back to Shamir is interrupted before completing
+	if err := core.Seal(result.RootToken); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	core = testMigrationCore(t, &testFailingPutBackend{
+		Backend:  phys,
+		failPath: barrierSealConfigPath,
+	}, nil, testMigrationAutoSeal())
+	if !core.SealMigrationPending() {
+		t.Fatalf("seal migration should be pending")
+	}
+	testCheckSealed(t, core, true)
+	if err := testMigrationUnseal(t, core, keys); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Without the auto seal configured, the storage cannot be unsealed
+	if _, err := NewCore(&CoreConfig{
+		Physical:      phys,
+		Seal:          &DefaultSeal{},
+		MigrationSeal: newAutoSeal("other-auto", &testMigrationEncryptor{}),
+		DisableMlock:  true,
+	}); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// It is resumed with the same keys, which become the unseal keys
+	core = testMigrationCore(t, phys, nil, testMigrationAutoSeal())
+	if err := testMigrationUnseal(t, core, keys); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testCheckSealed(t, core, false)
+
+	// The values of the auto seal are removed
+	for _, path := range []string{storedBarrierKeysPath, recoveryKeyPath, recoverySealConfigPath} {
+		pe, err := phys.Get(path)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if pe != nil {
+			t.Fatalf("%s should be deleted", path)
+		}
+	}
+
+	if err := core.Seal(result.RootToken); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	core = testMigrationCore(t, phys, nil, nil)
+	testCheckSealed(t, core, true)
+	for _, key := range keys {
+		if _, err := core.Unseal(TestKeyCopy(key)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	testCheckSealed(t, core, false)
+	entry, err = core.barrier.Get("test/foo")
+	if err != nil || entry == nil || string(entry.Value) != "bar" {
+		t.Fatalf("bad: %#v %v", entry, err)
+	}
+}
@@ -0,0 +1,254 @@
+// +build cgo
+
+package vault
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+const (
+	// pkcs11GCMNonceSize is the size of the nonce prepended to the values
+	// encrypted with an AES key
+	pkcs11GCMNonceSize = 12
+
+	// pkcs11GCMTagBits is the size of the GCM authentication tag in bits
+	pkcs11GCMTagBits = 128
+)
+
+// NewPKCS11Seal returns a seal which protects the barrier key with an AES or
+// RSA key resident in an HSM, accessed through a PKCS#11 library
+func NewPKCS11Seal(conf map[string]string) (Seal, error) {
+	lib, ok := conf["lib"]
+	if !ok || lib == "" {
+		return nil, fmt.Errorf("'lib' must be set")
+	}
+	slotStr, ok := conf["slot"]
+	if !ok || slotStr == "" {
+		return nil, fmt.Errorf("'slot' must be set")
+	}
+	slot, err := strconv.ParseUint(slotStr, 10, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing slot parameter: %v", err)
+	}
+	pin, ok := conf["pin"]
+	if !ok || pin == "" {
+		pin = os.Getenv("VAULT_HSM_PIN")
+	}
+	if pin == "" {
+		return nil, fmt.Errorf("'pin' must be set")
+	}
+	keyLabel, ok := conf["key_label"]
+	if !ok || keyLabel == "" {
+		return nil, fmt.Errorf("'key_label' must be set")
+	}
+
+	encryptor, err := newPKCS11Encryptor(lib, uint(slot), pin, keyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return newAutoSeal("pkcs11", encryptor), nil
+}
+
+// pkcs11Encryptor encrypts the values of a seal with a key of an HSM. AES
+// keys are used with GCM, and RSA keys with OAEP.
+type pkcs11Encryptor struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+
+	// For AES keys, encryptKey and decryptKey are the same object. For RSA
+	// keys, they are the public and private keys of the pair.
+	keyType    uint
+	encryptKey pkcs11.ObjectHandle
+	decryptKey pkcs11.ObjectHandle
+
+	// l serializes the operations, as a session must not be used
+	// concurrently
+	l sync.Mutex
+}
+
+func newPKCS11Encryptor(lib string, slot uint, pin, keyLabel string) (*pkcs11Encryptor, error) {
+	ctx := pkcs11.New(lib)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 library %s", lib)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("failed to initialize PKCS#11 library: %v", err)
+	}
+
+	p := &pkcs11Encryptor{
+		ctx: ctx,
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		p.finalizeCtx()
+		return nil, fmt.Errorf("failed to open PKCS#11 session on slot %d: %v", slot, err)
+	}
+	p.session = session
+
+	if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil {
+		ctx.CloseSession(session)
+		p.finalizeCtx()
+		return nil, fmt.Errorf("failed to log into PKCS#11 slot %d: %v", slot, err)
+	}
+
+	if err := p.findKeys(keyLabel); err != nil {
+		p.Finalize()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// findKeys looks up an AES key with the label, then an RSA key pair
+func (p *pkcs11Encryptor) findKeys(label string) error {
+	aesKey, err := p.findKey(label, pkcs11.CKO_SECRET_KEY, pkcs11.CKK_AES)
+	if err != nil {
+		return err
+	}
+	if aesKey != nil {
+		p.keyType = pkcs11.CKK_AES
+		p.encryptKey = *aesKey
+		p.decryptKey = *aesKey
+		return nil
+	}
+
+	publicKey, err := p.findKey(label, pkcs11.CKO_PUBLIC_KEY, pkcs11.CKK_RSA)
+	if err != nil {
+		return err
+	}
+	privateKey, err := p.findKey(label, pkcs11.CKO_PRIVATE_KEY, pkcs11.CKK_RSA)
+	if err != nil {
+		return err
+	}
+	if publicKey == nil || privateKey == nil {
+		return fmt.Errorf("no AES key or RSA key pair found with label %s", label)
+	}
+
+	p.keyType = pkcs11.CKK_RSA
+	p.encryptKey = *publicKey
+	p.decryptKey = *privateKey
+	return nil
+}
+
+func (p *pkcs11Encryptor) findKey(label string, class, keyType uint) (*pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+	}
+	if err := p.ctx.FindObjectsInit(p.session, template); err != nil {
+		return nil, fmt.Errorf("failed to search PKCS#11 objects: %v", err)
+	}
+	objects, _, err := p.ctx.FindObjects(p.session, 2)
+	if finalErr := p.ctx.FindObjectsFinal(p.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to search PKCS#11 objects: %v", err)
+	}
+
+	switch len(objects) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &objects[0], nil
+	default:
+		return nil, fmt.Errorf("multiple keys found with label %s", label)
+	}
+}
+
+func (p *pkcs11Encryptor) rsaMechanism() []*pkcs11.Mechanism {
+	params := pkcs11.NewOAEPParams(pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1, pkcs11.CKZ_DATA_SPECIFIED, nil)
+	return []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, params)}
+}
+
+func (p *pkcs11Encryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	if p.keyType == pkcs11.CKK_RSA {
+		if err := p.ctx.EncryptInit(p.session, p.rsaMechanism(), p.encryptKey); err != nil {
+			return nil, fmt.Errorf("PKCS#11 encryption failed: %v", err)
+		}
+		ciphertext, err := p.ctx.Encrypt(p.session, plaintext)
+		if err != nil {
+			return nil, fmt.Errorf("PKCS#11 encryption failed: %v", err)
+		}
+		return ciphertext, nil
+	}
+
+	nonce := make([]byte, pkcs11GCMNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	params := pkcs11.NewGCMParams(nonce, nil, pkcs11GCMTagBits)
+	defer params.Free()
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+	if err := p.ctx.EncryptInit(p.session, mechanism, p.encryptKey); err != nil {
+		return nil, fmt.Errorf("PKCS#11 encryption failed: %v", err)
+	}
+	ciphertext, err := p.ctx.Encrypt(p.session, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#11 encryption failed: %v", err)
+	}
+
+	// Some HSMs generate their own nonce, which is then the one to store
+	if iv := params.IV(); len(iv) == pkcs11GCMNonceSize {
+		nonce = iv
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+func (p *pkcs11Encryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	mechanism := p.rsaMechanism()
+	if p.keyType == pkcs11.CKK_AES {
+		if len(ciphertext) < pkcs11GCMNonceSize {
+			return nil, fmt.Errorf("ciphertext too short")
+		}
+		params := pkcs11.NewGCMParams(ciphertext[:pkcs11GCMNonceSize], nil, pkcs11GCMTagBits)
+		defer params.Free()
+		mechanism = []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+		ciphertext = ciphertext[pkcs11GCMNonceSize:]
+	}
+
+	if err := p.ctx.DecryptInit(p.session, mechanism, p.decryptKey); err != nil {
+		return nil, fmt.Errorf("PKCS#11 decryption failed: %v", err)
+	}
+	plaintext, err := p.ctx.Decrypt(p.session, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#11 decryption failed: %v", err)
+	}
+	return plaintext, nil
+}
+
+// Finalize logs out of the HSM and unloads the library
+func (p *pkcs11Encryptor) Finalize() error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	if p.ctx == nil {
+		return nil
+	}
+
+	p.ctx.Logout(p.session)
+	p.ctx.CloseSession(p.session)
+	return p.finalizeCtx()
+}
+
+func (p *pkcs11Encryptor) finalizeCtx() error {
+	err := p.ctx.Finalize()
+	p.ctx.Destroy()
+	p.ctx = nil
+	return err
+}
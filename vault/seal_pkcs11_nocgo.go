@@ -0,0 +1,11 @@
+// +build !cgo
+
+package vault
+
+import "fmt"
+
+// NewPKCS11Seal is not supported without cgo, which is required to load the
+// PKCS#11 library
+func NewPKCS11Seal(conf map[string]string) (Seal, error) {
+	return nil, fmt.Errorf("PKCS#11 support requires Vault to be built with cgo")
+}
@@ -0,0 +1,165 @@
+// +build cgo
+
+package vault
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// testPKCS11Keys creates an AES key and an RSA key pair in the slot, with
+// the given label. Tokens are typically set up with SoftHSM:
+//
+//   softhsm2-util --init-token --free --label vault --pin 1234 --so-pin 1234
+func testPKCS11Keys(t *testing.T, lib string, slot uint, pin, label string) func() {
+	ctx := pkcs11.New(lib)
+	if ctx == nil {
+		t.Fatalf("failed to load %s", lib)
+	}
+	if err := ctx.Initialize(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	aesKey, err := ctx.GenerateKey(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label+"-aes"),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+		})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	publicKey, privateKey, err := ctx.GenerateKeyPair(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label+"-rsa"),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+		},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label+"-rsa"),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+		})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	return func() {
+		for _, key := range []pkcs11.ObjectHandle{aesKey, publicKey, privateKey} {
+			ctx.DestroyObject(session, key)
+		}
+		ctx.Logout(session)
+		ctx.CloseSession(session)
+		ctx.Finalize()
+		ctx.Destroy()
+	}
+}
+
+func TestPKCS11Seal(t *testing.T) {
+	lib := os.Getenv("VAULT_PKCS11_LIB")
+	if lib == "" {
+		t.Skip("VAULT_PKCS11_LIB not set, skipping PKCS#11 seal test")
+	}
+	slot, err := strconv.ParseUint(os.Getenv("VAULT_PKCS11_SLOT"), 10, 0)
+	if err != nil {
+		t.Fatalf("VAULT_PKCS11_SLOT must be set: %v", err)
+	}
+	pin := os.Getenv("VAULT_PKCS11_PIN")
+
+	label := fmt.Sprintf("vault-test-%d", time.Now().UnixNano())
+	cleanup := testPKCS11Keys(t, lib, uint(slot), pin, label)
+	defer cleanup()
+
+	for _, keyType := range []string{"aes", "rsa"} {
+		conf := map[string]string{
+			"lib":       lib,
+			"slot":      strconv.FormatUint(slot, 10),
+			"pin":       pin,
+			"key_label": label + "-" + keyType,
+		}
+		seal, err := NewPKCS11Seal(conf)
+		if err != nil {
+			t.Fatalf("%s: err: %v", keyType, err)
+		}
+		defer seal.Finalize()
+
+		core := TestCoreWithSeal(t, seal)
+		result, err := core.Initialize(&SealConfig{
+			SecretShares:    1,
+			SecretThreshold: 1,
+			StoredShares:    1,
+		}, &SealConfig{
+			SecretShares:    3,
+			SecretThreshold: 2,
+		})
+		if err != nil {
+			t.Fatalf("%s: err: %v", keyType, err)
+		}
+		if len(result.SecretShares) != 0 || len(result.RecoveryShares) != 3 {
+			t.Fatalf("%s: bad: %#v", keyType, result)
+		}
+		if err := core.UnsealWithStoredKeys(); err != nil {
+			t.Fatalf("%s: err: %v", keyType, err)
+		}
+		if sealed, _ := core.Sealed(); sealed {
+			t.Fatalf("%s: should not be sealed", keyType)
+		}
+
+		// A new core on the same storage unseals itself
+		seal2, err := NewPKCS11Seal(conf)
+		if err != nil {
+			t.Fatalf("%s: err: %v", keyType, err)
+		}
+		defer seal2.Finalize()
+		core2, err := NewCore(&CoreConfig{
+			Physical:     core.physical,
+			Seal:         seal2,
+			DisableMlock: true,
+		})
+		if err != nil {
+			t.Fatalf("%s: err: %v", keyType, err)
+		}
+		if sealed, _ := core2.Sealed(); sealed {
+			t.Fatalf("%s: should not be sealed", keyType)
+		}
+
+		if err := seal2.VerifyRecoveryKey([]byte("bad")); err == nil {
+			t.Fatalf("%s: expected error verifying a bad recovery key", keyType)
+		}
+	}
+}
+
+func TestPKCS11Seal_Config(t *testing.T) {
+	for _, conf := range []map[string]string{
+		{"slot": "0", "pin": "1234", "key_label": "vault"},
+		{"lib": "/nonexistent.so", "pin": "1234", "key_label": "vault"},
+		{"lib": "/nonexistent.so", "slot": "zero", "pin": "1234", "key_label": "vault"},
+		{"lib": "/nonexistent.so", "slot": "0", "pin": "1234"},
+		{"lib": "/nonexistent.so", "slot": "0", "pin": "1234", "key_label": "vault"},
+	} {
+		if _, err := NewPKCS11Seal(conf); err == nil {
+			t.Fatalf("expected error for %#v", conf)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-rootcerts"
+)
+
+// NewTransitSeal returns a seal which protects the barrier key with a named
+// key of the transit backend of another Vault
+func NewTransitSeal(conf map[string]string) (Seal, error) {
+	address, ok := conf["address"]
+	if !ok || address == "" {
+		return nil, fmt.Errorf("'address' must be set")
+	}
+	keyName, ok := conf["key_name"]
+	if !ok || keyName == "" {
+		return nil, fmt.Errorf("'key_name' must be set")
+	}
+	mountPath, ok := conf["mount_path"]
+	if !ok || mountPath == "" {
+		mountPath = "transit"
+	}
+	mountPath = strings.Trim(mountPath, "/")
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if err := setupTransitSealTLS(tlsConfig, conf); err != nil {
+		return nil, err
+	}
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.TLSClientConfig = tlsConfig
+
+	token, ok := conf["token"]
+	if !ok || token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("'token' must be set")
+	}
+
+	encryptor := &transitEncryptor{
+		client:    &http.Client{Transport: transport},
+		address:   strings.TrimSuffix(address, "/"),
+		token:     token,
+		mountPath: mountPath,
+		keyName:   keyName,
+	}
+	return newAutoSeal("transit", encryptor), nil
+}
+
+// setupTransitSealTLS configures the TLS settings of the client talking to
+// the transit backend
+func setupTransitSealTLS(tlsConfig *tls.Config, conf map[string]string) error {
+	if v, ok := conf["tls_skip_verify"]; ok && v != "" {
+		skipVerify, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("failed parsing tls_skip_verify parameter: %v", err)
+		}
+		tlsConfig.InsecureSkipVerify = skipVerify
+	}
+
+	if v, ok := conf["tls_server_name"]; ok {
+		tlsConfig.ServerName = v
+	}
+
+	certFile, okCert := conf["tls_cert_file"]
+	keyFile, okKey := conf["tls_key_file"]
+	switch {
+	case okCert && okKey:
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("client tls setup failed: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case okCert || okKey:
+		return fmt.Errorf("both tls_cert_file and tls_key_file must be set")
+	}
+
+	return rootcerts.ConfigureTLS(tlsConfig, &rootcerts.Config{
+		CAFile: conf["tls_ca_file"],
+		CAPath: conf["tls_ca_path"],
+	})
+}
+
+// transitEncryptor encrypts the values of a seal using the transit backend.
+// It talks to the other Vault over plain HTTP rather than through the api
+// package, which depends on this package in its tests.
+type transitEncryptor struct {
+	client    *http.Client
+	address   string
+	token     string
+	mountPath string
+	keyName   string
+}
+
+// write sends an update request to a path of the other Vault, and returns
+// the data of the response
+func (t *transitEncryptor) write(path string, data map[string]interface{}) (map[string]interface{}, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/v1/%s", t.address, path), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", t.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []string               `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("code: %d, errors: %s", resp.StatusCode, strings.Join(result.Errors, ", "))
+	}
+	return result.Data, nil
+}
+
+func (t *transitEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	path := fmt.Sprintf("%s/encrypt/%s", t.mountPath, t.keyName)
+	data, err := t.write(path, map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("transit encryption failed: %v", err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("transit encryption returned no data")
+	}
+
+	ciphertext, ok := data["ciphertext"].(string)
+	if !ok || ciphertext == "" {
+		return nil, fmt.Errorf("transit encryption returned no ciphertext")
+	}
+	return []byte(ciphertext), nil
+}
+
+func (t *transitEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	path := fmt.Sprintf("%s/decrypt/%s", t.mountPath, t.keyName)
+	data, err := t.write(path, map[string]interface{}{
+		"ciphertext": string(ciphertext),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("transit decryption failed: %v", err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("transit decryption returned no data")
+	}
+
+	encoded, ok := data["plaintext"].(string)
+	if !ok {
+		return nil, fmt.Errorf("transit decryption returned no plaintext")
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transit plaintext: %v", err)
+	}
+	return plaintext, nil
+}
+
+func (t *transitEncryptor) Finalize() error {
+	return nil
+}
@@ -329,11 +329,24 @@ func (n *noopAudit) LogResponse(a *logical.Auth, r *logical.Request, re *logical
 type rawHTTP struct{}
 
 func (n *rawHTTP) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	// Echo back raw request bodies
+	body := []byte("hello world")
+	if raw, ok := req.Data[logical.HTTPRawBody].([]byte); ok {
+		body = raw
+	}
+	// Echo back the query parameter of the paths taking query parameters
+	if raw, ok := req.Data["body"].(string); ok {
+		body = []byte(raw)
+	}
+
 	return &logical.Response{
 		Data: map[string]interface{}{
 			logical.HTTPStatusCode:  200,
 			logical.HTTPContentType: "plain/text",
-			logical.HTTPRawBody:     []byte("hello world"),
+			logical.HTTPRawBody:     body,
+			logical.HTTPRawHeaders: map[string][]string{
+				"Cache-Control": []string{"no-store"},
+			},
 		},
 	}, nil
 }
@@ -343,7 +356,11 @@ func (n *rawHTTP) HandleExistenceCheck(req *logical.Request) (bool, bool, error)
 }
 
 func (n *rawHTTP) SpecialPaths() *logical.Paths {
-	return &logical.Paths{Unauthenticated: []string{"*"}}
+	return &logical.Paths{
+		Unauthenticated: []string{"*"},
+		QueryParams:     []string{"query"},
+		Head:            []string{"raw"},
+	}
 }
 
 func (n *rawHTTP) System() logical.SystemView {
@@ -0,0 +1,93 @@
+package vault
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// tidyFunc removes dangling entries from storage and returns the counts of
+// what it checked and removed
+type tidyFunc func() (map[string]int, error)
+
+// tidyStatus runs a tidy operation in the background, and keeps the status
+// of the last one so that it can be read while or after it runs
+type tidyStatus struct {
+	name string
+
+	lock      sync.Mutex
+	running   bool
+	startTime time.Time
+	endTime   time.Time
+	counts    map[string]int
+	err       error
+}
+
+// start runs the tidy operation in the background, unless one is already
+// running
+func (s *tidyStatus) start(f tidyFunc, logger *log.Logger) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.running {
+		return false
+	}
+
+	s.running = true
+	s.startTime = time.Now().UTC()
+	s.endTime = time.Time{}
+	s.counts = nil
+	s.err = nil
+
+	go func() {
+		counts, err := f()
+		if err != nil {
+			logger.Printf("[ERR] %s: tidy operation failed: %v", s.name, err)
+		} else {
+			logger.Printf("[INFO] %s: tidy operation completed: %v", s.name, counts)
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.running = false
+		s.endTime = time.Now().UTC()
+		s.counts = counts
+		s.err = err
+	}()
+	return true
+}
+
+// response returns the status of the last tidy operation
+func (s *tidyStatus) response() *logical.Response {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data := map[string]interface{}{
+		"state":      "none",
+		"start_time": nil,
+		"end_time":   nil,
+		"error":      "",
+	}
+	switch {
+	case s.running:
+		data["state"] = "running"
+	case s.err != nil:
+		data["state"] = "failed"
+		data["error"] = s.err.Error()
+	case !s.endTime.IsZero():
+		data["state"] = "finished"
+	}
+	if !s.startTime.IsZero() {
+		data["start_time"] = s.startTime
+	}
+	if !s.endTime.IsZero() {
+		data["end_time"] = s.endTime
+	}
+	for k, v := range s.counts {
+		data[k] = v
+	}
+	return &logical.Response{
+		Data: data,
+	}
+}
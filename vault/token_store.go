@@ -37,6 +37,14 @@ const (
 
 	// rolesPrefix is the prefix used to store role information
 	rolesPrefix = "roles/"
+
+	// namespaceRolesPrefix is the prefix used to store the role information
+	// of the namespaces other than the root one, below their IDs
+	namespaceRolesPrefix = "namespace-roles/"
+
+	// errTokenNamespace is the error returned when a token of another
+	// namespace is managed
+	errTokenNamespace = "token belongs to another namespace"
 )
 
 var (
@@ -60,9 +68,20 @@ type TokenStore struct {
 
 	cubbyholeBackend *CubbyholeBackend
 
-	policyLookupFunc func(string) (*Policy, error)
+	policyLookupFunc func(string, string) (*Policy, error)
+
+	entityPoliciesFunc func(string) []string
+
+	namespaceForPath func(string) *Namespace
+	namespaceByID    func(string) *Namespace
 
 	tokenLocks map[string]*sync.RWMutex
+
+	// tidyLock is held for reading while a token and its indexes are
+	// created, so that a tidy operation does not remove the indexes of a
+	// token that is not stored yet
+	tidyLock sync.RWMutex
+	tidy     tidyStatus
 }
 
 // NewTokenStore is used to construct a token store that is
@@ -74,10 +93,25 @@ func NewTokenStore(c *Core, config *logical.BackendConfig) (*TokenStore, error)
 	// Initialize the store
 	t := &TokenStore{
 		view: view,
+		tidy: tidyStatus{name: "token"},
 	}
 
 	if c.policyStore != nil {
-		t.policyLookupFunc = c.policyStore.GetPolicy
+		t.policyLookupFunc = func(namespaceID, name string) (*Policy, error) {
+			ps := c.namespacePolicyStore(namespaceID)
+			if ps == nil {
+				return nil, fmt.Errorf("namespace %q not found", namespaceID)
+			}
+			return ps.GetPolicy(name)
+		}
+	}
+	t.namespaceForPath = c.namespaceForPath
+	t.namespaceByID = c.namespaceByID
+	t.entityPoliciesFunc = func(entityID string) []string {
+		if c.identityStore == nil {
+			return nil
+		}
+		return c.identityStore.EntityPolicies(entityID)
 	}
 
 	// Setup the salt
@@ -102,6 +136,7 @@ func NewTokenStore(c *Core, config *logical.BackendConfig) (*TokenStore, error)
 		PathsSpecial: &logical.Paths{
 			Root: []string{
 				"revoke-orphan/*",
+				"tidy",
 			},
 		},
 
@@ -355,6 +390,18 @@ func NewTokenStore(c *Core, config *logical.BackendConfig) (*TokenStore, error)
 				HelpDescription: strings.TrimSpace(tokenRevokeOrphanHelp),
 			},
 
+			&framework.Path{
+				Pattern: "tidy$",
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation:   t.handleTidyStatus,
+					logical.UpdateOperation: t.handleTidy,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(tokenTidyHelp),
+				HelpDescription: strings.TrimSpace(tokenTidyDesc),
+			},
+
 			&framework.Path{
 				Pattern: "renew-self$",
 
@@ -426,6 +473,9 @@ type TokenEntry struct {
 	TTL            time.Duration     // Duration set when token was created
 	ExplicitMaxTTL time.Duration     // Explicit maximum TTL on the token
 	Role           string            // If set, the role that was used for parameters at creation time
+	EntityID       string            // If set, the identity entity whose policies also apply
+	LoginMeta      map[string]string // Metadata set by the auth backend at login, used by templated policies
+	NamespaceID    string            // Namespace of the token, empty for the root namespace
 }
 
 // tsRoleEntry contains token store role information
@@ -518,6 +568,9 @@ func (ts *TokenStore) create(entry *TokenEntry) error {
 
 	entry.Policies = policyutil.SanitizePolicies(entry.Policies, false)
 
+	ts.tidyLock.RLock()
+	defer ts.tidyLock.RUnlock()
+
 	err := ts.createAccessor(entry)
 	if err != nil {
 		return err
@@ -699,6 +752,16 @@ func (ts *TokenStore) lookupSalted(saltedId string) (*TokenEntry, error) {
 	return entry, nil
 }
 
+// tokenExistsSalted returns whether an entry is stored for the given salted
+// token ID, including a token awaiting deferred revocation
+func (ts *TokenStore) tokenExistsSalted(saltedId string) (bool, error) {
+	raw, err := ts.view.Get(lookupPrefix + saltedId)
+	if err != nil {
+		return false, fmt.Errorf("failed to read entry: %v", err)
+	}
+	return raw != nil, nil
+}
+
 // Revoke is used to invalidate a given token, any child tokens
 // will be orphaned.
 func (ts *TokenStore) Revoke(id string) error {
@@ -806,7 +869,7 @@ func (ts *TokenStore) revokeTreeSalted(saltedId string) error {
 func (ts *TokenStore) handleCreateAgainstRole(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	name := d.Get("role_name").(string)
-	roleEntry, err := ts.tokenStoreRole(name)
+	roleEntry, err := ts.tokenStoreRole(ts.requestNamespace(req).ID, name)
 	if err != nil {
 		return nil, err
 	}
@@ -893,6 +956,10 @@ func (ts *TokenStore) handleUpdateRevokeAccessor(req *logical.Request, data *fra
 		return nil, err
 	}
 
+	if resp, err := ts.verifyTokenNamespace(req, tokenID); resp != nil || err != nil {
+		return resp, err
+	}
+
 	// Revoke the token and its children
 	if err := ts.RevokeTree(tokenID); err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -933,6 +1000,15 @@ func (ts *TokenStore) handleCreateCommon(
 	// Check if the client token has sudo/root privileges for the requested path
 	isSudo := ts.System().SudoPrivilege(req.MountPoint+req.Path, req.ClientToken)
 
+	// The token is created in the namespace of the request. Creating it in
+	// another namespace than the one of its parent requires sudo privileges,
+	// which a namespace admin has for the namespaces below its own.
+	ns := ts.requestNamespace(req)
+	if ns.ID != parent.NamespaceID && !isSudo {
+		return logical.ErrorResponse("root or sudo privileges required to create a token in another namespace"),
+			logical.ErrInvalidRequest
+	}
+
 	// Read and parse the fields
 	var data struct {
 		ID              string
@@ -965,12 +1041,22 @@ func (ts *TokenStore) handleCreateCommon(
 		// The mount point is always the same since we have only one token
 		// store; using req.MountPoint causes trouble in tests since they don't
 		// have an official mount
-		Path: fmt.Sprintf("auth/token/%s", req.Path),
+		Path: fmt.Sprintf("%sauth/token/%s", ns.Path, req.Path),
 
 		Meta:         data.Metadata,
 		DisplayName:  "token",
 		NumUses:      data.NumUses,
 		CreationTime: time.Now().Unix(),
+		NamespaceID:  ns.ID,
+	}
+
+	// The token belongs to the same identity entity as its parent, and
+	// keeps its login metadata, unless it is created in another namespace.
+	// The metadata of the request is caller controlled, so it is never used
+	// by templated policies.
+	if ns.ID == parent.NamespaceID {
+		te.EntityID = parent.EntityID
+		te.LoginMeta = parent.LoginMeta
 	}
 
 	renewable := true
@@ -1180,7 +1266,7 @@ func (ts *TokenStore) handleCreateCommon(
 
 	if ts.policyLookupFunc != nil {
 		for _, p := range te.Policies {
-			policy, err := ts.policyLookupFunc(p)
+			policy, err := ts.policyLookupFunc(te.NamespaceID, p)
 			if err != nil {
 				return logical.ErrorResponse(fmt.Sprintf("could not look up policy %s", p)), nil
 			}
@@ -1218,6 +1304,10 @@ func (ts *TokenStore) handleRevokeTree(
 		}
 	}
 
+	if resp, err := ts.verifyTokenNamespace(req, id); resp != nil || err != nil {
+		return resp, err
+	}
+
 	// Revoke the token and its children
 	if err := ts.RevokeTree(id); err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -1255,6 +1345,10 @@ func (ts *TokenStore) handleRevokeOrphan(
 			logical.ErrInvalidRequest
 	}
 
+	if resp, err := ts.verifyTokenNamespace(req, id); resp != nil || err != nil {
+		return resp, err
+	}
+
 	// Revoke and orphan
 	if err := ts.Revoke(id); err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -1294,6 +1388,11 @@ func (ts *TokenStore) handleLookup(
 		return logical.ErrorResponse("bad token"), logical.ErrPermissionDenied
 	}
 
+	// A token can always look itself up
+	if id != req.ClientToken && !ts.inRequestNamespace(req, out) {
+		return logical.ErrorResponse(errTokenNamespace), logical.ErrPermissionDenied
+	}
+
 	// Generate a response. We purposely omit the parent reference otherwise
 	// you could escalate your privileges.
 	resp := &logical.Response{
@@ -1318,6 +1417,19 @@ func (ts *TokenStore) handleLookup(
 		resp.Data["orphan"] = true
 	}
 
+	if out.NamespaceID != rootNamespaceID {
+		if ns := ts.namespaceByID(out.NamespaceID); ns != nil {
+			resp.Data["namespace_path"] = ns.Path
+		}
+	}
+
+	if out.EntityID != "" {
+		resp.Data["entity_id"] = out.EntityID
+		if ts.entityPoliciesFunc != nil {
+			resp.Data["identity_policies"] = ts.entityPoliciesFunc(out.EntityID)
+		}
+	}
+
 	// Fetch the last renewal time
 	leaseTimes, err := ts.expiration.FetchLeaseTimesByToken(out.Path, out.ID)
 	if err != nil {
@@ -1373,10 +1485,146 @@ func (ts *TokenStore) handleRenew(
 		return logical.ErrorResponse("token not found"), logical.ErrInvalidRequest
 	}
 
+	// A token can always renew itself
+	if id != req.ClientToken && !ts.inRequestNamespace(req, te) {
+		return logical.ErrorResponse(errTokenNamespace), logical.ErrPermissionDenied
+	}
+
 	// Renew the token and its children
 	return ts.expiration.RenewToken(req, te.Path, te.ID, increment)
 }
 
+// handleTidy starts a tidy operation in the background
+func (ts *TokenStore) handleTidy(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if resp, err := ts.verifyRootNamespace(req); resp != nil || err != nil {
+		return resp, err
+	}
+	if !ts.tidy.start(ts.tidyIndexes, ts.Logger()) {
+		return logical.ErrorResponse("tidy operation already in progress"), logical.ErrInvalidRequest
+	}
+	return ts.tidy.response(), nil
+}
+
+// handleTidyStatus returns the status of the last tidy operation
+func (ts *TokenStore) handleTidyStatus(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if resp, err := ts.verifyRootNamespace(req); resp != nil || err != nil {
+		return resp, err
+	}
+	return ts.tidy.response(), nil
+}
+
+// tidyIndexes removes the accessor and parent indexes pointing at tokens
+// that no longer exist, and revokes the tokens whose parent no longer
+// exists. These are left behind when a revocation fails halfway.
+func (ts *TokenStore) tidyIndexes() (map[string]int, error) {
+	defer metrics.MeasureSince([]string{"token", "tidy"}, time.Now())
+
+	counts := map[string]int{
+		"accessor_indexes_checked": 0,
+		"accessor_indexes_removed": 0,
+		"parent_indexes_checked":   0,
+		"parent_indexes_removed":   0,
+		"tokens_revoked":           0,
+	}
+
+	accessors, err := ts.view.List(accessorPrefix)
+	if err != nil {
+		return counts, fmt.Errorf("failed to scan for accessors: %v", err)
+	}
+	for _, accessor := range accessors {
+		counts["accessor_indexes_checked"]++
+		removed, err := ts.tidyIndex(accessorPrefix+accessor, func(entry *logical.StorageEntry) string {
+			return ts.SaltID(string(entry.Value))
+		})
+		if err != nil {
+			return counts, err
+		}
+		if removed {
+			counts["accessor_indexes_removed"]++
+		}
+	}
+
+	parents, err := ts.view.List(parentPrefix)
+	if err != nil {
+		return counts, fmt.Errorf("failed to scan for parents: %v", err)
+	}
+	for _, parent := range parents {
+		children, err := ts.view.List(parentPrefix + parent)
+		if err != nil {
+			return counts, fmt.Errorf("failed to scan for children: %v", err)
+		}
+		parentExists, err := ts.tokenExistsSalted(strings.TrimSuffix(parent, "/"))
+		if err != nil {
+			return counts, err
+		}
+
+		for _, child := range children {
+			counts["parent_indexes_checked"]++
+			index := parentPrefix + parent + child
+			removed, err := ts.tidyIndex(index, func(*logical.StorageEntry) string {
+				return child
+			})
+			if err != nil {
+				return counts, err
+			}
+			if removed {
+				counts["parent_indexes_removed"]++
+				continue
+			}
+			if parentExists {
+				continue
+			}
+
+			// The parent is gone, so the child should have been revoked
+			// with it
+			if err := ts.revokeTreeSalted(child); err != nil {
+				ts.Logger().Printf("[WARN] token: tidy failed to revoke a child of a missing parent: %v", err)
+				continue
+			}
+			if err := ts.view.Delete(index); err != nil {
+				return counts, fmt.Errorf("failed to delete entry: %v", err)
+			}
+			counts["tokens_revoked"]++
+		}
+	}
+
+	return counts, nil
+}
+
+// tidyIndex removes the given index entry if the salted ID of the token it
+// points at, as returned by saltedIDFunc, has no entry. The check is repeated
+// while no token is being created, as the indexes of a token are written
+// before the token itself.
+func (ts *TokenStore) tidyIndex(key string, saltedIDFunc func(*logical.StorageEntry) string) (bool, error) {
+	dangling := func() (bool, error) {
+		entry, err := ts.view.Get(key)
+		if err != nil {
+			return false, fmt.Errorf("failed to read index: %v", err)
+		}
+		if entry == nil {
+			return false, nil
+		}
+		exists, err := ts.tokenExistsSalted(saltedIDFunc(entry))
+		return !exists, err
+	}
+
+	if ok, err := dangling(); !ok || err != nil {
+		return false, err
+	}
+
+	ts.tidyLock.Lock()
+	defer ts.tidyLock.Unlock()
+	if ok, err := dangling(); !ok || err != nil {
+		return false, err
+	}
+	if err := ts.view.Delete(key); err != nil {
+		return false, fmt.Errorf("failed to delete index: %v", err)
+	}
+	return true, nil
+}
+
 func (ts *TokenStore) destroyCubbyhole(saltedID string) error {
 	if ts.cubbyholeBackend == nil {
 		// Should only ever happen in testing
@@ -1406,7 +1654,7 @@ func (ts *TokenStore) authRenew(
 		return f(req, d)
 	}
 
-	role, err := ts.tokenStoreRole(te.Role)
+	role, err := ts.tokenStoreRole(te.NamespaceID, te.Role)
 	if err != nil {
 		return nil, fmt.Errorf("error looking up role %s: %s", te.Role, err)
 	}
@@ -1433,8 +1681,59 @@ func (ts *TokenStore) authRenew(
 	return f(req, d)
 }
 
-func (ts *TokenStore) tokenStoreRole(name string) (*tsRoleEntry, error) {
-	entry, err := ts.view.Get(fmt.Sprintf("%s%s", rolesPrefix, name))
+// requestNamespace returns the namespace of the token store mount of a
+// request
+func (ts *TokenStore) requestNamespace(req *logical.Request) *Namespace {
+	if ts.namespaceForPath == nil {
+		return rootNamespace
+	}
+	return ts.namespaceForPath(req.MountPoint)
+}
+
+// inRequestNamespace checks if a token belongs to the namespace of a request
+// or to one of the namespaces below it
+func (ts *TokenStore) inRequestNamespace(req *logical.Request, te *TokenEntry) bool {
+	if ts.namespaceByID == nil {
+		return true
+	}
+	return ts.requestNamespace(req).contains(ts.namespaceByID(te.NamespaceID))
+}
+
+// verifyRootNamespace returns an error response if the request is not made
+// through the token store mount of the root namespace. The operations on
+// the tokens of all the namespaces, such as tidying the token indexes, are
+// only available there.
+func (ts *TokenStore) verifyRootNamespace(req *logical.Request) (*logical.Response, error) {
+	if ts.requestNamespace(req).ID != rootNamespaceID {
+		return logical.ErrorResponse("only available in the root namespace"), logical.ErrUnsupportedPath
+	}
+	return nil, nil
+}
+
+// verifyTokenNamespace returns an error response if the token with the given
+// ID belongs to a namespace outside of the one of the request
+func (ts *TokenStore) verifyTokenNamespace(req *logical.Request, id string) (*logical.Response, error) {
+	te, err := ts.Lookup(id)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	if te != nil && !ts.inRequestNamespace(req, te) {
+		return logical.ErrorResponse(errTokenNamespace), logical.ErrPermissionDenied
+	}
+	return nil, nil
+}
+
+// namespaceRolesPath returns the prefix used to store the roles of a
+// namespace
+func namespaceRolesPath(namespaceID string) string {
+	if namespaceID == rootNamespaceID {
+		return rolesPrefix
+	}
+	return namespaceRolesPrefix + namespaceID + "/"
+}
+
+func (ts *TokenStore) tokenStoreRole(namespaceID, name string) (*tsRoleEntry, error) {
+	entry, err := ts.view.Get(fmt.Sprintf("%s%s", namespaceRolesPath(namespaceID), name))
 	if err != nil {
 		return nil, err
 	}
@@ -1452,14 +1751,15 @@ func (ts *TokenStore) tokenStoreRole(name string) (*tsRoleEntry, error) {
 
 func (ts *TokenStore) tokenStoreRoleList(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	entries, err := ts.view.List(rolesPrefix)
+	prefix := namespaceRolesPath(ts.requestNamespace(req).ID)
+	entries, err := ts.view.List(prefix)
 	if err != nil {
 		return nil, err
 	}
 
 	ret := make([]string, len(entries))
 	for i, entry := range entries {
-		ret[i] = strings.TrimPrefix(entry, rolesPrefix)
+		ret[i] = strings.TrimPrefix(entry, prefix)
 	}
 
 	return logical.ListResponse(ret), nil
@@ -1467,7 +1767,7 @@ func (ts *TokenStore) tokenStoreRoleList(
 
 func (ts *TokenStore) tokenStoreRoleDelete(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	err := ts.view.Delete(fmt.Sprintf("%s%s", rolesPrefix, data.Get("role_name").(string)))
+	err := ts.view.Delete(fmt.Sprintf("%s%s", namespaceRolesPath(ts.requestNamespace(req).ID), data.Get("role_name").(string)))
 	if err != nil {
 		return nil, err
 	}
@@ -1477,7 +1777,7 @@ func (ts *TokenStore) tokenStoreRoleDelete(
 
 func (ts *TokenStore) tokenStoreRoleRead(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	role, err := ts.tokenStoreRole(data.Get("role_name").(string))
+	role, err := ts.tokenStoreRole(ts.requestNamespace(req).ID, data.Get("role_name").(string))
 	if err != nil {
 		return nil, err
 	}
@@ -1505,7 +1805,7 @@ func (ts *TokenStore) tokenStoreRoleExistenceCheck(req *logical.Request, data *f
 	if name == "" {
 		return false, fmt.Errorf("role name cannot be empty")
 	}
-	role, err := ts.tokenStoreRole(name)
+	role, err := ts.tokenStoreRole(ts.requestNamespace(req).ID, name)
 	if err != nil {
 		return false, err
 	}
@@ -1519,7 +1819,7 @@ func (ts *TokenStore) tokenStoreRoleCreateUpdate(
 	if name == "" {
 		return logical.ErrorResponse("role name cannot be empty"), nil
 	}
-	entry, err := ts.tokenStoreRole(name)
+	entry, err := ts.tokenStoreRole(ts.requestNamespace(req).ID, name)
 	if err != nil {
 		return nil, err
 	}
@@ -1608,7 +1908,7 @@ func (ts *TokenStore) tokenStoreRoleCreateUpdate(
 	}
 
 	// Store it
-	jsonEntry, err := logical.StorageEntryJSON(fmt.Sprintf("%s%s", rolesPrefix, name), entry)
+	jsonEntry, err := logical.StorageEntryJSON(fmt.Sprintf("%s%s", namespaceRolesPath(ts.requestNamespace(req).ID), name), entry)
 	if err != nil {
 		return nil, err
 	}
@@ -1665,4 +1965,11 @@ no effect on the token being renewed.`
 	tokenRenewableHelp = `Tokens created via this role will be
 renewable or not according to this value.
 Defaults to "true".`
+	tokenTidyHelp = `This endpoint removes dangling token indexes in the background.`
+	tokenTidyDesc = `This endpoint starts a background operation that removes the accessor and
+parent indexes pointing at tokens that no longer exist, and revokes the tokens
+whose parent no longer exists. These are left behind when a revocation fails
+halfway. It is safe to run while the token store is in use. Reading this
+endpoint returns the state and counts of the last operation. As it covers the
+tokens of all the namespaces, it is only available in the root namespace.`
 )
@@ -1887,3 +1887,91 @@ func TestTokenStore_RoleExplicitMaxTTL(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenStore_Tidy(t *testing.T) {
+	_, ts, _, root := TestCoreWithTokenStore(t)
+
+	ent1 := &TokenEntry{}
+	if err := ts.create(ent1); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ent2 := &TokenEntry{Parent: ent1.ID}
+	if err := ts.create(ent2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ent3 := &TokenEntry{Parent: ent2.ID}
+	if err := ts.create(ent3); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ent4 := &TokenEntry{}
+	if err := ts.create(ent4); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Simulate a revocation of ent1 that failed halfway
+	if err := ts.view.Delete(lookupPrefix + ts.SaltID(ent1.ID)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A parent index points at a missing child
+	le := &logical.StorageEntry{Key: parentPrefix + ts.SaltID(ent4.ID) + "/" + ts.SaltID("missing")}
+	if err := ts.view.Put(le); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "tidy")
+	req.ClientToken = root
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	// Wait for the tidy operation to finish
+	for i := 0; i < 100; i++ {
+		req = logical.TestRequest(t, logical.ReadOperation, "tidy")
+		resp, err = ts.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if resp.Data["state"] != "running" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp.Data["state"] != "finished" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if resp.Data["accessor_indexes_removed"] != 1 || resp.Data["parent_indexes_removed"] != 1 || resp.Data["tokens_revoked"] != 1 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// The children of ent1 are revoked, ent4 remains
+	for _, id := range []string{ent2.ID, ent3.ID} {
+		out, err := ts.Lookup(id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if out != nil {
+			t.Fatalf("bad: %#v", out)
+		}
+	}
+	out, err := ts.Lookup(ent4.ID)
+	if err != nil || out == nil {
+		t.Fatalf("bad: %#v %v", out, err)
+	}
+
+	// The dangling indexes are removed
+	for _, key := range []string{
+		accessorPrefix + ts.SaltID(ent1.Accessor),
+		parentPrefix + ts.SaltID(ent1.ID) + "/" + ts.SaltID(ent2.ID),
+		parentPrefix + ts.SaltID(ent4.ID) + "/" + ts.SaltID("missing"),
+	} {
+		out, err := ts.view.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if out != nil {
+			t.Fatalf("%s should be removed", key)
+		}
+	}
+}